@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"picpurge/database"
+	"picpurge/grouping"
+	"picpurge/processor"
+)
+
+// runEstimateDates gives images without an EXIF or file name date the date of the
+// files around them, so sorting does not file them under the day they were copied.
+func runEstimateDates() error {
+	log.Println("Estimating dates of images without EXIF dates...")
+
+	db, err := database.GetDBInstance()
+	if err != nil {
+		return fmt.Errorf("failed to get database instance: %w", err)
+	}
+
+	rows, err := db.Query("SELECT id, file_path, create_date, COALESCE(date_source, '') FROM images WHERE is_recycled = FALSE")
+	if err != nil {
+		return fmt.Errorf("error querying images for date estimation: %w", err)
+	}
+	var files []grouping.DatedFile
+	for rows.Next() {
+		var file grouping.DatedFile
+		var filePath, createDateStr, dateSource string
+		if err := rows.Scan(&file.ID, &filePath, &createDateStr, &dateSource); err != nil {
+			log.Printf("Error scanning image for date estimation: %v\n", err)
+			continue
+		}
+		file.Dir, file.Name = filepath.Split(filePath)
+		switch dateSource {
+		case processor.DateSourceEXIF, processor.DateSourceMetadata, processor.DateSourceFileName:
+			createDate, err := time.Parse(time.RFC3339, createDateStr)
+			if err != nil {
+				continue // An unreadable date cannot anchor its neighbours
+			}
+			file.Date, file.Known = createDate, true
+		case processor.DateSourceModTime, processor.DateSourceEstimated:
+			// Undated, estimated from the files around it
+		default:
+			continue // Catalogued before date sources were recorded; leave it until it is rescanned
+		}
+		files = append(files, file)
+	}
+	rows.Close()
+
+	estimates := grouping.EstimateDates(files)
+	if len(estimates) == 0 {
+		log.Println("No dates could be estimated.")
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	for id, date := range estimates {
+		if _, err := tx.Exec("UPDATE images SET create_date = ?, date_source = ? WHERE id = ?", date.Format(time.RFC3339), processor.DateSourceEstimated, id); err != nil {
+			return fmt.Errorf("failed to update date of image %d: %w", id, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit estimated dates: %w", err)
+	}
+
+	log.Printf("Estimated dates for %d images.\n", len(estimates))
+	return nil
+}
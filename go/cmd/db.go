@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"picpurge/database"
+
+	"github.com/spf13/cobra"
+)
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Maintain persistent catalogs.",
+	Long:  `Commands that operate on the catalog selected with --db-path.`,
+}
+
+var dbMergeCmd = &cobra.Command{
+	Use:   "merge other.db",
+	Short: "Import another catalog into the one given by --db-path.",
+	Long: `This command imports every image of another catalog, e.g. one built on a different machine, so both libraries can be deduplicated together.
+Image IDs are remapped and duplicate, similar, bracket and panorama groups are carried over. When both catalogs know a path, the one hashed more recently wins. Files with the same content in both libraries become one duplicate group.
+Run scan with the merged catalog afterwards to find similar images across the libraries.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if dbPath == "" {
+			return fmt.Errorf("db merge cannot merge into a --temp-db")
+		}
+		if same, err := sameFile(dbPath, args[0]); err == nil && same {
+			return fmt.Errorf("cannot merge a catalog into itself")
+		}
+
+		stats, err := database.MergeCatalog(args[0], mergePathPrefix)
+		if err != nil {
+			return fmt.Errorf("error merging catalog: %w", err)
+		}
+		log.Printf("Merged %s: %d images imported, %d updated, %d kept, %d duplicate groups joined across catalogs.\n",
+			args[0], stats.Imported, stats.Updated, stats.Kept, stats.DuplicateGroups)
+		return nil
+	},
+}
+
+var mergePathPrefix string
+
+func init() {
+	RootCmd.AddCommand(dbCmd)
+	dbCmd.AddCommand(dbMergeCmd)
+	dbMergeCmd.Flags().StringVar(&mergePathPrefix, "path-prefix", "", "Prepend this to every imported path, e.g. /mnt/laptop when the other library is mounted there.")
+}
+
+// sameFile reports whether two paths name the same file.
+func sameFile(a, b string) (bool, error) {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(infoA, infoB), nil
+}
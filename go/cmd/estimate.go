@@ -0,0 +1,272 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"time"
+
+	"picpurge/processor"
+	"picpurge/util"
+	"picpurge/walker"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var estimateCmd = &cobra.Command{
+	Use:   "estimate [paths...]",
+	Short: "Project scan time, duplicates and reclaimable space from a sample.",
+	Long: `This command walks the given paths and processes a random sample of the images the way scan does, to project how long a full scan will take, how many images are exact duplicates and how much space recycling them would free. Use it to choose options such as --nice or the worker counts before starting an overnight run.
+
+Duplicates are found by hashing the files that have the same size as a sampled image, so the projection needs no catalog. Nothing is written to the catalog or moved on disk.`,
+	Args: cobra.ArbitraryArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if estimateFraction <= 0 || estimateFraction > 1 {
+			return fmt.Errorf("--fraction must be greater than 0 and at most 1")
+		}
+		walker.SetDetectContentType(detectContentType)
+		walker.SetSymlinkOptions(walker.SymlinkOptions{Follow: followSymlinks, Dedupe: dedupeSymlinks})
+		if err := setMinimumSize(); err != nil {
+			return err
+		}
+		args, err := scanRoots(args)
+		if err != nil {
+			return err
+		}
+		ctx, stop := interruptContext(cmd.Context())
+		defer stop()
+		var files []string
+		for _, path := range args {
+			info, err := os.Stat(path)
+			if err != nil {
+				log.Printf("Error accessing path '%s': %v\n", path, err)
+				continue
+			}
+			if info.IsDir() {
+				found, err := walker.FindImageFiles(ctx, path)
+				if err != nil {
+					if ctx.Err() != nil {
+						break
+					}
+					log.Printf("Error scanning directory '%s': %v\n", path, err)
+					continue
+				}
+				files = append(files, found...)
+			} else if info.Mode().IsRegular() && walker.IsImageFile(path) {
+				files = append(files, path)
+			}
+		}
+		files = walker.FilterSmall(walker.Dedupe(files))
+		if len(files) == 0 {
+			log.Println("No images to estimate.")
+			return withExitCode(ExitNoImages)
+		}
+
+		estimate, err := estimateScan(ctx, files, estimateFraction, estimateMinSample, estimateSeed)
+		if ctx.Err() != nil {
+			log.Println("Estimate interrupted.")
+			return withExitCode(ExitInterrupted)
+		}
+		if err != nil {
+			return err
+		}
+		if estimateJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(estimate)
+		}
+		printScanEstimate(os.Stdout, estimate)
+		return nil
+	},
+}
+
+var (
+	estimateFraction  float64
+	estimateMinSample int
+	estimateSeed      int64
+	estimateJSON      bool
+)
+
+func init() {
+	RootCmd.AddCommand(estimateCmd)
+	estimateCmd.Flags().StringVar(&pathsFrom, "paths-from", "", "Read newline- or NUL-delimited folders from a file, or from stdin with -, in addition to the path arguments, as for scan.")
+	estimateCmd.Flags().BoolVar(&detectContentType, "detect-content-type", false, "Also count files whose content is a supported image or video format despite their extension, as for scan.")
+	estimateCmd.Flags().BoolVar(&followSymlinks, "follow-symlinks", false, "Descend into symlinked folders, as for scan.")
+	estimateCmd.Flags().BoolVar(&dedupeSymlinks, "dedupe-symlinks", false, "Count a file reached through several symlinks once, as for scan.")
+	estimateCmd.Flags().StringVar(&minFileSize, "min-file-size", "", "Leave out files smaller than this, as for scan.")
+	estimateCmd.Flags().StringVar(&minResolution, "min-resolution", "", "Leave out images with fewer pixels across or down than this, as for scan.")
+	estimateCmd.Flags().Float64Var(&estimateFraction, "fraction", 0.02, "Share of the images to sample, e.g. 0.05 for 5%.")
+	estimateCmd.Flags().IntVar(&estimateMinSample, "min-sample", 200, "Sample at least this many images, or all of them in smaller libraries.")
+	estimateCmd.Flags().Int64Var(&estimateSeed, "seed", 0, "Seed for choosing the sample. 0 picks a different sample every run.")
+	estimateCmd.Flags().IntVar(&workers, "workers", 0, "Size of both worker pools processing the sample, as for scan.")
+	estimateCmd.Flags().IntVar(&ioWorkers, "io-workers", 0, "Number of goroutines reading and hashing the sample, as for scan.")
+	estimateCmd.Flags().IntVar(&cpuWorkers, "cpu-workers", 0, "Number of goroutines decoding the sample, as for scan.")
+	estimateCmd.Flags().IntVar(&ioWorkers, "hash-workers", 0, "Alias for --io-workers.")
+	estimateCmd.Flags().MarkHidden("hash-workers")
+	estimateCmd.Flags().BoolVar(&estimateJSON, "json", false, "Print the estimate as JSON.")
+}
+
+// ScanEstimate is the projection of a full scan from a sample.
+type ScanEstimate struct {
+	Files             int     `json:"files"`
+	Bytes             int64   `json:"bytes"`
+	NewFiles          int     `json:"new_files"` // files a scan would process; the others are unchanged in the catalog
+	SampledFiles      int     `json:"sampled_files"`
+	SampleErrors      int     `json:"sample_errors"`
+	SampleSeconds     float64 `json:"sample_seconds"`
+	ScanSeconds       float64 `json:"scan_seconds"`         // projected time to process the new files
+	DuplicateRate     float64 `json:"duplicate_rate"`       // share of images that have an exact copy elsewhere
+	RedundantFiles    int     `json:"redundant_files"`      // copies beyond the first of each duplicate group
+	ReclaimableBytes  int64   `json:"reclaimable_bytes"`    // space freed by recycling the redundant copies
+	MarginOfError     float64 `json:"margin_of_error"`      // 95% confidence half-width of DuplicateRate
+	SameSizeFilesRead int     `json:"same_size_files_read"` // files outside the sample hashed to find copies
+}
+
+// estimateScan processes a random sample of files and projects the cost and
+// outcome of scanning all of them. It returns ctx's error once ctx is cancelled.
+func estimateScan(ctx context.Context, files []string, fraction float64, minSample int, seed int64) (*ScanEstimate, error) {
+	estimate := &ScanEstimate{Files: len(files)}
+	if len(files) == 0 {
+		return estimate, nil
+	}
+	sizes := make(map[string]int64, len(files))
+	bySize := make(map[int64][]string)
+	for _, filePath := range files {
+		info, err := os.Stat(filePath)
+		if err != nil {
+			continue
+		}
+		sizes[filePath] = info.Size()
+		bySize[info.Size()] = append(bySize[info.Size()], filePath)
+		estimate.Bytes += info.Size()
+	}
+
+	pending, err := uncommittedFiles(files)
+	if err != nil {
+		return nil, err
+	}
+	estimate.NewFiles = len(pending)
+	var pendingBytes int64
+	for _, filePath := range pending {
+		pendingBytes += sizes[filePath]
+	}
+
+	n := int(math.Ceil(fraction * float64(len(files))))
+	if n < minSample {
+		n = minSample
+	}
+	if n > len(files) {
+		n = len(files)
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	sample := make([]string, n)
+	for i, j := range rand.New(rand.NewSource(seed)).Perm(len(files))[:n] {
+		sample[i] = files[j]
+	}
+	estimate.SampledFiles = n
+	log.Printf("Processing a sample of %d of %d images...\n", n, len(files))
+
+	// Time the sample the way scan processes files, with the same worker pools
+	hashes := make(map[string]string, n)
+	var sampleBytes int64
+	for _, filePath := range sample {
+		sampleBytes += sizes[filePath]
+	}
+	start := time.Now()
+	for res := range runWorkerPools(ctx, sample, ioPoolSize(), cpuPoolSize(), func(ctx context.Context, worker int) error { return ctx.Err() }) {
+		if res.Err != nil {
+			log.Printf("Error processing image '%s': %v\n", res.FilePath, res.Err)
+			estimate.SampleErrors++
+			continue
+		}
+		hashes[res.FilePath] = res.ImageData.MD5
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	estimate.SampleSeconds = time.Since(start).Seconds()
+	if sampleBytes > 0 {
+		estimate.ScanSeconds = estimate.SampleSeconds * float64(pendingBytes) / float64(sampleBytes)
+	}
+
+	// An image has exact copies only among the files of the same size. Each
+	// sampled image in a group of k copies stands for (k-1)/k of a redundant file.
+	duplicates := 0
+	var redundant, reclaimable float64
+	for _, filePath := range sample {
+		hash, ok := hashes[filePath]
+		if !ok {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		copies := 0
+		for _, peer := range bySize[sizes[filePath]] {
+			peerHash, ok := hashes[peer]
+			if !ok {
+				if peerHash, err = util.FileHash(peer, processor.HashAlgo()); err != nil {
+					log.Printf("Warning: Could not hash %s: %v\n", peer, err)
+					continue
+				}
+				hashes[peer] = peerHash
+				estimate.SameSizeFilesRead++
+			}
+			if peerHash == hash {
+				copies++
+			}
+		}
+		if copies > 1 {
+			duplicates++
+			share := float64(copies-1) / float64(copies)
+			redundant += share
+			reclaimable += share * float64(sizes[filePath])
+		}
+	}
+	scale := float64(len(files)) / float64(n)
+	estimate.DuplicateRate = float64(duplicates) / float64(n)
+	estimate.RedundantFiles = int(math.Round(redundant * scale))
+	estimate.ReclaimableBytes = int64(reclaimable * scale)
+	if n < len(files) {
+		// Normal approximation with finite population correction
+		p := estimate.DuplicateRate
+		correction := float64(len(files)-n) / float64(len(files)-1)
+		estimate.MarginOfError = 1.96 * math.Sqrt(p*(1-p)/float64(n)*correction)
+	}
+	return estimate, nil
+}
+
+// printScanEstimate prints the estimate as a small table in the style of the
+// phase summaries.
+func printScanEstimate(w io.Writer, e *ScanEstimate) {
+	title := color.New(color.Bold).SprintFunc()
+	badge := color.New(color.FgYellow, color.Bold).SprintFunc()
+	row := func(label, value string) {
+		fmt.Fprintf(w, "  %-22s %16s\n", label, value)
+	}
+
+	fmt.Fprintf(w, "%s\n", title(fmt.Sprintf("Estimate from %d sampled images", e.SampledFiles)))
+	row("Images", fmt.Sprint(e.Files))
+	row("Total size", util.FormatBytes(uint64(e.Bytes)))
+	row("New or changed", fmt.Sprint(e.NewFiles))
+	row("Projected scan time", time.Duration(e.ScanSeconds*float64(time.Second)).Round(time.Second).String())
+	rate := fmt.Sprintf("%.1f%%", e.DuplicateRate*100)
+	if e.MarginOfError > 0 {
+		rate += fmt.Sprintf(" ± %.1f%%", e.MarginOfError*100)
+	}
+	row("Duplicate rate", rate)
+	row("Redundant copies", badge(fmt.Sprint(e.RedundantFiles)))
+	row("Reclaimable space", badge(util.FormatBytes(uint64(e.ReclaimableBytes))))
+	if e.SampleErrors > 0 {
+		row("Unreadable in sample", fmt.Sprint(e.SampleErrors))
+	}
+	fmt.Fprintln(w, "  The scan time covers hashing and thumbnails; finding similar images adds to it.")
+}
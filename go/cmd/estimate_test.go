@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"context"
+	"image"
+	"image/jpeg"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"picpurge/database"
+	"picpurge/processor"
+)
+
+// writeTestJPEG writes a small JPEG filled with shade and returns its size.
+func writeTestJPEG(t *testing.T, path string, shade uint8) int64 {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 64, 48))
+	for i := range img.Pix {
+		img.Pix[i] = shade + uint8(i%7)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create image: %v", err)
+	}
+	defer file.Close()
+	if err := jpeg.Encode(file, img, nil); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	return info.Size()
+}
+
+func TestEstimateScan(t *testing.T) {
+	// Two copies of one photo, another photo and one already in the catalog
+	dir := t.TempDir()
+	original, copied := filepath.Join(dir, "a.jpg"), filepath.Join(dir, "b.jpg")
+	other, committed := filepath.Join(dir, "c.jpg"), filepath.Join(dir, "d.jpg")
+	copySize := writeTestJPEG(t, original, 10)
+	writeTestJPEG(t, copied, 10)
+	otherSize := writeTestJPEG(t, other, 120)
+	committedSize := writeTestJPEG(t, committed, 200)
+	imageData, _, err := processor.ProcessImage(committed)
+	if err != nil {
+		t.Fatalf("ProcessImage failed: %v", err)
+	}
+	if err := database.CommitScanBatch([]database.ScanResult{{ImageData: imageData}}); err != nil {
+		t.Fatalf("CommitScanBatch failed: %v", err)
+	}
+	files := []string{original, copied, other, committed}
+	totalBytes := 2*copySize + otherSize + committedSize
+	newBytes := totalBytes - committedSize
+
+	testCases := []struct {
+		name         string
+		files        []string
+		fraction     float64
+		minSample    int
+		sampled, new int
+		bytes        int64
+		// Exact projections, which only a sample of the whole library gives
+		duplicateRate float64
+		redundant     int
+		reclaimable   int64
+	}{
+		{"empty folder", nil, 0.5, 10, 0, 0, 0, 0, 0, 0},
+		{"everything", files, 1, 0, 4, 3, totalBytes, 0.5, 1, copySize},
+		{"min sample", files, 0.01, 4, 4, 3, totalBytes, 0.5, 1, copySize},
+		{"fraction", files, 0.5, 1, 2, 3, totalBytes, 0, 0, 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			estimate, err := estimateScan(context.Background(), tc.files, tc.fraction, tc.minSample, 1)
+			if err != nil {
+				t.Fatalf("estimateScan failed: %v", err)
+			}
+			if estimate.Files != len(tc.files) || estimate.Bytes != tc.bytes || estimate.NewFiles != tc.new || estimate.SampledFiles != tc.sampled {
+				t.Errorf("Counted %d files of %d bytes, %d new, %d sampled; expected %d, %d, %d, %d",
+					estimate.Files, estimate.Bytes, estimate.NewFiles, estimate.SampledFiles, len(tc.files), tc.bytes, tc.new, tc.sampled)
+			}
+			if estimate.SampleErrors != 0 {
+				t.Errorf("SampleErrors = %d; expected 0", estimate.SampleErrors)
+			}
+			if tc.sampled == 0 {
+				if *estimate != (ScanEstimate{}) {
+					t.Errorf("Estimated %+v for no images", *estimate)
+				}
+				return
+			}
+			if estimate.SampleSeconds <= 0 || estimate.ScanSeconds <= 0 {
+				t.Errorf("Timed the sample at %vs and the scan at %vs; expected both to take time", estimate.SampleSeconds, estimate.ScanSeconds)
+			}
+
+			if tc.sampled < len(tc.files) {
+				// A partial sample projects a share with a margin of error
+				p, n, total := estimate.DuplicateRate, float64(tc.sampled), float64(len(tc.files))
+				if p < 0 || p > 1 {
+					t.Errorf("DuplicateRate = %v; expected a share", p)
+				}
+				margin := 1.96 * math.Sqrt(p*(1-p)/n*(total-n)/(total-1))
+				if math.Abs(estimate.MarginOfError-margin) > 1e-9 {
+					t.Errorf("MarginOfError = %v; expected %v", estimate.MarginOfError, margin)
+				}
+				return
+			}
+			if estimate.DuplicateRate != tc.duplicateRate || estimate.RedundantFiles != tc.redundant || estimate.ReclaimableBytes != tc.reclaimable || estimate.MarginOfError != 0 {
+				t.Errorf("Projected a duplicate rate of %v ± %v, %d redundant files and %d reclaimable bytes; expected %v ± 0, %d, %d",
+					estimate.DuplicateRate, estimate.MarginOfError, estimate.RedundantFiles, estimate.ReclaimableBytes, tc.duplicateRate, tc.redundant, tc.reclaimable)
+			}
+			// The scan time scales the sample's time down to the bytes not yet catalogued
+			expected := estimate.SampleSeconds * float64(newBytes) / float64(totalBytes)
+			if math.Abs(estimate.ScanSeconds-expected) > 1e-9 {
+				t.Errorf("ScanSeconds = %v; expected %v", estimate.ScanSeconds, expected)
+			}
+		})
+	}
+
+	// A cancelled estimate reports why
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := estimateScan(ctx, files, 1, 0, 1); err != context.Canceled {
+		t.Errorf("estimateScan after cancelling = %v; expected %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"picpurge/util"
+)
+
+// Exit codes, so wrapper scripts can branch on the outcome of a run.
+const (
+	ExitOK                 = 0   // finished, nothing to report
+	ExitFailure            = 1   // the command failed
+	ExitNoImages           = 2   // no image files were found
+	ExitDuplicatesFound    = 3   // duplicate images were found
+	ExitProcessingErrors   = 4   // the run finished, but some files could not be processed
+	ExitRecycleCapExceeded = 5   // an automated recycle was blocked by --max-recycle
+	ExitInterrupted        = 130 // the run was stopped by Ctrl+C or SIGTERM, as shells report SIGINT
+)
+
+// resultError reports the outcome of a successful run through the exit code.
+// It is not printed as an error.
+type resultError struct {
+	code int
+}
+
+func (e *resultError) Error() string {
+	return fmt.Sprintf("exit code %d", e.code)
+}
+
+// withExitCode ends a command with the given result code.
+func withExitCode(code int) error {
+	if code == ExitOK {
+		return nil
+	}
+	return &resultError{code: code}
+}
+
+// exitCodeOf maps the error returned by a command to an exit code.
+func exitCodeOf(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	var result *resultError
+	if errors.As(err, &result) {
+		return result.code
+	}
+	if errors.Is(err, util.ErrRecycleCapExceeded) {
+		return ExitRecycleCapExceeded
+	}
+	if errors.Is(err, context.Canceled) {
+		return ExitInterrupted
+	}
+	return ExitFailure
+}
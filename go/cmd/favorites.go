@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"picpurge/export"
+	"picpurge/util"
+
+	"github.com/spf13/cobra"
+)
+
+var exportFavoritesCmd = &cobra.Command{
+	Use:   "export-favorites [destination]",
+	Short: "Copy all favorite images into a destination folder.",
+	Long:  `This command copies every image marked as favorite into the destination folder, using the same YYYY/MM layout and rename policy as sorting.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		policy, err := util.ParseRenamePolicy(favoritesRenamePolicy)
+		if err != nil {
+			return err
+		}
+
+		copied, err := export.Favorites(args[0], policy)
+		if err != nil {
+			return fmt.Errorf("error exporting favorites: %w", err)
+		}
+		log.Printf("Exported %d favorite images to %s.\n", copied, args[0])
+		return nil
+	},
+}
+
+var favoritesRenamePolicy string
+
+func init() {
+	RootCmd.AddCommand(exportFavoritesCmd)
+	exportFavoritesCmd.Flags().StringVar(&favoritesRenamePolicy, "rename-policy", string(util.RenameDatePrefix), "File naming used for exported files: keep, date-prefix or hash.")
+}
@@ -1 +1,67 @@
 package cmd
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"picpurge/export"
+	"picpurge/util"
+)
+
+// duplicatePair is a file marked as a duplicate of an earlier file with the same MD5.
+type duplicatePair struct {
+	md5        string
+	masterPath string
+	id         int
+	path       string
+}
+
+// verifyDuplicateSample compares up to n randomly chosen pairs byte for byte and
+// returns those whose contents differ. A mismatch means an MD5 collision or a
+// file that changed after hashing, so the pairing cannot be trusted. Pairs that
+// cannot be read are reported but not counted as mismatches.
+func verifyDuplicateSample(pairs []duplicatePair, n int) []duplicatePair {
+	if n <= 0 || len(pairs) == 0 {
+		return nil
+	}
+	if n > len(pairs) {
+		n = len(pairs)
+	}
+
+	var mismatches []duplicatePair
+	for _, i := range rand.Perm(len(pairs))[:n] {
+		pair := pairs[i]
+		equal, err := util.FilesEqual(pair.masterPath, pair.path)
+		if err != nil {
+			log.Printf("Warning: Could not compare %s with %s: %v\n", pair.path, pair.masterPath, err)
+			continue
+		}
+		if !equal {
+			log.Printf("ERROR: %s and %s have the same MD5 but different contents; they are no longer marked as duplicates.\n", pair.path, pair.masterPath)
+			mismatches = append(mismatches, pair)
+		}
+	}
+	log.Printf("Verified %d of %d duplicate pairs byte for byte, %d differed.\n", n, len(pairs), len(mismatches))
+	return mismatches
+}
+
+// archiveBeforeRecycle backs up the files of a recycle plan to a ZIP archive.
+// A folder, or a path ending in a separator, gets an archive named after the
+// current time, so repeated runs do not overwrite each other's backups.
+func archiveBeforeRecycle(archivePath string, files []export.ArchivedFile) error {
+	if info, err := os.Stat(archivePath); (err == nil && info.IsDir()) || strings.HasSuffix(archivePath, string(filepath.Separator)) {
+		archivePath = filepath.Join(archivePath, "picpurge-recycle-"+time.Now().Format("20060102-150405")+".zip")
+	}
+	log.Printf("Archiving %d files to %s before recycling...\n", len(files), archivePath)
+	size, err := export.RecycleArchive(archivePath, files)
+	if err != nil {
+		return fmt.Errorf("failed to archive the files to recycle: %w", err)
+	}
+	log.Printf("Archived %d files (%s) to %s.\n", len(files), util.FormatBytes(uint64(size)), archivePath)
+	return nil
+}
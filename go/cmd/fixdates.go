@@ -0,0 +1,250 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"picpurge/database"
+	"picpurge/processor"
+	"picpurge/util"
+
+	"github.com/spf13/cobra"
+)
+
+var fixDatesCmd = &cobra.Command{
+	Use:   "fix-dates",
+	Short: "Correct capture dates of selected images.",
+	Long: `This command repairs capture dates, e.g. of photos from a camera whose clock was wrong for months.
+Either shift dates with --shift (e.g. +1y2d, -3h; units y, mo, d, h, m, s) or take them from file names such as IMG_20230501_120000.jpg with --from-filename.
+Select images with --folder, --device-make, --device-model, --taken-from/--taken-to or --ids, or pass --all. Only the catalog changes unless --write-exif is given, which also rewrites the date tags in JPEG and TIFF-based RAW files. Rewritten files no longer match their untouched copies byte for byte.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if (fixShift == "") == !fixFromFileName {
+			return fmt.Errorf("fix-dates needs exactly one of --shift or --from-filename")
+		}
+		var shift util.DateShift
+		if fixShift != "" {
+			var err error
+			if shift, err = util.ParseDateShift(fixShift); err != nil {
+				return err
+			}
+		}
+		selection, err := parseDateSelection()
+		if err != nil {
+			return err
+		}
+		if !selection.any() && !fixAll {
+			return fmt.Errorf("select images with --folder, --device-make, --device-model, --taken-from, --taken-to or --ids, or pass --all")
+		}
+		return runFixDates(selection, shift, fixFromFileName, fixWriteEXIF, fixDryRun)
+	},
+}
+
+var (
+	fixShift        string
+	fixFromFileName bool
+	fixFolder       string
+	fixDeviceMake   string
+	fixDeviceModel  string
+	fixTakenFrom    string
+	fixTakenTo      string
+	fixIDs          string
+	fixAll          bool
+	fixWriteEXIF    bool
+	fixDryRun       bool
+)
+
+func init() {
+	RootCmd.AddCommand(fixDatesCmd)
+	fixDatesCmd.Flags().StringVar(&fixShift, "shift", "", "Move dates by this amount, e.g. +1y2d or -3h. Units: y, mo, d, h, m, s.")
+	fixDatesCmd.Flags().BoolVar(&fixFromFileName, "from-filename", false, "Set dates from dates written in the file names.")
+	fixDatesCmd.Flags().StringVar(&fixFolder, "folder", "", "Only images in this folder or its subfolders.")
+	fixDatesCmd.Flags().StringVar(&fixDeviceMake, "device-make", "", "Only images from this camera make.")
+	fixDatesCmd.Flags().StringVar(&fixDeviceModel, "device-model", "", "Only images from this camera model.")
+	fixDatesCmd.Flags().StringVar(&fixTakenFrom, "taken-from", "", "Only images currently dated on or after this day (YYYY-MM-DD).")
+	fixDatesCmd.Flags().StringVar(&fixTakenTo, "taken-to", "", "Only images currently dated on or before this day (YYYY-MM-DD).")
+	fixDatesCmd.Flags().StringVar(&fixIDs, "ids", "", "Only these comma-separated image IDs.")
+	fixDatesCmd.Flags().BoolVar(&fixAll, "all", false, "Apply to every image in the catalog.")
+	fixDatesCmd.Flags().BoolVar(&fixWriteEXIF, "write-exif", false, "Also rewrite the date tags in the image files.")
+	fixDatesCmd.Flags().BoolVar(&fixDryRun, "dry-run", false, "Print the changes without applying them.")
+}
+
+// dateSelection narrows fix-dates to part of the catalog.
+type dateSelection struct {
+	folder                  string
+	deviceMake, deviceModel string
+	from, to                time.Time // zero when unbounded; to is exclusive
+	ids                     map[int]bool
+}
+
+func parseDateSelection() (dateSelection, error) {
+	s := dateSelection{folder: fixFolder, deviceMake: fixDeviceMake, deviceModel: fixDeviceModel}
+	if fixTakenFrom != "" {
+		day, err := time.Parse("2006-01-02", fixTakenFrom)
+		if err != nil {
+			return s, fmt.Errorf("invalid --taken-from: %s", fixTakenFrom)
+		}
+		s.from = day
+	}
+	if fixTakenTo != "" {
+		day, err := time.Parse("2006-01-02", fixTakenTo)
+		if err != nil {
+			return s, fmt.Errorf("invalid --taken-to: %s", fixTakenTo)
+		}
+		s.to = day.AddDate(0, 0, 1)
+	}
+	if fixIDs != "" {
+		s.ids = make(map[int]bool)
+		for _, part := range strings.Split(fixIDs, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil {
+				return s, fmt.Errorf("invalid --ids: %s", fixIDs)
+			}
+			s.ids[id] = true
+		}
+	}
+	return s, nil
+}
+
+func (s dateSelection) any() bool {
+	return s.folder != "" || s.deviceMake != "" || s.deviceModel != "" || !s.from.IsZero() || !s.to.IsZero() || s.ids != nil
+}
+
+// matches compares dates by their wall clock, as EXIF dates carry no time zone.
+func (s dateSelection) matches(id int, filePath, deviceMake, deviceModel string, date time.Time) bool {
+	if s.folder != "" && !util.InFolder(filePath, s.folder) {
+		return false
+	}
+	if (s.deviceMake != "" && deviceMake != s.deviceMake) || (s.deviceModel != "" && deviceModel != s.deviceModel) {
+		return false
+	}
+	wallClock := time.Date(date.Year(), date.Month(), date.Day(), date.Hour(), date.Minute(), date.Second(), 0, time.UTC)
+	if (!s.from.IsZero() && wallClock.Before(s.from)) || (!s.to.IsZero() && !wallClock.Before(s.to)) {
+		return false
+	}
+	return s.ids == nil || s.ids[id]
+}
+
+type dateFix struct {
+	id        int
+	filePath  string
+	old, date time.Time
+	source    string
+}
+
+// runFixDates corrects the dates of the selected images in the catalog and, if
+// writeEXIF is set, in the files.
+func runFixDates(selection dateSelection, shift util.DateShift, fromFileName, writeEXIF, dryRun bool) error {
+	db, err := database.GetDBInstance()
+	if err != nil {
+		return fmt.Errorf("failed to get database instance: %w", err)
+	}
+
+	rows, err := db.Query("SELECT id, file_path, file_name, COALESCE(device_make, ''), COALESCE(device_model, ''), create_date, COALESCE(date_source, '') FROM images WHERE is_recycled = FALSE ORDER BY id ASC")
+	if err != nil {
+		return fmt.Errorf("error querying images: %w", err)
+	}
+	var fixes []dateFix
+	skipped := 0
+	for rows.Next() {
+		var id int
+		var filePath, fileName, deviceMake, deviceModel, createDateStr, dateSource string
+		if err := rows.Scan(&id, &filePath, &fileName, &deviceMake, &deviceModel, &createDateStr, &dateSource); err != nil {
+			log.Printf("Error scanning image: %v\n", err)
+			continue
+		}
+		createDate, err := time.Parse(time.RFC3339, createDateStr)
+		if err != nil {
+			log.Printf("Warning: Could not parse create_date '%s' for image ID %d, skipping.\n", createDateStr, id)
+			continue
+		}
+		if !selection.matches(id, filePath, deviceMake, deviceModel, createDate) {
+			continue
+		}
+
+		fix := dateFix{id: id, filePath: filePath, old: createDate, source: dateSource}
+		if fromFileName {
+			date, ok := processor.DateFromFileName(fileName)
+			if !ok {
+				skipped++
+				continue
+			}
+			fix.date, fix.source = date, processor.DateSourceFileName
+		} else {
+			fix.date = shift.Apply(createDate)
+		}
+		if !fix.date.Equal(fix.old) {
+			fixes = append(fixes, fix)
+		}
+	}
+	rows.Close()
+	if skipped > 0 {
+		log.Printf("%d selected images have no date in their file name and were left alone.\n", skipped)
+	}
+
+	if dryRun {
+		for _, fix := range fixes {
+			fmt.Printf("%s: %s -> %s\n", fix.filePath, fix.old.Format(time.RFC3339), fix.date.Format(time.RFC3339))
+		}
+		log.Printf("Dry run: %d images would be redated.\n", len(fixes))
+		return nil
+	}
+
+	updated, rewritten := 0, 0
+	for _, fix := range fixes {
+		if _, err := db.Exec("UPDATE images SET create_date = ?, date_source = ? WHERE id = ?", fix.date.Format(time.RFC3339), nullableString(fix.source), fix.id); err != nil {
+			log.Printf("Error updating date of image ID %d: %v\n", fix.id, err)
+			continue
+		}
+		updated++
+		if !writeEXIF {
+			continue
+		}
+		n, err := processor.WriteEXIFDate(fix.filePath, fix.date)
+		if err != nil {
+			log.Printf("Warning: Could not write EXIF date to %s: %v\n", fix.filePath, err)
+			continue
+		}
+		if n == 0 {
+			log.Printf("Warning: %s has no EXIF date to rewrite; only the catalog was updated.\n", fix.filePath)
+			continue
+		}
+		rewritten++
+		if err := refreshFileHash(fix.id, fix.filePath); err != nil {
+			log.Printf("Warning: %v\n", err)
+		}
+	}
+
+	if writeEXIF {
+		log.Printf("Redated %d images, rewrote EXIF dates in %d files.\n", updated, rewritten)
+	} else {
+		log.Printf("Redated %d images in the catalog.\n", updated)
+	}
+	return nil
+}
+
+// refreshFileHash records the new hash of a file rewritten in place, so the
+// next scan does not treat it as an unknown change.
+func refreshFileHash(id int, filePath string) error {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", filePath, err)
+	}
+	md5, err := util.FileHash(filePath, processor.HashAlgo())
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %w", filePath, err)
+	}
+	return database.RecordRewrittenFile(id, md5, info.Size(), info.ModTime())
+}
+
+// nullableString stores an empty string as NULL.
+func nullableString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
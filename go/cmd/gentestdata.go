@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"picpurge/fixture"
+
+	"github.com/spf13/cobra"
+)
+
+var genTestdataCmd = &cobra.Command{
+	Use:   "gen-testdata dir",
+	Short: "Generate a synthetic photo library for testing.",
+	Long: `This command writes a small library of generated photos together with exact duplicates, resized and rotated copies, RAW+JPEG pairs and corrupt files.
+A manifest.json in the directory lists how each file relates to the originals. The same --seed always produces the same files, so a library can be recreated when reporting a bug.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if entries, err := os.ReadDir(args[0]); err == nil && len(entries) > 0 {
+			return fmt.Errorf("%s is not empty", args[0])
+		}
+
+		manifest, err := fixture.Generate(args[0], fixture.Options{Originals: genOriginals, Seed: genSeed})
+		if err != nil {
+			return fmt.Errorf("error generating test library: %w", err)
+		}
+		log.Printf("Generated %d files in %s: %d originals, %d exact duplicates, %d resized, %d rotated, %d RAW, %d corrupt.\n",
+			len(manifest.Entries), args[0], manifest.Count(fixture.KindOriginal), manifest.Count(fixture.KindExactDuplicate),
+			manifest.Count(fixture.KindResized), manifest.Count(fixture.KindRotated), manifest.Count(fixture.KindRaw), manifest.Count(fixture.KindCorrupt))
+		return nil
+	},
+}
+
+var (
+	genOriginals int
+	genSeed      int64
+)
+
+func init() {
+	RootCmd.AddCommand(genTestdataCmd)
+	genTestdataCmd.Flags().IntVar(&genOriginals, "originals", fixture.DefaultOptions.Originals, "Number of distinct photos. Each one gets a duplicate, resized, rotated or RAW companion in turn.")
+	genTestdataCmd.Flags().Int64Var(&genSeed, "seed", fixture.DefaultOptions.Seed, "Seed for the generated content.")
+}
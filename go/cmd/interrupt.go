@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// interruptContext returns a context that is cancelled by the first SIGINT or
+// SIGTERM, so a long run can stop its workers, commit what it finished and
+// report before exiting. A second signal ends the process at once as usual.
+// The returned stop function releases the signal handler.
+func interruptContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			signal.Stop(signals)
+			log.Printf("Received %s; stopping. Press Ctrl+C again to quit immediately.\n", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"picpurge/database"
+	"picpurge/legacy"
+
+	"github.com/spf13/cobra"
+)
+
+var importLegacyCmd = &cobra.Command{
+	Use:   "import-legacy [folders...]",
+	Short: "Import ratings, albums and people tags from Picasa and digiKam.",
+	Long: `This command reads the Picasa.ini files and XMP sidecars found in the given folders, and the digiKam database given with --digikam-db, and adds their star ratings, albums, people and keywords to the catalogued images they describe.
+Ratings are only ever raised. Afterwards the best rated or tagged copy becomes the original of each duplicate group, so old curation decides which copy is kept.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 && digiKamDBPath == "" {
+			return fmt.Errorf("give folders to search for Picasa.ini files and XMP sidecars, or --digikam-db")
+		}
+
+		var records []legacy.Record
+		for _, folder := range args {
+			found, err := findLegacyRecords(folder)
+			if err != nil {
+				return fmt.Errorf("error reading %s: %w", folder, err)
+			}
+			records = append(records, found...)
+		}
+		if digiKamDBPath != "" {
+			found, err := legacy.ReadDigiKamDB(digiKamDBPath)
+			if err != nil {
+				return err
+			}
+			log.Printf("Read %d curated images from %s.\n", len(found), digiKamDBPath)
+			records = append(records, found...)
+		}
+		return runImportLegacy(records, importLegacyDryRun)
+	},
+}
+
+var (
+	digiKamDBPath      string
+	importLegacyDryRun bool
+)
+
+func init() {
+	RootCmd.AddCommand(importLegacyCmd)
+	importLegacyCmd.Flags().StringVar(&digiKamDBPath, "digikam-db", "", "Path to a digiKam database (digikam4.db) to import from.")
+	importLegacyCmd.Flags().BoolVar(&importLegacyDryRun, "dry-run", false, "Print what would be imported without changing the catalog.")
+}
+
+// findLegacyRecords reads every Picasa.ini and XMP sidecar below folder.
+func findLegacyRecords(folder string) ([]legacy.Record, error) {
+	var records []legacy.Record
+	err := filepath.WalkDir(folder, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("Warning: Could not read %s: %v\n", path, err)
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		name := entry.Name()
+		switch {
+		case name == legacy.PicasaFileNames[0] || name == legacy.PicasaFileNames[1]:
+			found, err := legacy.ReadPicasaINI(path)
+			if err != nil {
+				log.Printf("Warning: %v\n", err)
+				return nil
+			}
+			records = append(records, found...)
+		case strings.EqualFold(filepath.Ext(name), ".xmp"):
+			curation, err := legacy.ReadXMPSidecar(path)
+			if err != nil {
+				log.Printf("Warning: %v\n", err)
+				return nil
+			}
+			if !curation.Empty() {
+				records = append(records, legacy.SidecarRecord(path, curation))
+			}
+		}
+		return nil
+	})
+	return records, err
+}
+
+// runImportLegacy adds the records to the catalogued images they match.
+func runImportLegacy(records []legacy.Record, dryRun bool) error {
+	db, err := database.GetDBInstance()
+	if err != nil {
+		return fmt.Errorf("failed to get database instance: %w", err)
+	}
+	rows, err := db.Query("SELECT id, file_path FROM images WHERE is_recycled = FALSE")
+	if err != nil {
+		return fmt.Errorf("error querying images: %w", err)
+	}
+	var files []legacy.CatalogFile
+	for rows.Next() {
+		var file legacy.CatalogFile
+		if err := rows.Scan(&file.ID, &file.Path); err != nil {
+			log.Printf("Error scanning image: %v\n", err)
+			continue
+		}
+		files = append(files, file)
+	}
+	rows.Close()
+	index := legacy.NewIndex(files)
+
+	matched, unmatched, tagged := 0, 0, 0
+	for _, record := range records {
+		ids := index.Match(record)
+		if len(ids) == 0 {
+			unmatched++
+			continue
+		}
+		matched++
+		if dryRun {
+			fmt.Printf("%s: rating %d, albums %v, people %v, keywords %v (%d images)\n", record.Path, record.Rating, record.Albums, record.Faces, record.Keywords, len(ids))
+			continue
+		}
+		for _, id := range ids {
+			if record.Rating > 0 {
+				if err := database.RaiseRating(id, record.Rating); err != nil {
+					log.Printf("Error rating image ID %d: %v\n", id, err)
+				}
+			}
+			tags := map[string][]string{
+				database.TagKindAlbum:   record.Albums,
+				database.TagKindFace:    record.Faces,
+				database.TagKindKeyword: record.Keywords,
+			}
+			for kind, names := range tags {
+				for _, name := range names {
+					if err := database.AddImageTag(id, kind, name); err != nil {
+						log.Printf("Error tagging image ID %d: %v\n", id, err)
+						continue
+					}
+					tagged++
+				}
+			}
+		}
+	}
+	if unmatched > 0 {
+		log.Printf("%d curated files are not in the catalog and were skipped.\n", unmatched)
+	}
+	if dryRun {
+		log.Printf("Dry run: %d curated files match catalogued images.\n", matched)
+		return nil
+	}
+
+	changed, err := database.ReelectDuplicateOriginals()
+	if err != nil {
+		return fmt.Errorf("error choosing duplicate originals: %w", err)
+	}
+	log.Printf("Imported curation of %d files (%d tags). %d duplicate groups now keep a different copy.\n", matched, tagged, changed)
+	return nil
+}
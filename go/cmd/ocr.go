@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"picpurge/database"
+	"picpurge/ocr"
+)
+
+// runOCRScreenshots extracts text from every screenshot in the catalog so it can be searched.
+func runOCRScreenshots(languages string) error {
+	log.Println("Extracting text from screenshots...")
+
+	db, err := database.GetDBInstance()
+	if err != nil {
+		return fmt.Errorf("failed to get database instance: %w", err)
+	}
+
+	rows, err := db.Query("SELECT id, file_path FROM images WHERE is_screenshot = TRUE AND is_recycled = FALSE ORDER BY id ASC")
+	if err != nil {
+		return fmt.Errorf("error querying screenshots: %w", err)
+	}
+	var screenshots []struct {
+		ID       int
+		FilePath string
+	}
+	for rows.Next() {
+		var s struct {
+			ID       int
+			FilePath string
+		}
+		if err := rows.Scan(&s.ID, &s.FilePath); err != nil {
+			log.Printf("Error scanning screenshot: %v\n", err)
+			continue
+		}
+		screenshots = append(screenshots, s)
+	}
+	rows.Close()
+
+	startPhase(database.PhaseOCR, len(screenshots))
+	withText := 0
+	for i, s := range screenshots {
+		text, err := ocr.ExtractText(s.FilePath, languages)
+		if err != nil {
+			log.Printf("Error extracting text from %s: %v\n", s.FilePath, err)
+			continue
+		}
+		if err := database.SetOCRText(s.ID, text); err != nil {
+			log.Printf("Error storing text for image ID %d: %v\n", s.ID, err)
+			continue
+		}
+		if text != "" {
+			withText++
+		}
+		progressPhase(database.PhaseOCR, i+1)
+	}
+
+	log.Printf("Extracted text from %d of %d screenshots.\n", withText, len(screenshots))
+	return nil
+}
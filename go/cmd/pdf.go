@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"picpurge/database"
+	"picpurge/processor"
+
+	"github.com/spf13/cobra"
+)
+
+// PDF preview modes of --pdf-previews.
+const (
+	pdfPreviewsFirst = "first" // the first page of every PDF
+	pdfPreviewsAll   = "all"   // every page
+)
+
+var pdfMatchesCmd = &cobra.Command{
+	Use:   "pdf-matches",
+	Short: "List the PDF pages that show catalogued images.",
+	Long: `Scans with --pdf-previews render the pages of the PDFs they find, such as albums of scanned family photos, and hash them like images. This command lists every page that shows an image of the catalog, with the pHash distance between them; 0 is a perfect match.
+The PDFs themselves are never catalogued, recycled or moved.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		matches, err := database.PDFMatches()
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			fmt.Println("No PDF page matches a catalogued image.")
+			return nil
+		}
+		for _, match := range matches {
+			fmt.Printf("%s, page %d: %s (distance %d)\n", match.PDFPath, match.Page, match.FilePath, match.Distance)
+		}
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(pdfMatchesCmd)
+}
+
+// runPDFPreviews renders and hashes the pages of the PDFs that changed since
+// they were last rendered, and reports the pages that match catalogued images.
+func runPDFPreviews(ctx context.Context, pdfFiles []string, allPages bool) error {
+	log.Println("Rendering PDF pages...")
+	rendered, pages := 0, 0
+	for _, pdfPath := range pdfFiles {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info, err := os.Stat(pdfPath)
+		if err != nil {
+			log.Printf("Error accessing PDF %s: %v\n", pdfPath, err)
+			continue
+		}
+		modTime := info.ModTime().UnixNano()
+		if done, err := database.PDFRendered(pdfPath, modTime, allPages); err != nil {
+			return err
+		} else if done {
+			continue
+		}
+		rasters, err := processor.RasterizePDF(pdfPath, allPages)
+		if err != nil {
+			log.Printf("Warning: Could not render %s: %v\n", pdfPath, err)
+			continue
+		}
+		if err := database.StorePDFPages(pdfPath, modTime, allPages, rasters); err != nil {
+			return err
+		}
+		rendered++
+		pages += len(rasters)
+	}
+	if forgotten, err := database.ForgetMissingPDFs(); err != nil {
+		log.Printf("Warning: Could not forget deleted PDFs: %v\n", err)
+	} else if forgotten > 0 {
+		log.Printf("Forgot the pages of %d PDFs that are gone.\n", forgotten)
+	}
+	log.Printf("Rendered %d pages of %d PDFs; %d PDFs were unchanged.\n", pages, rendered, len(pdfFiles)-rendered)
+
+	matches, err := database.PDFMatches()
+	if err != nil {
+		return err
+	}
+	for _, match := range matches {
+		log.Printf("%s, page %d shows %s (distance %d).\n", match.PDFPath, match.Page, match.FilePath, match.Distance)
+	}
+	if len(matches) > 0 {
+		log.Printf("Found %d PDF pages that match catalogued images; run pdf-matches to list them again.\n", len(matches))
+	}
+	return nil
+}
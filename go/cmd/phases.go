@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"picpurge/database"
+	"picpurge/util"
+
+	"github.com/fatih/color"
+)
+
+// walkedFiles is the number of image files the current run found, reported in phase summaries.
+var walkedFiles int
+
+// summaryJSONPath receives the phase checkpoints and summaries after every phase; "-" is stdout.
+var summaryJSONPath string
+
+// startPhase checkpoints the start of a phase. Checkpoint failures are logged, not fatal.
+func startPhase(phase database.Phase, total int) {
+	if err := database.StartPhase(phase, total); err != nil {
+		log.Printf("Warning: %v\n", err)
+	}
+}
+
+// progressPhase records progress of a running phase.
+func progressPhase(phase database.Phase, done int) {
+	if err := database.UpdatePhaseProgress(phase, done); err != nil {
+		log.Printf("Warning: %v\n", err)
+	}
+}
+
+// finishPhase checkpoints the end of a phase, summarizes the run so far and
+// passes phaseErr through.
+func finishPhase(phase database.Phase, phaseErr error) error {
+	if err := database.FinishPhase(phase, phaseErr); err != nil {
+		log.Printf("Warning: %v\n", err)
+	}
+	if phaseErr == nil {
+		summarizePhase(phase)
+	}
+	return phaseErr
+}
+
+// skipPhase checkpoints a phase that will not run.
+func skipPhase(phase database.Phase) {
+	if err := database.SkipPhase(phase); err != nil {
+		log.Printf("Warning: %v\n", err)
+	}
+}
+
+// summarizePhase stores the counts of the run at the end of a phase, prints
+// them when the phase changed them and updates --summary-json.
+func summarizePhase(phase database.Phase) {
+	summary, err := database.CatalogSummary()
+	if err != nil {
+		log.Printf("Warning: %v\n", err)
+		return
+	}
+	summary.FilesWalked = walkedFiles
+
+	// Counts of later phases would describe an earlier run
+	reached := make(map[database.Phase]bool)
+	for _, p := range database.Phases {
+		reached[p] = true
+		if p == phase {
+			break
+		}
+	}
+	if !reached[database.PhaseHash] {
+		summary.FilesHashed, summary.FilesDecoded = 0, 0
+	}
+	if !reached[database.PhaseAnalyze] {
+		summary.DuplicateGroups, summary.ReclaimableBytes = 0, 0
+	}
+	if !reached[database.PhaseGroup] {
+		summary.SimilarGroups = 0
+	}
+	if err := database.SetPhaseSummary(phase, summary); err != nil {
+		log.Printf("Warning: %v\n", err)
+	}
+
+	switch phase {
+	case database.PhaseWalk, database.PhaseHash, database.PhaseAnalyze, database.PhaseGroup:
+		printPhaseSummary(os.Stderr, phase, summary, reached)
+	}
+	if summaryJSONPath != "" {
+		if err := writeSummaryJSON(summaryJSONPath); err != nil {
+			log.Printf("Warning: Could not write --summary-json: %v\n", err)
+		}
+	}
+}
+
+// printPhaseSummary prints the counts of the phases reached so far as a small
+// table. Duplicates and reclaimable space are highlighted when there are any.
+func printPhaseSummary(w io.Writer, phase database.Phase, s database.RunSummary, reached map[database.Phase]bool) {
+	title := color.New(color.Bold).SprintFunc()
+	badge := color.New(color.FgYellow, color.Bold).SprintFunc()
+	plain := fmt.Sprint
+	row := func(label, value string, highlight bool) {
+		format := plain
+		if highlight {
+			format = badge
+		}
+		fmt.Fprintf(w, "  %-18s %12s\n", label, format(value))
+	}
+
+	fmt.Fprintf(w, "%s\n", title(fmt.Sprintf("Summary after %s", phase)))
+	row("Files walked", fmt.Sprint(s.FilesWalked), false)
+	if reached[database.PhaseHash] {
+		row("Images hashed", fmt.Sprint(s.FilesHashed), false)
+		row("Images decoded", fmt.Sprint(s.FilesDecoded), s.FilesDecoded < s.FilesHashed)
+	}
+	if reached[database.PhaseAnalyze] {
+		row("Duplicate groups", fmt.Sprint(s.DuplicateGroups), s.DuplicateGroups > 0)
+		row("Reclaimable", util.FormatBytes(uint64(s.ReclaimableBytes)), s.ReclaimableBytes > 0)
+	}
+	if reached[database.PhaseGroup] {
+		row("Similar groups", fmt.Sprint(s.SimilarGroups), s.SimilarGroups > 0)
+	}
+}
+
+// writeSummaryJSON writes the phase checkpoints, with their summaries, as one JSON document.
+func writeSummaryJSON(path string) error {
+	phases, err := database.GetPhases()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(map[string]interface{}{"phases": phases})
+	if err != nil {
+		return err
+	}
+	if path == "-" {
+		_, err = fmt.Println(string(data))
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
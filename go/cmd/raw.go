@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"log"
+
+	"picpurge/database"
+	"picpurge/processor"
+	"picpurge/server"
+)
+
+// runRawConversions renders the RAW files that got placeholder thumbnails, one
+// at a time and at low priority. The queue lives in the catalog, so conversions
+// an earlier run did not get to are picked up here instead of being redone.
+func runRawConversions() {
+	conversions, err := database.PendingRawConversions()
+	if err != nil {
+		log.Printf("Warning: Could not read the RAW conversion queue: %v\n", err)
+		return
+	}
+	if len(conversions) == 0 {
+		return
+	}
+	if err := processor.RawConverterAvailable(); err != nil {
+		log.Printf("Warning: %d RAW files keep placeholder thumbnails: %v\n", len(conversions), err)
+		return
+	}
+
+	log.Printf("Rendering thumbnails of %d RAW files in the background...\n", len(conversions))
+	converted := 0
+	for _, c := range conversions {
+		thumbnail, lowInfo, err := processor.RawThumbnail(c.FilePath)
+		if err != nil {
+			log.Printf("Error converting %s (attempt %d of %d): %v\n", c.FilePath, c.Attempts+1, database.MaxRawConversionAttempts, err)
+			if err := database.FailRawConversion(c.MD5, err); err != nil {
+				log.Printf("Warning: %v\n", err)
+			}
+			continue
+		}
+		if err := database.FinishRawConversion(c.MD5, thumbnail, lowInfo); err != nil {
+			log.Printf("Error storing thumbnail of %s: %v\n", c.FilePath, err)
+			continue
+		}
+		server.AddThumbnailToMemory(c.MD5, thumbnail)
+		converted++
+	}
+	log.Printf("Rendered thumbnails of %d of %d RAW files.\n", converted, len(conversions))
+}
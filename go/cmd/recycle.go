@@ -0,0 +1,256 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"picpurge/database"
+	"picpurge/fileops"
+	"picpurge/util"
+
+	"github.com/spf13/cobra"
+)
+
+var recycleCmd = &cobra.Command{
+	Use:   "recycle",
+	Short: "List, restore or delete recycled files.",
+	Long: `Commands that manage the files picpurge moved to the recycle directory and their entries in the catalog.
+Files recycled since moves are logged are found where they were moved. Older ones are looked up in --recycle-path by name, size and content.`,
+}
+
+var recycleListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the recycled files.",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		images, err := database.RecycledImages()
+		if err != nil {
+			return err
+		}
+		var total uint64
+		known := make(map[string]bool)
+		for _, image := range images {
+			location := image.Location(recyclePath)
+			recycled := "unknown         "
+			if image.RecycledAt != nil {
+				recycled = image.RecycledAt.Local().Format("2006-01-02 15:04")
+			}
+			if location == "" {
+				location = "(file not found)"
+			} else {
+				known[location] = true
+				total += uint64(image.Size)
+			}
+			fmt.Printf("%6d  %s  %9s  %s <- %s\n", image.ID, recycled, util.FormatBytes(uint64(image.Size)), location, image.Path)
+		}
+		fmt.Printf("%d recycled images, %s on disk.\n", len(images), util.FormatBytes(total))
+		if others := database.StrayRecycledFiles(recyclePath, known); len(others) > 0 {
+			fmt.Printf("%s also holds %d files that are not in the catalog.\n", recyclePath, len(others))
+		}
+		return nil
+	},
+}
+
+var recycleRestoreCmd = &cobra.Command{
+	Use:   "restore ID|path...",
+	Short: "Move recycled files back to where they were.",
+	Long: `This command moves recycled files back to their original paths and returns them to their duplicate and similar groups.
+Name each file by the ID shown by recycle list, its original path or its path in the recycle directory. A file whose original path is taken is left where it is.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		images, err := database.RecycledImages()
+		if err != nil {
+			return err
+		}
+		restored, failed := 0, 0
+		for _, arg := range args {
+			image, location, ok := findRecycled(images, arg, recyclePath)
+			if !ok {
+				log.Printf("Error: %s is not a recycled image.\n", arg)
+				failed++
+				continue
+			}
+			if location == "" {
+				log.Printf("Error: The recycled file of %s was not found.\n", image.Path)
+				failed++
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(image.Path), 0755); err != nil {
+				log.Printf("Error creating directory for %s: %v\n", image.Path, err)
+				failed++
+				continue
+			}
+			if err := fileops.Unrecycle(location, image.Path); err != nil {
+				log.Printf("Error moving %s back to %s: %v\n", location, image.Path, err)
+				failed++
+				continue
+			}
+			if err := database.RestoreRecycled(image); err != nil {
+				log.Printf("Error updating database for restored file %s: %v\n", image.Path, err)
+				failed++
+				continue
+			}
+			log.Printf("Restored %s\n", image.Path)
+			restored++
+		}
+		log.Printf("Restored %d files.\n", restored)
+		if failed > 0 {
+			return fmt.Errorf("%d files could not be restored", failed)
+		}
+		return nil
+	},
+}
+
+var recycleEmptyCmd = &cobra.Command{
+	Use:   "empty",
+	Short: "Delete every recycled file for good.",
+	Long: `This command permanently deletes the recycled files, together with any other files in the recycle directory, and removes the recycled images from the catalog.
+Use --dry-run to see what would be deleted.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !recycleDryRun && !consentDelete {
+			return fmt.Errorf("recycle empty deletes files for good; re-run with --i-understand-files-will-be-deleted to confirm, or with --dry-run")
+		}
+		return runPurgeRecycled(time.Time{}, true, recycleDryRun)
+	},
+}
+
+var recyclePurgeCmd = &cobra.Command{
+	Use:   "purge --older-than AGE",
+	Short: "Delete files recycled longer ago than a given age for good.",
+	Long: `This command permanently deletes the files recycled more than --older-than ago, e.g. 30d, 6mo or 1y, and removes them from the catalog.
+Files recycled before recycle times were recorded are kept; use recycle empty to delete them. Use --dry-run to see what would be deleted.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if recycleOlderThan == "" {
+			return fmt.Errorf("--older-than is required, e.g. --older-than 30d")
+		}
+		if strings.HasPrefix(recycleOlderThan, "-") {
+			return fmt.Errorf("--older-than must not be negative")
+		}
+		age, err := util.ParseDateShift("-" + strings.TrimPrefix(recycleOlderThan, "+"))
+		if err != nil {
+			return fmt.Errorf("invalid --older-than: %w", err)
+		}
+		if !recycleDryRun && !consentDelete {
+			return fmt.Errorf("recycle purge deletes files for good; re-run with --i-understand-files-will-be-deleted to confirm, or with --dry-run")
+		}
+		return runPurgeRecycled(age.Apply(time.Now()), false, recycleDryRun)
+	},
+}
+
+var (
+	recycleOlderThan string
+	recycleDryRun    bool
+	consentDelete    bool
+)
+
+func init() {
+	RootCmd.AddCommand(recycleCmd)
+	recycleCmd.AddCommand(recycleListCmd, recycleRestoreCmd, recycleEmptyCmd, recyclePurgeCmd)
+	recycleCmd.PersistentFlags().StringVar(&recyclePath, "recycle-path", "Recycle", "Path of the Recycle directory, for files recycled before moves were logged.")
+	for _, command := range []*cobra.Command{recycleEmptyCmd, recyclePurgeCmd} {
+		command.Flags().BoolVar(&recycleDryRun, "dry-run", false, "Print what would be deleted without deleting anything.")
+		command.Flags().BoolVar(&consentDelete, "i-understand-files-will-be-deleted", false, "Required to delete the recycled files.")
+	}
+	recyclePurgeCmd.Flags().StringVar(&recycleOlderThan, "older-than", "", "Delete files recycled longer ago than this, e.g. 30d or 1y2mo.")
+}
+
+// runPurgeRecycled deletes the recycled files recycled before cutoff, or all of
+// them with everything, which also deletes the files in the recycle directory
+// that are not in the catalog. Images whose file is deleted, or gone already,
+// leave the catalog.
+func runPurgeRecycled(cutoff time.Time, everything, dryRun bool) error {
+	images, err := database.RecycledImages()
+	if err != nil {
+		return err
+	}
+	var deleted, freed uint64
+	known := make(map[string]bool)
+	for _, image := range images {
+		location := image.Location(recyclePath)
+		if location != "" {
+			known[location] = true
+		}
+		if !everything && (image.RecycledAt == nil || !image.RecycledAt.Before(cutoff)) {
+			continue
+		}
+		if dryRun {
+			if location != "" {
+				log.Printf("Would delete %s (%s)\n", location, image.Path)
+			}
+			deleted++
+			continue
+		}
+		if location != "" {
+			if err := fileops.Remove(location); err != nil {
+				log.Printf("Error deleting %s: %v\n", location, err)
+				continue
+			}
+			fileops.ForgetTrashed(location)
+			freed += uint64(image.Size)
+		}
+		if err := database.ForgetRecycled(image.ID); err != nil {
+			log.Printf("Error removing recycled image %s from the catalog: %v\n", image.Path, err)
+			continue
+		}
+		deleted++
+	}
+	if everything {
+		for _, path := range database.StrayRecycledFiles(recyclePath, known) {
+			if dryRun {
+				log.Printf("Would delete %s\n", path)
+				continue
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if err := fileops.Remove(path); err != nil {
+				log.Printf("Error deleting %s: %v\n", path, err)
+				continue
+			}
+			freed += uint64(info.Size())
+		}
+	}
+	if dryRun {
+		log.Printf("Dry run: %d recycled images would be deleted.\n", deleted)
+		return nil
+	}
+	log.Printf("Deleted %d recycled images, freeing %s.\n", deleted, util.FormatBytes(freed))
+	return nil
+}
+
+// findRecycled looks up a recycled image by ID, original path or path in the
+// recycle directory, and returns it with its current location.
+func findRecycled(images []database.RecycledImage, arg, recycleDir string) (database.RecycledImage, string, bool) {
+	id, err := strconv.Atoi(arg)
+	isID := err == nil
+	absArg, _ := filepath.Abs(arg)
+	for _, image := range images {
+		if (isID && image.ID == id) || image.Path == arg || image.Path == absArg {
+			return image, image.Location(recycleDir), true
+		}
+	}
+	for _, image := range images {
+		if location := image.Location(recycleDir); location != "" && (location == arg || location == absArg) {
+			return image, location, true
+		}
+	}
+	return database.RecycledImage{}, "", false
+}
+
+// unrecycle moves a recycled file back when the catalog could not record that
+// it was recycled, so the catalog does not list a file that is gone.
+func unrecycle(filePath, recycledPath string) {
+	if err := fileops.Unrecycle(recycledPath, filePath); err != nil {
+		log.Printf("ERROR: %s was recycled, but the catalog still lists it: %v\n", filePath, err)
+		return
+	}
+	log.Printf("Moved %s back.\n", filePath)
+}
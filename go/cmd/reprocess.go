@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"picpurge/database"
+	"picpurge/server"
+	"picpurge/util"
+
+	"github.com/spf13/cobra"
+)
+
+var reprocessCmd = &cobra.Command{
+	Use:   "reprocess <path|id>...",
+	Short: "Process selected images again and update their groups.",
+	Long: `This command hashes, reads the EXIF of and thumbnails catalogued images again, e.g. after a corrupt file was repaired or its EXIF edited in another program. Pass image IDs, files, or folders to reprocess every catalogued image below them.
+
+Only the duplicate and similar groups of the reprocessed images change; the rest of the catalog is left as it is. Similar groups are matched by pHash. Files not yet in the catalog are not added; use scan for those.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ids, err := reprocessTargets(args)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			log.Println("No catalogued images to reprocess.")
+			return withExitCode(ExitNoImages)
+		}
+
+		failed := 0
+		for _, id := range ids {
+			imageData, err := server.ReprocessImage(id)
+			if err != nil {
+				log.Printf("Error: %v\n", err)
+				failed++
+				continue
+			}
+			log.Printf("Reprocessed %s (image ID %d).\n", imageData.FilePath, id)
+		}
+		log.Printf("Reprocessed %d of %d images.\n", len(ids)-failed, len(ids))
+		if failed > 0 {
+			return withExitCode(ExitProcessingErrors)
+		}
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(reprocessCmd)
+}
+
+// reprocessTargets resolves image IDs, file paths and folders to the IDs of
+// catalogued images, in the order given and without repeats. An argument that
+// is neither an existing path nor a number is an error.
+func reprocessTargets(args []string) ([]int, error) {
+	db, err := database.GetDBInstance()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database instance: %w", err)
+	}
+	var ids []int
+	seen := make(map[int]bool)
+	add := func(id int) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	for _, arg := range args {
+		info, statErr := os.Stat(arg)
+		if statErr != nil {
+			id, err := strconv.Atoi(arg)
+			if err != nil {
+				return nil, fmt.Errorf("%s is neither a file, a folder nor an image ID", arg)
+			}
+			var exists bool
+			if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM images WHERE id = ? AND is_recycled = FALSE)", id).Scan(&exists); err != nil || !exists {
+				return nil, fmt.Errorf("image ID %d is not in the catalog", id)
+			}
+			add(id)
+			continue
+		}
+
+		// The catalog stores paths as they were given to scan, relative or absolute
+		abs, err := filepath.Abs(arg)
+		if err != nil {
+			abs = arg
+		}
+		if !info.IsDir() {
+			var id int
+			if err := db.QueryRow("SELECT id FROM images WHERE (file_path = ? OR file_path = ?) AND is_recycled = FALSE", arg, abs).Scan(&id); err != nil {
+				return nil, fmt.Errorf("%s is not in the catalog; use scan to add it", arg)
+			}
+			add(id)
+			continue
+		}
+
+		rows, err := db.Query("SELECT id, file_path FROM images WHERE is_recycled = FALSE ORDER BY file_path")
+		if err != nil {
+			return nil, fmt.Errorf("error querying images: %w", err)
+		}
+		found := 0
+		for rows.Next() {
+			var id int
+			var filePath string
+			if err := rows.Scan(&id, &filePath); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("error scanning image: %w", err)
+			}
+			if util.InFolder(filePath, arg) || util.InFolder(filePath, abs) {
+				add(id)
+				found++
+			}
+		}
+		rows.Close()
+		if found == 0 {
+			log.Printf("Warning: No catalogued images in %s.\n", arg)
+		}
+	}
+	return ids, nil
+}
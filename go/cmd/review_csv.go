@@ -0,0 +1,268 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"picpurge/database"
+	"picpurge/export"
+	"picpurge/fileops"
+	"picpurge/util"
+
+	"github.com/spf13/cobra"
+)
+
+var exportCSVCmd = &cobra.Command{
+	Use:   "export-csv [file]",
+	Short: "Write duplicate and similar groups to a CSV sheet for review.",
+	Long: `This command writes every duplicate and similar group to a CSV file, one image per row, for reviewing in a spreadsheet such as Excel. The suggested column shows what picpurge would keep or discard. Fill in the decision column with keep or discard and load the sheet back with apply-csv; rows left empty are not touched.
+
+Pass - to write to stdout.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out := os.Stdout
+		if args[0] != "-" {
+			file, err := os.Create(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to create %s: %w", args[0], err)
+			}
+			defer file.Close()
+			out = file
+		}
+		count, err := export.GroupsCSV(out)
+		if err != nil {
+			return fmt.Errorf("error exporting groups: %w", err)
+		}
+		if args[0] != "-" {
+			log.Printf("Wrote %d images to %s.\n", count, args[0])
+		}
+		return nil
+	},
+}
+
+var applyCSVCmd = &cobra.Command{
+	Use:   "apply-csv [file]",
+	Short: "Recycle the images marked discard in a reviewed CSV sheet.",
+	Long: `This command reads a sheet written by export-csv after someone filled in its decision column, and moves the images marked discard to the recycle directory. Groups whose images are all decided are marked as reviewed.
+
+Rows whose image is no longer in the catalog at the listed path are skipped. A group is never emptied: if every image of a group is marked discard, the group is left alone. Use --dry-run to see what would be recycled.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		recycleCap, err := util.ParseRecycleCap(maxRecycle)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", args[0], err)
+		}
+		defer file.Close()
+		rows, err := export.ReadDecisionsCSV(file)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", args[0], err)
+		}
+		if !applyCSVDryRun && !consentDataMove {
+			return fmt.Errorf("apply-csv will move files on disk; re-run with --i-understand-data-will-move to confirm, or with --dry-run")
+		}
+		if recyclePath == "" {
+			recyclePath = "Recycle"
+		}
+		return runApplyCSV(rows, recyclePath, recycleCap, applyCSVDryRun, recycleArchive)
+	},
+}
+
+var applyCSVDryRun bool
+
+func init() {
+	RootCmd.AddCommand(exportCSVCmd)
+	RootCmd.AddCommand(applyCSVCmd)
+	applyCSVCmd.Flags().StringVar(&recyclePath, "recycle-path", "", "Specify the path for the Recycle directory. Defaults to Recycle.")
+	applyCSVCmd.Flags().StringVar(&maxRecycle, "max-recycle", "10%", "Maximum number of files (e.g. 500) or share of the library (e.g. 10%) the sheet may recycle. 0 disables the cap.")
+	applyCSVCmd.Flags().BoolVar(&consentDataMove, "i-understand-data-will-move", false, "Required to move the discarded images on disk.")
+	applyCSVCmd.Flags().StringVar(&recycleArchive, "archive-before-recycle", "", "Back up the discarded images to a ZIP archive at this path, one folder per group with a manifest of their original paths, before moving them. A folder gets a time-stamped archive. Nothing is moved if the archive cannot be written.")
+	applyCSVCmd.Flags().BoolVar(&applyCSVDryRun, "dry-run", false, "Print what would be recycled without moving anything.")
+}
+
+// reviewedGroup collects the rows of one group in a review sheet.
+type reviewedGroup struct {
+	groupType, key string
+	rows           []export.ReviewRow
+	undecided      bool
+}
+
+// runApplyCSV recycles the images a reviewer discarded and records the groups
+// they fully decided as reviewed. With an archivePath the images are first
+// backed up to a ZIP archive there.
+func runApplyCSV(rows []export.ReviewRow, recyclePath string, recycleCap util.RecycleCap, dryRun bool, archivePath string) error {
+	db, err := database.GetDBInstance()
+	if err != nil {
+		return fmt.Errorf("failed to get database instance: %w", err)
+	}
+
+	var groups []*reviewedGroup
+	byKey := make(map[string]*reviewedGroup)
+	for _, row := range rows {
+		if row.GroupType != database.GroupTypeDuplicates && row.GroupType != database.GroupTypeSimilar {
+			log.Printf("Warning: Skipping image ID %d with unknown group type %q.\n", row.ID, row.GroupType)
+			continue
+		}
+		// The sheet may be older than the catalog; only act on images that are still where it says
+		var filePath string
+		var recycled bool
+		if err := db.QueryRow("SELECT file_path, is_recycled FROM images WHERE id = ?", row.ID).Scan(&filePath, &recycled); err != nil {
+			log.Printf("Warning: Skipping image ID %d, which is not in the catalog.\n", row.ID)
+			continue
+		}
+		if recycled || strings.TrimSpace(filePath) != row.Path {
+			log.Printf("Warning: Skipping image ID %d, which is no longer at %s.\n", row.ID, row.Path)
+			continue
+		}
+		row.Path = filePath
+
+		key := row.GroupType + "/" + row.Group
+		group := byKey[key]
+		if group == nil {
+			group = &reviewedGroup{groupType: row.GroupType, key: row.Group}
+			byKey[key] = group
+			groups = append(groups, group)
+		}
+		group.rows = append(group.rows, row)
+		if row.Decision == "" {
+			group.undecided = true
+		}
+	}
+
+	// An image can be in a duplicate and a similar group at once, so a group is
+	// only safe once the discards of all groups leave one of its images
+	discarded := make(map[int]bool)
+	for _, group := range groups {
+		for _, row := range group.rows {
+			if row.Decision == export.DecisionDiscard {
+				discarded[row.ID] = true
+			}
+		}
+	}
+	for emptied := true; emptied; {
+		emptied = false
+		for _, group := range groups {
+			kept := false
+			for _, row := range group.rows {
+				if !discarded[row.ID] {
+					kept = true
+					break
+				}
+			}
+			if kept {
+				continue
+			}
+			log.Printf("Warning: Every image of %s group %s is marked discard; keeping them all.\n", group.groupType, group.key)
+			for _, row := range group.rows {
+				delete(discarded, row.ID)
+			}
+			emptied = true
+		}
+	}
+	if len(discarded) == 0 {
+		log.Println("No images are marked discard.")
+	}
+
+	var librarySize int
+	if err := db.QueryRow("SELECT COUNT(*) FROM images WHERE is_recycled = FALSE").Scan(&librarySize); err != nil {
+		return fmt.Errorf("error counting images: %w", err)
+	}
+	if limit := recycleCap.Limit(librarySize); limit >= 0 && len(discarded) > limit {
+		log.Printf("Aborted: %d files would be recycled, cap is %s (%d of %d images).\n", len(discarded), recycleCap, limit, librarySize)
+		return fmt.Errorf("%w: %d files selected, at most %d allowed", util.ErrRecycleCapExceeded, len(discarded), limit)
+	}
+
+	done := make(map[int]bool) // images in several groups are recycled once
+	if dryRun {
+		for _, group := range groups {
+			for _, row := range group.rows {
+				if discarded[row.ID] && !done[row.ID] {
+					done[row.ID] = true
+					log.Printf("Would recycle %s\n", row.Path)
+				}
+			}
+		}
+		log.Printf("Dry run: %d images would be recycled.\n", len(discarded))
+		if archivePath != "" && len(discarded) > 0 {
+			log.Printf("Dry run: they would be archived to %s first.\n", archivePath)
+		}
+		return nil
+	}
+
+	log.Printf("Pre-action summary: %d files (of %d images) will be moved to %s\n", len(discarded), librarySize, fileops.RecycleDestination(recyclePath))
+	if archivePath != "" && len(discarded) > 0 {
+		var files []export.ArchivedFile
+		archived := make(map[int]bool)
+		for _, group := range groups {
+			for _, row := range group.rows {
+				if discarded[row.ID] && !archived[row.ID] {
+					archived[row.ID] = true
+					files = append(files, export.ArchivedFile{GroupType: group.groupType, Group: group.key, ID: row.ID, Path: row.Path})
+				}
+			}
+		}
+		if err := archiveBeforeRecycle(archivePath, files); err != nil {
+			log.Println("ERROR: Aborted because the archive could not be written. No files were moved.")
+			return err
+		}
+	}
+	recycledCount := 0
+	failed := make(map[int]bool)
+	moves := newOperationLog(database.OperationApplyCSV)
+	defer moves.report()
+	for _, group := range groups {
+		for _, row := range group.rows {
+			if !discarded[row.ID] || done[row.ID] {
+				continue
+			}
+			done[row.ID] = true
+			recycledPath, err := fileops.Recycle(row.Path, recyclePath)
+			if err != nil {
+				log.Printf("Error moving file to recycle bin %s: %v\n", row.Path, err)
+				failed[row.ID] = true
+				continue
+			}
+			if err := database.MarkRecycled(row.Path); err != nil {
+				log.Printf("Error updating database for recycled image %s: %v\n", row.Path, err)
+				unrecycle(row.Path, recycledPath)
+				failed[row.ID] = true
+				continue
+			}
+			moves.record(row.Path, recycledPath)
+			recycledCount++
+		}
+	}
+
+	reviewedCount := 0
+	for _, group := range groups {
+		if group.undecided {
+			continue
+		}
+		status := database.ReviewKeepAll
+		for _, row := range group.rows {
+			// Images that failed to move, or whose discard was overruled, leave the group open
+			if failed[row.ID] || (row.Decision == export.DecisionDiscard && !discarded[row.ID]) {
+				status = ""
+				break
+			}
+			if discarded[row.ID] {
+				status = database.ReviewResolved
+			}
+		}
+		if status == "" {
+			continue
+		}
+		if err := database.SetGroupReview(database.GroupReview{GroupType: group.groupType, GroupKey: group.key, Status: status, Notes: "Decided in a CSV review"}); err != nil {
+			log.Printf("Warning: %v\n", err)
+			continue
+		}
+		reviewedCount++
+	}
+	log.Printf("Recycled %d images and marked %d groups as reviewed.\n", recycledCount, reviewedCount)
+	return nil
+}
@@ -1,8 +1,17 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"os"
+	"path/filepath"
+	"strings"
+
+	"picpurge/database"
+	"picpurge/fileops"
+	"picpurge/processor"
+	"picpurge/util"
 
 	"github.com/spf13/cobra"
 )
@@ -12,16 +21,148 @@ var RootCmd = &cobra.Command{
 	Use:   "picpurge",
 	Short: "PicPurge is an image organization tool",
 	Long:  `A powerful command-line tool to organize, deduplicate, and manage your image collection.`,
+	// Errors are printed by Execute, which also skips result codes that are not failures
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// Open the catalog once flags are known, so --db-path can select the file
+		path, err := resolveDBPath()
+		if err != nil {
+			return err
+		}
+		dbPath = path
+		if dbPath == database.MemoryPath {
+			log.Println("Keeping the catalog in memory.")
+		} else if dbPath != "" {
+			log.Printf("Using catalog %s\n", dbPath)
+		}
+		if limits := util.DetectResourceLimits(); limits.Limited() {
+			log.Printf("Resource limits: %s\n", limits)
+			util.SetSoftMemoryLimit(limits)
+		}
+		database.SetPath(dbPath)
+		database.SetSnapshotPath(saveDBPath)
+		if err := database.SetSortLocale(sortLocale); err != nil {
+			return err
+		}
+		fileops.UseSystemTrash(systemTrash)
+		if err := injectFaults(injectedFaults); err != nil {
+			return err
+		}
+		if _, err := database.GetDBInstance(); err != nil {
+			return fmt.Errorf("failed to connect to database: %w", err)
+		}
+		return setHashAlgo(hashAlgo)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		// Default action if no subcommand is given
 		cmd.Help()
 	},
 }
 
-// Execute runs the root command.
-func Execute() {
-	if err := RootCmd.Execute(); err != nil {
+var (
+	dbPath      string
+	tempDB      bool
+	saveDBPath  string
+	sortLocale  string
+	systemTrash bool
+	hashAlgo    string
+
+	injectedFaults []string
+)
+
+// dbPathEnv names the environment variable that sets the catalog when --db-path is not given.
+const dbPathEnv = "PICPURGE_DB_PATH"
+
+func init() {
+	RootCmd.PersistentFlags().StringVar(&dbPath, "db-path", "", "Path of the catalog database, kept across runs. Interrupted scans resume from it. Defaults to $"+dbPathEnv+", or ~/.picpurge/picpurge.db. \""+database.MemoryPath+"\" keeps the catalog in RAM for quick one-off runs on small folders.")
+	RootCmd.PersistentFlags().StringVar(&dbPath, "db", "", "Alias for --db-path.")
+	RootCmd.PersistentFlags().MarkHidden("db")
+	RootCmd.PersistentFlags().StringVar(&sortLocale, "sort-locale", "und", "Language whose alphabet order is used when listings are sorted by name, e.g. de or sv. Numbers in names sort by value either way.")
+	RootCmd.PersistentFlags().BoolVar(&systemTrash, "system-trash", false, "Recycle files to the trash of the operating system instead of a Recycle directory, so they can be restored from there. Files sent to the Windows Recycle Bin cannot be moved back by undo.")
+	RootCmd.PersistentFlags().StringVar(&hashAlgo, "hash-algo", "", "Content hash that decides which files are exact duplicates: md5, sha256, blake3 or xxh3. Use sha256 or blake3 where crafted MD5 collisions matter. Defaults to the hash the catalog already uses, or md5; after a change the next scan hashes every image again.")
+	RootCmd.PersistentFlags().BoolVar(&tempDB, "temp-db", false, "Use a throwaway database that is deleted on exit instead of the catalog.")
+	RootCmd.PersistentFlags().StringVar(&saveDBPath, "save-db", "", "Save a copy of the catalog to this file when the command ends, e.g. to keep the results of --db memory. A file already there is replaced.")
+	RootCmd.PersistentFlags().StringSliceVar(&injectedFaults, "inject-fault", nil, "For testing: make operations fail on purpose. copy fails copies half-way, exdev makes moves copy and delete as across volumes, remove fails removing files and db-write fails the catalog updates that record moved and recycled files.")
+	RootCmd.PersistentFlags().MarkHidden("inject-fault")
+}
+
+// resolveDBPath returns the catalog selected by --db-path, $PICPURGE_DB_PATH or
+// the default under the home directory, creating its folder. It returns "" for
+// --temp-db and database.MemoryPath for an in-memory catalog, which SQLite
+// users may also name :memory:.
+func resolveDBPath() (string, error) {
+	if tempDB {
+		if dbPath != "" {
+			return "", fmt.Errorf("--temp-db and --db-path cannot be combined")
+		}
+		return "", nil
+	}
+	path := dbPath
+	if path == "" {
+		path = os.Getenv(dbPathEnv)
+	}
+	if path == database.MemoryPath || path == ":memory:" {
+		return database.MemoryPath, nil
+	}
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to find home directory for the default catalog, use --db-path: %w", err)
+		}
+		path = filepath.Join(home, ".picpurge", "picpurge.db")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create catalog folder: %w", err)
+	}
+	return path, nil
+}
+
+// setHashAlgo sets the content hash of --hash-algo, or keeps the one of the catalog.
+func setHashAlgo(name string) error {
+	current, err := database.CatalogHashAlgo()
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = string(current)
+	}
+	if name == "" {
+		name = string(util.HashMD5)
+	}
+	if err := processor.SetHashAlgo(name); err != nil {
+		return err
+	}
+	if current != "" && processor.HashAlgo() != current {
+		log.Printf("Switching content hashes from %s to %s.\n", current, processor.HashAlgo())
+	}
+	return nil
+}
+
+// injectFaults sets up the failures named by --inject-fault.
+func injectFaults(names []string) error {
+	var faults []fileops.Fault
+	for _, name := range names {
+		switch fault := fileops.Fault(strings.ToLower(strings.TrimSpace(name))); fault {
+		case fileops.FaultCopy, fileops.FaultCrossDevice, fileops.FaultRemove:
+			faults = append(faults, fault)
+		case "db-write":
+			database.FailMoveWrites(true)
+		default:
+			return fmt.Errorf("unknown fault %q; use %s, %s, %s or db-write", name, fileops.FaultCopy, fileops.FaultCrossDevice, fileops.FaultRemove)
+		}
+		log.Printf("Warning: Injecting %s failures for testing.\n", name)
+	}
+	fileops.InjectFaults(faults...)
+	return nil
+}
+
+// Execute runs the root command and returns the process exit code.
+func Execute() int {
+	err := RootCmd.Execute()
+	var result *resultError
+	if err != nil && !errors.As(err, &result) {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
 	}
+	return exitCodeOf(err)
 }
@@ -1,23 +1,31 @@
 package cmd
 
 import (
-	"bufio"
+	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
 	"math"
 	"os"
 	"path/filepath"
-	"runtime"
 	"strings"
 	"sync"
 	"time"
 
 	"picpurge/database"
+	"picpurge/events"
+	"picpurge/export"
+	"picpurge/fileops"
+	"picpurge/grouping"
+	"picpurge/ocr"
 	"picpurge/processor"
+	"picpurge/quota"
 	"picpurge/server"
+	"picpurge/similarity"
 	"picpurge/util"
 	"picpurge/walker"
+	"picpurge/worker"
 
 	"github.com/briandowns/spinner"
 	"github.com/corona10/goimagehash"
@@ -31,213 +39,547 @@ var scanCmd = &cobra.Command{
 	Long:  `This command scans the provided directories or files for images, extracts metadata, and stores it in the database.`,
 	Args:  cobra.ArbitraryArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		renamePolicy, err := util.ParseRenamePolicy(sortRenamePolicy)
+		if err != nil {
+			return err
+		}
+		recycleCap, err := util.ParseRecycleCap(maxRecycle)
+		if err != nil {
+			return err
+		}
+		var priorityPaths []string
+		if keepPathPriority != "" {
+			priorityPaths = strings.Split(keepPathPriority, ",")
+		}
+		keepPolicy, err := grouping.ParseKeepPolicy(keepPolicyRules, priorityPaths)
+		if err != nil {
+			return fmt.Errorf("invalid --keep-policy: %w", err)
+		}
+		freeSpaceThreshold, err := util.ParseSpaceThreshold(minFreeSpace)
+		if err != nil {
+			return err
+		}
+		if quotaWebhook != "" && !freeSpaceThreshold.Enabled() {
+			return fmt.Errorf("--quota-webhook needs --min-free-space")
+		}
+		if freeSpaceThreshold.Enabled() && quotaInterval <= 0 {
+			return fmt.Errorf("--quota-interval must be positive")
+		}
+		if err := checkDestructiveConsent(); err != nil {
+			return err
+		}
+		ctx, stop := interruptContext(cmd.Context())
+		defer stop()
+		if err := server.SetAPIVersion(apiVersion); err != nil {
+			return err
+		}
+		if err := server.SetBasePath(basePath); err != nil {
+			return err
+		}
+		if ocrFlag && !ocr.Available() {
+			return fmt.Errorf("--ocr requires tesseract. Please install tesseract or run without --ocr")
+		}
+		switch pdfPreviews {
+		case "", pdfPreviewsFirst, pdfPreviewsAll:
+		default:
+			return fmt.Errorf("unknown --pdf-previews %q: use first or all", pdfPreviews)
+		}
+		if pdfPreviews != "" {
+			if err := processor.PDFRasterizerAvailable(); err != nil {
+				return fmt.Errorf("--pdf-previews: %w", err)
+			}
+		}
+		if pathsFrom == "-" && filesFrom == "-" {
+			return fmt.Errorf("--paths-from and --files-from cannot both read stdin")
+		}
+		args, err = scanRoots(args)
+		if err != nil {
+			return err
+		}
+		if sortImagesFlag && sortDestinationPath == "" && len(args) == 0 {
+			return fmt.Errorf("--sort without --sort-destination needs a path argument to sort into")
+		}
 
-		log.Printf("Scanning paths: %v\n", args)
-
-		s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
-		s.Prefix = "Scanning for image files "
-		s.Start()
-
-		var allImageFiles []string
-		for _, path := range args {
-			info, err := os.Stat(path)
+		// Nice mode: lower our priority and cap disk reads so the machine stays usable.
+		var readLimit float64
+		if niceMode {
+			if err := util.LowerProcessPriority(); err != nil {
+				log.Printf("Warning: Could not lower process priority: %v\n", err)
+			}
+			readLimit = niceReadLimitMB * 1024 * 1024
+			log.Printf("Nice mode enabled. Disk reads capped at %.1f MB/s.\n", niceReadLimitMB)
+		}
+		limiter := util.NewRateLimiter(readLimit)
+		processor.SetReadLimiter(limiter)
+		walker.SetDetectContentType(detectContentType)
+		walker.SetSymlinkOptions(walker.SymlinkOptions{Follow: followSymlinks, Dedupe: dedupeSymlinks})
+		if err := setMinimumSize(); err != nil {
+			return err
+		}
+		if err := processor.SetConfirmHashes(confirmHashes); err != nil {
+			return fmt.Errorf("invalid --confirm-hashes: %w", err)
+		}
+		stopProvider, err := startSimilarityProvider()
+		if err != nil {
+			return err
+		}
+		defer stopProvider()
+
+		// Start the server up front so the scan can be paused and resumed through the API.
+		serverErr := make(chan error, 1)
+		if !noServer {
+			server.SetLaunchConfig(server.LaunchConfig{Enabled: allowLaunch, Editor: launchEditor})
+			if allowLaunch {
+				log.Println("Opening originals in desktop applications is enabled for requests from this machine.")
+			}
+			enableReportQueries()
+			server.SetBrowsePolicy(server.BrowsePolicy{Workers: browseWorkers, Idle: browseIdle})
+			log.Println("Starting web server...")
+			listeners, err := server.Listen(serverPort, listenAddresses)
 			if err != nil {
-				log.Printf("Error accessing path '%s': %v\n", path, err)
-				continue
+				return fmt.Errorf("failed to start server: %w", err)
 			}
+			go func() {
+				serverErr <- server.Serve(ctx, listeners)
+			}()
+		}
 
-			if info.IsDir() {
-				files, err := walker.FindImageFiles(path)
-				if err != nil {
-					log.Printf("Error scanning directory '%s': %v\n", path, err)
-					continue
-				}
-				allImageFiles = append(allImageFiles, files...)
-			} else if info.Mode().IsRegular() {
-				if walker.IsImageFile(path) {
-					allImageFiles = append(allImageFiles, path)
-				} else {
-					log.Printf("Skipping non-image file: %s\n", path)
+		if err := database.ResetPhases(); err != nil {
+			return err
+		}
+
+		// An interrupted scan left the files it found behind, so it need not walk again
+		var allImageFiles, pdfFiles []string
+		resumed := false
+		if resumeScan {
+			var roots, files []string
+			if roots, files, resumed, err = checkpointToResume(args); err != nil {
+				return err
+			}
+			if resumed {
+				args = roots
+				for _, file := range files {
+					if walker.IsPDFFile(file) {
+						pdfFiles = append(pdfFiles, file)
+					} else {
+						allImageFiles = append(allImageFiles, file)
+					}
 				}
 			}
 		}
 
-		s.Stop()
+		log.Printf("Scanning paths: %v\n", args)
+		startPhase(database.PhaseWalk, len(args))
+		if !resumed {
+			if allImageFiles, pdfFiles, err = findScanFiles(ctx, args, pdfPreviews != ""); err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				finishPhase(database.PhaseWalk, ctx.Err())
+				log.Println("Scan interrupted while looking for image files. Nothing was processed.")
+				return withExitCode(ExitInterrupted)
+			}
+			// PDFs are resumed with the images; with no images there is nothing to resume
+			checkpoint := allImageFiles
+			if len(allImageFiles) > 0 {
+				checkpoint = append(allImageFiles[:len(allImageFiles):len(allImageFiles)], pdfFiles...)
+			}
+			if err := database.SaveScanCheckpoint(args, checkpoint); err != nil {
+				log.Printf("Warning: %v\n", err)
+			}
+		}
+		recordScanRoots(args)
+		walkedFiles = len(allImageFiles)
+		progressPhase(database.PhaseWalk, len(args))
+		finishPhase(database.PhaseWalk, nil)
 		log.Printf("Found %d image files.\n", len(allImageFiles))
+		if pdfPreviews != "" {
+			log.Printf("Found %d PDF files.\n", len(pdfFiles))
+		}
 
 		if len(allImageFiles) == 0 {
 			log.Println("No images to process.")
-			return nil // No error, just no images
-		}
-
-		log.Println("Starting image processing...")
-
-		bar := progressbar.Default(int64(len(allImageFiles)), "Processing images")
-
-		numWorkers := runtime.NumCPU()
-		if numWorkers == 0 {
-			numWorkers = 1
-		}
-		log.Printf("Using %d worker goroutines for image processing.\n", numWorkers)
-
-		jobs := make(chan string, len(allImageFiles))
-		results := make(chan struct {
-			ImageData     *processor.ImageData
-			ThumbnailData []byte
-		}, len(allImageFiles))
-		errors := make(chan error, len(allImageFiles))
-		var wg sync.WaitGroup
-
-		for w := 0; w < numWorkers; w++ {
-			wg.Add(1)
-			go func(workerID int) {
-				defer wg.Done()
-				for filePath := range jobs {
-					imageData, thumbnailData, err := processor.ProcessImage(filePath)
-					if err != nil {
-						errors <- fmt.Errorf("error processing image '%s': %w", filePath, err)
-						bar.Add(1)
-						continue
-					}
-					results <- struct {
-						ImageData     *processor.ImageData
-						ThumbnailData []byte
-					}{
-						ImageData:     imageData,
-						ThumbnailData: thumbnailData,
-					}
-					bar.Add(1)
+			for _, phase := range database.Phases[1:] {
+				skipPhase(phase)
+			}
+			// The pages of a folder of PDFs are still matched against the catalog
+			if len(pdfFiles) > 0 {
+				if err := runPDFPreviews(ctx, pdfFiles, pdfPreviews == pdfPreviewsAll); err != nil {
+					return fmt.Errorf("error rendering PDF pages: %w", err)
 				}
-			}(w)
+			}
+			return withExitCode(ExitNoImages) // No error, just no images
 		}
 
-		for _, filePath := range allImageFiles {
-			jobs <- filePath
+		// Trust what an earlier run committed and only process new or changed files
+		interrupted, committedCount, err := database.BeginScanRecovery()
+		if err != nil {
+			return err
 		}
-		close(jobs)
+		if interrupted {
+			log.Printf("Previous scan was interrupted after committing %d images. Resuming.\n", committedCount)
+		}
+		if rehash {
+			log.Println("Rehashing every image, including unchanged ones.")
+		}
+		filesToProcess, err := filesToScan(allImageFiles, rehash)
+		if err != nil {
+			return err
+		}
+		if skipped := len(allImageFiles) - len(filesToProcess); skipped > 0 {
+			log.Printf("Skipping %d images already in the catalog.\n", skipped)
+		}
+		if err := database.LoadThumbnails(server.AddThumbnailToMemory); err != nil {
+			log.Printf("Warning: Could not load stored thumbnails: %v\n", err)
+		}
+
+		log.Println("Starting image processing...")
+		startPhase(database.PhaseHash, len(filesToProcess))
+
+		bar := progressbar.Default(int64(len(filesToProcess)), "Processing images")
 
+		// Report current read throughput in the progress bar description.
+		stopThroughput := make(chan struct{})
 		go func() {
-			wg.Wait()
-			close(results)
-			close(errors)
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+			last := limiter.Total()
+			for {
+				select {
+				case <-ticker.C:
+					total := limiter.Total()
+					bar.Describe(fmt.Sprintf("Processing images (%.1f MB/s)", float64(total-last)/(1024*1024)))
+					last = total
+				case <-stopThroughput:
+					return
+				}
+			}
 		}()
 
+		numIOWorkers, numCPUWorkers := ioPoolSize(), cpuPoolSize()
+		log.Printf("Using %d IO workers (read and hash) and %d CPU workers (decode, pHash and thumbnail).\n", numIOWorkers, numCPUWorkers)
+
+		gate := worker.NewGate()
+		server.SetScanController(gate)
+
 		processedCount := 0
 		errorCount := 0
-		for {
-			select {
-			case res, ok := <-results:
-				if !ok {
-					results = nil
-					break
-				}
-				if res.ThumbnailData != nil {
-					server.AddThumbnailToMemory(res.ImageData.MD5, res.ThumbnailData)
-				}
-
-				err := database.InsertImage(res.ImageData)
-				if err != nil {
-					log.Printf("Error inserting image data for '%s': %v\n", res.ImageData.FilePath, err)
-					errorCount++
-					continue
-				}
-				processedCount++
-			case errVal, ok := <-errors:
-				if !ok {
-					errors = nil
-					break
-				}
-				log.Println(errVal)
+		var batch []database.ScanResult
+		commitBatch := func() {
+			if len(batch) == 0 {
+				return
+			}
+			if err := database.CommitScanBatch(batch); err != nil {
+				log.Printf("Error committing %d images: %v\n", len(batch), err)
+				errorCount += len(batch)
+			} else {
+				processedCount += len(batch)
+			}
+			batch = nil
+			progressPhase(database.PhaseHash, processedCount+errorCount)
+		}
+		for res := range runWorkerPools(ctx, filesToProcess, numIOWorkers, numCPUWorkers, gate.WaitWorker) {
+			bar.Add(1)
+			if res.Err != nil {
+				log.Printf("Error processing image '%s': %v\n", res.FilePath, res.Err)
 				errorCount++
+				continue
+			}
+			// Keep a RAW thumbnail an earlier run rendered over the placeholder
+			if res.ThumbnailData != nil && !(res.ImageData.RawPending && server.GetThumbnailFromMemory(res.ImageData.MD5) != nil) {
+				server.AddThumbnailToMemory(res.ImageData.MD5, res.ThumbnailData)
 			}
 
-			if results == nil && errors == nil {
-				break
+			batch = append(batch, database.ScanResult{ImageData: res.ImageData, ThumbnailData: res.ThumbnailData})
+			if len(batch) >= scanBatchSize {
+				commitBatch()
 			}
 		}
-
+		// Whatever finished is kept, even when the scan was interrupted
+		commitBatch()
+		close(stopThroughput)
+		server.SetScanController(nil)
+		if ctx.Err() != nil {
+			// The recovery marker stays "running", so the next scan resumes
+			bar.Exit()
+			progressPhase(database.PhaseHash, processedCount+errorCount)
+			finishPhase(database.PhaseHash, ctx.Err())
+			log.Printf("Scan interrupted after processing %d of %d files (%d errors). Run it again with --resume to continue.\n", processedCount, len(filesToProcess), errorCount)
+			return withExitCode(ExitInterrupted)
+		}
+		if err := database.FinishScanRecovery(); err != nil {
+			log.Printf("Warning: %v\n", err)
+		}
+		progressPhase(database.PhaseHash, processedCount+errorCount)
+		finishPhase(database.PhaseHash, nil)
 		log.Printf("Image processing complete. Successfully processed %d files, encountered %d errors.\n", processedCount, errorCount)
 
 		// Handle recycle path
 		if recyclePath == "" {
-			defaultRecyclePath := "Recycle"
-			log.Printf("Recycle directory not specified. Defaulting to: %s\n", defaultRecyclePath)
-			fmt.Print("Continue with this path? (y/N): ")
-			reader := bufio.NewReader(os.Stdin)
-			input, _ := reader.ReadString('\n')
-			input = strings.ToLower(strings.TrimSpace(input))
-			if input != "y" {
-				log.Println("Exiting.")
-				return nil
+			recyclePath = "Recycle"
+			log.Printf("Recycle directory not specified. Defaulting to: %s\n", recyclePath)
+		}
+		log.Printf("Recycling files to %s\n", fileops.RecycleDestination(recyclePath))
+
+		// Date images without EXIF or file name dates from their neighbours before anything is grouped or sorted by date
+		if estimateDates {
+			if err := runEstimateDates(); err != nil {
+				return fmt.Errorf("error estimating dates: %w", err)
 			}
-			recyclePath = defaultRecyclePath
 		}
-		log.Printf("Using Recycle directory: %s\n", recyclePath)
 
-		// Find duplicates
+		// Pick up messenger folders in images hashed before they were recognized
+		if changed, err := database.ClassifyMessengers(); err != nil {
+			log.Printf("Warning: Could not classify messenger folders: %v\n", err)
+		} else if changed > 0 {
+			log.Printf("Updated the messenger folder of %d images.\n", changed)
+		}
+
+		// Find duplicates, starting from a clean slate in case an earlier analysis was cut short
 		log.Println("Finding duplicates...")
-		if err := runFindDuplicates(autoRecycleDuplicates, recyclePath); err != nil {
+		startPhase(database.PhaseAnalyze, 0)
+		if err := database.ResetAnalysis(); err != nil {
+			return fmt.Errorf("error finding duplicates: %w", finishPhase(database.PhaseAnalyze, err))
+		}
+		if err := finishPhase(database.PhaseAnalyze, runFindDuplicates(ctx, autoRecycleDuplicates, autoRecycleSameDir, keepEdited, keepPolicy, recyclePath, recycleCap, verifySample, verifyBeforeRecycle, recycleArchive)); err != nil {
 			return fmt.Errorf("error finding duplicates: %w", err)
 		}
 		log.Println("Duplicate analysis complete.")
 
-		// Find similar images
+		// Find exposure brackets and panoramas first so their frames are not reported as similar images
 		log.Println("Finding similar images...")
-		if err := runFindSimilarImages(); err != nil {
+		startPhase(database.PhaseGroup, 0)
+		groupErr := runFindBracketSets()
+		if groupErr == nil {
+			groupErr = runFindPanoramaSequences()
+		}
+		if groupErr == nil {
+			groupErr = runFindSimilarImages(ctx, keepPolicy)
+		}
+		if err := finishPhase(database.PhaseGroup, groupErr); err != nil {
 			return fmt.Errorf("error finding similar images: %w", err)
 		}
 		log.Println("Similarity analysis complete.")
+		reportMessengerUsage()
+
+		// Extract searchable text from screenshots if requested
+		if ocrFlag {
+			if err := finishPhase(database.PhaseOCR, runOCRScreenshots(ocrLanguages)); err != nil {
+				return fmt.Errorf("error extracting text: %w", err)
+			}
+			log.Println("Text extraction complete.")
+		} else {
+			skipPhase(database.PhaseOCR)
+		}
+
+		// Match the pages of PDFs, e.g. albums of scanned photos, against the catalog
+		if pdfPreviews != "" {
+			if err := runPDFPreviews(ctx, pdfFiles, pdfPreviews == pdfPreviewsAll); err != nil {
+				return fmt.Errorf("error rendering PDF pages: %w", err)
+			}
+		}
 
 		// Sort images if flag is set
 		if sortImagesFlag {
 			log.Println("Sorting enabled. Starting image sorting...")
 			// Use the first provided path as the root for sorting if no destination path is given
-			sortRootPath := args[0]
-			if err := runSortImages(sortRootPath, sortDestinationPath); err != nil {
+			sortRootPath := ""
+			if len(args) > 0 {
+				sortRootPath = args[0]
+			}
+			startPhase(database.PhaseActions, 0)
+			if err := finishPhase(database.PhaseActions, runSortImages(sortRootPath, sortDestinationPath, renamePolicy)); err != nil {
 				return fmt.Errorf("error sorting images: %w", err)
 			}
 			log.Println("Image sorting complete.")
+		} else {
+			skipPhase(database.PhaseActions)
 		}
 
-		// Start server
-		log.Printf("Starting web server on port %d...\n", serverPort)
-		if err := server.StartServer(serverPort); err != nil {
-			return fmt.Errorf("failed to start server: %w", err)
+		// Watch free space on the scanned volumes if a threshold was given
+		if freeSpaceThreshold.Enabled() {
+			checker := &quota.Checker{Threshold: freeSpaceThreshold, Notifiers: []quota.Notifier{&quota.LogNotifier{}}}
+			if quotaWebhook != "" {
+				checker.Notifiers = append(checker.Notifiers, quota.NewWebhook(quotaWebhook))
+			}
+			if noServer {
+				if _, err := checker.Check(); err != nil {
+					log.Printf("Warning: quota check failed: %v\n", err)
+				}
+			} else {
+				checker.Notifiers = append(checker.Notifiers, server.QuotaBanner{})
+				log.Printf("Checking free space every %s (threshold %s).\n", quotaInterval, freeSpaceThreshold)
+				go checker.Run(quotaInterval, nil)
+			}
+		}
+
+		// RAW conversions come last so they never hold up the analysis
+		if noServer {
+			runRawConversions()
+		} else {
+			go runRawConversions()
 		}
 
-		// Keep the main goroutine alive if the server is running
-		log.Printf("Server started on port %d. Press Ctrl+C to stop.\n", serverPort)
-		select {}
+		// Report the outcome through the exit code when running unattended
+		if noServer {
+			if errorCount > 0 {
+				return withExitCode(ExitProcessingErrors)
+			}
+			db, err := database.GetDBInstance()
+			if err != nil {
+				return fmt.Errorf("failed to get database instance: %w", err)
+			}
+			var duplicateCount int
+			if err := db.QueryRow("SELECT COUNT(*) FROM images WHERE is_duplicate = TRUE AND is_recycled = FALSE").Scan(&duplicateCount); err != nil {
+				return fmt.Errorf("error counting duplicates: %w", err)
+			}
+			if duplicateCount > 0 {
+				return withExitCode(ExitDuplicatesFound)
+			}
+			return nil
+		}
+
+		// Keep the main goroutine alive while the server is running
+		log.Println("Server started. Press Ctrl+C to stop.")
+		if err := <-serverErr; err != nil {
+			return fmt.Errorf("failed to start server: %w", err)
+		}
 		return nil
 	},
 }
 
 var (
 	autoRecycleDuplicates bool
+	consentDataMove       bool
 	recyclePath           string
 	sortImagesFlag        bool
 	sortDestinationPath   string
+	sortRenamePolicy      string
 	serverPort            int
+	listenAddresses       []string
+	basePath              string
+	noServer              bool
+	browseWorkers         int
+	browseIdle            time.Duration
+	maxRecycle            string
+	niceMode              bool
+	niceReadLimitMB       float64
+	filesFrom             string
+	pathsFrom             string
+	workers               int
+	ioWorkers             int
+	cpuWorkers            int
+	ocrFlag               bool
+	ocrLanguages          string
+	pdfPreviews           string
+	estimateDates         bool
+	allowLaunch           bool
+	launchEditor          string
+	queryToken            string
+	apiVersion            int
+	minFreeSpace          string
+	quotaWebhook          string
+	quotaInterval         time.Duration
+	verifySample          int
+	verifyBeforeRecycle   bool
+	recycleArchive        string
+	autoRecycleSameDir    bool
+	keepEdited            bool
+	keepPolicyRules       string
+	keepPathPriority      string
+	rehash                bool
+	resumeScan            bool
+	detectContentType     bool
+	followSymlinks        bool
+	dedupeSymlinks        bool
+	minFileSize           string
+	minResolution         string
+
+	similarityProviderSpec string
+	similarityThreshold    int
+	confirmHashes          int
+	similarityProvider     similarity.Provider // nil groups by pHash
+	providerSignatures     map[string]string   // cached signatures by MD5, as JSON
 )
 
 func init() {
 	RootCmd.AddCommand(scanCmd)
 	scanCmd.Flags().BoolVar(&autoRecycleDuplicates, "auto-recycle-duplicates", false, "Automatically move all but one duplicate image to the recycle directory.")
+	scanCmd.Flags().StringVar(&keepPolicyRules, "keep-policy", "", "Rules, in order, that choose which copy of a duplicate is kept: raw (RAW before JPEG), resolution (most pixels), earliest (earliest EXIF date) and path (folders of --keep-path-priority first), e.g. raw,path. Ratings, favorites, albums and copy names decide what the rules leave open.")
+	scanCmd.Flags().StringVar(&keepPathPriority, "keep-path-priority", "", "Comma-separated folders whose copies are kept first, the first folder most preferred, e.g. /photos/masters,/photos/phone. Adds the path rule to --keep-policy.")
+	scanCmd.Flags().BoolVar(&keepEdited, "keep-edited", false, "Never auto-recycle images edited in apps such as Photoshop or Snapseed, as told by their EXIF Software tag, nor the originals they were made from, as grouped by the last scan. Catalogs hashed before edits were detected need --rehash.")
+	scanCmd.Flags().BoolVar(&autoRecycleSameDir, "auto-recycle-same-dir", false, "Automatically recycle only exact duplicates in the same folder as another copy, e.g. IMG_0001 (1).JPG next to IMG_0001.JPG. Works without --auto-recycle-duplicates.")
+	scanCmd.Flags().BoolVar(&consentDataMove, "i-understand-data-will-move", false, "Required together with --auto-recycle-duplicates, --auto-recycle-same-dir or an in-place --sort, which move files on disk.")
+	scanCmd.Flags().IntVar(&verifySample, "verify-sample", 20, "Number of randomly chosen duplicate pairs compared byte for byte after hashing. Any difference stops --auto-recycle-duplicates. 0 disables the check.")
+	scanCmd.Flags().StringVar(&recycleArchive, "archive-before-recycle", "", "Back up the duplicates to a ZIP archive at this path, one folder per group with a manifest of their original paths, before auto-recycle moves them. A folder gets a time-stamped archive. Nothing is moved if the archive cannot be written.")
+	scanCmd.Flags().BoolVar(&verifyBeforeRecycle, "verify-before-recycle", false, "Compare each duplicate byte for byte with the copy that is kept right before moving it, and keep it if they differ, e.g. because either file changed since the scan.")
 	scanCmd.Flags().StringVar(&recyclePath, "recycle-path", "", "Specify the path for the Recycle directory.")
+	scanCmd.Flags().StringVar(&maxRecycle, "max-recycle", "10%", "Maximum number of files (e.g. 500) or share of the library (e.g. 10%) a single automated action may recycle. 0 disables the cap.")
 	scanCmd.Flags().BoolVar(&sortImagesFlag, "sort", false, "Sort images into directories based on metadata.")
 	scanCmd.Flags().StringVar(&sortDestinationPath, "sort-destination", "", "Optionally provide a destination path to copy sorted images instead of moving them.")
+	scanCmd.Flags().StringVar(&sortRenamePolicy, "rename-policy", string(util.RenameDatePrefix), "File naming used when sorting: keep, date-prefix or hash.")
 	scanCmd.Flags().IntVarP(&serverPort, "port", "p", 3000, "Port to start the server on")
+	scanCmd.Flags().StringSliceVar(&listenAddresses, "listen", nil, listenUsage)
+	scanCmd.Flags().StringVar(&basePath, "base-path", "", basePathUsage)
+	scanCmd.Flags().BoolVar(&noServer, "no-server", false, "Exit after the scan instead of serving the web interface. The exit code reports the outcome: 0 nothing to report, 2 no images, 3 duplicates found, 4 some files failed, 5 recycle cap exceeded.")
+	scanCmd.Flags().IntVar(&browseWorkers, "browse-workers", 1, "Workers per pool the scan keeps while the web interface is in use, so browsing stays responsive. 0 keeps the scan at full speed.")
+	scanCmd.Flags().DurationVar(&browseIdle, "browse-idle", 10*time.Second, "How long after the last request of the web interface the scan returns to full speed.")
+	scanCmd.Flags().BoolVar(&niceMode, "nice", false, "Lower process priority and throttle disk reads for background scans.")
+	scanCmd.Flags().Float64Var(&niceReadLimitMB, "nice-read-limit", 20, "Maximum disk read rate in MB/s when --nice is set.")
+	scanCmd.Flags().StringVar(&summaryJSONPath, "summary-json", "", "Write the phase checkpoints and the counts after each phase as JSON to this file, or to stdout with -.")
+	scanCmd.Flags().BoolVar(&rehash, "rehash", false, "Hash and decode every image again. By default images whose path, size and modification time match the catalog are skipped.")
+	scanCmd.Flags().BoolVar(&resumeScan, "resume", false, "Continue an interrupted scan with the files it found instead of walking the paths again, which can take hours on network storage. Without paths the paths of the interrupted scan are used. Images it committed are skipped either way.")
+	scanCmd.Flags().StringVar(&pathsFrom, "paths-from", "", "Read newline- or NUL-delimited folders to scan from a file, or from stdin with -, in addition to the path arguments. Repeated folders and folders inside another one are walked once.")
+	scanCmd.Flags().BoolVar(&detectContentType, "detect-content-type", false, "Also catalog files whose content is a supported image or video format despite their extension, e.g. JPEGs saved as .dat. Every file with another extension is opened to check.")
+	scanCmd.Flags().BoolVar(&followSymlinks, "follow-symlinks", false, "Descend into symlinked folders. Links that lead back into a folder being walked are skipped.")
+	scanCmd.Flags().BoolVar(&dedupeSymlinks, "dedupe-symlinks", false, "Catalog a file reached through several symlinks once, under its real path, instead of as duplicates of itself.")
+	scanCmd.Flags().StringVar(&minFileSize, "min-file-size", "", "Leave out files smaller than this, e.g. 20KB, so icons and UI sprites are neither catalogued nor grouped. Images already in the catalog are kept.")
+	scanCmd.Flags().StringVar(&minResolution, "min-resolution", "", "Leave out JPEG, PNG, GIF and WebP images narrower or lower than this, e.g. 200x200, or 200 for both sides.")
+	scanCmd.Flags().StringVar(&filesFrom, "files-from", "", "Read newline- or NUL-delimited image paths from a file, or from stdin with -, instead of walking directories.")
+	scanCmd.Flags().IntVar(&workers, "workers", 0, "Size of both the IO and the CPU worker pool, unless --io-workers or --cpu-workers is given.")
+	scanCmd.Flags().IntVar(&ioWorkers, "io-workers", 0, fmt.Sprintf("Number of goroutines reading and hashing files. Use 1 or 2 for spinning disks and more for NVMe drives. 0 uses %d.", defaultIOWorkers))
+	scanCmd.Flags().IntVar(&cpuWorkers, "cpu-workers", 0, "Number of goroutines decoding images, computing perceptual hashes and encoding thumbnails. 0 uses the number of CPUs.")
+	// Names of the pools before decoding moved to the CPU pool
+	scanCmd.Flags().IntVar(&ioWorkers, "hash-workers", 0, "Alias for --io-workers.")
+	scanCmd.Flags().IntVar(&cpuWorkers, "thumbnail-workers", 0, "Alias for --cpu-workers.")
+	scanCmd.Flags().MarkHidden("hash-workers")
+	scanCmd.Flags().MarkHidden("thumbnail-workers")
+	scanCmd.Flags().StringVar(&similarityProviderSpec, "similarity-provider", "", "Group similar images by the signatures of an external worker instead of pHash: a command that reads {\"path\": ...} lines on stdin and answers {\"hash\": hex} or {\"vector\": [...]} lines on stdout, or the URL of a service answering the same JSON over POST.")
+	scanCmd.Flags().IntVar(&confirmHashes, "confirm-hashes", 0, "How many of the dHash, aHash and wavelet hash of two images must also agree before a pHash match makes them similar, e.g. 2 to require two of the three. Cuts false matches between textured pictures such as foliage. 0 leaves the pHash alone to decide.")
+	scanCmd.Flags().IntVar(&similarityThreshold, "similarity-threshold", 0, "Largest distance between similar images with --similarity-provider: differing bits for hashes, cosine distance in thousandths for vectors (e.g. 150 for 0.15).")
+	scanCmd.Flags().BoolVar(&ocrFlag, "ocr", false, "Extract text from screenshots with tesseract so they can be searched.")
+	scanCmd.Flags().StringVar(&ocrLanguages, "ocr-lang", "eng", "Tesseract languages used by --ocr, e.g. eng+chi_sim.")
+	scanCmd.Flags().StringVar(&pdfPreviews, "pdf-previews", "", "Render the first page, or with all every page, of the PDFs found in the scanned folders or listed by --files-from with pdftoppm and list the pages that show catalogued images, e.g. scanned photos kept in a PDF album. The PDFs are not catalogued.")
+	scanCmd.Flags().BoolVar(&estimateDates, "estimate-dates", false, "Give images without EXIF or file name dates the date of neighbouring files in the same folder, marked as estimated.")
+	scanCmd.Flags().BoolVar(&allowLaunch, "allow-launch", false, allowLaunchUsage)
+	scanCmd.Flags().StringVar(&minFreeSpace, "min-free-space", "", "Alert when a volume holding scanned images has less free space than this, e.g. 20GB or 5%.")
+	scanCmd.Flags().StringVar(&quotaWebhook, "quota-webhook", "", "URL that receives a JSON POST when a volume drops below --min-free-space.")
+	scanCmd.Flags().DurationVar(&quotaInterval, "quota-interval", 10*time.Minute, "How often free space is checked while the server runs.")
+	scanCmd.Flags().IntVar(&apiVersion, "api-version", server.APIVersion1, "Field naming of the JSON API for clients that do not send the X-PicPurge-API-Version header: 1 keeps the original mixed naming, 2 uses snake_case throughout. The web interface asks for 1 either way.")
+	scanCmd.Flags().StringVar(&queryToken, "query-token", "", queryTokenUsage)
+	scanCmd.Flags().StringVar(&launchEditor, "editor", "", "Editor command used by the web interface's Edit button, e.g. gimp. The file path is appended as the last argument.")
 }
 
-func runFindDuplicates(autoRecycleDuplicates bool, recyclePath string) error {
+// runFindDuplicates marks every file with the same MD5 as the copy keepPolicy
+// ranks first, or else the best curated one, as its duplicate. With autoRecycleDuplicates all duplicates are
+// recycled, with sameDirOnly only those in the same folder as a kept copy, such
+// as IMG_0001 (1).JPG next to IMG_0001.JPG. With verifyBeforeRecycle each
+// duplicate is compared with its original right before it is moved. With
+// keepEdited copies of edited images and of their originals are not recycled.
+// With an archivePath the duplicates are first backed up to a ZIP archive there.
+func runFindDuplicates(ctx context.Context, autoRecycleDuplicates, sameDirOnly, keepEdited bool, keepPolicy grouping.KeepPolicy, recyclePath string, recycleCap util.RecycleCap, verifySample int, verifyBeforeRecycle bool, archivePath string) error {
 	log.Println("Finding duplicate images...")
+	if !keepPolicy.Empty() {
+		log.Printf("Choosing the copy to keep by the rules %s.\n", keepPolicy)
+	}
 
 	db, err := database.GetDBInstance()
 	if err != nil {
 		return fmt.Errorf("failed to get database instance: %w", err)
 	}
 
-	rows, err := db.Query("SELECT md5 FROM images GROUP BY md5 HAVING COUNT(*) > 1")
+	// Recycled rows stay in the catalog between runs, but are no copies to keep or recycle
+	rows, err := db.Query("SELECT md5 FROM images WHERE is_recycled = FALSE GROUP BY md5 HAVING COUNT(*) > 1")
 	if err != nil {
 		return fmt.Errorf("error querying for duplicate MD5s: %w", err)
 	}
@@ -260,33 +602,35 @@ func runFindDuplicates(autoRecycleDuplicates bool, recyclePath string) error {
 
 	duplicatePairsCount := 0
 	recycledCount := 0
+	var pairs []duplicatePair
 
 	for _, md5 := range duplicateMD5s {
-		imageRows, err := db.Query("SELECT id, file_path FROM images WHERE md5 = ? ORDER BY id ASC", md5)
+		imageRows, err := db.Query("SELECT id, file_path, image_width, image_height, COALESCE(file_size, 0), create_date, COALESCE(date_source, ''), COALESCE(format, '') FROM images WHERE md5 = ? AND is_recycled = FALSE ORDER BY "+database.CurationOrder, md5)
 		if err != nil {
 			log.Printf("Error querying images for MD5 %s: %v\n", md5, err)
 			continue
 		}
 		defer imageRows.Close()
 
-		var imagesWithSameMd5 []struct {
-			ID       int
-			FilePath string
-		}
+		var imagesWithSameMd5 []grouping.KeepCandidate
 		for imageRows.Next() {
-			var img struct {
-				ID       int
-				FilePath string
-			}
-			if err := imageRows.Scan(&img.ID, &img.FilePath); err != nil {
+			var id, width, height int
+			var fileSize int64
+			var filePath, createDate, dateSource, format string
+			if err := imageRows.Scan(&id, &filePath, &width, &height, &fileSize, &createDate, &dateSource, &format); err != nil {
 				log.Printf("Error scanning image for MD5 %s: %v\n", md5, err)
 				continue
 			}
-			imagesWithSameMd5 = append(imagesWithSameMd5, img)
+			imagesWithSameMd5 = append(imagesWithSameMd5, keepCandidate(id, filePath, width, height, fileSize, createDate, dateSource, format))
 		}
+		// The keep policy overrides the curation order, which breaks its ties
+		keepPolicy.Sort(imagesWithSameMd5)
 
 		if len(imagesWithSameMd5) > 1 {
 			masterImageID := imagesWithSameMd5[0].ID
+			if _, err := db.Exec("UPDATE images SET is_duplicate = FALSE, duplicate_of = NULL WHERE id = ?", masterImageID); err != nil {
+				log.Printf("Error updating duplicate status for image ID %d: %v\n", masterImageID, err)
+			}
 			for i := 1; i < len(imagesWithSameMd5); i++ {
 
 				duplicateImage := imagesWithSameMd5[i]
@@ -297,45 +641,173 @@ func runFindDuplicates(autoRecycleDuplicates bool, recyclePath string) error {
 				}
 
 				duplicatePairsCount++
+				pairs = append(pairs, duplicatePair{
+					md5:        md5,
+					masterPath: imagesWithSameMd5[0].Path,
+					id:         duplicateImage.ID,
+					path:       duplicateImage.Path,
+				})
+			}
+		}
+	}
 
-				if autoRecycleDuplicates {
-					fileName := filepath.Base(duplicateImage.FilePath)
-					destPath := filepath.Join(recyclePath, fileName)
+	log.Printf("Found and marked %d duplicate image pairs.\n", duplicatePairsCount)
 
-					if err := os.MkdirAll(recyclePath, 0755); err != nil {
-						log.Printf("Error creating recycle directory %s: %v\n", recyclePath, err)
-						continue
-					}
+	// Spot-check the hashes before anything is moved on their word
+	mismatches := verifyDuplicateSample(pairs, verifySample)
+	if len(mismatches) > 0 {
+		for _, pair := range mismatches {
+			if _, err := db.Exec("UPDATE images SET is_duplicate = FALSE, duplicate_of = NULL WHERE id = ?", pair.id); err != nil {
+				log.Printf("Error clearing duplicate status for image ID %d: %v\n", pair.id, err)
+			}
+		}
+		if autoRecycleDuplicates || sameDirOnly {
+			log.Println("ERROR: Auto-recycle aborted because sampled duplicates differ byte for byte. No files were moved.")
+			return fmt.Errorf("%d of the sampled duplicate pairs are not identical", len(mismatches))
+		}
+	}
+	if !autoRecycleDuplicates && !sameDirOnly {
+		return nil
+	}
+	// Edited versions and their originals are left to the reviewer
+	var keep map[int]bool
+	if keepEdited {
+		if keep, err = database.EditedFamilies(); err != nil {
+			return err
+		}
+	}
+	// In same-folder mode each folder keeps its best copy, so copies spread over
+	// several folders are left for review
+	var toRecycle []duplicatePair
+	keptEdited := 0
+	keptDirs := make(map[string]map[string]bool) // folders holding a kept copy, by original
+	for _, pair := range pairs {
+		if keep[pair.id] {
+			keptEdited++
+			continue
+		}
+		dirs := keptDirs[pair.masterPath]
+		if dirs == nil {
+			dirs = map[string]bool{filepath.Dir(pair.masterPath): true}
+			keptDirs[pair.masterPath] = dirs
+		}
+		dir := filepath.Dir(pair.path)
+		if autoRecycleDuplicates || dirs[dir] {
+			toRecycle = append(toRecycle, pair)
+		}
+		dirs[dir] = true
+	}
+	if keptEdited > 0 {
+		log.Printf("Keeping %d duplicates of edited images or their originals (--keep-edited).\n", keptEdited)
+	}
+	if len(toRecycle) == 0 {
+		return nil
+	}
 
-					if err := os.Rename(duplicateImage.FilePath, destPath); err != nil {
-						if copyErr := util.CopyFile(duplicateImage.FilePath, destPath); copyErr != nil {
-							log.Printf("Error moving/copying file to recycle bin %s: %v\n", duplicateImage.FilePath, copyErr)
-							continue
-						}
-						if removeErr := os.Remove(duplicateImage.FilePath); removeErr != nil {
-							log.Printf("Warning: Copied %s to %s, but failed to remove original: %v\n", duplicateImage.FilePath, destPath, removeErr)
-						}
-					}
+	// Refuse to run if a bad threshold would move a large part of the library.
+	var librarySize int
+	if err := db.QueryRow("SELECT COUNT(*) FROM images WHERE is_recycled = FALSE").Scan(&librarySize); err != nil {
+		return fmt.Errorf("error counting images: %w", err)
+	}
+	if limit := recycleCap.Limit(librarySize); limit >= 0 && len(toRecycle) > limit {
+		log.Printf("Auto-recycle aborted: %d files would be recycled, cap is %s (%d of %d images).\n", len(toRecycle), recycleCap, limit, librarySize)
+		for i, pair := range toRecycle {
+			if i == 10 {
+				log.Printf("  ... and %d more\n", len(toRecycle)-i)
+				break
+			}
+			log.Printf("  %s\n", pair.path)
+		}
+		return fmt.Errorf("%w: %d files selected, at most %d allowed", util.ErrRecycleCapExceeded, len(toRecycle), limit)
+	}
 
-					_, err := db.Exec("UPDATE images SET is_recycled = TRUE WHERE file_path = ?", duplicateImage.FilePath)
-					if err != nil {
-						log.Printf("Error updating database for recycled image %s: %v\n", duplicateImage.FilePath, err)
-						continue
-					}
-					recycledCount++
+	log.Printf("Pre-action summary: %d duplicate files (of %d images) will be moved to %s\n", len(toRecycle), librarySize, fileops.RecycleDestination(recyclePath))
+	if archivePath != "" {
+		files := make([]export.ArchivedFile, len(toRecycle))
+		for i, pair := range toRecycle {
+			files[i] = export.ArchivedFile{GroupType: database.GroupTypeDuplicates, Group: pair.md5, ID: pair.id, Path: pair.path}
+		}
+		if err := archiveBeforeRecycle(archivePath, files); err != nil {
+			log.Println("ERROR: Auto-recycle aborted because the archive could not be written. No files were moved.")
+			return err
+		}
+	}
+
+	skippedCount := 0
+	moves := newOperationLog(database.OperationAutoRecycle)
+	defer moves.report()
+	for _, pair := range toRecycle {
+		if err := ctx.Err(); err != nil {
+			log.Printf("Recycling interrupted after moving %d of %d duplicates.\n", recycledCount, len(toRecycle))
+			return err
+		}
+		filePath := pair.path
+		// Either file may have changed since it was hashed
+		if verifyBeforeRecycle {
+			equal, err := util.FilesEqual(pair.masterPath, filePath)
+			if err != nil {
+				log.Printf("Warning: Not recycling %s, which could not be compared with %s: %v\n", filePath, pair.masterPath, err)
+				skippedCount++
+				continue
+			}
+			if !equal {
+				log.Printf("ERROR: Not recycling %s, which differs byte for byte from %s; it is no longer marked as a duplicate.\n", filePath, pair.masterPath)
+				if _, err := db.Exec("UPDATE images SET is_duplicate = FALSE, duplicate_of = NULL WHERE id = ?", pair.id); err != nil {
+					log.Printf("Error clearing duplicate status for image ID %d: %v\n", pair.id, err)
 				}
+				skippedCount++
+				continue
 			}
 		}
+		recycledPath, err := fileops.Recycle(filePath, recyclePath)
+		if err != nil {
+			log.Printf("Error moving file to recycle bin %s: %v\n", filePath, err)
+			continue
+		}
+		if err := database.MarkRecycled(filePath); err != nil {
+			log.Printf("Error updating database for recycled image %s: %v\n", filePath, err)
+			unrecycle(filePath, recycledPath)
+			continue
+		}
+		moves.record(filePath, recycledPath)
+		recycledCount++
 	}
-
-	log.Printf("Found and marked %d duplicate image pairs.\n", duplicatePairsCount)
-	if autoRecycleDuplicates {
-		log.Printf("Automatically recycled %d duplicate images.\n", recycledCount)
+	log.Printf("Automatically recycled %d duplicate images.\n", recycledCount)
+	if skippedCount > 0 {
+		log.Printf("Kept %d duplicates that failed the byte comparison before recycling.\n", skippedCount)
 	}
 	return nil
 }
 
-func runFindSimilarImages() error {
+// reportMessengerUsage logs how much space WhatsApp, Telegram and Signal folders
+// take up and how much removing their copies of pictures kept elsewhere frees.
+func reportMessengerUsage() {
+	usage, err := database.GetMessengerUsage()
+	if err != nil {
+		log.Printf("Warning: Could not measure messenger folders: %v\n", err)
+		return
+	}
+	for _, u := range usage {
+		log.Printf("%s: %d files (%s), %d of them (%s) are copies of pictures kept elsewhere and could be freed.\n",
+			u.Messenger, u.Files, util.FormatBytes(uint64(u.Bytes)), u.RedundantFiles, util.FormatBytes(uint64(u.RedundantBytes)))
+	}
+}
+
+// keepCandidate describes an image for a keep policy.
+func keepCandidate(id int, filePath string, width, height int, fileSize int64, createDate, dateSource, format string) grouping.KeepCandidate {
+	candidate := grouping.KeepCandidate{ID: id, Path: filePath, Width: width, Height: height, Size: fileSize}
+	// Only the camera's own record counts as the date taken
+	if dateSource == processor.DateSourceEXIF {
+		candidate.Date, _ = time.Parse(time.RFC3339, createDate)
+	}
+	candidate.Raw = walker.IsRaw(filePath, format)
+	return candidate
+}
+
+// runFindSimilarImages groups images by perceptual hash. Of two similar files
+// of one exposure, keepPolicy tells which is the original the other is a copy
+// of; re-shoots of the same camera are left as plain similar images.
+func runFindSimilarImages(ctx context.Context, keepPolicy grouping.KeepPolicy) error {
 	log.Println("Finding similar images...")
 
 	db, err := database.GetDBInstance()
@@ -343,129 +815,423 @@ func runFindSimilarImages() error {
 		return fmt.Errorf("failed to get database instance: %w", err)
 	}
 
-	// Fetch all images with pHash values
-	rows, err := db.Query("SELECT id, phash, image_width, image_height FROM images WHERE phash IS NOT NULL AND phash != '' AND is_recycled = FALSE")
+	// Fetch all images with pHash values, or all images when a provider signs them
+	query := "SELECT id, md5, file_path, COALESCE(phash, ''), COALESCE(turned_phashes, ''), COALESCE(dhash, ''), COALESCE(ahash, ''), COALESCE(whash, ''), image_width, image_height, file_size, camera_serial, shutter_count, COALESCE(bracket_set, 0), COALESCE(panorama_set, 0), COALESCE(is_document, FALSE), create_date, COALESCE(date_source, ''), COALESCE(format, '') FROM images WHERE is_recycled = FALSE"
+	if similarityProvider == nil {
+		query += " AND phash IS NOT NULL AND phash != ''"
+	}
+	rows, err := db.Query(query + " ORDER BY id")
 	if err != nil {
 		return fmt.Errorf("error querying images for similar detection: %w", err)
 	}
 	defer rows.Close()
 
 	type ImageForSimilar struct {
-		ID          int
-		PHash       *goimagehash.ImageHash
-		ImageWidth  int
-		ImageHeight int
+		ID           int
+		MD5          string
+		FilePath     string
+		PHashes      processor.PHashes
+		Confirming   processor.ConfirmingHashes
+		Signature    similarity.Signature // set instead of PHash when a provider is used
+		ImageWidth   int
+		ImageHeight  int
+		FileSize     int64
+		CameraSerial string
+		ShutterCount int64
+		BracketSet   int
+		PanoramaSet  int
+		IsDocument   bool
+		Keep         grouping.KeepCandidate
 	}
 
 	var images []ImageForSimilar
 	for rows.Next() {
 		var id int
-		var phashStr string
+		var md5, filePath, phashStr, turnedStr, dhashStr, ahashStr, whashStr string
 		var width, height int
-		if err := rows.Scan(&id, &phashStr, &width, &height); err != nil {
+		var fileSize int64
+		var serial string
+		var shutterCount int64
+		var bracketSet, panoramaSet int
+		var isDocument bool
+		var createDate sql.NullString
+		var dateSource, format string
+		if err := rows.Scan(&id, &md5, &filePath, &phashStr, &turnedStr, &dhashStr, &ahashStr, &whashStr, &width, &height, &fileSize, &serial, &shutterCount, &bracketSet, &panoramaSet, &isDocument, &createDate, &dateSource, &format); err != nil {
 			log.Printf("Error scanning image for similar detection: %v\n", err)
 			continue
 		}
-		phash, err := goimagehash.ImageHashFromString(phashStr)
-		if err != nil {
-			log.Printf("Warning: Could not parse pHash string '%s' for image ID %d: %v\n", phashStr, id, err)
-			continue
+		image := ImageForSimilar{
+			ID: id, MD5: md5, FilePath: filePath, ImageWidth: width, ImageHeight: height,
+			FileSize: fileSize, CameraSerial: serial, ShutterCount: shutterCount,
+			BracketSet: bracketSet, PanoramaSet: panoramaSet, IsDocument: isDocument,
+			Keep: keepCandidate(id, filePath, width, height, fileSize, createDate.String, dateSource, format),
+		}
+		if similarityProvider == nil {
+			phashes, err := processor.ParsePHashes(phashStr, turnedStr)
+			if err != nil {
+				log.Printf("Warning: Could not parse pHash string '%s' for image ID %d: %v\n", phashStr, id, err)
+				continue
+			}
+			image.PHashes = phashes
+			image.Confirming = processor.ParseConfirmingHashes(dhashStr, ahashStr, whashStr)
 		}
-		images = append(images, ImageForSimilar{ID: id, PHash: phash, ImageWidth: width, ImageHeight: height})
+		images = append(images, image)
 	}
 
-	phashThreshold := 3         // Hamming distance threshold for pHash similarity
-	sizeThreshold := 0.2        // 20% tolerance for size difference (ratio of areas)
-	aspectRatioTolerance := 0.1 // 10% tolerance for aspect ratio
-
-	similarPairsCount := 0
-
-	for i := 0; i < len(images); i++ {
-		image1 := images[i]
-		if image1.PHash == nil {
-			continue
+	threshold := processor.PHashThreshold
+	// Different documents share a page layout, so they must be closer than photos
+	pairThreshold := processor.PairThreshold
+	unconfirmed, documentsApart := 0, 0
+	// A copy turned by 90 degrees is compared in the orientations it allows
+	distance := func(image1, image2 ImageForSimilar, upright, sideways bool) (int, error) {
+		d, turned := image1.PHashes.Distance(image2.PHashes, upright, sideways)
+		// The other hashes are only taken upright, so they can only confirm upright matches
+		if d <= threshold && !turned && !image1.Confirming.Confirm(image2.Confirming) {
+			unconfirmed++
+			return math.MaxInt, nil
+		}
+		return d, nil
+	}
+	if similarityProvider != nil {
+		threshold = similarityThreshold
+		pairThreshold = func(bool, bool) int { return threshold } // the provider's distances have a scale of their own
+		distance = func(image1, image2 ImageForSimilar, upright, sideways bool) (int, error) {
+			if !upright {
+				return math.MaxInt, nil // Signatures are only taken upright
+			}
+			return similarity.Distance(image1.Signature, image2.Signature)
+		}
+		signed := images[:0]
+		for _, image := range images {
+			signature, err := providerSignature(image.MD5, image.FilePath)
+			if err != nil {
+				log.Printf("Warning: %s could not sign %s: %v\n", similarityProvider.Name(), image.FilePath, err)
+				continue
+			}
+			image.Signature = signature
+			signed = append(signed, image)
 		}
-		similar := []int{}
-		aspectRatio1 := float64(image1.ImageWidth) / float64(image1.ImageHeight)
+		log.Printf("Comparing %d images by the signatures of %s.\n", len(signed), similarityProvider.Name())
+		images = signed
+	}
 
+	// pHashes are looked up in the index; a provider's signatures are compared pairwise
+	later := func(i int) []int {
+		others := make([]int, 0, len(images)-i-1)
 		for j := i + 1; j < len(images); j++ {
-			image2 := images[j]
-			if image2.PHash == nil {
-				continue
+			others = append(others, j)
+		}
+		return others
+	}
+	if similarityProvider == nil {
+		index, err := database.PHashIndex()
+		if err != nil {
+			return fmt.Errorf("error loading the pHash index: %w", err)
+		}
+		positions := make(map[int]int, len(images))
+		for i, image := range images {
+			positions[image.ID] = i
+		}
+		later = func(i int) []int {
+			var others []int
+			for _, id := range index.Candidates(images[i].PHashes, threshold) {
+				if j, ok := positions[id]; ok && j > i {
+					others = append(others, j)
+				}
 			}
+			return others
+		}
+	}
 
-			aspectRatio2 := float64(image2.ImageWidth) / float64(image2.ImageHeight)
+	var pairs []grouping.Pair
+	sameShotCount, reshootCount := 0, 0
 
-			// Pre-filter: Check aspect ratio similarity first
-			if aspectRatio1 == 0 || aspectRatio2 == 0 ||
-				(aspectRatio1 > 0 && aspectRatio2 > 0 &&
-					(math.Abs(aspectRatio1-aspectRatio2)/math.Max(aspectRatio1, aspectRatio2) > aspectRatioTolerance)) {
-				continue // Aspect ratios are too different, skip pHash comparison
-			}
+	for i := 0; i < len(images); i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		image1 := images[i]
+		for _, j := range later(i) {
+			image2 := images[j]
 
-			// Pre-filter: Check size similarity (ratio of areas)
-			area1 := float64(image1.ImageWidth * image1.ImageHeight)
-			area2 := float64(image2.ImageWidth * image2.ImageHeight)
-			sizeRatio := math.Min(area1, area2) / math.Max(area1, area2)
-			sizeDifference := 1 - sizeRatio
+			// Frames of one exposure bracket or panorama are kept together for merging, not offered as similar images
+			if (image1.BracketSet != 0 && image1.BracketSet == image2.BracketSet) ||
+				(image1.PanoramaSet != 0 && image1.PanoramaSet == image2.PanoramaSet) {
+				continue
+			}
 
-			if sizeDifference > sizeThreshold {
-				continue // Sizes are too different, skip pHash comparison
+			// Pre-filter: Check size and aspect ratio similarity, also with image2 turned sideways
+			upright, sideways := processor.Orientations(image1.ImageWidth, image1.ImageHeight, image2.ImageWidth, image2.ImageHeight)
+			if !upright && !sideways {
+				continue // Sizes or aspect ratios are too different, skip the comparison
 			}
 
-			// Calculate pHash distance only if pre-filters pass
-			distance, err := image1.PHash.Distance(image2.PHash)
+			// Calculate the distance only if pre-filters pass
+			d, err := distance(image1, image2, upright, sideways)
 			if err != nil {
-				log.Printf("Warning: Error calculating pHash distance between ID %d and ID %d: %v\n", image1.ID, image2.ID, err)
+				log.Printf("Warning: Error calculating distance between ID %d and ID %d: %v\n", image1.ID, image2.ID, err)
 				continue
 			}
 
-			if distance <= phashThreshold {
-				similar = append(similar, image2.ID)
-				similarPairsCount++
+			if d <= threshold && d > pairThreshold(image1.IsDocument, image2.IsDocument) {
+				documentsApart++
+				continue
+			}
+			if d <= threshold {
+				pairs = append(pairs, grouping.Pair{A: image1.ID, B: image2.ID, Distance: d})
+
+				// Same camera and same shutter count means one file was derived from the other
+				switch processor.CompareShots(image1.CameraSerial, image1.ShutterCount, image2.CameraSerial, image2.ShutterCount) {
+				case processor.ShotSame:
+					original, copied := keepPolicy.ShotOriginal(image1.Keep, image2.Keep)
+					if _, err := db.Exec("UPDATE images SET same_shot_of = ? WHERE id = ? AND same_shot_of IS NULL", original.ID, copied.ID); err != nil {
+						log.Printf("Error updating same_shot_of for image ID %d: %v\n", copied.ID, err)
+					}
+					sameShotCount++
+				case processor.ShotReshoot:
+					reshootCount++
+				}
 			}
 		}
-		if len(similar) > 0 {
-			// Update database: mark similar images
-			similarJSON, err := json.Marshal(similar)
-			if err != nil {
-				log.Printf("Error marshalling similar images for ID %d: %v\n", image1.ID, err)
+	}
+
+	// Larger groups must be tighter, so bursts do not chain unrelated images together.
+	// Every member stores the whole group, which is how the web interface groups them.
+	groups := grouping.ClusterSimilar(pairs, threshold)
+	// Groups merged or split by hand stay that way
+	if corrections, err := database.SimilarCorrections(); err != nil {
+		log.Printf("Warning: Could not load similar group corrections: %v\n", err)
+	} else if len(corrections) > 0 {
+		groups = grouping.ApplyCorrections(groups, corrections)
+		log.Printf("Applied %d manual similar group corrections.\n", len(corrections))
+	}
+	if err := database.StoreSimilarGroups(groups, pairs); err != nil {
+		return fmt.Errorf("error storing similar groups: %w", err)
+	}
+
+	log.Printf("Found %d similar image pairs in %d groups (%d copies of the same shot, %d re-shoots).\n", len(pairs), len(groups), sameShotCount, reshootCount)
+	if unconfirmed > 0 {
+		log.Printf("Left out %d pHash matches that too few other hashes confirmed.\n", unconfirmed)
+	}
+	if documentsApart > 0 {
+		log.Printf("Left out %d pHash matches of documents that were not close enough to be the same page.\n", documentsApart)
+	}
+	return nil
+}
+
+// providerSignature returns the signature of an image from the similarity
+// provider, reusing the one stored for its content by an earlier run.
+func providerSignature(md5, filePath string) (similarity.Signature, error) {
+	var signature similarity.Signature
+	if cached, ok := providerSignatures[md5]; ok {
+		if err := json.Unmarshal([]byte(cached), &signature); err == nil {
+			return signature, nil
+		}
+	}
+	signature, err := similarityProvider.Signature(filePath)
+	if err != nil {
+		return signature, err
+	}
+	data, err := json.Marshal(signature)
+	if err != nil {
+		return signature, err
+	}
+	providerSignatures[md5] = string(data)
+	if err := database.SetSignature(md5, similarityProvider.Name(), string(data)); err != nil {
+		log.Printf("Warning: %v\n", err)
+	}
+	return signature, nil
+}
+
+// setMinimumSize applies --min-file-size and --min-resolution to the walker.
+func setMinimumSize() error {
+	var m walker.MinimumSize
+	var err error
+	if minFileSize != "" {
+		if m.Bytes, err = util.ParseByteSize(minFileSize); err != nil {
+			return fmt.Errorf("invalid --min-file-size: %w", err)
+		}
+	}
+	if m.Width, m.Height, err = util.ParseResolution(minResolution); err != nil {
+		return fmt.Errorf("invalid --min-resolution: %w", err)
+	}
+	walker.SetMinimumSize(m)
+	return nil
+}
+
+// startSimilarityProvider starts the worker given by --similarity-provider, if
+// any, and loads the signatures it computed in earlier runs. The returned
+// function stops it.
+func startSimilarityProvider() (func(), error) {
+	if similarityProviderSpec == "" {
+		return func() {}, nil
+	}
+	if similarityThreshold <= 0 {
+		return nil, fmt.Errorf("--similarity-provider needs --similarity-threshold")
+	}
+	provider, err := similarity.New(similarityProviderSpec)
+	if err != nil {
+		return nil, err
+	}
+	signatures, err := database.Signatures(provider.Name())
+	if err != nil {
+		provider.Close()
+		return nil, err
+	}
+	similarityProvider, providerSignatures = provider, signatures
+	log.Printf("Grouping similar images with %s instead of pHash.\n", provider.Name())
+	return func() {
+		if err := provider.Close(); err != nil {
+			log.Printf("Warning: %v\n", err)
+		}
+		similarityProvider, providerSignatures = nil, nil
+	}, nil
+}
+
+func runFindBracketSets() error {
+	log.Println("Finding exposure bracket sets...")
+
+	db, err := database.GetDBInstance()
+	if err != nil {
+		return fmt.Errorf("failed to get database instance: %w", err)
+	}
+
+	rows, err := db.Query("SELECT id, device_make, device_model, camera_serial, create_date, exposure_bias, image_width, image_height FROM images WHERE exposure_bias IS NOT NULL AND is_recycled = FALSE")
+	if err != nil {
+		return fmt.Errorf("error querying images for bracket detection: %w", err)
+	}
+	defer rows.Close()
+
+	var shots []grouping.Shot
+	for rows.Next() {
+		var shot grouping.Shot
+		var deviceMake, deviceModel, serial, createDateStr string
+		var bias float64
+		if err := rows.Scan(&shot.ID, &deviceMake, &deviceModel, &serial, &createDateStr, &bias, &shot.Width, &shot.Height); err != nil {
+			log.Printf("Error scanning image for bracket detection: %v\n", err)
+			continue
+		}
+		createDate, err := time.Parse(time.RFC3339, createDateStr)
+		if err != nil {
+			continue // Without a capture time the frame cannot be placed in a sequence
+		}
+		if deviceMake != "" || deviceModel != "" {
+			shot.Camera = strings.Join([]string{deviceMake, deviceModel, serial}, "|")
+		}
+		shot.CreateDate = createDate
+		shot.ExposureBias = &bias
+		shots = append(shots, shot)
+	}
+
+	sets := grouping.FindBracketSets(shots)
+	bracketedCount := 0
+	for _, set := range sets {
+		for _, id := range set {
+			if _, err := db.Exec("UPDATE images SET bracket_set = ? WHERE id = ?", set[0], id); err != nil {
+				log.Printf("Error updating bracket_set for image ID %d: %v\n", id, err)
 				continue
 			}
-			_, err = db.Exec("UPDATE images SET similar_images = ? WHERE id = ?", string(similarJSON), image1.ID)
-			if err != nil {
-				log.Printf("Error updating similar_images for image ID %d: %v\n", image1.ID, err)
+			bracketedCount++
+		}
+	}
+
+	log.Printf("Found %d exposure bracket sets (%d frames).\n", len(sets), bracketedCount)
+	return nil
+}
+
+func runFindPanoramaSequences() error {
+	log.Println("Finding panorama sequences...")
+
+	db, err := database.GetDBInstance()
+	if err != nil {
+		return fmt.Errorf("failed to get database instance: %w", err)
+	}
+
+	// Bracketed frames repeat the same view and are never part of a sweep
+	rows, err := db.Query("SELECT id, device_make, device_model, camera_serial, create_date, left_edge_hash, right_edge_hash, image_width, image_height FROM images WHERE left_edge_hash != '' AND right_edge_hash != '' AND bracket_set IS NULL AND is_recycled = FALSE")
+	if err != nil {
+		return fmt.Errorf("error querying images for panorama detection: %w", err)
+	}
+	defer rows.Close()
+
+	var shots []grouping.Shot
+	for rows.Next() {
+		var shot grouping.Shot
+		var deviceMake, deviceModel, serial, createDateStr, leftStr, rightStr string
+		if err := rows.Scan(&shot.ID, &deviceMake, &deviceModel, &serial, &createDateStr, &leftStr, &rightStr, &shot.Width, &shot.Height); err != nil {
+			log.Printf("Error scanning image for panorama detection: %v\n", err)
+			continue
+		}
+		createDate, err := time.Parse(time.RFC3339, createDateStr)
+		if err != nil {
+			continue // Without a capture time the frame cannot be placed in a sequence
+		}
+		leftHash, err := goimagehash.ImageHashFromString(leftStr)
+		if err != nil {
+			log.Printf("Warning: Could not parse left edge hash '%s' for image ID %d: %v\n", leftStr, shot.ID, err)
+			continue
+		}
+		rightHash, err := goimagehash.ImageHashFromString(rightStr)
+		if err != nil {
+			log.Printf("Warning: Could not parse right edge hash '%s' for image ID %d: %v\n", rightStr, shot.ID, err)
+			continue
+		}
+		if deviceMake != "" || deviceModel != "" {
+			shot.Camera = strings.Join([]string{deviceMake, deviceModel, serial}, "|")
+		}
+		shot.CreateDate = createDate
+		shot.Edges = &grouping.Edges{Left: leftHash.GetHash(), Right: rightHash.GetHash()}
+		shots = append(shots, shot)
+	}
+
+	sequences := grouping.FindPanoramaSequences(shots)
+	frameCount := 0
+	for _, sequence := range sequences {
+		for _, id := range sequence {
+			if _, err := db.Exec("UPDATE images SET panorama_set = ? WHERE id = ?", sequence[0], id); err != nil {
+				log.Printf("Error updating panorama_set for image ID %d: %v\n", id, err)
+				continue
 			}
+			frameCount++
 		}
 	}
 
-	log.Printf("Found and marked %d similar image pairs.\n", similarPairsCount)
+	log.Printf("Found %d panorama sequences (%d frames).\n", len(sequences), frameCount)
 	return nil
 }
 
-func runSortImages(rootPath string, destinationPath string) error {
+func runSortImages(rootPath string, destinationPath string, renamePolicy util.RenamePolicy) error {
 	log.Printf("Sorting images from %s...\n", rootPath)
-	if destinationPath != "" {
-		log.Printf("Images will be copied to %s.\n", destinationPath)
-	} else {
-		log.Println("Images will be moved within the root path.")
-	}
 
 	db, err := database.GetDBInstance()
 	if err != nil {
 		return fmt.Errorf("failed to get database instance: %w", err)
 	}
-	rows, err := db.Query("SELECT id, file_path, create_date FROM images WHERE is_duplicate = FALSE AND is_recycled = FALSE ORDER BY id ASC")
+
+	var sortCount int
+	if err := db.QueryRow("SELECT COUNT(*) FROM images WHERE is_duplicate = FALSE AND is_recycled = FALSE").Scan(&sortCount); err != nil {
+		return fmt.Errorf("error counting images for sorting: %w", err)
+	}
+	if destinationPath != "" {
+		absDest, _ := filepath.Abs(destinationPath)
+		log.Printf("Pre-action summary: %d images will be copied to %s (YYYY/MM, %s names).\n", sortCount, absDest, renamePolicy)
+	} else {
+		absRoot, _ := filepath.Abs(rootPath)
+		log.Printf("Pre-action summary: %d images will be moved within %s (YYYY/MM, %s names).\n", sortCount, absRoot, renamePolicy)
+	}
+	rows, err := db.Query("SELECT id, file_path, md5, create_date FROM images WHERE is_duplicate = FALSE AND is_recycled = FALSE ORDER BY id ASC")
 	if err != nil {
 		return fmt.Errorf("error querying images for sorting: %w", err)
 	}
 	defer rows.Close()
 
+	moves := newOperationLog(database.OperationSort)
+	defer moves.report()
 	for rows.Next() {
 		var id int
-		var filePath string
+		var filePath, md5 string
 		var createDateStr string
-		if err := rows.Scan(&id, &filePath, &createDateStr); err != nil {
+		if err := rows.Scan(&id, &filePath, &md5, &createDateStr); err != nil {
 			log.Printf("Error scanning image for sorting: %v\n", err)
 			continue
 		}
@@ -476,53 +1242,393 @@ func runSortImages(rootPath string, destinationPath string) error {
 			createDate = time.Now()
 		}
 
-		year := createDate.Format("2006")
-		month := createDate.Format("01")
-
 		targetBaseDir := rootPath
 		if destinationPath != "" {
 			targetBaseDir = destinationPath
 		}
 
-		newBaseDir := filepath.Join(targetBaseDir, year, month)
+		newBaseDir := util.SortDir(targetBaseDir, createDate)
 
-		// Get the file extension
-		ext := filepath.Ext(filePath)
-
-		// Generate the new file name in the correct format
-		newFileName := fmt.Sprintf("%s.%06d%s", createDate.Format("20060102150405"), id, ext)
-		newPath := filepath.Join(newBaseDir, newFileName)
+		// Generate the new file name according to the rename policy
+		newFileName := util.SortFileName(renamePolicy, filepath.Base(filePath), createDate, md5)
+		if filepath.Join(newBaseDir, newFileName) == filepath.Clean(filePath) {
+			continue // Already in place
+		}
 
 		if err := os.MkdirAll(newBaseDir, 0755); err != nil {
 			log.Printf("Error creating directory %s: %v\n", newBaseDir, err)
 			continue
 		}
 
+		newPath, err := util.UniquePath(newBaseDir, newFileName)
+		if err != nil {
+			log.Printf("Error choosing destination name for %s: %v\n", filePath, err)
+			continue
+		}
+
 		if destinationPath != "" {
-			if err := util.CopyFile(filePath, newPath); err != nil {
+			if err := fileops.Copy(filePath, newPath); err != nil {
 				log.Printf("Error copying file from %s to %s: %v\n", filePath, newPath, err)
 				continue
 			}
 			log.Printf("Copied %s to %s\n", filePath, newPath)
 		} else {
-			if err := os.Rename(filePath, newPath); err != nil {
-				if copyErr := util.CopyFile(filePath, newPath); copyErr != nil {
-					log.Printf("Error moving/copying file from %s to %s: %v\n", filePath, newPath, copyErr)
-					continue
+			if err := fileops.Move(filePath, newPath); err != nil {
+				log.Printf("Error moving file from %s to %s: %v\n", filePath, newPath, err)
+				continue
+			}
+			if err := database.SetImagePath(id, newPath); err != nil {
+				log.Printf("Error: %v\n", err)
+				// The catalog still has the old path, so the file goes back there
+				if err := fileops.Move(newPath, filePath); err != nil {
+					log.Printf("ERROR: %s was moved to %s, but the catalog still lists the old path: %v\n", filePath, newPath, err)
+				}
+				continue
+			}
+			moves.record(filePath, newPath)
+			log.Printf("Moved %s to %s\n", filePath, newPath)
+			events.Publish(events.Event{Kind: events.ImageMoved, ImageID: id, MD5: md5, Path: newPath, OldPath: filePath})
+		}
+	}
+	log.Println("Image sorting complete.")
+	return nil
+}
+
+// scanRoots adds the folders listed in --paths-from to the path arguments and
+// drops repeated and nested ones. A folder the catalog holds under another
+// path, e.g. reached through a symlink, is scanned under the catalog's path so
+// its images are not catalogued twice and taken for duplicates of themselves.
+func scanRoots(args []string) ([]string, error) {
+	roots := args
+	if pathsFrom != "" {
+		listed, err := walker.OpenFileList(pathsFrom)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(append([]string{}, args...), listed...)
+	}
+
+	recorded, err := database.ScanRoots()
+	if err != nil {
+		return nil, err
+	}
+	roots = append([]string{}, roots...)
+	for i, root := range roots {
+		canonical, err := walker.CanonicalPath(root)
+		if err != nil {
+			return nil, err
+		}
+		for _, known := range recorded {
+			if canonical == known.Canonical || util.InFolder(canonical, known.Canonical) {
+				rel, err := filepath.Rel(known.Canonical, canonical)
+				if err != nil {
+					break
 				}
-				if removeErr := os.Remove(filePath); removeErr != nil {
-					log.Printf("Warning: Copied %s to %s, but failed to remove original: %v\n", filePath, newPath, removeErr)
+				if catalogPath := filepath.Join(known.Path, rel); !samePath(catalogPath, root) {
+					log.Printf("%s is %s in the catalog; scanning it under that path.\n", root, catalogPath)
+					roots[i] = catalogPath
 				}
-				log.Printf("Moved %s to %s (via copy/delete)\n", filePath, newPath)
-			} else {
-				log.Printf("Moved %s to %s\n", filePath, newPath)
+				break
 			}
-			_, err := db.Exec("UPDATE images SET file_path = ? WHERE id = ?", newPath, id)
+			if util.InFolder(known.Canonical, canonical) && !util.InFolder(known.Path, root) {
+				log.Printf("Warning: %s contains %s, which the catalog holds as %s. Its images will be catalogued again under the new path.\n", root, known.Canonical, known.Path)
+			}
+		}
+	}
+
+	collapsed, err := walker.CollapseRoots(roots)
+	if err != nil {
+		return nil, err
+	}
+	if dropped := len(roots) - len(collapsed); dropped > 0 {
+		log.Printf("Skipping %d repeated or nested paths already covered by another path.\n", dropped)
+	}
+	return collapsed, nil
+}
+
+// findScanFiles walks the scan roots and adds the files of --files-from,
+// leaving out repeats and files below --min-file-size or --min-resolution.
+// With withPDFs it also returns the PDF files found on the way. It returns nil
+// once ctx is cancelled.
+func findScanFiles(ctx context.Context, args []string, withPDFs bool) (imageFiles, pdfFiles []string, err error) {
+	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
+	s.Prefix = "Scanning for image files "
+	s.Start()
+
+	var allImageFiles []string
+	for _, path := range args {
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Printf("Error accessing path '%s': %v\n", path, err)
+			continue
+		}
+
+		if info.IsDir() {
+			files, pdfs, err := walker.FindImageAndPDFFiles(ctx, path)
 			if err != nil {
-				log.Printf("Error updating file_path for image ID %d: %v\n", id, err)
+				if ctx.Err() != nil {
+					break
+				}
+				log.Printf("Error scanning directory '%s': %v\n", path, err)
+				continue
+			}
+			allImageFiles = append(allImageFiles, files...)
+			if withPDFs {
+				pdfFiles = append(pdfFiles, pdfs...)
+			}
+		} else if info.Mode().IsRegular() {
+			if withPDFs && walker.IsPDFFile(path) {
+				pdfFiles = append(pdfFiles, path)
+			} else if walker.IsImageFile(path) {
+				allImageFiles = append(allImageFiles, path)
+			} else {
+				log.Printf("Skipping non-image file: %s\n", path)
 			}
 		}
 	}
-	log.Println("Image sorting complete.")
-	return nil
+
+	// Paths selected by another tool are taken as they are, without walking
+	if filesFrom != "" {
+		listedFiles, err := walker.OpenFileList(filesFrom)
+		if err != nil {
+			s.Stop()
+			return nil, nil, err
+		}
+		for _, path := range listedFiles {
+			isPDF := withPDFs && walker.IsPDFFile(path)
+			if !isPDF && !walker.IsImageFile(path) {
+				log.Printf("Skipping non-image file: %s\n", path)
+				continue
+			}
+			if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+				log.Printf("Skipping unreadable file: %s\n", path)
+				continue
+			}
+			if isPDF {
+				pdfFiles = append(pdfFiles, path)
+			} else {
+				allImageFiles = append(allImageFiles, path)
+			}
+		}
+	}
+
+	s.Stop()
+	if ctx.Err() != nil {
+		return nil, nil, nil
+	}
+	// A file listed by --files-from or under another root may be a symlink to one found already
+	return walker.FilterSmall(walker.Dedupe(allImageFiles)), walker.Dedupe(pdfFiles), nil
+}
+
+// checkpointToResume returns the roots and files saved by an interrupted scan,
+// when there is one and it covered the same roots as args. Without args the
+// roots of the interrupted scan are resumed.
+func checkpointToResume(args []string) (roots, files []string, ok bool, err error) {
+	roots, files, err = database.ScanCheckpoint()
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if len(files) == 0 {
+		log.Println("No interrupted scan to resume; scanning from the start.")
+		return nil, nil, false, nil
+	}
+	if len(args) > 0 {
+		same := len(args) == len(roots)
+		for i := 0; same && i < len(args); i++ {
+			same = samePath(args[i], roots[i])
+		}
+		if !same {
+			log.Printf("The interrupted scan covered %v, not these paths; scanning from the start.\n", roots)
+			return nil, nil, false, nil
+		}
+	}
+	log.Printf("Resuming the interrupted scan with the %d files it found.\n", len(files))
+	return roots, files, true, nil
+}
+
+// samePath reports whether two paths name the same location, without
+// resolving symlinks.
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// recordScanRoots remembers the folders a scan or watch covered.
+func recordScanRoots(roots []string) {
+	var records []database.ScanRoot
+	for _, root := range roots {
+		if info, err := os.Stat(root); err != nil || !info.IsDir() {
+			continue
+		}
+		canonical, err := walker.CanonicalPath(root)
+		if err != nil {
+			continue
+		}
+		records = append(records, database.ScanRoot{Path: root, Canonical: canonical})
+	}
+	if err := database.RecordScanRoots(records); err != nil {
+		log.Printf("Warning: %v\n", err)
+	}
+}
+
+// scanBatchSize is the number of processed images committed per transaction.
+const scanBatchSize = 100
+
+// filesToScan returns the files a scan reads: all of them with rehash,
+// otherwise those the catalog does not hold unchanged.
+func filesToScan(files []string, rehash bool) ([]string, error) {
+	if rehash {
+		return files, nil
+	}
+	return uncommittedFiles(files)
+}
+
+// uncommittedFiles returns the files the catalog does not hold as they are,
+// as database.ChangedFiles tells.
+func uncommittedFiles(files []string) ([]string, error) {
+	pending, rehashed, err := database.ChangedFiles(files)
+	if err != nil {
+		return nil, err
+	}
+	if rehashed > 0 {
+		log.Printf("Hashing %d images again with %s.\n", rehashed, processor.HashAlgo())
+	}
+	return pending, nil
+}
+
+// decodedImageMemory is the memory assumed for each image being decoded at
+// once: a 24 megapixel photo takes about 100 MB decoded, plus the copies made
+// for hashing and thumbnails.
+const decodedImageMemory = 192 << 20
+
+// defaultIOWorkers is the IO pool size unless --io-workers or --workers is
+// given: enough to keep an SSD busy without making a spinning disk seek between
+// many files.
+const defaultIOWorkers = 4
+
+// ioPoolSize returns the number of workers reading and hashing files.
+func ioPoolSize() int {
+	switch {
+	case ioWorkers > 0:
+		return ioWorkers
+	case workers > 0:
+		return workers
+	}
+	return defaultIOWorkers
+}
+
+// cpuPoolSize returns the number of workers decoding images, defaulting to one
+// per CPU the process may use. Under a memory limit the default is lowered so
+// that the decoded images of both the workers and the files waiting for them
+// fit in half of it.
+func cpuPoolSize() int {
+	switch {
+	case cpuWorkers > 0:
+		return cpuWorkers
+	case workers > 0:
+		return workers
+	}
+	limits := util.DetectResourceLimits()
+	n := limits.CPUs
+	if limits.Memory > 0 {
+		if fit := int(limits.Memory / 2 / (2 * decodedImageMemory)); fit < n {
+			n = fit
+		}
+	}
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// processedFile is the outcome of one file of runWorkerPools.
+type processedFile struct {
+	FilePath      string
+	ImageData     *processor.ImageData
+	ThumbnailData []byte
+	Err           error
+}
+
+// runWorkerPools processes files in two pools: ioCount workers read and hash
+// them (IO-bound) and cpuCount workers decode them, compute the perceptual
+// hashes and encode thumbnails (CPU-bound). Only as many read files as there
+// are CPU workers wait between the pools, so a fast disk does not fill memory.
+// wait is called before each step with the index of the worker in its pool,
+// e.g. to pause or throttle the scan; once it returns an
+// error, as it does when ctx is cancelled, the remaining files are skipped
+// without a result. The returned channel is closed once every worker stopped.
+func runWorkerPools(ctx context.Context, files []string, ioCount, cpuCount int, wait func(ctx context.Context, worker int) error) <-chan processedFile {
+	jobs := make(chan string, len(files))
+	for _, filePath := range files {
+		jobs <- filePath
+	}
+	close(jobs)
+	loaded := make(chan *processor.LoadedFile, cpuCount)
+	results := make(chan processedFile, cpuCount)
+	var ioWG, cpuWG sync.WaitGroup
+
+	for w := 0; w < ioCount; w++ {
+		ioWG.Add(1)
+		go func() {
+			defer ioWG.Done()
+			for filePath := range jobs {
+				if wait(ctx, w) != nil {
+					continue
+				}
+				file, err := processor.ReadImageFile(ctx, filePath)
+				if ctx.Err() != nil {
+					continue // Cut short, not failed
+				}
+				if err != nil {
+					results <- processedFile{FilePath: filePath, Err: err}
+					continue
+				}
+				loaded <- file
+			}
+		}()
+	}
+	for w := 0; w < cpuCount; w++ {
+		cpuWG.Add(1)
+		go func() {
+			defer cpuWG.Done()
+			for file := range loaded {
+				if wait(ctx, w) != nil {
+					file.Close()
+					continue
+				}
+				imageData, source, err := file.Analyze()
+				if err != nil {
+					results <- processedFile{FilePath: file.FilePath(), Err: err}
+					continue
+				}
+				results <- processedFile{FilePath: imageData.FilePath, ImageData: imageData, ThumbnailData: source.Encode(imageData)}
+			}
+		}()
+	}
+	go func() {
+		ioWG.Wait()
+		close(loaded)
+		cpuWG.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// checkDestructiveConsent refuses to start modes that move files unless the user
+// explicitly acknowledged it with --i-understand-data-will-move.
+func checkDestructiveConsent() error {
+	var modes []string
+	if autoRecycleDuplicates {
+		modes = append(modes, "--auto-recycle-duplicates")
+	} else if autoRecycleSameDir {
+		modes = append(modes, "--auto-recycle-same-dir")
+	}
+	if sortImagesFlag && sortDestinationPath == "" {
+		modes = append(modes, "--sort without --sort-destination")
+	}
+	if len(modes) == 0 || consentDataMove {
+		return nil
+	}
+	return fmt.Errorf("%s will move files on disk; re-run with --i-understand-data-will-move to confirm", strings.Join(modes, " and "))
 }
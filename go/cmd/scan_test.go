@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"picpurge/database"
+	"picpurge/grouping"
+	"picpurge/processor"
+	"picpurge/util"
+)
+
+// TestMain removes the temporary catalog the tests share.
+func TestMain(m *testing.M) {
+	code := m.Run()
+	database.CloseDb()
+	os.Exit(code)
+}
+
+// insertTestImage catalogs image and returns its ID.
+func insertTestImage(t *testing.T, image *processor.ImageData) int {
+	t.Helper()
+	if image.FileName == "" {
+		image.FileName = image.FilePath
+	}
+	if err := database.InsertImage(image); err != nil {
+		t.Fatalf("InsertImage failed: %v", err)
+	}
+	db, err := database.GetDBInstance()
+	if err != nil {
+		t.Fatalf("GetDBInstance failed: %v", err)
+	}
+	var id int
+	if err := db.QueryRow("SELECT id FROM images WHERE file_path = ?", image.FilePath).Scan(&id); err != nil {
+		t.Fatalf("Failed to look up inserted image: %v", err)
+	}
+	return id
+}
+
+func TestSameShotOriginal(t *testing.T) {
+	db, err := database.GetDBInstance()
+	if err != nil {
+		t.Fatalf("GetDBInstance failed: %v", err)
+	}
+	// A RAW, a slightly larger JPEG of the same exposure and a re-shoot a frame later
+	const phash = "p:a5a55a5aa5a55a5a"
+	raw := insertTestImage(t, &processor.ImageData{FilePath: "/photos/shot/b.cr2", MD5: "shot-raw", PHash: phash, ImageWidth: 4000, ImageHeight: 3000, FileSize: 25 << 20, CameraSerial: "0123", ShutterCount: 4711})
+	jpeg := insertTestImage(t, &processor.ImageData{FilePath: "/photos/shot/b.jpg", MD5: "shot-jpeg", PHash: phash, ImageWidth: 4032, ImageHeight: 3024, FileSize: 4 << 20, CameraSerial: "0123", ShutterCount: 4711})
+	reshoot := insertTestImage(t, &processor.ImageData{FilePath: "/photos/shot/c.jpg", MD5: "shot-reshoot", PHash: phash, ImageWidth: 4032, ImageHeight: 3024, FileSize: 4 << 20, CameraSerial: "0123", ShutterCount: 4712})
+
+	sameShotOf := func(id int) int {
+		t.Helper()
+		var original sql.NullInt64
+		if err := db.QueryRow("SELECT same_shot_of FROM images WHERE id = ?", id).Scan(&original); err != nil {
+			t.Fatalf("Failed to read same_shot_of: %v", err)
+		}
+		return int(original.Int64)
+	}
+	testCases := []struct {
+		rules            string
+		original, copied int
+	}{
+		{"", jpeg, raw},    // more pixels
+		{"raw", raw, jpeg}, // the keep policy decides first
+	}
+	for _, tc := range testCases {
+		if _, err := db.Exec("UPDATE images SET same_shot_of = NULL"); err != nil {
+			t.Fatalf("Failed to clear same_shot_of: %v", err)
+		}
+		policy, err := grouping.ParseKeepPolicy(tc.rules, nil)
+		if err != nil {
+			t.Fatalf("ParseKeepPolicy(%q) failed: %v", tc.rules, err)
+		}
+		if err := runFindSimilarImages(context.Background(), policy); err != nil {
+			t.Fatalf("runFindSimilarImages failed: %v", err)
+		}
+		if got := sameShotOf(tc.copied); got != tc.original {
+			t.Errorf("policy %q: image %d is a copy of %d; expected %d", tc.rules, tc.copied, got, tc.original)
+		}
+		if got := sameShotOf(tc.original); got != 0 {
+			t.Errorf("policy %q: the original %d is a copy of %d", tc.rules, tc.original, got)
+		}
+		if got := sameShotOf(reshoot); got != 0 {
+			t.Errorf("policy %q: the re-shoot is a copy of %d", tc.rules, got)
+		}
+	}
+}
+
+func TestFindDuplicatesSkipsRecycled(t *testing.T) {
+	db, err := database.GetDBInstance()
+	if err != nil {
+		t.Fatalf("GetDBInstance failed: %v", err)
+	}
+	// A favorite copy was recycled by an earlier run; curation would keep it first
+	recycled := insertTestImage(t, &processor.ImageData{FilePath: "/photos/rescan/a.jpg", MD5: "rescan"})
+	kept := insertTestImage(t, &processor.ImageData{FilePath: "/photos/rescan/b.jpg", MD5: "rescan"})
+	copied := insertTestImage(t, &processor.ImageData{FilePath: "/photos/rescan/c.jpg", MD5: "rescan"})
+	if err := database.SetFavorite(recycled, true); err != nil {
+		t.Fatalf("SetFavorite failed: %v", err)
+	}
+	if err := database.MarkRecycled("/photos/rescan/a.jpg"); err != nil {
+		t.Fatalf("MarkRecycled failed: %v", err)
+	}
+	// A lone copy whose twin was recycled is no duplicate
+	lone := insertTestImage(t, &processor.ImageData{FilePath: "/photos/rescan/d.jpg", MD5: "rescan-lone"})
+	insertTestImage(t, &processor.ImageData{FilePath: "/photos/rescan/e.jpg", MD5: "rescan-lone"})
+	if err := database.MarkRecycled("/photos/rescan/e.jpg"); err != nil {
+		t.Fatalf("MarkRecycled failed: %v", err)
+	}
+
+	// Both runs of a rescan must come to the same answer
+	for run := 1; run <= 2; run++ {
+		if err := database.ResetAnalysis(); err != nil {
+			t.Fatalf("ResetAnalysis failed: %v", err)
+		}
+		if err := runFindDuplicates(context.Background(), false, false, false, grouping.KeepPolicy{}, "", util.RecycleCap{}, 0, false, ""); err != nil {
+			t.Fatalf("runFindDuplicates failed: %v", err)
+		}
+		expected := map[int]sql.NullInt64{
+			kept:   {},
+			copied: {Int64: int64(kept), Valid: true},
+			lone:   {},
+		}
+		for id, duplicateOf := range expected {
+			var isDuplicate bool
+			var got sql.NullInt64
+			if err := db.QueryRow("SELECT is_duplicate, duplicate_of FROM images WHERE id = ?", id).Scan(&isDuplicate, &got); err != nil {
+				t.Fatalf("Failed to read duplicate status: %v", err)
+			}
+			if isDuplicate != duplicateOf.Valid || got != duplicateOf {
+				t.Errorf("run %d: image %d is_duplicate = %v, duplicate_of = %v; expected a duplicate of %v", run, id, isDuplicate, got, duplicateOf)
+			}
+		}
+	}
+}
+
+func TestFilesToScan(t *testing.T) {
+	dir := t.TempDir()
+	unchanged, edited := filepath.Join(dir, "unchanged.jpg"), filepath.Join(dir, "edited.jpg")
+	for _, path := range []string{unchanged, edited} {
+		if err := os.WriteFile(path, []byte(path), 0644); err != nil {
+			t.Fatalf("Failed to write image: %v", err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		image := &processor.ImageData{FilePath: path, FileName: filepath.Base(path), FileSize: info.Size(), ModTime: info.ModTime(), MD5: util.FormatDigest(processor.HashAlgo(), []byte(path))}
+		if err := database.CommitScanBatch([]database.ScanResult{{ImageData: image}}); err != nil {
+			t.Fatalf("CommitScanBatch failed: %v", err)
+		}
+	}
+	if err := os.WriteFile(edited, []byte("edited since"), 0644); err != nil {
+		t.Fatalf("Failed to edit image: %v", err)
+	}
+	files := []string{unchanged, edited}
+
+	testCases := []struct {
+		rehash   bool
+		expected []string
+	}{
+		{false, []string{edited}},
+		{true, files}, // --rehash reads unchanged files too
+	}
+	for _, tc := range testCases {
+		got, err := filesToScan(files, tc.rehash)
+		if err != nil {
+			t.Fatalf("filesToScan failed: %v", err)
+		}
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("filesToScan(rehash %v) = %v; expected %v", tc.rehash, got, tc.expected)
+		}
+	}
+}
+
+func TestFindScanFilesWithPDFs(t *testing.T) {
+	dir, elsewhere := t.TempDir(), t.TempDir()
+	image, album := filepath.Join(dir, "a.jpg"), filepath.Join(dir, "album.pdf")
+	listedImage, listedPDF := filepath.Join(elsewhere, "b.jpg"), filepath.Join(elsewhere, "scan.pdf")
+	for _, path := range []string{image, album, listedImage, listedPDF} {
+		if err := os.WriteFile(path, []byte(path), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+	list := filepath.Join(t.TempDir(), "files.txt")
+	if err := os.WriteFile(list, []byte(listedImage+"\n"+listedPDF+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file list: %v", err)
+	}
+	filesFrom = list
+	defer func() { filesFrom = "" }()
+
+	testCases := []struct {
+		withPDFs bool
+		pdfs     []string
+	}{
+		{false, nil},
+		{true, []string{album, listedPDF}}, // PDFs listed by --files-from too
+	}
+	for _, tc := range testCases {
+		images, pdfs, err := findScanFiles(context.Background(), []string{dir}, tc.withPDFs)
+		if err != nil {
+			t.Fatalf("findScanFiles failed: %v", err)
+		}
+		if expected := []string{image, listedImage}; !reflect.DeepEqual(images, expected) {
+			t.Errorf("findScanFiles(withPDFs %v) found images %v; expected %v", tc.withPDFs, images, expected)
+		}
+		if !reflect.DeepEqual(pdfs, tc.pdfs) {
+			t.Errorf("findScanFiles(withPDFs %v) found PDFs %v; expected %v", tc.withPDFs, pdfs, tc.pdfs)
+		}
+	}
+}
@@ -1 +1,93 @@
 package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"picpurge/database"
+	"picpurge/server"
+
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Browse an existing catalog in the web interface without rescanning.",
+	Long:  `This command starts the web interface on the catalog selected with --db-path, as left by an earlier scan. Nothing is hashed or analysed, so even large libraries open at once.`,
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if dbPath == "" || dbPath == database.MemoryPath {
+			return fmt.Errorf("serve needs a catalog from an earlier scan, not --temp-db or --db %s", database.MemoryPath)
+		}
+		db, err := database.GetDBInstance()
+		if err != nil {
+			return fmt.Errorf("failed to get database instance: %w", err)
+		}
+		var imageCount int
+		if err := db.QueryRow("SELECT COUNT(*) FROM images WHERE is_recycled = FALSE").Scan(&imageCount); err != nil {
+			return fmt.Errorf("error counting images: %w", err)
+		}
+		if imageCount == 0 {
+			log.Printf("Warning: %s has no images yet. Run scan with --db-path %s first.\n", dbPath, dbPath)
+		}
+
+		if err := database.LoadThumbnails(server.AddThumbnailToMemory); err != nil {
+			log.Printf("Warning: Could not load stored thumbnails: %v\n", err)
+		}
+		if err := server.SetAPIVersion(apiVersion); err != nil {
+			return err
+		}
+		if err := server.SetBasePath(basePath); err != nil {
+			return err
+		}
+		server.SetLaunchConfig(server.LaunchConfig{Enabled: allowLaunch, Editor: launchEditor})
+		if allowLaunch {
+			log.Println("Opening originals in desktop applications is enabled for requests from this machine.")
+		}
+		enableReportQueries()
+		go runRawConversions()
+		log.Printf("Serving %d images from %s. Press Ctrl+C to stop.\n", imageCount, dbPath)
+		ctx, stop := interruptContext(cmd.Context())
+		defer stop()
+		if err := server.StartServer(ctx, serverPort, listenAddresses); err != nil {
+			return fmt.Errorf("failed to start server: %w", err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().IntVarP(&serverPort, "port", "p", 3000, "Port to start the server on")
+	serveCmd.Flags().StringSliceVar(&listenAddresses, "listen", nil, listenUsage)
+	serveCmd.Flags().StringVar(&basePath, "base-path", "", basePathUsage)
+	serveCmd.Flags().BoolVar(&allowLaunch, "allow-launch", false, allowLaunchUsage)
+	serveCmd.Flags().IntVar(&apiVersion, "api-version", server.APIVersion1, "Field naming of the JSON API for clients that do not send the X-PicPurge-API-Version header: 1 keeps the original mixed naming, 2 uses snake_case throughout. The web interface asks for 1 either way.")
+	serveCmd.Flags().StringVar(&queryToken, "query-token", "", queryTokenUsage)
+	serveCmd.Flags().StringVar(&launchEditor, "editor", "", "Editor command used by the web interface's Edit button, e.g. gimp. The file path is appended as the last argument.")
+}
+
+// queryTokenEnv names the environment variable that sets the token of report
+// queries when --query-token is not given, keeping it out of process listings.
+const queryTokenEnv = "PICPURGE_QUERY_TOKEN"
+
+const listenUsage = "Address to serve on instead of every interface at --port, e.g. 127.0.0.1, [::1]:8080 or unix:/run/picpurge.sock for a reverse proxy. Repeat to listen on several at once."
+
+const basePathUsage = "Path prefix the web interface is reached under behind a reverse proxy, e.g. /picpurge when nginx forwards https://host/picpurge/. The proxy may strip the prefix or pass it on."
+
+const allowLaunchUsage = "Let the web interface open originals in the default viewer or --editor. Only honoured for requests from this machine; requests forwarded by a reverse proxy, e.g. with X-Forwarded-For, are refused even when the proxy runs here."
+
+const queryTokenUsage = "Enable read-only SQL report queries at /api/query for requests sending this bearer token. Defaults to $" + queryTokenEnv + "; without a token the endpoint is off."
+
+// enableReportQueries turns on /api/query if a token is given.
+func enableReportQueries() {
+	token := queryToken
+	if token == "" {
+		token = os.Getenv(queryTokenEnv)
+	}
+	server.SetQueryToken(token)
+	if token != "" {
+		log.Println("Read-only report queries are enabled at /api/query.")
+	}
+}
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"picpurge/database"
+	"picpurge/fileops"
+
+	"github.com/spf13/cobra"
+)
+
+var undoCmd = &cobra.Command{
+	Use:   "undo",
+	Short: "Move the files of an earlier recycle or sort back.",
+	Long: `Every file picpurge moves, by recycling from the web interface, apply-csv, --auto-recycle-duplicates, --auto-recycle-same-dir or an in-place --sort, is recorded in the operations log of the catalog.
+This command moves the files of one operation back where they were, the last move first, and restores recycled images to their groups.
+Without --last or --operation-id it lists the recent operations.
+Files that are gone from where the operation put them, or whose old path is taken, are left alone and reported; run undo again after sorting them out.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if undoLast && undoOperationID != 0 {
+			return fmt.Errorf("use either --last or --operation-id")
+		}
+		if !undoLast && undoOperationID == 0 {
+			return listOperations()
+		}
+		return undoOperation(undoOperationID)
+	},
+}
+
+var (
+	undoLast        bool
+	undoOperationID int64
+)
+
+func init() {
+	RootCmd.AddCommand(undoCmd)
+	undoCmd.Flags().BoolVar(&undoLast, "last", false, "Undo the newest operation that is not undone yet.")
+	undoCmd.Flags().Int64Var(&undoOperationID, "operation-id", 0, "Undo the operation with this ID, as listed by undo without flags.")
+}
+
+// listOperations prints the recent operations of the log.
+func listOperations() error {
+	operations, err := database.Operations(20)
+	if err != nil {
+		return err
+	}
+	if len(operations) == 0 {
+		fmt.Println("No file operations recorded.")
+		return nil
+	}
+	for _, op := range operations {
+		status := ""
+		if op.UndoneAt != nil {
+			status = ", undone " + op.UndoneAt.Local().Format("2006-01-02 15:04")
+		} else if op.Undone > 0 {
+			status = fmt.Sprintf(", %d undone", op.Undone)
+		}
+		fmt.Printf("%5d  %s  %-12s  %d files%s\n", op.ID, op.StartedAt.Local().Format("2006-01-02 15:04"), op.Kind, op.Moves, status)
+	}
+	return nil
+}
+
+// undoOperation moves the files of an operation back, or of the newest one
+// not undone when id is 0. Moves that were undone before are skipped, so an
+// undo that was interrupted or partly failed can be run again.
+func undoOperation(id int64) error {
+	op, err := database.GetOperation(id)
+	if err != nil {
+		return err
+	}
+	if op.UndoneAt != nil {
+		return fmt.Errorf("operation %d was undone already", op.ID)
+	}
+	moves, err := database.OperationMoves(op.ID)
+	if err != nil {
+		return err
+	}
+	log.Printf("Undoing %s operation %d of %s: %d files.\n", op.Kind, op.ID, op.StartedAt.Local().Format("2006-01-02 15:04"), op.Moves-op.Undone)
+
+	restored, failed := 0, 0
+	for _, move := range moves {
+		if move.Undone {
+			continue
+		}
+		if err := fileops.Move(move.Dst, move.Src); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				log.Printf("Warning: %s is no longer at %s; it stays where it is.\n", move.Src, move.Dst)
+			} else {
+				log.Printf("Error moving %s back to %s: %v\n", move.Dst, move.Src, err)
+			}
+			failed++
+			continue
+		}
+		if err := database.UndoMove(move); err != nil {
+			log.Printf("Error updating database for restored file %s: %v\n", move.Src, err)
+			// The catalog still has the file where it was moved, so it stays there
+			if err := fileops.Move(move.Src, move.Dst); err != nil {
+				log.Printf("ERROR: %s was moved back, but the catalog does not know: %v\n", move.Src, err)
+			}
+			failed++
+			continue
+		}
+		fileops.ForgetTrashed(move.Dst)
+		restored++
+	}
+
+	done, err := database.FinishUndo(op.ID)
+	if err != nil {
+		return err
+	}
+	log.Printf("Moved %d files back.\n", restored)
+	if !done {
+		return fmt.Errorf("%d files of operation %d could not be moved back; run undo --operation-id %d again once they are in place", failed, op.ID, op.ID)
+	}
+	return nil
+}
+
+// operationLog records the moves of one action in the operations log. The
+// operation starts with the first move, so actions that move nothing leave no
+// entry. A move that cannot be logged still happens; it just cannot be undone.
+type operationLog struct {
+	kind database.OperationKind
+	id   int64
+}
+
+func newOperationLog(kind database.OperationKind) *operationLog {
+	return &operationLog{kind: kind}
+}
+
+// record logs that src was moved to dst. Call it once the catalog has recorded
+// the move.
+func (l *operationLog) record(src, dst string) {
+	if l.id == 0 {
+		id, err := database.BeginOperation(l.kind)
+		if err != nil {
+			log.Printf("Warning: Could not start the operations log; the move of %s cannot be undone: %v\n", src, err)
+			return
+		}
+		l.id = id
+	}
+	if err := database.RecordMove(l.id, src, dst); err != nil {
+		log.Printf("Warning: The move of %s cannot be undone: %v\n", src, err)
+	}
+}
+
+// report tells how to undo the operation, if it moved anything.
+func (l *operationLog) report() {
+	if l.id != 0 {
+		log.Printf("Recorded as operation %d. Run undo --operation-id %d to move the files back.\n", l.id, l.id)
+	}
+}
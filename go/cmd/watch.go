@@ -0,0 +1,328 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"picpurge/database"
+	"picpurge/grouping"
+	"picpurge/processor"
+	"picpurge/server"
+	"picpurge/util"
+	"picpurge/walker"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch [paths...]",
+	Short: "Keep the catalog up to date while images arrive in folders.",
+	Long: `This command catalogs the given folders like scan and then keeps running, watching them for new, changed and deleted images. Once the folders have been quiet for --settle, the changed files are processed and duplicates and similar groups are found again, which suits auto-import folders and NAS drop directories.
+
+Nothing is ever recycled or sorted by this command.`,
+	Args: cobra.ArbitraryArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if watchSettle <= 0 {
+			return fmt.Errorf("--settle must be positive")
+		}
+		if err := server.SetAPIVersion(apiVersion); err != nil {
+			return err
+		}
+		if err := server.SetBasePath(basePath); err != nil {
+			return err
+		}
+		args, err := scanRoots(args)
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			return fmt.Errorf("no folders to watch; pass paths or --paths-from")
+		}
+		walker.SetDetectContentType(detectContentType)
+		walker.SetSymlinkOptions(walker.SymlinkOptions{Follow: followSymlinks, Dedupe: dedupeSymlinks})
+		if err := setMinimumSize(); err != nil {
+			return err
+		}
+		if err := processor.SetConfirmHashes(confirmHashes); err != nil {
+			return fmt.Errorf("invalid --confirm-hashes: %w", err)
+		}
+		stopProvider, err := startSimilarityProvider()
+		if err != nil {
+			return err
+		}
+		defer stopProvider()
+		ctx, stop := interruptContext(cmd.Context())
+		defer stop()
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			return fmt.Errorf("failed to start watching: %w", err)
+		}
+		defer watcher.Close()
+
+		var roots []string
+		for _, path := range args {
+			root, err := filepath.Abs(path)
+			if err != nil {
+				return fmt.Errorf("invalid path %s: %w", path, err)
+			}
+			if info, err := os.Stat(root); err != nil || !info.IsDir() {
+				return fmt.Errorf("%s is not a folder", path)
+			}
+			if err := watchTree(watcher, root); err != nil {
+				return err
+			}
+			roots = append(roots, root)
+		}
+		recordScanRoots(roots)
+
+		if err := database.LoadThumbnails(server.AddThumbnailToMemory); err != nil {
+			log.Printf("Warning: Could not load stored thumbnails: %v\n", err)
+		}
+		if !noServer {
+			server.SetLaunchConfig(server.LaunchConfig{Enabled: allowLaunch, Editor: launchEditor})
+			enableReportQueries()
+			log.Println("Starting web server...")
+			listeners, err := server.Listen(serverPort, listenAddresses)
+			if err != nil {
+				return fmt.Errorf("failed to start server: %w", err)
+			}
+			go func() {
+				if err := server.Serve(ctx, listeners); err != nil {
+					log.Printf("Error: web server stopped: %v\n", err)
+				}
+			}()
+		}
+
+		// Catch up with whatever happened while nothing was watching
+		var files []string
+		for _, root := range roots {
+			found, err := walker.FindImageFiles(ctx, root)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				log.Printf("Error scanning directory '%s': %v\n", root, err)
+				continue
+			}
+			files = append(files, found...)
+		}
+		syncWatchedFiles(ctx, files, roots)
+
+		log.Printf("Watching %s. Press Ctrl+C to stop.\n", strings.Join(roots, ", "))
+		changed := make(map[string]bool) // created or written paths
+		removed := make(map[string]bool) // deleted or renamed-away paths
+		settle := time.NewTimer(watchSettle)
+		settle.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("Stopped watching.")
+				return nil
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return nil
+				}
+				if event.Has(fsnotify.Create) || event.Has(fsnotify.Write) {
+					changed[event.Name] = true
+				}
+				if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+					removed[event.Name] = true
+				}
+				settle.Reset(watchSettle)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return nil
+				}
+				log.Printf("Warning: watch error: %v\n", err)
+			case <-settle.C:
+				var files []string
+				for path := range changed {
+					info, err := os.Stat(path)
+					if err != nil {
+						continue // Gone again; handled as removed
+					}
+					if info.IsDir() {
+						// Files copied in with a new folder arrive before it is watched
+						if err := watchTree(watcher, path); err != nil {
+							log.Printf("Warning: %v\n", err)
+						}
+						found, err := walker.FindImageFiles(ctx, path)
+						if err != nil {
+							log.Printf("Error scanning directory '%s': %v\n", path, err)
+						}
+						files = append(files, found...)
+					} else if walker.IsImageFile(path) {
+						files = append(files, path)
+					}
+				}
+				var gone []string
+				for path := range removed {
+					gone = append(gone, path)
+				}
+				changed, removed = make(map[string]bool), make(map[string]bool)
+				syncWatchedFiles(ctx, files, gone)
+			}
+		}
+	},
+}
+
+var watchSettle time.Duration
+
+func init() {
+	RootCmd.AddCommand(watchCmd)
+	watchCmd.Flags().StringVar(&pathsFrom, "paths-from", "", "Read newline- or NUL-delimited folders to watch from a file, in addition to the path arguments. Repeated folders and folders inside another one are watched once.")
+	watchCmd.Flags().BoolVar(&detectContentType, "detect-content-type", false, "Also catalog files whose content is a supported image or video format despite their extension, as for scan.")
+	watchCmd.Flags().StringVar(&similarityProviderSpec, "similarity-provider", "", "Group similar images by the signatures of an external worker instead of pHash, as for scan.")
+	watchCmd.Flags().IntVar(&similarityThreshold, "similarity-threshold", 0, "Largest distance between similar images with --similarity-provider, as for scan.")
+	watchCmd.Flags().IntVar(&confirmHashes, "confirm-hashes", 0, "How many of the other hashes must confirm a pHash match, as for scan.")
+	watchCmd.Flags().BoolVar(&followSymlinks, "follow-symlinks", false, "Descend into symlinked folders, as for scan.")
+	watchCmd.Flags().BoolVar(&dedupeSymlinks, "dedupe-symlinks", false, "Count a file reached through several symlinks once, as for scan.")
+	watchCmd.Flags().StringVar(&minFileSize, "min-file-size", "", "Leave out files smaller than this, as for scan.")
+	watchCmd.Flags().StringVar(&minResolution, "min-resolution", "", "Leave out images with fewer pixels across or down than this, as for scan.")
+	watchCmd.Flags().DurationVar(&watchSettle, "settle", 3*time.Second, "How long the folders must be quiet before changes are processed, so files still being copied are not read half-written.")
+	watchCmd.Flags().IntVarP(&serverPort, "port", "p", 3000, "Port to start the server on")
+	watchCmd.Flags().StringSliceVar(&listenAddresses, "listen", nil, listenUsage)
+	watchCmd.Flags().StringVar(&basePath, "base-path", "", basePathUsage)
+	watchCmd.Flags().BoolVar(&noServer, "no-server", false, "Only keep the catalog up to date, without serving the web interface.")
+	watchCmd.Flags().BoolVar(&allowLaunch, "allow-launch", false, allowLaunchUsage)
+	watchCmd.Flags().IntVar(&apiVersion, "api-version", server.APIVersion1, "Field naming of the JSON API for clients that do not ask for a version, as for scan.")
+	watchCmd.Flags().StringVar(&queryToken, "query-token", "", queryTokenUsage)
+	watchCmd.Flags().StringVar(&launchEditor, "editor", "", "Editor command used by the web interface's Edit button, e.g. gimp. The file path is appended as the last argument.")
+}
+
+// watchTree watches root and every folder below it; fsnotify does not recurse.
+func watchTree(watcher *fsnotify.Watcher, root string) error {
+	return walker.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("Warning: Could not watch %s: %v\n", path, err)
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if err := watcher.Add(path); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// syncWatchedFiles processes new or changed files, drops catalog images at or
+// below the gone paths that no longer exist, and analyses the catalog again if
+// anything changed. Once ctx is cancelled it commits the files processed so far
+// and skips the analysis.
+func syncWatchedFiles(ctx context.Context, files, gone []string) {
+	files = walker.FilterSmall(files)
+	removedCount := 0
+	for _, filePath := range missingCatalogFiles(gone) {
+		// A deleted file leaves the catalog the way a recycled one does
+		if err := database.MarkRecycled(filePath); err != nil {
+			log.Printf("Error removing %s from the catalog: %v\n", filePath, err)
+			continue
+		}
+		log.Printf("Removed %s from the catalog; the file is gone.\n", filePath)
+		removedCount++
+	}
+
+	pending, err := uncommittedFiles(files)
+	if err != nil {
+		log.Printf("Error: %v\n", err)
+		return
+	}
+	processedCount := 0
+	var batch []database.ScanResult
+	commitBatch := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := database.CommitScanBatch(batch); err != nil {
+			log.Printf("Error committing %d images: %v\n", len(batch), err)
+		} else {
+			processedCount += len(batch)
+		}
+		batch = nil
+	}
+	for _, filePath := range pending {
+		if ctx.Err() != nil {
+			break
+		}
+		imageData, thumbnailData, err := processor.ProcessImage(filePath)
+		if err != nil {
+			log.Printf("Error processing image '%s': %v\n", filePath, err)
+			continue
+		}
+		if thumbnailData != nil && !(imageData.RawPending && server.GetThumbnailFromMemory(imageData.MD5) != nil) {
+			server.AddThumbnailToMemory(imageData.MD5, thumbnailData)
+		}
+		batch = append(batch, database.ScanResult{ImageData: imageData, ThumbnailData: thumbnailData})
+		if len(batch) >= scanBatchSize {
+			commitBatch()
+		}
+	}
+	commitBatch()
+	if removedCount == 0 && processedCount == 0 {
+		return
+	}
+	log.Printf("Processed %d new or changed images, removed %d deleted ones.\n", processedCount, removedCount)
+	if ctx.Err() != nil {
+		return
+	}
+
+	if _, err := database.ClassifyMessengers(); err != nil {
+		log.Printf("Warning: Could not classify messenger folders: %v\n", err)
+	}
+	if err := database.ResetAnalysis(); err != nil {
+		log.Printf("Error finding duplicates: %v\n", err)
+		return
+	}
+	if err := runFindDuplicates(ctx, false, false, false, grouping.KeepPolicy{}, "", util.RecycleCap{}, 0, false, ""); err != nil {
+		log.Printf("Error finding duplicates: %v\n", err)
+		return
+	}
+	groupErr := runFindBracketSets()
+	if groupErr == nil {
+		groupErr = runFindPanoramaSequences()
+	}
+	if groupErr == nil {
+		groupErr = runFindSimilarImages(ctx, grouping.KeepPolicy{})
+	}
+	if groupErr != nil {
+		log.Printf("Error finding similar images: %v\n", groupErr)
+	}
+	if processedCount > 0 {
+		go runRawConversions()
+	}
+}
+
+// missingCatalogFiles returns the active catalog images at or below the given
+// paths whose files no longer exist. A path can be a file or a whole folder.
+func missingCatalogFiles(paths []string) []string {
+	if len(paths) == 0 {
+		return nil
+	}
+	committed, err := database.CommittedFiles()
+	if err != nil {
+		log.Printf("Error: %v\n", err)
+		return nil
+	}
+	var missing []string
+	for filePath := range committed {
+		for _, path := range paths {
+			if filePath != path && !util.InFolder(filePath, path) {
+				continue
+			}
+			if _, err := os.Stat(filePath); os.IsNotExist(err) {
+				missing = append(missing, filePath)
+			}
+			break
+		}
+	}
+	return missing
+}
@@ -0,0 +1,34 @@
+package database
+
+import (
+	"fmt"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// NaturalCollation orders text the way people read file names: numbers by
+// their value, so IMG_2 comes before IMG_10, letters without regard to case,
+// and accented letters by the rules of the sort locale. Use it as
+// "file_name COLLATE NATSORT".
+const NaturalCollation = "NATSORT"
+
+// sortLocale selects the alphabet order of NaturalCollation.
+var sortLocale = language.Und
+
+// SetSortLocale sets the language whose alphabet order NaturalCollation uses,
+// as a BCP 47 tag such as de or sv. It has no effect once the database is open.
+func SetSortLocale(tag string) error {
+	locale, err := language.Parse(tag)
+	if err != nil {
+		return fmt.Errorf("invalid sort locale %q: %w", tag, err)
+	}
+	sortLocale = locale
+	return nil
+}
+
+// newCollator returns a collator for NaturalCollation. A collator keeps
+// buffers between comparisons, so it must not be shared between goroutines.
+func newCollator() *collate.Collator {
+	return collate.New(sortLocale, collate.Numeric, collate.IgnoreCase)
+}
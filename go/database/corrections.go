@@ -0,0 +1,196 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"picpurge/grouping"
+)
+
+// similar_corrections keeps the similar groups someone merged or split by hand,
+// so that later scans group those images the same way.
+const createSimilarCorrectionsTableSQL = `
+CREATE TABLE IF NOT EXISTS similar_corrections (
+	image_id INTEGER NOT NULL,
+	other_id INTEGER NOT NULL, -- the lower ID comes first for merged pairs
+	apart BOOLEAN NOT NULL, -- image_id was split from the group of other_id; otherwise they were merged
+	created_at DATETIME,
+	PRIMARY KEY (image_id, other_id)
+);
+`
+
+// SimilarCorrections returns the manual corrections between images still in
+// the catalog, for grouping.ApplyCorrections.
+func SimilarCorrections() ([]grouping.Correction, error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT c.image_id, c.other_id, c.apart FROM similar_corrections c
+		JOIN images a ON a.id = c.image_id AND a.is_recycled = FALSE
+		JOIN images b ON b.id = c.other_id AND b.is_recycled = FALSE`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read similar group corrections: %w", err)
+	}
+	defer rows.Close()
+	var corrections []grouping.Correction
+	for rows.Next() {
+		var c grouping.Correction
+		if err := rows.Scan(&c.ID, &c.Other, &c.Apart); err != nil {
+			return nil, fmt.Errorf("failed to read similar group correction: %w", err)
+		}
+		corrections = append(corrections, c)
+	}
+	return corrections, rows.Err()
+}
+
+// MergeSimilarGroups puts the images of ids, with the similar groups they are
+// in, into one group and remembers the merge. It returns the merged group.
+func MergeSimilarGroups(ids []int) ([]int, error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return nil, err
+	}
+	var groups [][]int
+	seen := make(map[int]bool)
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		group, err := similarGroupOf(db, id)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range group {
+			seen[member] = true
+		}
+		groups = append(groups, group)
+	}
+	if len(groups) < 2 {
+		return nil, fmt.Errorf("the images are in one group already")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	var merged []int
+	now := time.Now().UTC()
+	for _, group := range groups {
+		for _, member := range group {
+			for _, earlier := range merged {
+				a, b := member, earlier
+				if a > b {
+					a, b = b, a
+				}
+				if _, err := tx.Exec("DELETE FROM similar_corrections WHERE (image_id = ? AND other_id = ?) OR (image_id = ? AND other_id = ?)", a, b, b, a); err != nil {
+					return nil, fmt.Errorf("failed to update similar group corrections: %w", err)
+				}
+				if _, err := tx.Exec("INSERT INTO similar_corrections (image_id, other_id, apart, created_at) VALUES (?, ?, FALSE, ?)", a, b, now); err != nil {
+					return nil, fmt.Errorf("failed to record merge of image IDs %d and %d: %w", a, b, err)
+				}
+			}
+		}
+		merged = append(merged, group...)
+	}
+	sort.Ints(merged)
+	if err := setSimilarGroup(tx, merged); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to merge similar groups: %w", err)
+	}
+	return merged, assignSimilarGroupIDs(db)
+}
+
+// SplitSimilarGroup takes the images of ids out of their similar groups and
+// remembers the split. Images split from the same group stay together.
+func SplitSimilarGroup(ids []int) error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	splitting := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		splitting[id] = true
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	done := make(map[int]bool)
+	now := time.Now().UTC()
+	for _, id := range ids {
+		if done[id] {
+			continue
+		}
+		group, err := similarGroupOf(db, id)
+		if err != nil {
+			return err
+		}
+		if len(group) < 2 {
+			return fmt.Errorf("image ID %d is not in a similar group", id)
+		}
+		var kept, out []int
+		for _, member := range group {
+			if splitting[member] {
+				out = append(out, member)
+				done[member] = true
+			} else {
+				kept = append(kept, member)
+			}
+		}
+		if len(kept) == 0 {
+			return fmt.Errorf("cannot split every image out of the group of image ID %d", id)
+		}
+		for _, member := range out {
+			for _, other := range kept {
+				if _, err := tx.Exec("DELETE FROM similar_corrections WHERE (image_id = ? AND other_id = ?) OR (image_id = ? AND other_id = ?)", member, other, other, member); err != nil {
+					return fmt.Errorf("failed to update similar group corrections: %w", err)
+				}
+				if _, err := tx.Exec("INSERT INTO similar_corrections (image_id, other_id, apart, created_at) VALUES (?, ?, TRUE, ?)", member, other, now); err != nil {
+					return fmt.Errorf("failed to record split of image ID %d: %w", member, err)
+				}
+			}
+		}
+		if err := setSimilarGroup(tx, kept); err != nil {
+			return err
+		}
+		if err := setSimilarGroup(tx, out); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to split similar group: %w", err)
+	}
+	return assignSimilarGroupIDs(db)
+}
+
+// similarGroupOf returns the similar group of an image in the catalog, or the
+// image alone if it is in none.
+func similarGroupOf(db *sql.DB, id int) ([]int, error) {
+	var similarJSON sql.NullString
+	if err := db.QueryRow("SELECT similar_images FROM images WHERE id = ? AND is_recycled = FALSE", id).Scan(&similarJSON); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("image ID %d not found", id)
+		}
+		return nil, fmt.Errorf("failed to look up image ID %d: %w", id, err)
+	}
+	var group []int
+	if similarJSON.Valid && json.Unmarshal([]byte(similarJSON.String), &group) == nil && len(group) > 1 {
+		return group, nil
+	}
+	return []int{id}, nil
+}
+
+// setSimilarGroup stores group as the similar group of its members, or clears
+// it when fewer than two images are left. Pairs joined by hand have no distance.
+func setSimilarGroup(tx *sql.Tx, group []int) error {
+	return storeSimilarGroup(tx, group, nil)
+}
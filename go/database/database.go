@@ -1,46 +1,104 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
+	"path/filepath"
 	"picpurge/processor"
+	"runtime"
+	"strings"
 	"sync" // Import sync package
 	"time"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
 var (
-	dbInstance *sql.DB
-	once       sync.Once
-	initErr    error  // To store any error from the once.Do block
-	tempDBFile string // To store the temporary database file name for cleanup
+	dbInstance   *sql.DB
+	once         sync.Once
+	initErr      error  // To store any error from the once.Do block
+	tempDBFile   string // To store the temporary database file name for cleanup
+	dbPath       string // Persistent catalog path; empty means a temporary database
+	memoryConn   *sql.Conn
+	snapshotPath string // Where CloseDb saves a copy of the catalog; empty saves none
 )
 
+// MemoryPath is the catalog path that keeps the catalog in memory, for quick
+// one-off runs that leave no file behind unless SetSnapshotPath saves one.
+const MemoryPath = "memory"
+
+// memoryDBName names the in-memory catalog. The memdb VFS shares a database
+// whose name starts with a slash between the connections of the process, with
+// the usual locking; a plain :memory: database would be a different, empty
+// one on every connection of the pool. It lives while any connection is open.
+const memoryDBName = "/picpurge.db"
+
+// SetPath makes GetDBInstance open a persistent catalog at path, or keep it in
+// memory for MemoryPath, instead of a temporary file. It has no effect once
+// the database is open.
+func SetPath(path string) {
+	dbPath = path
+}
+
+// SetSnapshotPath makes CloseDb save a copy of the catalog to path, replacing
+// the file there, e.g. to keep the results of an in-memory catalog.
+func SetSnapshotPath(path string) {
+	snapshotPath = path
+}
+
+// catalogSource returns the data source name of the catalog with the given
+// URI parameters, e.g. mode=ro.
+func catalogSource(params ...string) string {
+	switch dbPath {
+	case MemoryPath:
+		return catalogDSN(memoryDBName, append(params, "vfs=memdb")...)
+	case "":
+		return catalogDSN(tempDBFile, params...)
+	}
+	return catalogDSN(dbPath, params...)
+}
+
 // GetDBInstance returns the singleton database connection.
 func GetDBInstance() (*sql.DB, error) {
 	once.Do(func() {
 		// This code will only be executed once
-		// Create a temporary file for the database
-		tempFile, err := ioutil.TempFile("", "picpurge_*.db")
-		if err != nil {
-			initErr = fmt.Errorf("failed to create temporary database file: %w", err)
-			return
-		}
-		tempFileName := tempFile.Name()
-		tempFile.Close() // Close the file so SQLite can use it
+		if dbPath == "" {
+			// Create a temporary file for the database
+			tempFile, err := ioutil.TempFile("", "picpurge_*.db")
+			if err != nil {
+				initErr = fmt.Errorf("failed to create temporary database file: %w", err)
+				return
+			}
+			tempFile.Close() // Close the file so SQLite can use it
 
-		// Store the temp file name for cleanup later
-		tempDBFile = tempFileName
+			// Store the temp file name for cleanup later
+			tempDBFile = tempFile.Name()
+		}
 
-		dbInstance, initErr = sql.Open("sqlite3", tempFileName)
+		dbInstance, initErr = sql.Open(driverName, catalogSource())
 		if initErr != nil {
 			initErr = fmt.Errorf("failed to open database: %w", initErr)
 			return // Exit the once.Do function
 		}
+		if dbPath == MemoryPath {
+			// The pool may close idle connections; this one keeps the catalog
+			memoryConn, initErr = dbInstance.Conn(context.Background())
+			if initErr != nil {
+				initErr = fmt.Errorf("failed to open database: %w", initErr)
+				return
+			}
+		}
+
+		// Write-ahead logging keeps committed batches intact if the process dies
+		// mid-write, and lets the web interface read while a scan writes. It is
+		// a setting of the file, so unlike the busy timeout it is set once here.
+		if _, err := dbInstance.Exec("PRAGMA journal_mode=WAL"); err != nil {
+			log.Printf("ConnectDb: Could not enable WAL journal: %v", err)
+		}
 
 		// Create the images table if it doesn't exist
 		createTableSQL := `
@@ -49,19 +107,46 @@ func GetDBInstance() (*sql.DB, error) {
 			file_path TEXT NOT NULL UNIQUE,
 			file_name TEXT NOT NULL,
 			file_size INTEGER,
-			md5 TEXT,
+			file_mod_time INTEGER, -- modification time in Unix nanoseconds when the file was hashed
+			md5 TEXT, -- content hash; hex MD5, or hex prefixed with its algorithm, e.g. sha256:...
+			previous_md5 TEXT, -- content hash before the file last changed on disk
 			image_width INTEGER,
 			image_height INTEGER,
 			device_make TEXT,
 			device_model TEXT,
 			lens_model TEXT,
+			camera_serial TEXT,
+			shutter_count INTEGER,
 			create_date DATETIME,
+			date_source TEXT, -- exif, metadata, filename, estimated or modtime
+			exposure_bias REAL, -- EV compensation, NULL when unknown
 			phash TEXT,
+			turned_phashes TEXT, -- comma-separated pHashes of the image turned and mirrored
+			dhash TEXT,
+			ahash TEXT,
+			whash TEXT,
+			left_edge_hash TEXT,
+			right_edge_hash TEXT,
+			palette TEXT, -- JSON array of the most common colours as #rrggbb, dominant first
 			thumbnail_path TEXT,
 			is_duplicate BOOLEAN DEFAULT FALSE,
 			duplicate_of INTEGER,
 			similar_images TEXT, -- JSON array of image IDs
-			is_recycled BOOLEAN DEFAULT FALSE
+			similar_group TEXT, -- ID of the similar group that survives re-analysis, see AssignSimilarGroupIDs
+			same_shot_of INTEGER, -- ID of the image this one is a copy/re-encode of
+			bracket_set INTEGER, -- ID of the first frame of the exposure bracket set this image belongs to
+			panorama_set INTEGER, -- ID of the first frame of the panorama sweep this image belongs to
+			is_screenshot BOOLEAN DEFAULT FALSE,
+			is_document BOOLEAN DEFAULT FALSE, -- looks like a photographed document or receipt
+			low_info TEXT, -- solid, dark or bright for shots with almost no content
+			messenger TEXT, -- whatsapp, telegram or signal for media saved from a chat app
+			edited_with TEXT, -- editing app named by the EXIF Software tag, NULL for camera originals
+			duration REAL, -- length of a video in seconds, NULL for images
+			format TEXT, -- format found in the file's content, e.g. jpeg or heic, NULL when not recognized
+			is_recycled BOOLEAN DEFAULT FALSE,
+			recycled_at DATETIME, -- when the file was recycled, NULL if recycled before this was recorded
+			is_favorite BOOLEAN DEFAULT FALSE,
+			rating INTEGER -- 1 to 5 stars, NULL when unrated
 		);
 		`
 		_, initErr = dbInstance.Exec(createTableSQL)
@@ -69,7 +154,127 @@ func GetDBInstance() (*sql.DB, error) {
 			initErr = fmt.Errorf("failed to create images table: %w", initErr)
 			return // Exit the once.Do function
 		}
+		initErr = addMissingColumns(dbInstance, "images", addedImageColumns)
+		if initErr != nil {
+			initErr = fmt.Errorf("failed to upgrade images table: %w", initErr)
+			return
+		}
+		// After the upgrade, as older catalogs lack some indexed columns
+		_, initErr = dbInstance.Exec(createImageIndexesSQL)
+		if initErr != nil {
+			initErr = fmt.Errorf("failed to create images indexes: %w", initErr)
+			return
+		}
 		log.Println("ConnectDb: Images table created/ensured.")
+
+		_, initErr = dbInstance.Exec(createPhasesTableSQL)
+		if initErr != nil {
+			initErr = fmt.Errorf("failed to create run_phases table: %w", initErr)
+			return
+		}
+		initErr = addMissingColumns(dbInstance, "run_phases", addedPhaseColumns)
+		if initErr != nil {
+			initErr = fmt.Errorf("failed to upgrade run_phases table: %w", initErr)
+			return
+		}
+
+		_, initErr = dbInstance.Exec(createGroupReviewsTableSQL)
+		if initErr != nil {
+			initErr = fmt.Errorf("failed to create group_reviews table: %w", initErr)
+			return
+		}
+
+		_, initErr = dbInstance.Exec(createThumbnailsTableSQL)
+		if initErr != nil {
+			initErr = fmt.Errorf("failed to create thumbnails table: %w", initErr)
+			return
+		}
+
+		_, initErr = dbInstance.Exec(createScanRecoveryTableSQL)
+		if initErr != nil {
+			initErr = fmt.Errorf("failed to create scan_recovery table: %w", initErr)
+			return
+		}
+
+		_, initErr = dbInstance.Exec(createScanCheckpointTableSQL)
+		if initErr != nil {
+			initErr = fmt.Errorf("failed to create scan_checkpoint table: %w", initErr)
+			return
+		}
+
+		_, initErr = dbInstance.Exec(createOCRTextTableSQL)
+		if initErr != nil {
+			initErr = fmt.Errorf("failed to create ocr_text table: %w", initErr)
+			return
+		}
+
+		_, initErr = dbInstance.Exec(createImageTagsTableSQL)
+		if initErr != nil {
+			initErr = fmt.Errorf("failed to create image_tags table: %w", initErr)
+			return
+		}
+
+		_, initErr = dbInstance.Exec(createRawConversionsTableSQL)
+		if initErr != nil {
+			initErr = fmt.Errorf("failed to create raw_conversions table: %w", initErr)
+			return
+		}
+
+		_, initErr = dbInstance.Exec(createScanRootsTableSQL)
+		if initErr != nil {
+			initErr = fmt.Errorf("failed to create scan_roots table: %w", initErr)
+			return
+		}
+
+		_, initErr = dbInstance.Exec(createSignaturesTableSQL)
+		if initErr != nil {
+			initErr = fmt.Errorf("failed to create similarity_signatures table: %w", initErr)
+			return
+		}
+
+		_, initErr = dbInstance.Exec(createOperationsTableSQL)
+		if initErr != nil {
+			initErr = fmt.Errorf("failed to create operations tables: %w", initErr)
+			return
+		}
+
+		_, initErr = dbInstance.Exec(createSimilarCorrectionsTableSQL)
+		if initErr != nil {
+			initErr = fmt.Errorf("failed to create similar_corrections table: %w", initErr)
+			return
+		}
+
+		_, initErr = dbInstance.Exec(createSimilarPairsTableSQL)
+		if initErr != nil {
+			initErr = fmt.Errorf("failed to create similar_pairs table: %w", initErr)
+			return
+		}
+		if initErr = fillSimilarPairs(dbInstance); initErr != nil {
+			return
+		}
+
+		_, initErr = dbInstance.Exec(createPDFPagesTableSQL)
+		if initErr != nil {
+			initErr = fmt.Errorf("failed to create pdf_pages table: %w", initErr)
+			return
+		}
+
+		_, initErr = dbInstance.Exec(createPHashIndexTableSQL)
+		if initErr != nil {
+			initErr = fmt.Errorf("failed to create phash_index table: %w", initErr)
+			return
+		}
+
+		if initErr = createReportViews(dbInstance); initErr != nil {
+			return
+		}
+
+		// FTS5 needs the sqlite_fts5 build tag with cgo; without it search falls back to LIKE scans.
+		if err := createSearchIndex(dbInstance); err == nil {
+			ftsEnabled = true
+		} else {
+			log.Printf("ConnectDb: WARNING: FTS5 unavailable (%v), search falls back to slow LIKE scans. Build with -tags sqlite_fts5 to index it.", err)
+		}
 		log.Println("ConnectDb: Database connected and schema ensured.")
 	})
 
@@ -79,13 +284,94 @@ func GetDBInstance() (*sql.DB, error) {
 	return dbInstance, nil
 }
 
+// createImageIndexesSQL indexes the images columns that duplicate and
+// similarity lookups and every listing filter on.
+const createImageIndexesSQL = `
+CREATE INDEX IF NOT EXISTS idx_images_md5 ON images(md5);
+CREATE INDEX IF NOT EXISTS idx_images_phash ON images(phash);
+CREATE INDEX IF NOT EXISTS idx_images_is_duplicate ON images(is_duplicate);
+CREATE INDEX IF NOT EXISTS idx_images_is_recycled ON images(is_recycled);
+CREATE INDEX IF NOT EXISTS idx_images_similar_group ON images(similar_group);
+`
+
+// addedImageColumns lists images columns added after persistent catalogs were
+// introduced, in the order they were added.
+var addedImageColumns = []addedColumn{
+	{"dhash", "TEXT"},
+	{"date_source", "TEXT"},
+	{"palette", "TEXT"},
+	{"low_info", "TEXT"},
+	{"previous_md5", "TEXT"},
+	{"rating", "INTEGER"},
+	{"messenger", "TEXT"},
+	{"duration", "REAL"},
+	{"format", "TEXT"},
+	{"recycled_at", "DATETIME"},
+	{"edited_with", "TEXT"},
+	{"turned_phashes", "TEXT"},
+	{"ahash", "TEXT"},
+	{"whash", "TEXT"},
+	{"is_document", "BOOLEAN DEFAULT FALSE"},
+	{"similar_group", "TEXT"},
+}
+
+// addedColumn is a column added to a table after catalogs were first persisted.
+type addedColumn struct{ name, definition string }
+
+// addMissingColumns upgrades a table created by an older version in place.
+func addMissingColumns(db *sql.DB, table string, columns []addedColumn) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, column := range columns {
+		if existing[column.name] {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column.name, column.definition)); err != nil {
+			return err
+		}
+		log.Printf("ConnectDb: Added %s.%s column.", table, column.name)
+	}
+	return nil
+}
+
 // CloseDb closes the database connection and removes the temporary file.
 func CloseDb() error {
+	var snapshotErr error
+	if dbInstance != nil && snapshotPath != "" {
+		snapshotErr = saveSnapshot(dbInstance, snapshotPath)
+	}
+	if reportDB != nil {
+		reportDB.Close()
+		reportDB = nil
+	}
+	if memoryConn != nil {
+		memoryConn.Close()
+		memoryConn = nil
+	}
 	if dbInstance != nil {
 		if err := dbInstance.Close(); err != nil {
 			return fmt.Errorf("failed to close database: %w", err)
 		}
 		dbInstance = nil // Clear the instance after closing
+		phashIndexCache = nil
 	}
 
 	// Remove the temporary database file if it exists
@@ -97,43 +383,254 @@ func CloseDb() error {
 		}
 		tempDBFile = "" // Clear the file name
 	}
+	return snapshotErr
+}
+
+// saveSnapshot writes a compacted copy of the catalog to path. The copy is
+// written next to it first, so a failed save leaves the file there intact.
+func saveSnapshot(db *sql.DB, path string) error {
+	saving := path + ".saving"
+	os.Remove(saving) // VACUUM INTO refuses to overwrite a file
+	target := saving
+	if dbPath == MemoryPath {
+		// VACUUM INTO writes through the VFS of the catalog, which would keep the copy in memory too
+		absPath, err := filepath.Abs(saving)
+		if err != nil {
+			return fmt.Errorf("failed to save catalog to %s: %w", path, err)
+		}
+		diskVFS := "unix"
+		if runtime.GOOS == "windows" {
+			diskVFS = "win32"
+		}
+		absPath = filepath.ToSlash(absPath)
+		if !strings.HasPrefix(absPath, "/") {
+			absPath = "/" + absPath // file:/C:/... on Windows
+		}
+		target = "file:" + (&url.URL{Path: absPath}).EscapedPath() + "?vfs=" + diskVFS
+	}
+	if _, err := db.Exec("VACUUM INTO ?", target); err != nil {
+		os.Remove(saving)
+		return fmt.Errorf("failed to save catalog to %s: %w", path, err)
+	}
+	if err := os.Rename(saving, path); err != nil {
+		os.Remove(saving)
+		return fmt.Errorf("failed to save catalog to %s: %w", path, err)
+	}
+	log.Printf("Saved the catalog to %s.\n", path)
 	return nil
 }
 
-// InsertImage inserts image metadata into the database.
+// insertImageSQL adds an image, or refreshes its metadata when the path is
+// already in the catalog (e.g. a file that changed since an earlier run). When
+// the content changed, the old hash is kept in previous_md5; a hash that only
+// changed algorithm says nothing about the content and is not. A file found again
+// at the path of a recycled or deleted image is active again.
+const insertImageSQL = `
+	INSERT INTO images (
+		file_path, file_name, file_size, file_mod_time, md5, image_width, image_height,
+		device_make, device_model, lens_model, camera_serial, shutter_count,
+		create_date, date_source, exposure_bias, phash, dhash, left_edge_hash, right_edge_hash, palette, thumbnail_path, is_screenshot, low_info, messenger, duration, format, edited_with, turned_phashes, ahash, whash, is_document
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(file_path) DO UPDATE SET
+		file_name = excluded.file_name, file_size = excluded.file_size, file_mod_time = excluded.file_mod_time,
+		previous_md5 = CASE WHEN images.md5 != excluded.md5
+			AND substr(images.md5, 1, instr(images.md5, ':')) = substr(excluded.md5, 1, instr(excluded.md5, ':'))
+			THEN images.md5 ELSE images.previous_md5 END,
+		md5 = excluded.md5, image_width = excluded.image_width, image_height = excluded.image_height,
+		device_make = excluded.device_make, device_model = excluded.device_model, lens_model = excluded.lens_model,
+		camera_serial = excluded.camera_serial, shutter_count = excluded.shutter_count,
+		create_date = excluded.create_date, date_source = excluded.date_source, exposure_bias = excluded.exposure_bias, phash = excluded.phash, dhash = excluded.dhash,
+		left_edge_hash = excluded.left_edge_hash, right_edge_hash = excluded.right_edge_hash, palette = excluded.palette,
+		thumbnail_path = excluded.thumbnail_path, is_screenshot = excluded.is_screenshot, low_info = excluded.low_info,
+		messenger = excluded.messenger, duration = excluded.duration, format = excluded.format,
+		edited_with = excluded.edited_with, turned_phashes = excluded.turned_phashes,
+		ahash = excluded.ahash, whash = excluded.whash, is_document = excluded.is_document, is_recycled = FALSE
+`
+
+// InsertImage inserts image metadata into the database in a transaction of its
+// own. To store many images, such as the results of a scan, use CommitScanBatch,
+// which writes a whole batch in one transaction with prepared statements.
 func InsertImage(imageData *processor.ImageData) error {
 	db, err := GetDBInstance() // Get the singleton instance
 	if err != nil {
 		return err
 	}
 
-	stmt, err := db.Prepare(`
-		INSERT OR IGNORE INTO images (
-			file_path, file_name, file_size, md5, image_width, image_height,
-			device_make, device_model, lens_model, create_date, phash, thumbnail_path
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`)
+	stmt, err := db.Prepare(insertImageSQL)
 	if err != nil {
 		return fmt.Errorf("failed to prepare insert statement: %w", err)
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(
+	return execInsertImage(stmt, imageData)
+}
+
+func execInsertImage(stmt *sql.Stmt, imageData *processor.ImageData) error {
+	_, err := stmt.Exec(
 		imageData.FilePath,
 		imageData.FileName,
 		imageData.FileSize,
+		imageData.ModTime.UnixNano(),
 		imageData.MD5,
 		imageData.ImageWidth,
 		imageData.ImageHeight,
 		imageData.DeviceMake,
 		imageData.DeviceModel,
 		imageData.LensModel,
+		imageData.CameraSerial,
+		imageData.ShutterCount,
 		imageData.CreateDate.Format(time.RFC3339), // Format time for DATETIME column
+		imageData.DateSource,
+		imageData.ExposureBias,
 		imageData.PHash,
+		imageData.DHash,
+		imageData.LeftEdgeHash,
+		imageData.RightEdgeHash,
+		paletteJSON(imageData.Palette),
 		imageData.ThumbnailPath,
+		imageData.IsScreenshot,
+		nullIfEmpty(imageData.LowInfo),
+		nullIfEmpty(imageData.Messenger),
+		nullIfZero(imageData.Duration),
+		nullIfEmpty(imageData.Format),
+		nullIfEmpty(imageData.EditedWith),
+		nullIfEmpty(imageData.TurnedPHashes),
+		nullIfEmpty(imageData.AHash),
+		nullIfEmpty(imageData.WHash),
+		imageData.IsDocument,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to execute insert statement: %w", err)
 	}
 	return nil
 }
+
+// paletteJSON encodes a palette for the palette column, or NULL when there is none.
+func paletteJSON(palette []string) interface{} {
+	if len(palette) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(palette)
+	if err != nil {
+		return nil
+	}
+	return string(data)
+}
+
+// nullIfEmpty stores an empty string as NULL.
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// nullIfZero stores a zero measurement, such as the duration of an image, as NULL.
+func nullIfZero(value float64) interface{} {
+	if value == 0 {
+		return nil
+	}
+	return value
+}
+
+// SetFavorite marks or unmarks an image as favorite.
+func SetFavorite(id int, favorite bool) error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	result, err := db.Exec("UPDATE images SET is_favorite = ? WHERE id = ?", favorite, id)
+	if err != nil {
+		return fmt.Errorf("failed to update favorite flag: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("image %d not found", id)
+	}
+	return nil
+}
+
+// UpdateImageContent stores freshly extracted content metadata for an image whose
+// file changed on disk since it was scanned, keeping the old hash in previous_md5.
+func UpdateImageContent(id int, imageData *processor.ImageData) error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		UPDATE images SET file_size = ?, file_mod_time = ?,
+			previous_md5 = CASE WHEN md5 != ? THEN md5 ELSE previous_md5 END, md5 = ?, image_width = ?, image_height = ?,
+			phash = ?, turned_phashes = ?, dhash = ?, ahash = ?, whash = ?, left_edge_hash = ?, right_edge_hash = ?, palette = ?, thumbnail_path = ?, low_info = ?, is_document = ?
+		WHERE id = ?
+	`,
+		imageData.FileSize,
+		imageData.ModTime.UnixNano(),
+		imageData.MD5,
+		imageData.MD5,
+		imageData.ImageWidth,
+		imageData.ImageHeight,
+		imageData.PHash,
+		nullIfEmpty(imageData.TurnedPHashes),
+		imageData.DHash,
+		nullIfEmpty(imageData.AHash),
+		nullIfEmpty(imageData.WHash),
+		imageData.LeftEdgeHash,
+		imageData.RightEdgeHash,
+		paletteJSON(imageData.Palette),
+		imageData.ThumbnailPath,
+		nullIfEmpty(imageData.LowInfo),
+		imageData.IsDocument,
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update image content: %w", err)
+	}
+	return nil
+}
+
+// RecordRewrittenFile stores the new hash, size and modification time of an image
+// whose file was rewritten without changing its pixels, e.g. after fixing its
+// EXIF date. The old hash is kept in previous_md5 and the thumbnail follows the
+// new one.
+func RecordRewrittenFile(id int, md5 string, size int64, modTime time.Time) error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var oldMD5, thumbnailPath string
+	if err := tx.QueryRow("SELECT md5, COALESCE(thumbnail_path, '') FROM images WHERE id = ?", id).Scan(&oldMD5, &thumbnailPath); err != nil {
+		return fmt.Errorf("failed to look up image %d: %w", id, err)
+	}
+	if _, err := tx.Exec("INSERT OR IGNORE INTO thumbnails (md5, data) SELECT ?, data FROM thumbnails WHERE md5 = ?", md5, oldMD5); err != nil {
+		return fmt.Errorf("failed to copy thumbnail: %w", err)
+	}
+	if strings.HasPrefix(thumbnailPath, "memory://") {
+		thumbnailPath = "memory://" + md5
+	}
+	_, err = tx.Exec(`
+		UPDATE images SET previous_md5 = CASE WHEN md5 != ? THEN md5 ELSE previous_md5 END, md5 = ?,
+			file_size = ?, file_mod_time = ?, thumbnail_path = ?
+		WHERE id = ?
+	`, md5, md5, size, modTime.UnixNano(), thumbnailPath, id)
+	if err != nil {
+		return fmt.Errorf("failed to update image %d: %w", id, err)
+	}
+	return tx.Commit()
+}
+
+// SetFileModTime records that an image file was verified unchanged at the given modification time.
+func SetFileModTime(id int, modTime time.Time) error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec("UPDATE images SET file_mod_time = ? WHERE id = ?", modTime.UnixNano(), id); err != nil {
+		return fmt.Errorf("failed to update file modification time: %w", err)
+	}
+	return nil
+}
@@ -1,7 +1,20 @@
 package database
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"picpurge/grouping"
+	"picpurge/processor"
 )
 
 func TestGetDBInstance(t *testing.T) {
@@ -22,6 +35,1469 @@ func TestGetDBInstance(t *testing.T) {
 	}
 }
 
+func TestConnectionSettings(t *testing.T) {
+	db, err := GetDBInstance()
+	if err != nil {
+		t.Fatalf("GetDBInstance failed: %v", err)
+	}
+	// Hold several connections at once, so each one is checked
+	var conns []*sql.Conn
+	for i := 0; i < 3; i++ {
+		conn, err := db.Conn(context.Background())
+		if err != nil {
+			t.Fatalf("Conn failed: %v", err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	for i, conn := range conns {
+		var journalMode string
+		var timeout int64
+		conn.QueryRowContext(context.Background(), "PRAGMA journal_mode").Scan(&journalMode)
+		conn.QueryRowContext(context.Background(), "PRAGMA busy_timeout").Scan(&timeout)
+		if journalMode != "wal" || timeout != busyTimeout.Milliseconds() {
+			t.Errorf("Connection %d: journal_mode = %q, busy_timeout = %d", i, journalMode, timeout)
+		}
+	}
+
+	var detail string
+	var id, parent, notUsed int
+	for _, query := range []string{
+		"SELECT id FROM images WHERE md5 = 'x'",
+		"SELECT id FROM images WHERE phash = 'x'",
+		"SELECT id FROM images WHERE is_duplicate = TRUE",
+		"SELECT id FROM images WHERE is_recycled = TRUE",
+	} {
+		if err := db.QueryRow("EXPLAIN QUERY PLAN "+query).Scan(&id, &parent, &notUsed, &detail); err != nil {
+			t.Fatalf("EXPLAIN failed: %v", err)
+		}
+		if !strings.Contains(detail, "INDEX idx_images_") {
+			t.Errorf("%s: plan %q does not use an index", query, detail)
+		}
+	}
+}
+
+func TestPhases(t *testing.T) {
+	if err := ResetPhases(); err != nil {
+		t.Fatalf("ResetPhases failed: %v", err)
+	}
+	if err := StartPhase(PhaseWalk, 10); err != nil {
+		t.Fatalf("StartPhase failed: %v", err)
+	}
+	if err := UpdatePhaseProgress(PhaseWalk, 4); err != nil {
+		t.Fatalf("UpdatePhaseProgress failed: %v", err)
+	}
+
+	current, err := CurrentPhase()
+	if err != nil {
+		t.Fatalf("CurrentPhase failed: %v", err)
+	}
+	if current != PhaseWalk {
+		t.Errorf("CurrentPhase = %s; expected %s", current, PhaseWalk)
+	}
+
+	if err := FinishPhase(PhaseWalk, nil); err != nil {
+		t.Fatalf("FinishPhase failed: %v", err)
+	}
+	current, err = CurrentPhase()
+	if err != nil {
+		t.Fatalf("CurrentPhase failed: %v", err)
+	}
+	if current != PhaseHash {
+		t.Errorf("CurrentPhase = %s; expected %s", current, PhaseHash)
+	}
+
+	phases, err := GetPhases()
+	if err != nil {
+		t.Fatalf("GetPhases failed: %v", err)
+	}
+	if len(phases) != len(Phases) {
+		t.Fatalf("GetPhases returned %d phases; expected %d", len(phases), len(Phases))
+	}
+	if phases[0].Status != PhaseStatusDone || phases[0].Done != 4 || phases[0].StartedAt == nil {
+		t.Errorf("Unexpected walk checkpoint: %+v", phases[0])
+	}
+
+	summary := RunSummary{FilesWalked: 4, DuplicateGroups: 1, ReclaimableBytes: 2048}
+	if err := SetPhaseSummary(PhaseWalk, summary); err != nil {
+		t.Fatalf("SetPhaseSummary failed: %v", err)
+	}
+	if phases, err = GetPhases(); err != nil {
+		t.Fatalf("GetPhases failed: %v", err)
+	}
+	if phases[0].Summary == nil || *phases[0].Summary != summary || phases[1].Summary != nil {
+		t.Errorf("Summaries = %+v, %+v; expected %+v on the walk phase only", phases[0].Summary, phases[1].Summary, summary)
+	}
+}
+
+func TestGroupReviews(t *testing.T) {
+	review := GroupReview{GroupType: GroupTypeDuplicates, GroupKey: "abc", Status: ReviewKeepAll, Notes: "burst"}
+	if err := SetGroupReview(review); err != nil {
+		t.Fatalf("SetGroupReview failed: %v", err)
+	}
+	review.Status = ReviewResolved
+	if err := SetGroupReview(review); err != nil {
+		t.Fatalf("SetGroupReview update failed: %v", err)
+	}
+
+	reviews, err := GetGroupReviews(GroupTypeDuplicates)
+	if err != nil {
+		t.Fatalf("GetGroupReviews failed: %v", err)
+	}
+	if got := reviews["abc"]; got.Status != ReviewResolved || got.Notes != "burst" {
+		t.Errorf("Unexpected review: %+v", got)
+	}
+
+	if err := SetGroupReview(GroupReview{GroupType: GroupTypeDuplicates, GroupKey: "abc", Status: "bogus"}); err == nil {
+		t.Error("SetGroupReview should reject unknown status")
+	}
+}
+
+func TestSearchImages(t *testing.T) {
+	image := &processor.ImageData{FilePath: "/photos/trip/Screenshot_boarding.png", FileName: "Screenshot_boarding.png", MD5: "search"}
+	if err := InsertImage(image); err != nil {
+		t.Fatalf("InsertImage failed: %v", err)
+	}
+	db, err := GetDBInstance()
+	if err != nil {
+		t.Fatalf("GetDBInstance failed: %v", err)
+	}
+	var id int
+	if err := db.QueryRow("SELECT id FROM images WHERE file_path = ?", image.FilePath).Scan(&id); err != nil {
+		t.Fatalf("Failed to look up inserted image: %v", err)
+	}
+	if err := SetOCRText(id, "Boarding pass gate B12 seat 14A"); err != nil {
+		t.Fatalf("SetOCRText failed: %v", err)
+	}
+	if err := AddImageTag(id, TagKindAlbum, "Lisbon weekend"); err != nil {
+		t.Fatalf("AddImageTag failed: %v", err)
+	}
+	// Recycled images are not found
+	recycled := &processor.ImageData{FilePath: "/photos/trip/Screenshot_boarding copy.png", FileName: "Screenshot_boarding copy.png", MD5: "search-recycled"}
+	if err := InsertImage(recycled); err != nil {
+		t.Fatalf("InsertImage failed: %v", err)
+	}
+	if _, err := db.Exec("UPDATE images SET is_recycled = TRUE WHERE file_path = ?", recycled.FilePath); err != nil {
+		t.Fatalf("Failed to recycle image: %v", err)
+	}
+
+	for _, query := range []string{"trip", "oardin", `"boarding pass"`, "gate 14A", "lisbon"} {
+		ids, err := SearchImages(query)
+		if err != nil {
+			t.Fatalf("SearchImages(%q) failed: %v", query, err)
+		}
+		if len(ids) != 1 || ids[0] != id {
+			t.Errorf("SearchImages(%q) = %v; expected [%d]", query, ids, id)
+		}
+	}
+	if ids, _ := SearchImages(`"pass boarding"`); len(ids) != 0 {
+		t.Errorf("Phrase search matched out-of-order words: %v", ids)
+	}
+
+	if !FTSEnabled() {
+		return
+	}
+	// Images catalogued by a build without FTS5, or indexed without their tags
+	// by an older version, are indexed when the catalog is opened
+	if _, err := db.Exec(dropSearchIndexSQL); err != nil {
+		t.Fatalf("Failed to drop the search index: %v", err)
+	}
+	if _, err := db.Exec("CREATE VIRTUAL TABLE image_search USING fts5(file_name, file_path, ocr_text, tokenize = 'trigram')"); err != nil {
+		t.Fatalf("Failed to create an old search index: %v", err)
+	}
+	if err := createSearchIndex(db); err != nil {
+		t.Fatalf("createSearchIndex failed: %v", err)
+	}
+	var images, indexed int
+	db.QueryRow("SELECT COUNT(*) FROM images").Scan(&images)
+	db.QueryRow("SELECT COUNT(*) FROM image_search").Scan(&indexed)
+	if images == 0 || indexed != images {
+		t.Errorf("search index holds %d of %d images", indexed, images)
+	}
+	for _, query := range []string{"gate 14A", "lisbon"} {
+		if ids, err := SearchImages(query); err != nil || len(ids) != 1 || ids[0] != id {
+			t.Errorf("SearchImages(%q) after filling the index = %v, %v; expected [%d]", query, ids, err, id)
+		}
+	}
+}
+
+func TestMarkRecycledUpdatesGroups(t *testing.T) {
+	db, err := GetDBInstance()
+	if err != nil {
+		t.Fatalf("GetDBInstance failed: %v", err)
+	}
+	ids := make([]int, 3)
+	for i, name := range []string{"dup_a.jpg", "dup_b.jpg", "dup_c.jpg"} {
+		image := &processor.ImageData{FilePath: "/photos/groups/" + name, FileName: name, MD5: "groups"}
+		if err := InsertImage(image); err != nil {
+			t.Fatalf("InsertImage failed: %v", err)
+		}
+		if err := db.QueryRow("SELECT id FROM images WHERE file_path = ?", image.FilePath).Scan(&ids[i]); err != nil {
+			t.Fatalf("Failed to look up inserted image: %v", err)
+		}
+	}
+	if _, err := db.Exec("UPDATE images SET is_duplicate = TRUE, duplicate_of = ? WHERE id IN (?, ?)", ids[0], ids[1], ids[2]); err != nil {
+		t.Fatalf("Failed to mark duplicates: %v", err)
+	}
+	if err := StoreSimilarGroups([][]int{{ids[0], ids[2]}}, nil); err != nil {
+		t.Fatalf("StoreSimilarGroups failed: %v", err)
+	}
+
+	if err := MarkRecycled("/photos/groups/dup_a.jpg"); err != nil {
+		t.Fatalf("MarkRecycled failed: %v", err)
+	}
+
+	var isDuplicate bool
+	var duplicateOf sql.NullInt64
+	if err := db.QueryRow("SELECT is_duplicate, duplicate_of FROM images WHERE id = ?", ids[1]).Scan(&isDuplicate, &duplicateOf); err != nil {
+		t.Fatalf("Failed to read promoted image: %v", err)
+	}
+	if isDuplicate || duplicateOf.Valid {
+		t.Errorf("Oldest remaining copy was not promoted to original")
+	}
+	if err := db.QueryRow("SELECT duplicate_of FROM images WHERE id = ?", ids[2]).Scan(&duplicateOf); err != nil {
+		t.Fatalf("Failed to read remaining duplicate: %v", err)
+	}
+	if int(duplicateOf.Int64) != ids[1] {
+		t.Errorf("duplicate_of = %d; expected %d", duplicateOf.Int64, ids[1])
+	}
+	var similar sql.NullString
+	if err := db.QueryRow("SELECT similar_images FROM images WHERE id = ?", ids[2]).Scan(&similar); err != nil {
+		t.Fatalf("Failed to read similar images: %v", err)
+	}
+	if similar.Valid {
+		t.Errorf("similar_images = %q; expected NULL", similar.String)
+	}
+}
+
+func TestRegroupImage(t *testing.T) {
+	db, err := GetDBInstance()
+	if err != nil {
+		t.Fatalf("GetDBInstance failed: %v", err)
+	}
+	images := []*processor.ImageData{
+		{FilePath: "/photos/regroup/a.jpg", FileName: "a.jpg", MD5: "regroup-old", PHash: "p:ff00ff00ff00ff00"},
+		{FilePath: "/photos/regroup/b.jpg", FileName: "b.jpg", MD5: "regroup-old", PHash: "p:ff00ff00ff00ff00"},
+		{FilePath: "/photos/regroup/c.jpg", FileName: "c.jpg", MD5: "regroup-new", PHash: "p:0123456789abcdef"},
+	}
+	ids := make([]int, len(images))
+	for i, image := range images {
+		image.ImageWidth, image.ImageHeight = 400, 300
+		if err := InsertImage(image); err != nil {
+			t.Fatalf("InsertImage failed: %v", err)
+		}
+		if err := db.QueryRow("SELECT id FROM images WHERE file_path = ?", image.FilePath).Scan(&ids[i]); err != nil {
+			t.Fatalf("Failed to look up inserted image: %v", err)
+		}
+	}
+	if _, err := db.Exec("UPDATE images SET is_duplicate = TRUE, duplicate_of = ? WHERE id = ?", ids[0], ids[1]); err != nil {
+		t.Fatalf("Failed to mark duplicates: %v", err)
+	}
+	if err := StoreSimilarGroups([][]int{{ids[0], ids[1]}}, nil); err != nil {
+		t.Fatalf("StoreSimilarGroups failed: %v", err)
+	}
+
+	// a.jpg was replaced by a near copy of c.jpg
+	edited := *images[0]
+	edited.MD5, edited.PHash = "regroup-edited", "p:0123456789abcdee"
+	if err := InsertImage(&edited); err != nil {
+		t.Fatalf("InsertImage failed: %v", err)
+	}
+	if err := RegroupImage(ids[0], "regroup-old"); err != nil {
+		t.Fatalf("RegroupImage failed: %v", err)
+	}
+
+	var isDuplicate bool
+	var similar sql.NullString
+	if err := db.QueryRow("SELECT is_duplicate, similar_images FROM images WHERE id = ?", ids[1]).Scan(&isDuplicate, &similar); err != nil {
+		t.Fatalf("Failed to read b.jpg: %v", err)
+	}
+	if isDuplicate || similar.Valid {
+		t.Errorf("b.jpg is_duplicate = %v, similar_images = %q; expected an ungrouped original", isDuplicate, similar.String)
+	}
+	expected := fmt.Sprintf("[%d,%d]", ids[0], ids[2])
+	for _, id := range []int{ids[0], ids[2]} {
+		if err := db.QueryRow("SELECT similar_images FROM images WHERE id = ?", id).Scan(&similar); err != nil {
+			t.Fatalf("Failed to read similar images: %v", err)
+		}
+		if similar.String != expected {
+			t.Errorf("similar_images of image ID %d = %q; expected %s", id, similar.String, expected)
+		}
+	}
+}
+
+func TestInsertImageTracksContentChanges(t *testing.T) {
+	db, err := GetDBInstance()
+	if err != nil {
+		t.Fatalf("GetDBInstance failed: %v", err)
+	}
+	image := &processor.ImageData{FilePath: "/photos/edited.jpg", FileName: "edited.jpg", MD5: "before", ImageWidth: 10}
+	if err := InsertImage(image); err != nil {
+		t.Fatalf("InsertImage failed: %v", err)
+	}
+
+	readBack := func() (md5 string, previous sql.NullString, width int) {
+		if err := db.QueryRow("SELECT md5, previous_md5, image_width FROM images WHERE file_path = ?", image.FilePath).Scan(&md5, &previous, &width); err != nil {
+			t.Fatalf("Failed to read image: %v", err)
+		}
+		return
+	}
+
+	// Same content again keeps no previous hash
+	if err := InsertImage(image); err != nil {
+		t.Fatalf("InsertImage failed: %v", err)
+	}
+	if _, previous, _ := readBack(); previous.Valid {
+		t.Errorf("previous_md5 = %q for unchanged content; expected NULL", previous.String)
+	}
+
+	// Edited content replaces the metadata and remembers the old hash
+	image.MD5, image.ImageWidth = "after", 20
+	if err := InsertImage(image); err != nil {
+		t.Fatalf("InsertImage failed: %v", err)
+	}
+	md5, previous, width := readBack()
+	if md5 != "after" || previous.String != "before" || width != 20 {
+		t.Errorf("Got md5=%q previous_md5=%q width=%d; expected after, before, 20", md5, previous.String, width)
+	}
+
+	// A rescan of the edited file does not forget the change
+	if err := InsertImage(image); err != nil {
+		t.Fatalf("InsertImage failed: %v", err)
+	}
+	if _, previous, _ := readBack(); previous.String != "before" {
+		t.Errorf("previous_md5 = %q after rescanning; expected before", previous.String)
+	}
+
+	var id int
+	if err := db.QueryRow("SELECT id FROM images WHERE file_path = ?", image.FilePath).Scan(&id); err != nil {
+		t.Fatalf("Failed to look up image: %v", err)
+	}
+	image.MD5 = "again"
+	if err := UpdateImageContent(id, image); err != nil {
+		t.Fatalf("UpdateImageContent failed: %v", err)
+	}
+	if md5, previous, _ := readBack(); md5 != "again" || previous.String != "after" {
+		t.Errorf("Got md5=%q previous_md5=%q after UpdateImageContent; expected again, after", md5, previous.String)
+	}
+}
+
+func TestCurationElectsDuplicateOriginal(t *testing.T) {
+	db, err := GetDBInstance()
+	if err != nil {
+		t.Fatalf("GetDBInstance failed: %v", err)
+	}
+	var ids []int
+	for _, name := range []string{"copy1.jpg", "copy2.jpg", "copy3.jpg"} {
+		image := &processor.ImageData{FilePath: "/curated/" + name, FileName: name, MD5: "curated"}
+		if err := InsertImage(image); err != nil {
+			t.Fatalf("InsertImage failed: %v", err)
+		}
+		var id int
+		if err := db.QueryRow("SELECT id FROM images WHERE file_path = ?", image.FilePath).Scan(&id); err != nil {
+			t.Fatalf("Failed to look up image: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if _, err := db.Exec("UPDATE images SET is_duplicate = TRUE, duplicate_of = ? WHERE id IN (?, ?)", ids[0], ids[1], ids[2]); err != nil {
+		t.Fatalf("Failed to mark duplicates: %v", err)
+	}
+	original := func() int {
+		var id int
+		if err := db.QueryRow("SELECT id FROM images WHERE md5 = 'curated' AND is_duplicate = FALSE").Scan(&id); err != nil {
+			t.Fatalf("Failed to look up original: %v", err)
+		}
+		return id
+	}
+
+	// A tagged copy beats an untagged one, a rated copy beats both
+	if err := AddImageTag(ids[1], TagKindAlbum, "Holiday 2009"); err != nil {
+		t.Fatalf("AddImageTag failed: %v", err)
+	}
+	if changed, err := ReelectDuplicateOriginals(); err != nil || changed != 1 || original() != ids[1] {
+		t.Fatalf("After tagging: changed=%d err=%v original=%d; expected 1 group, original %d", changed, err, original(), ids[1])
+	}
+	if err := RaiseRating(ids[2], 4); err != nil {
+		t.Fatalf("RaiseRating failed: %v", err)
+	}
+	if err := RaiseRating(ids[2], 2); err != nil {
+		t.Fatalf("RaiseRating failed: %v", err)
+	}
+	if _, err := ReelectDuplicateOriginals(); err != nil || original() != ids[2] {
+		t.Fatalf("After rating: err=%v original=%d; expected %d", err, original(), ids[2])
+	}
+	var rating int
+	db.QueryRow("SELECT rating FROM images WHERE id = ?", ids[2]).Scan(&rating)
+	if rating != 4 {
+		t.Errorf("Rating = %d after a lower import; expected 4", rating)
+	}
+
+	tags, err := GetImageTags()
+	if err != nil {
+		t.Fatalf("GetImageTags failed: %v", err)
+	}
+	if len(tags[ids[1]]) != 1 || tags[ids[1]][0] != (ImageTag{Kind: TagKindAlbum, Name: "Holiday 2009"}) {
+		t.Errorf("Tags of image %d = %v; expected the album", ids[1], tags[ids[1]])
+	}
+	if tags, err := GetImageTags(ids[0], ids[2]); err != nil || len(tags) != 0 {
+		t.Errorf("GetImageTags(%d, %d) = %v, %v; expected no tags", ids[0], ids[2], tags, err)
+	}
+}
+
+func TestMessengerUsage(t *testing.T) {
+	db, err := GetDBInstance()
+	if err != nil {
+		t.Fatalf("GetDBInstance failed: %v", err)
+	}
+	ids := make(map[string]int)
+	for _, path := range []string{"/camera/a.jpg", "/phone/WhatsApp Images/a.jpg", "/phone/WhatsApp Images/b.jpg", "/phone/Telegram/c.jpg"} {
+		image := &processor.ImageData{FilePath: path, FileName: filepath.Base(path), MD5: "messenger-" + path, FileSize: 100}
+		if err := InsertImage(image); err != nil {
+			t.Fatalf("InsertImage failed: %v", err)
+		}
+		var id int
+		if err := db.QueryRow("SELECT id FROM images WHERE file_path = ?", path).Scan(&id); err != nil {
+			t.Fatalf("Failed to look up image: %v", err)
+		}
+		ids[path] = id
+	}
+	// Messenger folders are classified even for images hashed before they were recognized
+	if _, err := db.Exec("UPDATE images SET messenger = NULL WHERE file_path LIKE '/phone/%'"); err != nil {
+		t.Fatalf("Failed to clear messengers: %v", err)
+	}
+	if changed, err := ClassifyMessengers(); err != nil || changed != 3 {
+		t.Fatalf("ClassifyMessengers = %d, %v; expected 3 images", changed, err)
+	}
+	group := fmt.Sprintf("[%d,%d]", ids["/camera/a.jpg"], ids["/phone/WhatsApp Images/a.jpg"])
+	if _, err := db.Exec("UPDATE images SET similar_images = ? WHERE id IN (?, ?)", group, ids["/camera/a.jpg"], ids["/phone/WhatsApp Images/a.jpg"]); err != nil {
+		t.Fatalf("Failed to group images: %v", err)
+	}
+
+	usage, err := GetMessengerUsage()
+	if err != nil {
+		t.Fatalf("GetMessengerUsage failed: %v", err)
+	}
+	expected := []MessengerUsage{
+		{Messenger: processor.MessengerWhatsApp, Files: 2, Bytes: 200, RedundantFiles: 1, RedundantBytes: 100},
+		{Messenger: processor.MessengerTelegram, Files: 1, Bytes: 100},
+	}
+	if len(usage) != len(expected) || usage[0] != expected[0] || usage[1] != expected[1] {
+		t.Errorf("GetMessengerUsage = %+v; expected %+v", usage, expected)
+	}
+}
+
+func TestCurationOrderPrefersOriginalNames(t *testing.T) {
+	db, err := GetDBInstance()
+	if err != nil {
+		t.Fatalf("GetDBInstance failed: %v", err)
+	}
+	for _, name := range []string{"IMG_0001 (1).JPG", "IMG_0001 copy 2.JPG", "IMG_0001 - Copy.JPG", "IMG_0001.JPG"} {
+		image := &processor.ImageData{FilePath: "/copies/" + name, FileName: name, MD5: "copy-names"}
+		if err := InsertImage(image); err != nil {
+			t.Fatalf("InsertImage failed: %v", err)
+		}
+	}
+	var first string
+	if err := db.QueryRow("SELECT file_name FROM images WHERE md5 = 'copy-names' ORDER BY " + CurationOrder + " LIMIT 1").Scan(&first); err != nil {
+		t.Fatalf("Failed to order copies: %v", err)
+	}
+	if first != "IMG_0001.JPG" {
+		t.Errorf("First copy = %q; expected IMG_0001.JPG", first)
+	}
+}
+
+func TestRawConversionQueue(t *testing.T) {
+	db, err := GetDBInstance()
+	if err != nil {
+		t.Fatalf("GetDBInstance failed: %v", err)
+	}
+	commit := func(path, md5 string, thumbnail string) {
+		image := &processor.ImageData{FilePath: path, FileName: filepath.Base(path), MD5: md5, RawPending: true}
+		if err := CommitScanBatch([]ScanResult{{ImageData: image, ThumbnailData: []byte(thumbnail)}}); err != nil {
+			t.Fatalf("CommitScanBatch failed: %v", err)
+		}
+	}
+	pending := func() map[string]string {
+		conversions, err := PendingRawConversions()
+		if err != nil {
+			t.Fatalf("PendingRawConversions failed: %v", err)
+		}
+		paths := make(map[string]string)
+		for _, c := range conversions {
+			paths[c.MD5] = c.FilePath
+		}
+		return paths
+	}
+	commit("/raw/a.cr2", "raw-a", "placeholder")
+	commit("/raw/b.cr2", "raw-b", "placeholder")
+	if paths := pending(); paths["raw-a"] != "/raw/a.cr2" || paths["raw-b"] != "/raw/b.cr2" {
+		t.Fatalf("Pending conversions = %v; expected both RAW files", paths)
+	}
+
+	// A rendered thumbnail survives a rescan of another copy
+	if err := FinishRawConversion("raw-a", []byte("rendered"), ""); err != nil {
+		t.Fatalf("FinishRawConversion failed: %v", err)
+	}
+	commit("/raw/copy of a.cr2", "raw-a", "placeholder")
+	var data string
+	if err := db.QueryRow("SELECT data FROM thumbnails WHERE md5 = 'raw-a'").Scan(&data); err != nil || data != "rendered" {
+		t.Errorf("Thumbnail = %q, %v; expected the rendered one", data, err)
+	}
+
+	// Failing files give up after MaxRawConversionAttempts
+	for i := 0; i < MaxRawConversionAttempts; i++ {
+		if _, ok := pending()["raw-b"]; !ok {
+			t.Fatalf("raw-b left the queue after %d attempts", i)
+		}
+		if err := FailRawConversion("raw-b", fmt.Errorf("dcraw failed")); err != nil {
+			t.Fatalf("FailRawConversion failed: %v", err)
+		}
+	}
+	if paths := pending(); len(paths) != 0 {
+		t.Errorf("Pending conversions = %v; expected none", paths)
+	}
+}
+
+func TestScanRoots(t *testing.T) {
+	if err := RecordScanRoots([]ScanRoot{{Path: "/photos", Canonical: "/volume1/photos"}}); err != nil {
+		t.Fatalf("RecordScanRoots failed: %v", err)
+	}
+	// The same folder reached through another path keeps its first path
+	if err := RecordScanRoots([]ScanRoot{{Path: "/mnt/nas/photos", Canonical: "/volume1/photos"}, {Path: "/scans", Canonical: "/scans"}}); err != nil {
+		t.Fatalf("RecordScanRoots failed: %v", err)
+	}
+	roots, err := ScanRoots()
+	if err != nil {
+		t.Fatalf("ScanRoots failed: %v", err)
+	}
+	paths := make(map[string]string)
+	for _, root := range roots {
+		paths[root.Canonical] = root.Path
+		if root.LastScanned == "" {
+			t.Errorf("Root %s has no scan time", root.Path)
+		}
+	}
+	if len(paths) != 2 || paths["/volume1/photos"] != "/photos" || paths["/scans"] != "/scans" {
+		t.Errorf("ScanRoots = %+v; expected /photos and /scans", roots)
+	}
+}
+
+func TestScanCheckpoint(t *testing.T) {
+	if err := SaveScanCheckpoint([]string{"/old"}, []string{"/old/a.jpg"}); err != nil {
+		t.Fatalf("SaveScanCheckpoint failed: %v", err)
+	}
+	// A new scan replaces the checkpoint of the one before
+	if err := SaveScanCheckpoint([]string{"/photos", "/scans"}, []string{"/photos/b.jpg", "/photos/a.jpg", "/scans/c.png"}); err != nil {
+		t.Fatalf("SaveScanCheckpoint failed: %v", err)
+	}
+	roots, files, err := ScanCheckpoint()
+	if err != nil {
+		t.Fatalf("ScanCheckpoint failed: %v", err)
+	}
+	if fmt.Sprint(roots) != "[/photos /scans]" || fmt.Sprint(files) != "[/photos/b.jpg /photos/a.jpg /scans/c.png]" {
+		t.Errorf("ScanCheckpoint = %v, %v; expected the second scan in walk order", roots, files)
+	}
+
+	if _, _, err := BeginScanRecovery(); err != nil {
+		t.Fatalf("BeginScanRecovery failed: %v", err)
+	}
+	if err := FinishScanRecovery(); err != nil {
+		t.Fatalf("FinishScanRecovery failed: %v", err)
+	}
+	if roots, files, err := ScanCheckpoint(); err != nil || len(roots) != 0 || len(files) != 0 {
+		t.Errorf("ScanCheckpoint after a finished scan = %v, %v, %v; expected nothing", roots, files, err)
+	}
+}
+
+func TestScanRecovery(t *testing.T) {
+	if err := FinishScanRecovery(); err != nil {
+		t.Fatalf("FinishScanRecovery failed: %v", err)
+	}
+	if interrupted, _, err := BeginScanRecovery(); err != nil || interrupted {
+		t.Fatalf("BeginScanRecovery after a finished scan = %v, %v; expected not interrupted", interrupted, err)
+	}
+	modTime := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	batch := []ScanResult{
+		{ImageData: &processor.ImageData{FilePath: "/photos/recovery/a.jpg", FileName: "a.jpg", FileSize: 100, ModTime: modTime, MD5: "recovery-a"}, ThumbnailData: []byte("thumb-a")},
+		{ImageData: &processor.ImageData{FilePath: "/photos/recovery/b.jpg", FileName: "b.jpg", FileSize: 200, ModTime: modTime, MD5: "recovery-b"}},
+	}
+	if err := CommitScanBatch(batch); err != nil {
+		t.Fatalf("CommitScanBatch failed: %v", err)
+	}
+
+	// The scan crashes before it finishes: the next one finds what it committed
+	interrupted, committed, err := BeginScanRecovery()
+	if err != nil || !interrupted || committed != len(batch) {
+		t.Errorf("BeginScanRecovery after a crash = %v, %d, %v; expected interrupted after %d images", interrupted, committed, err, len(batch))
+	}
+	files, err := CommittedFiles()
+	if err != nil {
+		t.Fatalf("CommittedFiles failed: %v", err)
+	}
+	for _, result := range batch {
+		expected := CommittedFile{Size: result.ImageData.FileSize, ModTime: modTime.UnixNano(), MD5: result.ImageData.MD5}
+		if files[result.ImageData.FilePath] != expected {
+			t.Errorf("CommittedFiles[%s] = %+v; expected %+v", result.ImageData.FilePath, files[result.ImageData.FilePath], expected)
+		}
+	}
+	if data, err := Thumbnail("recovery-a"); err != nil || string(data) != "thumb-a" {
+		t.Errorf("Thumbnail committed with the batch = %q, %v", data, err)
+	}
+	if err := StoreThumbnail("recovery-b", []byte("thumb-b")); err != nil {
+		t.Fatalf("StoreThumbnail failed: %v", err)
+	}
+	if data, err := Thumbnail("recovery-b"); err != nil || string(data) != "thumb-b" {
+		t.Errorf("Thumbnail after StoreThumbnail = %q, %v", data, err)
+	}
+
+	if err := FinishScanRecovery(); err != nil {
+		t.Fatalf("FinishScanRecovery failed: %v", err)
+	}
+	if interrupted, _, err := BeginScanRecovery(); err != nil || interrupted {
+		t.Errorf("BeginScanRecovery after FinishScanRecovery = %v, %v; expected not interrupted", interrupted, err)
+	}
+	if err := FinishScanRecovery(); err != nil {
+		t.Fatalf("FinishScanRecovery failed: %v", err)
+	}
+}
+
+func TestResetAnalysis(t *testing.T) {
+	db, err := GetDBInstance()
+	if err != nil {
+		t.Fatalf("GetDBInstance failed: %v", err)
+	}
+	ids := make([]int, 2)
+	for i := range ids {
+		image := &processor.ImageData{FilePath: fmt.Sprintf("/photos/reset/%d.jpg", i), FileName: fmt.Sprintf("%d.jpg", i), MD5: "reset"}
+		if err := InsertImage(image); err != nil {
+			t.Fatalf("InsertImage failed: %v", err)
+		}
+		if err := db.QueryRow("SELECT id FROM images WHERE file_path = ?", image.FilePath).Scan(&ids[i]); err != nil {
+			t.Fatalf("Failed to look up inserted image: %v", err)
+		}
+	}
+	if _, err := db.Exec("UPDATE images SET is_duplicate = TRUE, duplicate_of = ?, same_shot_of = ?, bracket_set = 1, panorama_set = 1 WHERE id = ?", ids[0], ids[0], ids[1]); err != nil {
+		t.Fatalf("Failed to mark analysis results: %v", err)
+	}
+	if err := StoreSimilarGroups([][]int{ids}, nil); err != nil {
+		t.Fatalf("StoreSimilarGroups failed: %v", err)
+	}
+	var groupID string
+	if err := db.QueryRow("SELECT similar_group FROM images WHERE id = ?", ids[0]).Scan(&groupID); err != nil {
+		t.Fatalf("Failed to read similar group: %v", err)
+	}
+
+	if err := ResetAnalysis(); err != nil {
+		t.Fatalf("ResetAnalysis failed: %v", err)
+	}
+	var left, pairs int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM images WHERE id IN (?, ?) AND (is_duplicate OR duplicate_of IS NOT NULL
+		OR similar_images IS NOT NULL OR same_shot_of IS NOT NULL OR bracket_set IS NOT NULL OR panorama_set IS NOT NULL)`, ids[0], ids[1]).Scan(&left); err != nil {
+		t.Fatalf("Failed to count analysis results: %v", err)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM similar_pairs").Scan(&pairs); err != nil {
+		t.Fatalf("Failed to count similar pairs: %v", err)
+	}
+	if left != 0 || pairs != 0 {
+		t.Errorf("ResetAnalysis left %d images with results and %d similar pairs", left, pairs)
+	}
+	var kept string
+	if err := db.QueryRow("SELECT COALESCE(similar_group, '') FROM images WHERE id = ?", ids[0]).Scan(&kept); err != nil || kept != groupID {
+		t.Errorf("similar_group after ResetAnalysis = %q, %v; expected %q to be kept", kept, err, groupID)
+	}
+}
+
+func TestChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	paths := make([]string, 4)
+	var batch []ScanResult
+	for i := range paths {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("%d.jpg", i))
+		if err := os.WriteFile(paths[i], []byte(fmt.Sprintf("image %d", i)), 0644); err != nil {
+			t.Fatalf("Failed to write image: %v", err)
+		}
+		info, err := os.Stat(paths[i])
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		if i == 3 {
+			continue // never catalogued
+		}
+		md5 := fmt.Sprintf("%032x", i)
+		if i == 2 {
+			md5 = "sha256:" + md5 // hashed with another algorithm
+		}
+		batch = append(batch, ScanResult{ImageData: &processor.ImageData{FilePath: paths[i], FileName: filepath.Base(paths[i]), FileSize: info.Size(), ModTime: info.ModTime(), MD5: md5}})
+	}
+	if err := CommitScanBatch(batch); err != nil {
+		t.Fatalf("CommitScanBatch failed: %v", err)
+	}
+	// The second file changes after it was committed
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(paths[1], later, later); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+
+	changed, rehashed, err := ChangedFiles(paths)
+	if err != nil {
+		t.Fatalf("ChangedFiles failed: %v", err)
+	}
+	if !reflect.DeepEqual(changed, paths[1:]) || rehashed != 1 {
+		t.Errorf("ChangedFiles = %v, %d rehashed; expected %v, 1 rehashed", changed, rehashed, paths[1:])
+	}
+}
+
+func TestPHashIndex(t *testing.T) {
+	db, err := GetDBInstance()
+	if err != nil {
+		t.Fatalf("GetDBInstance failed: %v", err)
+	}
+	const hash = "p:3c3c3c3c3c3c3c3c"
+	query, err := processor.ParsePHashes(hash, "")
+	if err != nil {
+		t.Fatalf("ParsePHashes failed: %v", err)
+	}
+	var ids []int
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		image := &processor.ImageData{FilePath: "/photos/indexed/" + name, FileName: name, MD5: "indexed-" + name, PHash: hash}
+		if err := InsertImage(image); err != nil {
+			t.Fatalf("InsertImage failed: %v", err)
+		}
+		var id int
+		if err := db.QueryRow("SELECT id FROM images WHERE file_path = ?", image.FilePath).Scan(&id); err != nil {
+			t.Fatalf("Failed to look up inserted image: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	candidates := func() []int {
+		t.Helper()
+		index, err := PHashIndex()
+		if err != nil {
+			t.Fatalf("PHashIndex failed: %v", err)
+		}
+		return index.Candidates(query, 0)
+	}
+	if got := candidates(); fmt.Sprint(got) != fmt.Sprint(ids) {
+		t.Errorf("Candidates = %v; expected %v", got, ids)
+	}
+
+	// The index follows the catalog
+	if err := MarkRecycled("/photos/indexed/a.jpg"); err != nil {
+		t.Fatalf("MarkRecycled failed: %v", err)
+	}
+	if got := candidates(); fmt.Sprint(got) != fmt.Sprint(ids[1:]) {
+		t.Errorf("Candidates after recycling = %v; expected %v", got, ids[1:])
+	}
+
+	// A new process loads the stored index instead of building it
+	var generation, built int64
+	if err := db.QueryRow("SELECT generation, built_generation FROM phash_index").Scan(&generation, &built); err != nil || built != generation {
+		t.Fatalf("Stored index of generation %d, catalog at %d, %v; expected it current", built, generation, err)
+	}
+	phashIndexCache = nil
+	if got := candidates(); fmt.Sprint(got) != fmt.Sprint(ids[1:]) {
+		t.Errorf("Candidates of the stored index = %v; expected %v", got, ids[1:])
+	}
+
+	// A corrupt stored index is built again
+	phashIndexCache = nil
+	if _, err := db.Exec("UPDATE phash_index SET data = x'ff'"); err != nil {
+		t.Fatalf("Failed to corrupt the index: %v", err)
+	}
+	if got := candidates(); fmt.Sprint(got) != fmt.Sprint(ids[1:]) {
+		t.Errorf("Candidates after rebuilding = %v; expected %v", got, ids[1:])
+	}
+}
+
+func TestNaturalCollation(t *testing.T) {
+	db, err := GetDBInstance()
+	if err != nil {
+		t.Fatalf("GetDBInstance failed: %v", err)
+	}
+	rows, err := db.Query("SELECT column1 FROM (VALUES ('IMG_10.jpg'), ('img_2.jpg'), ('IMG_1.jpg'), ('Écran.png'), ('Zebra.png')) ORDER BY column1 COLLATE " + NaturalCollation)
+	if err != nil {
+		t.Fatalf("Query with the natural collation failed: %v", err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if fmt.Sprint(names) != "[Écran.png IMG_1.jpg img_2.jpg IMG_10.jpg Zebra.png]" {
+		t.Errorf("natural order = %v; expected numbers by value, case and accents ignored", names)
+	}
+}
+
+func TestFailMoveWrites(t *testing.T) {
+	if err := InsertImage(&processor.ImageData{FilePath: "/faults/a.jpg", FileName: "a.jpg", MD5: "faults1"}); err != nil {
+		t.Fatalf("InsertImage failed: %v", err)
+	}
+	FailMoveWrites(true)
+	defer FailMoveWrites(false)
+	if err := MarkRecycled("/faults/a.jpg"); !errors.Is(err, errInjected) {
+		t.Errorf("MarkRecycled returned %v; expected the injected failure", err)
+	}
+	images, err := RecycledImages()
+	if err != nil {
+		t.Fatalf("RecycledImages failed: %v", err)
+	}
+	for _, image := range images {
+		if image.Path == "/faults/a.jpg" {
+			t.Errorf("Image was marked as recycled despite the failure")
+		}
+	}
+}
+
+func TestUndoOperation(t *testing.T) {
+	db, err := GetDBInstance()
+	if err != nil {
+		t.Fatalf("GetDBInstance failed: %v", err)
+	}
+	ids := make([]int, 2)
+	for i, name := range []string{"undo_a.jpg", "undo_b.jpg"} {
+		image := &processor.ImageData{FilePath: "/photos/undo/" + name, FileName: name, MD5: "undo"}
+		if err := InsertImage(image); err != nil {
+			t.Fatalf("InsertImage failed: %v", err)
+		}
+		if err := db.QueryRow("SELECT id FROM images WHERE file_path = ?", image.FilePath).Scan(&ids[i]); err != nil {
+			t.Fatalf("Failed to look up inserted image: %v", err)
+		}
+	}
+	if _, err := db.Exec("UPDATE images SET is_duplicate = TRUE, duplicate_of = ? WHERE id = ?", ids[0], ids[1]); err != nil {
+		t.Fatalf("Failed to mark duplicate: %v", err)
+	}
+
+	if _, err := BeginOperation(OperationSort); err != nil {
+		t.Fatalf("BeginOperation failed: %v", err)
+	}
+	operationID, err := BeginOperation(OperationAutoRecycle)
+	if err != nil {
+		t.Fatalf("BeginOperation failed: %v", err)
+	}
+	if err := RecordMove(operationID, "/photos/undo/undo_b.jpg", "/Recycle/undo_b.jpg"); err != nil {
+		t.Fatalf("RecordMove failed: %v", err)
+	}
+	if err := MarkRecycled("/photos/undo/undo_b.jpg"); err != nil {
+		t.Fatalf("MarkRecycled failed: %v", err)
+	}
+
+	// The sort that moved nothing is not offered for undo
+	op, err := GetOperation(0)
+	if err != nil || op.ID != operationID || op.Kind != OperationAutoRecycle || op.Moves != 1 {
+		t.Fatalf("GetOperation(0) = %+v, %v; expected auto-recycle operation %d with 1 move", op, err, operationID)
+	}
+	moves, err := OperationMoves(operationID)
+	if err != nil || len(moves) != 1 || moves[0].ImageID != ids[1] {
+		t.Fatalf("OperationMoves = %+v, %v; expected the move of image ID %d", moves, err, ids[1])
+	}
+	if err := UndoMove(moves[0]); err != nil {
+		t.Fatalf("UndoMove failed: %v", err)
+	}
+	if done, err := FinishUndo(operationID); err != nil || !done {
+		t.Fatalf("FinishUndo = %v, %v; expected the operation to be done", done, err)
+	}
+
+	var recycled, isDuplicate bool
+	if err := db.QueryRow("SELECT is_recycled, is_duplicate FROM images WHERE id = ?", ids[1]).Scan(&recycled, &isDuplicate); err != nil {
+		t.Fatalf("Failed to read restored image: %v", err)
+	}
+	if recycled || !isDuplicate {
+		t.Errorf("restored image: is_recycled = %v, is_duplicate = %v; expected an active duplicate again", recycled, isDuplicate)
+	}
+	if op, err := GetOperation(operationID); err != nil || op.UndoneAt == nil || op.Undone != 1 {
+		t.Errorf("GetOperation(%d) = %+v, %v; expected it undone", operationID, op, err)
+	}
+}
+
+func TestRecycledImages(t *testing.T) {
+	image := &processor.ImageData{FilePath: "/photos/bin/old.jpg", FileName: "old.jpg", MD5: "bin", FileSize: 42}
+	if err := InsertImage(image); err != nil {
+		t.Fatalf("InsertImage failed: %v", err)
+	}
+	operationID, err := BeginOperation(OperationRecycle)
+	if err != nil {
+		t.Fatalf("BeginOperation failed: %v", err)
+	}
+	if err := RecordMove(operationID, image.FilePath, "/Recycle/old.jpg"); err != nil {
+		t.Fatalf("RecordMove failed: %v", err)
+	}
+	if err := MarkRecycled(image.FilePath); err != nil {
+		t.Fatalf("MarkRecycled failed: %v", err)
+	}
+
+	images, err := RecycledImages()
+	if err != nil {
+		t.Fatalf("RecycledImages failed: %v", err)
+	}
+	var found *RecycledImage
+	for i := range images {
+		if images[i].Path == image.FilePath {
+			found = &images[i]
+		}
+	}
+	if found == nil || found.Size != 42 || found.RecycledAt == nil || found.Move == nil || found.Move.Dst != "/Recycle/old.jpg" || found.OperationID != operationID {
+		t.Fatalf("RecycledImages = %+v; expected %s with its logged move", images, image.FilePath)
+	}
+
+	// Deleting the file for good leaves nothing to undo
+	if err := ForgetRecycled(found.ID); err != nil {
+		t.Fatalf("ForgetRecycled failed: %v", err)
+	}
+	images, err = RecycledImages()
+	if err != nil {
+		t.Fatalf("RecycledImages failed: %v", err)
+	}
+	for _, recycled := range images {
+		if recycled.ID == found.ID {
+			t.Errorf("image ID %d is still recycled after ForgetRecycled", found.ID)
+		}
+	}
+	if _, err := GetOperation(operationID); err == nil {
+		t.Errorf("operation %d is still listed after its only file was deleted", operationID)
+	}
+}
+
+func TestRecycleStats(t *testing.T) {
+	recycleDir := t.TempDir()
+	now := time.Now()
+	for i, age := range []time.Duration{time.Hour, 40 * 24 * time.Hour, 50 * 24 * time.Hour} {
+		image := &processor.ImageData{FilePath: fmt.Sprintf("/trash/%d/a%d.jpg", i/2, i), FileName: fmt.Sprintf("a%d.jpg", i), MD5: fmt.Sprintf("trash%d", i), FileSize: int64(10 * (i + 1))}
+		if err := InsertImage(image); err != nil {
+			t.Fatalf("InsertImage failed: %v", err)
+		}
+		operationID, err := BeginOperation(OperationRecycle)
+		if err != nil {
+			t.Fatalf("BeginOperation failed: %v", err)
+		}
+		dst := filepath.Join(recycleDir, image.FileName)
+		if err := RecordMove(operationID, image.FilePath, dst); err != nil {
+			t.Fatalf("RecordMove failed: %v", err)
+		}
+		if err := MarkRecycled(image.FilePath); err != nil {
+			t.Fatalf("MarkRecycled failed: %v", err)
+		}
+		db, _ := GetDBInstance()
+		db.Exec("UPDATE images SET recycled_at = ? WHERE file_path = ?", now.Add(-age).UTC(), image.FilePath)
+		if i < 2 { // the last file was deleted by hand
+			os.WriteFile(dst, make([]byte, image.FileSize), 0644)
+		}
+	}
+	os.WriteFile(filepath.Join(recycleDir, "stray.jpg"), make([]byte, 5), 0644)
+
+	stats, err := GetRecycleStats(recycleDir, now)
+	if err != nil {
+		t.Fatalf("GetRecycleStats failed: %v", err)
+	}
+	if stats.Files != 2 || stats.Bytes != 30 || stats.StrayFiles != 1 || stats.StrayBytes != 5 || stats.Missing == 0 {
+		t.Errorf("GetRecycleStats = %+v; expected 2 files of 30 bytes, one stray file and a missing one", stats)
+	}
+	if fmt.Sprint(stats.Folders) != "[{/trash/0 2 30}]" {
+		t.Errorf("Folders = %v", stats.Folders)
+	}
+	if fmt.Sprint(stats.Ages) != "[{1 1 10} {7 0 0} {30 0 0} {90 1 20} {365 0 0} {0 0 0}]" {
+		t.Errorf("Ages = %v", stats.Ages)
+	}
+	if stats.OldestAt == nil || now.Sub(*stats.OldestAt) < 39*24*time.Hour {
+		t.Errorf("OldestAt = %v; expected 40 days ago", stats.OldestAt)
+	}
+}
+
+func TestSimilarCorrections(t *testing.T) {
+	db, err := GetDBInstance()
+	if err != nil {
+		t.Fatalf("GetDBInstance failed: %v", err)
+	}
+	ids := make([]int, 4)
+	for i := range ids {
+		image := &processor.ImageData{FilePath: fmt.Sprintf("/photos/correct/%d.jpg", i), FileName: fmt.Sprintf("%d.jpg", i), MD5: fmt.Sprintf("correct-%d", i)}
+		if err := InsertImage(image); err != nil {
+			t.Fatalf("InsertImage failed: %v", err)
+		}
+		if err := db.QueryRow("SELECT id FROM images WHERE file_path = ?", image.FilePath).Scan(&ids[i]); err != nil {
+			t.Fatalf("Failed to look up inserted image: %v", err)
+		}
+	}
+	group := fmt.Sprintf("[%d,%d]", ids[0], ids[1])
+	if _, err := db.Exec("UPDATE images SET similar_images = ? WHERE id IN (?, ?)", group, ids[0], ids[1]); err != nil {
+		t.Fatalf("Failed to mark similar images: %v", err)
+	}
+
+	merged, err := MergeSimilarGroups([]int{ids[0], ids[2]})
+	if err != nil {
+		t.Fatalf("MergeSimilarGroups failed: %v", err)
+	}
+	if !reflect.DeepEqual(merged, ids[:3]) {
+		t.Errorf("MergeSimilarGroups = %v; expected %v", merged, ids[:3])
+	}
+	if _, err := MergeSimilarGroups([]int{ids[1], ids[2]}); err == nil {
+		t.Error("MergeSimilarGroups of one group succeeded; expected an error")
+	}
+	if err := SplitSimilarGroup([]int{ids[1]}); err != nil {
+		t.Fatalf("SplitSimilarGroup failed: %v", err)
+	}
+	if err := SplitSimilarGroup([]int{ids[3]}); err == nil {
+		t.Error("SplitSimilarGroup of an ungrouped image succeeded; expected an error")
+	}
+	var similar sql.NullString
+	if err := db.QueryRow("SELECT similar_images FROM images WHERE id = ?", ids[1]).Scan(&similar); err != nil {
+		t.Fatalf("Failed to read similar images: %v", err)
+	}
+	if similar.Valid {
+		t.Errorf("similar_images of split image = %q; expected NULL", similar.String)
+	}
+
+	corrections, err := SimilarCorrections()
+	if err != nil {
+		t.Fatalf("SimilarCorrections failed: %v", err)
+	}
+	mine := make(map[[2]int]bool)
+	for _, c := range corrections {
+		if c.ID >= ids[0] && c.Other >= ids[0] {
+			mine[[2]int{c.ID, c.Other}] = c.Apart
+		}
+	}
+	expected := map[[2]int]bool{
+		{ids[0], ids[2]}: false,
+		{ids[1], ids[2]}: true, // the split replaces the merge of 1 and 2
+		{ids[1], ids[0]}: true,
+	}
+	if !reflect.DeepEqual(mine, expected) {
+		t.Errorf("SimilarCorrections = %v; expected %v", mine, expected)
+	}
+}
+
+func TestSimilarGroupIDs(t *testing.T) {
+	db, err := GetDBInstance()
+	if err != nil {
+		t.Fatalf("GetDBInstance failed: %v", err)
+	}
+	ids := make([]int, 5)
+	md5s := make([]string, len(ids))
+	for i := range ids {
+		md5s[i] = fmt.Sprintf("groupid-%d", i)
+		image := &processor.ImageData{FilePath: fmt.Sprintf("/photos/groupid/%d.jpg", i), FileName: fmt.Sprintf("%d.jpg", i), MD5: md5s[i]}
+		if err := InsertImage(image); err != nil {
+			t.Fatalf("InsertImage failed: %v", err)
+		}
+		if err := db.QueryRow("SELECT id FROM images WHERE file_path = ?", image.FilePath).Scan(&ids[i]); err != nil {
+			t.Fatalf("Failed to look up inserted image: %v", err)
+		}
+	}
+	// readIDs returns the similar_group of each image
+	readIDs := func() map[int]string {
+		t.Helper()
+		assigned := make(map[int]string)
+		for i, id := range ids {
+			var groupID sql.NullString
+			if err := db.QueryRow("SELECT similar_group FROM images WHERE id = ?", id).Scan(&groupID); err != nil {
+				t.Fatalf("Failed to read similar group: %v", err)
+			}
+			assigned[i] = groupID.String
+		}
+		return assigned
+	}
+	// regroup stores the given groups as an analysis would, with a distance
+	// for the pair of their first two images
+	regroup := func(groups ...[]int) map[int]string {
+		t.Helper()
+		if _, err := db.Exec("UPDATE images SET similar_images = NULL WHERE id BETWEEN ? AND ?", ids[0], ids[4]); err != nil {
+			t.Fatalf("Failed to clear similar images: %v", err)
+		}
+		if _, err := db.Exec("DELETE FROM similar_pairs WHERE id_a BETWEEN ? AND ?", ids[0], ids[4]); err != nil {
+			t.Fatalf("Failed to clear similar pairs: %v", err)
+		}
+		var stored [][]int
+		var pairs []grouping.Pair
+		for _, group := range groups {
+			var members []int
+			for _, i := range group {
+				members = append(members, ids[i])
+			}
+			stored = append(stored, members)
+			pairs = append(pairs, grouping.Pair{A: members[0], B: members[1], Distance: 3})
+		}
+		if err := StoreSimilarGroups(stored, pairs); err != nil {
+			t.Fatalf("StoreSimilarGroups failed: %v", err)
+		}
+		return readIDs()
+	}
+
+	// A review keyed by the member IDs, as older catalogs have it
+	legacyKey := fmt.Sprintf("[%d,%d,%d]", ids[0], ids[1], ids[2])
+	if err := SetGroupReview(GroupReview{GroupType: GroupTypeSimilar, GroupKey: legacyKey, Status: ReviewKeepAll}); err != nil {
+		t.Fatalf("SetGroupReview failed: %v", err)
+	}
+	assigned := regroup([]int{0, 1, 2})
+	first := similarGroupID(md5s[:3])
+	if !IsSimilarGroupID(first) || IsSimilarGroupID(legacyKey) {
+		t.Errorf("IsSimilarGroupID(%q), IsSimilarGroupID(%q) = %v, %v; expected true, false", first, legacyKey, IsSimilarGroupID(first), IsSimilarGroupID(legacyKey))
+	}
+	if assigned[0] != first || assigned[1] != first || assigned[2] != first || assigned[3] != "" {
+		t.Errorf("similar groups = %v; expected %s for the first three", assigned, first)
+	}
+	var measured, unmeasured sql.NullInt64
+	if err := db.QueryRow("SELECT distance FROM similar_pairs WHERE id_a = ? AND id_b = ?", ids[0], ids[1]).Scan(&measured); err != nil {
+		t.Fatalf("Failed to read similar pair: %v", err)
+	}
+	if err := db.QueryRow("SELECT distance FROM similar_pairs WHERE id_a = ? AND id_b = ?", ids[1], ids[2]).Scan(&unmeasured); err != nil {
+		t.Fatalf("Failed to read similar pair: %v", err)
+	}
+	if measured.Int64 != 3 || unmeasured.Valid {
+		t.Errorf("pair distances = %v, %v; expected 3 and NULL", measured, unmeasured)
+	}
+	reviews, err := GetGroupReviews(GroupTypeSimilar)
+	if err != nil {
+		t.Fatalf("GetGroupReviews failed: %v", err)
+	}
+	if _, ok := reviews[legacyKey]; ok || reviews[first].Status != ReviewKeepAll {
+		t.Errorf("review of the group = %+v; expected it moved from %s to %s", reviews[first], legacyKey, first)
+	}
+
+	// A later analysis that drops one image and adds another keeps the ID
+	assigned = regroup([]int{0, 1, 3})
+	if assigned[0] != first || assigned[1] != first || assigned[3] != first || assigned[2] != "" {
+		t.Errorf("similar groups after a change = %v; expected %s for 0, 1 and 3", assigned, first)
+	}
+
+	// A split group keeps its ID in its larger part
+	assigned = regroup([]int{0, 1}, []int{3, 4})
+	if second := similarGroupID([]string{md5s[4], md5s[3]}); assigned[0] != first || assigned[1] != first || assigned[3] != second || assigned[4] != second {
+		t.Errorf("similar groups after a split = %v; expected %s for 0 and 1, %s for 3 and 4", assigned, first, second)
+	}
+
+	// A pair between the groups joins them, members of members included
+	if _, err := db.Exec("INSERT INTO similar_pairs (id_a, id_b) VALUES (?, ?)", ids[1], ids[3]); err != nil {
+		t.Fatalf("Failed to add similar pair: %v", err)
+	}
+	if err := AssignSimilarGroupIDs(); err != nil {
+		t.Fatalf("AssignSimilarGroupIDs failed: %v", err)
+	}
+	assigned = readIDs()
+	var similarJSON string
+	if err := db.QueryRow("SELECT similar_images FROM images WHERE id = ?", ids[4]).Scan(&similarJSON); err != nil {
+		t.Fatalf("Failed to read similar images: %v", err)
+	}
+	joined := fmt.Sprintf("[%d,%d,%d,%d]", ids[0], ids[1], ids[3], ids[4])
+	if assigned[0] == "" || assigned[1] != assigned[0] || assigned[3] != assigned[0] || assigned[4] != assigned[0] || similarJSON != joined {
+		t.Errorf("similar groups after a join = %v with %s; expected one ID for 0, 1, 3 and 4 with %s", assigned, similarJSON, joined)
+	}
+}
+
+func TestEditedFamilies(t *testing.T) {
+	db, err := GetDBInstance()
+	if err != nil {
+		t.Fatalf("GetDBInstance failed: %v", err)
+	}
+	images := []*processor.ImageData{
+		{FilePath: "/photos/edit/a.jpg", FileName: "a.jpg", MD5: "edit-original"},
+		{FilePath: "/photos/edit/a-edit.jpg", FileName: "a-edit.jpg", MD5: "edit-edited", EditedWith: "Snapseed"},
+		{FilePath: "/photos/edit/copy/a.jpg", FileName: "a.jpg", MD5: "edit-original"},
+		{FilePath: "/photos/edit/b.jpg", FileName: "b.jpg", MD5: "edit-other"},
+	}
+	ids := make([]int, len(images))
+	for i, image := range images {
+		if err := InsertImage(image); err != nil {
+			t.Fatalf("InsertImage failed: %v", err)
+		}
+		if err := db.QueryRow("SELECT id FROM images WHERE file_path = ?", image.FilePath).Scan(&ids[i]); err != nil {
+			t.Fatalf("Failed to look up inserted image: %v", err)
+		}
+	}
+	group := fmt.Sprintf("[%d,%d]", ids[0], ids[1])
+	if _, err := db.Exec("UPDATE images SET similar_images = ? WHERE id IN (?, ?)", group, ids[0], ids[1]); err != nil {
+		t.Fatalf("Failed to mark similar images: %v", err)
+	}
+
+	family, err := EditedFamilies()
+	if err != nil {
+		t.Fatalf("EditedFamilies failed: %v", err)
+	}
+	// The edit, its original and the copy of the original, but not the unrelated image
+	for i, expected := range []bool{true, true, true, false} {
+		if family[ids[i]] != expected {
+			t.Errorf("EditedFamilies()[%s] = %v; expected %v", images[i].FilePath, family[ids[i]], expected)
+		}
+	}
+}
+
+func TestParseSearchTerms(t *testing.T) {
+	terms := ParseSearchTerms(`  screenshot "boarding  pass" 2023 `)
+	expected := []string{"screenshot", "boarding pass", "2023"}
+	if len(terms) != len(expected) {
+		t.Fatalf("ParseSearchTerms returned %v; expected %v", terms, expected)
+	}
+	for i := range expected {
+		if terms[i] != expected[i] {
+			t.Errorf("ParseSearchTerms term %d = %q; expected %q", i, terms[i], expected[i])
+		}
+	}
+}
+
+func TestFindSimilarTo(t *testing.T) {
+	db, err := GetDBInstance()
+	if err != nil {
+		t.Fatalf("GetDBInstance failed: %v", err)
+	}
+	images := []*processor.ImageData{
+		{FilePath: "/photos/example/copy.jpg", FileName: "copy.jpg", MD5: "example", PHash: "p:5a5a5a5a5a5a5a5a"},
+		{FilePath: "/photos/example/near.jpg", FileName: "near.jpg", MD5: "example-near", PHash: "p:a5a5a5a5a5a5a5a4"},
+		{FilePath: "/photos/example/far.jpg", FileName: "far.jpg", MD5: "example-far", PHash: "p:5a5a5a5a5a5a5a5a"},
+	}
+	ids := make([]int, len(images))
+	for i, image := range images {
+		if err := InsertImage(image); err != nil {
+			t.Fatalf("InsertImage failed: %v", err)
+		}
+		if err := db.QueryRow("SELECT id FROM images WHERE file_path = ?", image.FilePath).Scan(&ids[i]); err != nil {
+			t.Fatalf("Failed to look up inserted image: %v", err)
+		}
+	}
+
+	example, err := processor.ParsePHashes("p:a5a5a5a5a5a5a5a5", "")
+	if err != nil {
+		t.Fatalf("ParsePHashes failed: %v", err)
+	}
+	matches, err := FindSimilarTo("example", example, 5)
+	if err != nil {
+		t.Fatalf("FindSimilarTo failed: %v", err)
+	}
+	var found []string
+	for _, match := range matches {
+		for i, id := range ids {
+			if match.ID == id {
+				found = append(found, fmt.Sprintf("%s:%d:%v", images[i].FileName, match.Distance, match.Exact))
+			}
+		}
+	}
+	// The copy matches by content although its pHash is far off
+	if fmt.Sprint(found) != "[copy.jpg:0:true near.jpg:1:false]" {
+		t.Errorf("FindSimilarTo found %v", found)
+	}
+
+	if matches, err := FindSimilarTo("example", processor.PHashes{}, 5); err != nil || len(matches) != 1 || matches[0].ID != ids[0] {
+		t.Errorf("FindSimilarTo without a pHash = %v, %v; expected only the copy", matches, err)
+	}
+}
+
+func TestMergeCatalog(t *testing.T) {
+	db, err := GetDBInstance()
+	if err != nil {
+		t.Fatalf("GetDBInstance failed: %v", err)
+	}
+	if err := InsertImage(&processor.ImageData{FilePath: "/merge/a.jpg", FileName: "a.jpg", MD5: "merge1"}); err != nil {
+		t.Fatalf("InsertImage failed: %v", err)
+	}
+
+	// An older catalog without most of the newer columns
+	otherPath := filepath.Join(t.TempDir(), "other.db")
+	other, err := sql.Open(driverName, catalogDSN(otherPath))
+	if err != nil {
+		t.Fatalf("Failed to create other catalog: %v", err)
+	}
+	_, err = other.Exec(`
+		CREATE TABLE images (id INTEGER PRIMARY KEY, file_path TEXT NOT NULL UNIQUE, file_name TEXT NOT NULL,
+			file_mod_time INTEGER, md5 TEXT, similar_images TEXT, similar_group TEXT, is_recycled BOOLEAN DEFAULT FALSE);
+		INSERT INTO images (id, file_path, file_name, md5, similar_images, similar_group) VALUES
+			(1, '/merge/b.jpg', 'b.jpg', 'merge1', NULL, NULL),
+			(2, '/merge/c.jpg', 'c.jpg', 'merge2', '[3]', NULL),
+			(3, '/merge/d.jpg', 'd.jpg', 'merge3', NULL, NULL),
+			(4, '/merge/e.jpg', 'e.jpg', 'merge4', '[4,5]', '0123456789abcdef'),
+			(5, '/merge/f.jpg', 'f.jpg', 'merge5', '[4,5]', '0123456789abcdef'),
+			(6, '/merge/g.jpg', 'g.jpg', 'merge6', '[6,7]', NULL),
+			(7, '/merge/h.jpg', 'h.jpg', 'merge7', '[6,7]', NULL);
+		CREATE TABLE group_reviews (group_type TEXT NOT NULL, group_key TEXT NOT NULL, status TEXT NOT NULL DEFAULT 'unreviewed',
+			notes TEXT NOT NULL DEFAULT '', updated_at DATETIME, PRIMARY KEY (group_type, group_key));
+		INSERT INTO group_reviews (group_type, group_key, status, notes) VALUES
+			('similar', '0123456789abcdef', 'reviewed-keep-all', 'beach'),
+			('similar', '6-7', 'resolved', 'legacy');
+	`)
+	other.Close()
+	if err != nil {
+		t.Fatalf("Failed to fill other catalog: %v", err)
+	}
+
+	stats, err := MergeCatalog(otherPath, "")
+	if err != nil {
+		t.Fatalf("MergeCatalog failed: %v", err)
+	}
+	if stats.Imported != 7 || stats.DuplicateGroups != 1 {
+		t.Errorf("MergeCatalog stats = %+v; expected 7 imported and 1 duplicate group", stats)
+	}
+
+	idOf := func(path string) int {
+		var id int
+		if err := db.QueryRow("SELECT id FROM images WHERE file_path = ?", path).Scan(&id); err != nil {
+			t.Fatalf("Failed to look up %s: %v", path, err)
+		}
+		return id
+	}
+	var duplicateOf sql.NullInt64
+	if err := db.QueryRow("SELECT duplicate_of FROM images WHERE id = ?", idOf("/merge/b.jpg")).Scan(&duplicateOf); err != nil {
+		t.Fatalf("Failed to read duplicate_of: %v", err)
+	}
+	if int(duplicateOf.Int64) != idOf("/merge/a.jpg") {
+		t.Errorf("Imported copy is a duplicate of %d; expected %d", duplicateOf.Int64, idOf("/merge/a.jpg"))
+	}
+	var similar string
+	if err := db.QueryRow("SELECT similar_images FROM images WHERE id = ?", idOf("/merge/c.jpg")).Scan(&similar); err != nil {
+		t.Fatalf("Failed to read similar_images: %v", err)
+	}
+	if expected := fmt.Sprintf("[%d]", idOf("/merge/d.jpg")); similar != expected {
+		t.Errorf("similar_images = %s; expected %s", similar, expected)
+	}
+
+	// The review of a group keeps its ID; one keyed by member IDs follows the group
+	reviews, err := GetGroupReviews(GroupTypeSimilar)
+	if err != nil {
+		t.Fatalf("GetGroupReviews failed: %v", err)
+	}
+	var group string
+	if err := db.QueryRow("SELECT COALESCE(similar_group, '') FROM images WHERE id = ?", idOf("/merge/e.jpg")).Scan(&group); err != nil {
+		t.Fatalf("Failed to read similar_group: %v", err)
+	}
+	if review := reviews["0123456789abcdef"]; group != "0123456789abcdef" || review.Status != ReviewKeepAll || review.Notes != "beach" {
+		t.Errorf("Group %q has review %+v; expected the reviewed group 0123456789abcdef kept", group, review)
+	}
+	if err := db.QueryRow("SELECT COALESCE(similar_group, '') FROM images WHERE id = ?", idOf("/merge/g.jpg")).Scan(&group); err != nil {
+		t.Fatalf("Failed to read similar_group: %v", err)
+	}
+	if review := reviews[group]; group == "" || review.Status != ReviewResolved {
+		t.Errorf("Group %q has review %+v; expected the legacy review moved to it", group, review)
+	}
+}
+
+func TestRunReport(t *testing.T) {
+	if !reportsSupported {
+		t.Skip("reports need the cgo SQLite driver")
+	}
+	if err := InsertImage(&processor.ImageData{FilePath: "/report/a.jpg", FileName: "a.jpg", MD5: "report1", PHash: "p:0000000000000001"}); err != nil {
+		t.Fatalf("InsertImage failed: %v", err)
+	}
+	report, err := RunReport(context.Background(), "SELECT file_name, md5 FROM report_images WHERE file_path LIKE '/report/%' ORDER BY file_name COLLATE NATSORT", 10)
+	if err != nil {
+		t.Fatalf("RunReport failed: %v", err)
+	}
+	if fmt.Sprint(report.Columns, report.Rows) != "[file_name md5] [[a.jpg report1]]" {
+		t.Errorf("Report = %v %v", report.Columns, report.Rows)
+	}
+	if report, err := RunReport(context.Background(), "SELECT COUNT(*) FROM report_images", 10); err != nil || len(report.Rows) != 1 {
+		t.Errorf("Counting failed: %v", err)
+	}
+
+	for _, query := range []string{
+		"SELECT phash FROM images",
+		"SELECT * FROM thumbnails",
+		"SELECT name FROM sqlite_master",
+		"UPDATE images SET rating = 5",
+		"DELETE FROM images",
+		"PRAGMA journal_mode",
+		"ATTACH DATABASE '/tmp/other.db' AS other",
+		"SELECT 1; DELETE FROM images",
+	} {
+		if _, err := RunReport(context.Background(), query, 10); err == nil {
+			t.Errorf("Report %q succeeded; expected it to be refused", query)
+		}
+	}
+	var count int
+	db, _ := GetDBInstance()
+	db.QueryRow("SELECT COUNT(*) FROM images WHERE file_path = '/report/a.jpg'").Scan(&count)
+	if count != 1 {
+		t.Error("A refused report changed the catalog")
+	}
+}
+
+func TestPDFMatches(t *testing.T) {
+	pdfPath := filepath.Join(t.TempDir(), "album.pdf")
+	if err := os.WriteFile(pdfPath, []byte("%PDF-1.4"), 0644); err != nil {
+		t.Fatalf("Failed to write PDF: %v", err)
+	}
+	photo := &processor.ImageData{FilePath: "/photos/pdf/scan.jpg", FileName: "scan.jpg", MD5: "pdf-scan", PHash: "p:c3a5c3a5c3a5c3a5", ImageWidth: 1200, ImageHeight: 800}
+	if err := InsertImage(photo); err != nil {
+		t.Fatalf("InsertImage failed: %v", err)
+	}
+
+	if rendered, err := PDFRendered(pdfPath, 1, false); err != nil || rendered {
+		t.Fatalf("PDFRendered before rendering = %v, %v; expected false", rendered, err)
+	}
+	pages := []processor.PDFPage{
+		{Page: 1, Width: 600, Height: 401, PHash: "p:c3a5c3a5c3a5c3a4"}, // the photo, rendered smaller
+		{Page: 2, Width: 600, Height: 900, PHash: "p:c3a5c3a5c3a5c3a5"}, // another shape
+		{Page: 3, Width: 600, Height: 400, PHash: "p:3c5a3c5a3c5a3c5a"}, // another picture
+	}
+	if err := StorePDFPages(pdfPath, 1, false, pages); err != nil {
+		t.Fatalf("StorePDFPages failed: %v", err)
+	}
+	for _, tc := range []struct {
+		modTime  int64
+		allPages bool
+		rendered bool
+	}{
+		{1, false, true},
+		{1, true, false}, // only the first page was asked for
+		{2, false, false},
+	} {
+		if rendered, err := PDFRendered(pdfPath, tc.modTime, tc.allPages); err != nil || rendered != tc.rendered {
+			t.Errorf("PDFRendered(%d, %v) = %v, %v; expected %v", tc.modTime, tc.allPages, rendered, err, tc.rendered)
+		}
+	}
+
+	matches, err := PDFMatches()
+	if err != nil {
+		t.Fatalf("PDFMatches failed: %v", err)
+	}
+	var found []PDFMatch
+	for _, match := range matches {
+		if match.PDFPath == pdfPath && match.FilePath == photo.FilePath {
+			found = append(found, match)
+		}
+	}
+	if len(found) != 1 || found[0].Page != 1 || found[0].Distance != 1 {
+		t.Errorf("PDFMatches found %+v; expected page 1 at distance 1", found)
+	}
+
+	if err := os.Remove(pdfPath); err != nil {
+		t.Fatalf("Failed to remove PDF: %v", err)
+	}
+	if forgotten, err := ForgetMissingPDFs(); err != nil || forgotten != 1 {
+		t.Errorf("ForgetMissingPDFs = %d, %v; expected 1", forgotten, err)
+	}
+	if rendered, _ := PDFRendered(pdfPath, 1, false); rendered {
+		t.Error("the pages of the removed PDF are still stored")
+	}
+}
+
+func TestSaveSnapshot(t *testing.T) {
+	db, err := GetDBInstance()
+	if err != nil {
+		t.Fatalf("GetDBInstance failed: %v", err)
+	}
+	if err := InsertImage(&processor.ImageData{FilePath: "/snapshot/a.jpg", FileName: "a.jpg", MD5: "snapshot1"}); err != nil {
+		t.Fatalf("InsertImage failed: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "saved.db")
+	os.WriteFile(path, []byte("an older file"), 0644)
+	if err := saveSnapshot(db, path); err != nil {
+		t.Fatalf("saveSnapshot failed: %v", err)
+	}
+
+	saved, err := sql.Open(driverName, catalogDSN(path))
+	if err != nil {
+		t.Fatalf("Failed to open snapshot: %v", err)
+	}
+	defer saved.Close()
+	var count int
+	if err := saved.QueryRow("SELECT COUNT(*) FROM images WHERE file_path = '/snapshot/a.jpg'").Scan(&count); err != nil || count != 1 {
+		t.Errorf("Snapshot holds %d copies of the image, %v; expected 1", count, err)
+	}
+	if _, err := os.Stat(path + ".saving"); !os.IsNotExist(err) {
+		t.Errorf("The file the snapshot was written to first was left behind: %v", err)
+	}
+}
+
 func TestCloseDb(t *testing.T) {
 	// Get a database instance
 	db, err := GetDBInstance()
@@ -41,3 +1517,73 @@ func TestCloseDb(t *testing.T) {
 		t.Fatal("Expected error when using closed database, but got none")
 	}
 }
+
+func TestMemoryCatalog(t *testing.T) {
+	// Runs after TestCloseDb: it opens catalogs of its own
+	reopen := func(path string) *sql.DB {
+		t.Helper()
+		SetPath(path)
+		once, initErr = sync.Once{}, nil
+		db, err := GetDBInstance()
+		if err != nil {
+			t.Fatalf("GetDBInstance(%s) failed: %v", path, err)
+		}
+		return db
+	}
+	defer func() {
+		SetPath("")
+		SetSnapshotPath("")
+	}()
+	countImages := func(db *sql.DB) int {
+		t.Helper()
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM images").Scan(&count); err != nil {
+			t.Fatalf("Failed to count images: %v", err)
+		}
+		return count
+	}
+
+	// --db memory with --save-db: nothing is written until the catalog is closed
+	saved := filepath.Join(t.TempDir(), "saved.db")
+	SetSnapshotPath(saved)
+	db := reopen(MemoryPath)
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		if err := InsertImage(&processor.ImageData{FilePath: "/memory/" + name, FileName: name, MD5: "memory-" + name}); err != nil {
+			t.Fatalf("InsertImage failed: %v", err)
+		}
+	}
+	if count := countImages(db); count != 2 {
+		t.Fatalf("Memory catalog holds %d images; expected 2", count)
+	}
+	if _, err := os.Stat(saved); !os.IsNotExist(err) {
+		t.Errorf("The catalog was saved before it was closed: %v", err)
+	}
+	if _, err := os.Stat(MemoryPath); !os.IsNotExist(err) {
+		t.Errorf("The memory catalog left a file behind: %v", err)
+	}
+	if err := CloseDb(); err != nil {
+		t.Fatalf("CloseDb failed: %v", err)
+	}
+
+	// The saved file is a catalog of its own
+	SetSnapshotPath("")
+	db = reopen(saved)
+	if count := countImages(db); count != 2 {
+		t.Errorf("Saved catalog holds %d images; expected 2", count)
+	}
+	if err := InsertImage(&processor.ImageData{FilePath: "/memory/c.jpg", FileName: "c.jpg", MD5: "memory-c.jpg"}); err != nil {
+		t.Errorf("InsertImage into the saved catalog failed: %v", err)
+	}
+	if err := CloseDb(); err != nil {
+		t.Fatalf("CloseDb failed: %v", err)
+	}
+
+	// Closing dropped the memory catalog
+	db = reopen(MemoryPath)
+	if count := countImages(db); count != 0 {
+		t.Errorf("A new memory catalog holds %d images; expected none", count)
+	}
+	if err := CloseDb(); err != nil {
+		t.Fatalf("CloseDb failed: %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+package database
+
+import (
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The catalog is opened with github.com/mattn/go-sqlite3, which needs cgo.
+// Built with the purego tag it is opened with modernc.org/sqlite instead, so
+// picpurge cross-compiles without a C toolchain, e.g. for ARM NAS boxes:
+//
+//	CGO_ENABLED=0 GOOS=linux GOARCH=arm64 go build -tags purego
+//
+// Both drivers read and write the same catalog files.
+
+// busyTimeout is how long a statement waits for a write on another connection,
+// e.g. a scan batch while the web interface reads, before failing with
+// "database is locked". It is a setting of the connection, so each driver sets
+// it on every new one; a PRAGMA run once on the pool would only reach
+// whichever connection ran it.
+const busyTimeout = 5 * time.Second
+
+// catalogDSN returns the data source name that opens the SQLite file at path
+// with the given URI parameters, e.g. mode=ro, and those the driver needs.
+func catalogDSN(path string, params ...string) string {
+	if driverParams != "" {
+		params = append(params, driverParams)
+	}
+	if len(params) == 0 {
+		return path
+	}
+	return "file:" + (&url.URL{Path: path}).EscapedPath() + "?" + strings.Join(params, "&")
+}
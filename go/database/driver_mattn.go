@@ -0,0 +1,32 @@
+//go:build !purego
+
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// driverName is the SQLite driver of the catalog, which sets up every
+// connection with prepareConnection.
+const driverName = "sqlite3_picpurge"
+
+// driverParams are the URI parameters every catalog connection is opened with.
+const driverParams = ""
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{ConnectHook: prepareConnection})
+}
+
+// prepareConnection sets the busy timeout of a new connection and adds
+// NaturalCollation.
+func prepareConnection(conn *sqlite3.SQLiteConn) error {
+	if _, err := conn.Exec(fmt.Sprintf("PRAGMA busy_timeout = %d", busyTimeout.Milliseconds()), nil); err != nil {
+		return fmt.Errorf("failed to set busy timeout: %w", err)
+	}
+	// Every connection gets its own collator; SQLite uses a connection from
+	// one goroutine at a time.
+	return conn.RegisterCollation(NaturalCollation, newCollator().CompareString)
+}
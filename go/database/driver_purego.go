@@ -0,0 +1,44 @@
+//go:build purego
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"golang.org/x/text/collate"
+	"modernc.org/sqlite"
+)
+
+// driverName is the SQLite driver of the catalog. Collations and connection
+// hooks of modernc.org/sqlite apply to every connection of its own driver,
+// so the catalog uses that one rather than registering another.
+const driverName = "sqlite"
+
+// driverParams are the URI parameters every catalog connection is opened with.
+// Times are written the way mattn/go-sqlite3 writes them, so catalogs stay
+// readable by either build.
+const driverParams = "_time_format=sqlite"
+
+// collators holds the collators of NaturalCollation. The collation is one
+// function for all connections, which may compare at the same time.
+var collators = sync.Pool{New: func() interface{} { return newCollator() }}
+
+func init() {
+	err := sqlite.RegisterCollationUtf8(NaturalCollation, func(left, right string) int {
+		collator := collators.Get().(*collate.Collator)
+		defer collators.Put(collator)
+		return collator.CompareString(left, right)
+	})
+	if err != nil {
+		panic(fmt.Sprintf("failed to register %s collation: %v", NaturalCollation, err))
+	}
+	sqlite.RegisterConnectionHook(func(conn sqlite.ExecQuerierContext, _ string) error {
+		query := fmt.Sprintf("PRAGMA busy_timeout = %d", busyTimeout.Milliseconds())
+		if _, err := conn.ExecContext(context.Background(), query, nil); err != nil {
+			return fmt.Errorf("failed to set busy timeout: %w", err)
+		}
+		return nil
+	})
+}
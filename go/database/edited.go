@@ -0,0 +1,85 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// EditedFamilies returns the IDs of the edited images of the catalog together
+// with the originals they were made from, and exact copies of either. An
+// edited image's originals are the unedited members of its similar group and
+// the images it is a re-encode of, or that are re-encodes of it.
+func EditedFamilies() (map[int]bool, error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT id, md5, COALESCE(edited_with, ''), similar_images, same_shot_of
+		FROM images WHERE is_recycled = FALSE`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query edited images: %w", err)
+	}
+	defer rows.Close()
+
+	type member struct {
+		md5        string
+		edited     bool
+		similar    []int
+		sameShotOf int
+	}
+	images := make(map[int]member)
+	reencodes := make(map[int][]int) // images by the ID they are re-encodes of
+	for rows.Next() {
+		var id int
+		var m member
+		var editedWith string
+		var similarJSON sql.NullString
+		var sameShotOf sql.NullInt64
+		if err := rows.Scan(&id, &m.md5, &editedWith, &similarJSON, &sameShotOf); err != nil {
+			return nil, fmt.Errorf("failed to scan image: %w", err)
+		}
+		m.edited = editedWith != ""
+		if similarJSON.Valid {
+			json.Unmarshal([]byte(similarJSON.String), &m.similar)
+		}
+		if sameShotOf.Valid {
+			m.sameShotOf = int(sameShotOf.Int64)
+			reencodes[m.sameShotOf] = append(reencodes[m.sameShotOf], id)
+		}
+		images[id] = m
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	family := make(map[int]bool)
+	md5s := make(map[string]bool)
+	add := func(id int) {
+		if m, ok := images[id]; ok {
+			family[id] = true
+			md5s[m.md5] = true
+		}
+	}
+	for id, m := range images {
+		if !m.edited {
+			continue
+		}
+		add(id)
+		for _, other := range m.similar {
+			if !images[other].edited {
+				add(other)
+			}
+		}
+		add(m.sameShotOf)
+		for _, other := range reencodes[id] {
+			add(other)
+		}
+	}
+	for id, m := range images {
+		if md5s[m.md5] {
+			family[id] = true
+		}
+	}
+	return family, nil
+}
@@ -0,0 +1,26 @@
+package database
+
+import "errors"
+
+// failMoveWrites makes the catalog updates that record moved and recycled
+// files fail.
+var failMoveWrites bool
+
+// errInjected is returned by catalog updates failed on purpose.
+var errInjected = errors.New("injected failure")
+
+// FailMoveWrites makes the catalog updates that record a moved, recycled or
+// restored file fail, so it can be tested that files and catalog stay in step
+// when they do. It is meant for testing and never used in normal runs.
+func FailMoveWrites(fail bool) {
+	failMoveWrites = fail
+}
+
+// moveWriteFault returns the injected failure of a catalog update recording a
+// file move, or nil.
+func moveWriteFault() error {
+	if failMoveWrites {
+		return errInjected
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Every member of a similar group stores the group as a list of image IDs,
+// which changes whenever the group is found again or the images are
+// catalogued anew. Reviews, share links and review sheets name a group by its
+// similar_group ID instead: a new group is named by a hash of the sorted MD5s
+// of its members, and a group found again by a later analysis keeps the ID
+// most of its members had, even when members joined or left it.
+
+// similarGroupID names a group of images by their content.
+func similarGroupID(md5s []string) string {
+	sorted := append([]string(nil), md5s...)
+	sort.Strings(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// IsSimilarGroupID reports whether s looks like a similar_group ID rather
+// than a list of member IDs.
+func IsSimilarGroupID(s string) bool {
+	if len(s) != 16 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// AssignSimilarGroupIDs gives the images of every similar group the ID of
+// their group and clears it on images in none. Reviews still keyed by the
+// member IDs of a group, as older catalogs stored them, move to its ID.
+func AssignSimilarGroupIDs() error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	return assignSimilarGroupIDs(db)
+}
+
+// similarGroupMembers is a similar group: images connected by similar pairs.
+type similarGroupMembers struct {
+	ids      []int    // sorted
+	md5s     []string // of ids
+	previous []string // similar_group of ids before
+	lists    []string // similar_images of ids
+}
+
+func assignSimilarGroupIDs(db *sql.DB) error {
+	// Union-find over the pairs of active images
+	rows, err := db.Query(`SELECT p.id_a, p.id_b FROM similar_pairs p
+		JOIN images a ON a.id = p.id_a AND a.is_recycled = FALSE
+		JOIN images b ON b.id = p.id_b AND b.is_recycled = FALSE`)
+	if err != nil {
+		return fmt.Errorf("failed to query similar pairs: %w", err)
+	}
+	parent := make(map[int]int)
+	var find func(id int) int
+	find = func(id int) int {
+		if parent[id] != id {
+			parent[id] = find(parent[id])
+		}
+		return parent[id]
+	}
+	for rows.Next() {
+		var a, b int
+		if err := rows.Scan(&a, &b); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan similar pair: %w", err)
+		}
+		for _, id := range []int{a, b} {
+			if _, ok := parent[id]; !ok {
+				parent[id] = id
+			}
+		}
+		if rootA, rootB := find(a), find(b); rootA != rootB {
+			parent[max(rootA, rootB)] = min(rootA, rootB)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = db.Query(`SELECT id, md5, COALESCE(similar_group, ''), COALESCE(similar_images, '') FROM images
+		WHERE similar_group IS NOT NULL OR id IN (SELECT id_a FROM similar_pairs UNION SELECT id_b FROM similar_pairs)
+		ORDER BY id`)
+	if err != nil {
+		return fmt.Errorf("failed to query similar groups: %w", err)
+	}
+	byRoot := make(map[int]*similarGroupMembers)
+	var groups []*similarGroupMembers // by their first image
+	stale := make(map[int]bool)       // images with an ID but no group
+	for rows.Next() {
+		var id int
+		var md5, previous, list string
+		if err := rows.Scan(&id, &md5, &previous, &list); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan similar group: %w", err)
+		}
+		if _, ok := parent[id]; !ok {
+			if previous != "" {
+				stale[id] = true
+			}
+			continue
+		}
+		root := find(id)
+		group := byRoot[root]
+		if group == nil {
+			group = &similarGroupMembers{}
+			byRoot[root] = group
+			groups = append(groups, group)
+		}
+		group.ids = append(group.ids, id)
+		group.md5s = append(group.md5s, md5)
+		group.previous = append(group.previous, previous)
+		group.lists = append(group.lists, list)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// An old ID goes to the group that kept most of its images, so a group that
+	// was split keeps its ID in its larger part.
+	type claim struct {
+		group, count int
+		id           string
+	}
+	var claims []claim
+	for i, group := range groups {
+		counts := make(map[string]int)
+		for _, previous := range group.previous {
+			if previous != "" {
+				counts[previous]++
+			}
+		}
+		for id, count := range counts {
+			claims = append(claims, claim{i, count, id})
+		}
+	}
+	sort.Slice(claims, func(i, j int) bool {
+		if claims[i].count != claims[j].count {
+			return claims[i].count > claims[j].count
+		}
+		if claims[i].group != claims[j].group {
+			return claims[i].group < claims[j].group
+		}
+		return claims[i].id < claims[j].id
+	})
+	assigned := make([]string, len(groups))
+	taken := make(map[string]bool)
+	for _, c := range claims {
+		if assigned[c.group] == "" && !taken[c.id] {
+			assigned[c.group] = c.id
+			taken[c.id] = true
+		}
+	}
+	for i, group := range groups {
+		if assigned[i] != "" {
+			continue
+		}
+		id := similarGroupID(group.md5s)
+		for taken[id] {
+			id = similarGroupID(append(group.md5s, id)) // another group holds the same content
+		}
+		assigned[i] = id
+		taken[id] = true
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to assign similar group IDs: %w", err)
+	}
+	defer tx.Rollback()
+	for id := range stale {
+		if _, err := tx.Exec("UPDATE images SET similar_group = NULL WHERE id = ?", id); err != nil {
+			return fmt.Errorf("failed to clear similar group ID of image ID %d: %w", id, err)
+		}
+	}
+	var legacyReviews int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM group_reviews WHERE group_type = ? AND (group_key LIKE '[%' OR group_key GLOB '[0-9]*-[0-9]*')", GroupTypeSimilar).Scan(&legacyReviews); err != nil {
+		return fmt.Errorf("failed to look up similar group reviews: %w", err)
+	}
+	for i, group := range groups {
+		memberJSON, _ := json.Marshal(group.ids)
+		for j, id := range group.ids {
+			if group.previous[j] != assigned[i] {
+				if _, err := tx.Exec("UPDATE images SET similar_group = ? WHERE id = ?", assigned[i], id); err != nil {
+					return fmt.Errorf("failed to set similar group ID of image ID %d: %w", id, err)
+				}
+			}
+			// Lists that overlapped, e.g. after a merge of catalogs, become the whole group
+			if group.lists[j] != string(memberJSON) {
+				if _, err := tx.Exec("UPDATE images SET similar_images = ? WHERE id = ?", string(memberJSON), id); err != nil {
+					return fmt.Errorf("failed to update similar group of image ID %d: %w", id, err)
+				}
+			}
+		}
+		if legacyReviews == 0 {
+			continue
+		}
+		parts := make([]string, len(group.ids))
+		for j, member := range group.ids {
+			parts[j] = strconv.Itoa(member)
+		}
+		for _, legacyKey := range []string{string(memberJSON), strings.Join(parts, "-")} {
+			if _, err := tx.Exec("UPDATE OR IGNORE group_reviews SET group_key = ? WHERE group_type = ? AND group_key = ?", assigned[i], GroupTypeSimilar, legacyKey); err != nil {
+				return fmt.Errorf("failed to move review of similar group %s: %w", legacyKey, err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to assign similar group IDs: %w", err)
+	}
+	return nil
+}
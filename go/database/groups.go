@@ -0,0 +1,190 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"picpurge/events"
+	"picpurge/util"
+)
+
+func init() {
+	events.Subscribe(func(e events.Event) {
+		if e.Kind != events.ImageRecycled {
+			return
+		}
+		if err := removeFromGroups(e.ImageID); err != nil {
+			log.Printf("Warning: Could not update groups after recycling image ID %d: %v\n", e.ImageID, err)
+		}
+	})
+}
+
+// MarkRecycled flags the image at filePath as recycled and publishes an
+// ImageRecycled event so caches and groups drop it.
+func MarkRecycled(filePath string) error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	var id int
+	var md5 string
+	if err := db.QueryRow("SELECT id, md5 FROM images WHERE file_path = ?", filePath).Scan(&id, &md5); err != nil {
+		return fmt.Errorf("image not found: %s", filePath)
+	}
+	if err := moveWriteFault(); err != nil {
+		return fmt.Errorf("failed to mark image as recycled: %w", err)
+	}
+	if _, err := db.Exec("UPDATE images SET is_recycled = TRUE, recycled_at = ? WHERE id = ?", time.Now().UTC(), id); err != nil {
+		return fmt.Errorf("failed to mark image as recycled: %w", err)
+	}
+	events.Publish(events.Event{Kind: events.ImageRecycled, ImageID: id, MD5: md5, Path: filePath})
+	return nil
+}
+
+// SetImagePath records that the file of an image was moved to filePath.
+func SetImagePath(id int, filePath string) error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	if err := moveWriteFault(); err != nil {
+		return fmt.Errorf("failed to update path of image ID %d: %w", id, err)
+	}
+	if _, err := db.Exec("UPDATE images SET file_path = ? WHERE id = ?", filePath, id); err != nil {
+		return fmt.Errorf("failed to update path of image ID %d: %w", id, err)
+	}
+	return nil
+}
+
+// CountActiveImagesWithMD5 returns how many non-recycled images have the given content hash.
+func CountActiveImagesWithMD5(md5 string) (int, error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM images WHERE md5 = ? AND is_recycled = FALSE", md5).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count images: %w", err)
+	}
+	return count, nil
+}
+
+// removeFromGroups takes a recycled image out of the similar groups stored on
+// the other members and, if it was the original of a duplicate group, promotes the oldest
+// remaining copy to be the new original.
+func removeFromGroups(id int) error {
+	if err := removeFromSimilarGroups(id); err != nil {
+		return err
+	}
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+
+	var newOriginal int
+	err = db.QueryRow("SELECT MIN(id) FROM images WHERE duplicate_of = ? AND is_recycled = FALSE", id).Scan(&newOriginal)
+	if err != nil || newOriginal == 0 {
+		return nil // Not the original of a duplicate group
+	}
+	if _, err := db.Exec("UPDATE images SET is_duplicate = FALSE, duplicate_of = NULL WHERE id = ?", newOriginal); err != nil {
+		return fmt.Errorf("failed to promote image ID %d: %w", newOriginal, err)
+	}
+	if _, err := db.Exec("UPDATE images SET duplicate_of = ? WHERE duplicate_of = ?", newOriginal, id); err != nil {
+		return fmt.Errorf("failed to re-point duplicates of image ID %d: %w", id, err)
+	}
+	return nil
+}
+
+// removeFromSimilarGroups takes an image out of the similar groups stored on
+// the other members, which similar_pairs links it to. Its own similar_images
+// are left as they are.
+func removeFromSimilarGroups(id int) error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to remove image ID %d from its similar group: %w", id, err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT images.id, images.similar_images FROM similar_pairs
+		JOIN images ON images.id = CASE similar_pairs.id_a WHEN ? THEN similar_pairs.id_b ELSE similar_pairs.id_a END
+		WHERE (similar_pairs.id_a = ? OR similar_pairs.id_b = ?) AND images.similar_images IS NOT NULL`, id, id, id)
+	if err != nil {
+		return fmt.Errorf("failed to query similar groups: %w", err)
+	}
+	updates := make(map[int][]int)
+	for rows.Next() {
+		var otherID int
+		var similarJSON string
+		if err := rows.Scan(&otherID, &similarJSON); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan similar group: %w", err)
+		}
+		var similar []int
+		if err := json.Unmarshal([]byte(similarJSON), &similar); err != nil {
+			continue
+		}
+		remaining := similar[:0]
+		for _, similarID := range similar {
+			if similarID != id {
+				remaining = append(remaining, similarID)
+			}
+		}
+		if len(remaining) != len(similar) {
+			updates[otherID] = remaining
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to query similar groups: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM similar_pairs WHERE id_a = ? OR id_b = ?", id, id); err != nil {
+		return fmt.Errorf("failed to remove similar pairs of image ID %d: %w", id, err)
+	}
+	for otherID, remaining := range updates {
+		var value interface{} // NULL once nothing but the image itself is left
+		if len(remaining) > 1 || (len(remaining) == 1 && remaining[0] != otherID) {
+			data, _ := json.Marshal(remaining)
+			value = string(data)
+		}
+		if _, err := tx.Exec("UPDATE images SET similar_images = ? WHERE id = ?", value, otherID); err != nil {
+			return fmt.Errorf("failed to update similar group of image ID %d: %w", otherID, err)
+		}
+		// The others keep the ID of the group; an image left alone is in none
+		if value == nil {
+			if _, err := tx.Exec("UPDATE images SET similar_group = NULL WHERE id = ?", otherID); err != nil {
+				return fmt.Errorf("failed to update similar group of image ID %d: %w", otherID, err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to remove image ID %d from its similar group: %w", id, err)
+	}
+	return nil
+}
+
+// CatalogHashAlgo returns the content hash algorithm of the newest image in
+// the catalog, which a scan keeps using unless told otherwise, or "" for an
+// empty catalog.
+func CatalogHashAlgo() (util.HashAlgo, error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return "", err
+	}
+	var md5 string
+	err = db.QueryRow("SELECT md5 FROM images WHERE md5 IS NOT NULL AND md5 != '' ORDER BY id DESC LIMIT 1").Scan(&md5)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up the hash algorithm of the catalog: %w", err)
+	}
+	return util.DigestAlgo(md5), nil
+}
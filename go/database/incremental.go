@@ -0,0 +1,38 @@
+package database
+
+import (
+	"os"
+
+	"picpurge/processor"
+	"picpurge/util"
+)
+
+// Scans skip the files the catalog already holds as they are: a file whose
+// path, size and modification time match its committed image is not read
+// again, unless it was hashed with another algorithm than processor.HashAlgo,
+// whose digests would never match the others. scan --rehash reads them all.
+
+// ChangedFiles returns the files of files a scan has to read: those not yet in
+// the catalog, those whose size or modification time changed since they were
+// committed, and those hashed with another algorithm, which it also counts.
+func ChangedFiles(files []string) (changed []string, rehashed int, err error) {
+	committed, err := CommittedFiles()
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(committed) == 0 {
+		return files, 0, nil
+	}
+	for _, filePath := range files {
+		file, ok := committed[filePath]
+		if ok {
+			if util.DigestAlgo(file.MD5) != processor.HashAlgo() {
+				rehashed++
+			} else if info, err := os.Stat(filePath); err == nil && info.Size() == file.Size && info.ModTime().UnixNano() == file.ModTime {
+				continue
+			}
+		}
+		changed = append(changed, filePath)
+	}
+	return changed, rehashed, nil
+}
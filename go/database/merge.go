@@ -0,0 +1,471 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MergeStats summarises a catalog merge.
+type MergeStats struct {
+	Imported        int // images new to this catalog
+	Updated         int // images whose path was known but the other catalog had newer data
+	Kept            int // images whose path was known and this catalog's data was kept
+	DuplicateGroups int // MD5 groups whose original was re-chosen across both catalogs
+}
+
+// imageReferenceColumns hold image IDs and must be remapped when rows move between catalogs.
+var imageReferenceColumns = []string{"duplicate_of", "same_shot_of", "bracket_set", "panorama_set"}
+
+// mergedImage is an image row read from the other catalog.
+type mergedImage struct {
+	otherID int
+	values  []interface{} // in the order of the shared columns
+	refs    map[string]sql.NullInt64
+	similar []int // similar image IDs in the other catalog
+	newID   int
+	won     bool  // the other catalog's row was written to this catalog
+	ownList []int // this catalog's similar images for a path both catalogs knew
+}
+
+// MergeCatalog imports the images of the catalog at otherPath into this one.
+// pathPrefix is prepended to every imported path, for libraries that this machine
+// reaches under a different mount point. When both catalogs know a path, the row
+// hashed more recently wins. IDs are remapped, group memberships carried over and
+// duplicate groups spanning both catalogs get a single original.
+func MergeCatalog(otherPath, pathPrefix string) (MergeStats, error) {
+	var stats MergeStats
+	// ATTACH would silently create a missing file
+	if _, err := os.Stat(otherPath); err != nil {
+		return stats, fmt.Errorf("failed to open catalog: %w", err)
+	}
+	db, err := GetDBInstance()
+	if err != nil {
+		return stats, err
+	}
+
+	// ATTACH applies to one connection, so pin one for the whole merge.
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return stats, fmt.Errorf("failed to get database connection: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS other", otherPath); err != nil {
+		return stats, fmt.Errorf("failed to open catalog %s: %w", otherPath, err)
+	}
+	defer conn.ExecContext(ctx, "DETACH DATABASE other")
+
+	columns, err := sharedImageColumns(ctx, conn)
+	if err != nil {
+		return stats, err
+	}
+	images, err := readOtherImages(ctx, conn, columns, pathPrefix)
+	if err != nil {
+		return stats, err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return stats, fmt.Errorf("failed to begin merge: %w", err)
+	}
+	defer tx.Rollback()
+
+	idMap := make(map[int]int, len(images))
+	touchedMD5s := make(map[string]bool)
+	pathIndex := indexOf(columns, "file_path")
+	md5Index := indexOf(columns, "md5")
+	for _, image := range images {
+		filePath := image.values[pathIndex]
+		var existingID int
+		var existingModTime sql.NullInt64
+		var existingSimilar sql.NullString
+		err := tx.QueryRow("SELECT id, file_mod_time, similar_images FROM images WHERE file_path = ?", filePath).Scan(&existingID, &existingModTime, &existingSimilar)
+		switch {
+		case err == sql.ErrNoRows:
+			result, err := tx.Exec(fmt.Sprintf("INSERT INTO images (%s) VALUES (%s)",
+				strings.Join(columns, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")), image.values...)
+			if err != nil {
+				return stats, fmt.Errorf("failed to import %v: %w", filePath, err)
+			}
+			id, _ := result.LastInsertId()
+			image.newID, image.won = int(id), true
+			stats.Imported++
+		case err != nil:
+			return stats, fmt.Errorf("failed to look up %v: %w", filePath, err)
+		default:
+			image.newID = existingID
+			if existingSimilar.Valid {
+				json.Unmarshal([]byte(existingSimilar.String), &image.ownList)
+			}
+			otherModTime, _ := image.values[indexOf(columns, "file_mod_time")].(int64)
+			if otherModTime > existingModTime.Int64 {
+				assignments := make([]string, len(columns))
+				for i, column := range columns {
+					assignments[i] = column + " = ?"
+				}
+				args := append(append([]interface{}{}, image.values...), existingID)
+				if _, err := tx.Exec(fmt.Sprintf("UPDATE images SET %s WHERE id = ?", strings.Join(assignments, ", ")), args...); err != nil {
+					return stats, fmt.Errorf("failed to update %v: %w", filePath, err)
+				}
+				image.won = true
+				stats.Updated++
+			} else {
+				stats.Kept++
+			}
+		}
+		idMap[image.otherID] = image.newID
+		if md5, ok := image.values[md5Index].(string); ok && md5 != "" {
+			touchedMD5s[md5] = true
+		}
+	}
+
+	for _, image := range images {
+		if err := remapImageReferences(tx, image, idMap); err != nil {
+			return stats, err
+		}
+	}
+	if err := mergeSideTables(tx, images, idMap); err != nil {
+		return stats, err
+	}
+	for md5 := range touchedMD5s {
+		changed, err := electDuplicateOriginal(tx, md5)
+		if err != nil {
+			return stats, err
+		}
+		if changed {
+			stats.DuplicateGroups++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return stats, fmt.Errorf("failed to commit merge: %w", err)
+	}
+	// Groups both catalogs had parts of join up through their pairs
+	if _, err := linkSimilarLists(db); err != nil {
+		return stats, err
+	}
+	return stats, assignSimilarGroupIDs(db)
+}
+
+// sharedImageColumns returns the images columns, other than id, present in both
+// catalogs, so catalogs written by older versions can still be merged.
+func sharedImageColumns(ctx context.Context, conn *sql.Conn) ([]string, error) {
+	tableColumns := func(schema string) ([]string, error) {
+		rows, err := conn.QueryContext(ctx, fmt.Sprintf("PRAGMA %s.table_info(images)", schema))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		var names []string
+		for rows.Next() {
+			var cid, notNull, pk int
+			var name, columnType string
+			var defaultValue sql.NullString
+			if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+				return nil, err
+			}
+			names = append(names, name)
+		}
+		return names, rows.Err()
+	}
+
+	mainColumns, err := tableColumns("main")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read images columns: %w", err)
+	}
+	otherColumns, err := tableColumns("other")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read images columns of the other catalog: %w", err)
+	}
+	if len(otherColumns) == 0 {
+		return nil, fmt.Errorf("the other file is not a picpurge catalog")
+	}
+	var shared []string
+	for _, column := range mainColumns {
+		if column != "id" && indexOf(otherColumns, column) >= 0 {
+			shared = append(shared, column)
+		}
+	}
+	for _, required := range []string{"file_path", "md5", "file_mod_time", "similar_images"} {
+		if indexOf(shared, required) < 0 {
+			return nil, fmt.Errorf("the other catalog has no %s column; rescan it with this version first", required)
+		}
+	}
+	return shared, nil
+}
+
+func readOtherImages(ctx context.Context, conn *sql.Conn, columns []string, pathPrefix string) ([]*mergedImage, error) {
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT id, %s FROM other.images ORDER BY id", strings.Join(columns, ", ")))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read images of the other catalog: %w", err)
+	}
+	defer rows.Close()
+
+	pathIndex := indexOf(columns, "file_path")
+	similarIndex := indexOf(columns, "similar_images")
+	var images []*mergedImage
+	for rows.Next() {
+		image := &mergedImage{values: make([]interface{}, len(columns)), refs: make(map[string]sql.NullInt64)}
+		dest := make([]interface{}, len(columns)+1)
+		dest[0] = &image.otherID
+		for i := range image.values {
+			dest[i+1] = &image.values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to read image of the other catalog: %w", err)
+		}
+		for i, value := range image.values {
+			switch v := value.(type) {
+			case []byte:
+				image.values[i] = string(v)
+			case time.Time:
+				image.values[i] = v.Format(time.RFC3339) // DATETIME columns are parsed by the driver
+			}
+		}
+		if filePath, ok := image.values[pathIndex].(string); ok && pathPrefix != "" {
+			image.values[pathIndex] = pathPrefix + filePath
+		}
+		for _, column := range imageReferenceColumns {
+			if i := indexOf(columns, column); i >= 0 {
+				if ref, ok := image.values[i].(int64); ok {
+					image.refs[column] = sql.NullInt64{Int64: ref, Valid: true}
+				}
+			}
+		}
+		if similar, ok := image.values[similarIndex].(string); ok {
+			json.Unmarshal([]byte(similar), &image.similar)
+		}
+		images = append(images, image)
+	}
+	return images, rows.Err()
+}
+
+// remapImageReferences rewrites the ID columns of a row written from the other
+// catalog into this catalog's IDs. Similar lists of rows both catalogs knew are merged.
+func remapImageReferences(tx *sql.Tx, image *mergedImage, idMap map[int]int) error {
+	similar := image.ownList
+	for _, otherID := range image.similar {
+		if id, ok := idMap[otherID]; ok && !containsInt(similar, id) {
+			similar = append(similar, id)
+		}
+	}
+	sort.Ints(similar) // members of a group store identical lists
+
+	var similarValue interface{}
+	if len(similar) > 0 {
+		data, err := json.Marshal(similar)
+		if err != nil {
+			return err
+		}
+		similarValue = string(data)
+	}
+	if !image.won {
+		if len(image.similar) == 0 {
+			return nil
+		}
+		_, err := tx.Exec("UPDATE images SET similar_images = ? WHERE id = ?", similarValue, image.newID)
+		return err
+	}
+
+	assignments := []string{"similar_images = ?"}
+	args := []interface{}{similarValue}
+	for _, column := range imageReferenceColumns {
+		ref, ok := image.refs[column]
+		if !ok {
+			continue
+		}
+		var value interface{}
+		if id, ok := idMap[int(ref.Int64)]; ok {
+			value = id
+		}
+		assignments = append(assignments, column+" = ?")
+		args = append(args, value)
+	}
+	args = append(args, image.newID)
+	if _, err := tx.Exec(fmt.Sprintf("UPDATE images SET %s WHERE id = ?", strings.Join(assignments, ", ")), args...); err != nil {
+		return fmt.Errorf("failed to remap references of image %d: %w", image.newID, err)
+	}
+	return nil
+}
+
+// mergeSideTables copies thumbnails, OCR text, tags and group reviews the other catalog has.
+func mergeSideTables(tx *sql.Tx, images []*mergedImage, idMap map[int]int) error {
+	hasTable := func(name string) bool {
+		var n int
+		tx.QueryRow("SELECT COUNT(*) FROM other.sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&n)
+		return n > 0
+	}
+
+	if hasTable("thumbnails") {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO thumbnails (md5, data) SELECT md5, data FROM other.thumbnails"); err != nil {
+			return fmt.Errorf("failed to merge thumbnails: %w", err)
+		}
+	}
+
+	if hasTable("ocr_text") {
+		for _, image := range images {
+			if !image.won {
+				continue
+			}
+			var text string
+			err := tx.QueryRow("SELECT text FROM other.ocr_text WHERE image_id = ?", image.otherID).Scan(&text)
+			if err == sql.ErrNoRows {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read OCR text: %w", err)
+			}
+			if _, err := tx.Exec("INSERT OR REPLACE INTO ocr_text (image_id, text) VALUES (?, ?)", image.newID, text); err != nil {
+				return fmt.Errorf("failed to merge OCR text: %w", err)
+			}
+		}
+	}
+
+	if hasTable("image_tags") {
+		for _, image := range images {
+			if _, err := tx.Exec("INSERT OR IGNORE INTO image_tags (image_id, kind, name) SELECT ?, kind, name FROM other.image_tags WHERE image_id = ?", image.newID, image.otherID); err != nil {
+				return fmt.Errorf("failed to merge tags: %w", err)
+			}
+		}
+	}
+
+	if hasTable("group_reviews") {
+		rows, err := tx.Query("SELECT group_type, group_key, status, notes, updated_at FROM other.group_reviews")
+		if err != nil {
+			return fmt.Errorf("failed to read group reviews: %w", err)
+		}
+		var reviews []GroupReview
+		for rows.Next() {
+			var review GroupReview
+			var updatedAt sql.NullString
+			if err := rows.Scan(&review.GroupType, &review.GroupKey, &review.Status, &review.Notes, &updatedAt); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to read group review: %w", err)
+			}
+			review.UpdatedAt = updatedAt.String
+			reviews = append(reviews, review)
+		}
+		rows.Close()
+
+		for _, review := range reviews {
+			// Similar groups are keyed by their similar_group ID, which is copied
+			// with the images and kept by assignSimilarGroupIDs, and duplicate
+			// groups by MD5. Only reviews older catalogs keyed by the member IDs
+			// of a group need this catalog's IDs.
+			if review.GroupType == GroupTypeSimilar && !IsSimilarGroupID(review.GroupKey) {
+				key, ok := remapSimilarGroupKey(review.GroupKey, idMap)
+				if !ok {
+					continue
+				}
+				review.GroupKey = key
+			}
+			if _, err := tx.Exec("INSERT OR IGNORE INTO group_reviews (group_type, group_key, status, notes, updated_at) VALUES (?, ?, ?, ?, ?)",
+				review.GroupType, review.GroupKey, review.Status, review.Notes, review.UpdatedAt); err != nil {
+				return fmt.Errorf("failed to merge group review: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// remapSimilarGroupKey rewrites a review key that lists the member IDs of a
+// similar group, as a JSON list or joined by dashes, into this catalog's IDs.
+// assignSimilarGroupIDs moves such keys to the ID of the group afterwards.
+func remapSimilarGroupKey(key string, idMap map[int]int) (string, bool) {
+	var ids []int
+	dashed := !strings.HasPrefix(key, "[")
+	if dashed {
+		for _, part := range strings.Split(key, "-") {
+			id, err := strconv.Atoi(part)
+			if err != nil {
+				return "", false
+			}
+			ids = append(ids, id)
+		}
+	} else if err := json.Unmarshal([]byte(key), &ids); err != nil {
+		return "", false
+	}
+	for i, id := range ids {
+		ids[i] = idMap[id]
+	}
+	sort.Ints(ids) // members of a group store sorted lists
+	if dashed {
+		parts := make([]string, len(ids))
+		for i, id := range ids {
+			parts[i] = strconv.Itoa(id)
+		}
+		return strings.Join(parts, "-"), true
+	}
+	data, _ := json.Marshal(ids)
+	return string(data), true
+}
+
+// electDuplicateOriginal makes the best curated active image with md5, or else the
+// oldest, the original of all its copies. It reports whether the group changed.
+func electDuplicateOriginal(tx *sql.Tx, md5 string) (bool, error) {
+	rows, err := tx.Query("SELECT id, is_duplicate, duplicate_of FROM images WHERE md5 = ? AND is_recycled = FALSE ORDER BY "+CurationOrder, md5)
+	if err != nil {
+		return false, fmt.Errorf("failed to query images with MD5 %s: %w", md5, err)
+	}
+	type member struct {
+		id          int
+		isDuplicate bool
+		duplicateOf sql.NullInt64
+	}
+	var members []member
+	for rows.Next() {
+		var m member
+		if err := rows.Scan(&m.id, &m.isDuplicate, &m.duplicateOf); err != nil {
+			rows.Close()
+			return false, fmt.Errorf("failed to scan image with MD5 %s: %w", md5, err)
+		}
+		members = append(members, m)
+	}
+	rows.Close()
+	if len(members) < 2 {
+		return false, nil
+	}
+
+	original := members[0].id
+	changed := members[0].isDuplicate
+	for _, m := range members[1:] {
+		if !m.isDuplicate || int(m.duplicateOf.Int64) != original {
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	if _, err := tx.Exec("UPDATE images SET is_duplicate = FALSE, duplicate_of = NULL WHERE id = ?", original); err != nil {
+		return false, fmt.Errorf("failed to update original for MD5 %s: %w", md5, err)
+	}
+	if _, err := tx.Exec("UPDATE images SET is_duplicate = TRUE, duplicate_of = ? WHERE md5 = ? AND is_recycled = FALSE AND id != ?", original, md5, original); err != nil {
+		return false, fmt.Errorf("failed to update duplicates for MD5 %s: %w", md5, err)
+	}
+	return true, nil
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
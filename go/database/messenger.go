@@ -0,0 +1,132 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"picpurge/processor"
+)
+
+// MessengerUsage is how much of the library one messenger's folders take up.
+type MessengerUsage struct {
+	Messenger string `json:"messenger"`
+	Files     int    `json:"files"`
+	Bytes     int64  `json:"bytes"`
+	// Redundant files are copies of pictures also kept outside the messenger's
+	// folders, or exact duplicates of other messenger files; removing them frees
+	// RedundantBytes.
+	RedundantFiles int   `json:"redundantFiles"`
+	RedundantBytes int64 `json:"redundantBytes"`
+}
+
+// ClassifyMessengers sets the messenger of every active image from its path,
+// so catalogs hashed before messenger folders were recognized pick them up
+// without rehashing. It returns the number of images that changed.
+func ClassifyMessengers() (int, error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return 0, err
+	}
+	rows, err := db.Query("SELECT id, file_path, COALESCE(messenger, '') FROM images WHERE is_recycled = FALSE")
+	if err != nil {
+		return 0, fmt.Errorf("failed to query images: %w", err)
+	}
+	changes := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var filePath, messenger string
+		if err := rows.Scan(&id, &filePath, &messenger); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan image: %w", err)
+		}
+		if detected := processor.MessengerOf(filePath); detected != messenger {
+			changes[id] = detected
+		}
+	}
+	rows.Close()
+
+	for id, messenger := range changes {
+		if _, err := db.Exec("UPDATE images SET messenger = ? WHERE id = ?", nullIfEmpty(messenger), id); err != nil {
+			return 0, fmt.Errorf("failed to update image %d: %w", id, err)
+		}
+	}
+	return len(changes), nil
+}
+
+// GetMessengerUsage reports the space taken by each messenger's files and how
+// much of it removing their redundant copies would free.
+func GetMessengerUsage() ([]MessengerUsage, error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`
+		SELECT id, COALESCE(messenger, ''), COALESCE(file_size, 0), is_duplicate, same_shot_of, COALESCE(similar_images, '')
+		FROM images WHERE is_recycled = FALSE
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query images: %w", err)
+	}
+	type member struct {
+		messenger     string
+		size          int64
+		isDuplicate   bool
+		sameShotOf    sql.NullInt64
+		similarImages string
+	}
+	members := make(map[int]member)
+	for rows.Next() {
+		var id int
+		var m member
+		if err := rows.Scan(&id, &m.messenger, &m.size, &m.isDuplicate, &m.sameShotOf, &m.similarImages); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan image: %w", err)
+		}
+		members[id] = m
+	}
+	rows.Close()
+
+	// A similar group or re-encode counts when some member lies outside messenger folders
+	keptElsewhere := func(m member) bool {
+		if m.sameShotOf.Valid && members[int(m.sameShotOf.Int64)].messenger == "" {
+			return true
+		}
+		var group []int
+		if m.similarImages == "" || json.Unmarshal([]byte(m.similarImages), &group) != nil {
+			return false
+		}
+		for _, id := range group {
+			if other, ok := members[id]; ok && other.messenger == "" {
+				return true
+			}
+		}
+		return false
+	}
+
+	usage := make(map[string]*MessengerUsage)
+	for _, m := range members {
+		if m.messenger == "" {
+			continue
+		}
+		u := usage[m.messenger]
+		if u == nil {
+			u = &MessengerUsage{Messenger: m.messenger}
+			usage[m.messenger] = u
+		}
+		u.Files++
+		u.Bytes += m.size
+		if m.isDuplicate || keptElsewhere(m) {
+			u.RedundantFiles++
+			u.RedundantBytes += m.size
+		}
+	}
+
+	result := make([]MessengerUsage, 0, len(usage))
+	for _, u := range usage {
+		result = append(result, *u)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Bytes > result[j].Bytes })
+	return result, nil
+}
@@ -0,0 +1,31 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+)
+
+const createOCRTextTableSQL = `
+CREATE TABLE IF NOT EXISTS ocr_text (
+	image_id INTEGER PRIMARY KEY,
+	text TEXT NOT NULL
+);
+`
+
+// SetOCRText replaces the text extracted from an image.
+func SetOCRText(imageID int, text string) error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec("DELETE FROM ocr_text WHERE image_id = ?", imageID); err != nil {
+		return fmt.Errorf("failed to clear OCR text: %w", err)
+	}
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	if _, err := db.Exec("INSERT INTO ocr_text (image_id, text) VALUES (?, ?)", imageID, text); err != nil {
+		return fmt.Errorf("failed to store OCR text: %w", err)
+	}
+	return nil
+}
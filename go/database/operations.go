@@ -0,0 +1,261 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"picpurge/events"
+)
+
+// OperationKind names an action that moves files.
+type OperationKind string
+
+// Operation kinds.
+const (
+	OperationRecycle     OperationKind = "recycle"      // recycled from the web interface
+	OperationApplyCSV    OperationKind = "apply-csv"    // decisions applied from a review CSV
+	OperationAutoRecycle OperationKind = "auto-recycle" // --auto-recycle-duplicates or --auto-recycle-same-dir
+	OperationSort        OperationKind = "sort"         // --sort without --sort-destination
+)
+
+// The operations log records every file move so that an action can be undone.
+// An operation is one action, e.g. an auto-recycle run; its moves are the
+// files it moved, in order.
+const createOperationsTableSQL = `
+CREATE TABLE IF NOT EXISTS operations (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind TEXT NOT NULL,
+	started_at DATETIME NOT NULL,
+	undone_at DATETIME
+);
+CREATE TABLE IF NOT EXISTS operation_moves (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	operation_id INTEGER NOT NULL REFERENCES operations(id),
+	image_id INTEGER, -- NULL for files that were not in the catalog
+	src TEXT NOT NULL,
+	dst TEXT NOT NULL,
+	undone BOOLEAN NOT NULL DEFAULT FALSE
+);
+CREATE INDEX IF NOT EXISTS idx_operation_moves_operation ON operation_moves(operation_id);
+`
+
+// Operation is an action in the operations log.
+type Operation struct {
+	ID        int64
+	Kind      OperationKind
+	StartedAt time.Time
+	UndoneAt  *time.Time // set once every move was undone
+	Moves     int
+	Undone    int // moves undone so far
+}
+
+// OperationMove is one file moved by an operation.
+type OperationMove struct {
+	ID      int64
+	ImageID int // 0 for files that were not in the catalog
+	Src     string
+	Dst     string
+	Undone  bool
+}
+
+// BeginOperation starts an operation in the log and returns its ID for
+// RecordMove. Operations that end up moving nothing are not listed.
+func BeginOperation(kind OperationKind) (int64, error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return 0, err
+	}
+	result, err := db.Exec("INSERT INTO operations (kind, started_at) VALUES (?, ?)", string(kind), time.Now().UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to start operation: %w", err)
+	}
+	return result.LastInsertId()
+}
+
+// RecordMove logs that an operation moved src to dst. Call it once the catalog
+// has recorded the move, so a move rolled back because the catalog could not
+// is not logged; the image is found at src or dst. A move to an unknown dst,
+// such as the Windows Recycle Bin, is not logged as it cannot be undone.
+func RecordMove(operationID int64, src, dst string) error {
+	if dst == "" {
+		return nil
+	}
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	// The recycle directory may be relative to where picpurge was started
+	if abs, err := filepath.Abs(dst); err == nil {
+		dst = abs
+	}
+	if _, err := db.Exec("INSERT INTO operation_moves (operation_id, image_id, src, dst) VALUES (?, (SELECT id FROM images WHERE file_path IN (?, ?)), ?, ?)",
+		operationID, src, dst, src, dst); err != nil {
+		return fmt.Errorf("failed to log move of %s: %w", src, err)
+	}
+	return nil
+}
+
+// operationSelect reads operations with the number of their moves, leaving out
+// operations that moved nothing.
+const operationSelect = `SELECT o.id, o.kind, o.started_at, o.undone_at, COUNT(m.id), COALESCE(SUM(m.undone), 0)
+	FROM operations o JOIN operation_moves m ON m.operation_id = o.id`
+
+func scanOperations(rows *sql.Rows) ([]Operation, error) {
+	defer rows.Close()
+	var operations []Operation
+	for rows.Next() {
+		var op Operation
+		var kind string
+		var undoneAt sql.NullTime
+		if err := rows.Scan(&op.ID, &kind, &op.StartedAt, &undoneAt, &op.Moves, &op.Undone); err != nil {
+			return nil, fmt.Errorf("failed to read operation: %w", err)
+		}
+		op.Kind = OperationKind(kind)
+		if undoneAt.Valid {
+			op.UndoneAt = &undoneAt.Time
+		}
+		operations = append(operations, op)
+	}
+	return operations, rows.Err()
+}
+
+// Operations returns up to limit operations of the log, newest first.
+func Operations(limit int) ([]Operation, error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(operationSelect+" GROUP BY o.id ORDER BY o.id DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list operations: %w", err)
+	}
+	return scanOperations(rows)
+}
+
+// GetOperation returns the operation with the given ID. With id 0 it returns
+// the newest operation that is not completely undone.
+func GetOperation(id int64) (Operation, error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return Operation{}, err
+	}
+	var rows *sql.Rows
+	if id == 0 {
+		rows, err = db.Query(operationSelect + " WHERE o.undone_at IS NULL GROUP BY o.id ORDER BY o.id DESC LIMIT 1")
+	} else {
+		rows, err = db.Query(operationSelect+" WHERE o.id = ? GROUP BY o.id", id)
+	}
+	if err != nil {
+		return Operation{}, fmt.Errorf("failed to look up operation: %w", err)
+	}
+	operations, err := scanOperations(rows)
+	if err != nil {
+		return Operation{}, err
+	}
+	if len(operations) == 0 {
+		if id == 0 {
+			return Operation{}, fmt.Errorf("no operation left to undo")
+		}
+		return Operation{}, fmt.Errorf("operation %d not found", id)
+	}
+	return operations[0], nil
+}
+
+// OperationMoves returns the moves of an operation in the order to undo them,
+// the last move first.
+func OperationMoves(operationID int64) ([]OperationMove, error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT id, COALESCE(image_id, 0), src, dst, undone FROM operation_moves WHERE operation_id = ? ORDER BY id DESC", operationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list moves of operation %d: %w", operationID, err)
+	}
+	defer rows.Close()
+	var moves []OperationMove
+	for rows.Next() {
+		var move OperationMove
+		if err := rows.Scan(&move.ID, &move.ImageID, &move.Src, &move.Dst, &move.Undone); err != nil {
+			return nil, fmt.Errorf("failed to read move: %w", err)
+		}
+		moves = append(moves, move)
+	}
+	return moves, rows.Err()
+}
+
+// UndoMove updates the catalog after the file of move was moved back to its
+// source: the image takes its old path again and, if it was recycled, rejoins
+// its duplicate and similar groups.
+func UndoMove(move OperationMove) error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	if move.ImageID != 0 {
+		if err := restoreImage(move.ImageID, move.Src); err != nil && err != sql.ErrNoRows {
+			return err
+		}
+	}
+	if _, err := db.Exec("UPDATE operation_moves SET undone = TRUE WHERE id = ?", move.ID); err != nil {
+		return fmt.Errorf("failed to mark move as undone: %w", err)
+	}
+	return nil
+}
+
+// FinishUndo marks an operation as undone once none of its moves is left.
+// It reports whether the operation is now completely undone.
+func FinishUndo(operationID int64) (bool, error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return false, err
+	}
+	result, err := db.Exec(`UPDATE operations SET undone_at = ? WHERE id = ? AND undone_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM operation_moves WHERE operation_id = operations.id AND undone = FALSE)`, time.Now().UTC(), operationID)
+	if err != nil {
+		return false, fmt.Errorf("failed to finish undo of operation %d: %w", operationID, err)
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		return true, nil
+	}
+	var remaining int
+	if err := db.QueryRow("SELECT COUNT(*) FROM operation_moves WHERE operation_id = ? AND undone = FALSE", operationID).Scan(&remaining); err != nil {
+		return false, fmt.Errorf("failed to count moves of operation %d: %w", operationID, err)
+	}
+	return remaining == 0, nil
+}
+
+// restoreImage gives an image back its path and, if it was recycled, returns
+// it to its duplicate and similar groups. It returns sql.ErrNoRows for images
+// that are no longer in the catalog.
+func restoreImage(id int, filePath string) error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	var md5, path string
+	var recycled bool
+	if err := db.QueryRow("SELECT md5, file_path, is_recycled FROM images WHERE id = ?", id).Scan(&md5, &path, &recycled); err != nil {
+		if err == sql.ErrNoRows {
+			return err
+		}
+		return fmt.Errorf("failed to look up image ID %d: %w", id, err)
+	}
+	if err := moveWriteFault(); err != nil {
+		return fmt.Errorf("failed to restore image ID %d: %w", id, err)
+	}
+	if _, err := db.Exec("UPDATE images SET file_path = ?, is_recycled = FALSE, recycled_at = NULL WHERE id = ?", filePath, id); err != nil {
+		return fmt.Errorf("failed to restore image ID %d: %w", id, err)
+	}
+	if recycled {
+		if err := RegroupImage(id, ""); err != nil {
+			return fmt.Errorf("failed to regroup restored image ID %d: %w", id, err)
+		}
+	}
+	if path != filePath {
+		events.Publish(events.Event{Kind: events.ImageMoved, ImageID: id, MD5: md5, Path: filePath, OldPath: path})
+	}
+	return nil
+}
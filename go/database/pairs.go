@@ -0,0 +1,199 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"picpurge/grouping"
+)
+
+// similar_pairs holds a row for every two images in one similar group, with
+// their distance when an analysis measured it. Groups are the connected
+// images of these pairs; the similar_images list every member stores is kept
+// for older readers and follows the pairs.
+const createSimilarPairsTableSQL = `
+CREATE TABLE IF NOT EXISTS similar_pairs (
+	id_a INTEGER NOT NULL, -- the lower ID
+	id_b INTEGER NOT NULL,
+	distance INTEGER, -- NULL for images grouped by hand or by an older version
+	PRIMARY KEY (id_a, id_b)
+);
+CREATE INDEX IF NOT EXISTS idx_similar_pairs_id_b ON similar_pairs(id_b);
+`
+
+// storeSimilarGroup makes group, sorted, the similar group of its members: it
+// stores the list on every member and a pair for every two of them, and drops
+// the pairs linking them to images outside it. distance, which may be nil,
+// returns the measured distance of two members; pairs it does not know keep
+// the distance they had. A group of fewer than two images is cleared.
+func storeSimilarGroup(tx *sql.Tx, group []int, distance func(a, b int) (int, bool)) error {
+	if len(group) == 0 {
+		return nil
+	}
+	var value interface{} // NULL once nothing but the image itself is left
+	if len(group) > 1 {
+		data, _ := json.Marshal(group)
+		value = string(data)
+	}
+	setList, err := tx.Prepare("UPDATE images SET similar_images = ? WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("failed to update similar groups: %w", err)
+	}
+	defer setList.Close()
+	for _, id := range group {
+		if _, err := setList.Exec(value, id); err != nil {
+			return fmt.Errorf("failed to update similar group of image ID %d: %w", id, err)
+		}
+	}
+
+	members := make([]interface{}, len(group))
+	for i, id := range group {
+		members[i] = id
+	}
+	in := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(group)), ", ") + ")"
+	statement := "DELETE FROM similar_pairs WHERE (id_a IN " + in + ") != (id_b IN " + in + ")"
+	if len(group) < 2 {
+		statement = "DELETE FROM similar_pairs WHERE id_a IN " + in + " OR id_b IN " + in
+	}
+	if _, err := tx.Exec(statement, append(members, members...)...); err != nil {
+		return fmt.Errorf("failed to update similar pairs: %w", err)
+	}
+	if len(group) < 2 {
+		return nil
+	}
+	measured, err := tx.Prepare("INSERT INTO similar_pairs (id_a, id_b, distance) VALUES (?, ?, ?) ON CONFLICT(id_a, id_b) DO UPDATE SET distance = excluded.distance")
+	if err != nil {
+		return fmt.Errorf("failed to update similar pairs: %w", err)
+	}
+	defer measured.Close()
+	linked, err := tx.Prepare("INSERT OR IGNORE INTO similar_pairs (id_a, id_b) VALUES (?, ?)")
+	if err != nil {
+		return fmt.Errorf("failed to update similar pairs: %w", err)
+	}
+	defer linked.Close()
+	for i, a := range group {
+		for _, b := range group[i+1:] {
+			var err error
+			if d, ok := distanceOf(distance, a, b); ok {
+				_, err = measured.Exec(min(a, b), max(a, b), d)
+			} else {
+				_, err = linked.Exec(min(a, b), max(a, b))
+			}
+			if err != nil {
+				return fmt.Errorf("failed to store similar pair %d-%d: %w", a, b, err)
+			}
+		}
+	}
+	return nil
+}
+
+func distanceOf(distance func(a, b int) (int, bool), a, b int) (int, bool) {
+	if distance == nil {
+		return 0, false
+	}
+	if d, ok := distance(a, b); ok {
+		return d, true
+	}
+	return distance(b, a)
+}
+
+// StoreSimilarGroups stores the similar groups of an analysis, with the
+// distances of the pairs it found, and assigns their IDs.
+func StoreSimilarGroups(groups [][]int, pairs []grouping.Pair) error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	distances := make(map[[2]int]int, len(pairs))
+	for _, p := range pairs {
+		distances[[2]int{p.A, p.B}] = p.Distance
+	}
+	distance := func(a, b int) (int, bool) {
+		d, ok := distances[[2]int{a, b}]
+		return d, ok
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to store similar groups: %w", err)
+	}
+	defer tx.Rollback()
+	for _, group := range groups {
+		if err := storeSimilarGroup(tx, group, distance); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to store similar groups: %w", err)
+	}
+	return assignSimilarGroupIDs(db)
+}
+
+// linkSimilarLists adds the pairs the similar_images lists of active images
+// imply and returns how many lists it read, e.g. for catalogs written before
+// similar_pairs or merged from another catalog.
+func linkSimilarLists(db *sql.DB) (int, error) {
+	rows, err := db.Query("SELECT DISTINCT similar_images FROM images WHERE is_recycled = FALSE AND similar_images IS NOT NULL AND similar_images NOT IN ('', '[]')")
+	if err != nil {
+		return 0, fmt.Errorf("failed to query similar groups: %w", err)
+	}
+	var lists [][]int
+	for rows.Next() {
+		var similarJSON string
+		if err := rows.Scan(&similarJSON); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan similar group: %w", err)
+		}
+		var members []int
+		if json.Unmarshal([]byte(similarJSON), &members) == nil && len(members) > 1 {
+			lists = append(lists, members)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to link similar groups: %w", err)
+	}
+	defer tx.Rollback()
+	for _, members := range lists {
+		for i, a := range members {
+			for _, b := range members[i+1:] {
+				if a == b {
+					continue
+				}
+				if _, err := tx.Exec("INSERT OR IGNORE INTO similar_pairs (id_a, id_b) VALUES (?, ?)", min(a, b), max(a, b)); err != nil {
+					return 0, fmt.Errorf("failed to store similar pair %d-%d: %w", a, b, err)
+				}
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to link similar groups: %w", err)
+	}
+	return len(lists), nil
+}
+
+// fillSimilarPairs fills in similar_pairs of catalogs that only have the
+// similar_images lists.
+func fillSimilarPairs(db *sql.DB) error {
+	var hasPairs bool
+	if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM similar_pairs)").Scan(&hasPairs); err != nil {
+		return fmt.Errorf("failed to read similar pairs: %w", err)
+	}
+	if hasPairs {
+		return nil
+	}
+	lists, err := linkSimilarLists(db)
+	if err != nil || lists == 0 {
+		return err
+	}
+	log.Printf("ConnectDb: Filled in similar_pairs from %d similar groups.", lists)
+	return assignSimilarGroupIDs(db)
+}
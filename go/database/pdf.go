@@ -0,0 +1,188 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+
+	"picpurge/processor"
+)
+
+// pdf_pages holds the hashes of the rendered pages of PDFs found by scans with
+// --pdf-previews. The PDFs are not catalogued; their pages are only compared
+// with the catalogued images.
+const createPDFPagesTableSQL = `
+CREATE TABLE IF NOT EXISTS pdf_pages (
+	pdf_path TEXT NOT NULL,
+	page INTEGER NOT NULL, -- from 1
+	file_mod_time INTEGER NOT NULL, -- of the PDF when it was rendered, in Unix nanoseconds
+	all_pages BOOLEAN NOT NULL, -- every page was rendered, not just the first
+	width INTEGER,
+	height INTEGER,
+	phash TEXT NOT NULL,
+	turned_phashes TEXT,
+	PRIMARY KEY (pdf_path, page)
+);
+`
+
+// PDFRendered reports whether the pages of the PDF at pdfPath were rendered
+// since it last changed, every page if allPages is set.
+func PDFRendered(pdfPath string, modTime int64, allPages bool) (bool, error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return false, err
+	}
+	var rendered bool
+	err = db.QueryRow("SELECT EXISTS (SELECT 1 FROM pdf_pages WHERE pdf_path = ? AND file_mod_time = ? AND (all_pages OR NOT ?))", pdfPath, modTime, allPages).Scan(&rendered)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up PDF %s: %w", pdfPath, err)
+	}
+	return rendered, nil
+}
+
+// StorePDFPages replaces the stored pages of the PDF at pdfPath.
+func StorePDFPages(pdfPath string, modTime int64, allPages bool, pages []processor.PDFPage) error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to store pages of %s: %w", pdfPath, err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM pdf_pages WHERE pdf_path = ?", pdfPath); err != nil {
+		return fmt.Errorf("failed to store pages of %s: %w", pdfPath, err)
+	}
+	for _, page := range pages {
+		if _, err := tx.Exec("INSERT INTO pdf_pages (pdf_path, page, file_mod_time, all_pages, width, height, phash, turned_phashes) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			pdfPath, page.Page, modTime, allPages, page.Width, page.Height, page.PHash, page.TurnedPHashes); err != nil {
+			return fmt.Errorf("failed to store page %d of %s: %w", page.Page, pdfPath, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to store pages of %s: %w", pdfPath, err)
+	}
+	return nil
+}
+
+// ForgetMissingPDFs drops the pages of PDFs that are no longer on disk and
+// returns how many PDFs it forgot.
+func ForgetMissingPDFs() (int, error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return 0, err
+	}
+	rows, err := db.Query("SELECT DISTINCT pdf_path FROM pdf_pages")
+	if err != nil {
+		return 0, fmt.Errorf("failed to query PDFs: %w", err)
+	}
+	var missing []string
+	for rows.Next() {
+		var pdfPath string
+		if err := rows.Scan(&pdfPath); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan PDF: %w", err)
+		}
+		if _, err := os.Stat(pdfPath); os.IsNotExist(err) {
+			missing = append(missing, pdfPath)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, pdfPath := range missing {
+		if _, err := db.Exec("DELETE FROM pdf_pages WHERE pdf_path = ?", pdfPath); err != nil {
+			return 0, fmt.Errorf("failed to forget PDF %s: %w", pdfPath, err)
+		}
+	}
+	return len(missing), nil
+}
+
+// PDFMatch is a PDF page that shows a catalogued image.
+type PDFMatch struct {
+	PDFPath  string `json:"pdfPath"`
+	Page     int    `json:"page"`
+	ImageID  int    `json:"imageId"`
+	FilePath string `json:"filePath"`
+	Distance int    `json:"distance"` // between the pHashes of the page and the image
+}
+
+// PDFMatches looks up every stored PDF page in the pHash index of the active
+// images and returns the pairs within processor.PHashThreshold, by PDF and
+// page and closest first.
+func PDFMatches() ([]PDFMatch, error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT pdf_path, page, width, height, phash, COALESCE(turned_phashes, '') FROM pdf_pages ORDER BY pdf_path, page")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PDF pages: %w", err)
+	}
+	var pages []hashedImage // FilePath is the PDF and ID the page number
+	for rows.Next() {
+		var page hashedImage
+		var phash, turned string
+		var width, height sql.NullInt64
+		if err := rows.Scan(&page.FilePath, &page.ID, &width, &height, &phash, &turned); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read PDF pages: %w", err)
+		}
+		if page.PHashes, err = processor.ParsePHashes(phash, turned); err != nil {
+			continue
+		}
+		page.Width, page.Height = int(width.Int64), int(height.Int64)
+		pages = append(pages, page)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read PDF pages: %w", err)
+	}
+	if len(pages) == 0 {
+		return nil, nil
+	}
+
+	index, err := PHashIndex()
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([][]int, len(pages))
+	seen := make(map[int]bool)
+	var ids []int
+	for i, page := range pages {
+		candidates[i] = index.Candidates(page.PHashes, processor.PHashThreshold)
+		for _, id := range candidates[i] {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	images, err := hashedImagesByID(db, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read images: %w", err)
+	}
+
+	var matches []PDFMatch
+	for i, page := range pages {
+		var found []PDFMatch
+		for _, id := range candidates[i] {
+			image, ok := images[id]
+			if !ok {
+				continue
+			}
+			// Only the aspect ratio is compared: pages are rendered at a size of their own
+			upright := processor.AspectDelta(page.Width, page.Height, image.Width, image.Height) <= processor.AspectRatioTolerance
+			sideways := processor.AspectDelta(page.Width, page.Height, image.Height, image.Width) <= processor.AspectRatioTolerance
+			if d, _ := page.PHashes.Distance(image.PHashes, upright, sideways); d <= processor.PHashThreshold {
+				found = append(found, PDFMatch{PDFPath: page.FilePath, Page: page.ID, ImageID: image.ID, FilePath: image.FilePath, Distance: d})
+			}
+		}
+		sort.SliceStable(found, func(i, j int) bool { return found[i].Distance < found[j].Distance })
+		matches = append(matches, found...)
+	}
+	return matches, nil
+}
@@ -0,0 +1,223 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Phase identifies one step of a scan run.
+type Phase string
+
+// The phases of a run, in execution order.
+const (
+	PhaseWalk    Phase = "walk"    // discover image files
+	PhaseHash    Phase = "hash"    // hash files and extract metadata
+	PhaseAnalyze Phase = "analyze" // find exact duplicates
+	PhaseGroup   Phase = "group"   // find similar images
+	PhaseOCR     Phase = "ocr"     // optional text extraction from screenshots
+	PhaseActions Phase = "actions" // optional sort and other file operations
+)
+
+// Phases lists all phases in execution order.
+var Phases = []Phase{PhaseWalk, PhaseHash, PhaseAnalyze, PhaseGroup, PhaseOCR, PhaseActions}
+
+// Phase statuses.
+const (
+	PhaseStatusPending = "pending"
+	PhaseStatusRunning = "running"
+	PhaseStatusDone    = "done"
+	PhaseStatusSkipped = "skipped"
+	PhaseStatusFailed  = "failed"
+)
+
+// PhaseCheckpoint is the persisted state of one phase.
+type PhaseCheckpoint struct {
+	Phase      Phase       `json:"phase"`
+	Status     string      `json:"status"`
+	Total      int         `json:"total"`
+	Done       int         `json:"done"`
+	StartedAt  *time.Time  `json:"startedAt"`
+	FinishedAt *time.Time  `json:"finishedAt"`
+	Error      string      `json:"error,omitempty"`
+	Summary    *RunSummary `json:"summary,omitempty"` // counts when the phase finished
+}
+
+// RunSummary counts what a run has found so far. Counts of phases that have
+// not run yet are zero.
+type RunSummary struct {
+	FilesWalked      int   `json:"filesWalked"`
+	FilesHashed      int   `json:"filesHashed"`
+	FilesDecoded     int   `json:"filesDecoded"` // hashed files whose pixels could be read
+	DuplicateGroups  int   `json:"duplicateGroups"`
+	SimilarGroups    int   `json:"similarGroups"`
+	ReclaimableBytes int64 `json:"reclaimableBytes"` // size of all exact duplicates
+}
+
+const createPhasesTableSQL = `
+CREATE TABLE IF NOT EXISTS run_phases (
+	phase TEXT PRIMARY KEY,
+	position INTEGER NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	total INTEGER NOT NULL DEFAULT 0,
+	done INTEGER NOT NULL DEFAULT 0,
+	started_at DATETIME,
+	finished_at DATETIME,
+	error TEXT,
+	summary TEXT -- RunSummary as JSON when the phase finished
+);
+`
+
+// addedPhaseColumns lists run_phases columns added after the table was introduced.
+var addedPhaseColumns = []addedColumn{
+	{"summary", "TEXT"},
+}
+
+// ResetPhases marks every phase as pending, starting a new run.
+func ResetPhases() error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	for i, phase := range Phases {
+		_, err := db.Exec(`
+			INSERT INTO run_phases (phase, position, status, total, done, started_at, finished_at, error, summary)
+			VALUES (?, ?, ?, 0, 0, NULL, NULL, NULL, NULL)
+			ON CONFLICT(phase) DO UPDATE SET status = excluded.status, total = 0, done = 0,
+				started_at = NULL, finished_at = NULL, error = NULL, summary = NULL
+		`, string(phase), i, PhaseStatusPending)
+		if err != nil {
+			return fmt.Errorf("failed to reset phase %s: %w", phase, err)
+		}
+	}
+	return nil
+}
+
+// StartPhase marks a phase as running with the given amount of work.
+func StartPhase(phase Phase, total int) error {
+	return execPhase(phase, "UPDATE run_phases SET status = ?, total = ?, done = 0, started_at = ?, finished_at = NULL, error = NULL WHERE phase = ?",
+		PhaseStatusRunning, total, time.Now().Format(time.RFC3339), string(phase))
+}
+
+// UpdatePhaseProgress records how much of a running phase is complete.
+func UpdatePhaseProgress(phase Phase, done int) error {
+	return execPhase(phase, "UPDATE run_phases SET done = ? WHERE phase = ?", done, string(phase))
+}
+
+// FinishPhase checkpoints a phase as done, or failed when phaseErr is not nil.
+func FinishPhase(phase Phase, phaseErr error) error {
+	status, errText := PhaseStatusDone, ""
+	if phaseErr != nil {
+		status, errText = PhaseStatusFailed, phaseErr.Error()
+	}
+	return execPhase(phase, "UPDATE run_phases SET status = ?, finished_at = ?, error = ? WHERE phase = ?",
+		status, time.Now().Format(time.RFC3339), errText, string(phase))
+}
+
+// SkipPhase marks a phase that will not run.
+func SkipPhase(phase Phase) error {
+	return execPhase(phase, "UPDATE run_phases SET status = ? WHERE phase = ?", PhaseStatusSkipped, string(phase))
+}
+
+// GetPhases returns the checkpoints of all phases in execution order.
+func GetPhases() ([]PhaseCheckpoint, error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT phase, status, total, done, started_at, finished_at, error, summary FROM run_phases ORDER BY position ASC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query phases: %w", err)
+	}
+	defer rows.Close()
+
+	var phases []PhaseCheckpoint
+	for rows.Next() {
+		var cp PhaseCheckpoint
+		var phase string
+		var startedAt, finishedAt, errText, summary sql.NullString
+		if err := rows.Scan(&phase, &cp.Status, &cp.Total, &cp.Done, &startedAt, &finishedAt, &errText, &summary); err != nil {
+			return nil, fmt.Errorf("failed to scan phase: %w", err)
+		}
+		if summary.Valid && summary.String != "" {
+			cp.Summary = &RunSummary{}
+			if err := json.Unmarshal([]byte(summary.String), cp.Summary); err != nil {
+				return nil, fmt.Errorf("failed to parse summary of phase %s: %w", phase, err)
+			}
+		}
+		cp.Phase = Phase(phase)
+		cp.StartedAt = parseNullTime(startedAt)
+		cp.FinishedAt = parseNullTime(finishedAt)
+		cp.Error = errText.String
+		phases = append(phases, cp)
+	}
+	return phases, rows.Err()
+}
+
+// SetPhaseSummary stores the counts of the run as of the end of a phase.
+func SetPhaseSummary(phase Phase, summary RunSummary) error {
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("failed to encode summary: %w", err)
+	}
+	return execPhase(phase, "UPDATE run_phases SET summary = ? WHERE phase = ?", string(data), string(phase))
+}
+
+// CatalogSummary counts the active images of the catalog and what analysis has
+// found in them. FilesWalked is left for the caller, who knows what was walked.
+func CatalogSummary() (RunSummary, error) {
+	var s RunSummary
+	db, err := GetDBInstance()
+	if err != nil {
+		return s, err
+	}
+	err = db.QueryRow(`
+		SELECT COUNT(*),
+			COALESCE(SUM(image_width > 0 AND image_height > 0), 0),
+			COUNT(DISTINCT CASE WHEN is_duplicate THEN md5 END),
+			COUNT(DISTINCT similar_group),
+			COALESCE(SUM(CASE WHEN is_duplicate THEN file_size ELSE 0 END), 0)
+		FROM images WHERE is_recycled = FALSE
+	`).Scan(&s.FilesHashed, &s.FilesDecoded, &s.DuplicateGroups, &s.SimilarGroups, &s.ReclaimableBytes)
+	if err != nil {
+		return s, fmt.Errorf("failed to summarize catalog: %w", err)
+	}
+	return s, nil
+}
+
+// CurrentPhase returns the first phase that is not done or skipped, or "" when the run is complete.
+func CurrentPhase() (Phase, error) {
+	phases, err := GetPhases()
+	if err != nil {
+		return "", err
+	}
+	for _, cp := range phases {
+		if cp.Status != PhaseStatusDone && cp.Status != PhaseStatusSkipped {
+			return cp.Phase, nil
+		}
+	}
+	return "", nil
+}
+
+func execPhase(phase Phase, query string, args ...interface{}) error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to update phase %s: %w", phase, err)
+	}
+	return nil
+}
+
+func parseNullTime(s sql.NullString) *time.Time {
+	if !s.Valid || s.String == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, s.String)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
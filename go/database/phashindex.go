@@ -0,0 +1,163 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"picpurge/processor"
+)
+
+// phash_index holds a single row with the pHash index of the active images,
+// so that serve and pdf-matches do not parse every pHash before their first
+// lookup. The triggers count every change to the indexed images in
+// generation; the stored index is current while built_generation matches it.
+const createPHashIndexTableSQL = `
+CREATE TABLE IF NOT EXISTS phash_index (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	generation INTEGER NOT NULL DEFAULT 0,
+	built_generation INTEGER, -- NULL until an index was stored
+	data BLOB
+);
+INSERT OR IGNORE INTO phash_index (id) VALUES (1);
+CREATE TRIGGER IF NOT EXISTS phash_index_insert AFTER INSERT ON images BEGIN
+	UPDATE phash_index SET generation = generation + 1;
+END;
+CREATE TRIGGER IF NOT EXISTS phash_index_update AFTER UPDATE OF phash, turned_phashes, is_recycled ON images BEGIN
+	UPDATE phash_index SET generation = generation + 1;
+END;
+CREATE TRIGGER IF NOT EXISTS phash_index_delete AFTER DELETE ON images BEGIN
+	UPDATE phash_index SET generation = generation + 1;
+END;
+`
+
+var (
+	phashIndexMutex      sync.Mutex
+	phashIndexCache      *processor.PHashIndex // the index last loaded or built
+	phashIndexGeneration int64                 // the generation phashIndexCache was built at
+)
+
+// PHashIndex returns the index of the pHashes of the active images. It is kept
+// in memory and in the catalog, and only built again after images changed.
+func PHashIndex() (*processor.PHashIndex, error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return nil, err
+	}
+	phashIndexMutex.Lock()
+	defer phashIndexMutex.Unlock()
+
+	var generation int64
+	var builtGeneration sql.NullInt64
+	var data []byte
+	if err := db.QueryRow("SELECT generation, built_generation, data FROM phash_index WHERE id = 1").Scan(&generation, &builtGeneration, &data); err != nil {
+		return nil, fmt.Errorf("failed to read pHash index: %w", err)
+	}
+	if phashIndexCache != nil && phashIndexGeneration == generation {
+		return phashIndexCache, nil
+	}
+	if builtGeneration.Valid && builtGeneration.Int64 == generation {
+		index := processor.NewPHashIndex()
+		if err := index.UnmarshalBinary(data); err == nil {
+			phashIndexCache, phashIndexGeneration = index, generation
+			return index, nil
+		}
+		log.Printf("Warning: The stored pHash index is corrupt; building it again.\n")
+	}
+
+	// Read the images and the generation they belong to in one transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if err := tx.QueryRow("SELECT generation FROM phash_index WHERE id = 1").Scan(&generation); err != nil {
+		return nil, fmt.Errorf("failed to read pHash index: %w", err)
+	}
+	index, err := buildPHashIndex(tx)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = index.MarshalBinary(); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("UPDATE phash_index SET built_generation = ?, data = ? WHERE id = 1", generation, data); err != nil {
+		return nil, fmt.Errorf("failed to store pHash index: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to store pHash index: %w", err)
+	}
+	phashIndexCache, phashIndexGeneration = index, generation
+	return index, nil
+}
+
+// buildPHashIndex indexes the pHashes of the active images.
+func buildPHashIndex(tx *sql.Tx) (*processor.PHashIndex, error) {
+	rows, err := tx.Query("SELECT id, phash, COALESCE(turned_phashes, '') FROM images WHERE is_recycled = FALSE AND phash IS NOT NULL AND phash != ''")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query images for the pHash index: %w", err)
+	}
+	defer rows.Close()
+	index := processor.NewPHashIndex()
+	for rows.Next() {
+		var id int
+		var phash, turned string
+		if err := rows.Scan(&id, &phash, &turned); err != nil {
+			return nil, fmt.Errorf("failed to scan image for the pHash index: %w", err)
+		}
+		phashes, err := processor.ParsePHashes(phash, turned)
+		if err != nil {
+			continue // Left out of similarity detection as well
+		}
+		index.Add(id, phashes)
+	}
+	return index, rows.Err()
+}
+
+// hashedImage is an image with its size and pHashes, as similarity lookups
+// compare them.
+type hashedImage struct {
+	ID            int
+	MD5           string
+	FilePath      string
+	Width, Height int
+	PHashes       processor.PHashes
+}
+
+// hashedImagesByID returns the active images among ids whose pHashes parse,
+// keyed by ID.
+func hashedImagesByID(db *sql.DB, ids []int) (map[int]hashedImage, error) {
+	images := make(map[int]hashedImage, len(ids))
+	const chunkSize = 500 // well below SQLite's limit of variables
+	for start := 0; start < len(ids); start += chunkSize {
+		chunk := ids[start:min(start+chunkSize, len(ids))]
+		args := make([]interface{}, len(chunk))
+		for i, id := range chunk {
+			args[i] = id
+		}
+		rows, err := db.Query(`SELECT id, md5, file_path, COALESCE(image_width, 0), COALESCE(image_height, 0), phash, COALESCE(turned_phashes, '') FROM images
+			WHERE is_recycled = FALSE AND phash IS NOT NULL AND phash != '' AND id IN (`+strings.TrimSuffix(strings.Repeat("?, ", len(chunk)), ", ")+")", args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query images: %w", err)
+		}
+		for rows.Next() {
+			var image hashedImage
+			var phash, turned string
+			if err := rows.Scan(&image.ID, &image.MD5, &image.FilePath, &image.Width, &image.Height, &phash, &turned); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan image: %w", err)
+			}
+			if image.PHashes, err = processor.ParsePHashes(phash, turned); err != nil {
+				continue
+			}
+			images[image.ID] = image
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return images, nil
+}
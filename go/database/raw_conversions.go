@@ -0,0 +1,118 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// Statuses of a queued RAW conversion.
+const (
+	RawConversionPending = "pending"
+	RawConversionDone    = "done"
+	RawConversionFailed  = "failed"
+)
+
+// MaxRawConversionAttempts is how often a RAW file is tried before it keeps its
+// placeholder thumbnail.
+const MaxRawConversionAttempts = 3
+
+// RawConversion is a RAW file whose thumbnail is a placeholder until an external
+// converter has rendered it.
+type RawConversion struct {
+	MD5      string
+	FilePath string
+	Attempts int
+}
+
+// Conversions are keyed by content, so copies of a RAW file are converted once
+// and a converted thumbnail is never replaced by a placeholder again.
+const createRawConversionsTableSQL = `
+CREATE TABLE IF NOT EXISTS raw_conversions (
+	md5 TEXT PRIMARY KEY,
+	file_path TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	queued_at DATETIME,
+	updated_at DATETIME
+);
+`
+
+// PendingRawConversions returns the queued conversions of RAW files still in the
+// catalog, oldest first.
+func PendingRawConversions() ([]RawConversion, error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return nil, err
+	}
+	// Pick a copy that is still active, in case the queued path was recycled
+	rows, err := db.Query(`
+		SELECT r.md5, COALESCE(
+			(SELECT file_path FROM images WHERE file_path = r.file_path AND md5 = r.md5 AND is_recycled = FALSE),
+			(SELECT file_path FROM images WHERE md5 = r.md5 AND is_recycled = FALSE ORDER BY id LIMIT 1)), r.attempts
+		FROM raw_conversions r
+		WHERE r.status = ? AND EXISTS (SELECT 1 FROM images WHERE md5 = r.md5 AND is_recycled = FALSE)
+		ORDER BY r.queued_at, r.md5
+	`, RawConversionPending)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query RAW conversions: %w", err)
+	}
+	defer rows.Close()
+
+	var conversions []RawConversion
+	for rows.Next() {
+		var c RawConversion
+		if err := rows.Scan(&c.MD5, &c.FilePath, &c.Attempts); err != nil {
+			return nil, fmt.Errorf("failed to scan RAW conversion: %w", err)
+		}
+		conversions = append(conversions, c)
+	}
+	return conversions, rows.Err()
+}
+
+// FinishRawConversion stores the rendered thumbnail in place of the placeholder,
+// together with its low-information class, and takes the file off the queue.
+func FinishRawConversion(md5 string, thumbnail []byte, lowInfo string) error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("INSERT OR REPLACE INTO thumbnails (md5, data) VALUES (?, ?)", md5, thumbnail); err != nil {
+		return fmt.Errorf("failed to store thumbnail: %w", err)
+	}
+	if _, err := tx.Exec("UPDATE images SET low_info = ? WHERE md5 = ?", nullIfEmpty(lowInfo), md5); err != nil {
+		return fmt.Errorf("failed to update images: %w", err)
+	}
+	if _, err := tx.Exec("UPDATE raw_conversions SET status = ?, attempts = attempts + 1, last_error = NULL, updated_at = ? WHERE md5 = ?",
+		RawConversionDone, time.Now().Format(time.RFC3339), md5); err != nil {
+		return fmt.Errorf("failed to update RAW conversion: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// FailRawConversion records a failed attempt. After MaxRawConversionAttempts
+// the file is no longer returned by PendingRawConversions.
+func FailRawConversion(md5 string, cause error) error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		UPDATE raw_conversions SET attempts = attempts + 1, last_error = ?, updated_at = ?,
+			status = CASE WHEN attempts + 1 >= ? THEN ? ELSE status END
+		WHERE md5 = ?
+	`, cause.Error(), time.Now().Format(time.RFC3339), MaxRawConversionAttempts, RawConversionFailed, md5)
+	if err != nil {
+		return fmt.Errorf("failed to update RAW conversion: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"picpurge/processor"
+)
+
+// Scan recovery statuses.
+const (
+	RecoveryRunning  = "running"
+	RecoveryComplete = "complete"
+)
+
+const createThumbnailsTableSQL = `
+CREATE TABLE IF NOT EXISTS thumbnails (
+	md5 TEXT PRIMARY KEY,
+	data BLOB NOT NULL
+);
+`
+
+// scan_recovery holds a single row marking whether the last scan finished
+// writing its results. A "running" row found at startup means it crashed.
+const createScanRecoveryTableSQL = `
+CREATE TABLE IF NOT EXISTS scan_recovery (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	status TEXT NOT NULL,
+	batches INTEGER NOT NULL DEFAULT 0,
+	images INTEGER NOT NULL DEFAULT 0,
+	updated_at DATETIME
+);
+`
+
+// scan_checkpoint holds the roots and the files found by the running scan, in
+// walk order, so an interrupted scan can resume without walking again. It is
+// emptied once the scan finishes.
+const createScanCheckpointTableSQL = `
+CREATE TABLE IF NOT EXISTS scan_checkpoint (
+	seq INTEGER PRIMARY KEY,
+	kind TEXT NOT NULL,
+	path TEXT NOT NULL
+);
+`
+
+// Kinds of scan_checkpoint rows.
+const (
+	checkpointRoot = "root"
+	checkpointFile = "file"
+)
+
+// ScanResult is one processed image waiting to be committed.
+type ScanResult struct {
+	ImageData     *processor.ImageData
+	ThumbnailData []byte
+}
+
+// CommittedFile is the size and modification time an image had when it was committed.
+type CommittedFile struct {
+	Size    int64
+	ModTime int64  // Unix nanoseconds
+	MD5     string // content digest
+}
+
+// BeginScanRecovery marks a scan as running. It reports whether the previous
+// scan was interrupted before finishing, and how many images it had committed.
+func BeginScanRecovery() (interrupted bool, committed int, err error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return false, 0, err
+	}
+
+	var status string
+	err = db.QueryRow("SELECT status, images FROM scan_recovery WHERE id = 1").Scan(&status, &committed)
+	interrupted = err == nil && status == RecoveryRunning
+
+	_, err = db.Exec(`
+		INSERT INTO scan_recovery (id, status, batches, images, updated_at) VALUES (1, ?, 0, 0, ?)
+		ON CONFLICT(id) DO UPDATE SET status = excluded.status, batches = 0, images = 0, updated_at = excluded.updated_at
+	`, RecoveryRunning, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to mark scan as running: %w", err)
+	}
+	return interrupted, committed, nil
+}
+
+// CommitScanBatch stores a batch of images and their thumbnails in one transaction,
+// so after a crash every image in the catalog is complete.
+func CommitScanBatch(results []ScanResult) error {
+	return storeScanResults(results, true)
+}
+
+// StoreScanResult stores one image processed outside of a scan, e.g. again
+// after its file was repaired, the way a scan batch would.
+func StoreScanResult(result ScanResult) error {
+	return storeScanResults([]ScanResult{result}, false)
+}
+
+// storeScanResults stores images and their thumbnails in one transaction and,
+// for scan batches, counts them in the recovery marker.
+func storeScanResults(results []ScanResult, scanBatch bool) error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin batch: %w", err)
+	}
+	defer tx.Rollback()
+
+	imageStmt, err := tx.Prepare(insertImageSQL)
+	if err != nil {
+		return fmt.Errorf("failed to prepare insert statement: %w", err)
+	}
+	defer imageStmt.Close()
+	thumbnailStmt, err := tx.Prepare("INSERT OR REPLACE INTO thumbnails (md5, data) VALUES (?, ?)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare thumbnail statement: %w", err)
+	}
+	defer thumbnailStmt.Close()
+	// A placeholder never replaces a thumbnail an earlier conversion rendered
+	placeholderStmt, err := tx.Prepare("INSERT OR IGNORE INTO thumbnails (md5, data) VALUES (?, ?)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare thumbnail statement: %w", err)
+	}
+	defer placeholderStmt.Close()
+	queueStmt, err := tx.Prepare("INSERT OR IGNORE INTO raw_conversions (md5, file_path, status, queued_at) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare RAW conversion statement: %w", err)
+	}
+	defer queueStmt.Close()
+	queuedAt := time.Now().Format(time.RFC3339)
+
+	for _, result := range results {
+		if err := execInsertImage(imageStmt, result.ImageData); err != nil {
+			return fmt.Errorf("failed to insert %s: %w", result.ImageData.FilePath, err)
+		}
+		if result.ImageData.RawPending {
+			if _, err := queueStmt.Exec(result.ImageData.MD5, result.ImageData.FilePath, RawConversionPending, queuedAt); err != nil {
+				return fmt.Errorf("failed to queue RAW conversion of %s: %w", result.ImageData.FilePath, err)
+			}
+		}
+		if result.ThumbnailData != nil {
+			stmt := thumbnailStmt
+			if result.ImageData.RawPending {
+				stmt = placeholderStmt
+			}
+			if _, err := stmt.Exec(result.ImageData.MD5, result.ThumbnailData); err != nil {
+				return fmt.Errorf("failed to store thumbnail for %s: %w", result.ImageData.FilePath, err)
+			}
+		}
+	}
+
+	if scanBatch {
+		_, err = tx.Exec("UPDATE scan_recovery SET batches = batches + 1, images = images + ?, updated_at = ? WHERE id = 1",
+			len(results), time.Now().Format(time.RFC3339))
+		if err != nil {
+			return fmt.Errorf("failed to update recovery marker: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit batch: %w", err)
+	}
+	return nil
+}
+
+// FinishScanRecovery marks the results of the current scan as completely
+// written and drops its checkpoint.
+func FinishScanRecovery() error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec("UPDATE scan_recovery SET status = ?, updated_at = ? WHERE id = 1", RecoveryComplete, time.Now().Format(time.RFC3339)); err != nil {
+		return fmt.Errorf("failed to mark scan as complete: %w", err)
+	}
+	if _, err := db.Exec("DELETE FROM scan_checkpoint"); err != nil {
+		return fmt.Errorf("failed to clear scan checkpoint: %w", err)
+	}
+	return nil
+}
+
+// SaveScanCheckpoint records the roots of the current scan and the files it
+// found, replacing the checkpoint of an earlier scan.
+func SaveScanCheckpoint(roots, files []string) error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin checkpoint: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM scan_checkpoint"); err != nil {
+		return fmt.Errorf("failed to clear scan checkpoint: %w", err)
+	}
+	stmt, err := tx.Prepare("INSERT INTO scan_checkpoint (kind, path) VALUES (?, ?)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare checkpoint statement: %w", err)
+	}
+	defer stmt.Close()
+	for _, root := range roots {
+		if _, err := stmt.Exec(checkpointRoot, root); err != nil {
+			return fmt.Errorf("failed to save scan checkpoint: %w", err)
+		}
+	}
+	for _, file := range files {
+		if _, err := stmt.Exec(checkpointFile, file); err != nil {
+			return fmt.Errorf("failed to save scan checkpoint: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit scan checkpoint: %w", err)
+	}
+	return nil
+}
+
+// ScanCheckpoint returns the roots and files saved by a scan that has not
+// finished, in walk order. Both are empty when there is nothing to resume.
+func ScanCheckpoint() (roots, files []string, err error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, err := db.Query("SELECT kind, path FROM scan_checkpoint ORDER BY seq")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query scan checkpoint: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kind, path string
+		if err := rows.Scan(&kind, &path); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan checkpoint entry: %w", err)
+		}
+		if kind == checkpointRoot {
+			roots = append(roots, path)
+		} else {
+			files = append(files, path)
+		}
+	}
+	return roots, files, rows.Err()
+}
+
+// CommittedFiles returns the committed images keyed by file path.
+func CommittedFiles() (map[string]CommittedFile, error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT file_path, COALESCE(file_size, 0), COALESCE(file_mod_time, 0), COALESCE(md5, '') FROM images WHERE is_recycled = FALSE")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query committed images: %w", err)
+	}
+	defer rows.Close()
+
+	files := make(map[string]CommittedFile)
+	for rows.Next() {
+		var path string
+		var file CommittedFile
+		if err := rows.Scan(&path, &file.Size, &file.ModTime, &file.MD5); err != nil {
+			return nil, fmt.Errorf("failed to scan committed image: %w", err)
+		}
+		files[path] = file
+	}
+	return files, rows.Err()
+}
+
+// LoadThumbnails calls fn for every stored thumbnail.
+func LoadThumbnails(fn func(md5 string, data []byte)) error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	rows, err := db.Query("SELECT md5, data FROM thumbnails")
+	if err != nil {
+		return fmt.Errorf("failed to query thumbnails: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var md5 string
+		var data []byte
+		if err := rows.Scan(&md5, &data); err != nil {
+			return fmt.Errorf("failed to scan thumbnail: %w", err)
+		}
+		fn(md5, data)
+	}
+	return rows.Err()
+}
+
+// ResetAnalysis clears duplicate, similarity and sequence results so analysis can
+// run again from a clean state, e.g. after an interrupted run. Similar group IDs
+// are kept, so the groups found again get their old IDs back.
+func ResetAnalysis() error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		UPDATE images SET is_duplicate = FALSE, duplicate_of = NULL, similar_images = NULL,
+			same_shot_of = NULL, bracket_set = NULL, panorama_set = NULL
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to reset analysis: %w", err)
+	}
+	if _, err := db.Exec("DELETE FROM similar_pairs"); err != nil {
+		return fmt.Errorf("failed to reset analysis: %w", err)
+	}
+	return nil
+}
+
+// StoreThumbnail saves a thumbnail so it survives a restart.
+func StoreThumbnail(md5 string, data []byte) error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec("INSERT OR REPLACE INTO thumbnails (md5, data) VALUES (?, ?)", md5, data); err != nil {
+		return fmt.Errorf("failed to store thumbnail: %w", err)
+	}
+	return nil
+}
+
+// Thumbnail returns the stored thumbnail for an MD5, or nil when there is none.
+func Thumbnail(md5 string) ([]byte, error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	err = db.QueryRow("SELECT data FROM thumbnails WHERE md5 = ?", md5).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load thumbnail: %w", err)
+	}
+	return data, nil
+}
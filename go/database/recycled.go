@@ -0,0 +1,261 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"picpurge/util"
+)
+
+// RecycledImage is an image in the catalog whose file was recycled.
+type RecycledImage struct {
+	ID         int
+	Path       string // where the file was before it was recycled
+	MD5        string
+	Size       int64
+	RecycledAt *time.Time // nil if recycled before this was recorded
+	// Move is the logged move into the recycle directory, nil for files
+	// recycled before moves were logged or deleted outside picpurge.
+	Move        *OperationMove
+	OperationID int64
+}
+
+// RecycledImages returns the recycled images of the catalog, the longest
+// recycled first.
+func RecycledImages() ([]RecycledImage, error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT i.id, i.file_path, COALESCE(i.md5, ''), COALESCE(i.file_size, 0), i.recycled_at,
+			m.id, m.operation_id, m.src, m.dst
+		FROM images i LEFT JOIN operation_moves m
+			ON m.id = (SELECT MAX(id) FROM operation_moves WHERE image_id = i.id AND undone = FALSE)
+		WHERE i.is_recycled = TRUE
+		ORDER BY i.recycled_at IS NOT NULL, i.recycled_at, i.id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recycled images: %w", err)
+	}
+	defer rows.Close()
+	var images []RecycledImage
+	for rows.Next() {
+		var image RecycledImage
+		var recycledAt sql.NullTime
+		var moveID, operationID sql.NullInt64
+		var src, dst sql.NullString
+		if err := rows.Scan(&image.ID, &image.Path, &image.MD5, &image.Size, &recycledAt, &moveID, &operationID, &src, &dst); err != nil {
+			return nil, fmt.Errorf("failed to read recycled image: %w", err)
+		}
+		if recycledAt.Valid {
+			image.RecycledAt = &recycledAt.Time
+		}
+		if moveID.Valid {
+			image.Move = &OperationMove{ID: moveID.Int64, ImageID: image.ID, Src: src.String, Dst: dst.String}
+			image.OperationID = operationID.Int64
+		}
+		images = append(images, image)
+	}
+	return images, rows.Err()
+}
+
+// RestoreRecycled updates the catalog after the file of a recycled image was
+// moved back to its original path. A logged move into the recycle directory
+// counts as undone.
+func RestoreRecycled(image RecycledImage) error {
+	if image.Move != nil {
+		if err := UndoMove(*image.Move); err != nil {
+			return err
+		}
+		_, err := FinishUndo(image.OperationID)
+		return err
+	}
+	return restoreImage(image.ID, image.Path)
+}
+
+// ForgetRecycled removes a recycled image from the catalog once its file was
+// deleted for good. Its logged moves that were not undone are dropped too, as
+// they can no longer be undone, and operations left with nothing to undo
+// count as undone.
+func ForgetRecycled(id int) error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	for _, query := range []string{
+		"DELETE FROM image_tags WHERE image_id = ?",
+		"DELETE FROM ocr_text WHERE image_id = ?",
+		"DELETE FROM operation_moves WHERE image_id = ? AND undone = FALSE",
+		"DELETE FROM images WHERE id = ? AND is_recycled = TRUE",
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			return fmt.Errorf("failed to remove recycled image ID %d: %w", id, err)
+		}
+	}
+	if _, err := tx.Exec(`UPDATE operations SET undone_at = ? WHERE undone_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM operation_moves WHERE operation_id = operations.id AND undone = FALSE)`, time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to update operations log: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to remove recycled image ID %d: %w", id, err)
+	}
+	return nil
+}
+
+// Location returns where the file of a recycled image is now, or "" if it
+// cannot be found. Files recycled before moves were logged are looked up in
+// recycleDir under their name, numbered as fileops.Recycle numbers them, and
+// must match in size and content.
+func (image RecycledImage) Location(recycleDir string) string {
+	if image.Move != nil {
+		if _, err := os.Stat(image.Move.Dst); err == nil {
+			return image.Move.Dst
+		}
+		return ""
+	}
+	name := filepath.Base(image.Path)
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := filepath.Join(recycleDir, name)
+	for counter := 1; counter <= 1000; counter++ {
+		info, err := os.Stat(candidate)
+		if err != nil {
+			return ""
+		}
+		if info.Size() == image.Size {
+			if md5, err := util.FileHash(candidate, util.DigestAlgo(image.MD5)); err == nil && md5 == image.MD5 {
+				return candidate
+			}
+		}
+		candidate = filepath.Join(recycleDir, fmt.Sprintf("%s_%d%s", base, counter, ext))
+	}
+	return ""
+}
+
+// StrayRecycledFiles lists the files in recycleDir that belong to no recycled
+// image of the catalog. known holds the locations of the recycled images.
+func StrayRecycledFiles(recycleDir string, known map[string]bool) []string {
+	entries, err := os.ReadDir(recycleDir)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		path := filepath.Join(recycleDir, entry.Name())
+		absPath, _ := filepath.Abs(path)
+		if !known[path] && !known[absPath] {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// RecycleStats is what sits in the recycle directory: the recycled files that
+// are still there, by the folder they came from and by how long ago they were
+// recycled, and the files of the directory the catalog does not know.
+type RecycleStats struct {
+	Files      int             `json:"files"`
+	Bytes      int64           `json:"bytes"`
+	Missing    int             `json:"missing"` // recycled images whose file is gone already
+	StrayFiles int             `json:"strayFiles"`
+	StrayBytes int64           `json:"strayBytes"`
+	OldestAt   *time.Time      `json:"oldestAt,omitempty"`
+	Folders    []RecycleFolder `json:"folders"`    // most bytes first
+	Ages       []RecycleAge    `json:"ages"`       // youngest first
+	UnknownAge RecycleAge      `json:"unknownAge"` // recycled before recycle times were recorded
+}
+
+// RecycleFolder counts the recycled files that came from one folder.
+type RecycleFolder struct {
+	Folder string `json:"folder"`
+	Files  int    `json:"files"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// RecycleAge counts the files recycled less than MaxDays days ago and at
+// least as long ago as the age before it; the last age has no MaxDays.
+type RecycleAge struct {
+	MaxDays int   `json:"maxDays,omitempty"`
+	Files   int   `json:"files"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// recycleAgeDays are the upper bounds of the ages in RecycleStats, in days.
+var recycleAgeDays = []int{1, 7, 30, 90, 365, 0}
+
+// GetRecycleStats sums up the recycled files still in recycleDir, or wherever
+// their logged moves put them, with ages as of now.
+func GetRecycleStats(recycleDir string, now time.Time) (RecycleStats, error) {
+	images, err := RecycledImages()
+	if err != nil {
+		return RecycleStats{}, err
+	}
+	stats := RecycleStats{Folders: []RecycleFolder{}}
+	for _, days := range recycleAgeDays {
+		stats.Ages = append(stats.Ages, RecycleAge{MaxDays: days})
+	}
+	folders := make(map[string]*RecycleFolder)
+	known := make(map[string]bool)
+	for _, image := range images {
+		location := image.Location(recycleDir)
+		if location == "" {
+			stats.Missing++
+			continue
+		}
+		known[location] = true
+		stats.Files++
+		stats.Bytes += image.Size
+
+		folder := folders[filepath.Dir(image.Path)]
+		if folder == nil {
+			folder = &RecycleFolder{Folder: filepath.Dir(image.Path)}
+			folders[folder.Folder] = folder
+		}
+		folder.Files++
+		folder.Bytes += image.Size
+
+		age := &stats.UnknownAge
+		if image.RecycledAt != nil {
+			if stats.OldestAt == nil || image.RecycledAt.Before(*stats.OldestAt) {
+				stats.OldestAt = image.RecycledAt
+			}
+			days := now.Sub(*image.RecycledAt).Hours() / 24
+			for i := range stats.Ages {
+				if stats.Ages[i].MaxDays == 0 || days < float64(stats.Ages[i].MaxDays) {
+					age = &stats.Ages[i]
+					break
+				}
+			}
+		}
+		age.Files++
+		age.Bytes += image.Size
+	}
+	for _, folder := range folders {
+		stats.Folders = append(stats.Folders, *folder)
+	}
+	sort.Slice(stats.Folders, func(i, j int) bool {
+		if stats.Folders[i].Bytes != stats.Folders[j].Bytes {
+			return stats.Folders[i].Bytes > stats.Folders[j].Bytes
+		}
+		return stats.Folders[i].Folder < stats.Folders[j].Folder
+	})
+	for _, path := range StrayRecycledFiles(recycleDir, known) {
+		if info, err := os.Stat(path); err == nil {
+			stats.StrayFiles++
+			stats.StrayBytes += info.Size()
+		}
+	}
+	return stats, nil
+}
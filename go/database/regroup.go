@@ -0,0 +1,184 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"picpurge/grouping"
+	"picpurge/processor"
+)
+
+// RegroupImage updates the duplicate and similar groups of an image that was
+// processed again, e.g. after its file was repaired, without finding every
+// group anew. oldMD5 is its hash before; the duplicate group it leaves is
+// settled as well. The image joins the similar group of its closest match by
+// pHash when it is close enough to every member, or pairs up with an
+// ungrouped match.
+func RegroupImage(id int, oldMD5 string) error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	var md5, phashStr, turnedStr, dhashStr, ahashStr, whashStr string
+	var width, height, bracketSet, panoramaSet int
+	var isDocument bool
+	err = db.QueryRow("SELECT md5, COALESCE(phash, ''), COALESCE(turned_phashes, ''), COALESCE(dhash, ''), COALESCE(ahash, ''), COALESCE(whash, ''), image_width, image_height, COALESCE(bracket_set, 0), COALESCE(panorama_set, 0), COALESCE(is_document, FALSE) FROM images WHERE id = ? AND is_recycled = FALSE", id).
+		Scan(&md5, &phashStr, &turnedStr, &dhashStr, &ahashStr, &whashStr, &width, &height, &bracketSet, &panoramaSet, &isDocument)
+	if err != nil {
+		return fmt.Errorf("image ID %d not found: %w", id, err)
+	}
+
+	if oldMD5 != "" && oldMD5 != md5 {
+		if err := regroupDuplicates(oldMD5); err != nil {
+			return err
+		}
+	}
+	if err := regroupDuplicates(md5); err != nil {
+		return err
+	}
+
+	if err := removeFromSimilarGroups(id); err != nil {
+		return err
+	}
+	if _, err := db.Exec("UPDATE images SET similar_images = NULL WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to clear similar group of image ID %d: %w", id, err)
+	}
+	if phashStr == "" {
+		return assignSimilarGroupIDs(db) // Videos and undecodable files are never similar
+	}
+	phashes, err := processor.ParsePHashes(phashStr, turnedStr)
+	if err != nil {
+		return fmt.Errorf("invalid pHash of image ID %d: %w", id, err)
+	}
+	confirming := processor.ParseConfirmingHashes(dhashStr, ahashStr, whashStr)
+
+	rows, err := db.Query(`SELECT id, phash, COALESCE(turned_phashes, ''), COALESCE(dhash, ''), COALESCE(ahash, ''), COALESCE(whash, ''), image_width, image_height, COALESCE(bracket_set, 0), COALESCE(panorama_set, 0), COALESCE(similar_images, ''), COALESCE(is_document, FALSE)
+		FROM images WHERE is_recycled = FALSE AND id != ? AND phash IS NOT NULL AND phash != ''`, id)
+	if err != nil {
+		return fmt.Errorf("failed to query images for similar detection: %w", err)
+	}
+	distances := make(map[int]int) // of the matches within their processor.PairThreshold
+	groups := make(map[int][]int)  // current similar group of each match
+	var matches []int
+	for rows.Next() {
+		var otherID, otherWidth, otherHeight, otherBracket, otherPanorama int
+		var otherPHash, otherTurned, otherDHash, otherAHash, otherWHash, similarJSON string
+		var otherIsDocument bool
+		if err := rows.Scan(&otherID, &otherPHash, &otherTurned, &otherDHash, &otherAHash, &otherWHash, &otherWidth, &otherHeight, &otherBracket, &otherPanorama, &similarJSON, &otherIsDocument); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan image for similar detection: %w", err)
+		}
+		// The same pre-filters as a full search
+		upright, sideways := processor.Orientations(width, height, otherWidth, otherHeight)
+		if (bracketSet != 0 && bracketSet == otherBracket) || (panoramaSet != 0 && panoramaSet == otherPanorama) ||
+			(!upright && !sideways) {
+			continue
+		}
+		other, err := processor.ParsePHashes(otherPHash, otherTurned)
+		if err != nil {
+			continue
+		}
+		d, turned := phashes.Distance(other, upright, sideways)
+		if d > processor.PairThreshold(isDocument, otherIsDocument) ||
+			(!turned && !confirming.Confirm(processor.ParseConfirmingHashes(otherDHash, otherAHash, otherWHash))) {
+			continue
+		}
+		distances[otherID] = d
+		matches = append(matches, otherID)
+		group := []int{otherID}
+		var similar []int
+		if json.Unmarshal([]byte(similarJSON), &similar) == nil && len(similar) > 1 {
+			group = similar
+		}
+		groups[otherID] = group
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if distances[matches[i]] != distances[matches[j]] {
+			return distances[matches[i]] < distances[matches[j]]
+		}
+		return matches[i] < matches[j]
+	})
+	for _, match := range matches {
+		group := groups[match]
+		// Like grouping.ClusterSimilar, a larger group must stay tighter
+		limit := grouping.ClusterThreshold(processor.PHashThreshold, len(group)+1)
+		fits := true
+		for _, member := range group {
+			if d, ok := distances[member]; !ok || d > limit {
+				fits = false
+				break
+			}
+		}
+		if !fits {
+			continue
+		}
+		joined := append(append([]int(nil), group...), id)
+		sort.Ints(joined)
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to update similar group: %w", err)
+		}
+		err = storeSimilarGroup(tx, joined, func(a, b int) (int, bool) {
+			if a != id {
+				return 0, false
+			}
+			d, ok := distances[b]
+			return d, ok
+		})
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to update similar group: %w", err)
+		}
+		break
+	}
+	// The group the image joined keeps its ID
+	return assignSimilarGroupIDs(db)
+}
+
+// regroupDuplicates makes the first catalogued image with md5 in
+// CurationOrder the original and the others its duplicates.
+func regroupDuplicates(md5 string) error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	rows, err := db.Query("SELECT id FROM images WHERE md5 = ? AND is_recycled = FALSE ORDER BY "+CurationOrder, md5)
+	if err != nil {
+		return fmt.Errorf("failed to query images with MD5 %s: %w", md5, err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan image with MD5 %s: %w", md5, err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		var err error
+		if i == 0 {
+			_, err = db.Exec("UPDATE images SET is_duplicate = FALSE, duplicate_of = NULL WHERE id = ?", id)
+		} else {
+			_, err = db.Exec("UPDATE images SET is_duplicate = TRUE, duplicate_of = ? WHERE id = ?", ids[0], id)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update duplicate status of image ID %d: %w", id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reports are SELECT statements written by users against the report views
+// below, which expose what a custom report needs and nothing that could be
+// changed or used to reach other files. They run on a separate read-only
+// connection whose authorizer denies anything but reading the columns behind
+// the views, so neither writes, pragmas nor attached databases get through.
+// modernc.org/sqlite has no authorizer, so builds with the purego tag run no
+// reports at all.
+
+// reportView is a view of the catalog reports can read.
+type reportView struct {
+	name    string
+	table   string
+	columns []string
+}
+
+var reportViews = []reportView{
+	{"report_images", "images", []string{
+		"id", "file_path", "file_name", "file_size", "md5", "image_width", "image_height",
+		"device_make", "device_model", "lens_model", "camera_serial", "shutter_count",
+		"create_date", "date_source", "exposure_bias", "format", "duration",
+		"is_duplicate", "duplicate_of", "similar_images", "similar_group", "same_shot_of", "bracket_set", "panorama_set",
+		"is_screenshot", "low_info", "messenger", "edited_with", "is_favorite", "rating",
+		"is_recycled", "recycled_at",
+	}},
+	{"report_operations", "operations", []string{"id", "kind", "started_at", "undone_at"}},
+	{"report_moves", "operation_moves", []string{"id", "operation_id", "image_id", "src", "dst", "undone"}},
+	{"report_similar_pairs", "similar_pairs", []string{"id_a", "id_b", "distance"}},
+	{"report_reviews", "group_reviews", []string{"group_type", "group_key", "status", "notes", "updated_at"}},
+}
+
+// reportColumns holds the readable columns of every table behind a report view.
+var reportColumns = func() map[string]map[string]bool {
+	columns := make(map[string]map[string]bool)
+	for _, view := range reportViews {
+		columns[view.table] = make(map[string]bool)
+		for _, column := range view.columns {
+			columns[view.table][column] = true
+		}
+	}
+	return columns
+}()
+
+// createReportViews replaces the report views, so they follow the columns of
+// this version.
+func createReportViews(db *sql.DB) error {
+	for _, view := range reportViews {
+		statement := fmt.Sprintf("DROP VIEW IF EXISTS %s; CREATE VIEW %s AS SELECT %s FROM %s",
+			view.name, view.name, strings.Join(view.columns, ", "), view.table)
+		if _, err := db.Exec(statement); err != nil {
+			return fmt.Errorf("failed to create view %s: %w", view.name, err)
+		}
+	}
+	return nil
+}
+
+// ReportViews returns the names of the views reports can read, each with its columns.
+func ReportViews() map[string][]string {
+	views := make(map[string][]string, len(reportViews))
+	for _, view := range reportViews {
+		views[view.name] = append([]string(nil), view.columns...)
+	}
+	return views
+}
+
+var (
+	reportDB     *sql.DB
+	reportDBOnce sync.Once
+	reportDBErr  error
+)
+
+// getReportDB opens the read-only connection reports run on.
+func getReportDB() (*sql.DB, error) {
+	reportDBOnce.Do(func() {
+		if !reportsSupported {
+			reportDBErr = fmt.Errorf("reports need the cgo SQLite driver; this build uses the pure Go one")
+			return
+		}
+		if _, reportDBErr = GetDBInstance(); reportDBErr != nil {
+			return
+		}
+		reportDB, reportDBErr = sql.Open(reportDriverName, catalogSource("mode=ro"))
+	})
+	return reportDB, reportDBErr
+}
+
+// Report is the result of a report query.
+type Report struct {
+	Columns   []string        `json:"columns"`
+	Rows      [][]interface{} `json:"rows"`
+	Truncated bool            `json:"truncated"` // more rows matched than the limit
+}
+
+// reportTimeout bounds how long a report may run.
+const reportTimeout = 10 * time.Second
+
+// RunReport runs a SELECT statement against the report views and returns up
+// to limit rows. Statements that read anything else, or change anything, fail.
+func RunReport(ctx context.Context, query string, limit int) (*Report, error) {
+	db, err := getReportDB()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, reportTimeout)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("report failed: %w", err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("report failed: %w", err)
+	}
+	report := &Report{Columns: columns, Rows: [][]interface{}{}}
+	for rows.Next() {
+		if len(report.Rows) == limit {
+			report.Truncated = true
+			break
+		}
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, fmt.Errorf("report failed: %w", err)
+		}
+		for i, value := range values {
+			if b, ok := value.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		report.Rows = append(report.Rows, values)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("report failed: %w", err)
+	}
+	return report, nil
+}
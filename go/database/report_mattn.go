@@ -0,0 +1,52 @@
+//go:build !purego
+
+package database
+
+import (
+	"database/sql"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// reportsSupported is whether the driver can restrict report connections to
+// the report views.
+const reportsSupported = true
+
+// sqliteRecursive is SQLite's authorizer action for recursive common table
+// expressions, which the driver does not name.
+const sqliteRecursive = 33
+
+// authorizeReport is the SQLite authorizer of report connections.
+func authorizeReport(action int, arg1, arg2, _ string) int {
+	switch action {
+	case sqlite3.SQLITE_SELECT, sqlite3.SQLITE_FUNCTION, sqliteRecursive:
+		return sqlite3.SQLITE_OK
+	case sqlite3.SQLITE_READ:
+		// Views are read through the columns of their tables
+		if columns, ok := reportColumns[arg1]; ok && (columns[arg2] || arg2 == "") {
+			return sqlite3.SQLITE_OK
+		}
+		for _, view := range reportViews {
+			if arg1 == view.name {
+				return sqlite3.SQLITE_OK
+			}
+		}
+	}
+	return sqlite3.SQLITE_DENY
+}
+
+// reportDriverName is the driver of report connections: the catalog's driver
+// with the report authorizer.
+const reportDriverName = "sqlite3_picpurge_report"
+
+func init() {
+	sql.Register(reportDriverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if err := prepareConnection(conn); err != nil {
+				return err
+			}
+			conn.RegisterAuthorizer(authorizeReport)
+			return nil
+		},
+	})
+}
@@ -0,0 +1,10 @@
+//go:build purego
+
+package database
+
+// reportsSupported is whether the driver can restrict report connections to
+// the report views. modernc.org/sqlite cannot.
+const reportsSupported = false
+
+// reportDriverName is never opened, as reports are not supported.
+const reportDriverName = driverName
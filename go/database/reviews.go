@@ -0,0 +1,94 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// Group types used as keys for group reviews.
+const (
+	GroupTypeDuplicates = "duplicates"
+	GroupTypeSimilar    = "similar"
+)
+
+// Review statuses of a duplicate or similar group.
+const (
+	ReviewUnreviewed = "unreviewed"
+	ReviewKeepAll    = "reviewed-keep-all"
+	ReviewResolved   = "resolved"
+)
+
+// GroupReview is the triage state of one duplicate or similar group.
+type GroupReview struct {
+	GroupType string `json:"groupType"`
+	GroupKey  string `json:"groupKey"`
+	Status    string `json:"status"`
+	Notes     string `json:"notes"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+const createGroupReviewsTableSQL = `
+CREATE TABLE IF NOT EXISTS group_reviews (
+	group_type TEXT NOT NULL,
+	group_key TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'unreviewed',
+	notes TEXT NOT NULL DEFAULT '',
+	updated_at DATETIME,
+	PRIMARY KEY (group_type, group_key)
+);
+`
+
+// IsValidReviewStatus reports whether status is one of the known review statuses.
+func IsValidReviewStatus(status string) bool {
+	switch status {
+	case ReviewUnreviewed, ReviewKeepAll, ReviewResolved:
+		return true
+	}
+	return false
+}
+
+// SetGroupReview stores the status and notes of a group.
+func SetGroupReview(review GroupReview) error {
+	if review.GroupType != GroupTypeDuplicates && review.GroupType != GroupTypeSimilar {
+		return fmt.Errorf("invalid group type: %s", review.GroupType)
+	}
+	if !IsValidReviewStatus(review.Status) {
+		return fmt.Errorf("invalid review status: %s", review.Status)
+	}
+
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		INSERT INTO group_reviews (group_type, group_key, status, notes, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(group_type, group_key) DO UPDATE SET status = excluded.status, notes = excluded.notes, updated_at = excluded.updated_at
+	`, review.GroupType, review.GroupKey, review.Status, review.Notes, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("failed to save group review: %w", err)
+	}
+	return nil
+}
+
+// GetGroupReviews returns all reviews of the given group type, keyed by group key.
+func GetGroupReviews(groupType string) (map[string]GroupReview, error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT group_type, group_key, status, notes, COALESCE(updated_at, '') FROM group_reviews WHERE group_type = ?", groupType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query group reviews: %w", err)
+	}
+	defer rows.Close()
+
+	reviews := make(map[string]GroupReview)
+	for rows.Next() {
+		var review GroupReview
+		if err := rows.Scan(&review.GroupType, &review.GroupKey, &review.Status, &review.Notes, &review.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan group review: %w", err)
+		}
+		reviews[review.GroupKey] = review
+	}
+	return reviews, rows.Err()
+}
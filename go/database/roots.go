@@ -0,0 +1,74 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// ScanRoot is a folder that was scanned or watched. Path is the folder as it
+// was scanned, which prefixes the catalog paths of its files; Canonical is its
+// absolute path with symlinks resolved, which identifies the folder on disk.
+type ScanRoot struct {
+	Path        string `json:"path"`
+	Canonical   string `json:"canonical"`
+	LastScanned string `json:"last_scanned"`
+}
+
+const createScanRootsTableSQL = `
+CREATE TABLE IF NOT EXISTS scan_roots (
+	canonical TEXT PRIMARY KEY,
+	path TEXT NOT NULL,
+	last_scanned DATETIME
+);
+`
+
+// RecordScanRoots stores the roots of a scan. A folder scanned again keeps the
+// path it was first scanned under, since its files are catalogued under it.
+func RecordScanRoots(roots []ScanRoot) error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Format(time.RFC3339)
+	for _, root := range roots {
+		if _, err := tx.Exec(`
+			INSERT INTO scan_roots (canonical, path, last_scanned) VALUES (?, ?, ?)
+			ON CONFLICT(canonical) DO UPDATE SET last_scanned = excluded.last_scanned
+		`, root.Canonical, root.Path, now); err != nil {
+			return fmt.Errorf("failed to record scan root %s: %w", root.Path, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// ScanRoots returns the recorded scan roots, most recently scanned first.
+func ScanRoots() ([]ScanRoot, error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT path, canonical, COALESCE(last_scanned, '') FROM scan_roots ORDER BY last_scanned DESC, canonical")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scan roots: %w", err)
+	}
+	defer rows.Close()
+
+	var roots []ScanRoot
+	for rows.Next() {
+		var root ScanRoot
+		if err := rows.Scan(&root.Path, &root.Canonical, &root.LastScanned); err != nil {
+			return nil, fmt.Errorf("failed to scan scan root: %w", err)
+		}
+		roots = append(roots, root)
+	}
+	return roots, rows.Err()
+}
@@ -0,0 +1,271 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"picpurge/processor"
+)
+
+// ftsEnabled is set when SQLite supports FTS5; otherwise search falls back to LIKE scans.
+var ftsEnabled bool
+
+// The search index uses the image ID as rowid. The trigram tokenizer makes
+// quoted terms match anywhere inside a word, so substring search stays indexed.
+const createSearchIndexSQL = `
+CREATE VIRTUAL TABLE IF NOT EXISTS image_search USING fts5(file_name, file_path, tags, ocr_text, tokenize = 'trigram');
+
+CREATE TRIGGER IF NOT EXISTS image_search_insert AFTER INSERT ON images BEGIN
+	INSERT INTO image_search (rowid, file_name, file_path, tags, ocr_text) VALUES (new.id, new.file_name, new.file_path, '', '');
+END;
+CREATE TRIGGER IF NOT EXISTS image_search_update AFTER UPDATE OF file_name, file_path ON images BEGIN
+	UPDATE image_search SET file_name = new.file_name, file_path = new.file_path WHERE rowid = new.id;
+END;
+CREATE TRIGGER IF NOT EXISTS image_search_delete AFTER DELETE ON images BEGIN
+	DELETE FROM image_search WHERE rowid = old.id;
+END;
+CREATE TRIGGER IF NOT EXISTS image_search_ocr_insert AFTER INSERT ON ocr_text BEGIN
+	UPDATE image_search SET ocr_text = new.text WHERE rowid = new.image_id;
+END;
+CREATE TRIGGER IF NOT EXISTS image_search_ocr_delete AFTER DELETE ON ocr_text BEGIN
+	UPDATE image_search SET ocr_text = '' WHERE rowid = old.image_id;
+END;
+CREATE TRIGGER IF NOT EXISTS image_search_tags_insert AFTER INSERT ON image_tags BEGIN
+	UPDATE image_search SET tags = ` + imageTagsSQL + ` WHERE rowid = new.image_id;
+END;
+CREATE TRIGGER IF NOT EXISTS image_search_tags_delete AFTER DELETE ON image_tags BEGIN
+	UPDATE image_search SET tags = ` + imageTagsSQL + ` WHERE rowid = old.image_id;
+END;
+`
+
+// dropSearchIndexSQL removes an index written by an older version, before
+// tags were indexed.
+const dropSearchIndexSQL = `
+DROP TRIGGER IF EXISTS image_search_insert;
+DROP TRIGGER IF EXISTS image_search_update;
+DROP TRIGGER IF EXISTS image_search_delete;
+DROP TRIGGER IF EXISTS image_search_ocr_insert;
+DROP TRIGGER IF EXISTS image_search_ocr_delete;
+DROP TABLE IF EXISTS image_search;
+`
+
+// imageTagsSQL is the names of the tags of rowid's image, one text, for the
+// tags column of the index.
+const imageTagsSQL = `COALESCE((SELECT group_concat(name, ' ') FROM image_tags WHERE image_tags.image_id = image_search.rowid), '')`
+
+// createSearchIndex creates the search index if SQLite supports FTS5 and
+// fills it when it does not hold every image, as after a build without FTS5
+// catalogued them or an older version indexed them without their tags.
+func createSearchIndex(db *sql.DB) error {
+	var existing string
+	err := db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'image_search'").Scan(&existing)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if existing != "" && !strings.Contains(existing, "tags") {
+		if _, err := db.Exec(dropSearchIndexSQL); err != nil {
+			return fmt.Errorf("failed to drop the old search index: %w", err)
+		}
+	}
+	if _, err := db.Exec(createSearchIndexSQL); err != nil {
+		return err
+	}
+
+	var images, indexed int
+	if err := db.QueryRow("SELECT COUNT(*) FROM images").Scan(&images); err != nil {
+		return err
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM image_search").Scan(&indexed); err != nil {
+		return err
+	}
+	if images == indexed {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM image_search"); err != nil {
+		return fmt.Errorf("failed to clear the search index: %w", err)
+	}
+	if _, err := tx.Exec(`INSERT INTO image_search (rowid, file_name, file_path, tags, ocr_text)
+		SELECT images.id, images.file_name, images.file_path, '', COALESCE(ocr_text.text, '')
+		FROM images LEFT JOIN ocr_text ON ocr_text.image_id = images.id`); err != nil {
+		return fmt.Errorf("failed to fill the search index: %w", err)
+	}
+	if _, err := tx.Exec("UPDATE image_search SET tags = " + imageTagsSQL + " WHERE rowid IN (SELECT image_id FROM image_tags)"); err != nil {
+		return fmt.Errorf("failed to index tags: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("ConnectDb: Indexed %d images for search.", images)
+	return nil
+}
+
+// minTrigramTerm is the shortest term the trigram tokenizer can match.
+const minTrigramTerm = 3
+
+// FTSEnabled reports whether search uses the SQLite FTS5 index.
+func FTSEnabled() bool {
+	return ftsEnabled
+}
+
+// SearchImages returns the IDs of active images whose file name, path, tags or
+// extracted text contains every term of query, best matches first. Double-quoted parts of the
+// query are matched as phrases.
+func SearchImages(query string) ([]int, error) {
+	terms := ParseSearchTerms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	db, err := GetDBInstance()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows *sql.Rows
+	if ftsEnabled && !hasShortTerm(terms) {
+		rows, err = db.Query("SELECT images.id FROM image_search JOIN images ON images.id = image_search.rowid WHERE image_search MATCH ? AND images.is_recycled = FALSE ORDER BY image_search.rank", ftsQuery(terms))
+	} else {
+		// Terms shorter than a trigram are not in the index and need a full scan
+		conditions := make([]string, len(terms))
+		args := make([]interface{}, len(terms))
+		for i, term := range terms {
+			conditions[i] = "(images.file_name || ' ' || images.file_path || ' ' || " + imageTagsOf + " || ' ' || COALESCE(ocr_text.text, '')) LIKE ?"
+			args[i] = "%" + term + "%"
+		}
+		rows, err = db.Query("SELECT images.id FROM images LEFT JOIN ocr_text ON ocr_text.image_id = images.id WHERE images.is_recycled = FALSE AND "+
+			strings.Join(conditions, " AND ")+" ORDER BY images.id ASC", args...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to search images: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan search result: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ParseSearchTerms splits a query into terms. Text in double quotes is kept as one term.
+func ParseSearchTerms(query string) []string {
+	var terms []string
+	for i, part := range strings.Split(query, `"`) {
+		if i%2 == 1 {
+			if phrase := strings.Join(strings.Fields(part), " "); phrase != "" {
+				terms = append(terms, phrase)
+			}
+			continue
+		}
+		terms = append(terms, strings.Fields(part)...)
+	}
+	return terms
+}
+
+// imageTagsOf is the names of the tags of an image for LIKE searches.
+const imageTagsOf = `COALESCE((SELECT group_concat(name, ' ') FROM image_tags WHERE image_tags.image_id = images.id), '')`
+
+func hasShortTerm(terms []string) bool {
+	for _, term := range terms {
+		if utf8.RuneCountInString(term) < minTrigramTerm {
+			return true
+		}
+	}
+	return false
+}
+
+// ftsQuery quotes each term so user input is never parsed as FTS5 query syntax.
+func ftsQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	}
+	return strings.Join(quoted, " ")
+}
+
+// ImageMatch is a catalog image found by FindSimilarTo.
+type ImageMatch struct {
+	ID       int
+	Distance int  // pHash distance to the example
+	Turned   bool // the image matches the example turned or mirrored
+	Exact    bool // same content as the example
+}
+
+// FindSimilarTo returns the images whose pHash is within maxDistance of the
+// pHash of an example image, in any orientation and at any size, closest
+// first. Images with the example's MD5 come first with distance 0. Recycled
+// images are left out. Only the candidates of the pHash index are compared.
+func FindSimilarTo(md5 string, phashes processor.PHashes, maxDistance int) ([]ImageMatch, error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT id FROM images WHERE is_recycled = FALSE AND md5 = ?", md5)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query images for similar search: %w", err)
+	}
+	defer rows.Close()
+	var matches []ImageMatch
+	exact := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan image for similar search: %w", err)
+		}
+		matches = append(matches, ImageMatch{ID: id, Exact: true})
+		exact[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if phashes.Upright == nil {
+		return matches, nil // Only exact copies of an undecodable example are found
+	}
+
+	index, err := PHashIndex()
+	if err != nil {
+		return nil, err
+	}
+	var candidates []int
+	for _, id := range index.Candidates(phashes, maxDistance) {
+		if !exact[id] {
+			candidates = append(candidates, id)
+		}
+	}
+	images, err := hashedImagesByID(db, candidates)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range candidates {
+		image, ok := images[id]
+		if !ok {
+			continue
+		}
+		// A copy found elsewhere may well have been resized or rotated
+		if d, turned := phashes.Distance(image.PHashes, true, true); d <= maxDistance {
+			matches = append(matches, ImageMatch{ID: id, Distance: d, Turned: turned})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Exact != matches[j].Exact {
+			return matches[i].Exact
+		}
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	return matches, nil
+}
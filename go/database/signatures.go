@@ -0,0 +1,51 @@
+package database
+
+import "fmt"
+
+const createSignaturesTableSQL = `
+CREATE TABLE IF NOT EXISTS similarity_signatures (
+	md5 TEXT NOT NULL,
+	provider TEXT NOT NULL, -- command line or URL of the worker
+	signature TEXT NOT NULL, -- JSON as returned by the worker
+	PRIMARY KEY (md5, provider)
+);
+`
+
+// Signatures returns the signatures a similarity provider computed earlier,
+// keyed by MD5, so unchanged images are not sent to the worker again.
+func Signatures(provider string) (map[string]string, error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT md5, signature FROM similarity_signatures WHERE provider = ?", provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query signatures: %w", err)
+	}
+	defer rows.Close()
+
+	signatures := make(map[string]string)
+	for rows.Next() {
+		var md5, signature string
+		if err := rows.Scan(&md5, &signature); err != nil {
+			return nil, fmt.Errorf("failed to scan signature: %w", err)
+		}
+		signatures[md5] = signature
+	}
+	return signatures, rows.Err()
+}
+
+// SetSignature stores the signature a similarity provider computed for an image.
+func SetSignature(md5, provider, signature string) error {
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(`
+		INSERT INTO similarity_signatures (md5, provider, signature) VALUES (?, ?, ?)
+		ON CONFLICT(md5, provider) DO UPDATE SET signature = excluded.signature
+	`, md5, provider, signature); err != nil {
+		return fmt.Errorf("failed to store signature: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kinds of image tags.
+const (
+	TagKindAlbum   = "album"
+	TagKindFace    = "face"
+	TagKindKeyword = "keyword"
+)
+
+// ImageTag is an album, person or keyword attached to an image.
+type ImageTag struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+const createImageTagsTableSQL = `
+CREATE TABLE IF NOT EXISTS image_tags (
+	image_id INTEGER NOT NULL,
+	kind TEXT NOT NULL,
+	name TEXT NOT NULL,
+	PRIMARY KEY (image_id, kind, name)
+);
+`
+
+// CurationOrder ranks copies of the same picture for keeping: the best rated
+// first, then favorites, then copies filed in albums or tagged, then copies
+// outside messenger folders, then names without a copy marker such as
+// "IMG_0001 (1).JPG", then the oldest catalog entry.
+const CurationOrder = `COALESCE(rating, 0) DESC, is_favorite DESC,
+	EXISTS (SELECT 1 FROM image_tags WHERE image_tags.image_id = images.id) DESC,
+	messenger IS NOT NULL ASC, ` + copyNameSQL + ` ASC, id ASC`
+
+// copyNameSQL is true for file names that file managers give copies: "name (1).jpg"
+// on Windows and Android, "name - Copy.jpg" on Windows, "name copy 2.jpg" on macOS.
+const copyNameSQL = `(file_name GLOB '* ([0-9]).*' OR file_name GLOB '* ([0-9][0-9]).*'
+	OR file_name GLOB '* - Copy.*' OR file_name GLOB '* - Copy ([0-9]*).*'
+	OR file_name GLOB '* copy.*' OR file_name GLOB '* copy [0-9]*.*')`
+
+// AddImageTag attaches a tag to an image. Adding a tag twice has no effect.
+func AddImageTag(imageID int, kind, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil
+	}
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec("INSERT OR IGNORE INTO image_tags (image_id, kind, name) VALUES (?, ?, ?)", imageID, kind, name); err != nil {
+		return fmt.Errorf("failed to add tag: %w", err)
+	}
+	return nil
+}
+
+// RaiseRating sets the star rating (1-5) of an image unless it already has a
+// higher one, so imports never lower a rating given since.
+func RaiseRating(imageID, rating int) error {
+	if rating < 0 || rating > 5 {
+		return fmt.Errorf("invalid rating: %d", rating)
+	}
+	db, err := GetDBInstance()
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec("UPDATE images SET rating = ? WHERE id = ? AND COALESCE(rating, 0) < ?", rating, imageID, rating); err != nil {
+		return fmt.Errorf("failed to update rating: %w", err)
+	}
+	return nil
+}
+
+// tagQueryBatch is how many image IDs GetImageTags puts in one query, well
+// below SQLite's limit on bound parameters.
+const tagQueryBatch = 500
+
+// GetImageTags returns image tags keyed by image ID: those of the given images,
+// or of all images when no IDs are given.
+func GetImageTags(ids ...int) (map[int][]ImageTag, error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[int][]ImageTag)
+	load := func(query string, args ...interface{}) error {
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			return fmt.Errorf("failed to query tags: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var imageID int
+			var tag ImageTag
+			if err := rows.Scan(&imageID, &tag.Kind, &tag.Name); err != nil {
+				return fmt.Errorf("failed to scan tag: %w", err)
+			}
+			tags[imageID] = append(tags[imageID], tag)
+		}
+		return rows.Err()
+	}
+
+	if len(ids) == 0 {
+		if err := load("SELECT image_id, kind, name FROM image_tags ORDER BY image_id, kind, name"); err != nil {
+			return nil, err
+		}
+		return tags, nil
+	}
+	for start := 0; start < len(ids); start += tagQueryBatch {
+		batch := ids[start:min(start+tagQueryBatch, len(ids))]
+		args := make([]interface{}, len(batch))
+		for i, id := range batch {
+			args[i] = id
+		}
+		query := fmt.Sprintf("SELECT image_id, kind, name FROM image_tags WHERE image_id IN (%s) ORDER BY image_id, kind, name",
+			strings.TrimSuffix(strings.Repeat("?, ", len(batch)), ", "))
+		if err := load(query, args...); err != nil {
+			return nil, err
+		}
+	}
+	return tags, nil
+}
+
+// ReelectDuplicateOriginals picks the original of every duplicate group again
+// by CurationOrder, after ratings or tags changed. It returns the number of
+// groups whose original changed.
+func ReelectDuplicateOriginals() (int, error) {
+	db, err := GetDBInstance()
+	if err != nil {
+		return 0, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT DISTINCT md5 FROM images WHERE is_duplicate = TRUE AND is_recycled = FALSE")
+	if err != nil {
+		return 0, fmt.Errorf("failed to query duplicate groups: %w", err)
+	}
+	var md5s []string
+	for rows.Next() {
+		var md5 string
+		if err := rows.Scan(&md5); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan duplicate group: %w", err)
+		}
+		md5s = append(md5s, md5)
+	}
+	rows.Close()
+
+	changed := 0
+	for _, md5 := range md5s {
+		groupChanged, err := electDuplicateOriginal(tx, md5)
+		if err != nil {
+			return 0, err
+		}
+		if groupChanged {
+			changed++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit: %w", err)
+	}
+	return changed, nil
+}
@@ -0,0 +1,59 @@
+package events
+
+import "sync"
+
+// Kind identifies what happened to an image.
+type Kind string
+
+// Event kinds.
+const (
+	ImageRecycled Kind = "image-recycled" // the file was moved to the recycle directory
+	ImageMoved    Kind = "image-moved"    // the file got a new path, e.g. by sorting
+	ImageUpdated  Kind = "image-updated"  // the file content changed and was processed again
+)
+
+// Event describes a change to one image in the catalog.
+type Event struct {
+	Kind    Kind
+	ImageID int
+	MD5     string // current content hash
+	OldMD5  string // previous content hash, set for ImageUpdated
+	Path    string // current file path
+	OldPath string // previous file path, set for ImageMoved
+}
+
+var (
+	mu          sync.RWMutex
+	subscribers = make(map[int]func(Event))
+	nextID      int
+)
+
+// Subscribe registers fn to be called for every published event and returns a
+// function that removes the subscription.
+func Subscribe(fn func(Event)) (unsubscribe func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	id := nextID
+	nextID++
+	subscribers[id] = fn
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(subscribers, id)
+	}
+}
+
+// Publish delivers an event to all subscribers before returning, so callers can
+// rely on caches and groups being up to date afterwards.
+func Publish(e Event) {
+	mu.RLock()
+	handlers := make([]func(Event), 0, len(subscribers))
+	for _, fn := range subscribers {
+		handlers = append(handlers, fn)
+	}
+	mu.RUnlock()
+
+	for _, fn := range handlers {
+		fn(e)
+	}
+}
@@ -0,0 +1,18 @@
+package events
+
+import "testing"
+
+func TestPublish(t *testing.T) {
+	var received []Event
+	unsubscribe := Subscribe(func(e Event) {
+		received = append(received, e)
+	})
+
+	Publish(Event{Kind: ImageRecycled, ImageID: 1})
+	unsubscribe()
+	Publish(Event{Kind: ImageRecycled, ImageID: 2})
+
+	if len(received) != 1 || received[0].ImageID != 1 {
+		t.Errorf("Unexpected events received: %+v", received)
+	}
+}
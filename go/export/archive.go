@@ -0,0 +1,151 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"picpurge/util"
+)
+
+// ArchivedFile is one file of a recycle plan, backed up by RecycleArchive
+// before it is moved.
+type ArchivedFile struct {
+	GroupType string // database.GroupTypeDuplicates or database.GroupTypeSimilar
+	Group     string // as in ReviewRow
+	ID        int
+	Path      string
+}
+
+// archiveManifest is the name of the file in a recycle archive that lists
+// where each archived file came from.
+const archiveManifest = "manifest.csv"
+
+// RecycleArchive writes files into a ZIP archive at archivePath, one folder per
+// group, with a manifest.csv mapping each entry back to its original path. The
+// archive is written under a temporary name and only appears at archivePath once
+// it is complete, so a recycle must not go ahead while this returns an error.
+// It returns the number of bytes archived.
+func RecycleArchive(archivePath string, files []ArchivedFile) (int64, error) {
+	var total int64
+	for _, file := range files {
+		info, err := os.Stat(file.Path)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read %s for the archive: %w", file.Path, err)
+		}
+		total += info.Size()
+	}
+	dir := filepath.Dir(archivePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, fmt.Errorf("failed to create archive folder: %w", err)
+	}
+	// Images barely compress, so the archive needs about as much room as the files
+	if free, _, err := util.VolumeUsage(dir); err == nil && free < uint64(total) {
+		return 0, fmt.Errorf("not enough free space for the archive: %s needed, %s free", util.FormatBytes(uint64(total)), util.FormatBytes(free))
+	}
+
+	partial := archivePath + ".partial"
+	out, err := os.Create(partial)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create archive: %w", err)
+	}
+	if err := writeRecycleArchive(out, files); err != nil {
+		out.Close()
+		os.Remove(partial)
+		return 0, err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(partial)
+		return 0, fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(partial)
+		return 0, fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := os.Rename(partial, archivePath); err != nil {
+		os.Remove(partial)
+		return 0, fmt.Errorf("failed to finish archive: %w", err)
+	}
+	return total, nil
+}
+
+func writeRecycleArchive(w io.Writer, files []ArchivedFile) error {
+	archive := zip.NewWriter(w)
+	var manifest strings.Builder
+	manifestWriter := csv.NewWriter(&manifest)
+	manifestWriter.Write([]string{"group_type", "group", "id", "path", "entry"})
+
+	used := make(map[string]bool)
+	for _, file := range files {
+		entry := archiveEntryName(file, used)
+		if err := addArchiveFile(archive, entry, file.Path); err != nil {
+			return err
+		}
+		manifestWriter.Write([]string{file.GroupType, file.Group, strconv.Itoa(file.ID), file.Path, entry})
+	}
+	manifestWriter.Flush()
+	if err := manifestWriter.Error(); err != nil {
+		return fmt.Errorf("failed to write archive manifest: %w", err)
+	}
+	entry, err := archive.CreateHeader(&zip.FileHeader{Name: archiveManifest, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to write archive manifest: %w", err)
+	}
+	if _, err := io.WriteString(entry, manifest.String()); err != nil {
+		return fmt.Errorf("failed to write archive manifest: %w", err)
+	}
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
+
+// archiveEntryName places a file in the folder of its group, numbering names
+// that are taken already, e.g. by IMG_0001.JPG from two different folders.
+func archiveEntryName(file ArchivedFile, used map[string]bool) string {
+	folder := path.Join(file.GroupType, file.Group)
+	name := filepath.Base(file.Path)
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	entry := path.Join(folder, name)
+	for i := 2; used[entry]; i++ {
+		entry = path.Join(folder, fmt.Sprintf("%s (%d)%s", base, i, ext))
+	}
+	used[entry] = true
+	return entry
+}
+
+// addArchiveFile adds a file under entry, keeping its modification time.
+func addArchiveFile(archive *zip.Writer, entry, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s for the archive: %w", filePath, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read %s for the archive: %w", filePath, err)
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return fmt.Errorf("failed to archive %s: %w", filePath, err)
+	}
+	header.Name = entry
+	header.Method = zip.Deflate
+	w, err := archive.CreateHeader(header)
+	if err != nil {
+		return fmt.Errorf("failed to archive %s: %w", filePath, err)
+	}
+	if _, err := io.Copy(w, file); err != nil {
+		return fmt.Errorf("failed to archive %s: %w", filePath, err)
+	}
+	return nil
+}
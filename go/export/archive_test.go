@@ -0,0 +1,74 @@
+package export
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecycleArchive(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a", "IMG_0001.JPG")
+	b := filepath.Join(dir, "b", "IMG_0001.JPG")
+	for path, content := range map[string]string{a: "first", b: "second"} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archivePath := filepath.Join(dir, "backup", "recycle.zip")
+	files := []ArchivedFile{
+		{GroupType: "duplicates", Group: "abc", ID: 1, Path: a},
+		{GroupType: "duplicates", Group: "abc", ID: 2, Path: b},
+	}
+	size, err := RecycleArchive(archivePath, files)
+	if err != nil || size != int64(len("first")+len("second")) {
+		t.Fatalf("RecycleArchive = %d, %v; expected %d bytes", size, err, len("first")+len("second"))
+	}
+	if _, err := os.Stat(archivePath + ".partial"); !os.IsNotExist(err) {
+		t.Errorf("RecycleArchive left its partial file behind")
+	}
+
+	archive, err := zip.OpenReader(archivePath)
+	if err != nil {
+		t.Fatalf("failed to open archive: %v", err)
+	}
+	defer archive.Close()
+	contents := make(map[string]string)
+	for _, file := range archive.File {
+		r, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(r)
+		r.Close()
+		contents[file.Name] = string(data)
+	}
+	expected := map[string]string{
+		"duplicates/abc/IMG_0001.JPG":     "first",
+		"duplicates/abc/IMG_0001 (2).JPG": "second",
+	}
+	for name, content := range expected {
+		if contents[name] != content {
+			t.Errorf("entry %s = %q; expected %q", name, contents[name], content)
+		}
+	}
+	if manifest := contents[archiveManifest]; !strings.Contains(manifest, b+",duplicates/abc/IMG_0001 (2).JPG") {
+		t.Errorf("manifest does not map the renamed entry back to %s:\n%s", b, manifest)
+	}
+
+	// A file that disappeared leaves no archive behind
+	os.Remove(archivePath)
+	if _, err := RecycleArchive(archivePath, append(files, ArchivedFile{Path: filepath.Join(dir, "missing.jpg")})); err == nil {
+		t.Errorf("RecycleArchive succeeded with a missing file")
+	}
+	if _, err := os.Stat(archivePath); !os.IsNotExist(err) {
+		t.Errorf("RecycleArchive left an archive after failing")
+	}
+}
@@ -0,0 +1,267 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"picpurge/database"
+	"picpurge/util"
+)
+
+// Decisions a reviewer can enter in the decision column.
+const (
+	DecisionKeep    = "keep"
+	DecisionDiscard = "discard"
+)
+
+// csvHeader names the columns of a review sheet. Only group_type, group, id,
+// path and decision are read back; the others help the reviewer decide.
+var csvHeader = []string{"group_type", "group", "id", "path", "size", "width", "height", "date", "camera", "suggested", "decision"}
+
+// ReviewRow is one image of a duplicate or similar group in a review sheet.
+type ReviewRow struct {
+	GroupType string // database.GroupTypeDuplicates or database.GroupTypeSimilar
+	Group     string // MD5 of a duplicate group, ID of a similar group (member IDs such as 5-12 in older catalogs)
+	ID        int
+	Path      string
+	Size      int64
+	Width     int
+	Height    int
+	Date      string
+	Camera    string
+	Suggested string // what picpurge would keep or discard, empty when it has no opinion
+	Decision  string // DecisionKeep, DecisionDiscard or empty when undecided
+}
+
+// GroupsCSV writes every duplicate and similar group as a review sheet, one
+// image per row, with an empty decision column to fill in. It returns the
+// number of images written.
+func GroupsCSV(w io.Writer) (int, error) {
+	db, err := database.GetDBInstance()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get database instance: %w", err)
+	}
+	duplicates, err := duplicateRows(db)
+	if err != nil {
+		return 0, err
+	}
+	similar, err := similarRows(db)
+	if err != nil {
+		return 0, err
+	}
+
+	// Spreadsheet programs only recognize UTF-8 files by their byte order mark
+	if _, err := io.WriteString(w, "\ufeff"); err != nil {
+		return 0, err
+	}
+	writer := csv.NewWriter(w)
+	writer.Write(csvHeader)
+	rows := append(duplicates, similar...)
+	for _, row := range rows {
+		writer.Write([]string{
+			row.GroupType, row.Group, strconv.Itoa(row.ID), row.Path,
+			strconv.FormatInt(row.Size, 10), strconv.Itoa(row.Width), strconv.Itoa(row.Height),
+			row.Date, row.Camera, row.Suggested, row.Decision,
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return 0, fmt.Errorf("failed to write CSV: %w", err)
+	}
+	return len(rows), nil
+}
+
+const reviewColumns = "id, md5, file_path, file_size, image_width, image_height, create_date, COALESCE(device_make, ''), COALESCE(device_model, '')"
+
+// scanReviewRow reads the reviewColumns of an image, followed by extra columns.
+func scanReviewRow(rows *sql.Rows, extra ...interface{}) (ReviewRow, string, error) {
+	var row ReviewRow
+	var md5, createDate, deviceMake, deviceModel string
+	dest := append([]interface{}{&row.ID, &md5, &row.Path, &row.Size, &row.Width, &row.Height, &createDate, &deviceMake, &deviceModel}, extra...)
+	if err := rows.Scan(dest...); err != nil {
+		return row, "", err
+	}
+	row.Date = createDate
+	if t, err := time.Parse(time.RFC3339, createDate); err == nil {
+		row.Date = t.Format("2006-01-02 15:04:05")
+	}
+	// Camera names are stored quoted, as EXIF tags print them
+	row.Camera = strings.TrimSpace(strings.Trim(deviceMake, `"`) + " " + strings.Trim(deviceModel, `"`))
+	return row, md5, nil
+}
+
+// duplicateRows lists the images of every duplicate group, the original first.
+func duplicateRows(db *sql.DB) ([]ReviewRow, error) {
+	rows, err := db.Query("SELECT " + reviewColumns + `, is_duplicate FROM images
+		WHERE is_recycled = FALSE AND md5 IN (SELECT md5 FROM images WHERE is_duplicate = TRUE AND is_recycled = FALSE)
+		ORDER BY md5, is_duplicate, id`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying duplicate groups: %w", err)
+	}
+	defer rows.Close()
+
+	var result []ReviewRow
+	for rows.Next() {
+		var isDuplicate bool
+		row, md5, err := scanReviewRow(rows, &isDuplicate)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning duplicate image: %w", err)
+		}
+		row.GroupType, row.Group, row.Suggested = database.GroupTypeDuplicates, md5, DecisionKeep
+		if isDuplicate {
+			row.Suggested = DecisionDiscard
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// similarRows lists the images of every similar group. The largest image of
+// each group is suggested for keeping; the others are left to the reviewer.
+func similarRows(db *sql.DB) ([]ReviewRow, error) {
+	rows, err := db.Query("SELECT " + reviewColumns + ", similar_images, COALESCE(similar_group, '') FROM images WHERE is_recycled = FALSE AND similar_images IS NOT NULL AND similar_images != '' ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("error querying similar groups: %w", err)
+	}
+	defer rows.Close()
+
+	groups := make(map[string][]ReviewRow)
+	var keys []string
+	for rows.Next() {
+		var similarJSON, similarGroup string
+		row, _, err := scanReviewRow(rows, &similarJSON, &similarGroup)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning similar image: %w", err)
+		}
+		var members []int
+		if err := json.Unmarshal([]byte(similarJSON), &members); err != nil || len(members) < 2 {
+			continue
+		}
+		sort.Ints(members)
+		parts := make([]string, len(members))
+		for i, id := range members {
+			parts[i] = strconv.Itoa(id)
+		}
+		row.GroupType, row.Group = database.GroupTypeSimilar, strings.Join(parts, "-")
+		if similarGroup != "" {
+			row.Group = similarGroup // the key of its review
+		}
+		if groups[row.Group] == nil {
+			keys = append(keys, row.Group)
+		}
+		groups[row.Group] = append(groups[row.Group], row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var result []ReviewRow
+	for _, key := range keys {
+		group := groups[key]
+		best := 0
+		for i, row := range group {
+			area, bestArea := row.Width*row.Height, group[best].Width*group[best].Height
+			if area > bestArea || (area == bestArea && row.Size > group[best].Size) {
+				best = i
+			}
+		}
+		group[best].Suggested = DecisionKeep
+		result = append(result, group...)
+	}
+	return result, nil
+}
+
+// ReadDecisionsCSV reads a review sheet written by GroupsCSV and edited in a
+// spreadsheet. Columns are found by their header, so the reviewer may reorder
+// or add columns. Sheets saved with semicolons, as some locales do, and in a
+// legacy code page are read as well. Rows without a decision are returned with
+// an empty Decision.
+func ReadDecisionsCSV(r io.Reader) ([]ReviewRow, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	if !utf8.Valid(data) {
+		data = []byte(util.DecodeText(data))
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	firstLine, _ := bufio.NewReader(bytes.NewReader(data)).ReadString('\n')
+	if strings.Count(firstLine, ";") > strings.Count(firstLine, ",") {
+		reader.Comma = ';'
+	}
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CSV: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("the CSV file is empty")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"group_type", "group", "id", "path", "decision"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("the CSV file has no %s column", name)
+		}
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var rows []ReviewRow
+	for n, record := range records[1:] {
+		line := n + 2
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue // blank lines left by the spreadsheet
+		}
+		id, err := strconv.Atoi(field(record, "id"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid id %q", line, field(record, "id"))
+		}
+		decision, err := ParseDecision(field(record, "decision"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rows = append(rows, ReviewRow{
+			GroupType: field(record, "group_type"),
+			Group:     field(record, "group"),
+			ID:        id,
+			Path:      field(record, "path"),
+			Suggested: field(record, "suggested"),
+			Decision:  decision,
+		})
+	}
+	return rows, nil
+}
+
+// ParseDecision accepts the words a reviewer is likely to type: keep or k,
+// and discard, d, delete or remove. An empty cell means undecided.
+func ParseDecision(s string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "":
+		return "", nil
+	case "keep", "k":
+		return DecisionKeep, nil
+	case "discard", "d", "delete", "remove":
+		return DecisionDiscard, nil
+	}
+	return "", fmt.Errorf("unknown decision %q; use keep or discard", s)
+}
@@ -0,0 +1,49 @@
+package export
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadDecisionsCSV(t *testing.T) {
+	// As saved by a spreadsheet in a European locale: semicolons, a legacy code
+	// page, a reordered column and a blank line
+	sheet := "decision;group_type;group;id;path;notes\r\n" +
+		"Keep;duplicates;abc;1;/photos/caf\xe9.jpg;\r\n" +
+		"d;duplicates;abc;2;/photos/copy.jpg;blurry\r\n" +
+		";;;;;\r\n" +
+		";similar;3-4;3;/photos/a.jpg;\r\n"
+	rows, err := ReadDecisionsCSV(strings.NewReader(sheet))
+	if err != nil {
+		t.Fatalf("ReadDecisionsCSV failed: %v", err)
+	}
+	expected := []ReviewRow{
+		{GroupType: "duplicates", Group: "abc", ID: 1, Path: "/photos/caf\u00e9.jpg", Decision: DecisionKeep},
+		{GroupType: "duplicates", Group: "abc", ID: 2, Path: "/photos/copy.jpg", Decision: DecisionDiscard},
+		{GroupType: "similar", Group: "3-4", ID: 3, Path: "/photos/a.jpg"},
+	}
+	if len(rows) != len(expected) {
+		t.Fatalf("ReadDecisionsCSV returned %d rows; expected %d: %+v", len(rows), len(expected), rows)
+	}
+	for i := range expected {
+		if rows[i] != expected[i] {
+			t.Errorf("row %d = %+v; expected %+v", i, rows[i], expected[i])
+		}
+	}
+
+	// As written by GroupsCSV
+	sheet = "\ufeffgroup_type,group,id,path,suggested,decision\nsimilar,3-4,4,/photos/b.jpg,keep,discard\n"
+	if rows, err := ReadDecisionsCSV(strings.NewReader(sheet)); err != nil || len(rows) != 1 || rows[0].Decision != DecisionDiscard || rows[0].Suggested != DecisionKeep {
+		t.Errorf("ReadDecisionsCSV = %+v, %v; expected one discarded row", rows, err)
+	}
+
+	for _, bad := range []string{
+		"group_type,group,id,path\nsimilar,3-4,4,/photos/b.jpg\n",
+		"group_type,group,id,path,decision\nsimilar,3-4,4,/photos/b.jpg,maybe\n",
+		"group_type,group,id,path,decision\nsimilar,3-4,four,/photos/b.jpg,keep\n",
+	} {
+		if _, err := ReadDecisionsCSV(strings.NewReader(bad)); err == nil {
+			t.Errorf("ReadDecisionsCSV(%q) succeeded; expected an error", bad)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package export
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"picpurge/database"
+	"picpurge/fileops"
+	"picpurge/util"
+)
+
+// Favorites copies all favorite images into destination, laid out like the
+// sort command (YYYY/MM) and named according to policy. It returns the number
+// of files copied.
+func Favorites(destination string, policy util.RenamePolicy) (int, error) {
+	if destination == "" {
+		return 0, fmt.Errorf("destination is required")
+	}
+
+	db, err := database.GetDBInstance()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get database instance: %w", err)
+	}
+	rows, err := db.Query("SELECT id, file_path, file_name, md5, create_date FROM images WHERE is_favorite = TRUE AND is_recycled = FALSE ORDER BY id ASC")
+	if err != nil {
+		return 0, fmt.Errorf("error querying favorites: %w", err)
+	}
+	defer rows.Close()
+
+	copied := 0
+	for rows.Next() {
+		var id int
+		var filePath, fileName, md5, createDateStr string
+		if err := rows.Scan(&id, &filePath, &fileName, &md5, &createDateStr); err != nil {
+			log.Printf("Error scanning favorite image: %v\n", err)
+			continue
+		}
+
+		createDate, err := time.Parse(time.RFC3339, createDateStr)
+		if err != nil {
+			log.Printf("Warning: Could not parse create_date '%s' for image ID %d. Using current time. Error: %v\n", createDateStr, id, err)
+			createDate = time.Now()
+		}
+
+		dir := util.SortDir(destination, createDate)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return copied, fmt.Errorf("error creating directory %s: %w", dir, err)
+		}
+		destPath, err := util.UniquePath(dir, util.SortFileName(policy, fileName, createDate, md5))
+		if err != nil {
+			log.Printf("Error choosing destination name for %s: %v\n", filePath, err)
+			continue
+		}
+		if err := fileops.Copy(filePath, destPath); err != nil {
+			log.Printf("Error copying file from %s to %s: %v\n", filePath, destPath, err)
+			continue
+		}
+		copied++
+	}
+	if err := rows.Err(); err != nil {
+		return copied, err
+	}
+	return copied, nil
+}
@@ -0,0 +1,65 @@
+package fileops
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// Fault names a failure InjectFaults simulates, so the way picpurge copes with
+// file operations that go wrong can be tried out end to end.
+type Fault string
+
+// Faults that can be injected.
+const (
+	FaultCopy        Fault = "copy"   // copies fail half-way, after writing part of the destination
+	FaultCrossDevice Fault = "exdev"  // moves cannot rename, as across volumes, and copy and delete instead
+	FaultRemove      Fault = "remove" // removing files fails
+)
+
+// errInjected is returned by operations failed on purpose.
+var errInjected = errors.New("injected failure")
+
+// faults holds the injected faults. It is set before any file is touched.
+var faults = make(map[Fault]bool)
+
+// InjectFaults makes the given operations fail from now on, replacing faults
+// injected before. It is meant for testing and never used in normal runs.
+func InjectFaults(list ...Fault) {
+	faults = make(map[Fault]bool)
+	for _, fault := range list {
+		faults[fault] = true
+	}
+}
+
+// renameFile renames src to dst for a move, failing as across volumes with FaultCrossDevice.
+func renameFile(src, dst string) error {
+	if faults[FaultCrossDevice] {
+		return &os.LinkError{Op: "rename", Old: src, New: dst, Err: syscall.EXDEV}
+	}
+	return rename(src, dst)
+}
+
+// removeFile removes path, failing with FaultRemove.
+func removeFile(path string) error {
+	if faults[FaultRemove] {
+		return &os.PathError{Op: "remove", Path: path, Err: errInjected}
+	}
+	return os.Remove(path)
+}
+
+// copySource returns the reader a copy of src reads from, which fails half-way
+// through with FaultCopy.
+func copySource(src *os.File, size int64) io.Reader {
+	if faults[FaultCopy] {
+		return io.MultiReader(io.LimitReader(src, size/2), failingReader{})
+	}
+	return src
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errInjected
+}
@@ -0,0 +1,245 @@
+// Package fileops performs every copy, move and delete picpurge does on image
+// files. Transient failures, such as a file briefly locked by a virus scanner or
+// a dropped network share, are retried with backoff. Moves behave the same on
+// every platform, and each finished operation is reported to subscribers so it
+// can be logged or audited.
+package fileops
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Op names a file operation.
+type Op string
+
+// File operations.
+const (
+	OpCopy    Op = "copy"
+	OpMove    Op = "move"
+	OpReplace Op = "replace" // a finished temporary file takes the place of an original
+	OpRemove  Op = "remove"
+	OpTrash   Op = "trash" // sent to a system trash picpurge cannot look into, i.e. the Windows Recycle Bin
+)
+
+// Record describes one finished file operation, successful or not.
+type Record struct {
+	Op       Op
+	Src      string
+	Dst      string // empty for OpRemove
+	Attempts int    // tries of the last step, more than 1 after transient failures
+	ViaCopy  bool   // a move done as copy and delete, e.g. across volumes
+	Err      error
+}
+
+// maxAttempts and backoff control retries of transient failures. The delay
+// doubles after every attempt.
+var (
+	maxAttempts = 4
+	backoff     = 100 * time.Millisecond
+)
+
+// rename is os.Rename, replaced in tests to simulate transient failures.
+var rename = os.Rename
+
+var (
+	mu          sync.RWMutex
+	subscribers = make(map[int]func(Record))
+	nextID      int
+)
+
+// Subscribe registers fn to be called after every file operation and returns a
+// function that removes the subscription.
+func Subscribe(fn func(Record)) (unsubscribe func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	id := nextID
+	nextID++
+	subscribers[id] = fn
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(subscribers, id)
+	}
+}
+
+func publish(r Record) {
+	mu.RLock()
+	handlers := make([]func(Record), 0, len(subscribers))
+	for _, fn := range subscribers {
+		handlers = append(handlers, fn)
+	}
+	mu.RUnlock()
+
+	for _, fn := range handlers {
+		fn(r)
+	}
+}
+
+// retry runs fn until it succeeds, fails permanently or runs out of attempts,
+// and returns the number of attempts made.
+func retry(op Op, path string, fn func() error) (int, error) {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !isTransient(err) || attempt == maxAttempts {
+			return attempt, err
+		}
+		log.Printf("Warning: %s of %s failed (attempt %d of %d), retrying: %v\n", op, path, attempt, maxAttempts, err)
+		time.Sleep(backoff << (attempt - 1))
+	}
+}
+
+// Copy copies src to dst, replacing dst. A failed copy leaves no partial dst behind.
+func Copy(src, dst string) error {
+	attempts, err := retry(OpCopy, src, func() error { return copyFile(src, dst) })
+	publish(Record{Op: OpCopy, Src: src, Dst: dst, Attempts: attempts, Err: err})
+	return err
+}
+
+func copyFile(src, dst string) error {
+	sourceFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+	info, err := sourceFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	destinationFile, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(destinationFile, copySource(sourceFile, info.Size())); err != nil {
+		destinationFile.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := destinationFile.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+// Move moves src to dst. Unlike os.Rename it never overwrites an existing dst,
+// on Windows as elsewhere, though a case-only rename of the same file is
+// allowed. When renaming fails, e.g. across volumes, the file is copied and the
+// original removed.
+func Move(src, dst string) error {
+	record := Record{Op: OpMove, Src: src, Dst: dst}
+	record.Err = move(src, dst, &record)
+	publish(record)
+	return record.Err
+}
+
+func move(src, dst string, record *Record) error {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if dstInfo, err := os.Stat(dst); err == nil && !os.SameFile(srcInfo, dstInfo) {
+		return fmt.Errorf("destination already exists: %s: %w", dst, os.ErrExist)
+	}
+
+	var renameErr error
+	if record.Attempts, renameErr = retry(OpMove, src, func() error { return renameFile(src, dst) }); renameErr == nil {
+		return nil
+	}
+
+	record.ViaCopy = true
+	if record.Attempts, err = retry(OpCopy, src, func() error { return copyFile(src, dst) }); err != nil {
+		return fmt.Errorf("failed to move or copy file: %w", errors.Join(renameErr, err))
+	}
+	if record.Attempts, err = retry(OpRemove, src, func() error { return removeFile(src) }); err != nil {
+		// Leave the file only where it was, as the caller takes the move as failed
+		if removeErr := os.Remove(dst); removeErr != nil {
+			return fmt.Errorf("copied file successfully but failed to remove original, and the copy at %s: %w", dst, errors.Join(err, removeErr))
+		}
+		return fmt.Errorf("copied file successfully but failed to remove original: %w", err)
+	}
+	return nil
+}
+
+// Replace renames the finished temporary file tmp over dst. On failure tmp is
+// removed and dst is left as it was.
+func Replace(tmp, dst string) error {
+	attempts, err := retry(OpReplace, dst, func() error { return rename(tmp, dst) })
+	if err != nil {
+		os.Remove(tmp)
+	}
+	publish(Record{Op: OpReplace, Src: tmp, Dst: dst, Attempts: attempts, Err: err})
+	return err
+}
+
+// Remove deletes a file.
+func Remove(path string) error {
+	attempts, err := retry(OpRemove, path, func() error { return removeFile(path) })
+	publish(Record{Op: OpRemove, Src: path, Attempts: attempts, Err: err})
+	return err
+}
+
+// Recycle moves a file into recycleDir, numbering its name if the directory
+// already holds a file of that name, and returns its new path. With
+// UseSystemTrash it sends the file to the system trash instead; the path
+// returned is then "" if the trash does not tell where the file went.
+func Recycle(filePath, recycleDir string) (string, error) {
+	// Check if file exists
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return "", fmt.Errorf("file does not exist: %s", filePath)
+	}
+	if systemTrash {
+		return trash(filePath)
+	}
+
+	// Create the Recycle directory if it doesn't exist
+	if err := os.MkdirAll(recycleDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create Recycle directory: %w", err)
+	}
+
+	// Get the base name of the file
+	fileName := filepath.Base(filePath)
+
+	// If a file with the same name already exists in Recycle, add a counter
+	for counter := 0; counter <= 1000; counter++ {
+		destPath := filepath.Join(recycleDir, numberedName(fileName, counter))
+		if _, err := os.Stat(destPath); !os.IsNotExist(err) {
+			continue
+		}
+		if err := Move(filePath, destPath); err != nil {
+			return "", err
+		}
+		return destPath, nil
+	}
+	return "", fmt.Errorf("too many files with the same name in Recycle directory")
+}
+
+// Unrecycle moves a file that Recycle moved to recycledPath back to filePath,
+// e.g. when the catalog could not record that it was recycled.
+func Unrecycle(recycledPath, filePath string) error {
+	if recycledPath == "" {
+		return fmt.Errorf("%s cannot be moved back from %s", filePath, systemTrashName)
+	}
+	if err := Move(recycledPath, filePath); err != nil {
+		return err
+	}
+	ForgetTrashed(recycledPath)
+	return nil
+}
+
+// numberedName returns fileName with counter added before the extension, as
+// in photo_2.jpg, or fileName itself for counter 0.
+func numberedName(fileName string, counter int) string {
+	if counter == 0 {
+		return fileName
+	}
+	ext := filepath.Ext(fileName)
+	return fmt.Sprintf("%s_%d%s", fileName[:len(fileName)-len(ext)], counter, ext)
+}
@@ -0,0 +1,213 @@
+package fileops
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCopy(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.txt")
+	dst := filepath.Join(dir, "dst.txt")
+	content := "Hello, World!"
+	if err := os.WriteFile(src, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write source file: %v", err)
+	}
+
+	if err := Copy(src, dst); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	dstContent, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatalf("Failed to read destination file: %v", err)
+	}
+	if string(dstContent) != content {
+		t.Fatalf("Content mismatch. Expected: %s, Got: %s", content, string(dstContent))
+	}
+}
+
+func TestRecycle(t *testing.T) {
+	dir := t.TempDir()
+	recycleDir := filepath.Join(dir, "Recycle")
+	for i := 0; i < 2; i++ {
+		filePath := filepath.Join(dir, "photo.jpg")
+		if err := os.WriteFile(filePath, []byte("photo"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		destPath, err := Recycle(filePath, recycleDir)
+		if err != nil {
+			t.Fatalf("Recycle failed: %v", err)
+		}
+
+		// The second file of the same name is numbered
+		expected := filepath.Join(recycleDir, "photo.jpg")
+		if i == 1 {
+			expected = filepath.Join(recycleDir, "photo_1.jpg")
+		}
+		if destPath != expected {
+			t.Errorf("Recycle returned %s; expected %s", destPath, expected)
+		}
+		if _, err := os.Stat(destPath); err != nil {
+			t.Errorf("File was not moved to recycle directory: %v", err)
+		}
+		if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+			t.Errorf("Original file still exists")
+		}
+	}
+}
+
+func TestRecycleToSystemTrash(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		t.Skip("the freedesktop.org trash is used on Linux and other Unix systems")
+	}
+	dir := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", filepath.Join(dir, "data"))
+	UseSystemTrash(true)
+	defer UseSystemTrash(false)
+
+	filePath := filepath.Join(dir, "my photo.jpg")
+	if err := os.WriteFile(filePath, []byte("photo"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	destPath, err := Recycle(filePath, filepath.Join(dir, "Recycle"))
+	if err != nil {
+		t.Fatalf("Recycle failed: %v", err)
+	}
+	trashDir := filepath.Join(dir, "data", "Trash")
+	if expected := filepath.Join(trashDir, "files", "my photo.jpg"); destPath != expected {
+		t.Errorf("Recycle returned %s; expected %s", destPath, expected)
+	}
+	infoPath := filepath.Join(trashDir, "info", "my photo.jpg.trashinfo")
+	info, err := os.ReadFile(infoPath)
+	if err != nil {
+		t.Fatalf("Failed to read trash info: %v", err)
+	}
+	if expected := "Path=" + strings.ReplaceAll(filePath, " ", "%20") + "\n"; !strings.Contains(string(info), expected) {
+		t.Errorf("trash info = %q; expected it to contain %q", info, expected)
+	}
+
+	// Moving the file back leaves no entry in the trash
+	if err := Move(destPath, filePath); err != nil {
+		t.Fatalf("Move failed: %v", err)
+	}
+	ForgetTrashed(destPath)
+	if _, err := os.Stat(infoPath); !os.IsNotExist(err) {
+		t.Errorf("trash info was not removed")
+	}
+}
+
+func TestMoveNeverOverwrites(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.jpg")
+	dst := filepath.Join(dir, "b.jpg")
+	os.WriteFile(src, []byte("a"), 0644)
+	os.WriteFile(dst, []byte("b"), 0644)
+
+	if err := Move(src, dst); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("Move over an existing file returned %v; expected ErrExist", err)
+	}
+	if data, _ := os.ReadFile(dst); string(data) != "b" {
+		t.Errorf("Destination was overwritten with %q", data)
+	}
+}
+
+func TestMoveFallsBackToCopy(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.jpg")
+	dst := filepath.Join(dir, "b.jpg")
+	os.WriteFile(src, []byte("a"), 0644)
+
+	// A rename that cannot work, as across volumes
+	defer func(original func(string, string) error) { rename = original }(rename)
+	rename = func(string, string) error { return &os.LinkError{Op: "rename", Err: syscall.EXDEV} }
+
+	var records []Record
+	defer Subscribe(func(r Record) { records = append(records, r) })()
+	if err := Move(src, dst); err != nil {
+		t.Fatalf("Move failed: %v", err)
+	}
+	if data, _ := os.ReadFile(dst); string(data) != "a" {
+		t.Errorf("Destination holds %q; expected the moved file", data)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("Original file still exists")
+	}
+	if len(records) != 1 || records[0].Op != OpMove || !records[0].ViaCopy || records[0].Err != nil {
+		t.Errorf("Unexpected records: %+v", records)
+	}
+}
+
+func TestRetryTransientErrors(t *testing.T) {
+	defer func(original time.Duration) { backoff = original }(backoff)
+	backoff = time.Millisecond
+
+	calls := 0
+	attempts, err := retry(OpMove, "a.jpg", func() error {
+		calls++
+		if calls < 3 {
+			return &os.PathError{Op: "rename", Path: "a.jpg", Err: syscall.EBUSY}
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Errorf("retry = %d, %v; expected success on attempt 3", attempts, err)
+	}
+
+	calls = 0
+	attempts, err = retry(OpMove, "a.jpg", func() error {
+		calls++
+		return os.ErrPermission
+	})
+	if err == nil || attempts != 1 {
+		t.Errorf("retry = %d, %v; expected a permanent error to fail at once", attempts, err)
+	}
+}
+
+func TestInjectedFaults(t *testing.T) {
+	defer InjectFaults()
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.jpg")
+	dst := filepath.Join(dir, "b.jpg")
+	os.WriteFile(src, []byte("abcdefgh"), 0644)
+
+	// A copy failing half-way leaves no partial destination
+	InjectFaults(FaultCopy)
+	if err := Copy(src, dst); !errors.Is(err, errInjected) {
+		t.Fatalf("Copy returned %v; expected the injected failure", err)
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("Failed copy left %s behind", dst)
+	}
+
+	// A move across volumes whose original cannot be removed leaves the file
+	// only where it was
+	InjectFaults(FaultCrossDevice, FaultRemove)
+	if err := Move(src, dst); !errors.Is(err, errInjected) {
+		t.Fatalf("Move returned %v; expected the injected failure", err)
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("Failed move left the copy %s behind", dst)
+	}
+	if data, _ := os.ReadFile(src); string(data) != "abcdefgh" {
+		t.Errorf("Original holds %q after the failed move", data)
+	}
+
+	// Moving across volumes copies and deletes
+	InjectFaults(FaultCrossDevice)
+	recycled, err := Recycle(src, filepath.Join(dir, "Recycle"))
+	if err != nil {
+		t.Fatalf("Recycle failed: %v", err)
+	}
+	if err := Unrecycle(recycled, src); err != nil {
+		t.Fatalf("Unrecycle failed: %v", err)
+	}
+	if data, _ := os.ReadFile(src); string(data) != "abcdefgh" {
+		t.Errorf("Original holds %q after moving it back", data)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+
+package fileops
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isTransient reports whether err is worth retrying: a busy file or a network
+// file system that timed out or lost track of a handle.
+func isTransient(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	switch errno {
+	case syscall.EBUSY, syscall.EAGAIN, syscall.EINTR, syscall.ETIMEDOUT, syscall.ESTALE:
+		return true
+	}
+	return false
+}
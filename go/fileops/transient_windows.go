@@ -0,0 +1,28 @@
+package fileops
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Windows error codes that usually clear up when retried.
+const (
+	errorAccessDenied     syscall.Errno = 5  // often a virus scanner holding the file
+	errorSharingViolation syscall.Errno = 32 // another process has the file open
+	errorLockViolation    syscall.Errno = 33
+	errorUnexpNetErr      syscall.Errno = 59 // network share hiccup
+	errorNetnameDeleted   syscall.Errno = 64
+)
+
+// isTransient reports whether err is worth retrying.
+func isTransient(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	switch errno {
+	case errorAccessDenied, errorSharingViolation, errorLockViolation, errorUnexpNetErr, errorNetnameDeleted:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+package fileops
+
+import "path/filepath"
+
+// systemTrash makes Recycle send files to the system trash.
+var systemTrash bool
+
+// UseSystemTrash makes Recycle send files to the trash of the operating system
+// instead of a recycle directory, so they can be restored with the usual tools
+// of the desktop: the freedesktop.org trash on Linux and other Unix systems,
+// the Trash on macOS and the Recycle Bin on Windows.
+func UseSystemTrash(enabled bool) {
+	systemTrash = enabled
+}
+
+// RecycleDestination describes where Recycle moves files, for messages.
+func RecycleDestination(recycleDir string) string {
+	if systemTrash {
+		return systemTrashName
+	}
+	absDir, err := filepath.Abs(recycleDir)
+	if err != nil {
+		return recycleDir
+	}
+	return absDir
+}
@@ -0,0 +1,59 @@
+package fileops
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const systemTrashName = "the Trash"
+
+// trash moves a file into the Trash: ~/.Trash for files on the startup disk
+// and .Trashes/$uid at the top of other volumes, where the Finder lists them.
+// Finder's Put Back only works for files it trashed itself; picpurge undo and
+// recycle restore move these back.
+func trash(filePath string) (string, error) {
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return "", err
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the Trash: %w", err)
+	}
+	dir := filepath.Join(home, ".Trash")
+	if !sameDevice(info, home) {
+		if top, err := mountTop(absPath); err == nil {
+			volumeTrash := filepath.Join(top, ".Trashes", strconv.Itoa(os.Getuid()))
+			if err := os.MkdirAll(volumeTrash, 0700); err == nil {
+				dir = volumeTrash
+			}
+		}
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create the Trash: %w", err)
+	}
+
+	fileName := filepath.Base(absPath)
+	for counter := 0; counter <= 1000; counter++ {
+		destPath := filepath.Join(dir, numberedName(fileName, counter))
+		err := Move(absPath, destPath)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return destPath, nil
+	}
+	return "", fmt.Errorf("too many files with the same name in %s", dir)
+}
+
+// ForgetTrashed does nothing on macOS, whose Trash keeps nothing but the file.
+func ForgetTrashed(path string) {}
@@ -0,0 +1,151 @@
+//go:build !windows && !darwin
+
+package fileops
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const systemTrashName = "the trash"
+
+// trash moves a file into a trash as laid out by the freedesktop.org Trash
+// specification, which file managers use to list and restore it. Files on the
+// file system of the home directory go to the home trash, others to the trash
+// at the top of their own file system, or the home trash if that fails.
+func trash(filePath string) (string, error) {
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return "", err
+	}
+	home, err := homeTrash()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(home, 0700); err != nil {
+		return "", fmt.Errorf("failed to create trash directory: %w", err)
+	}
+	if !sameDevice(info, home) {
+		if top, err := topTrash(absPath); err == nil {
+			if dst, err := trashInto(top, absPath); err == nil {
+				return dst, nil
+			}
+		}
+	}
+	return trashInto(home, absPath)
+}
+
+// homeTrash returns $XDG_DATA_HOME/Trash.
+func homeTrash() (string, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if !filepath.IsAbs(dataHome) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to find the home trash: %w", err)
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dataHome, "Trash"), nil
+}
+
+// topTrash returns the trash of the current user at the top of the file
+// system of path: $topdir/.Trash/$uid if an administrator set up .Trash,
+// otherwise $topdir/.Trash-$uid.
+func topTrash(path string) (string, error) {
+	top, err := mountTop(path)
+	if err != nil {
+		return "", err
+	}
+	uid := strconv.Itoa(os.Getuid())
+	shared := filepath.Join(top, ".Trash")
+	// The specification ignores a .Trash that is a symbolic link or lacks the sticky bit
+	if info, err := os.Lstat(shared); err == nil && info.IsDir() && info.Mode()&os.ModeSticky != 0 {
+		dir := filepath.Join(shared, uid)
+		if err := os.MkdirAll(dir, 0700); err == nil {
+			return dir, nil
+		}
+	}
+	dir := filepath.Join(top, ".Trash-"+uid)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	if info, err := os.Lstat(dir); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", dir)
+	}
+	return dir, nil
+}
+
+// trashInto moves the file at absPath into the trash directory dir. The
+// .trashinfo file that records where it came from is created first, which
+// also reserves the name.
+func trashInto(dir, absPath string) (string, error) {
+	filesDir, infoDir := filepath.Join(dir, "files"), filepath.Join(dir, "info")
+	for _, d := range []string{filesDir, infoDir} {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			return "", fmt.Errorf("failed to create trash directory: %w", err)
+		}
+	}
+	trashInfo := fmt.Sprintf("[Trash Info]\nPath=%s\nDeletionDate=%s\n",
+		(&url.URL{Path: absPath}).EscapedPath(), time.Now().Format("2006-01-02T15:04:05"))
+
+	fileName := filepath.Base(absPath)
+	for counter := 0; counter <= 1000; counter++ {
+		name := numberedName(fileName, counter)
+		infoPath := filepath.Join(infoDir, name+".trashinfo")
+		f, err := os.OpenFile(infoPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to write trash info: %w", err)
+		}
+		_, err = f.WriteString(trashInfo)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(infoPath)
+			return "", fmt.Errorf("failed to write trash info: %w", err)
+		}
+		destPath := filepath.Join(filesDir, name)
+		if err := Move(absPath, destPath); err != nil {
+			os.Remove(infoPath)
+			if errors.Is(err, os.ErrExist) {
+				continue // a file left without its .trashinfo
+			}
+			return "", err
+		}
+		return destPath, nil
+	}
+	return "", fmt.Errorf("too many files with the same name in %s", dir)
+}
+
+// ForgetTrashed removes the .trashinfo of a file that was moved out of the
+// trash or deleted from it, so file managers no longer list it.
+func ForgetTrashed(path string) {
+	filesDir := filepath.Dir(path)
+	dir := filepath.Dir(filesDir)
+	if filepath.Base(filesDir) != "files" || !isTrashDir(dir) {
+		return
+	}
+	infoPath := filepath.Join(dir, "info", filepath.Base(path)+".trashinfo")
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		os.Remove(infoPath)
+	}
+}
+
+// isTrashDir reports whether dir is named like a home or top directory trash.
+func isTrashDir(dir string) bool {
+	name := filepath.Base(dir)
+	return name == "Trash" || strings.HasPrefix(name, ".Trash-") || filepath.Base(filepath.Dir(dir)) == ".Trash"
+}
@@ -0,0 +1,36 @@
+//go:build !windows
+
+package fileops
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// sameDevice reports whether the file of info is on the file system of path.
+func sameDevice(info os.FileInfo, path string) bool {
+	other, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	a, ok := info.Sys().(*syscall.Stat_t)
+	b, ok2 := other.Sys().(*syscall.Stat_t)
+	return ok && ok2 && a.Dev == b.Dev
+}
+
+// mountTop returns the top directory of the file system path is on.
+func mountTop(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	top := path
+	for {
+		parent := filepath.Dir(top)
+		if parent == top || !sameDevice(info, parent) {
+			return top, nil
+		}
+		top = parent
+	}
+}
@@ -0,0 +1,66 @@
+package fileops
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+const systemTrashName = "the Recycle Bin"
+
+var procSHFileOperationW = syscall.NewLazyDLL("shell32.dll").NewProc("SHFileOperationW")
+
+// shFileOpStruct is SHFILEOPSTRUCTW.
+type shFileOpStruct struct {
+	hwnd                  uintptr
+	wFunc                 uint32
+	pFrom                 *uint16
+	pTo                   *uint16
+	fFlags                uint16
+	fAnyOperationsAborted int32
+	hNameMappings         uintptr
+	lpszProgressTitle     *uint16
+}
+
+const (
+	foDelete            = 0x3
+	fofSilent           = 0x4
+	fofNoConfirmation   = 0x10
+	fofAllowUndo        = 0x40
+	fofNoErrorUI        = 0x400
+	fofWantNukeWarning  = 0x4000 // ask before deleting a file the Recycle Bin cannot take
+	trashOperationFlags = fofSilent | fofNoConfirmation | fofAllowUndo | fofNoErrorUI | fofWantNukeWarning
+)
+
+// trash sends a file to the Recycle Bin, where Explorer can restore it. The
+// Recycle Bin does not tell where it keeps the file, so trash returns "" and
+// picpurge cannot move the file back itself.
+func trash(filePath string) (string, error) {
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return "", err
+	}
+	from, err := syscall.UTF16FromString(absPath)
+	if err != nil {
+		return "", err
+	}
+	from = append(from, 0) // a list of paths ends with an empty one
+	op := shFileOpStruct{wFunc: foDelete, pFrom: &from[0], fFlags: trashOperationFlags}
+	ret, _, _ := procSHFileOperationW.Call(uintptr(unsafe.Pointer(&op)))
+	err = nil
+	if ret != 0 {
+		err = fmt.Errorf("failed to move %s to the Recycle Bin: error %#x", absPath, ret)
+	} else if op.fAnyOperationsAborted != 0 {
+		err = fmt.Errorf("moving %s to the Recycle Bin was cancelled", absPath)
+	} else if _, statErr := os.Stat(absPath); statErr == nil {
+		err = fmt.Errorf("%s is still in place after moving it to the Recycle Bin", absPath)
+	}
+	publish(Record{Op: OpTrash, Src: absPath, Attempts: 1, Err: err})
+	return "", err
+}
+
+// ForgetTrashed does nothing on Windows, where picpurge does not know where
+// trashed files are.
+func ForgetTrashed(path string) {}
@@ -0,0 +1,203 @@
+// Package fixture generates synthetic photo libraries with known duplicates,
+// near-duplicates and broken files, for end-to-end tests and bug reports.
+package fixture
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nfnt/resize"
+)
+
+// Kind describes how a generated file relates to the originals.
+type Kind string
+
+const (
+	KindOriginal       Kind = "original"
+	KindExactDuplicate Kind = "exact_duplicate" // byte-for-byte copy in another folder
+	KindResized        Kind = "resized"         // re-encoded at 90% of the original's width and height
+	KindRotated        Kind = "rotated"         // turned by 180 degrees
+	KindRaw            Kind = "raw"             // RAW stand-in next to its JPEG, not decodable
+	KindCorrupt        Kind = "corrupt"         // truncated or empty image file
+)
+
+// ManifestName is the file Generate writes the manifest to, in the library root.
+const ManifestName = "manifest.json"
+
+// Entry is one generated file. Paths are relative to the library root and use forward slashes.
+type Entry struct {
+	Path string `json:"path"`
+	Kind Kind   `json:"kind"`
+	Of   string `json:"of,omitempty"` // the original this file was derived from
+}
+
+// Manifest lists everything Generate wrote.
+type Manifest struct {
+	Seed    int64   `json:"seed"`
+	Entries []Entry `json:"entries"`
+}
+
+// Options control the size and content of a generated library.
+type Options struct {
+	Originals int   // number of distinct photos; each gets one derived copy in turn
+	Seed      int64 // the same seed always produces the same files
+}
+
+// DefaultOptions covers every kind of derived file twice.
+var DefaultOptions = Options{Originals: 8, Seed: 1}
+
+const (
+	imageWidth  = 320
+	imageHeight = 240
+	jpegQuality = 90
+)
+
+// derivedKinds are assigned to originals in turn.
+var derivedKinds = []Kind{KindExactDuplicate, KindResized, KindRotated, KindRaw}
+
+// Generate writes a synthetic library into dir, which is created if needed, and
+// returns its manifest. Originals are named after their capture date the way
+// phones do, so date sorting has something to work with.
+func Generate(dir string, opts Options) (*Manifest, error) {
+	if opts.Originals < 1 {
+		return nil, fmt.Errorf("at least one original is required")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create library directory: %w", err)
+	}
+
+	rng := rand.New(rand.NewSource(opts.Seed))
+	manifest := &Manifest{Seed: opts.Seed}
+	add := func(kind Kind, of string, path string, data []byte) error {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", path, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, path), data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		manifest.Entries = append(manifest.Entries, Entry{Path: filepath.ToSlash(path), Kind: kind, Of: of})
+		return nil
+	}
+
+	taken := time.Date(2021, 1, 1, 9, 0, 0, 0, time.UTC)
+	var firstJPEG []byte
+	for i := 0; i < opts.Originals; i++ {
+		// Spread capture dates over a few years, so sorting creates several folders
+		taken = taken.Add(time.Duration(20+rng.Intn(60)) * 24 * time.Hour).Add(time.Duration(rng.Intn(3600)) * time.Second)
+		name := "IMG_" + taken.Format("20060102_150405")
+
+		img := randomPhoto(rng)
+		data, err := encodeJPEG(img)
+		if err != nil {
+			return nil, err
+		}
+		original := filepath.Join("originals", name+".jpg")
+		if err := add(KindOriginal, "", original, data); err != nil {
+			return nil, err
+		}
+		if firstJPEG == nil {
+			firstJPEG = data
+		}
+		of := filepath.ToSlash(original)
+
+		switch derivedKinds[i%len(derivedKinds)] {
+		case KindExactDuplicate:
+			err = add(KindExactDuplicate, of, filepath.Join("copies", name+".jpg"), data)
+		case KindResized:
+			small := resize.Resize(imageWidth*9/10, imageHeight*9/10, img, resize.Bilinear)
+			if data, err = encodeJPEG(small); err == nil {
+				err = add(KindResized, of, filepath.Join("resized", name+"_small.jpg"), data)
+			}
+		case KindRotated:
+			if data, err = encodeJPEG(rotate180(img)); err == nil {
+				err = add(KindRotated, of, filepath.Join("rotated", name+"_rotated.jpg"), data)
+			}
+		case KindRaw:
+			err = add(KindRaw, of, filepath.Join("originals", name+".dng"), rawStub(rng))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// A download cut short and a file that never got any content
+	if err := add(KindCorrupt, "", filepath.Join("corrupt", "truncated.jpg"), firstJPEG[:len(firstJPEG)/2]); err != nil {
+		return nil, err
+	}
+	if err := add(KindCorrupt, "", filepath.Join("corrupt", "empty.png"), nil); err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ManifestName), data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// Count returns how many entries of kind the manifest lists.
+func (m *Manifest) Count(kind Kind) int {
+	count := 0
+	for _, entry := range m.Entries {
+		if entry.Kind == kind {
+			count++
+		}
+	}
+	return count
+}
+
+// randomPhoto paints a coarse grid of random colours. Blocks of this size survive
+// resizing and re-encoding, so each photo keeps a distinct perceptual hash.
+func randomPhoto(rng *rand.Rand) image.Image {
+	const grid = 8
+	img := image.NewRGBA(image.Rect(0, 0, imageWidth, imageHeight))
+	colors := make([]color.RGBA, grid*grid)
+	for i := range colors {
+		colors[i] = color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255}
+	}
+	for y := 0; y < imageHeight; y++ {
+		for x := 0; x < imageWidth; x++ {
+			img.SetRGBA(x, y, colors[(y*grid/imageHeight)*grid+x*grid/imageWidth])
+		}
+	}
+	return img
+}
+
+func rotate180(img image.Image) image.Image {
+	bounds := img.Bounds()
+	rotated := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			rotated.Set(bounds.Max.X-1-(x-bounds.Min.X), bounds.Max.Y-1-(y-bounds.Min.Y), img.At(x, y))
+		}
+	}
+	return rotated
+}
+
+// rawStub returns a little-endian TIFF header followed by noise, which is how a
+// RAW file looks to a reader that does not understand the format.
+func rawStub(rng *rand.Rand) []byte {
+	data := make([]byte, 4096)
+	rng.Read(data)
+	copy(data, []byte{'I', 'I', 42, 0})
+	return data
+}
+
+func encodeJPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode JPEG: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,52 @@
+package fixture
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	dirA, dirB := t.TempDir(), t.TempDir()
+	manifest, err := Generate(dirA, Options{Originals: 5, Seed: 7})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	expected := map[Kind]int{KindOriginal: 5, KindExactDuplicate: 2, KindResized: 1, KindRotated: 1, KindRaw: 1, KindCorrupt: 2}
+	for kind, count := range expected {
+		if got := manifest.Count(kind); got != count {
+			t.Errorf("Expected %d %s files, got %d", count, kind, got)
+		}
+	}
+	for _, entry := range manifest.Entries {
+		if _, err := os.Stat(filepath.Join(dirA, entry.Path)); err != nil {
+			t.Errorf("Manifest lists %s, which was not written: %v", entry.Path, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dirA, ManifestName)); err != nil {
+		t.Errorf("Manifest file was not written: %v", err)
+	}
+
+	// The same seed reproduces the library byte for byte
+	again, err := Generate(dirB, Options{Originals: 5, Seed: 7})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !reflect.DeepEqual(manifest, again) {
+		t.Fatalf("Manifests differ for the same seed")
+	}
+	for _, entry := range manifest.Entries {
+		a, _ := os.ReadFile(filepath.Join(dirA, entry.Path))
+		b, _ := os.ReadFile(filepath.Join(dirB, entry.Path))
+		if !bytes.Equal(a, b) {
+			t.Errorf("%s differs for the same seed", entry.Path)
+		}
+	}
+
+	if _, err := Generate(t.TempDir(), Options{}); err == nil {
+		t.Errorf("Expected an error without originals")
+	}
+}
@@ -0,0 +1,85 @@
+package grouping
+
+import (
+	"sort"
+	"time"
+)
+
+// Shot is the metadata needed to detect sequences of consecutive exposures.
+type Shot struct {
+	ID           int
+	Camera       string // make, model and serial; shots from different cameras never group
+	CreateDate   time.Time
+	ExposureBias *float64 // EV compensation, nil when unknown
+	Width        int
+	Height       int
+	Edges        *Edges // hashes of the outer strips, nil when unknown
+}
+
+// MaxBracketGap is the longest pause between two frames of one bracket set.
+const MaxBracketGap = 2 * time.Second
+
+// FindBracketSets returns exposure bracket sets: runs of at least two shots from
+// the same camera, taken at most MaxBracketGap apart, with identical dimensions
+// and at least two different exposure bias values. Each set lists shot IDs in
+// capture order.
+func FindBracketSets(shots []Shot) [][]int {
+	var candidates []Shot
+	for _, s := range shots {
+		if s.ExposureBias != nil && s.Camera != "" && !s.CreateDate.IsZero() {
+			candidates = append(candidates, s)
+		}
+	}
+	sortByCameraAndTime(candidates)
+
+	var sets [][]int
+	var run []Shot
+	flush := func() {
+		if len(run) >= 2 && distinctBiases(run) >= 2 {
+			sets = append(sets, shotIDs(run))
+		}
+		run = nil
+	}
+
+	for _, s := range candidates {
+		if len(run) > 0 {
+			prev := run[len(run)-1]
+			if prev.Camera != s.Camera || s.CreateDate.Sub(prev.CreateDate) > MaxBracketGap ||
+				prev.Width != s.Width || prev.Height != s.Height {
+				flush()
+			}
+		}
+		run = append(run, s)
+	}
+	flush()
+	return sets
+}
+
+func distinctBiases(run []Shot) int {
+	seen := make(map[float64]bool)
+	for _, s := range run {
+		seen[*s.ExposureBias] = true
+	}
+	return len(seen)
+}
+
+// sortByCameraAndTime orders shots by camera, then capture time, then ID.
+func sortByCameraAndTime(shots []Shot) {
+	sort.SliceStable(shots, func(i, j int) bool {
+		if shots[i].Camera != shots[j].Camera {
+			return shots[i].Camera < shots[j].Camera
+		}
+		if !shots[i].CreateDate.Equal(shots[j].CreateDate) {
+			return shots[i].CreateDate.Before(shots[j].CreateDate)
+		}
+		return shots[i].ID < shots[j].ID
+	})
+}
+
+func shotIDs(run []Shot) []int {
+	ids := make([]int, len(run))
+	for i, s := range run {
+		ids[i] = s.ID
+	}
+	return ids
+}
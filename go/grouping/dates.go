@@ -0,0 +1,130 @@
+package grouping
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DatedFile is an image's place in its folder and what is known about its date.
+type DatedFile struct {
+	ID    int
+	Dir   string
+	Name  string
+	Date  time.Time
+	Known bool // the date came from EXIF or the file name
+}
+
+// EstimateDates guesses dates for files whose date is not known from the files
+// next to them. Files are ordered by name within their folder, the way cameras
+// number them, preferring neighbours with the same name prefix (e.g. IMG_ vs DSC_).
+// A file between two dated neighbours gets the midpoint of their dates, a file at
+// either end gets the date of the nearest dated one. Files with no dated
+// neighbour in their folder are left out of the result.
+func EstimateDates(files []DatedFile) map[int]time.Time {
+	byDir := make(map[string][]DatedFile)
+	for _, f := range files {
+		byDir[f.Dir] = append(byDir[f.Dir], f)
+	}
+
+	estimates := make(map[int]time.Time)
+	for _, dirFiles := range byDir {
+		sort.Slice(dirFiles, func(i, j int) bool { return naturalLess(dirFiles[i].Name, dirFiles[j].Name) })
+
+		bySeries := make(map[string][]DatedFile)
+		for _, f := range dirFiles {
+			prefix := seriesPrefix(f.Name)
+			bySeries[prefix] = append(bySeries[prefix], f)
+		}
+		for _, f := range dirFiles {
+			if f.Known {
+				continue
+			}
+			if date, ok := estimateFrom(bySeries[seriesPrefix(f.Name)], f.ID); ok {
+				estimates[f.ID] = date
+			} else if date, ok := estimateFrom(dirFiles, f.ID); ok {
+				estimates[f.ID] = date
+			}
+		}
+	}
+	return estimates
+}
+
+// estimateFrom dates the file with id from the nearest dated files before and after it in sequence.
+func estimateFrom(sequence []DatedFile, id int) (time.Time, bool) {
+	pos := -1
+	for i, f := range sequence {
+		if f.ID == id {
+			pos = i
+			break
+		}
+	}
+	var before, after *DatedFile
+	for i := pos - 1; i >= 0; i-- {
+		if sequence[i].Known {
+			before = &sequence[i]
+			break
+		}
+	}
+	for i := pos + 1; i < len(sequence); i++ {
+		if sequence[i].Known {
+			after = &sequence[i]
+			break
+		}
+	}
+
+	switch {
+	case before != nil && after != nil && !after.Date.Before(before.Date):
+		return before.Date.Add(after.Date.Sub(before.Date) / 2), true
+	case before != nil:
+		return before.Date, true
+	case after != nil:
+		return after.Date, true
+	}
+	return time.Time{}, false
+}
+
+// seriesPrefix returns the letters before the first digit of a file name, which
+// identify the camera's numbering series.
+func seriesPrefix(name string) string {
+	if i := strings.IndexFunc(name, unicode.IsDigit); i >= 0 {
+		return strings.ToUpper(name[:i])
+	}
+	return strings.ToUpper(name)
+}
+
+// naturalLess compares file names treating runs of digits as numbers, so IMG_9
+// sorts before IMG_10.
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		ca, cb := chunk(a), chunk(b)
+		if ca != cb {
+			da, db := unicode.IsDigit(rune(ca[0])), unicode.IsDigit(rune(cb[0]))
+			if da && db {
+				na, nb := strings.TrimLeft(ca, "0"), strings.TrimLeft(cb, "0")
+				if len(na) != len(nb) {
+					return len(na) < len(nb)
+				}
+				if na != nb {
+					return na < nb
+				}
+			} else {
+				return ca < cb
+			}
+		}
+		a, b = a[len(ca):], b[len(cb):]
+	}
+	return len(a) < len(b)
+}
+
+// chunk returns the leading run of digits or non-digits of s.
+func chunk(s string) string {
+	digit := unicode.IsDigit(rune(s[0]))
+	for i, r := range s {
+		if unicode.IsDigit(r) != digit {
+			return s[:i]
+		}
+	}
+	return s
+}
@@ -0,0 +1,212 @@
+package grouping
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func ev(v float64) *float64 {
+	return &v
+}
+
+func TestFindBracketSets(t *testing.T) {
+	base := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	shots := []Shot{
+		{ID: 1, Camera: "A", CreateDate: base, ExposureBias: ev(-2), Width: 10, Height: 10},
+		{ID: 2, Camera: "A", CreateDate: base.Add(time.Second), ExposureBias: ev(0), Width: 10, Height: 10},
+		{ID: 3, Camera: "A", CreateDate: base.Add(2 * time.Second), ExposureBias: ev(2), Width: 10, Height: 10},
+		// Same exposure: a burst, not a bracket
+		{ID: 4, Camera: "A", CreateDate: base.Add(time.Minute), ExposureBias: ev(0), Width: 10, Height: 10},
+		{ID: 5, Camera: "A", CreateDate: base.Add(time.Minute + time.Second), ExposureBias: ev(0), Width: 10, Height: 10},
+		// Different camera at the same time
+		{ID: 6, Camera: "B", CreateDate: base, ExposureBias: ev(1), Width: 10, Height: 10},
+		// No exposure information
+		{ID: 7, Camera: "A", CreateDate: base.Add(3 * time.Second), Width: 10, Height: 10},
+	}
+
+	sets := FindBracketSets(shots)
+	if len(sets) != 1 {
+		t.Fatalf("FindBracketSets returned %d sets; expected 1: %v", len(sets), sets)
+	}
+	if len(sets[0]) != 3 || sets[0][0] != 1 || sets[0][2] != 3 {
+		t.Errorf("Unexpected bracket set: %v", sets[0])
+	}
+}
+
+func TestFindPanoramaSequences(t *testing.T) {
+	base := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	const (
+		sky   uint64 = 0x00000000ffffffff
+		hill  uint64 = 0x0f0f0f0f0f0f0f0f
+		tree  uint64 = 0x3333333333333333
+		river uint64 = 0x5555555555555555
+	)
+	shots := []Shot{
+		// Left-to-right sweep: each right strip reappears on the left of the next frame
+		{ID: 1, Camera: "A", CreateDate: base, Edges: &Edges{Left: sky, Right: hill}, Width: 10, Height: 20},
+		{ID: 2, Camera: "A", CreateDate: base.Add(3 * time.Second), Edges: &Edges{Left: hill ^ 0x3, Right: tree}, Width: 10, Height: 20},
+		{ID: 3, Camera: "A", CreateDate: base.Add(6 * time.Second), Edges: &Edges{Left: tree, Right: river}, Width: 10, Height: 20},
+		// Too late to continue the sweep
+		{ID: 4, Camera: "A", CreateDate: base.Add(time.Minute), Edges: &Edges{Left: river, Right: sky}, Width: 10, Height: 20},
+		// Unrelated shots from another camera
+		{ID: 5, Camera: "B", CreateDate: base, Edges: &Edges{Left: sky, Right: hill}, Width: 10, Height: 20},
+		{ID: 6, Camera: "B", CreateDate: base.Add(time.Second), Edges: &Edges{Left: river, Right: tree}, Width: 10, Height: 20},
+	}
+
+	sequences := FindPanoramaSequences(shots)
+	if len(sequences) != 1 {
+		t.Fatalf("FindPanoramaSequences returned %d sequences; expected 1: %v", len(sequences), sequences)
+	}
+	if len(sequences[0]) != 3 || sequences[0][0] != 1 || sequences[0][2] != 3 {
+		t.Errorf("Unexpected panorama sequence: %v", sequences[0])
+	}
+}
+
+func TestClusterThreshold(t *testing.T) {
+	expected := map[int]int{2: 3, 3: 2, 4: 2, 5: 1, 20: 1}
+	for size, limit := range expected {
+		if got := ClusterThreshold(3, size); got != limit {
+			t.Errorf("ClusterThreshold(3, %d) = %d; expected %d", size, got, limit)
+		}
+	}
+}
+
+func TestClusterSimilar(t *testing.T) {
+	pairs := []Pair{
+		// A chain 1-2-3-4 where the ends are not similar must not become one group
+		{A: 1, B: 2, Distance: 1},
+		{A: 2, B: 3, Distance: 3},
+		{A: 3, B: 4, Distance: 1},
+		// A tight burst of five frames
+		{A: 10, B: 11, Distance: 0}, {A: 10, B: 12, Distance: 1}, {A: 10, B: 13, Distance: 1}, {A: 10, B: 14, Distance: 0},
+		{A: 11, B: 12, Distance: 1}, {A: 11, B: 13, Distance: 0}, {A: 11, B: 14, Distance: 1},
+		{A: 12, B: 13, Distance: 1}, {A: 12, B: 14, Distance: 1},
+		{A: 13, B: 14, Distance: 0},
+		// A loose trio only stays a pair under the stricter three-member limit
+		{A: 20, B: 21, Distance: 2}, {A: 20, B: 22, Distance: 3}, {A: 21, B: 22, Distance: 3},
+	}
+
+	groups := ClusterSimilar(pairs, 3)
+	expected := [][]int{{1, 2}, {3, 4}, {10, 11, 12, 13, 14}, {20, 21}}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("ClusterSimilar = %v; expected %v", groups, expected)
+	}
+}
+
+func TestApplyCorrections(t *testing.T) {
+	groups := [][]int{{1, 2}, {3, 4}, {10, 11, 12, 13}}
+	corrections := []Correction{
+		// Two groups the algorithm kept apart, and an image it missed
+		{ID: 1, Other: 3}, {ID: 5, Other: 4},
+		// Two frames of the burst split out; they stay together
+		{ID: 12, Other: 10, Apart: true}, {ID: 13, Other: 11, Apart: true},
+	}
+	expected := [][]int{{1, 2, 3, 4, 5}, {10, 11}, {12, 13}}
+	if got := ApplyCorrections(groups, corrections); !reflect.DeepEqual(got, expected) {
+		t.Errorf("ApplyCorrections = %v; expected %v", got, expected)
+	}
+
+	// A pair split in two leaves no group
+	if got := ApplyCorrections([][]int{{7, 8}}, []Correction{{ID: 8, Other: 7, Apart: true}}); len(got) != 0 {
+		t.Errorf("ApplyCorrections of a split pair = %v; expected no groups", got)
+	}
+}
+
+func TestEstimateDates(t *testing.T) {
+	day := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	files := []DatedFile{
+		{ID: 1, Dir: "/a/", Name: "IMG_9.jpg", Date: day, Known: true},
+		{ID: 2, Dir: "/a/", Name: "IMG_10.jpg"},
+		{ID: 3, Dir: "/a/", Name: "IMG_11.jpg", Date: day.Add(2 * time.Hour), Known: true},
+		{ID: 4, Dir: "/a/", Name: "IMG_12.jpg"},
+		// Another camera's series in the same folder keeps to its own neighbours
+		{ID: 5, Dir: "/a/", Name: "DSC_0001.jpg", Date: day.AddDate(0, 0, 7), Known: true},
+		{ID: 6, Dir: "/a/", Name: "DSC_0002.jpg"},
+		// Falls back to the folder when its own series has no dates
+		{ID: 7, Dir: "/a/", Name: "scan.jpg"},
+		// Nothing dated in the folder
+		{ID: 8, Dir: "/b/", Name: "IMG_1.jpg"},
+	}
+
+	estimates := EstimateDates(files)
+	expected := map[int]time.Time{
+		2: day.Add(time.Hour),
+		4: day.Add(2 * time.Hour),
+		6: day.AddDate(0, 0, 7),
+		7: day.Add(2 * time.Hour),
+	}
+	if !reflect.DeepEqual(estimates, expected) {
+		t.Errorf("EstimateDates = %v; expected %v", estimates, expected)
+	}
+}
+
+func TestKeepPolicy(t *testing.T) {
+	day := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	candidates := []KeepCandidate{
+		{ID: 1, Path: "/inbox/a.jpg", Width: 4000, Height: 3000, Date: day},
+		{ID: 2, Path: "/masters/a.cr2", Width: 4000, Height: 3000, Raw: true},
+		{ID: 3, Path: "/phone/a.jpg", Width: 6000, Height: 4000, Date: day.Add(-time.Hour)},
+		{ID: 4, Path: "/masters-old/a.jpg", Width: 4000, Height: 3000, Date: day.Add(-2 * time.Hour)},
+	}
+	testCases := []struct {
+		rules    string
+		paths    []string
+		expected []int
+	}{
+		{"", nil, []int{1, 2, 3, 4}},
+		{"raw", nil, []int{2, 1, 3, 4}},
+		{"resolution", nil, []int{3, 1, 2, 4}},
+		{"earliest", nil, []int{4, 3, 1, 2}},
+		{"resolution, earliest", nil, []int{3, 4, 1, 2}},
+		// Priority folders without the path rule come first; /masters-old is not under /masters
+		{"earliest", []string{"/masters", "/inbox"}, []int{2, 1, 4, 3}},
+	}
+	for _, tc := range testCases {
+		policy, err := ParseKeepPolicy(tc.rules, tc.paths)
+		if err != nil {
+			t.Fatalf("ParseKeepPolicy(%q) failed: %v", tc.rules, err)
+		}
+		sorted := append([]KeepCandidate(nil), candidates...)
+		policy.Sort(sorted)
+		var ids []int
+		for _, c := range sorted {
+			ids = append(ids, c.ID)
+		}
+		if !reflect.DeepEqual(ids, tc.expected) {
+			t.Errorf("policy %q with %v sorted %v; expected %v", tc.rules, tc.paths, ids, tc.expected)
+		}
+	}
+
+	// Two files of one exposure: a RAW and the smaller JPEG the camera or an app made of it
+	raw := KeepCandidate{ID: 5, Path: "/masters/b.cr2", Width: 4000, Height: 3000, Raw: true, Size: 25 << 20}
+	jpeg := KeepCandidate{ID: 6, Path: "/phone/b.jpg", Width: 6000, Height: 4000, Size: 4 << 20}
+	resized := KeepCandidate{ID: 7, Path: "/phone/b-small.jpg", Width: 6000, Height: 4000, Size: 1 << 20}
+	shotCases := []struct {
+		rules    string
+		a, b     KeepCandidate
+		original int
+	}{
+		{"", raw, jpeg, 6},         // more pixels
+		{"", resized, jpeg, 6},     // as many pixels in a larger file
+		{"raw", jpeg, raw, 5},      // the policy decides first
+		{"earliest", jpeg, raw, 6}, // no rule tells them apart
+	}
+	for _, tc := range shotCases {
+		policy, err := ParseKeepPolicy(tc.rules, nil)
+		if err != nil {
+			t.Fatalf("ParseKeepPolicy(%q) failed: %v", tc.rules, err)
+		}
+		original, copied := policy.ShotOriginal(tc.a, tc.b)
+		if original.ID != tc.original || copied.ID == tc.original {
+			t.Errorf("policy %q: ShotOriginal(%d, %d) = %d, %d; expected %d as the original", tc.rules, tc.a.ID, tc.b.ID, original.ID, copied.ID, tc.original)
+		}
+	}
+
+	if _, err := ParseKeepPolicy("newest", nil); err == nil {
+		t.Error("ParseKeepPolicy accepted an unknown rule")
+	}
+	if _, err := ParseKeepPolicy("path", nil); err == nil {
+		t.Error("ParseKeepPolicy accepted the path rule without priority folders")
+	}
+}
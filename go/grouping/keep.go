@@ -0,0 +1,143 @@
+package grouping
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Keep rules, in the words of the --keep-policy flag.
+const (
+	KeepRAW        = "raw"        // camera RAW files before JPEGs and other formats
+	KeepResolution = "resolution" // the most pixels first
+	KeepEarliest   = "earliest"   // the earliest EXIF date first, files without one last
+	KeepPath       = "path"       // files under the priority folders first, in the order given
+)
+
+// KeepCandidate is one copy of a picture that a KeepPolicy ranks.
+type KeepCandidate struct {
+	ID     int
+	Path   string
+	Width  int
+	Height int
+	Date   time.Time // zero when unknown
+	Raw    bool
+	Size   int64 // of the file in bytes
+}
+
+// KeepPolicy ranks the copies of a picture for keeping by a list of rules. The
+// first rule that tells two copies apart decides; copies no rule tells apart
+// keep the order they came in.
+type KeepPolicy struct {
+	rules []string
+	paths []string // priority folders of the path rule, absolute
+}
+
+// ParseKeepPolicy reads a comma-separated list of rules, e.g. "raw,resolution",
+// and the priority folders of the path rule. Priority folders without the path
+// rule put it first.
+func ParseKeepPolicy(rules string, priorityPaths []string) (KeepPolicy, error) {
+	var p KeepPolicy
+	seen := make(map[string]bool)
+	for _, rule := range strings.Split(rules, ",") {
+		rule = strings.ToLower(strings.TrimSpace(rule))
+		if rule == "" {
+			continue
+		}
+		switch rule {
+		case KeepRAW, KeepResolution, KeepEarliest, KeepPath:
+		default:
+			return KeepPolicy{}, fmt.Errorf("unknown keep rule %q; use %s, %s, %s or %s", rule, KeepRAW, KeepResolution, KeepEarliest, KeepPath)
+		}
+		if !seen[rule] {
+			seen[rule] = true
+			p.rules = append(p.rules, rule)
+		}
+	}
+	for _, path := range priorityPaths {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return KeepPolicy{}, fmt.Errorf("invalid priority path %s: %w", path, err)
+		}
+		p.paths = append(p.paths, absPath)
+	}
+	if seen[KeepPath] && len(p.paths) == 0 {
+		return KeepPolicy{}, fmt.Errorf("the %s rule needs priority folders", KeepPath)
+	}
+	if len(p.paths) > 0 && !seen[KeepPath] {
+		p.rules = append([]string{KeepPath}, p.rules...)
+	}
+	return p, nil
+}
+
+// Empty reports whether the policy has no rules, leaving copies in the order
+// they came in.
+func (p KeepPolicy) Empty() bool {
+	return len(p.rules) == 0
+}
+
+// String lists the rules of the policy.
+func (p KeepPolicy) String() string {
+	return strings.Join(p.rules, ",")
+}
+
+// Sort orders candidates best first: the copy to keep comes first.
+func (p KeepPolicy) Sort(candidates []KeepCandidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		for _, rule := range p.rules {
+			switch rule {
+			case KeepRAW:
+				if a.Raw != b.Raw {
+					return a.Raw
+				}
+			case KeepResolution:
+				if areaA, areaB := a.Width*a.Height, b.Width*b.Height; areaA != areaB {
+					return areaA > areaB
+				}
+			case KeepEarliest:
+				if !a.Date.Equal(b.Date) {
+					if a.Date.IsZero() || b.Date.IsZero() {
+						return b.Date.IsZero()
+					}
+					return a.Date.Before(b.Date)
+				}
+			case KeepPath:
+				if rankA, rankB := p.pathRank(a.Path), p.pathRank(b.Path); rankA != rankB {
+					return rankA < rankB
+				}
+			}
+		}
+		return false
+	})
+}
+
+// ShotOriginal tells which of two files of one exposure, as their camera
+// serial number and shutter count show, is the original and which a copy or
+// re-encode of it. The rules of the policy decide first, then the file with
+// more pixels and then the larger file, as re-encoding loses detail; a is the
+// original of files nothing tells apart.
+func (p KeepPolicy) ShotOriginal(a, b KeepCandidate) (original, copied KeepCandidate) {
+	if areaA, areaB := a.Width*a.Height, b.Width*b.Height; areaB > areaA || (areaB == areaA && b.Size > a.Size) {
+		a, b = b, a
+	}
+	candidates := []KeepCandidate{a, b}
+	p.Sort(candidates)
+	return candidates[0], candidates[1]
+}
+
+// pathRank returns the index of the first priority folder holding path, or
+// the number of folders if none does.
+func (p KeepPolicy) pathRank(path string) int {
+	for i, dir := range p.paths {
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return i
+		}
+	}
+	return len(p.paths)
+}
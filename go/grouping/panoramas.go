@@ -0,0 +1,87 @@
+package grouping
+
+import (
+	"math/bits"
+	"time"
+)
+
+// Edges holds 64-bit perceptual hashes of the left and right strips of an image.
+type Edges struct {
+	Left  uint64
+	Right uint64
+}
+
+// MaxPanoramaGap is the longest pause between two frames of one panorama sweep.
+const MaxPanoramaGap = 10 * time.Second
+
+// EdgeMatchThreshold is the largest Hamming distance at which two strip hashes
+// are considered to show the same overlapping part of a scene.
+const EdgeMatchThreshold = 10
+
+// Sweep directions of a panorama.
+const (
+	sweepUnknown = iota
+	sweepRight   // each frame's right strip reappears as the next frame's left strip
+	sweepLeft    // each frame's left strip reappears as the next frame's right strip
+)
+
+// FindPanoramaSequences returns panorama sweeps: runs of at least two shots from
+// the same camera, taken at most MaxPanoramaGap apart, with identical dimensions,
+// where every frame overlaps the next one at the edge facing the sweep direction.
+// Each sequence lists shot IDs in capture order.
+func FindPanoramaSequences(shots []Shot) [][]int {
+	var candidates []Shot
+	for _, s := range shots {
+		if s.Edges != nil && s.Camera != "" && !s.CreateDate.IsZero() {
+			candidates = append(candidates, s)
+		}
+	}
+	sortByCameraAndTime(candidates)
+
+	var sequences [][]int
+	var run []Shot
+	direction := sweepUnknown
+	flush := func() {
+		if len(run) >= 2 {
+			sequences = append(sequences, shotIDs(run))
+		}
+		run = nil
+		direction = sweepUnknown
+	}
+
+	for _, s := range candidates {
+		if len(run) > 0 {
+			prev := run[len(run)-1]
+			next := sweepUnknown
+			if prev.Camera == s.Camera && s.CreateDate.Sub(prev.CreateDate) <= MaxPanoramaGap &&
+				prev.Width == s.Width && prev.Height == s.Height {
+				next = sweepBetween(*prev.Edges, *s.Edges, direction)
+			}
+			if next == sweepUnknown {
+				// Not a continuation; the current shot may still start a new sweep.
+				flush()
+			} else {
+				direction = next
+			}
+		}
+		run = append(run, s)
+	}
+	flush()
+	return sequences
+}
+
+// sweepBetween returns the direction in which b continues a from a sweep, or
+// sweepUnknown when their edges do not overlap. A known direction is kept.
+func sweepBetween(a, b Edges, direction int) int {
+	if direction != sweepLeft && hammingDistance(a.Right, b.Left) <= EdgeMatchThreshold {
+		return sweepRight
+	}
+	if direction != sweepRight && hammingDistance(a.Left, b.Right) <= EdgeMatchThreshold {
+		return sweepLeft
+	}
+	return sweepUnknown
+}
+
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
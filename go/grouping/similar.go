@@ -0,0 +1,217 @@
+package grouping
+
+import "sort"
+
+// Pair is two images whose perceptual hashes are within the base threshold.
+type Pair struct {
+	A, B     int
+	Distance int
+}
+
+// MinClusterThreshold is the tightest distance ClusterThreshold ever requires.
+const MinClusterThreshold = 1
+
+// ClusterThreshold returns the largest distance allowed between any two members
+// of a group of size images. Pairs use base; the limit drops by one each time the
+// group size doubles beyond that, so burst sequences only group when they are
+// nearly identical.
+func ClusterThreshold(base, size int) int {
+	limit := base
+	for n := 2; n < size && limit > MinClusterThreshold; n *= 2 {
+		limit--
+	}
+	return limit
+}
+
+type cluster struct {
+	members  []int
+	diameter int // largest distance between two members
+}
+
+// ClusterSimilar groups the images of pairs so that every two members of a group
+// are themselves a pair within ClusterThreshold(base, group size). Closest pairs
+// merge first. Because a missing pair keeps two groups apart, a chain of slightly
+// different images cannot grow into one large cluster. Groups list IDs in
+// ascending order and are sorted by their first ID.
+func ClusterSimilar(pairs []Pair, base int) [][]int {
+	type key struct{ a, b int }
+	keyOf := func(a, b int) key {
+		if a > b {
+			a, b = b, a
+		}
+		return key{a, b}
+	}
+	distances := make(map[key]int, len(pairs))
+	for _, p := range pairs {
+		distances[keyOf(p.A, p.B)] = p.Distance
+	}
+
+	sorted := append([]Pair(nil), pairs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Distance != sorted[j].Distance {
+			return sorted[i].Distance < sorted[j].Distance
+		}
+		if sorted[i].A != sorted[j].A {
+			return sorted[i].A < sorted[j].A
+		}
+		return sorted[i].B < sorted[j].B
+	})
+
+	clusters := make(map[int]*cluster)
+	clusterOf := func(id int) *cluster {
+		if c := clusters[id]; c != nil {
+			return c
+		}
+		c := &cluster{members: []int{id}}
+		clusters[id] = c
+		return c
+	}
+
+	for _, p := range sorted {
+		a, b := clusterOf(p.A), clusterOf(p.B)
+		if a == b {
+			continue
+		}
+		limit := ClusterThreshold(base, len(a.members)+len(b.members))
+		diameter := a.diameter
+		if b.diameter > diameter {
+			diameter = b.diameter
+		}
+		joinable := diameter <= limit
+		for _, x := range a.members {
+			for _, y := range b.members {
+				d, ok := distances[keyOf(x, y)]
+				if !ok || d > limit {
+					joinable = false
+					break
+				}
+				if d > diameter {
+					diameter = d
+				}
+			}
+			if !joinable {
+				break
+			}
+		}
+		if !joinable {
+			continue
+		}
+		a.members = append(a.members, b.members...)
+		a.diameter = diameter
+		for _, id := range b.members {
+			clusters[id] = a
+		}
+	}
+
+	seen := make(map[*cluster]bool)
+	var groups [][]int
+	for _, c := range clusters {
+		if seen[c] || len(c.members) < 2 {
+			continue
+		}
+		seen[c] = true
+		group := append([]int(nil), c.members...)
+		sort.Ints(group)
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+	return groups
+}
+
+// Correction is a manual decision about two images that overrides the
+// similarity grouping.
+type Correction struct {
+	ID, Other int
+	Apart     bool // ID was split out of the group of Other; otherwise they were merged
+}
+
+// ApplyCorrections adjusts similar groups to manual corrections. Merged images
+// end up in one group, with the groups they were in. An image split from
+// another leaves the group they share; the images split out of one group form
+// a group of their own. Groups are returned as by ClusterSimilar.
+func ApplyCorrections(groups [][]int, corrections []Correction) [][]int {
+	parent := make(map[int]int)
+	var find func(id int) int
+	find = func(id int) int {
+		p, ok := parent[id]
+		if !ok || p == id {
+			return id
+		}
+		root := find(p)
+		parent[id] = root
+		return root
+	}
+	union := func(a, b int) {
+		for _, id := range []int{a, b} {
+			if _, ok := parent[id]; !ok {
+				parent[id] = id
+			}
+		}
+		if ra, rb := find(a), find(b); ra != rb {
+			parent[rb] = ra
+		}
+	}
+	for _, group := range groups {
+		for _, id := range group[1:] {
+			union(group[0], id)
+		}
+	}
+	apart := make(map[int][]int) // images each image was split from
+	for _, c := range corrections {
+		if c.Apart {
+			apart[c.ID] = append(apart[c.ID], c.Other)
+		} else {
+			union(c.ID, c.Other)
+		}
+	}
+
+	members := make(map[int][]int)
+	for id := range parent {
+		root := find(id)
+		members[root] = append(members[root], id)
+	}
+	var result [][]int
+	for _, group := range members {
+		result = append(result, splitApart(group, apart)...)
+	}
+	for _, group := range result {
+		sort.Ints(group)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i][0] < result[j][0] })
+	return result
+}
+
+// splitApart takes the images split from another member out of group and
+// splits them in turn. Only groups of two or more are returned.
+func splitApart(group []int, apart map[int][]int) [][]int {
+	inGroup := make(map[int]bool, len(group))
+	for _, id := range group {
+		inGroup[id] = true
+	}
+	var kept, out []int
+	for _, id := range group {
+		leaves := false
+		for _, other := range apart[id] {
+			if inGroup[other] {
+				leaves = true
+				break
+			}
+		}
+		if leaves {
+			out = append(out, id)
+		} else {
+			kept = append(kept, id)
+		}
+	}
+	if len(kept) == 0 {
+		return nil // every member was split from another; none stay together
+	}
+	var groups [][]int
+	if len(kept) > 1 {
+		groups = append(groups, kept)
+	}
+	if len(out) > 1 {
+		groups = append(groups, splitApart(out, apart)...)
+	}
+	return groups
+}
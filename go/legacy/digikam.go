@@ -0,0 +1,178 @@
+package legacy
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// digiKamInternalTags is the root of the tags digiKam uses for its own bookkeeping.
+const digiKamInternalTags = "_Digikam_Internal_Tags_"
+
+// digiKamVisible is the Images.status of pictures that are still in the collection.
+const digiKamVisible = 1
+
+// ReadDigiKamDB reads ratings and tags from a digiKam database (digikam4.db).
+// Collections on removable or network drives are matched by their path below
+// the drive, since where the drive is mounted now is unknown.
+func ReadDigiKamDB(dbPath string) ([]Record, error) {
+	// sql.Open would create a missing file
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, fmt.Errorf("failed to open digiKam database: %w", err)
+	}
+	db, err := sql.Open(sqliteDriver, "file:"+dbPath+"?mode=ro")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open digiKam database: %w", err)
+	}
+	defer db.Close()
+
+	tagPaths, people, err := readDigiKamTags(db)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT Images.id, Images.name, Albums.relativePath, AlbumRoots.identifier, AlbumRoots.specificPath,
+			COALESCE(ImageInformation.rating, 0)
+		FROM Images
+		JOIN Albums ON Images.album = Albums.id
+		JOIN AlbumRoots ON Albums.albumRoot = AlbumRoots.id
+		LEFT JOIN ImageInformation ON ImageInformation.imageid = Images.id
+		WHERE Images.status = ?
+	`, digiKamVisible)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query digiKam images: %w", err)
+	}
+	records := make(map[int]*Record)
+	var order []int
+	for rows.Next() {
+		var id, rating int
+		var name, relativePath, identifier, specificPath string
+		if err := rows.Scan(&id, &name, &relativePath, &identifier, &specificPath, &rating); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read digiKam image: %w", err)
+		}
+		record := &Record{}
+		if root := digiKamMountPath(identifier); root != "" {
+			record.Path = filepath.Join(root, filepath.FromSlash(path.Join(specificPath, relativePath, name)))
+		} else {
+			record.Path = filepath.FromSlash(path.Join(specificPath, relativePath, name))
+			record.Suffix = true
+		}
+		if rating >= 1 && rating <= 5 {
+			record.Rating = rating
+		}
+		records[id] = record
+		order = append(order, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read digiKam images: %w", err)
+	}
+
+	rows, err = db.Query("SELECT imageid, tagid FROM ImageTags")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query digiKam tags: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var imageID, tagID int
+		if err := rows.Scan(&imageID, &tagID); err != nil {
+			return nil, fmt.Errorf("failed to read digiKam tag: %w", err)
+		}
+		record, tagPath := records[imageID], tagPaths[tagID]
+		if record == nil || tagPath == "" {
+			continue
+		}
+		leaf := tagPath[strings.LastIndex(tagPath, "/")+1:]
+		if people[tagID] {
+			record.Faces = appendUnique(record.Faces, leaf)
+		} else {
+			record.Keywords = appendUnique(record.Keywords, leaf)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read digiKam tags: %w", err)
+	}
+
+	var result []Record
+	for _, id := range order {
+		if !records[id].Empty() {
+			result = append(result, *records[id])
+		}
+	}
+	return result, nil
+}
+
+// readDigiKamTags returns the full path of every user tag, and which tags name
+// people: those marked as persons or filed under People.
+func readDigiKamTags(db *sql.DB) (map[int]string, map[int]bool, error) {
+	rows, err := db.Query("SELECT id, pid, name FROM Tags")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query digiKam tag tree: %w", err)
+	}
+	parents := make(map[int]int)
+	names := make(map[int]string)
+	for rows.Next() {
+		var id, pid int
+		var name string
+		if err := rows.Scan(&id, &pid, &name); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("failed to read digiKam tag tree: %w", err)
+		}
+		parents[id], names[id] = pid, name
+	}
+	rows.Close()
+
+	people := make(map[int]bool)
+	// Older databases have no TagProperties
+	if rows, err := db.Query("SELECT tagid FROM TagProperties WHERE property = 'person'"); err == nil {
+		for rows.Next() {
+			var id int
+			if rows.Scan(&id) == nil {
+				people[id] = true
+			}
+		}
+		rows.Close()
+	}
+
+	paths := make(map[int]string)
+	for id := range names {
+		var parts []string
+		// The depth limit guards against a damaged tree with cycles
+		for current := id; current != 0 && len(parts) < 64; current = parents[current] {
+			parts = append([]string{names[current]}, parts...)
+		}
+		tagPath := strings.Join(parts, "/")
+		if parts[0] == digiKamInternalTags {
+			continue
+		}
+		paths[id] = tagPath
+		if strings.HasPrefix(tagPath, digiKamPeopleTag) {
+			people[id] = true
+		}
+	}
+	return paths, people, nil
+}
+
+// digiKamMountPath returns the folder a collection root identifier points to,
+// such as volumeid:?path=%2Fhome%2Fme%2FPictures, or "" for roots identified by
+// a volume UUID or label.
+func digiKamMountPath(identifier string) string {
+	_, query, ok := strings.Cut(identifier, "?")
+	if !ok {
+		return ""
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return ""
+	}
+	if p := values.Get("path"); p != "" {
+		return p
+	}
+	return values.Get("mountpath")
+}
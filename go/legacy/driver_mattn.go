@@ -0,0 +1,8 @@
+//go:build !purego
+
+package legacy
+
+import _ "github.com/mattn/go-sqlite3"
+
+// sqliteDriver is the database/sql driver that opens other applications' SQLite databases.
+const sqliteDriver = "sqlite3"
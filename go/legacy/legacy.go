@@ -0,0 +1,94 @@
+// Package legacy reads ratings, albums and people tags left behind by Picasa and
+// digiKam, so curation done in those tools carries over to picpurge.
+package legacy
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Curation is what an older tool recorded about one file.
+type Curation struct {
+	Rating   int // 1 to 5 stars, 0 when unrated
+	Albums   []string
+	Faces    []string // names of the people tagged in the picture
+	Keywords []string
+}
+
+// Empty reports whether nothing was recorded.
+func (c Curation) Empty() bool {
+	return c.Rating == 0 && len(c.Albums) == 0 && len(c.Faces) == 0 && len(c.Keywords) == 0
+}
+
+// Record ties a curation to the file it describes.
+type Record struct {
+	Path string
+	// Suffix is set when Path is only the end of the file's path, as for digiKam
+	// collections on removable drives whose mount point is unknown.
+	Suffix bool
+	// AnyExtension is set when Path has no extension and matches the file with
+	// any, as for photo.xmp sidecars.
+	AnyExtension bool
+	Curation
+}
+
+// CatalogFile is a catalogued image that records are matched against.
+type CatalogFile struct {
+	ID   int
+	Path string
+}
+
+// Index finds catalogued files by the paths older tools recorded. Paths are
+// compared case-insensitively, as Picasa was mostly used on Windows.
+type Index struct {
+	byName map[string][]CatalogFile
+	byStem map[string][]CatalogFile
+}
+
+// NewIndex indexes files by name.
+func NewIndex(files []CatalogFile) *Index {
+	index := &Index{byName: make(map[string][]CatalogFile), byStem: make(map[string][]CatalogFile)}
+	for _, file := range files {
+		name := strings.ToLower(filepath.Base(file.Path))
+		index.byName[name] = append(index.byName[name], file)
+		stem := strings.TrimSuffix(name, filepath.Ext(name))
+		index.byStem[stem] = append(index.byStem[stem], file)
+	}
+	return index
+}
+
+// Match returns the IDs of the files a record describes.
+func (index *Index) Match(record Record) []int {
+	want := strings.ToLower(filepath.Clean(record.Path))
+	name := filepath.Base(want)
+	candidates := index.byName[name]
+	if record.AnyExtension {
+		candidates = index.byStem[name]
+	}
+
+	var ids []int
+	for _, file := range candidates {
+		have := strings.ToLower(filepath.Clean(file.Path))
+		if record.AnyExtension {
+			have = strings.TrimSuffix(have, filepath.Ext(have))
+		}
+		if have == want || (record.Suffix && strings.HasSuffix(have, string(filepath.Separator)+strings.TrimLeft(want, string(filepath.Separator)))) {
+			ids = append(ids, file.ID)
+		}
+	}
+	return ids
+}
+
+// appendUnique adds value to values unless it is empty or already present.
+func appendUnique(values []string, value string) []string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return values
+	}
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
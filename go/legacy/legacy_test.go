@@ -0,0 +1,155 @@
+package legacy
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+func TestReadPicasaINI(t *testing.T) {
+	dir := t.TempDir()
+	ini := "\ufeff[Picasa]\nname=Trip\n" +
+		"[Contacts2]\nabc123=Alice Smith;;\n" +
+		"[.album:a1]\nname=Holiday 2009\n" +
+		"[IMG_0001.JPG]\nstar=yes\nalbums=a1,unknown\nfaces=rect64(3f845bcb59418507),abc123;rect64(1),ffffffffffffffff\nkeywords=beach,sunset\n" +
+		"[IMG_0002.JPG]\nrotate=rotate(1)\n"
+	path := filepath.Join(dir, "Picasa.ini")
+	if err := os.WriteFile(path, []byte(ini), 0644); err != nil {
+		t.Fatalf("Failed to write Picasa.ini: %v", err)
+	}
+
+	records, err := ReadPicasaINI(path)
+	if err != nil {
+		t.Fatalf("ReadPicasaINI failed: %v", err)
+	}
+	expected := []Record{{
+		Path: filepath.Join(dir, "IMG_0001.JPG"),
+		Curation: Curation{
+			Rating:   5,
+			Albums:   []string{"Holiday 2009"},
+			Faces:    []string{"Alice Smith"},
+			Keywords: []string{"beach", "sunset"},
+		},
+	}}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("ReadPicasaINI = %+v; expected %+v", records, expected)
+	}
+}
+
+func TestParseXMP(t *testing.T) {
+	xmp := `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmlns:MicrosoftPhoto="http://ns.microsoft.com/photo/1.0/"
+    xmlns:digiKam="http://www.digikam.org/ns/1.0/" xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:mwg-rs="http://www.metadataworkinggroup.com/schemas/regions/"
+    xmp:Rating="4" MicrosoftPhoto:Rating="75">
+   <digiKam:TagsList><rdf:Seq><rdf:li>People/Family/Bob</rdf:li><rdf:li>Places/France/Paris</rdf:li></rdf:Seq></digiKam:TagsList>
+   <dc:subject><rdf:Bag><rdf:li>Bob</rdf:li><rdf:li>Paris</rdf:li><rdf:li>Eiffel Tower</rdf:li></rdf:Bag></dc:subject>
+   <mwg-rs:Regions><mwg-rs:RegionList><rdf:Bag><rdf:li>
+    <rdf:Description mwg-rs:Name="Carol" mwg-rs:Type="Face"/>
+   </rdf:li></rdf:Bag></mwg-rs:RegionList></mwg-rs:Regions>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`
+	c, err := parseXMP(strings.NewReader(xmp))
+	if err != nil {
+		t.Fatalf("parseXMP failed: %v", err)
+	}
+	expected := Curation{Rating: 4, Faces: []string{"Bob", "Carol"}, Keywords: []string{"Paris", "Eiffel Tower"}}
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("parseXMP = %+v; expected %+v", c, expected)
+	}
+
+	if r := SidecarRecord("/p/IMG_1.JPG.xmp", c); r.Path != "/p/IMG_1.JPG" || r.AnyExtension {
+		t.Errorf("SidecarRecord(IMG_1.JPG.xmp) = %+v", r)
+	}
+	if r := SidecarRecord("/p/IMG_1.xmp", c); r.Path != "/p/IMG_1" || !r.AnyExtension {
+		t.Errorf("SidecarRecord(IMG_1.xmp) = %+v", r)
+	}
+}
+
+func TestParseXMPLegacyEncoding(t *testing.T) {
+	keywords := `<dc:subject xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"><rdf:Bag><rdf:li>北京</rdf:li></rdf:Bag></dc:subject>`
+	for _, declaration := range []string{`<?xml version="1.0" encoding="GBK"?>`, ""} {
+		encoded, err := simplifiedchinese.GBK.NewEncoder().String(declaration + keywords)
+		if err != nil {
+			t.Fatalf("Failed to encode XMP: %v", err)
+		}
+		c, err := parseXMP(strings.NewReader(encoded))
+		if err != nil {
+			t.Fatalf("parseXMP failed: %v", err)
+		}
+		if !reflect.DeepEqual(c.Keywords, []string{"北京"}) {
+			t.Errorf("Keywords with declaration %q = %q; expected 北京", declaration, c.Keywords)
+		}
+	}
+}
+
+func TestIndexMatch(t *testing.T) {
+	index := NewIndex([]CatalogFile{
+		{1, "/photos/2009/IMG_0001.jpg"},
+		{2, "/backup/2009/img_0001.JPG"},
+		{3, "/photos/2009/IMG_0002.cr2"},
+		{4, "/photos/old2009/IMG_0001.jpg"},
+	})
+	testCases := []struct {
+		record   Record
+		expected []int
+	}{
+		{Record{Path: "/photos/2009/IMG_0001.JPG"}, []int{1}},
+		{Record{Path: "/2009/img_0001.jpg", Suffix: true}, []int{1, 2}},
+		{Record{Path: "/photos/2009/IMG_0002", AnyExtension: true}, []int{3}},
+		{Record{Path: "/photos/2010/IMG_0001.jpg"}, nil},
+	}
+	for _, tc := range testCases {
+		if ids := index.Match(tc.record); !reflect.DeepEqual(ids, tc.expected) {
+			t.Errorf("Match(%+v) = %v; expected %v", tc.record, ids, tc.expected)
+		}
+	}
+}
+
+func TestReadDigiKamDB(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "digikam4.db")
+	db, err := sql.Open(sqliteDriver, dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	_, err = db.Exec(`
+		CREATE TABLE AlbumRoots (id INTEGER PRIMARY KEY, identifier TEXT, specificPath TEXT);
+		CREATE TABLE Albums (id INTEGER PRIMARY KEY, albumRoot INTEGER, relativePath TEXT);
+		CREATE TABLE Images (id INTEGER PRIMARY KEY, album INTEGER, name TEXT, status INTEGER);
+		CREATE TABLE ImageInformation (imageid INTEGER PRIMARY KEY, rating INTEGER);
+		CREATE TABLE Tags (id INTEGER PRIMARY KEY, pid INTEGER, name TEXT);
+		CREATE TABLE ImageTags (imageid INTEGER, tagid INTEGER);
+		CREATE TABLE TagProperties (tagid INTEGER, property TEXT, value TEXT);
+		INSERT INTO AlbumRoots VALUES (1, 'volumeid:?path=%2Fhome%2Fme%2FPictures', '/'), (2, 'volumeid:?uuid=1234', '/Photos');
+		INSERT INTO Albums VALUES (1, 1, '/2019'), (2, 2, '/');
+		INSERT INTO Images VALUES (1, 1, 'a.jpg', 1), (2, 2, 'b.jpg', 1), (3, 1, 'gone.jpg', 3), (4, 1, 'plain.jpg', 1);
+		INSERT INTO ImageInformation VALUES (1, 3), (2, -1), (3, 5), (4, 0);
+		INSERT INTO Tags VALUES (1, 0, 'People'), (2, 1, 'Alice'), (3, 0, 'Trips'), (4, 3, 'Rome'), (5, 0, 'Dave'),
+			(6, 0, '_Digikam_Internal_Tags_'), (7, 6, 'Color Label Red');
+		INSERT INTO TagProperties VALUES (5, 'person', 'Dave');
+		INSERT INTO ImageTags VALUES (1, 2), (1, 4), (1, 7), (2, 5), (3, 4);
+	`)
+	db.Close()
+	if err != nil {
+		t.Fatalf("Failed to fill database: %v", err)
+	}
+
+	records, err := ReadDigiKamDB(dbPath)
+	if err != nil {
+		t.Fatalf("ReadDigiKamDB failed: %v", err)
+	}
+	expected := []Record{
+		{Path: filepath.FromSlash("/home/me/Pictures/2019/a.jpg"), Curation: Curation{Rating: 3, Faces: []string{"Alice"}, Keywords: []string{"Rome"}}},
+		{Path: filepath.FromSlash("/Photos/b.jpg"), Suffix: true, Curation: Curation{Faces: []string{"Dave"}}},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("ReadDigiKamDB = %+v; expected %+v", records, expected)
+	}
+}
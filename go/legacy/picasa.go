@@ -0,0 +1,103 @@
+package legacy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"picpurge/util"
+)
+
+// PicasaFileNames are the names Picasa gives its per-folder metadata file on
+// Windows and macOS.
+var PicasaFileNames = []string{"Picasa.ini", ".picasa.ini"}
+
+// unknownPicasaContact marks a face Picasa detected but nobody named.
+const unknownPicasaContact = "ffffffffffffffff"
+
+// ReadPicasaINI reads a Picasa.ini file. Picasa has a single star, which is
+// imported as a five-star rating. Album and contact IDs are resolved through
+// the [.album:ID] and [Contacts2] sections of the same file; faces of contacts
+// only listed in Picasa's global contacts.xml are skipped.
+func ReadPicasaINI(path string) ([]Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	sections := make(map[string]map[string]string)
+	var order []string
+	var current map[string]string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Picasa wrote the file in the Windows code page, e.g. GBK on Chinese systems
+		line := strings.TrimSpace(strings.TrimPrefix(util.DecodeText(scanner.Bytes()), "\ufeff"))
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name := line[1 : len(line)-1]
+			if sections[name] == nil {
+				sections[name] = make(map[string]string)
+				order = append(order, name)
+			}
+			current = sections[name]
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok && current != nil {
+			current[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	albums := make(map[string]string)
+	for name, values := range sections {
+		if id, ok := strings.CutPrefix(name, ".album:"); ok && values["name"] != "" {
+			albums[id] = values["name"]
+		}
+	}
+	contacts := make(map[string]string)
+	for id, value := range sections["Contacts2"] {
+		if name, _, _ := strings.Cut(value, ";"); name != "" {
+			contacts[id] = name
+		}
+	}
+
+	dir := filepath.Dir(path)
+	var records []Record
+	for _, name := range order {
+		values := sections[name]
+		if name == "Picasa" || name == "Contacts2" || strings.HasPrefix(name, ".") {
+			continue
+		}
+		var c Curation
+		if values["star"] == "yes" {
+			c.Rating = 5
+		}
+		for _, id := range splitList(values["albums"], ",") {
+			c.Albums = appendUnique(c.Albums, albums[id])
+		}
+		for _, face := range splitList(values["faces"], ";") {
+			// rect64(...),contactID
+			if _, id, ok := strings.Cut(face, ","); ok && id != unknownPicasaContact {
+				c.Faces = appendUnique(c.Faces, contacts[id])
+			}
+		}
+		for _, keyword := range splitList(values["keywords"], ",") {
+			c.Keywords = appendUnique(c.Keywords, keyword)
+		}
+		if !c.Empty() {
+			records = append(records, Record{Path: filepath.Join(dir, name), Curation: c})
+		}
+	}
+	return records, nil
+}
+
+func splitList(value, separator string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, separator)
+}
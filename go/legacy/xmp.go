@@ -0,0 +1,158 @@
+package legacy
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"picpurge/util"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// digiKamPeopleTag is the tag tree digiKam files face tags under.
+const digiKamPeopleTag = "People/"
+
+// xmpRating is the standard star rating. Other schemas, such as Microsoft's
+// percentage, also have a Rating.
+var xmpRating = xml.Name{Space: "http://ns.adobe.com/xap/1.0/", Local: "Rating"}
+
+// SidecarRecord returns the record for an XMP sidecar: photo.jpg.xmp describes
+// photo.jpg, photo.xmp any photo.* in the same folder.
+func SidecarRecord(path string, c Curation) Record {
+	target := strings.TrimSuffix(path, filepath.Ext(path))
+	return Record{Path: target, AnyExtension: filepath.Ext(target) == "", Curation: c}
+}
+
+// ReadXMPSidecar reads the rating, digiKam tags, keywords and named face
+// regions from an XMP sidecar such as those written by digiKam.
+func ReadXMPSidecar(path string) (Curation, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Curation{}, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+	c, err := parseXMP(file)
+	if err != nil {
+		return Curation{}, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return c, nil
+}
+
+func parseXMP(r io.Reader) (Curation, error) {
+	var c Curation
+	var stack []xml.Name // the open elements
+	inside := func(local string) bool {
+		for _, open := range stack {
+			if open.Local == local {
+				return true
+			}
+		}
+		return false
+	}
+	setRating := func(value string) {
+		// -1 marks rejected pictures
+		if rating, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && rating >= 1 && rating <= 5 {
+			c.Rating = rating
+		}
+	}
+	// Tags are kept by their leaf name, Places/France/Paris as Paris
+	addTag := func(tag string) {
+		leaf := tag[strings.LastIndex(tag, "/")+1:]
+		if strings.HasPrefix(tag, digiKamPeopleTag) {
+			c.Faces = appendUnique(c.Faces, leaf)
+		} else {
+			c.Keywords = appendUnique(c.Keywords, leaf)
+		}
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return c, err
+	}
+	// Sidecars without an encoding declaration are sometimes in GBK or Shift-JIS anyway
+	if !utf8.Valid(data) && !bytes.Contains(data[:min(len(data), 256)], []byte("encoding=")) {
+		data = []byte(util.DecodeText(data))
+	}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.CharsetReader = charsetReader
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return c, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name)
+			var regionName, regionType string
+			for _, attr := range t.Attr {
+				switch {
+				case attr.Name == xmpRating:
+					setRating(attr.Value)
+				case attr.Name.Local == "Name":
+					regionName = attr.Value
+				case attr.Name.Local == "Type":
+					regionType = attr.Value
+				}
+			}
+			if inside("RegionList") && regionType == "Face" {
+				c.Faces = appendUnique(c.Faces, regionName)
+			}
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			if len(stack) == 0 {
+				continue
+			}
+			text := strings.TrimSpace(string(t))
+			switch {
+			case stack[len(stack)-1] == xmpRating:
+				setRating(text)
+			case stack[len(stack)-1].Local != "li":
+			case inside("TagsList"):
+				addTag(text)
+			case inside("subject"):
+				c.Keywords = appendUnique(c.Keywords, text)
+			}
+		}
+	}
+
+	// dc:subject repeats the names of people tagged in the tag tree
+	keywords := c.Keywords[:0]
+	for _, keyword := range c.Keywords {
+		if !containsFold(c.Faces, keyword) {
+			keywords = append(keywords, keyword)
+		}
+	}
+	c.Keywords = keywords
+	return c, nil
+}
+
+// charsetReader decodes sidecars that declare a legacy encoding such as GBK or Shift_JIS.
+func charsetReader(label string, input io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(label)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported encoding %q: %w", label, err)
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
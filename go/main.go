@@ -14,20 +14,16 @@ import (
 var webFiles embed.FS
 
 func main() {
-	// Connect to the database (and initialize if not already)
-	_, err := database.GetDBInstance()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: Failed to connect to database: %v\n", err)
-		os.Exit(1)
-	}
-	defer func() {
-		if err := database.CloseDb(); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: Failed to close database connection: %v\n", err)
-			os.Exit(1)
-		}
-	}()
-
 	log.Println("PicPurge Go application started.")
 
-	cmd.Execute() // Call Execute from the cmd package
+	// The database is opened by the root command once --db-path is parsed
+	code := cmd.Execute() // Call Execute from the cmd package
+
+	if err := database.CloseDb(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to close database connection: %v\n", err)
+		if code == cmd.ExitOK {
+			code = cmd.ExitFailure
+		}
+	}
+	os.Exit(code)
 }
@@ -0,0 +1,37 @@
+package ocr
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Available reports whether the tesseract command is installed.
+func Available() bool {
+	_, err := exec.LookPath("tesseract")
+	return err == nil
+}
+
+// ExtractText runs tesseract on an image file and returns the recognized text.
+// languages is a tesseract language list such as "eng" or "eng+chi_sim".
+func ExtractText(filePath string, languages string) (string, error) {
+	if !Available() {
+		return "", fmt.Errorf("tesseract is not installed. Please install tesseract to extract text from images")
+	}
+
+	args := []string{filePath, "stdout"}
+	if languages != "" {
+		args = append(args, "-l", languages)
+	}
+	cmd := exec.Command("tesseract", args...)
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("tesseract failed: %w, stderr: %s", err, stderr.String())
+	}
+	return strings.Join(strings.Fields(stdout.String()), " "), nil
+}
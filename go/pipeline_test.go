@@ -0,0 +1,145 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"picpurge/cmd"
+	"picpurge/database"
+	"picpurge/fixture"
+)
+
+type cataloguedImage struct {
+	id          int
+	md5         string
+	width       int
+	isDuplicate bool
+	similar     []int
+}
+
+// TestPipeline scans a generated library with duplicate detection, similarity
+// grouping and sorting, and checks each file against what the manifest says it is.
+func TestPipeline(t *testing.T) {
+	dir := t.TempDir()
+	library := filepath.Join(dir, "library")
+	sorted := filepath.Join(dir, "sorted")
+	manifest, err := fixture.Generate(library, fixture.DefaultOptions)
+	if err != nil {
+		t.Fatalf("Failed to generate library: %v", err)
+	}
+
+	cmd.RootCmd.SetArgs([]string{"scan", library, "--db-path", filepath.Join(dir, "catalog.db"), "--no-server", "--sort", "--sort-destination", sorted})
+	if code := cmd.Execute(); code != cmd.ExitDuplicatesFound {
+		t.Fatalf("Expected exit code %d, got %d", cmd.ExitDuplicatesFound, code)
+	}
+	defer database.CloseDb()
+
+	db, err := database.GetDBInstance()
+	if err != nil {
+		t.Fatalf("Failed to get database instance: %v", err)
+	}
+	images := loadCatalogue(t, db)
+	if len(images) != len(manifest.Entries) {
+		t.Fatalf("Expected %d catalogued files, got %d", len(manifest.Entries), len(images))
+	}
+
+	lookup := func(path string) cataloguedImage {
+		img, ok := images[filepath.Join(library, filepath.FromSlash(path))]
+		if !ok {
+			t.Fatalf("%s was not catalogued", path)
+		}
+		return img
+	}
+	grouped := func(a, b cataloguedImage) bool {
+		for _, id := range a.similar {
+			if id == b.id {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, entry := range manifest.Entries {
+		img := lookup(entry.Path)
+		switch entry.Kind {
+		case fixture.KindOriginal:
+			if img.width == 0 {
+				t.Errorf("%s: original was not decoded", entry.Path)
+			}
+		case fixture.KindExactDuplicate:
+			original := lookup(entry.Of)
+			if img.md5 != original.md5 {
+				t.Errorf("%s: MD5 differs from %s", entry.Path, entry.Of)
+			}
+			if img.isDuplicate == original.isDuplicate {
+				t.Errorf("%s: exactly one of the copy and %s should be marked duplicate", entry.Path, entry.Of)
+			}
+		case fixture.KindResized:
+			if img.isDuplicate || !grouped(img, lookup(entry.Of)) {
+				t.Errorf("%s: expected a similar image of %s, got duplicate=%v similar=%v", entry.Path, entry.Of, img.isDuplicate, img.similar)
+			}
+		case fixture.KindRotated:
+			if img.isDuplicate || !grouped(img, lookup(entry.Of)) {
+				t.Errorf("%s: expected a similar image of %s, got duplicate=%v similar=%v", entry.Path, entry.Of, img.isDuplicate, img.similar)
+			}
+		case fixture.KindRaw, fixture.KindCorrupt:
+			if img.width != 0 || img.isDuplicate || len(img.similar) != 0 {
+				t.Errorf("%s: undecodable file should be catalogued on its own, got width=%d duplicate=%v similar=%v", entry.Path, img.width, img.isDuplicate, img.similar)
+			}
+		}
+	}
+
+	// Every file except the marked duplicates is copied, originals into the month their name gives
+	var sortedFiles []string
+	filepath.Walk(sorted, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			sortedFiles = append(sortedFiles, filepath.ToSlash(path))
+		}
+		return nil
+	})
+	if expected := len(manifest.Entries) - manifest.Count(fixture.KindExactDuplicate); len(sortedFiles) != expected {
+		t.Errorf("Expected %d sorted files, got %d: %v", expected, len(sortedFiles), sortedFiles)
+	}
+	for _, entry := range manifest.Entries {
+		if entry.Kind != fixture.KindOriginal {
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(entry.Path), ".jpg") // IMG_YYYYMMDD_HHMMSS
+		month := name[4:8] + "/" + name[8:10] + "/"
+		found := false
+		for _, path := range sortedFiles {
+			found = found || (strings.Contains(path, "/"+month) && strings.HasSuffix(path, name+".jpg"))
+		}
+		if !found {
+			t.Errorf("%s was not sorted into %s", entry.Path, month)
+		}
+	}
+}
+
+func loadCatalogue(t *testing.T, db *sql.DB) map[string]cataloguedImage {
+	rows, err := db.Query("SELECT id, file_path, md5, image_width, is_duplicate, COALESCE(similar_images, '') FROM images")
+	if err != nil {
+		t.Fatalf("Failed to query images: %v", err)
+	}
+	defer rows.Close()
+
+	images := make(map[string]cataloguedImage)
+	for rows.Next() {
+		var img cataloguedImage
+		var path, similar string
+		if err := rows.Scan(&img.id, &path, &img.md5, &img.width, &img.isDuplicate, &similar); err != nil {
+			t.Fatalf("Failed to scan image: %v", err)
+		}
+		if similar != "" {
+			if err := json.Unmarshal([]byte(similar), &img.similar); err != nil {
+				t.Fatalf("Failed to parse similar images of %s: %v", path, err)
+			}
+		}
+		images[path] = img
+	}
+	return images
+}
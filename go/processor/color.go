@@ -0,0 +1,107 @@
+package processor
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	PaletteSize     = 5    // most colours kept per image
+	paletteMinShare = 0.02 // colours covering less of the image are left out
+	paletteSamples  = 64   // the image is sampled on a grid this many points wide and high
+	paletteBits     = 3    // bits per channel when bucketing similar colours
+)
+
+// MaxColorDistance is the distance between black and white under ColorDistance.
+var MaxColorDistance = math.Sqrt(3 * 255 * 255)
+
+// Palette returns the image's most common colours as #rrggbb, most common first.
+// Similar shades are bucketed together and reported as their average, so the
+// first entry is the image's dominant colour. Mostly transparent pixels are ignored.
+func Palette(img image.Image) []string {
+	type bucket struct {
+		count      int
+		r, g, b    int
+		firstIndex int // keeps the order of equally common colours stable
+	}
+	buckets := make(map[int]*bucket)
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return nil
+	}
+	stepsX, stepsY := min(width, paletteSamples), min(height, paletteSamples)
+	total := 0
+	for sy := 0; sy < stepsY; sy++ {
+		for sx := 0; sx < stepsX; sx++ {
+			x := bounds.Min.X + (2*sx+1)*width/(2*stepsX)
+			y := bounds.Min.Y + (2*sy+1)*height/(2*stepsY)
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A < 128 {
+				continue
+			}
+			shift := 8 - paletteBits
+			key := int(c.R>>shift)<<(2*paletteBits) | int(c.G>>shift)<<paletteBits | int(c.B>>shift)
+			b := buckets[key]
+			if b == nil {
+				b = &bucket{firstIndex: total}
+				buckets[key] = b
+			}
+			b.count++
+			b.r += int(c.R)
+			b.g += int(c.G)
+			b.b += int(c.B)
+			total++
+		}
+	}
+
+	sorted := make([]*bucket, 0, len(buckets))
+	for _, b := range buckets {
+		sorted = append(sorted, b)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		return sorted[i].firstIndex < sorted[j].firstIndex
+	})
+
+	var palette []string
+	for _, b := range sorted {
+		if len(palette) == PaletteSize || float64(b.count)/float64(total) < paletteMinShare {
+			break
+		}
+		palette = append(palette, fmt.Sprintf("#%02x%02x%02x", b.r/b.count, b.g/b.count, b.b/b.count))
+	}
+	return palette
+}
+
+// ParseHexColor reads a colour written as #rrggbb, rrggbb or #rgb.
+func ParseHexColor(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid color: %s", s)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color: %s", s)
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}, nil
+}
+
+// ColorDistance returns the Euclidean distance between two colours in RGB space,
+// from 0 for equal colours to MaxColorDistance.
+func ColorDistance(a, b color.RGBA) float64 {
+	dr := float64(a.R) - float64(b.R)
+	dg := float64(a.G) - float64(b.G)
+	db := float64(a.B) - float64(b.B)
+	return math.Sqrt(dr*dr + dg*dg + db*db)
+}
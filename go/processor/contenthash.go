@@ -0,0 +1,24 @@
+package processor
+
+import "picpurge/util"
+
+// contentHash is the algorithm of the content digests ReadImageFile takes.
+var contentHash = util.HashMD5
+
+// SetHashAlgo sets the algorithm of the content digests that decide which
+// files are exact duplicates: md5, sha256, blake3 or xxh3. Images hashed with
+// another algorithm are hashed again by the next scan, as digests of different
+// algorithms never match.
+func SetHashAlgo(name string) error {
+	algo, err := util.ParseHashAlgo(name)
+	if err != nil {
+		return err
+	}
+	contentHash = algo
+	return nil
+}
+
+// HashAlgo returns the algorithm set by SetHashAlgo.
+func HashAlgo() util.HashAlgo {
+	return contentHash
+}
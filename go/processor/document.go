@@ -0,0 +1,61 @@
+package processor
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Photos of documents and receipts are mostly white paper with dense,
+// high-contrast text. Their pHashes describe the page layout more than the
+// text, so different receipts from one shop can be as close as two shots of
+// the same receipt, and they are held to DocumentPHashThreshold instead.
+const (
+	paperMinBrightness = 170  // luma of a sample on paper, even in dim light
+	paperMaxChroma     = 40   // largest spread between channels of paper, which is grey or white
+	paperMinShare      = 0.55 // part of the samples that must be paper
+	edgeMinContrast    = 48   // luma step between neighbouring samples counted as an edge
+	edgeMinShare       = 0.08 // part of the samples that must be edges, as in text
+)
+
+// IsDocument reports whether an image looks like a photographed or scanned
+// document: a light, colourless background with many sharp edges. Like
+// ClassifyLowInfo it samples the image on a grid, so it is meant for a
+// thumbnail rather than the original.
+func IsDocument(img image.Image) bool {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 2 || height < 2 {
+		return false
+	}
+	stepsX, stepsY := min(width, lowInfoSamples), min(height, lowInfoSamples)
+
+	luma := make([][]float64, stepsY)
+	paper := 0
+	for sy := range luma {
+		luma[sy] = make([]float64, stepsX)
+		for sx := range luma[sy] {
+			c := color.NRGBAModel.Convert(img.At(bounds.Min.X+sx*width/stepsX, bounds.Min.Y+sy*height/stepsY)).(color.NRGBA)
+			r, g, b := float64(c.R), float64(c.G), float64(c.B)
+			luma[sy][sx] = 0.299*r + 0.587*g + 0.114*b
+			if luma[sy][sx] >= paperMinBrightness && max(r, g, b)-min(r, g, b) <= paperMaxChroma {
+				paper++
+			}
+		}
+	}
+	samples := float64(stepsX * stepsY)
+	if float64(paper) < paperMinShare*samples {
+		return false
+	}
+
+	edges := 0
+	for sy := 0; sy < stepsY; sy++ {
+		for sx := 0; sx < stepsX; sx++ {
+			if (sx+1 < stepsX && math.Abs(luma[sy][sx+1]-luma[sy][sx]) >= edgeMinContrast) ||
+				(sy+1 < stepsY && math.Abs(luma[sy+1][sx]-luma[sy][sx]) >= edgeMinContrast) {
+				edges++
+			}
+		}
+	}
+	return float64(edges) >= edgeMinShare*samples
+}
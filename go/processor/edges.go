@@ -0,0 +1,40 @@
+package processor
+
+import (
+	"image"
+	"log"
+
+	"github.com/corona10/goimagehash"
+)
+
+// edgeStripFraction is the share of the image width hashed on each side. Panorama
+// frames usually overlap by a quarter to a third, so the strips see the same scenery.
+const edgeStripFraction = 4
+
+// edgeHashes returns average hashes of the left and right strips of img, used to
+// chain panorama frames. Both are empty when the image is too narrow or cannot be cropped.
+func edgeHashes(img image.Image, filePath string) (left, right string) {
+	bounds := img.Bounds()
+	stripWidth := bounds.Dx() / edgeStripFraction
+	if stripWidth < 8 {
+		return "", ""
+	}
+	cropper, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	})
+	if !ok {
+		return "", ""
+	}
+
+	leftHash, err := goimagehash.AverageHash(cropper.SubImage(image.Rect(bounds.Min.X, bounds.Min.Y, bounds.Min.X+stripWidth, bounds.Max.Y)))
+	if err != nil {
+		log.Printf("Warning: Could not hash left edge of %s: %v\n", filePath, err)
+		return "", ""
+	}
+	rightHash, err := goimagehash.AverageHash(cropper.SubImage(image.Rect(bounds.Max.X-stripWidth, bounds.Min.Y, bounds.Max.X, bounds.Max.Y)))
+	if err != nil {
+		log.Printf("Warning: Could not hash right edge of %s: %v\n", filePath, err)
+		return "", ""
+	}
+	return leftHash.ToString(), rightHash.ToString()
+}
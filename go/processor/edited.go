@@ -0,0 +1,36 @@
+package processor
+
+import "strings"
+
+// editorSoftware maps lowercase fragments of the EXIF Software tag to the
+// editing app they name. Cameras and phones write their firmware version
+// there, which matches none of them.
+var editorSoftware = []struct{ fragment, editor string }{
+	{"lightroom", "Lightroom"}, // before Photoshop, as in "Adobe Photoshop Lightroom"
+	{"photoshop", "Photoshop"},
+	{"snapseed", "Snapseed"},
+	{"instagram", "Instagram"},
+	{"vsco", "VSCO"},
+	{"picsart", "PicsArt"},
+	{"facetune", "Facetune"},
+	{"gimp", "GIMP"},
+	{"affinity photo", "Affinity Photo"},
+	{"pixelmator", "Pixelmator"},
+	{"luminar", "Luminar"},
+	{"capture one", "Capture One"},
+	{"darktable", "darktable"},
+	{"rawtherapee", "RawTherapee"},
+	{"paint.net", "Paint.NET"},
+}
+
+// EditorOf returns the editing app named by an EXIF Software tag, or "" for
+// camera firmware and software it does not know.
+func EditorOf(software string) string {
+	software = strings.ToLower(software)
+	for _, e := range editorSoftware {
+		if strings.Contains(software, e.fragment) {
+			return e.editor
+		}
+	}
+	return ""
+}
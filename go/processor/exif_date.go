@@ -0,0 +1,72 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+
+	"picpurge/fileops"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// exifDateLayout is how EXIF stores dates: 19 characters plus a terminating NUL.
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// exifDateFields are the tags rewritten by WriteEXIFDate.
+var exifDateFields = []exif.FieldName{exif.DateTimeOriginal, exif.DateTimeDigitized, exif.DateTime}
+
+// WriteEXIFDate overwrites the capture dates already present in a JPEG or
+// TIFF-based RAW file. Dates have a fixed length, so they are patched in place
+// and nothing else in the file moves. It returns how many tags were rewritten;
+// files without date tags are left unchanged.
+func WriteEXIFDate(filePath string, date time.Time) (int, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat file: %w", err)
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read file: %w", err)
+	}
+	x, err := exif.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read EXIF: %w", err)
+	}
+
+	// Tag offsets are relative to the TIFF header, which follows the Exif marker in a JPEG
+	base := bytes.Index(data, x.Raw)
+	if base < 0 {
+		return 0, fmt.Errorf("failed to locate EXIF data in file")
+	}
+
+	value := append([]byte(date.Format(exifDateLayout)), 0)
+	written := 0
+	for _, field := range exifDateFields {
+		tag, err := x.Get(field)
+		if err != nil || tag.ValOffset == 0 || int(tag.Count) != len(value) {
+			continue
+		}
+		start := base + int(tag.ValOffset)
+		if start+len(value) > len(data) || !bytes.Equal(data[start:start+len(value)], tag.Val) {
+			return 0, fmt.Errorf("EXIF %s is not where its tag says", field)
+		}
+		copy(data[start:], value)
+		written++
+	}
+	if written == 0 {
+		return 0, nil
+	}
+
+	// Write a sibling first so a failure never leaves a half-written original
+	tmp := filePath + ".picpurge-tmp"
+	if err := os.WriteFile(tmp, data, info.Mode().Perm()); err != nil {
+		os.Remove(tmp)
+		return 0, fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := fileops.Replace(tmp, filePath); err != nil {
+		return 0, fmt.Errorf("failed to replace file: %w", err)
+	}
+	return written, nil
+}
@@ -0,0 +1,94 @@
+package processor
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// Extra EXIF fields that goexif does not know about.
+const (
+	BodySerialNumber   exif.FieldName = "BodySerialNumber"
+	CameraSerialNumber exif.FieldName = "CameraSerialNumber"
+	ImageNumber        exif.FieldName = "ImageNumber"
+)
+
+var serialFields = map[uint16]exif.FieldName{
+	0xA431: BodySerialNumber,
+	0xC62F: CameraSerialNumber, // DNG
+	0x9211: ImageNumber,        // TIFF/EP, used by several cameras as shutter count
+}
+
+// serialParser loads camera serial and shutter count tags from IFD0 and the EXIF sub-IFD.
+type serialParser struct{}
+
+func init() {
+	exif.RegisterParsers(serialParser{})
+}
+
+func (serialParser) Parse(x *exif.Exif) error {
+	if len(x.Tiff.Dirs) > 0 {
+		x.LoadTags(x.Tiff.Dirs[0], serialFields, false)
+	}
+	tag, err := x.Get(exif.ExifIFDPointer)
+	if err != nil {
+		return nil
+	}
+	offset, err := tag.Int64(0)
+	if err != nil {
+		return nil
+	}
+	r := bytes.NewReader(x.Raw)
+	if _, err := r.Seek(offset, 0); err != nil {
+		return nil
+	}
+	subDir, _, err := tiff.DecodeDir(r, x.Tiff.Order)
+	if err != nil {
+		return nil
+	}
+	x.LoadTags(subDir, serialFields, false)
+	return nil
+}
+
+// extractCameraIdentity returns the camera serial number and shutter count, if present.
+func extractCameraIdentity(x *exif.Exif) (serial string, shutterCount int64) {
+	for _, field := range []exif.FieldName{BodySerialNumber, CameraSerialNumber} {
+		if tag, err := x.Get(field); err == nil {
+			if s, err := tag.StringVal(); err == nil && strings.TrimSpace(s) != "" {
+				serial = strings.TrimSpace(s)
+				break
+			}
+		}
+	}
+	if tag, err := x.Get(ImageNumber); err == nil {
+		if n, err := tag.Int64(0); err == nil {
+			shutterCount = n
+		}
+	}
+	return serial, shutterCount
+}
+
+// ShotRelation describes how two images taken with a known camera relate.
+type ShotRelation int
+
+const (
+	// ShotUnknown means there is not enough metadata to decide.
+	ShotUnknown ShotRelation = iota
+	// ShotSame means both files come from the same exposure (a copy or re-encode).
+	ShotSame
+	// ShotReshoot means both files come from the same camera but different exposures.
+	ShotReshoot
+)
+
+// CompareShots classifies two images by camera serial number and shutter count.
+func CompareShots(serialA string, countA int64, serialB string, countB int64) ShotRelation {
+	if serialA == "" || serialB == "" || serialA != serialB || countA == 0 || countB == 0 {
+		return ShotUnknown
+	}
+	if countA == countB {
+		return ShotSame
+	}
+	return ShotReshoot
+}
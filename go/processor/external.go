@@ -0,0 +1,82 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os/exec"
+)
+
+// AVIF and JPEG XL files are decoded by an external converter, like RAW files,
+// since there are no pure Go decoders. Registering the formats lets
+// image.Decode handle them wherever images are decoded.
+func init() {
+	registerExternalFormat("avif", &avifConverters,
+		"????ftypavif",
+		"????ftypavis", // animated AVIF; the first frame is used
+	)
+	registerExternalFormat("jxl", &jxlConverters,
+		"\xff\x0a",                             // bare codestream
+		"\x00\x00\x00\x0cJXL \x0d\x0a\x87\x0a", // ISO BMFF container
+	)
+}
+
+// avifConverters and jxlConverters are the commands tried in order to turn an
+// image from stdin into PNG on stdout.
+var (
+	avifConverters = [][]string{
+		{"magick", "avif:-", "png:-"},
+		{"convert", "avif:-", "png:-"},
+		{"ffmpeg", "-v", "error", "-i", "pipe:0", "-frames:v", "1", "-f", "image2pipe", "-vcodec", "png", "-"},
+	}
+	jxlConverters = [][]string{
+		{"magick", "jxl:-", "png:-"},
+		{"convert", "jxl:-", "png:-"},
+		{"ffmpeg", "-v", "error", "-f", "jpegxl_pipe", "-i", "pipe:0", "-frames:v", "1", "-f", "image2pipe", "-vcodec", "png", "-"},
+	}
+)
+
+func registerExternalFormat(name string, converters *[][]string, magics ...string) {
+	decode := func(r io.Reader) (image.Image, error) {
+		return decodeExternal(name, *converters, r)
+	}
+	decodeConfig := func(r io.Reader) (image.Config, error) {
+		img, err := decode(r)
+		if err != nil {
+			return image.Config{}, err
+		}
+		return image.Config{ColorModel: img.ColorModel(), Width: img.Bounds().Dx(), Height: img.Bounds().Dy()}, nil
+	}
+	for _, magic := range magics {
+		image.RegisterFormat(name, magic, decode, decodeConfig)
+	}
+}
+
+// decodeExternal pipes an image through the first installed converter.
+func decodeExternal(name string, converters [][]string, r io.Reader) (image.Image, error) {
+	var converter []string
+	for _, c := range converters {
+		if _, err := exec.LookPath(c[0]); err == nil {
+			converter = c
+			break
+		}
+	}
+	if converter == nil {
+		return nil, fmt.Errorf("no %s decoder found. Please install ImageMagick or ffmpeg to process %s files", name, name)
+	}
+	cmd := exec.Command(converter[0], converter[1:]...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = r
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed to decode %s: %w, stderr: %s", converter[0], name, err, stderr.String())
+	}
+	img, err := png.Decode(&stdout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode converted %s: %w", name, err)
+	}
+	return img, nil
+}
@@ -0,0 +1,38 @@
+package processor
+
+import (
+	"regexp"
+	"time"
+)
+
+// Where an image's create date came from, most to least reliable.
+const (
+	DateSourceEXIF      = "exif"
+	DateSourceMetadata  = "metadata" // creation time of a video container
+	DateSourceFileName  = "filename"
+	DateSourceEstimated = "estimated" // taken from neighbouring files
+	DateSourceModTime   = "modtime"
+)
+
+// fileNameDatePattern matches dates written by phones, cameras and messengers,
+// e.g. IMG_20230501_120000, 2023-05-01 12.00.00, PXL_20230501_120000123 or
+// IMG-20230501-WA0001. The time part is optional.
+var fileNameDatePattern = regexp.MustCompile(`(?:^|[^0-9])((?:19|20)\d{2})[-_.]?(0[1-9]|1[0-2])[-_.]?(0[1-9]|[12]\d|3[01])(?:[-_. T]?([01]\d|2[0-3])[-_.:]?([0-5]\d)[-_.:]?([0-5]\d)\d{0,3})?(?:[^0-9]|$)`)
+
+// DateFromFileName extracts a capture date from a file name. Dates in the future
+// are rejected, as they are more likely to be serial numbers.
+func DateFromFileName(fileName string) (time.Time, bool) {
+	m := fileNameDatePattern.FindStringSubmatch(fileName)
+	if m == nil {
+		return time.Time{}, false
+	}
+	layout, value := "20060102", m[1]+m[2]+m[3]
+	if m[4] != "" {
+		layout, value = "20060102150405", value+m[4]+m[5]+m[6]
+	}
+	t, err := time.Parse(layout, value)
+	if err != nil || t.After(time.Now()) {
+		return time.Time{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,133 @@
+package processor
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+
+	"github.com/corona10/goimagehash"
+	"github.com/nfnt/resize"
+)
+
+// Thresholds of the hashes that can confirm a pHash match, see SetConfirmHashes.
+const (
+	DHashThreshold = 10 // Hamming distance threshold for dHash similarity
+	AHashThreshold = 10 // Hamming distance threshold for aHash similarity
+	WHashThreshold = 10 // Hamming distance threshold for wavelet hash similarity
+)
+
+// confirmHashes is the number of other hashes that must agree with a pHash match.
+var confirmHashes int
+
+// SetConfirmHashes makes similar detection count a pair of images as similar
+// only when, besides their pHashes, at least n of their dHashes, aHashes and
+// wavelet hashes are within their thresholds too. Textured pictures such as
+// foliage or gravel often have close pHashes without looking alike; the other
+// hashes look at brightness and edges differently and rarely agree on them.
+// 0 leaves the pHash alone to decide.
+func SetConfirmHashes(n int) error {
+	if n < 0 || n > 3 {
+		return fmt.Errorf("the number of confirming hashes must be between 0 and 3, not %d", n)
+	}
+	confirmHashes = n
+	return nil
+}
+
+// waveletHash returns the wavelet hash of img, as in the imagehash library: the
+// low-frequency band of a Haar wavelet transform of its 64x64 grey image down
+// to 8x8, without the overall brightness, with a bit set for every coefficient
+// above their median.
+func waveletHash(img image.Image) *goimagehash.ImageHash {
+	const size = 64
+	small := resize.Resize(size, size, img, resize.Bilinear)
+	bounds := small.Bounds()
+	pixels := make([]float64, size*size)
+	var mean float64
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			gray := color.GrayModel.Convert(small.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray)
+			pixels[y*size+x] = float64(gray.Y) / 255
+			mean += pixels[y*size+x]
+		}
+	}
+	mean /= size * size
+	for i := range pixels {
+		pixels[i] -= mean
+	}
+	for n := size; n > 8; n /= 2 {
+		pixels = haarLowPass(pixels, n)
+	}
+
+	sorted := append([]float64(nil), pixels...)
+	sort.Float64s(sorted)
+	median := (sorted[31] + sorted[32]) / 2
+	var hash uint64
+	for i, p := range pixels {
+		if p > median {
+			hash |= 1 << uint(63-i)
+		}
+	}
+	return goimagehash.NewImageHash(hash, goimagehash.WHash)
+}
+
+// haarLowPass returns the low-frequency band of one Haar wavelet step of an
+// n by n image, n/2 by n/2 in size.
+func haarLowPass(pixels []float64, n int) []float64 {
+	half := n / 2
+	band := make([]float64, half*half)
+	for y := 0; y < half; y++ {
+		for x := 0; x < half; x++ {
+			i := 2*y*n + 2*x
+			band[y*half+x] = (pixels[i] + pixels[i+1] + pixels[i+n] + pixels[i+n+1]) / 2
+		}
+	}
+	return band
+}
+
+// ConfirmingHashes holds the hashes of an image that can confirm a pHash match.
+type ConfirmingHashes struct {
+	DHash, AHash, WHash *goimagehash.ImageHash // nil when not taken
+}
+
+// ParseConfirmingHashes reads the stored dHash, aHash and wavelet hash of an
+// image. Missing or unreadable ones stay nil.
+func ParseConfirmingHashes(dhash, ahash, whash string) ConfirmingHashes {
+	parse := func(s string) *goimagehash.ImageHash {
+		if s == "" {
+			return nil
+		}
+		hash, err := goimagehash.ImageHashFromString(s)
+		if err != nil {
+			return nil
+		}
+		return hash
+	}
+	return ConfirmingHashes{DHash: parse(dhash), AHash: parse(ahash), WHash: parse(whash)}
+}
+
+// Confirm reports whether enough of the hashes of two images agree for a pHash
+// match between them to count, as set by SetConfirmHashes. A hash missing on
+// either image does not agree.
+func (h ConfirmingHashes) Confirm(other ConfirmingHashes) bool {
+	if confirmHashes == 0 {
+		return true
+	}
+	agreeing := 0
+	for _, pair := range []struct {
+		a, b      *goimagehash.ImageHash
+		threshold int
+	}{
+		{h.DHash, other.DHash, DHashThreshold},
+		{h.AHash, other.AHash, AHashThreshold},
+		{h.WHash, other.WHash, WHashThreshold},
+	} {
+		if pair.a == nil || pair.b == nil {
+			continue
+		}
+		if d, err := pair.a.Distance(pair.b); err == nil && d <= pair.threshold {
+			agreeing++
+		}
+	}
+	return agreeing >= confirmHashes
+}
@@ -0,0 +1,70 @@
+package processor
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Kinds of images that carry almost no information, such as lens-cap shots,
+// pocket photos or shots blown out to white.
+const (
+	LowInfoSolid  = "solid"  // one flat colour
+	LowInfoDark   = "dark"   // almost black
+	LowInfoBright = "bright" // almost white
+)
+
+const (
+	solidMaxDeviation   = 6   // per-channel standard deviation below which an image is flat
+	extremeMaxDeviation = 20  // dark and bright images may show a little more detail
+	darkMaxBrightness   = 24  // mean luma of an almost black image
+	brightMinBrightness = 235 // mean luma of an almost white image
+	lowInfoSamples      = 128 // the thumbnail is sampled on a grid this many points wide and high
+)
+
+// ClassifyLowInfo reports whether an image is essentially blank, returning one of
+// the LowInfo constants, or "" for a normal image. It looks at the mean and
+// spread of the pixels, so it is meant for a thumbnail rather than the original.
+func ClassifyLowInfo(img image.Image) string {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return ""
+	}
+	stepsX, stepsY := min(width, lowInfoSamples), min(height, lowInfoSamples)
+
+	var n float64
+	var sum, sumSq [3]float64
+	var lumaSum float64
+	for sy := 0; sy < stepsY; sy++ {
+		for sx := 0; sx < stepsX; sx++ {
+			x := bounds.Min.X + sx*width/stepsX
+			y := bounds.Min.Y + sy*height/stepsY
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			channels := [3]float64{float64(c.R), float64(c.G), float64(c.B)}
+			for i, v := range channels {
+				sum[i] += v
+				sumSq[i] += v * v
+			}
+			lumaSum += 0.299*channels[0] + 0.587*channels[1] + 0.114*channels[2]
+			n++
+		}
+	}
+
+	deviation := 0.0
+	for i := range sum {
+		mean := sum[i] / n
+		deviation = math.Max(deviation, math.Sqrt(math.Max(0, sumSq[i]/n-mean*mean)))
+	}
+	luma := lumaSum / n
+
+	switch {
+	case luma <= darkMaxBrightness && deviation <= extremeMaxDeviation:
+		return LowInfoDark
+	case luma >= brightMinBrightness && deviation <= extremeMaxDeviation:
+		return LowInfoBright
+	case deviation <= solidMaxDeviation:
+		return LowInfoSolid
+	}
+	return ""
+}
@@ -0,0 +1,59 @@
+package processor
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Messengers whose exports picpurge recognizes.
+const (
+	MessengerWhatsApp = "whatsapp"
+	MessengerTelegram = "telegram"
+	MessengerSignal   = "signal"
+)
+
+// messengerFolders are lowercase folder names the messengers save or export
+// media to, on phones and desktops.
+var messengerFolders = map[string]string{
+	"whatsapp":                 MessengerWhatsApp,
+	"whatsapp images":          MessengerWhatsApp,
+	"whatsapp animated gifs":   MessengerWhatsApp,
+	"whatsapp business images": MessengerWhatsApp,
+	"telegram":                 MessengerTelegram,
+	"telegram images":          MessengerTelegram,
+	"telegram desktop":         MessengerTelegram,
+	"signal":                   MessengerSignal,
+}
+
+// messengerNames match the file names the messengers give received media.
+var messengerNames = []struct {
+	pattern   *regexp.Regexp
+	messenger string
+}{
+	{regexp.MustCompile(`(?i)^(IMG|VID|STK)-\d{8}-WA\d{4}`), MessengerWhatsApp},
+	{regexp.MustCompile(`(?i)^WhatsApp Image \d{4}-\d{2}-\d{2} at `), MessengerWhatsApp},
+	{regexp.MustCompile(`(?i)^photo_\d{4}-\d{2}-\d{2}_\d{2}-\d{2}-\d{2}`), MessengerTelegram},
+	{regexp.MustCompile(`(?i)^signal-\d{4}-\d{2}-\d{2}-\d{6}`), MessengerSignal},
+}
+
+// MessengerOf returns the messenger an image was received or exported through,
+// judged by its folders and file name, or "" for other images. Messengers
+// recompress and strip metadata, so these files are low-priority copies when
+// the original exists elsewhere.
+func MessengerOf(filePath string) string {
+	name := filepath.Base(filePath)
+	for _, n := range messengerNames {
+		if n.pattern.MatchString(name) {
+			return n.messenger
+		}
+	}
+	for dir := filepath.Dir(filePath); ; dir = filepath.Dir(dir) {
+		if messenger, ok := messengerFolders[strings.ToLower(filepath.Base(dir))]; ok {
+			return messenger
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			return ""
+		}
+	}
+}
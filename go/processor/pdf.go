@@ -0,0 +1,157 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/corona10/goimagehash"
+)
+
+// Scanned photos often end up in PDFs. Their pages are rendered with pdftoppm
+// from poppler and hashed like images, so a page showing a photo that is also
+// catalogued as a file can be found. PDFs are never catalogued themselves.
+
+const (
+	pdfResolution = 100 // dots per inch of the rendered pages, plenty for a pHash
+	maxPDFPages   = 200 // pages rendered of one PDF at most
+	marginMinLuma = 235 // lighter samples count as the blank paper around a photo
+)
+
+// PDFPage is a rendered page of a PDF.
+type PDFPage struct {
+	Page          int // from 1
+	Width         int // of the page content, margins trimmed
+	Height        int
+	PHash         string
+	TurnedPHashes string
+}
+
+// PDFRasterizerAvailable reports why PDF pages cannot be rendered, or nil when
+// pdftoppm is installed.
+func PDFRasterizerAvailable() error {
+	if _, err := exec.LookPath("pdftoppm"); err != nil {
+		return fmt.Errorf("pdftoppm is not installed. Please install poppler-utils to preview PDFs")
+	}
+	return nil
+}
+
+// RasterizePDF renders the first page of a PDF, or with allPages every page up
+// to maxPDFPages, and hashes the content of each page.
+func RasterizePDF(filePath string, allPages bool) ([]PDFPage, error) {
+	if err := PDFRasterizerAvailable(); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "picpurge-pdf-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create directory for PDF pages: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	last := 1
+	if allPages {
+		last = maxPDFPages
+	}
+	cmd := exec.Command("pdftoppm", "-png", "-r", strconv.Itoa(pdfResolution), "-f", "1", "-l", strconv.Itoa(last), filePath, filepath.Join(dir, "page"))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("pdftoppm failed: %w, stderr: %s", err, stderr.String())
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PDF pages: %w", err)
+	}
+	var pages []PDFPage
+	for _, entry := range entries {
+		number, ok := pdfPageNumber(entry.Name())
+		if !ok {
+			continue
+		}
+		page, err := hashPDFPage(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", number, err)
+		}
+		page.Page = number
+		pages = append(pages, page)
+	}
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("pdftoppm rendered no pages")
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Page < pages[j].Page })
+	return pages, nil
+}
+
+// pdfPageNumber reads the page number of a file pdftoppm wrote, such as
+// page-1.png or page-007.png; pdftoppm pads the numbers of longer documents.
+func pdfPageNumber(name string) (int, bool) {
+	name, ok := strings.CutSuffix(name, ".png")
+	if !ok {
+		return 0, false
+	}
+	i := strings.LastIndex(name, "-")
+	if i < 0 {
+		return 0, false
+	}
+	number, err := strconv.Atoi(name[i+1:])
+	return number, err == nil && number > 0
+}
+
+func hashPDFPage(path string) (PDFPage, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return PDFPage{}, err
+	}
+	defer file.Close()
+	img, err := png.Decode(file)
+	if err != nil {
+		return PDFPage{}, fmt.Errorf("failed to decode rendered page: %w", err)
+	}
+	img = trimMargins(img)
+	phash, err := goimagehash.PerceptionHash(img)
+	if err != nil {
+		return PDFPage{}, fmt.Errorf("failed to calculate pHash: %w", err)
+	}
+	return PDFPage{
+		Width:         img.Bounds().Dx(),
+		Height:        img.Bounds().Dy(),
+		PHash:         phash.ToString(),
+		TurnedPHashes: turnedPHashes(img, path),
+	}, nil
+}
+
+// trimMargins cuts the blank paper around the content of a page, so a scanned
+// photo is hashed as it would be on its own. Pages that are blank, or whose
+// content would be a sliver, are returned as they are.
+func trimMargins(img image.Image) image.Image {
+	bounds := img.Bounds()
+	content := image.Rectangle{Min: bounds.Max, Max: bounds.Min}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			if 0.299*float64(r>>8)+0.587*float64(g>>8)+0.114*float64(b>>8) >= marginMinLuma {
+				continue
+			}
+			content.Min.X, content.Min.Y = min(content.Min.X, x), min(content.Min.Y, y)
+			content.Max.X, content.Max.Y = max(content.Max.X, x+1), max(content.Max.Y, y+1)
+		}
+	}
+	if content.Empty() || content.Dx()*8 < bounds.Dx() || content.Dy()*8 < bounds.Dy() {
+		return img
+	}
+	sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	})
+	if !ok {
+		return img
+	}
+	return sub.SubImage(content)
+}
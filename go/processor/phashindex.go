@@ -0,0 +1,185 @@
+package processor
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"sort"
+)
+
+// PHashIndex finds the images with a pHash close to a given one without
+// comparing it to every image. It is a BK-tree over the 64-bit hashes: every
+// child of a node lies at a distinct distance from it, so by the triangle
+// inequality a search only descends into the children within maxDistance of
+// the distance between the node and the hash looked for.
+//
+// Both the upright and the turned pHashes of an image are indexed, so the
+// candidates of a lookup include the images that match in any orientation.
+type PHashIndex struct {
+	nodes []phashNode
+}
+
+// phashNode holds one distinct hash and the images that have it.
+type phashNode struct {
+	hash     uint64
+	ids      []int
+	parent   int // index of the parent node; -1 for the root
+	children []phashEdge
+}
+
+// phashEdge links a node to a child at the given distance.
+type phashEdge struct {
+	distance int
+	node     int
+}
+
+// NewPHashIndex returns an empty index.
+func NewPHashIndex() *PHashIndex {
+	return &PHashIndex{}
+}
+
+// Len returns the number of distinct hashes in the index.
+func (x *PHashIndex) Len() int {
+	return len(x.nodes)
+}
+
+// Add indexes the pHashes of the image with the given ID.
+func (x *PHashIndex) Add(id int, p PHashes) {
+	if p.Upright == nil {
+		return
+	}
+	x.add(p.Upright.GetHash(), id)
+	for _, hash := range p.turned {
+		x.add(hash.GetHash(), id)
+	}
+}
+
+func (x *PHashIndex) add(hash uint64, id int) {
+	if len(x.nodes) == 0 {
+		x.nodes = append(x.nodes, phashNode{hash: hash, ids: []int{id}, parent: -1})
+		return
+	}
+	n := 0
+	for {
+		d := bits.OnesCount64(x.nodes[n].hash ^ hash)
+		if d == 0 {
+			ids := x.nodes[n].ids
+			if ids[len(ids)-1] != id { // the turned hashes of a symmetric image repeat
+				x.nodes[n].ids = append(ids, id)
+			}
+			return
+		}
+		child := -1
+		for _, edge := range x.nodes[n].children {
+			if edge.distance == d {
+				child = edge.node
+				break
+			}
+		}
+		if child < 0 {
+			x.nodes = append(x.nodes, phashNode{hash: hash, ids: []int{id}, parent: n})
+			x.nodes[n].children = append(x.nodes[n].children, phashEdge{distance: d, node: len(x.nodes) - 1})
+			return
+		}
+		n = child
+	}
+}
+
+// Candidates returns the IDs, in ascending order, of the images with an upright
+// or turned pHash within maxDistance of the upright pHash of p. Which of them
+// match is left to PHashes.Distance, as it depends on the orientations the
+// sizes of two images allow.
+func (x *PHashIndex) Candidates(p PHashes, maxDistance int) []int {
+	if p.Upright == nil || len(x.nodes) == 0 {
+		return nil
+	}
+	hash := p.Upright.GetHash()
+	found := make(map[int]bool)
+	pending := []int{0}
+	for len(pending) > 0 {
+		node := &x.nodes[pending[len(pending)-1]]
+		pending = pending[:len(pending)-1]
+		d := bits.OnesCount64(node.hash ^ hash)
+		if d <= maxDistance {
+			for _, id := range node.ids {
+				found[id] = true
+			}
+		}
+		for _, edge := range node.children {
+			if edge.distance >= d-maxDistance && edge.distance <= d+maxDistance {
+				pending = append(pending, edge.node)
+			}
+		}
+	}
+	ids := make([]int, 0, len(found))
+	for id := range found {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// MarshalBinary encodes the index so that it can be stored and loaded again
+// without inserting every hash anew. The nodes are written in the order they
+// were added, each with its parent, so the tree is rebuilt as it was.
+func (x *PHashIndex) MarshalBinary() ([]byte, error) {
+	data := binary.AppendUvarint(nil, uint64(len(x.nodes)))
+	for _, node := range x.nodes {
+		data = binary.LittleEndian.AppendUint64(data, node.hash)
+		data = binary.AppendVarint(data, int64(node.parent))
+		data = binary.AppendUvarint(data, uint64(len(node.ids)))
+		for _, id := range node.ids {
+			data = binary.AppendVarint(data, int64(id))
+		}
+	}
+	return data, nil
+}
+
+// errCorruptPHashIndex is returned for data MarshalBinary did not write.
+var errCorruptPHashIndex = errors.New("corrupt pHash index")
+
+// UnmarshalBinary replaces the index with one encoded by MarshalBinary.
+func (x *PHashIndex) UnmarshalBinary(data []byte) error {
+	count, n := binary.Uvarint(data)
+	if n <= 0 || count > uint64(len(data)) {
+		return errCorruptPHashIndex
+	}
+	data = data[n:]
+	nodes := make([]phashNode, 0, count)
+	for i := 0; i < int(count); i++ {
+		if len(data) < 8 {
+			return errCorruptPHashIndex
+		}
+		node := phashNode{hash: binary.LittleEndian.Uint64(data)}
+		data = data[8:]
+		parent, n := binary.Varint(data)
+		if n <= 0 || parent < -1 || parent >= int64(i) || (parent == -1) != (i == 0) {
+			return errCorruptPHashIndex
+		}
+		node.parent = int(parent)
+		data = data[n:]
+		idCount, n := binary.Uvarint(data)
+		if n <= 0 || idCount == 0 || idCount > uint64(len(data)) {
+			return errCorruptPHashIndex
+		}
+		data = data[n:]
+		for ; idCount > 0; idCount-- {
+			id, n := binary.Varint(data)
+			if n <= 0 {
+				return errCorruptPHashIndex
+			}
+			node.ids = append(node.ids, int(id))
+			data = data[n:]
+		}
+		if i > 0 {
+			parent := &nodes[node.parent]
+			parent.children = append(parent.children, phashEdge{distance: bits.OnesCount64(parent.hash ^ node.hash), node: i})
+		}
+		nodes = append(nodes, node)
+	}
+	if len(data) != 0 {
+		return errCorruptPHashIndex
+	}
+	x.nodes = nodes
+	return nil
+}
@@ -2,8 +2,7 @@ package processor
 
 import (
 	"bytes"
-	"crypto/md5"
-	"encoding/hex"
+	"context"
 	"fmt"
 	"image"
 	"image/color"
@@ -16,48 +15,166 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+	"unicode"
+
+	"picpurge/util"
+	"picpurge/walker"
 
-	"github.com/chai2010/webp"         // Import webp encoder
 	"github.com/corona10/goimagehash"  // Import goimagehash
 	"github.com/nfnt/resize"           // Import for image resizing
 	"github.com/rwcarlsen/goexif/exif" // Import goexif
+	"github.com/rwcarlsen/goexif/tiff"
 )
 
+// readLimiter throttles and counts file reads done by ProcessImage. Nil means unlimited.
+var readLimiter *util.RateLimiter
+
+// SetReadLimiter installs a limiter shared by all subsequent ProcessImage calls.
+func SetReadLimiter(l *util.RateLimiter) {
+	readLimiter = l
+}
+
+// readLimitedFile reads a file through readLimiter, for files too large to be
+// buffered by ReadImageFile.
+type readLimitedFile struct {
+	*os.File
+}
+
+func (f readLimitedFile) Read(p []byte) (int, error) {
+	return readLimiter.Reader(f.File).Read(p)
+}
+
+// contextReader stops reading with ctx's error once ctx is cancelled, so a
+// large file being hashed does not hold up an interrupted scan.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
 // ImageData represents the extracted metadata for an image.
 type ImageData struct {
 	FilePath      string
 	FileName      string
 	FileSize      int64
-	MD5           string
+	ModTime       time.Time // file modification time when the image was processed
+	MD5           string    // content digest in the form of util.FormatDigest, of the algorithm set by SetHashAlgo
 	ImageWidth    int
 	ImageHeight   int
 	DeviceMake    string
 	DeviceModel   string
 	LensModel     string
+	CameraSerial  string
+	ShutterCount  int64
 	CreateDate    time.Time
+	DateSource    string   // where CreateDate came from, one of the DateSource constants
+	ExposureBias  *float64 // EV compensation from EXIF, nil when absent
 	PHash         string
+	TurnedPHashes string   // pHashes of the image turned and mirrored, so rotated copies are matched
+	DHash         string   // difference hash, can confirm a pHash match
+	AHash         string   // average hash, can confirm a pHash match
+	WHash         string   // wavelet hash, can confirm a pHash match
+	LeftEdgeHash  string   // average hash of the left strip, for panorama detection
+	RightEdgeHash string   // average hash of the right strip
+	Palette       []string // most common colours as #rrggbb, dominant first
+	LowInfo       string   // set to a LowInfo constant for blank, black or white shots
 	ThumbnailPath string
+	IsScreenshot  bool
+	IsDocument    bool    // looks like a photographed or scanned document or receipt
+	Messenger     string  // set to a Messenger constant for media saved from a chat app
+	EditedWith    string  // editing app named by the EXIF Software tag, e.g. Photoshop; empty for camera originals
+	RawPending    bool    // the thumbnail is a placeholder until RawThumbnail renders the RAW file
+	Duration      float64 // length of a video in seconds, 0 for images
+	Format        string  // format found in the file's content, e.g. jpeg or heic; empty when not recognized
+}
+
+// ThumbnailSource holds the decoded image (or RAW preview data) needed to build a
+// thumbnail, so thumbnail encoding can run separately from hashing.
+type ThumbnailSource struct {
+	filePath string
+	img      image.Image
+	exif     *exif.Exif // used for the embedded preview of RAW files
+	raw      bool
+	video    bool // img is a frame grabbed from a video
 }
 
 // ProcessImage extracts metadata from a given image file and returns thumbnail data.
 func ProcessImage(filePath string) (*ImageData, []byte, error) {
-	// --- Calculate MD5 hash ---
-	fileForMD5, err := os.Open(filePath)
+	imageData, source, err := AnalyzeImage(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return imageData, source.Encode(imageData), nil
+}
+
+// AnalyzeImage hashes an image file and extracts its metadata. The returned source
+// is turned into a thumbnail with Encode.
+func AnalyzeImage(filePath string) (*ImageData, *ThumbnailSource, error) {
+	file, err := ReadImageFile(context.Background(), filePath)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to open file for MD5: %w", err)
+		return nil, nil, err
 	}
-	defer fileForMD5.Close()
+	return file.Analyze()
+}
+
+// maxBufferedFile is the largest file ReadImageFile keeps in memory. Larger
+// files are copied to a temporary file on the way instead, so that Analyze
+// does not read them from their disk, which may be a slow network share, again.
+// It is a variable so tests can lower it.
+var maxBufferedFile int64 = 128 << 20
 
-	hash := md5.New()
-	if _, err := io.Copy(hash, fileForMD5); err != nil {
-		return nil, nil, fmt.Errorf("failed to calculate MD5: %w", err)
+// LoadedFile is an image file that was read and hashed by ReadImageFile and
+// waits to be decoded by Analyze.
+type LoadedFile struct {
+	imageData *ImageData
+	content   []byte // nil when the file is too large to keep or is a video
+	spill     string // temporary copy of a file too large to keep, removed by Analyze or Close
+}
+
+// ReadImageFile reads a file once, hashing it on the way, and keeps its content
+// for Analyze, which decodes it and reads its metadata without reading the file
+// again. Videos are only hashed, as ffmpeg reads them itself. It is the
+// IO-bound half of AnalyzeImage, so reading and decoding can run in pools of
+// different sizes. Reading stops with ctx's error once ctx is cancelled.
+func ReadImageFile(ctx context.Context, filePath string) (*LoadedFile, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file for MD5: %w", err)
 	}
-	md5Hash := hex.EncodeToString(hash.Sum(nil))
+	defer file.Close()
 
 	// Get file info for size and creation date (from file system)
-	fileInfo, err := os.Stat(filePath)
+	fileInfo, err := file.Stat()
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get file info: %w", err)
+		return nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	hash := util.NewHash(contentHash)
+	reader := contextReader{ctx, readLimiter.Reader(file)}
+	var content []byte
+	var spill string
+	switch {
+	case walker.IsVideoFile(filePath):
+		if _, err := io.Copy(hash, reader); err != nil {
+			return nil, fmt.Errorf("failed to calculate MD5: %w", err)
+		}
+	case fileInfo.Size() <= maxBufferedFile:
+		var buf bytes.Buffer
+		buf.Grow(int(fileInfo.Size()))
+		if _, err := io.Copy(io.MultiWriter(hash, &buf), reader); err != nil {
+			return nil, fmt.Errorf("failed to calculate MD5: %w", err)
+		}
+		content = buf.Bytes()
+	default:
+		if spill, err = spillFile(hash, reader, filePath); err != nil {
+			return nil, err
+		}
 	}
 
 	// Initialize imageData with basic info
@@ -65,32 +182,117 @@ func ProcessImage(filePath string) (*ImageData, []byte, error) {
 		FilePath:   filePath,
 		FileName:   fileInfo.Name(),
 		FileSize:   fileInfo.Size(),
-		MD5:        md5Hash,
+		ModTime:    fileInfo.ModTime(),
+		MD5:        util.FormatDigest(contentHash, hash.Sum(nil)),
 		CreateDate: fileInfo.ModTime(), // Default to file modification time
+		DateSource: DateSourceModTime,
+	}
+	// The extension of a misnamed file says nothing about how to read it
+	if content != nil {
+		imageData.Format = walker.SniffFormat(content)
+	} else if spill != "" {
+		if format, err := walker.DetectFormat(spill); err == nil {
+			imageData.Format = format
+		}
+	} else if format, err := walker.DetectFormat(filePath); err == nil {
+		imageData.Format = format
 	}
+	return &LoadedFile{imageData: imageData, content: content, spill: spill}, nil
+}
 
-	// --- Try to decode image ---
-	fileForImage, err := os.Open(filePath)
+// spillFile copies a file being hashed to a temporary file and returns its path.
+func spillFile(hash io.Writer, reader io.Reader, filePath string) (string, error) {
+	spill, err := os.CreateTemp("", "picpurge-read-*"+filepath.Ext(filePath))
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary copy: %w", err)
+	}
+	_, err = io.Copy(io.MultiWriter(hash, spill), reader)
+	if closeErr := spill.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to open file for image processing: %w", err)
+		os.Remove(spill.Name())
+		return "", fmt.Errorf("failed to calculate MD5: %w", err)
+	}
+	return spill.Name(), nil
+}
+
+// FilePath returns the path the file was read from.
+func (f *LoadedFile) FilePath() string {
+	return f.imageData.FilePath
+}
+
+// Close releases what ReadImageFile kept of a file that will not be analyzed.
+func (f *LoadedFile) Close() {
+	f.content = nil
+	if f.spill != "" {
+		os.Remove(f.spill)
+		f.spill = ""
+	}
+}
+
+// imageReader is what decoding needs from a file or its buffered content.
+type imageReader interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+}
+
+// Analyze decodes a file read by ReadImageFile and extracts its metadata and
+// perceptual hashes. It is the CPU-bound half of AnalyzeImage.
+func (f *LoadedFile) Analyze() (*ImageData, *ThumbnailSource, error) {
+	defer f.Close()
+	imageData := f.imageData
+	filePath := imageData.FilePath
+	if date, ok := DateFromFileName(imageData.FileName); ok {
+		imageData.CreateDate, imageData.DateSource = date, DateSourceFileName
+	}
+	if walker.IsVideo(filePath, imageData.Format) {
+		return analyzeVideo(imageData)
+	}
+
+	// --- Try to decode image ---
+	var fileForImage imageReader
+	if f.content != nil {
+		fileForImage = bytes.NewReader(f.content)
+		f.content = nil // the decoded image takes over; let the bytes go with the reader
+	} else if f.spill != "" {
+		file, err := os.Open(f.spill)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open file for image processing: %w", err)
+		}
+		defer file.Close()
+		fileForImage = file // A local copy, not throttled
+	} else {
+		file, err := os.Open(filePath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open file for image processing: %w", err)
+		}
+		defer file.Close()
+		fileForImage = readLimitedFile{file}
 	}
-	defer fileForImage.Close()
 
 	var img image.Image
-	ext := strings.ToLower(filepath.Ext(filePath))
-
-	// For RAW formats like CR2, we won't be able to decode them with standard library
-	// but we can still extract EXIF data
-	if ext == ".cr2" {
-		// For CR2 files, we can't decode them with standard library
-		// Set default dimensions and skip thumbnail generation
-		imageData.ImageWidth = 0
-		imageData.ImageHeight = 0
+	var err error
+
+	// RAW formats can't be decoded with the standard library, but nearly all of
+	// them embed a JPEG preview that serves for hashing and the thumbnail
+	raw := walker.IsRaw(filePath, imageData.Format)
+	if raw {
+		var width, height int
+		img, width, height, err = decodeRawPreview(fileForImage, imageData.FileSize)
+		if err != nil {
+			log.Printf("Warning: Could not extract a preview from RAW file %s: %v. Proceeding with EXIF extraction only.\n", filePath, err)
+		}
+		imageData.ImageWidth, imageData.ImageHeight = width, height
 	} else {
 		// Decode image to get dimensions and for thumbnail generation
 		img, _, err = image.Decode(fileForImage)
 		if err != nil {
 			// For unsupported formats, we'll still process EXIF data but skip image processing
+			if imageData.Format != "" {
+				err = fmt.Errorf("%w (content is %s)", err, imageData.Format)
+			}
 			log.Printf("Warning: Could not decode image %s: %v. Proceeding with EXIF extraction only.\n", filePath, err)
 			imageData.ImageWidth = 0
 			imageData.ImageHeight = 0
@@ -111,17 +313,33 @@ func ProcessImage(filePath string) (*ImageData, []byte, error) {
 	if err == nil {
 		// Camera Make
 		if makeTag, err := x.Get(exif.Make); err == nil {
-			imageData.DeviceMake = makeTag.String()
+			imageData.DeviceMake = exifText(makeTag)
 		}
 		// Camera Model
 		if modelTag, err := x.Get(exif.Model); err == nil {
-			imageData.DeviceModel = modelTag.String()
+			imageData.DeviceModel = exifText(modelTag)
 		}
 		// Lens Model (often in LensModel or LensMake)
 		if lensTag, err := x.Get(exif.LensModel); err == nil {
-			imageData.LensModel = lensTag.String()
+			imageData.LensModel = exifText(lensTag)
 		} else if lensTag, err := x.Get(exif.LensMake); err == nil {
-			imageData.LensModel = lensTag.String()
+			imageData.LensModel = exifText(lensTag)
+		}
+
+		// Editing apps name themselves in the Software tag, cameras write their firmware version
+		if softwareTag, err := x.Get(exif.Software); err == nil {
+			imageData.EditedWith = EditorOf(exifText(softwareTag))
+		}
+
+		// Camera serial number and shutter count, used to tell copies from re-shoots
+		imageData.CameraSerial, imageData.ShutterCount = extractCameraIdentity(x)
+
+		// Exposure compensation, used to recognize exposure bracket sets
+		if evTag, err := x.Get(exif.ExposureBiasValue); err == nil {
+			if num, den, err := evTag.Rat2(0); err == nil && den != 0 {
+				bias := float64(num) / float64(den)
+				imageData.ExposureBias = &bias
+			}
 		}
 
 		// DateTimeOriginal (creation date from EXIF)
@@ -131,7 +349,7 @@ func ProcessImage(filePath string) (*ImageData, []byte, error) {
 			dt = strings.TrimSuffix(dt, "\"")
 			parsedTime, parseErr := time.Parse("2006:01:02 15:04:05", dt)
 			if parseErr == nil {
-				imageData.CreateDate = parsedTime
+				imageData.CreateDate, imageData.DateSource = parsedTime, DateSourceEXIF
 			} else {
 				log.Printf("Warning: Error parsing EXIF DateTimeOriginal '%s' for %s: %v\n", dt, filePath, parseErr)
 			}
@@ -148,59 +366,108 @@ func ProcessImage(filePath string) (*ImageData, []byte, error) {
 			imageData.PHash = "" // Set to empty string if pHash calculation fails
 		} else {
 			imageData.PHash = phash.ToString() // Convert hash to string
+			imageData.TurnedPHashes = turnedPHashes(img, filePath)
+		}
+		if dhash, err := goimagehash.DifferenceHash(img); err != nil {
+			log.Printf("Warning: Could not calculate dHash for %s: %v\n", filePath, err)
+		} else {
+			imageData.DHash = dhash.ToString()
 		}
+		if ahash, err := goimagehash.AverageHash(img); err != nil {
+			log.Printf("Warning: Could not calculate aHash for %s: %v\n", filePath, err)
+		} else {
+			imageData.AHash = ahash.ToString()
+		}
+		imageData.WHash = waveletHash(img).ToString()
+		imageData.LeftEdgeHash, imageData.RightEdgeHash = edgeHashes(img, filePath)
+		imageData.Palette = Palette(img)
 	} else {
 		imageData.PHash = ""
 	}
 
-	// --- Generate Thumbnail (WebP) ---
+	imageData.IsScreenshot = IsScreenshot(imageData.FileName, imageData.DeviceMake, imageData.DeviceModel)
+	imageData.Messenger = MessengerOf(filePath)
+
+	return imageData, &ThumbnailSource{filePath: filePath, img: img, exif: x, raw: raw}, nil
+}
+
+// Encode builds the thumbnail and records its reference in imageData.
+// It returns nil when no thumbnail can be made.
+func (src *ThumbnailSource) Encode(imageData *ImageData) []byte {
+	filePath, img, x := src.filePath, src.img, src.exif
+
+	// --- Generate Thumbnail ---
 	var thumbnailData []byte
 	if img != nil {
 		// Resize the image to 320x320 (or smaller if original is smaller)
 		thumbnail := resize.Thumbnail(320, 320, img, resize.Lanczos3)
+		if !src.video {
+			// A dark frame says nothing about the rest of a video
+			imageData.LowInfo = ClassifyLowInfo(thumbnail)
+			imageData.IsDocument = imageData.LowInfo == "" && IsDocument(thumbnail)
+		}
 
-		// Encode thumbnail to WebP
-		var buf bytes.Buffer
-		if err := webp.Encode(&buf, thumbnail, &webp.Options{Lossless: false, Quality: 80}); err != nil { // Encode to WebP
-			log.Printf("Warning: Could not generate WebP thumbnail for %s: %v\n", filePath, err)
+		// Encode the thumbnail, as WebP unless built with purego
+		if encoded, err := encodeThumbnail(thumbnail); err != nil {
+			log.Printf("Warning: Could not generate thumbnail for %s: %v\n", filePath, err)
 			thumbnailData = nil // Set to nil if encoding fails
 		} else {
-			thumbnailData = buf.Bytes()
+			thumbnailData = encoded
 			// Set ThumbnailPath to a reference, e.g., "memory://<MD5>"
 			imageData.ThumbnailPath = fmt.Sprintf("memory://%s", imageData.MD5)
 		}
-	} else if ext == ".cr2" && x != nil {
-		// For CR2 files, try to extract embedded thumbnail from EXIF
-		thumbnailData = extractEXIFThumbnail(x, filePath)
+	} else if src.raw {
+		// For RAW files without a usable preview, try the EXIF thumbnail
+		if x != nil {
+			thumbnailData = extractEXIFThumbnail(x, filePath)
+		}
 		if thumbnailData != nil {
-			// Convert JPEG thumbnail to WebP
+			// Re-encode the JPEG thumbnail like the others
 			thumbnailImg, err := jpeg.Decode(bytes.NewReader(thumbnailData))
 			if err == nil {
 				// Resize the thumbnail to 320x320
 				resizedThumb := resize.Thumbnail(320, 320, thumbnailImg, resize.Lanczos3)
+				imageData.LowInfo = ClassifyLowInfo(resizedThumb)
+				imageData.IsDocument = imageData.LowInfo == "" && IsDocument(resizedThumb)
 
-				// Encode to WebP
-				var webpBuf bytes.Buffer
-				if err := webp.Encode(&webpBuf, resizedThumb, &webp.Options{Lossless: false, Quality: 80}); err == nil {
-					thumbnailData = webpBuf.Bytes()
+				// Encode the resized thumbnail
+				if encoded, err := encodeThumbnail(resizedThumb); err == nil {
+					thumbnailData = encoded
 					imageData.ThumbnailPath = fmt.Sprintf("memory://%s", imageData.MD5)
 				} else {
-					log.Printf("Warning: Could not encode CR2 thumbnail to WebP for %s: %v\n", filePath, err)
+					log.Printf("Warning: Could not encode RAW thumbnail for %s: %v\n", filePath, err)
 				}
 			} else {
-				log.Printf("Warning: Could not decode CR2 thumbnail for %s: %v\n", filePath, err)
+				log.Printf("Warning: Could not decode RAW thumbnail for %s: %v\n", filePath, err)
 			}
 		} else {
-			// Generate a placeholder thumbnail for CR2 files
+			// Show a placeholder until the conversion queue renders the file
 			thumbnailData = generatePlaceholderThumbnail(320, 320)
 			imageData.ThumbnailPath = fmt.Sprintf("memory://%s", imageData.MD5)
+			imageData.RawPending = true
 		}
 	} else {
 		thumbnailData = nil
 		imageData.ThumbnailPath = ""
 	}
 
-	return imageData, thumbnailData, nil
+	return thumbnailData
+}
+
+// exifText formats a text tag the way tiff.Tag.String does, quoted and without
+// unprintable characters, which is how catalogs store camera names. Unlike
+// String it keeps strings written in GBK or Shift-JIS by converting them to UTF-8.
+func exifText(tag *tiff.Tag) string {
+	text, err := tag.StringVal()
+	if err != nil {
+		return tag.String()
+	}
+	return `"` + strings.Map(func(r rune) rune {
+		if unicode.IsPrint(r) {
+			return r
+		}
+		return -1
+	}, util.DecodeText([]byte(text))) + `"`
 }
 
 // extractEXIFThumbnail extracts thumbnail from EXIF data if available
@@ -227,12 +494,12 @@ func generatePlaceholderThumbnail(width, height int) []byte {
 		}
 	}
 
-	// Encode to WebP
-	var buf bytes.Buffer
-	if err := webp.Encode(&buf, img, &webp.Options{Lossless: false, Quality: 80}); err != nil {
+	// Encode the placeholder
+	data, err := encodeThumbnail(img)
+	if err != nil {
 		log.Printf("Warning: Could not encode placeholder thumbnail: %v\n", err)
 		return nil
 	}
 
-	return buf.Bytes()
+	return data
 }
@@ -1,12 +1,22 @@
 package processor
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
 	"image"
 	"image/color"
+	"image/jpeg"
 	"image/png"
+	"math"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/corona10/goimagehash"
 )
 
 func TestProcessImage(t *testing.T) {
@@ -61,7 +71,692 @@ func TestProcessImage(t *testing.T) {
 		t.Errorf("ImageHeight mismatch. Expected: 100, Got: %d", imageData.ImageHeight)
 	}
 
+	if imageData.Format != "png" {
+		t.Errorf("Format mismatch. Expected: png, Got: %s", imageData.Format)
+	}
+
 	if thumbnailData == nil {
 		t.Error("ThumbnailData is nil")
 	}
 }
+
+func TestReadImageFileOnce(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	for x := 0; x < 40; x++ {
+		img.Set(x, x%30, color.RGBA{0, 0, 255, 255})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode PNG image: %v", err)
+	}
+	imagePath := filepath.Join(t.TempDir(), "large.png")
+	if err := os.WriteFile(imagePath, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write test image: %v", err)
+	}
+	buffered, _, err := AnalyzeImage(imagePath)
+	if err != nil {
+		t.Fatalf("AnalyzeImage failed: %v", err)
+	}
+
+	// Too large to keep in memory: the file is copied while it is hashed
+	defer func(limit int64) { maxBufferedFile = limit }(maxBufferedFile)
+	maxBufferedFile = 0
+	file, err := ReadImageFile(context.Background(), imagePath)
+	if err != nil {
+		t.Fatalf("ReadImageFile failed: %v", err)
+	}
+	if file.spill == "" {
+		t.Fatal("ReadImageFile kept no copy of a large file")
+	}
+	spill := file.spill
+	imageData, _, err := file.Analyze()
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if imageData.MD5 != buffered.MD5 || imageData.PHash != buffered.PHash || imageData.Format != "png" ||
+		imageData.ImageWidth != 40 || imageData.ImageHeight != 30 {
+		t.Errorf("Analyze of a copied file = %+v; expected the same as buffered %+v", imageData, buffered)
+	}
+	if _, err := os.Stat(spill); !os.IsNotExist(err) {
+		t.Errorf("Temporary copy %s was not removed: %v", spill, err)
+	}
+}
+
+func TestCompareShots(t *testing.T) {
+	testCases := []struct {
+		serialA, serialB string
+		countA, countB   int64
+		expected         ShotRelation
+	}{
+		{"123", "123", 42, 42, ShotSame},
+		{"123", "123", 42, 43, ShotReshoot},
+		{"123", "456", 42, 42, ShotUnknown},
+		{"", "", 42, 42, ShotUnknown},
+		{"123", "123", 0, 42, ShotUnknown},
+	}
+
+	for _, tc := range testCases {
+		result := CompareShots(tc.serialA, tc.countA, tc.serialB, tc.countB)
+		if result != tc.expected {
+			t.Errorf("CompareShots(%s, %d, %s, %d) = %v; expected %v", tc.serialA, tc.countA, tc.serialB, tc.countB, result, tc.expected)
+		}
+	}
+}
+
+func TestIsScreenshot(t *testing.T) {
+	testCases := []struct {
+		fileName, deviceMake, deviceModel string
+		expected                          bool
+	}{
+		{"Screenshot_20230501-120000.jpg", "", "", true},
+		{"Screen Shot 2023-05-01 at 12.00.00.png", "", "", true},
+		{"capture.png", "", "", true},
+		{"IMG_0001.png", "Apple", "iPhone 12", false},
+		{"IMG_0001.jpg", "", "", false},
+	}
+
+	for _, tc := range testCases {
+		result := IsScreenshot(tc.fileName, tc.deviceMake, tc.deviceModel)
+		if result != tc.expected {
+			t.Errorf("IsScreenshot(%s, %s, %s) = %v; expected %v", tc.fileName, tc.deviceMake, tc.deviceModel, result, tc.expected)
+		}
+	}
+}
+
+func TestSimilarityMeasures(t *testing.T) {
+	if delta := AspectDelta(400, 300, 800, 600); delta != 0 {
+		t.Errorf("AspectDelta for equal ratios = %f; expected 0", delta)
+	}
+	if delta := AspectDelta(400, 300, 0, 0); delta != 1 {
+		t.Errorf("AspectDelta with unknown size = %f; expected 1", delta)
+	}
+	if ratio := SizeRatio(400, 300, 800, 600); ratio != 0.25 {
+		t.Errorf("SizeRatio = %f; expected 0.25", ratio)
+	}
+	if ratio := SizeRatio(400, 300, 0, 600); ratio != 0 {
+		t.Errorf("SizeRatio with unknown size = %f; expected 0", ratio)
+	}
+}
+
+func TestTurnedPHashes(t *testing.T) {
+	// A picture with structure: a gradient sky, a dark block and a bright disc
+	upright := image.NewRGBA(image.Rect(0, 0, 300, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 300; x++ {
+			c := color.RGBA{uint8(y), uint8(100 + y/2), 220, 255}
+			if x > 180 && y > 120 {
+				c = color.RGBA{30, 40, 20, 255}
+			}
+			if (x-80)*(x-80)+(y-60)*(y-60) < 900 {
+				c = color.RGBA{250, 240, 200, 255}
+			}
+			upright.Set(x, y, c)
+		}
+	}
+	// The same picture turned clockwise, and mirrored
+	clockwise := image.NewRGBA(image.Rect(0, 0, 200, 300))
+	mirrored := image.NewRGBA(image.Rect(0, 0, 300, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 300; x++ {
+			clockwise.Set(199-y, x, upright.At(x, y))
+			mirrored.Set(299-x, y, upright.At(x, y))
+		}
+	}
+
+	hashes := func(img image.Image) PHashes {
+		hash, err := goimagehash.PerceptionHash(img)
+		if err != nil {
+			t.Fatalf("PerceptionHash failed: %v", err)
+		}
+		p, err := ParsePHashes(hash.ToString(), turnedPHashes(img, "test.png"))
+		if err != nil {
+			t.Fatalf("ParsePHashes failed: %v", err)
+		}
+		return p
+	}
+	original := hashes(upright)
+	for name, copied := range map[string]image.Image{"clockwise": clockwise, "mirrored": mirrored} {
+		other := hashes(copied)
+		b := copied.Bounds()
+		uprightOK, sideways := Orientations(300, 200, b.Dx(), b.Dy())
+		if plain, _ := original.Distance(PHashes{Upright: other.Upright}, true, true); plain <= PHashThreshold {
+			t.Errorf("%s copy matches upright at distance %d; the test picture is too plain", name, plain)
+		}
+		if d, turned := original.Distance(other, uprightOK, sideways); d > PHashThreshold || !turned {
+			t.Errorf("%s copy: distance %d, turned %v; expected a turned match within %d", name, d, turned, PHashThreshold)
+		}
+	}
+
+	// Catalogued before turned pHashes were taken: upright only
+	if d, _ := original.Distance(PHashes{Upright: original.Upright}, false, true); d != math.MaxInt {
+		t.Errorf("Distance without turned pHashes sideways = %d; expected none", d)
+	}
+}
+
+func TestPHashIndex(t *testing.T) {
+	// Hashes in clusters, so lookups find a few neighbours among many others
+	random := rand.New(rand.NewSource(1))
+	pHash := func(hash uint64) *goimagehash.ImageHash { return goimagehash.NewImageHash(hash, goimagehash.PHash) }
+	flip := func(hash uint64, n int) uint64 {
+		for i := 0; i < n; i++ {
+			hash ^= 1 << random.Intn(64)
+		}
+		return hash
+	}
+	centres := make([]uint64, 20)
+	for i := range centres {
+		centres[i] = random.Uint64()
+	}
+	images := make(map[int]PHashes)
+	index := NewPHashIndex()
+	for id := 1; id <= 500; id++ {
+		p := PHashes{Upright: pHash(flip(centres[id%len(centres)], random.Intn(6)))}
+		if id%3 == 0 { // catalogued with turned pHashes, one of them near another cluster
+			for range turns {
+				p.turned = append(p.turned, pHash(random.Uint64()))
+			}
+			p.turned[id%len(turns)] = pHash(flip(centres[(id+1)%len(centres)], 1))
+		}
+		images[id] = p
+		index.Add(id, p)
+	}
+	index.Add(501, PHashes{}) // no pHash, not indexed
+
+	data, err := index.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	loaded := NewPHashIndex()
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %v", err)
+	}
+	if loaded.Len() != index.Len() {
+		t.Errorf("Loaded %d hashes; expected %d", loaded.Len(), index.Len())
+	}
+	if err := loaded.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Errorf("UnmarshalBinary accepted truncated data")
+	}
+
+	// Every lookup finds exactly the images a full comparison finds
+	for _, centre := range centres[:5] {
+		query := PHashes{Upright: pHash(flip(centre, 2))}
+		for _, maxDistance := range []int{0, PHashThreshold, 10} {
+			var expected []int
+			for id := 1; id <= 500; id++ {
+				if d, _ := query.Distance(images[id], true, true); d <= maxDistance {
+					expected = append(expected, id)
+				}
+			}
+			for name, x := range map[string]*PHashIndex{"built": index, "loaded": loaded} {
+				if got := x.Candidates(query, maxDistance); fmt.Sprint(got) != fmt.Sprint(expected) {
+					t.Errorf("%s index: Candidates within %d = %v; expected %v", name, maxDistance, got, expected)
+				}
+			}
+		}
+	}
+	if got := NewPHashIndex().Candidates(images[1], 64); len(got) != 0 {
+		t.Errorf("Empty index found %v", got)
+	}
+}
+
+func TestConfirmingHashes(t *testing.T) {
+	gradient := image.NewGray(image.Rect(0, 0, 128, 96))
+	inverted := image.NewGray(gradient.Bounds())
+	for y := 0; y < 96; y++ {
+		for x := 0; x < 128; x++ {
+			gradient.SetGray(x, y, color.Gray{uint8(x + y)})
+			inverted.SetGray(x, y, color.Gray{uint8(255 - x - y)})
+		}
+	}
+	if d, _ := waveletHash(gradient).Distance(waveletHash(inverted)); d <= WHashThreshold {
+		t.Errorf("Wavelet hashes of an image and its negative differ by %d bits; expected more than %d", d, WHashThreshold)
+	}
+
+	hashes := ParseConfirmingHashes("d:00000000000000ff", "a:ffff000000000000", "w:ffff000000000000")
+	other := ParseConfirmingHashes("d:00000000000000ff", "a:0000ffffffff0000", "")
+	defer SetConfirmHashes(0)
+	for n, expected := range []bool{true, true, false, false} {
+		if err := SetConfirmHashes(n); err != nil {
+			t.Fatalf("SetConfirmHashes(%d) failed: %v", n, err)
+		}
+		if confirmed := hashes.Confirm(other); confirmed != expected {
+			t.Errorf("Confirm with %d hashes required = %v; expected %v, as only the dHashes agree", n, confirmed, expected)
+		}
+	}
+	if err := SetConfirmHashes(4); err == nil {
+		t.Error("SetConfirmHashes(4) succeeded; there are only three other hashes")
+	}
+}
+
+func TestDateFromFileName(t *testing.T) {
+	cases := map[string]string{
+		"IMG_20230501_120000.jpg":    "2023-05-01T12:00:00Z",
+		"PXL_20230501_120000123.jpg": "2023-05-01T12:00:00Z",
+		"2023-05-01 12.00.00.png":    "2023-05-01T12:00:00Z",
+		"IMG-20230501-WA0001.jpg":    "2023-05-01T00:00:00Z",
+		"Screenshot_2023-05-01.png":  "2023-05-01T00:00:00Z",
+		"IMG_1234.jpg":               "",
+		"photo_99991231.jpg":         "",
+		"DSC_120230501999.jpg":       "",
+	}
+	for name, expected := range cases {
+		date, ok := DateFromFileName(name)
+		if expected == "" {
+			if ok {
+				t.Errorf("DateFromFileName(%q) = %v; expected no date", name, date)
+			}
+			continue
+		}
+		if !ok || date.Format(time.RFC3339) != expected {
+			t.Errorf("DateFromFileName(%q) = %v, %v; expected %s", name, date, ok, expected)
+		}
+	}
+}
+
+func TestPalette(t *testing.T) {
+	// Three quarters orange, one quarter blue, with a little noise in the orange
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 100; x++ {
+			c := color.RGBA{255, 102 + uint8(x%3), 0, 255}
+			if x >= 75 {
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	palette := Palette(img)
+	if len(palette) != 2 || palette[1] != "#0000ff" {
+		t.Fatalf("Palette = %v; expected orange then blue", palette)
+	}
+	dominant, err := ParseHexColor(palette[0])
+	if err != nil {
+		t.Fatalf("ParseHexColor(%q) failed: %v", palette[0], err)
+	}
+	orange, _ := ParseHexColor("#ff6600")
+	if d := ColorDistance(dominant, orange); d > 2 {
+		t.Errorf("Dominant colour %s is %.1f away from #ff6600", palette[0], d)
+	}
+
+	if c, err := ParseHexColor("F60"); err != nil || c != orange {
+		t.Errorf("ParseHexColor(F60) = %v, %v; expected %v", c, err, orange)
+	}
+	for _, invalid := range []string{"", "#ff66", "#gg6600"} {
+		if _, err := ParseHexColor(invalid); err == nil {
+			t.Errorf("ParseHexColor(%q) should fail", invalid)
+		}
+	}
+	if d := ColorDistance(color.RGBA{0, 0, 0, 255}, color.RGBA{255, 255, 255, 255}); d != MaxColorDistance {
+		t.Errorf("ColorDistance(black, white) = %f; expected %f", d, MaxColorDistance)
+	}
+}
+
+func TestClassifyLowInfo(t *testing.T) {
+	fill := func(c func(x, y int) color.RGBA) image.Image {
+		img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+		for y := 0; y < 48; y++ {
+			for x := 0; x < 64; x++ {
+				img.Set(x, y, c(x, y))
+			}
+		}
+		return img
+	}
+	cases := map[string]struct {
+		img      image.Image
+		expected string
+	}{
+		"lens cap":  {fill(func(x, y int) color.RGBA { return color.RGBA{uint8(x % 8), uint8(y % 8), 4, 255} }), LowInfoDark},
+		"blown out": {fill(func(x, y int) color.RGBA { return color.RGBA{250, 250, uint8(240 + x%10), 255} }), LowInfoBright},
+		"grey wall": {fill(func(x, y int) color.RGBA { return color.RGBA{128, 128, uint8(126 + x%4), 255} }), LowInfoSolid},
+		"gradient":  {fill(func(x, y int) color.RGBA { return color.RGBA{uint8(x * 4), uint8(y * 5), 100, 255} }), ""},
+		"night scene": {fill(func(x, y int) color.RGBA {
+			v := uint8(0)
+			if x%16 == 0 {
+				v = 255
+			}
+			return color.RGBA{v, v, v, 255}
+		}), ""},
+	}
+	for name, c := range cases {
+		if got := ClassifyLowInfo(c.img); got != c.expected {
+			t.Errorf("%s: ClassifyLowInfo = %q; expected %q", name, got, c.expected)
+		}
+	}
+}
+
+func TestIsDocument(t *testing.T) {
+	fill := func(c func(x, y int) color.RGBA) image.Image {
+		img := image.NewRGBA(image.Rect(0, 0, 240, 320))
+		for y := 0; y < 320; y++ {
+			for x := 0; x < 240; x++ {
+				img.Set(x, y, c(x, y))
+			}
+		}
+		return img
+	}
+	// Lines of glyph-sized strokes on slightly grey paper
+	text := func(x, y int) bool { return y%20 < 10 && x%6 < 2 && x > 20 && x < 220 }
+	cases := map[string]struct {
+		img      image.Image
+		expected bool
+	}{
+		"receipt": {fill(func(x, y int) color.RGBA {
+			if text(x, y) {
+				return color.RGBA{30, 30, 30, 255}
+			}
+			return color.RGBA{235, 232, 225, 255}
+		}), true},
+		"blank page": {fill(func(x, y int) color.RGBA { return color.RGBA{240, 240, 240, 255} }), false},
+		"gradient":   {fill(func(x, y int) color.RGBA { return color.RGBA{uint8(x), uint8(y * 3 / 4), 100, 255} }), false},
+		"chalkboard": {fill(func(x, y int) color.RGBA {
+			if text(x, y) {
+				return color.RGBA{230, 230, 230, 255}
+			}
+			return color.RGBA{30, 60, 40, 255}
+		}), false},
+		"yellow flowers": {fill(func(x, y int) color.RGBA {
+			if text(x, y) {
+				return color.RGBA{40, 90, 20, 255}
+			}
+			return color.RGBA{250, 210, 30, 255}
+		}), false},
+	}
+	for name, c := range cases {
+		if got := IsDocument(c.img); got != c.expected {
+			t.Errorf("%s: IsDocument = %v; expected %v", name, got, c.expected)
+		}
+	}
+}
+
+func TestWriteEXIFDate(t *testing.T) {
+	// A little-endian TIFF block with only IFD0's DateTime tag
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	tiff = binary.LittleEndian.AppendUint16(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 0x0132)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 2) // ASCII
+	tiff = binary.LittleEndian.AppendUint32(tiff, 20)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 26)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 0)
+	tiff = append(tiff, "2020:01:02 03:04:05\x00"...)
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+	data := []byte{0xFF, 0xD8, 0xFF, 0xE1}
+	data = binary.BigEndian.AppendUint16(data, uint16(len(app1)+2))
+	data = append(data, app1...)
+	data = append(data, encoded.Bytes()[2:]...)
+	imagePath := filepath.Join(t.TempDir(), "dated.jpg")
+	if err := os.WriteFile(imagePath, data, 0644); err != nil {
+		t.Fatalf("Failed to write test image: %v", err)
+	}
+
+	written, err := WriteEXIFDate(imagePath, time.Date(2021, 6, 7, 8, 9, 10, 0, time.UTC))
+	if err != nil || written != 1 {
+		t.Fatalf("WriteEXIFDate = %d, %v; expected 1 tag rewritten", written, err)
+	}
+	rewritten, err := os.ReadFile(imagePath)
+	if err != nil {
+		t.Fatalf("Failed to read rewritten image: %v", err)
+	}
+	if len(rewritten) != len(data) || !bytes.Contains(rewritten, []byte("2021:06:07 08:09:10\x00")) {
+		t.Errorf("Date was not patched in place")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(rewritten)); err != nil {
+		t.Errorf("Rewritten file is no longer a valid JPEG: %v", err)
+	}
+}
+
+func TestMessengerOf(t *testing.T) {
+	testCases := map[string]string{
+		"/sdcard/WhatsApp/Media/WhatsApp Images/Sent/IMG-20230501-WA0001.jpg": MessengerWhatsApp,
+		"/backup/phone/IMG-20230501-WA0001.jpg":                               MessengerWhatsApp,
+		"/Users/me/Downloads/WhatsApp Image 2023-05-01 at 12.00.00.jpeg":      MessengerWhatsApp,
+		"/home/me/Downloads/Telegram Desktop/photo_2023-05-01_12-00-00.jpg":   MessengerTelegram,
+		"/sdcard/Pictures/Telegram/123456_121.jpg":                            MessengerTelegram,
+		"/sdcard/Pictures/Signal/signal-2023-05-01-120000.jpg":                MessengerSignal,
+		"/photos/2023/IMG_20230501_120000.jpg":                                "",
+		"/photos/whatsapp-ideas/IMG_0001.jpg":                                 "",
+	}
+	for path, expected := range testCases {
+		if got := MessengerOf(path); got != expected {
+			t.Errorf("MessengerOf(%q) = %q; expected %q", path, got, expected)
+		}
+	}
+}
+
+func TestEditorOf(t *testing.T) {
+	testCases := map[string]string{
+		"Adobe Photoshop 24.1 (Windows)":         "Photoshop",
+		"Adobe Photoshop Lightroom Classic 12.0": "Lightroom",
+		"Snapseed 2.0":                           "Snapseed",
+		"Instagram":                              "Instagram",
+		"GIMP 2.10.34":                           "GIMP",
+		"HDR+ 1.0.498702335zd":                   "",
+		"Ver.1.00":                               "",
+		"16.1.1":                                 "",
+		"":                                       "",
+	}
+	for software, expected := range testCases {
+		if got := EditorOf(software); got != expected {
+			t.Errorf("EditorOf(%q) = %q; expected %q", software, got, expected)
+		}
+	}
+}
+
+func TestParseProbeOutput(t *testing.T) {
+	output := `{
+		"streams": [{"width": 1920, "height": 1080, "tags": {"rotate": "90"}}],
+		"format": {"duration": "12.345000", "tags": {"creation_time": "2023-05-01T12:00:00.000000Z"}}
+	}`
+	info, err := parseProbeOutput([]byte(output))
+	if err != nil {
+		t.Fatalf("parseProbeOutput failed: %v", err)
+	}
+	// Portrait recordings are stored landscape with a rotate tag
+	if info.Width != 1080 || info.Height != 1920 {
+		t.Errorf("Resolution = %dx%d; expected 1080x1920", info.Width, info.Height)
+	}
+	if info.Duration != 12.345 {
+		t.Errorf("Duration = %v; expected 12.345", info.Duration)
+	}
+	if !info.CreatedAt.Equal(time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("CreatedAt = %v; expected 2023-05-01 12:00 UTC", info.CreatedAt)
+	}
+
+	if _, err := parseProbeOutput([]byte(`{"streams": [], "format": {}}`)); err == nil {
+		t.Errorf("Expected an error for a file without video stream")
+	}
+}
+
+func TestProcessVideo(t *testing.T) {
+	// Not a playable video, but it must still be catalogued for exact duplicates
+	videoPath := filepath.Join(t.TempDir(), "clip.mp4")
+	if err := os.WriteFile(videoPath, []byte("not really a video"), 0644); err != nil {
+		t.Fatalf("Failed to write video: %v", err)
+	}
+	imageData, thumbnailData, err := ProcessImage(videoPath)
+	if err != nil {
+		t.Fatalf("ProcessImage failed: %v", err)
+	}
+	if imageData.MD5 == "" {
+		t.Errorf("Video has no MD5")
+	}
+	if imageData.PHash != "" || len(imageData.Palette) != 0 || thumbnailData != nil {
+		t.Errorf("Unreadable video got image data: %+v", imageData)
+	}
+}
+
+func TestProcessExternalFormats(t *testing.T) {
+	defer func(avif, jxl [][]string) { avifConverters, jxlConverters = avif, jxl }(avifConverters, jxlConverters)
+	testCases := []struct {
+		fileName string
+		header   string
+		setup    func(converter []string)
+	}{
+		{"photo.avif", "\x00\x00\x00\x20ftypavif\x00\x00\x00\x00avifmif1miafMA1B", func(c []string) { avifConverters = [][]string{c} }},
+		{"photo.jxl", "\xff\x0a", func(c []string) { jxlConverters = [][]string{c} }},
+	}
+	for _, tc := range testCases {
+		// Stand in for a converter: strip the header to get the PNG behind it
+		tc.setup([]string{"sh", "-c", fmt.Sprintf("tail -c +%d", len(tc.header)+1)})
+
+		var buf bytes.Buffer
+		buf.WriteString(tc.header)
+		img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+		for x := 0; x < 32; x++ {
+			for y := 0; y < 48; y++ {
+				img.Set(x, y, color.RGBA{0, 0, 255, 255})
+			}
+		}
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatalf("Failed to encode PNG image: %v", err)
+		}
+		imagePath := filepath.Join(t.TempDir(), tc.fileName)
+		if err := os.WriteFile(imagePath, buf.Bytes(), 0644); err != nil {
+			t.Fatalf("Failed to write image: %v", err)
+		}
+
+		imageData, thumbnailData, err := ProcessImage(imagePath)
+		if err != nil {
+			t.Fatalf("ProcessImage(%s) failed: %v", tc.fileName, err)
+		}
+		if imageData.ImageWidth != 64 || imageData.ImageHeight != 48 {
+			t.Errorf("%s: dimensions = %dx%d; expected 64x48", tc.fileName, imageData.ImageWidth, imageData.ImageHeight)
+		}
+		if imageData.PHash == "" || thumbnailData == nil {
+			t.Errorf("%s was not decoded: phash %q, thumbnail %d bytes", tc.fileName, imageData.PHash, len(thumbnailData))
+		}
+	}
+}
+
+func TestProcessRawPreview(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for x := 0; x < 32; x++ {
+		for y := 0; y < 48; y++ {
+			img.Set(x, y, color.RGBA{0, 0, 255, 255})
+		}
+	}
+	var preview bytes.Buffer
+	if err := jpeg.Encode(&preview, img, nil); err != nil {
+		t.Fatalf("Failed to encode JPEG image: %v", err)
+	}
+
+	// A TIFF-based RAW file: IFD0 holds the preview, its SubIFD the sensor image
+	writeIFD := func(buf *bytes.Buffer, entries [][3]uint32) {
+		binary.Write(buf, binary.LittleEndian, uint16(len(entries)))
+		for _, e := range entries {
+			binary.Write(buf, binary.LittleEndian, uint16(e[0]))
+			binary.Write(buf, binary.LittleEndian, uint16(e[1]))
+			binary.Write(buf, binary.LittleEndian, uint32(1))
+			binary.Write(buf, binary.LittleEndian, e[2])
+		}
+		binary.Write(buf, binary.LittleEndian, uint32(0))
+	}
+	const ifdSize = 2 + 4*12 + 4
+	var tiff bytes.Buffer
+	tiff.WriteString("II*\x00")
+	binary.Write(&tiff, binary.LittleEndian, uint32(8))
+	writeIFD(&tiff, [][3]uint32{
+		{tagNewSubfileType, 4, 1},
+		{tagSubIFDs, 13, 8 + ifdSize},
+		{tagJPEGOffset, 4, 8 + 2*ifdSize},
+		{tagJPEGLength, 4, uint32(preview.Len())},
+	})
+	writeIFD(&tiff, [][3]uint32{
+		{tagNewSubfileType, 4, 0},
+		{tagImageWidth, 4, 6000},
+		{tagImageLength, 4, 4000},
+		{tagCompression, 3, 7},
+	})
+	tiff.Write(preview.Bytes())
+
+	// A format that is not parsed, found by scanning for the JPEG
+	var other bytes.Buffer
+	other.WriteString("FOVb\x00\x00\x02\x00 sensor data \xff\xd8 not a JPEG ")
+	other.Write(preview.Bytes())
+
+	testCases := []struct {
+		fileName      string
+		data          []byte
+		width, height int
+	}{
+		{"photo.nef", tiff.Bytes(), 6000, 4000},
+		{"photo.x3f", other.Bytes(), 64, 48},
+		{"misnamed.jpg", other.Bytes(), 64, 48}, // read as RAW for its content
+	}
+	for _, tc := range testCases {
+		imagePath := filepath.Join(t.TempDir(), tc.fileName)
+		if err := os.WriteFile(imagePath, tc.data, 0644); err != nil {
+			t.Fatalf("Failed to write image: %v", err)
+		}
+		imageData, thumbnailData, err := ProcessImage(imagePath)
+		if err != nil {
+			t.Fatalf("ProcessImage(%s) failed: %v", tc.fileName, err)
+		}
+		if imageData.ImageWidth != tc.width || imageData.ImageHeight != tc.height {
+			t.Errorf("%s: dimensions = %dx%d; expected %dx%d", tc.fileName, imageData.ImageWidth, imageData.ImageHeight, tc.width, tc.height)
+		}
+		if imageData.PHash == "" || thumbnailData == nil || imageData.RawPending {
+			t.Errorf("%s preview was not used: phash %q, thumbnail %d bytes", tc.fileName, imageData.PHash, len(thumbnailData))
+		}
+
+		extracted, err := ExtractRawPreview(imagePath)
+		if err != nil || !bytes.Equal(extracted, preview.Bytes()) {
+			t.Errorf("ExtractRawPreview(%s) = %d bytes, %v; expected the %d byte preview", tc.fileName, len(extracted), err, preview.Len())
+		}
+	}
+}
+
+func TestPDFPageNumber(t *testing.T) {
+	testCases := []struct {
+		name   string
+		number int
+		ok     bool
+	}{
+		{"page-1.png", 1, true},
+		{"page-007.png", 7, true},
+		{"page-0.png", 0, false},
+		{"page-1.ppm", 0, false},
+		{"page.png", 0, false},
+	}
+	for _, tc := range testCases {
+		if number, ok := pdfPageNumber(tc.name); number != tc.number || ok != tc.ok {
+			t.Errorf("pdfPageNumber(%q) = %d, %v; expected %d, %v", tc.name, number, ok, tc.number, tc.ok)
+		}
+	}
+}
+
+func TestTrimMargins(t *testing.T) {
+	// A photo on a white page
+	page := image.NewRGBA(image.Rect(0, 0, 200, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 200; x++ {
+			c := color.RGBA{255, 255, 255, 255}
+			if x >= 40 && x < 160 && y >= 50 && y < 130 {
+				c = color.RGBA{uint8(x), uint8(y), 90, 255}
+			}
+			page.Set(x, y, c)
+		}
+	}
+	if bounds := trimMargins(page).Bounds(); bounds != image.Rect(40, 50, 160, 130) {
+		t.Errorf("trimMargins kept %v; expected the photo at %v", bounds, image.Rect(40, 50, 160, 130))
+	}
+
+	// A blank page and a page with a thin line are left as they are
+	blank := image.NewRGBA(image.Rect(0, 0, 200, 300))
+	for i := range blank.Pix {
+		blank.Pix[i] = 255
+	}
+	if bounds := trimMargins(blank).Bounds(); bounds != blank.Bounds() {
+		t.Errorf("trimMargins of a blank page kept %v", bounds)
+	}
+	for x := 0; x < 200; x++ {
+		blank.Set(x, 150, color.Black)
+	}
+	if bounds := trimMargins(blank).Bounds(); bounds != blank.Bounds() {
+		t.Errorf("trimMargins of a page with a line kept %v", bounds)
+	}
+}
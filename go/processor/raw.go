@@ -0,0 +1,82 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"image/jpeg"
+	"os/exec"
+
+	"picpurge/util"
+
+	"github.com/nfnt/resize"
+)
+
+// RawConverterAvailable reports why RAW files cannot be rendered, or nil when
+// dcraw and ImageMagick are installed.
+func RawConverterAvailable() error {
+	if _, err := exec.LookPath("dcraw"); err != nil {
+		return fmt.Errorf("dcraw is not installed. Please install dcraw to view CR2 files")
+	}
+	if _, err := exec.LookPath("convert"); err != nil {
+		return fmt.Errorf("ImageMagick is not installed. Please install ImageMagick to view CR2 files")
+	}
+	return nil
+}
+
+// ConvertRAW renders a RAW file to JPEG at half size with dcraw and ImageMagick.
+// Background conversions pass lowPriority so the converters only take idle CPU.
+func ConvertRAW(filePath string, lowPriority bool) ([]byte, error) {
+	if err := RawConverterAvailable(); err != nil {
+		return nil, err
+	}
+	command := exec.Command
+	if lowPriority {
+		command = util.LowPriorityCommand
+	}
+
+	// Use dcraw to convert CR2 to PPM with half size for better performance
+	cmd := command("dcraw", "-c", "-q", "3", "-w", "-H", "5", "-h", filePath)
+	var ppmData bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &ppmData
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("dcraw failed: %w, stderr: %s", err, stderr.String())
+	}
+
+	// Convert PPM to JPEG using ImageMagick's convert command
+	convertCmd := command("convert", "-", "-quality", "85", "jpeg:-")
+	convertCmd.Stdin = &ppmData
+
+	var jpegData bytes.Buffer
+	var convertStderr bytes.Buffer
+	convertCmd.Stdout = &jpegData
+	convertCmd.Stderr = &convertStderr
+
+	if err := convertCmd.Run(); err != nil {
+		return nil, fmt.Errorf("convert failed: %w, stderr: %s", err, convertStderr.String())
+	}
+
+	return jpegData.Bytes(), nil
+}
+
+// RawThumbnail renders the WebP thumbnail of a RAW file that got a placeholder
+// during the scan, together with its LowInfo class.
+func RawThumbnail(filePath string) ([]byte, string, error) {
+	jpegData, err := ConvertRAW(filePath, true)
+	if err != nil {
+		return nil, "", err
+	}
+	img, err := jpeg.Decode(bytes.NewReader(jpegData))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode converted %s: %w", filePath, err)
+	}
+	thumbnail := resize.Thumbnail(320, 320, img, resize.Lanczos3)
+
+	thumbnailData, err := encodeThumbnail(thumbnail)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode thumbnail of %s: %w", filePath, err)
+	}
+	return thumbnailData, ClassifyLowInfo(thumbnail), nil
+}
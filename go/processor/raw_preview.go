@@ -0,0 +1,273 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+)
+
+// TIFF tags that locate images inside a RAW file.
+const (
+	tagNewSubfileType  = 0x00fe
+	tagImageWidth      = 0x0100
+	tagImageLength     = 0x0101
+	tagCompression     = 0x0103
+	tagStripOffsets    = 0x0111
+	tagStripByteCounts = 0x0117
+	tagSubIFDs         = 0x014a
+	tagJPEGOffset      = 0x0201
+	tagJPEGLength      = 0x0202
+)
+
+// Limits against malformed or hostile files.
+const (
+	maxIFDs            = 64
+	maxIFDEntries      = 1024
+	maxJPEGScanMatches = 64
+)
+
+// rawPreview is an embedded JPEG found in a RAW file.
+type rawPreview struct {
+	offset, length int64
+	width, height  int
+}
+
+// ExtractRawPreview returns the largest JPEG preview embedded in a RAW file.
+// Most cameras embed one at or near full resolution.
+func ExtractRawPreview(filePath string) ([]byte, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	preview, _, _, err := findRawPreview(file, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, preview.length)
+	if _, err := file.ReadAt(data, preview.offset); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read preview: %w", err)
+	}
+	return data, nil
+}
+
+// decodeRawPreview decodes the largest embedded preview of a RAW file. width
+// and height are the size of the sensor image when the file records it, and
+// the size of the preview otherwise.
+func decodeRawPreview(r io.ReaderAt, size int64) (img image.Image, width, height int, err error) {
+	preview, width, height, err := findRawPreview(r, size)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	img, err = jpeg.Decode(io.NewSectionReader(r, preview.offset, preview.length))
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to decode preview: %w", err)
+	}
+	if width == 0 || height == 0 {
+		width, height = img.Bounds().Dx(), img.Bounds().Dy()
+	}
+	return img, width, height, nil
+}
+
+// findRawPreview locates the largest decodable JPEG in a RAW file. TIFF-based
+// formats (CR2, NEF, ARW, DNG, ORF, RW2, PEF and most others) are read through
+// their IFDs, Fuji RAF through its header. Other formats, and TIFF files whose
+// IFDs list no usable JPEG, are searched for JPEG start markers.
+func findRawPreview(r io.ReaderAt, size int64) (best rawPreview, width, height int, err error) {
+	var candidates []rawPreview
+	header := make([]byte, 92)
+	n, _ := r.ReadAt(header, 0)
+	header = header[:n]
+	switch {
+	case bytes.HasPrefix(header, []byte("FUJIFILMCCD-RAW")) && len(header) >= 92:
+		candidates = append(candidates, rawPreview{
+			offset: int64(binary.BigEndian.Uint32(header[84:])),
+			length: int64(binary.BigEndian.Uint32(header[88:])),
+		})
+	case len(header) >= 8 && (bytes.HasPrefix(header, []byte("II")) || bytes.HasPrefix(header, []byte("MM"))):
+		// ORF and RW2 use their own magic numbers after the byte order mark
+		candidates, width, height = tiffPreviews(r, size, header)
+	}
+
+	for _, candidate := range candidates {
+		if candidate.offset <= 0 || candidate.length <= 0 || candidate.offset+candidate.length > size {
+			continue
+		}
+		// Lossless JPEG raw data and broken previews fail here
+		config, err := jpeg.DecodeConfig(io.NewSectionReader(r, candidate.offset, candidate.length))
+		if err != nil {
+			continue
+		}
+		candidate.width, candidate.height = config.Width, config.Height
+		if candidate.width*candidate.height > best.width*best.height {
+			best = candidate
+		}
+	}
+	if best.length == 0 {
+		if best, err = scanForJPEG(r, size); err != nil {
+			return rawPreview{}, 0, 0, err
+		}
+	}
+	return best, width, height, nil
+}
+
+// tiffPreviews walks the IFDs of a TIFF-based RAW file and returns the JPEGs
+// they reference, together with the size of the largest full-resolution image.
+func tiffPreviews(r io.ReaderAt, size int64, header []byte) (candidates []rawPreview, width, height int) {
+	var order binary.ByteOrder = binary.LittleEndian
+	if header[0] == 'M' {
+		order = binary.BigEndian
+	}
+	queue := []int64{int64(order.Uint32(header[4:]))}
+	visited := make(map[int64]bool)
+	for len(queue) > 0 && len(visited) < maxIFDs {
+		offset := queue[0]
+		queue = queue[1:]
+		if offset <= 0 || offset >= size || visited[offset] {
+			continue
+		}
+		visited[offset] = true
+
+		entries, next := readIFD(r, order, offset)
+		if next != 0 {
+			queue = append(queue, next)
+		}
+		queue = append(queue, entries.values(r, order, tagSubIFDs)...)
+
+		if jpegOffset, jpegLength := entries.value(r, order, tagJPEGOffset), entries.value(r, order, tagJPEGLength); jpegOffset > 0 {
+			candidates = append(candidates, rawPreview{offset: jpegOffset, length: jpegLength})
+		}
+		subfileType := entries.value(r, order, tagNewSubfileType)
+		compression := entries.value(r, order, tagCompression)
+		strips, counts := entries.values(r, order, tagStripOffsets), entries.values(r, order, tagStripByteCounts)
+		// Compression 6 is the old-style JPEG of CR2 and others; 7 is also used
+		// for lossless raw data, so only reduced-resolution images count.
+		if len(strips) == 1 && len(counts) == 1 && (compression == 6 || compression == 7 && subfileType == 1) {
+			candidates = append(candidates, rawPreview{offset: strips[0], length: counts[0]})
+		}
+		if subfileType == 0 {
+			w, h := int(entries.value(r, order, tagImageWidth)), int(entries.value(r, order, tagImageLength))
+			if w*h > width*height {
+				width, height = w, h
+			}
+		}
+	}
+	return candidates, width, height
+}
+
+// ifdEntry is one 12-byte TIFF directory entry.
+type ifdEntry struct {
+	typ   uint16
+	count uint32
+	raw   [4]byte // the value itself, or its offset when it does not fit
+}
+
+type ifdEntries map[uint16]ifdEntry
+
+// readIFD reads the directory at offset and returns its entries and the offset
+// of the next directory.
+func readIFD(r io.ReaderAt, order binary.ByteOrder, offset int64) (ifdEntries, int64) {
+	countBuf := make([]byte, 2)
+	if _, err := r.ReadAt(countBuf, offset); err != nil {
+		return nil, 0
+	}
+	count := int(order.Uint16(countBuf))
+	if count == 0 || count > maxIFDEntries {
+		return nil, 0
+	}
+	buf := make([]byte, count*12+4)
+	if _, err := r.ReadAt(buf, offset+2); err != nil {
+		return nil, 0
+	}
+	entries := make(ifdEntries, count)
+	for i := 0; i < count; i++ {
+		e := buf[i*12:]
+		entry := ifdEntry{typ: order.Uint16(e[2:]), count: order.Uint32(e[4:])}
+		copy(entry.raw[:], e[8:12])
+		entries[order.Uint16(e)] = entry
+	}
+	return entries, int64(order.Uint32(buf[count*12:]))
+}
+
+// values returns the integer values of a SHORT, LONG or IFD tag.
+func (entries ifdEntries) values(r io.ReaderAt, order binary.ByteOrder, tag uint16) []int64 {
+	entry, ok := entries[tag]
+	if !ok || entry.count == 0 || entry.count > maxIFDEntries {
+		return nil
+	}
+	var width int
+	switch entry.typ {
+	case 3: // SHORT
+		width = 2
+	case 4, 13: // LONG, IFD
+		width = 4
+	default:
+		return nil
+	}
+	data := entry.raw[:]
+	if total := int(entry.count) * width; total > 4 {
+		data = make([]byte, total)
+		if _, err := r.ReadAt(data, int64(order.Uint32(entry.raw[:]))); err != nil {
+			return nil
+		}
+	}
+	values := make([]int64, entry.count)
+	for i := range values {
+		if width == 2 {
+			values[i] = int64(order.Uint16(data[i*2:]))
+		} else {
+			values[i] = int64(order.Uint32(data[i*4:]))
+		}
+	}
+	return values
+}
+
+// value returns the first value of a tag, or 0 when it is missing.
+func (entries ifdEntries) value(r io.ReaderAt, order binary.ByteOrder, tag uint16) int64 {
+	if values := entries.values(r, order, tag); len(values) > 0 {
+		return values[0]
+	}
+	return 0
+}
+
+// scanForJPEG searches a file for JPEG start markers and returns the largest
+// image that can be decoded from one, for formats whose layout is not parsed.
+func scanForJPEG(r io.ReaderAt, size int64) (rawPreview, error) {
+	const chunkSize = 1 << 20
+	marker := []byte{0xff, 0xd8, 0xff}
+	var best rawPreview
+	matches := 0
+	chunk := make([]byte, chunkSize+len(marker)-1)
+	for base := int64(0); base < size && matches < maxJPEGScanMatches; base += chunkSize {
+		n, err := r.ReadAt(chunk, base)
+		if n == 0 && err != nil {
+			break
+		}
+		data := chunk[:n]
+		for i := 0; matches < maxJPEGScanMatches; {
+			found := bytes.Index(data[i:], marker)
+			if found < 0 {
+				break
+			}
+			offset := base + int64(i+found)
+			i += found + 1
+			matches++
+			config, err := jpeg.DecodeConfig(io.NewSectionReader(r, offset, size-offset))
+			if err == nil && config.Width*config.Height > best.width*best.height {
+				best = rawPreview{offset: offset, length: size - offset, width: config.Width, height: config.Height}
+			}
+		}
+	}
+	if best.length == 0 {
+		return rawPreview{}, fmt.Errorf("no embedded JPEG preview found")
+	}
+	return best, nil
+}
@@ -0,0 +1,24 @@
+package processor
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// screenshotNamePatterns are lowercase file name fragments used by common screenshot tools.
+var screenshotNamePatterns = []string{
+	"screenshot", "screen shot", "screen_shot", "screencapture", "screencap",
+	"屏幕截图", "截屏", "截图",
+}
+
+// IsScreenshot guesses whether an image is a screen capture from its file name and
+// camera metadata. PNG files without a camera make or model are treated as screenshots.
+func IsScreenshot(fileName, deviceMake, deviceModel string) bool {
+	name := strings.ToLower(fileName)
+	for _, pattern := range screenshotNamePatterns {
+		if strings.Contains(name, pattern) {
+			return true
+		}
+	}
+	return deviceMake == "" && deviceModel == "" && strings.ToLower(filepath.Ext(name)) == ".png"
+}
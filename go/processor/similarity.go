@@ -0,0 +1,42 @@
+package processor
+
+import "math"
+
+// Thresholds used when grouping visually similar images.
+const (
+	PHashThreshold         = 3   // Hamming distance threshold for pHash similarity
+	DocumentPHashThreshold = 1   // stricter threshold when either image is a document, see IsDocument
+	SizeThreshold          = 0.2 // 20% tolerance for size difference (ratio of areas)
+	AspectRatioTolerance   = 0.1 // 10% tolerance for aspect ratio
+)
+
+// PairThreshold returns the largest pHash distance at which two images count
+// as similar, which is stricter when either of them is a document.
+func PairThreshold(isDocument1, isDocument2 bool) int {
+	if isDocument1 || isDocument2 {
+		return DocumentPHashThreshold
+	}
+	return PHashThreshold
+}
+
+// AspectDelta returns the relative difference between the aspect ratios of two
+// images. Unknown dimensions count as completely different.
+func AspectDelta(width1, height1, width2, height2 int) float64 {
+	if width1 <= 0 || height1 <= 0 || width2 <= 0 || height2 <= 0 {
+		return 1
+	}
+	aspectRatio1 := float64(width1) / float64(height1)
+	aspectRatio2 := float64(width2) / float64(height2)
+	return math.Abs(aspectRatio1-aspectRatio2) / math.Max(aspectRatio1, aspectRatio2)
+}
+
+// SizeRatio returns the smaller pixel area divided by the larger one, or 0 when
+// either size is unknown.
+func SizeRatio(width1, height1, width2, height2 int) float64 {
+	area1 := float64(width1) * float64(height1)
+	area2 := float64(width2) * float64(height2)
+	if area1 <= 0 || area2 <= 0 {
+		return 0
+	}
+	return math.Min(area1, area2) / math.Max(area1, area2)
+}
@@ -0,0 +1,20 @@
+//go:build purego
+
+package processor
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+)
+
+// encodeThumbnail encodes a thumbnail as JPEG. The WebP codec needs cgo, so
+// builds with the purego tag neither write WebP thumbnails nor decode WebP
+// originals.
+func encodeThumbnail(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,19 @@
+//go:build !purego
+
+package processor
+
+import (
+	"bytes"
+	"image"
+
+	"github.com/chai2010/webp" // also registers the decoder for WebP originals
+)
+
+// encodeThumbnail encodes a thumbnail as lossy WebP.
+func encodeThumbnail(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := webp.Encode(&buf, img, &webp.Options{Lossless: false, Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,123 @@
+package processor
+
+import (
+	"image"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/corona10/goimagehash"
+	"github.com/nfnt/resize"
+)
+
+// A pHash changes completely when a picture is turned by 90 degrees or
+// mirrored, so the pHashes of every image are also taken in its seven other
+// orientations. A copy that was rotated or flipped matches the upright pHash
+// of its original in one of them.
+
+// turns map a pixel of a turned n+1 pixels square image to the pixel of the
+// upright one it comes from, in the order turned pHashes are stored: first
+// those that keep width and height, then those that swap them.
+var turns = []func(x, y, n int) (int, int){
+	func(x, y, n int) (int, int) { return n - x, n - y }, // turned by 180 degrees
+	func(x, y, n int) (int, int) { return n - x, y },     // mirrored left to right
+	func(x, y, n int) (int, int) { return x, n - y },     // mirrored top to bottom
+	func(x, y, n int) (int, int) { return y, n - x },     // turned clockwise
+	func(x, y, n int) (int, int) { return n - y, x },     // turned anticlockwise
+	func(x, y, n int) (int, int) { return y, x },         // mirrored along the diagonal
+	func(x, y, n int) (int, int) { return n - y, n - x }, // mirrored along the other diagonal
+}
+
+// sameAxisTurns is the number of turns that keep width and height.
+const sameAxisTurns = 3
+
+// turnSize is the side of the square a pHash scales images to.
+const turnSize = 64
+
+// turnedPHashes returns the pHashes of img in its other orientations, in the
+// order of turns, separated by commas; empty if they could not be taken.
+func turnedPHashes(img image.Image, filePath string) string {
+	small := resize.Resize(turnSize, turnSize, img, resize.Bilinear)
+	bounds := small.Bounds()
+	hashes := make([]string, 0, len(turns))
+	turned := image.NewRGBA(image.Rect(0, 0, turnSize, turnSize))
+	for _, turn := range turns {
+		for y := 0; y < turnSize; y++ {
+			for x := 0; x < turnSize; x++ {
+				sx, sy := turn(x, y, turnSize-1)
+				turned.Set(x, y, small.At(bounds.Min.X+sx, bounds.Min.Y+sy))
+			}
+		}
+		hash, err := goimagehash.PerceptionHash(turned)
+		if err != nil {
+			log.Printf("Warning: Could not calculate turned pHashes for %s: %v\n", filePath, err)
+			return ""
+		}
+		hashes = append(hashes, hash.ToString())
+	}
+	return strings.Join(hashes, ",")
+}
+
+// PHashes holds the pHash of an image and, for images catalogued since turned
+// copies are matched, its pHashes in the other orientations.
+type PHashes struct {
+	Upright *goimagehash.ImageHash
+	turned  []*goimagehash.ImageHash
+}
+
+// ParsePHashes reads the stored pHash of an image and its turned pHashes,
+// which may be empty.
+func ParsePHashes(upright, turned string) (PHashes, error) {
+	hash, err := goimagehash.ImageHashFromString(upright)
+	if err != nil {
+		return PHashes{}, err
+	}
+	p := PHashes{Upright: hash}
+	if turned == "" {
+		return p, nil
+	}
+	for _, s := range strings.Split(turned, ",") {
+		hash, err := goimagehash.ImageHashFromString(s)
+		if err != nil {
+			return PHashes{}, err
+		}
+		p.turned = append(p.turned, hash)
+	}
+	if len(p.turned) != len(turns) {
+		p.turned = nil // Written by another version; match upright only
+	}
+	return p, nil
+}
+
+// Orientations reports whether two images agree closely enough in size and
+// aspect ratio to be compared for similarity as they are, and with the second
+// one turned by 90 degrees.
+func Orientations(width1, height1, width2, height2 int) (upright, sideways bool) {
+	if 1-SizeRatio(width1, height1, width2, height2) > SizeThreshold {
+		return false, false
+	}
+	return AspectDelta(width1, height1, width2, height2) <= AspectRatioTolerance,
+		AspectDelta(width1, height1, height2, width2) <= AspectRatioTolerance
+}
+
+// Distance returns the smallest distance between the upright pHash of p and
+// a pHash of other in the allowed orientations, as told by Orientations, and
+// whether the closest one is turned or mirrored. It returns math.MaxInt when
+// no orientation can be compared.
+func (p PHashes) Distance(other PHashes, upright, sideways bool) (distance int, turned bool) {
+	distance = math.MaxInt
+	consider := func(hash *goimagehash.ImageHash, isTurned bool) {
+		if d, err := p.Upright.Distance(hash); err == nil && d < distance {
+			distance, turned = d, isTurned
+		}
+	}
+	if upright {
+		consider(other.Upright, false)
+	}
+	for i, hash := range other.turned {
+		if (i < sameAxisTurns && upright) || (i >= sameAxisTurns && sideways) {
+			consider(hash, true)
+		}
+	}
+	return distance, turned
+}
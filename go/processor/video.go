@@ -0,0 +1,145 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"math"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// missingToolsWarning is logged once per run instead of for every video.
+var missingToolsWarning sync.Once
+
+// analyzeVideo fills in the metadata of a video file and grabs a frame for its
+// thumbnail. Videos are only matched by MD5, so no perceptual hashes, edge
+// hashes or palette are computed.
+func analyzeVideo(imageData *ImageData) (*ImageData, *ThumbnailSource, error) {
+	filePath := imageData.FilePath
+	source := &ThumbnailSource{filePath: filePath, video: true}
+	imageData.Messenger = MessengerOf(filePath)
+
+	if err := VideoToolsAvailable(); err != nil {
+		missingToolsWarning.Do(func() {
+			log.Printf("Warning: %v. Videos are catalogued without resolution, duration or thumbnail.\n", err)
+		})
+		return imageData, source, nil
+	}
+	info, err := probeVideo(filePath)
+	if err != nil {
+		log.Printf("Warning: Could not read video metadata of %s: %v\n", filePath, err)
+		return imageData, source, nil
+	}
+	imageData.ImageWidth, imageData.ImageHeight, imageData.Duration = info.Width, info.Height, info.Duration
+	if !info.CreatedAt.IsZero() {
+		// creation_time is UTC, while EXIF dates are stored as the local wall clock
+		local := info.CreatedAt.Local()
+		imageData.CreateDate = time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(), 0, time.UTC)
+		imageData.DateSource = DateSourceMetadata
+	}
+	if source.img, err = videoFrame(filePath, info.Duration); err != nil {
+		log.Printf("Warning: Could not grab a thumbnail frame of %s: %v\n", filePath, err)
+	}
+	return imageData, source, nil
+}
+
+// VideoToolsAvailable reports why videos cannot be probed and thumbnailed, or
+// nil when ffprobe and ffmpeg are installed.
+func VideoToolsAvailable() error {
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		return fmt.Errorf("ffprobe is not installed. Please install ffmpeg to catalog videos")
+	}
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return fmt.Errorf("ffmpeg is not installed. Please install ffmpeg to catalog videos")
+	}
+	return nil
+}
+
+// videoInfo is what ffprobe reports about a video file.
+type videoInfo struct {
+	Width     int
+	Height    int
+	Duration  float64   // seconds
+	CreatedAt time.Time // zero when the container has no creation_time tag
+}
+
+// probeVideo reads the resolution, duration and creation time of a video.
+func probeVideo(filePath string) (videoInfo, error) {
+	cmd := exec.Command("ffprobe", "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "stream=width,height:stream_tags=rotate:format=duration:format_tags=creation_time",
+		"-of", "json", filePath)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return videoInfo{}, fmt.Errorf("ffprobe failed: %w, stderr: %s", err, stderr.String())
+	}
+	return parseProbeOutput(stdout.Bytes())
+}
+
+// parseProbeOutput parses ffprobe's JSON output. Videos recorded in portrait
+// carry a rotate tag of 90 or 270; their width and height are swapped so they
+// match what a player shows.
+func parseProbeOutput(data []byte) (videoInfo, error) {
+	var probe struct {
+		Streams []struct {
+			Width  int               `json:"width"`
+			Height int               `json:"height"`
+			Tags   map[string]string `json:"tags"`
+		} `json:"streams"`
+		Format struct {
+			Duration string            `json:"duration"`
+			Tags     map[string]string `json:"tags"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return videoInfo{}, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	if len(probe.Streams) == 0 {
+		return videoInfo{}, fmt.Errorf("no video stream found")
+	}
+
+	stream := probe.Streams[0]
+	info := videoInfo{Width: stream.Width, Height: stream.Height}
+	if rotate, err := strconv.Atoi(stream.Tags["rotate"]); err == nil && (rotate == 90 || rotate == 270 || rotate == -90) {
+		info.Width, info.Height = info.Height, info.Width
+	}
+	if duration, err := strconv.ParseFloat(probe.Format.Duration, 64); err == nil && !math.IsNaN(duration) {
+		info.Duration = duration
+	}
+	if created, err := time.Parse(time.RFC3339Nano, probe.Format.Tags["creation_time"]); err == nil && !created.IsZero() && created.Year() > 1970 {
+		info.CreatedAt = created
+	}
+	return info, nil
+}
+
+// videoFrame grabs a representative frame for the thumbnail: one second in,
+// past the black fade most recordings start with, or the middle of shorter clips.
+func videoFrame(filePath string, duration float64) (image.Image, error) {
+	seek := 1.0
+	if duration > 0 && duration < 2 {
+		seek = duration / 2
+	}
+	cmd := exec.Command("ffmpeg", "-v", "error", "-ss", strconv.FormatFloat(seek, 'f', 3, 64), "-i", filePath,
+		"-frames:v", "1", "-f", "image2pipe", "-vcodec", "png", "-")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %w, stderr: %s", err, stderr.String())
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("ffmpeg returned no frame")
+	}
+	frame, err := png.Decode(&stdout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode frame: %w", err)
+	}
+	return frame, nil
+}
@@ -0,0 +1,175 @@
+// Package quota watches free space on the volumes holding the catalog's images
+// and tells pluggable notifiers when it runs low.
+package quota
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"picpurge/database"
+	"picpurge/util"
+)
+
+// Alert describes a volume whose free space is below the configured threshold.
+type Alert struct {
+	VolumeID         string `json:"volumeId"`
+	Volume           string `json:"volume"` // display name, e.g. "C:" or "Photos"
+	Path             string `json:"path"`   // a scanned folder on the volume
+	FreeBytes        uint64 `json:"freeBytes"`
+	TotalBytes       uint64 `json:"totalBytes"`
+	Threshold        string `json:"threshold"`
+	ReclaimableBytes int64  `json:"reclaimableBytes"` // size of unrecycled duplicates on the volume
+	ReclaimableFiles int    `json:"reclaimableFiles"`
+}
+
+// Message returns a one-line summary suitable for logs and chat webhooks.
+func (a Alert) Message() string {
+	return fmt.Sprintf("Low disk space on %s: %s free of %s (threshold %s). %d duplicates on this volume could free %s.",
+		a.Volume, util.FormatBytes(a.FreeBytes), util.FormatBytes(a.TotalBytes), a.Threshold,
+		a.ReclaimableFiles, util.FormatBytes(uint64(a.ReclaimableBytes)))
+}
+
+// Notifier receives the result of every check.
+type Notifier interface {
+	// Notify is called with all volumes currently below the threshold; an empty
+	// slice means every volume has recovered.
+	Notify(alerts []Alert) error
+}
+
+// Checker compares free space on every volume holding catalog images with a threshold.
+type Checker struct {
+	Threshold util.SpaceThreshold
+	Notifiers []Notifier
+}
+
+type volumeStats struct {
+	id, path         string
+	reclaimableBytes int64
+	reclaimableFiles int
+}
+
+// Check measures all volumes once and passes the alerts to every notifier.
+func (c *Checker) Check() ([]Alert, error) {
+	db, err := database.GetDBInstance()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT file_path, COALESCE(file_size, 0), is_duplicate FROM images WHERE is_recycled = FALSE")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query images for quota check: %w", err)
+	}
+	defer rows.Close()
+
+	dirVolumes := make(map[string]*volumeStats) // cache, most images share a folder
+	volumes := make(map[string]*volumeStats)
+	for rows.Next() {
+		var filePath string
+		var fileSize int64
+		var isDuplicate bool
+		if err := rows.Scan(&filePath, &fileSize, &isDuplicate); err != nil {
+			return nil, fmt.Errorf("failed to scan image for quota check: %w", err)
+		}
+		dir := filepath.Dir(filePath)
+		volume, seen := dirVolumes[dir]
+		if !seen {
+			if id, err := util.VolumeID(dir); err == nil {
+				if volume = volumes[id]; volume == nil {
+					volume = &volumeStats{id: id, path: dir}
+					volumes[id] = volume
+				} else if len(dir) < len(volume.path) {
+					volume.path = dir
+				}
+			}
+			dirVolumes[dir] = volume // nil for folders that are gone
+		}
+		if volume != nil && isDuplicate {
+			volume.reclaimableBytes += fileSize
+			volume.reclaimableFiles++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read images for quota check: %w", err)
+	}
+
+	alerts := []Alert{}
+	for _, volume := range volumes {
+		free, total, err := util.VolumeUsage(volume.path)
+		if err != nil {
+			log.Printf("Warning: %v\n", err)
+			continue
+		}
+		if !c.Threshold.Low(free, total) {
+			continue
+		}
+		alerts = append(alerts, Alert{
+			VolumeID:         volume.id,
+			Volume:           util.VolumeName(volume.path),
+			Path:             volume.path,
+			FreeBytes:        free,
+			TotalBytes:       total,
+			Threshold:        c.Threshold.String(),
+			ReclaimableBytes: volume.reclaimableBytes,
+			ReclaimableFiles: volume.reclaimableFiles,
+		})
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].Path < alerts[j].Path })
+
+	for _, n := range c.Notifiers {
+		if err := n.Notify(alerts); err != nil {
+			log.Printf("Warning: quota notification failed: %v\n", err)
+		}
+	}
+	return alerts, nil
+}
+
+// Run checks immediately and then every interval until stop is closed.
+func (c *Checker) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := c.Check(); err != nil {
+			log.Printf("Warning: quota check failed: %v\n", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// transitions remembers which volumes were low at the previous check so
+// notifiers that push messages only report a volume once until it recovers.
+type transitions struct {
+	low map[string]bool
+}
+
+// fresh returns the alerts for volumes that were not low at the previous check.
+func (t *transitions) fresh(alerts []Alert) []Alert {
+	low := make(map[string]bool, len(alerts))
+	var result []Alert
+	for _, a := range alerts {
+		low[a.VolumeID] = true
+		if !t.low[a.VolumeID] {
+			result = append(result, a)
+		}
+	}
+	t.low = low
+	return result
+}
+
+// LogNotifier writes newly low volumes to the log.
+type LogNotifier struct {
+	transitions
+}
+
+// Notify implements Notifier.
+func (n *LogNotifier) Notify(alerts []Alert) error {
+	for _, a := range n.fresh(alerts) {
+		log.Println(a.Message())
+	}
+	return nil
+}
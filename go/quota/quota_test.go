@@ -0,0 +1,20 @@
+package quota
+
+import "testing"
+
+func TestTransitionsReportVolumesOnce(t *testing.T) {
+	var tr transitions
+	a := Alert{VolumeID: "1"}
+	b := Alert{VolumeID: "2"}
+
+	if fresh := tr.fresh([]Alert{a}); len(fresh) != 1 {
+		t.Fatalf("first check reported %d alerts; expected 1", len(fresh))
+	}
+	if fresh := tr.fresh([]Alert{a, b}); len(fresh) != 1 || fresh[0].VolumeID != "2" {
+		t.Fatalf("second check reported %v; expected only volume 2", fresh)
+	}
+	tr.fresh(nil) // both volumes recovered
+	if fresh := tr.fresh([]Alert{a}); len(fresh) != 1 {
+		t.Errorf("volume running low again reported %d alerts; expected 1", len(fresh))
+	}
+}
@@ -0,0 +1,47 @@
+package quota
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Webhook posts newly low volumes as JSON to a URL. The payload carries a "text"
+// field so it can be sent straight to Slack- or Mattermost-style incoming webhooks.
+type Webhook struct {
+	URL    string
+	client *http.Client
+	transitions
+}
+
+// NewWebhook returns a notifier posting to url.
+func NewWebhook(url string) *Webhook {
+	return &Webhook{URL: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Notify implements Notifier.
+func (w *Webhook) Notify(alerts []Alert) error {
+	for _, a := range w.fresh(alerts) {
+		body, err := json.Marshal(struct {
+			Text string `json:"text"`
+			Alert
+		}{a.Message(), a})
+		if err != nil {
+			return err
+		}
+		resp, err := w.client.Post(w.URL, "application/json", bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				err = fmt.Errorf("quota webhook returned %s", resp.Status)
+			}
+		}
+		if err != nil {
+			delete(w.low, a.VolumeID) // retry at the next check
+			return fmt.Errorf("failed to post quota alert: %w", err)
+		}
+	}
+	return nil
+}
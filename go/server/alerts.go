@@ -0,0 +1,36 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+
+	"picpurge/quota"
+)
+
+var (
+	quotaAlerts   = []quota.Alert{}
+	quotaAlertsMu sync.RWMutex
+)
+
+// QuotaBanner is a quota.Notifier that shows low-space alerts as banners in the web interface.
+type QuotaBanner struct{}
+
+// Notify implements quota.Notifier.
+func (QuotaBanner) Notify(alerts []quota.Alert) error {
+	quotaAlertsMu.Lock()
+	defer quotaAlertsMu.Unlock()
+	quotaAlerts = alerts
+	return nil
+}
+
+// handleAlerts returns the volumes currently below the free space threshold.
+func handleAlerts(w http.ResponseWriter, r *http.Request) {
+	quotaAlertsMu.RLock()
+	alerts := quotaAlerts
+	quotaAlertsMu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, r, map[string]interface{}{
+		"alerts": alerts,
+	})
+}
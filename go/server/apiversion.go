@@ -0,0 +1,160 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// API versions. Version 1 is the original naming, which the embedded web
+// interface is written against: images use snake_case fields, while listings,
+// stats and most other responses use camelCase. Version 2 uses snake_case for
+// every field, in responses and request bodies alike.
+const (
+	APIVersion1 = 1
+	APIVersion2 = 2
+)
+
+// apiVersionHeader selects the API version of a request and reports the version
+// of a response. The api_version query parameter does the same for clients
+// that cannot set headers.
+const apiVersionHeader = "X-PicPurge-API-Version"
+
+// defaultAPIVersion is used by requests that do not ask for a version.
+var defaultAPIVersion = APIVersion1
+
+// SetAPIVersion sets the version served to clients that do not ask for one.
+func SetAPIVersion(version int) error {
+	if version != APIVersion1 && version != APIVersion2 {
+		return fmt.Errorf("unsupported API version %d; use %d or %d", version, APIVersion1, APIVersion2)
+	}
+	defaultAPIVersion = version
+	return nil
+}
+
+// requestAPIVersion returns the API version a request asked for, or the
+// default. Unknown versions fall back to the default too.
+func requestAPIVersion(r *http.Request) int {
+	requested := r.Header.Get(apiVersionHeader)
+	if requested == "" {
+		requested = r.URL.Query().Get("api_version")
+	}
+	if version, err := strconv.Atoi(requested); err == nil && (version == APIVersion1 || version == APIVersion2) {
+		return version
+	}
+	return defaultAPIVersion
+}
+
+// writeJSON encodes v as the response in the API version of the request.
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	version := requestAPIVersion(r)
+	w.Header().Set(apiVersionHeader, strconv.Itoa(version))
+	if version == APIVersion1 {
+		return json.NewEncoder(w).Encode(v)
+	}
+	converted, err := snakeCaseJSON(v)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(converted)
+}
+
+// readJSON decodes a request body into v, which is tagged with the version 1
+// names. Version 2 bodies are renamed to match first.
+func readJSON(r *http.Request, v interface{}) error {
+	if requestAPIVersion(r) == APIVersion1 {
+		return json.NewDecoder(r.Body).Decode(v)
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var body interface{}
+	if err := decoder.Decode(&body); err != nil {
+		return err
+	}
+	renamed, err := json.Marshal(renameKeys(body, camelCase))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(renamed, v)
+}
+
+// snakeCaseJSON returns v as generic JSON with its field names in snake_case.
+func snakeCaseJSON(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber() // keep large integers such as byte counts exact
+	var generic interface{}
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return renameKeys(generic, snakeCase), nil
+}
+
+// camelCaseName matches field names such as totalImages. Keys that are data,
+// such as the MD5s and ID lists keying groupReviews, never match.
+var camelCaseName = regexp.MustCompile(`^[a-z][a-z0-9]*(?:[A-Z][a-z0-9]*)+$`)
+
+// snakeCaseName matches field names such as total_images.
+var snakeCaseName = regexp.MustCompile(`^[a-z][a-z0-9]*(?:_[a-z0-9]+)+$`)
+
+// renameKeys applies rename to the object keys in generic JSON, recursively.
+func renameKeys(v interface{}, rename func(string) string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		renamed := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			renamed[rename(key)] = renameKeys(value, rename)
+		}
+		return renamed
+	case []interface{}:
+		for i := range v {
+			v[i] = renameKeys(v[i], rename)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// snakeCase turns a camelCase field name into snake_case, e.g. totalImages into
+// total_images. Other keys are returned unchanged.
+func snakeCase(name string) string {
+	if !camelCaseName.MatchString(name) {
+		return name
+	}
+	var b strings.Builder
+	for _, r := range name {
+		if unicode.IsUpper(r) {
+			b.WriteByte('_')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// camelCase turns a snake_case field name into camelCase, e.g. file_path into
+// filePath. Other keys are returned unchanged.
+func camelCase(name string) string {
+	if !snakeCaseName.MatchString(name) {
+		return name
+	}
+	parts := strings.Split(name, "_")
+	for i := 1; i < len(parts); i++ {
+		parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+	}
+	return strings.Join(parts, "")
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// basePath is the path prefix the server is reached under behind a reverse
+// proxy, e.g. /picpurge; empty when it is served at the root.
+var basePath string
+
+// SetBasePath serves the web interface under a path prefix such as /picpurge,
+// for reverse proxies that forward a sub-path. Requests work with or without
+// the prefix, so the proxy may strip it or pass it on; the pages and the URLs
+// in API responses always include it.
+func SetBasePath(p string) error {
+	if p == "" || p == "/" {
+		basePath = ""
+		return nil
+	}
+	if strings.ContainsAny(p, "?#\"'<>\\") {
+		return fmt.Errorf("base path %q must be a plain URL path", p)
+	}
+	basePath = strings.TrimSuffix(path.Clean("/"+p), "/")
+	return nil
+}
+
+// appURL returns the URL of a server path as seen through the reverse proxy.
+func appURL(p string) string {
+	return basePath + p
+}
+
+// stripBasePath serves requests for paths under basePath as if they were made
+// to the root, and redirects the base path itself to its index.
+func stripBasePath(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if basePath == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.URL.Path == basePath {
+			target := basePath + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		if rest, ok := strings.CutPrefix(r.URL.Path, basePath+"/"); ok {
+			r = r.Clone(r.Context())
+			r.URL.Path, r.URL.RawPath = "/"+rest, ""
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// indexPage returns the web interface's page with the base path filled in,
+// which it prefixes to every URL it requests.
+func indexPage() ([]byte, error) {
+	page, err := fs.ReadFile(webFiles, "web/index.html")
+	if err != nil {
+		return nil, err
+	}
+	placeholder := `<meta name="picpurge-base" content="">`
+	return []byte(strings.Replace(string(page), placeholder,
+		`<meta name="picpurge-base" content="`+html.EscapeString(basePath)+`">`, 1)), nil
+}
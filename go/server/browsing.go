@@ -0,0 +1,86 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BrowsePolicy controls how a running scan makes way for someone browsing the
+// web interface: while API requests come in, the scan runs with fewer workers,
+// and it returns to full speed once they stop for Idle.
+type BrowsePolicy struct {
+	Workers int           // workers per pool while browsing; 0 keeps the scan at full speed
+	Idle    time.Duration // time without requests after which browsing counts as stopped
+}
+
+var (
+	browsePolicy   BrowsePolicy
+	browseTimer    *time.Timer
+	browseThrottle ScanController // the scan throttled for browsing, nil if none
+	browseMu       sync.Mutex
+)
+
+// SetBrowsePolicy sets how a running scan makes way for browsing.
+func SetBrowsePolicy(p BrowsePolicy) {
+	browseMu.Lock()
+	defer browseMu.Unlock()
+	browsePolicy = p
+}
+
+// trackBrowsing wraps the server's handler to throttle a running scan while
+// the web interface is in use.
+func trackBrowsing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isBrowsingRequest(r) {
+			noteBrowsing()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isBrowsingRequest reports whether a request comes from someone using the
+// web interface, as opposed to the polling it does on its own.
+func isBrowsingRequest(r *http.Request) bool {
+	path := r.URL.Path
+	if strings.HasPrefix(path, "/api/scan/") || path == "/api/alerts" {
+		return false
+	}
+	return strings.HasPrefix(path, "/api/") || strings.HasPrefix(path, "/thumbnails/")
+}
+
+// noteBrowsing throttles the running scan, if it is not throttled already, and
+// restarts the countdown to full speed.
+func noteBrowsing() {
+	browseMu.Lock()
+	defer browseMu.Unlock()
+	if browsePolicy.Workers <= 0 {
+		return
+	}
+	c := getScanController()
+	if c == nil {
+		return
+	}
+	if browseThrottle != c {
+		c.Throttle(browsePolicy.Workers)
+		browseThrottle = c
+		log.Printf("Scan slowed to %d workers per pool while the web interface is in use.\n", browsePolicy.Workers)
+	}
+	if browseTimer != nil {
+		browseTimer.Stop()
+	}
+	browseTimer = time.AfterFunc(browsePolicy.Idle, endBrowsing)
+}
+
+// endBrowsing lets the throttled scan run at full speed again.
+func endBrowsing() {
+	browseMu.Lock()
+	defer browseMu.Unlock()
+	if browseThrottle != nil {
+		browseThrottle.Throttle(0)
+		browseThrottle = nil
+		log.Println("Scan back at full speed.")
+	}
+}
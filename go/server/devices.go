@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+
+	"picpurge/database"
+)
+
+// DeviceStats aggregates the images taken with one camera or phone.
+type DeviceStats struct {
+	DeviceMake  string `json:"deviceMake"`
+	DeviceModel string `json:"deviceModel"`
+	ImageCount  int    `json:"imageCount"`
+	TotalSize   int64  `json:"totalSize"`
+	FirstDate   string `json:"firstDate"`
+	LastDate    string `json:"lastDate"`
+}
+
+// handleDeviceStats returns image counts, sizes and date ranges per device make and model.
+func handleDeviceStats(w http.ResponseWriter, r *http.Request) {
+	db, err := database.GetDBInstance()
+	if err != nil {
+		http.Error(w, "Failed to connect to database", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT COALESCE(device_make, ''), COALESCE(device_model, ''), COUNT(*), COALESCE(SUM(file_size), 0),
+			COALESCE(MIN(create_date), ''), COALESCE(MAX(create_date), '')
+		FROM images
+		WHERE is_recycled = FALSE
+		GROUP BY COALESCE(device_make, ''), COALESCE(device_model, '')
+		ORDER BY COUNT(*) DESC
+	`)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	devices := []DeviceStats{}
+	for rows.Next() {
+		var d DeviceStats
+		if err := rows.Scan(&d.DeviceMake, &d.DeviceModel, &d.ImageCount, &d.TotalSize, &d.FirstDate, &d.LastDate); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		devices = append(devices, d)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, r, map[string]interface{}{
+		"devices": devices,
+	})
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"net/http"
+	"path/filepath"
+	"sort"
+
+	"picpurge/database"
+)
+
+// FolderNode is one directory in the folder tree derived from image paths.
+type FolderNode struct {
+	Name       string        `json:"name"`
+	Path       string        `json:"path"`
+	ImageCount int           `json:"imageCount"` // images directly in this folder
+	TotalCount int           `json:"totalCount"` // images in this folder and below
+	TotalSize  int64         `json:"totalSize"`  // bytes in this folder and below
+	Children   []*FolderNode `json:"children"`
+}
+
+// handleFolders returns the directory tree of all non-recycled images.
+func handleFolders(w http.ResponseWriter, r *http.Request) {
+	db, err := database.GetDBInstance()
+	if err != nil {
+		http.Error(w, "Failed to connect to database", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := db.Query("SELECT file_path, file_size FROM images WHERE is_recycled = FALSE")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	root := &FolderNode{Name: "", Path: ""}
+	nodes := map[string]*FolderNode{"": root}
+	for rows.Next() {
+		var filePath string
+		var fileSize int64
+		if err := rows.Scan(&filePath, &fileSize); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		addToFolderTree(nodes, filepath.Dir(filePath), fileSize)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sortFolderTree(root)
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, r, map[string]interface{}{
+		"folders":     root.Children,
+		"totalImages": root.TotalCount,
+		"totalSize":   root.TotalSize,
+	})
+}
+
+// addToFolderTree counts one image of the given size in dir and all of its ancestors,
+// creating missing nodes on the way.
+func addToFolderTree(nodes map[string]*FolderNode, dir string, size int64) {
+	node := folderNode(nodes, filepath.Clean(dir))
+	node.ImageCount++
+	for n := node; n != nil; n = parentFolder(nodes, n) {
+		n.TotalCount++
+		n.TotalSize += size
+	}
+}
+
+func folderNode(nodes map[string]*FolderNode, dir string) *FolderNode {
+	if node, ok := nodes[dir]; ok {
+		return node
+	}
+	node := &FolderNode{Name: filepath.Base(dir), Path: dir}
+	nodes[dir] = node
+
+	parentDir := filepath.Dir(dir)
+	parent := nodes[""]
+	if parentDir != dir {
+		parent = folderNode(nodes, parentDir)
+	}
+	parent.Children = append(parent.Children, node)
+	return node
+}
+
+func parentFolder(nodes map[string]*FolderNode, node *FolderNode) *FolderNode {
+	if node.Path == "" {
+		return nil
+	}
+	parentDir := filepath.Dir(node.Path)
+	if parentDir == node.Path {
+		return nodes[""]
+	}
+	return nodes[parentDir]
+}
+
+func sortFolderTree(node *FolderNode) {
+	sort.Slice(node.Children, func(i, j int) bool {
+		return node.Children[i].Name < node.Children[j].Name
+	})
+	for _, child := range node.Children {
+		sortFolderTree(child)
+	}
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"picpurge/database"
+)
+
+// Bulk operations run as background jobs, so recycling thousands of files does
+// not hang on one request that the browser gives up on. The page polls a job
+// for its progress and, after a reload, finds it again by its ID.
+
+// JobState is how far a job got.
+type JobState string
+
+// Job states.
+const (
+	JobRunning   JobState = "running"
+	JobCancelled JobState = "cancelled" // stopped early; the files handled so far stay handled
+	JobFinished  JobState = "finished"
+)
+
+// JobResult is what a job did with one file.
+type JobResult struct {
+	FilePath     string `json:"filePath"`
+	RecycledPath string `json:"recycledPath,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// Job is a bulk operation running in the background.
+type Job struct {
+	ID          string      `json:"id"`
+	Kind        string      `json:"kind"`
+	State       JobState    `json:"state"`
+	Total       int         `json:"total"`
+	Done        int         `json:"done"` // files handled so far, failed ones included
+	Failed      int         `json:"failed"`
+	OperationID int64       `json:"operationId,omitempty"` // undoes the whole job
+	StartedAt   time.Time   `json:"startedAt"`
+	FinishedAt  *time.Time  `json:"finishedAt,omitempty"`
+	Results     []JobResult `json:"results,omitempty"` // once the job is over, or with ?results=1
+
+	cancel context.CancelFunc
+}
+
+// maxKeptJobs is the number of jobs kept for their reports; older ones that
+// are over are forgotten.
+const maxKeptJobs = 20
+
+var (
+	jobs     = make(map[string]*Job)
+	jobOrder []string // IDs, oldest first
+	jobsMu   sync.Mutex
+)
+
+// startJob registers a job over total files and runs it in the background.
+// run calls handled once per file, in order, and stops when ctx is cancelled.
+func startJob(kind string, total int, run func(ctx context.Context, job *Job, handled func(JobResult))) *Job {
+	id := make([]byte, 8)
+	rand.Read(id)
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{ID: hex.EncodeToString(id), Kind: kind, State: JobRunning, Total: total, StartedAt: time.Now().UTC(), cancel: cancel}
+
+	jobsMu.Lock()
+	jobs[job.ID] = job
+	jobOrder = append(jobOrder, job.ID)
+	for i := 0; len(jobOrder) > maxKeptJobs && i < len(jobOrder); {
+		if old := jobs[jobOrder[i]]; old.State != JobRunning {
+			delete(jobs, old.ID)
+			jobOrder = append(jobOrder[:i], jobOrder[i+1:]...)
+		} else {
+			i++
+		}
+	}
+	jobsMu.Unlock()
+
+	go func() {
+		defer cancel()
+		run(ctx, job, func(result JobResult) {
+			jobsMu.Lock()
+			defer jobsMu.Unlock()
+			job.Done++
+			if result.Error != "" {
+				job.Failed++
+			}
+			job.Results = append(job.Results, result)
+		})
+		jobsMu.Lock()
+		defer jobsMu.Unlock()
+		job.State = JobFinished
+		if ctx.Err() != nil && job.Done < job.Total {
+			job.State = JobCancelled
+		}
+		finished := time.Now().UTC()
+		job.FinishedAt = &finished
+		log.Printf("%s job %s %s: %d of %d files handled, %d failed.\n", job.Kind, job.ID, job.State, job.Done, job.Total, job.Failed)
+	}()
+	return job
+}
+
+// snapshot returns a copy of a job that can be encoded while it runs on.
+func (job *Job) snapshot(withResults bool) Job {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	copied := *job
+	copied.Results = nil
+	if withResults || job.State != JobRunning {
+		copied.Results = append([]JobResult{}, job.Results...)
+	}
+	return copied
+}
+
+// handleJobs lists the jobs, newest first, without their results.
+func handleJobs(w http.ResponseWriter, r *http.Request) {
+	jobsMu.Lock()
+	list := make([]*Job, 0, len(jobOrder))
+	for i := len(jobOrder) - 1; i >= 0; i-- {
+		list = append(list, jobs[jobOrder[i]])
+	}
+	jobsMu.Unlock()
+
+	snapshots := make([]Job, 0, len(list))
+	for _, job := range list {
+		snapshot := job.snapshot(false)
+		snapshot.Results = nil
+		snapshots = append(snapshots, snapshot)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, r, map[string]interface{}{"jobs": snapshots})
+}
+
+// handleJob reports the progress of /api/jobs/{id}, and cancels it on a POST
+// to /api/jobs/{id}/cancel.
+func handleJob(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/jobs/"), "/")
+	jobsMu.Lock()
+	job := jobs[id]
+	jobsMu.Unlock()
+	if job == nil {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+	case action == "cancel" && r.Method == http.MethodPost:
+		job.cancel()
+	case action == "" || action == "cancel":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, r, job.snapshot(r.URL.Query().Get("results") == "1"))
+}
+
+// handleRecycleJob starts a job recycling the files of a POST body
+// {"filePaths": [...]}, as one operation that can be undone as a whole.
+func handleRecycleJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var requestData struct {
+		FilePaths []string `json:"filePaths"`
+	}
+	if err := readJSON(r, &requestData); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	var filePaths []string
+	seen := make(map[string]bool)
+	for _, filePath := range requestData.FilePaths {
+		if filePath != "" && !seen[filePath] {
+			seen[filePath] = true
+			filePaths = append(filePaths, filePath)
+		}
+	}
+	if len(filePaths) == 0 {
+		http.Error(w, "File paths are required", http.StatusBadRequest)
+		return
+	}
+
+	job := startJob(string(database.OperationRecycle), len(filePaths), func(ctx context.Context, job *Job, handled func(JobResult)) {
+		operationID, err := database.BeginOperation(database.OperationRecycle)
+		if err != nil {
+			log.Printf("Warning: The recycles of job %s cannot be undone: %v\n", job.ID, err)
+		} else {
+			jobsMu.Lock()
+			job.OperationID = operationID
+			jobsMu.Unlock()
+		}
+		for _, filePath := range filePaths {
+			if ctx.Err() != nil {
+				return
+			}
+			recycledPath, err := recycleFile(filePath)
+			if err != nil {
+				handled(JobResult{FilePath: filePath, Error: err.Error()})
+				continue
+			}
+			if operationID != 0 {
+				if err := database.RecordMove(operationID, filePath, recycledPath); err != nil {
+					log.Printf("Warning: The recycle of %s cannot be undone: %v\n", filePath, err)
+				}
+			}
+			handled(JobResult{FilePath: filePath, RecycledPath: recycledPath})
+		}
+	})
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, r, job.snapshot(false))
+}
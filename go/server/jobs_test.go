@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// waitForJob waits until job is over and returns its final snapshot.
+func waitForJob(t *testing.T, job *Job) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if snapshot := job.snapshot(false); snapshot.State != JobRunning {
+			return snapshot
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Job %s is still running", job.ID)
+	return Job{}
+}
+
+// requestJob calls handleJob and decodes the job it reports.
+func requestJob(t *testing.T, method, path string) (int, Job) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handleJob(recorder, httptest.NewRequest(method, path, nil))
+	var job Job
+	if recorder.Code == http.StatusOK {
+		if err := json.NewDecoder(recorder.Body).Decode(&job); err != nil {
+			t.Fatalf("Failed to decode job: %v", err)
+		}
+	}
+	return recorder.Code, job
+}
+
+func TestJobLifecycle(t *testing.T) {
+	// A job over three files that waits for a go-ahead before each one
+	next, handledOne := make(chan bool), make(chan bool)
+	step := func(fail bool) {
+		next <- fail
+		<-handledOne
+	}
+	job := startJob("test", 3, func(ctx context.Context, job *Job, handled func(JobResult)) {
+		for i := 1; i <= 3; i++ {
+			select {
+			case <-ctx.Done():
+				return
+			case fail := <-next:
+				result := JobResult{FilePath: fmt.Sprintf("/photos/%d.jpg", i)}
+				if fail {
+					result.Error = "permission denied"
+				}
+				handled(result)
+				handledOne <- true
+			}
+		}
+	})
+	if started := job.snapshot(false); started.ID == "" || started.State != JobRunning || started.Total != 3 || started.StartedAt.IsZero() {
+		t.Fatalf("Started job %+v", started)
+	}
+
+	// Progress is reported while it runs, the results only when asked for
+	step(false)
+	step(true)
+	code, progress := requestJob(t, http.MethodGet, "/api/jobs/"+job.ID)
+	if code != http.StatusOK || progress.State != JobRunning || progress.Done != 2 || progress.Failed != 1 || progress.FinishedAt != nil || progress.Results != nil {
+		t.Errorf("Progress %d %+v; expected 2 of 3 files done, 1 failed, no results", code, progress)
+	}
+	if _, progress := requestJob(t, http.MethodGet, "/api/jobs/"+job.ID+"?results=1"); len(progress.Results) != 2 || progress.Results[1].Error == "" {
+		t.Errorf("Results while running = %+v; expected both files, the second failed", progress.Results)
+	}
+
+	step(false)
+	finished := waitForJob(t, job)
+	if finished.State != JobFinished || finished.Done != 3 || finished.Failed != 1 || finished.FinishedAt == nil || len(finished.Results) != 3 {
+		t.Errorf("Finished job %+v; expected all 3 files handled with their results", finished)
+	}
+	// Cancelling a finished job changes nothing
+	if code, cancelled := requestJob(t, http.MethodPost, "/api/jobs/"+job.ID+"/cancel"); code != http.StatusOK || cancelled.State != JobFinished {
+		t.Errorf("Cancelling the finished job: %d, state %s; expected it finished", code, cancelled.State)
+	}
+}
+
+func TestJobCancel(t *testing.T) {
+	started := make(chan bool)
+	job := startJob("test", 2, func(ctx context.Context, job *Job, handled func(JobResult)) {
+		handled(JobResult{FilePath: "/photos/1.jpg"})
+		close(started)
+		<-ctx.Done()
+	})
+	<-started
+	if code, _ := requestJob(t, http.MethodGet, "/api/jobs/"+job.ID+"/cancel"); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET cancel = %d; expected %d", code, http.StatusMethodNotAllowed)
+	}
+	if code, _ := requestJob(t, http.MethodPost, "/api/jobs/"+job.ID+"/cancel"); code != http.StatusOK {
+		t.Errorf("POST cancel = %d; expected %d", code, http.StatusOK)
+	}
+	cancelled := waitForJob(t, job)
+	if cancelled.State != JobCancelled || cancelled.Done != 1 || cancelled.FinishedAt == nil || len(cancelled.Results) != 1 {
+		t.Errorf("Cancelled job %+v; expected 1 of 2 files handled", cancelled)
+	}
+
+	if code, _ := requestJob(t, http.MethodGet, "/api/jobs/unknown"); code != http.StatusNotFound {
+		t.Errorf("Unknown job = %d; expected %d", code, http.StatusNotFound)
+	}
+	if code, _ := requestJob(t, http.MethodGet, "/api/jobs/"+job.ID+"/pause"); code != http.StatusNotFound {
+		t.Errorf("Unknown action = %d; expected %d", code, http.StatusNotFound)
+	}
+}
+
+func TestJobsList(t *testing.T) {
+	// A running job is kept however many jobs finish after it
+	release := make(chan bool)
+	running := startJob("test", 1, func(ctx context.Context, job *Job, handled func(JobResult)) { <-release })
+	defer close(release)
+	var last *Job
+	for i := 0; i < maxKeptJobs+5; i++ {
+		last = startJob("test", 0, func(ctx context.Context, job *Job, handled func(JobResult)) {})
+		waitForJob(t, last)
+	}
+
+	recorder := httptest.NewRecorder()
+	handleJobs(recorder, httptest.NewRequest(http.MethodGet, "/api/jobs", nil))
+	var response struct {
+		Jobs []Job `json:"jobs"`
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode jobs: %v", err)
+	}
+	if len(response.Jobs) != maxKeptJobs {
+		t.Errorf("Listed %d jobs; expected the %d kept", len(response.Jobs), maxKeptJobs)
+	}
+	if len(response.Jobs) > 0 && response.Jobs[0].ID != last.ID {
+		t.Errorf("Listed %s first; expected the newest job %s", response.Jobs[0].ID, last.ID)
+	}
+	found := false
+	for _, job := range response.Jobs {
+		found = found || job.ID == running.ID
+		if job.Results != nil {
+			t.Errorf("Job %s listed with its results", job.ID)
+		}
+	}
+	if !found {
+		t.Errorf("The running job %s was forgotten", running.ID)
+	}
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"picpurge/database"
+	"picpurge/walker"
+)
+
+// LaunchConfig controls opening originals in desktop applications on the server host.
+// Launching is off unless Enabled is set.
+type LaunchConfig struct {
+	Enabled bool
+	Editor  string // editor command line; the file path is appended as the last argument
+}
+
+var (
+	launchConfig   LaunchConfig
+	launchConfigMu sync.RWMutex
+)
+
+// SetLaunchConfig enables or disables the launch endpoint.
+func SetLaunchConfig(c LaunchConfig) {
+	launchConfigMu.Lock()
+	defer launchConfigMu.Unlock()
+	launchConfig = c
+}
+
+func getLaunchConfig() LaunchConfig {
+	launchConfigMu.RLock()
+	defer launchConfigMu.RUnlock()
+	return launchConfig
+}
+
+// forwardingHeaders are set by reverse proxies. A proxy on the server host
+// connects from loopback for every client it forwards, so requests passed on
+// by one are never taken as local.
+var forwardingHeaders = []string{"Forwarded", "X-Forwarded-For", "X-Forwarded-Host", "X-Real-IP"}
+
+// isLocalRequest reports whether the request came from the server host itself,
+// not through a reverse proxy, and, for browser requests, from a page served
+// by this server.
+func isLocalRequest(r *http.Request) bool {
+	for _, header := range forwardingHeaders {
+		if len(r.Header.Values(header)) > 0 {
+			return false
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return false
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host != r.Host {
+			return false
+		}
+	}
+	return true
+}
+
+// launchablePath returns the catalog path of an image after checking it is still a
+// regular, non-recycled image file. Only paths from the catalog are ever launched.
+func launchablePath(id int) (string, error) {
+	db, err := database.GetDBInstance()
+	if err != nil {
+		return "", err
+	}
+	var filePath string
+	var isRecycled bool
+	err = db.QueryRow("SELECT file_path, is_recycled FROM images WHERE id = ?", id).Scan(&filePath, &isRecycled)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("image %d not found", id)
+	}
+	if err != nil {
+		return "", err
+	}
+	if isRecycled {
+		return "", fmt.Errorf("image %d has been recycled", id)
+	}
+
+	filePath, err = filepath.Abs(filepath.Clean(filePath))
+	if err != nil {
+		return "", err
+	}
+	if !walker.IsImageFile(filePath) {
+		return "", fmt.Errorf("%s is not a supported image file", filePath)
+	}
+	info, err := os.Lstat(filePath)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a regular file", filePath)
+	}
+	return filePath, nil
+}
+
+// launchCommand builds the command that opens filePath. No shell is involved, so the
+// path is always passed as a single argument.
+func launchCommand(editor, filePath string) (*exec.Cmd, error) {
+	if args := strings.Fields(editor); len(args) > 0 {
+		return exec.Command(args[0], append(args[1:], filePath)...), nil
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", filePath), nil
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", filePath), nil
+	case "linux", "freebsd", "openbsd", "netbsd":
+		return exec.Command("xdg-open", filePath), nil
+	}
+	return nil, fmt.Errorf("opening files is not supported on %s", runtime.GOOS)
+}
+
+// decodeLaunchRequest applies the checks shared by endpoints that start desktop
+// applications and decodes the JSON body into v. It writes the error response and
+// returns false when the request is refused.
+func decodeLaunchRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if !getLaunchConfig().Enabled {
+		http.Error(w, "Launching applications is disabled; start the scan with --allow-launch", http.StatusForbidden)
+		return false
+	}
+	if !isLocalRequest(r) {
+		http.Error(w, "Launching applications is only allowed from the server host", http.StatusForbidden)
+		return false
+	}
+	// A JSON body cannot be sent cross-origin without a CORS preflight, which this server never grants.
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return false
+	}
+	if err := readJSON(r, v); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// handleLaunch reports whether launching is enabled (GET) or opens an original in the
+// default viewer or the configured editor (POST).
+func handleLaunch(w http.ResponseWriter, r *http.Request) {
+	config := getLaunchConfig()
+
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, r, map[string]interface{}{
+			"enabled":   config.Enabled,
+			"hasEditor": config.Enabled && strings.TrimSpace(config.Editor) != "",
+		})
+		return
+	}
+
+	var requestData struct {
+		ID     int  `json:"id"`
+		Editor bool `json:"editor"`
+	}
+	if !decodeLaunchRequest(w, r, &requestData) {
+		return
+	}
+	if requestData.Editor && strings.TrimSpace(config.Editor) == "" {
+		http.Error(w, "No editor configured; start the scan with --editor", http.StatusBadRequest)
+		return
+	}
+
+	filePath, err := launchablePath(requestData.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	editor := ""
+	if requestData.Editor {
+		editor = config.Editor
+	}
+	cmd, err := launchCommand(editor, filePath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to launch %s: %v", cmd.Path, err), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Opened %s with %s\n", filePath, cmd.Path)
+	go cmd.Wait() // reap the viewer once it exits
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, r, map[string]interface{}{
+		"success": true,
+	})
+}
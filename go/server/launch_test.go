@@ -0,0 +1,37 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsLocalRequest(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		remoteAddr string
+		header     http.Header
+		local      bool
+	}{
+		{"loopback", "127.0.0.1:50000", nil, true},
+		{"IPv6 loopback", "[::1]:50000", nil, true},
+		{"same origin", "127.0.0.1:50000", http.Header{"Origin": {"http://example.com"}}, true},
+		{"remote", "192.168.1.20:50000", nil, false},
+		{"unix socket", "@", nil, false},
+		{"other origin", "127.0.0.1:50000", http.Header{"Origin": {"http://evil.example"}}, false},
+		{"X-Forwarded-For", "127.0.0.1:50000", http.Header{"X-Forwarded-For": {"203.0.113.7"}}, false},
+		{"X-Forwarded-Host", "127.0.0.1:50000", http.Header{"X-Forwarded-Host": {"example.com"}}, false},
+		{"Forwarded", "127.0.0.1:50000", http.Header{"Forwarded": {"for=203.0.113.7"}}, false},
+		{"X-Real-IP", "127.0.0.1:50000", http.Header{"X-Real-Ip": {"203.0.113.7"}}, false},
+		{"empty X-Forwarded-For", "127.0.0.1:50000", http.Header{"X-Forwarded-For": {""}}, false},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "http://example.com/api/launch", nil)
+		r.RemoteAddr = tc.remoteAddr
+		for name, values := range tc.header {
+			r.Header[name] = values
+		}
+		if local := isLocalRequest(r); local != tc.local {
+			t.Errorf("%s: isLocalRequest = %v; expected %v", tc.name, local, tc.local)
+		}
+	}
+}
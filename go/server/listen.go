@@ -0,0 +1,70 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// unixSocketPrefix marks a listen address as the path of a Unix domain
+// socket, e.g. unix:/run/picpurge.sock for a reverse proxy to connect to.
+const unixSocketPrefix = "unix:"
+
+// listenAddress is an address StartServer accepts connections on.
+type listenAddress struct {
+	network string // tcp or unix
+	address string
+}
+
+// parseListenAddress reads an address given to StartServer: a Unix socket
+// path after unix:, or a TCP host and port. IPv6 hosts may be given with or
+// without brackets; a host or IP alone listens on port, a number alone on that
+// port of every interface.
+func parseListenAddress(s string, port int) (listenAddress, error) {
+	s = strings.TrimSpace(s)
+	if path, ok := strings.CutPrefix(s, unixSocketPrefix); ok {
+		if path == "" {
+			return listenAddress{}, fmt.Errorf("listen address %q has no socket path", s)
+		}
+		return listenAddress{"unix", path}, nil
+	}
+	if _, err := strconv.Atoi(s); err == nil {
+		return listenAddress{"tcp", ":" + s}, nil
+	}
+	host, portText, err := net.SplitHostPort(s)
+	if err != nil {
+		// No port, as in localhost, ::1 or [::1]
+		host, portText = s, strconv.Itoa(port)
+		if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+			host = s[1 : len(s)-1]
+		}
+	}
+	if strings.Contains(host, ":") && net.ParseIP(host) == nil {
+		return listenAddress{}, fmt.Errorf("listen address %q is not a valid IPv6 address", s)
+	}
+	return listenAddress{"tcp", net.JoinHostPort(host, portText)}, nil
+}
+
+func (a listenAddress) String() string {
+	if a.network == "unix" {
+		return unixSocketPrefix + a.address
+	}
+	return a.address
+}
+
+// listen opens the listener of a. A socket file left behind by a server that
+// did not shut down cleanly is replaced; any other file at the path is not.
+func (a listenAddress) listen() (net.Listener, error) {
+	if a.network == "unix" {
+		if info, err := os.Lstat(a.address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if conn, err := net.Dial("unix", a.address); err == nil {
+				conn.Close()
+				return nil, fmt.Errorf("another server is listening on %s", a.address)
+			}
+			os.Remove(a.address)
+		}
+	}
+	return net.Listen(a.network, a.address)
+}
@@ -0,0 +1,24 @@
+package server
+
+import (
+	"net/http"
+
+	"picpurge/database"
+)
+
+// handlePDFMatches lists the PDF pages rendered by scans with --pdf-previews
+// that show catalogued images.
+func handlePDFMatches(w http.ResponseWriter, r *http.Request) {
+	matches, err := database.PDFMatches()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if matches == nil {
+		matches = []database.PDFMatch{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, r, map[string]interface{}{
+		"matches": matches,
+	})
+}
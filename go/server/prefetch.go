@@ -0,0 +1,197 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"picpurge/database"
+	"picpurge/walker"
+)
+
+// Prefetch hint kinds.
+const (
+	prefetchPreview   = "preview"   // the full image or RAW preview, as /api/image/{id}
+	prefetchThumbnail = "thumbnail" // the grid thumbnail, as /thumbnails/{md5}
+)
+
+// defaultPrefetchGroups and maxPrefetchGroups bound how many groups after the
+// current one are hinted.
+const (
+	defaultPrefetchGroups = 3
+	maxPrefetchGroups     = 20
+)
+
+// prefetchHint is one URL the web interface should load ahead of time.
+type prefetchHint struct {
+	URL     string `json:"url"`
+	Kind    string `json:"kind"`
+	ImageID int    `json:"image_id"`
+	Group   string `json:"group"` // as in share links: an MD5 or a similar group ID
+}
+
+// handlePrefetch answers /api/images/prefetch?type=duplicates|similar&group=ID
+// with the URLs to warm while a group is reviewed, most urgent first: the
+// previews of the group itself, the thumbnails of the next few groups in
+// listing order, then the previews of the next group. On a slow NAS link the
+// client can fetch these in the background so the next group opens at once.
+func handlePrefetch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	groupType := r.URL.Query().Get("type")
+	if groupType == "" {
+		groupType = database.GroupTypeDuplicates
+	}
+	group := r.URL.Query().Get("group")
+	if group == "" {
+		http.Error(w, "group is required", http.StatusBadRequest)
+		return
+	}
+	db, err := database.GetDBInstance()
+	if err != nil {
+		http.Error(w, "Failed to connect to database", http.StatusInternalServerError)
+		return
+	}
+	keyColumn := "md5"
+	key := group
+	switch groupType {
+	case database.GroupTypeDuplicates:
+	case database.GroupTypeSimilar:
+		keyColumn = "similar_group"
+		var ok bool
+		if key, ok = similarGroupID(db, group); !ok {
+			http.Error(w, fmt.Sprintf("invalid similar group: %s", group), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "type must be duplicates or similar", http.StatusBadRequest)
+		return
+	}
+	count := defaultPrefetchGroups
+	if s := r.URL.Query().Get("groups"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("invalid groups: %s", s), http.StatusBadRequest)
+			return
+		}
+		count = min(n, maxPrefetchGroups)
+	}
+
+	current, err := prefetchMembers(db, keyColumn, key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(current) == 0 {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+
+	// Groups follow each other in key order, as in the listings of imageTypeOrders
+	rows, err := db.Query("SELECT DISTINCT "+keyColumn+" FROM images WHERE is_recycled = FALSE AND "+imageTypeConditions[groupType]+" AND "+keyColumn+" > ? ORDER BY "+keyColumn+" LIMIT ?", key, count)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to look up the next groups: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var nextKeys []string
+	for rows.Next() {
+		var next string
+		if err := rows.Scan(&next); err != nil {
+			rows.Close()
+			http.Error(w, fmt.Sprintf("failed to read the next groups: %v", err), http.StatusInternalServerError)
+			return
+		}
+		nextKeys = append(nextKeys, next)
+	}
+	rows.Close()
+	var next [][]prefetchMember
+	for _, nextKey := range nextKeys {
+		members, err := prefetchMembers(db, keyColumn, nextKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(members) > 0 {
+			next = append(next, members)
+		}
+	}
+
+	hints := []prefetchHint{}
+	seen := make(map[string]bool)
+	add := func(hint prefetchHint) {
+		if !seen[hint.URL] {
+			seen[hint.URL] = true
+			hints = append(hints, hint)
+		}
+	}
+	for _, member := range current {
+		if !member.video {
+			add(member.preview())
+		}
+	}
+	for _, members := range next {
+		for _, member := range members {
+			if member.thumbnailMD5 != "" {
+				add(member.thumbnail())
+			}
+		}
+	}
+	if len(next) > 0 {
+		for _, member := range next[0] {
+			if !member.video {
+				add(member.preview())
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := writeJSON(w, r, map[string]interface{}{"group": group, "type": groupType, "hints": hints}); err != nil {
+		log.Printf("Error writing prefetch hints: %v\n", err)
+	}
+}
+
+// prefetchMember is an image of a group, as far as prefetching needs it.
+type prefetchMember struct {
+	id           int
+	group        string
+	thumbnailMD5 string
+	video        bool // videos are streamed when played, never prefetched whole
+}
+
+func (m prefetchMember) preview() prefetchHint {
+	return prefetchHint{URL: appURL(fmt.Sprintf("/api/image/%d", m.id)), Kind: prefetchPreview, ImageID: m.id, Group: m.group}
+}
+
+func (m prefetchMember) thumbnail() prefetchHint {
+	return prefetchHint{URL: appURL("/thumbnails/" + m.thumbnailMD5), Kind: prefetchThumbnail, ImageID: m.id, Group: m.group}
+}
+
+// prefetchMembers lists the images of the group whose keyColumn is key,
+// largest first like the listings.
+func prefetchMembers(db *sql.DB, keyColumn, key string) ([]prefetchMember, error) {
+	rows, err := db.Query("SELECT id, file_path, md5, COALESCE(thumbnail_path, ''), COALESCE(format, '') FROM images WHERE is_recycled = FALSE AND "+keyColumn+" = ? ORDER BY image_width * image_height DESC, id", key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up group: %w", err)
+	}
+	defer rows.Close()
+
+	group := key
+	var members []prefetchMember
+	for rows.Next() {
+		var id int
+		var filePath, md5, thumbnailPath, format string
+		if err := rows.Scan(&id, &filePath, &md5, &thumbnailPath, &format); err != nil {
+			return nil, fmt.Errorf("failed to read group member: %w", err)
+		}
+		members = append(members, prefetchMember{
+			id: id, group: group, thumbnailMD5: thumbnailMD5(thumbnailPath, md5),
+			video: walker.IsVideo(filePath, format),
+		})
+	}
+	return members, rows.Err()
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"picpurge/database"
+	"picpurge/util"
+	"picpurge/walker"
+)
+
+// imageColumns are the images columns scanned into an Image, in scan order.
+const imageColumns = "id, file_path, file_name, file_size, md5, COALESCE(previous_md5, ''), image_width, image_height, device_make, device_model, lens_model, camera_serial, shutter_count, create_date, COALESCE(date_source, ''), exposure_bias, phash, palette, thumbnail_path, is_duplicate, duplicate_of, similar_images, same_shot_of, bracket_set, panorama_set, is_screenshot, COALESCE(low_info, ''), COALESCE(messenger, ''), COALESCE(edited_with, ''), is_recycled, is_favorite, COALESCE(rating, 0), COALESCE(duration, 0), COALESCE(format, ''), COALESCE(similar_group, '')"
+
+// imageQuery collects the conditions of an image listing, so filtering happens
+// in SQLite instead of on every row in memory. Recycled images are always excluded.
+type imageQuery struct {
+	conditions []string
+	args       []interface{}
+}
+
+// where adds a condition; its placeholders are bound to args.
+func (q *imageQuery) where(condition string, args ...interface{}) {
+	q.conditions = append(q.conditions, condition)
+	q.args = append(q.args, args...)
+}
+
+func (q imageQuery) clause() string {
+	return strings.Join(append([]string{"is_recycled = FALSE"}, q.conditions...), " AND ")
+}
+
+// count returns the number of images matching the query.
+func (q imageQuery) count(db *sql.DB) (int, error) {
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM images WHERE "+q.clause(), q.args...).Scan(&total); err != nil {
+		return 0, fmt.Errorf("failed to count images: %w", err)
+	}
+	return total, nil
+}
+
+// images returns the matching images sorted by orderBy. A positive limit
+// returns one page starting at offset; otherwise all matches are returned.
+// Tags are not loaded; see attachTags.
+func (q imageQuery) images(db *sql.DB, orderBy string, limit, offset int) ([]Image, error) {
+	query := "SELECT " + imageColumns + " FROM images WHERE " + q.clause()
+	args := q.args
+	if orderBy != "" {
+		query += " ORDER BY " + orderBy
+	}
+	if limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(append([]interface{}{}, args...), limit, offset)
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []Image
+	for rows.Next() {
+		var img Image
+		var duplicateOf sql.NullInt64
+		var similarImages sql.NullString
+		var sameShotOf sql.NullInt64
+		var exposureBias sql.NullFloat64
+		var bracketSet sql.NullInt64
+		var panoramaSet sql.NullInt64
+		var palette sql.NullString
+		var createDateStr string
+
+		err := rows.Scan(
+			&img.ID, &img.FilePath, &img.FileName, &img.FileSize, &img.MD5, &img.PreviousMD5, &img.ImageWidth, &img.ImageHeight,
+			&img.DeviceMake, &img.DeviceModel, &img.LensModel, &img.CameraSerial, &img.ShutterCount,
+			&createDateStr, &img.DateSource, &exposureBias, &img.PHash, &palette, &img.ThumbnailPath,
+			&img.IsDuplicate, &duplicateOf, &similarImages, &sameShotOf, &bracketSet, &panoramaSet, &img.IsScreenshot, &img.LowInfo, &img.Messenger, &img.EditedWith, &img.IsRecycled, &img.IsFavorite, &img.Rating, &img.Duration, &img.Format, &img.SimilarGroup,
+		)
+		if err != nil {
+			log.Printf("Error scanning image row: %v\n", err)
+			continue
+		}
+
+		img.CreateDate = createDateStr
+		img.FileName = util.DecodeText([]byte(img.FileName))
+		img.DisplayPath = displayPath(img.FilePath)
+		img.Volume = util.VolumeName(img.FilePath)
+		img.RevealURI = revealURI(img.FilePath)
+		img.IsVideo = walker.IsVideo(img.FilePath, img.Format)
+		if duplicateOf.Valid {
+			val := int(duplicateOf.Int64)
+			img.DuplicateOf = &val
+		}
+		if similarImages.Valid {
+			img.SimilarImages = similarImages.String
+		}
+		if palette.Valid && palette.String != "" {
+			if err := json.Unmarshal([]byte(palette.String), &img.Palette); err != nil {
+				log.Printf("Warning: Could not parse palette of image ID %d: %v\n", img.ID, err)
+			} else if len(img.Palette) > 0 {
+				img.DominantColor = img.Palette[0]
+			}
+		}
+		if sameShotOf.Valid {
+			val := int(sameShotOf.Int64)
+			img.SameShotOf = &val
+		}
+		if exposureBias.Valid {
+			val := exposureBias.Float64
+			img.ExposureBias = &val
+		}
+		if bracketSet.Valid {
+			val := int(bracketSet.Int64)
+			img.BracketSet = &val
+		}
+		if panoramaSet.Valid {
+			val := int(panoramaSet.Int64)
+			img.PanoramaSet = &val
+		}
+
+		images = append(images, img)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// imagesByID returns the active images among ids in the order of ids, e.g.
+// one page of ranked search results. Tags are not loaded; see attachTags.
+func imagesByID(db *sql.DB, ids []int) ([]Image, error) {
+	byID := make(map[int]Image, len(ids))
+	const chunkSize = 500 // well below SQLite's limit of variables
+	for start := 0; start < len(ids); start += chunkSize {
+		chunk := ids[start:min(start+chunkSize, len(ids))]
+		args := make([]interface{}, len(chunk))
+		for i, id := range chunk {
+			args[i] = id
+		}
+		var q imageQuery
+		q.where("id IN ("+strings.TrimSuffix(strings.Repeat("?, ", len(chunk)), ", ")+")", args...)
+		images, err := q.images(db, "", 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, img := range images {
+			byID[img.ID] = img
+		}
+	}
+	images := make([]Image, 0, len(byID))
+	for _, id := range ids {
+		if img, ok := byID[id]; ok {
+			images = append(images, img)
+		}
+	}
+	return images, nil
+}
+
+// attachTags loads the tags of the given images, typically one page of a listing.
+func attachTags(images []Image) error {
+	if len(images) == 0 {
+		return nil
+	}
+	ids := make([]int, len(images))
+	for i, img := range images {
+		ids[i] = img.ID
+	}
+	tags, err := database.GetImageTags(ids...)
+	if err != nil {
+		return err
+	}
+	for i := range images {
+		images[i].Tags = tags[images[i].ID]
+	}
+	return nil
+}
+
+// folderPrefix returns the path prefix shared by every file inside folder.
+func folderPrefix(folder string) string {
+	prefix := filepath.Clean(folder)
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+	return prefix
+}
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"picpurge/database"
+)
+
+// recycleDir is where the web interface recycles files to, relative to the
+// working directory as for scan.
+const recycleDir = "Recycle"
+
+// handleRecycleStats reports how many files and bytes sit in the recycle
+// directory, by the folder they came from and by how long ago they were
+// recycled, so users can tell when to purge old ones.
+func handleRecycleStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := database.GetRecycleStats(recycleDir, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, r, stats)
+}
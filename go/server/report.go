@@ -0,0 +1,88 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"sync"
+
+	"picpurge/database"
+)
+
+// Report queries let power users build custom reports with SQL instead of
+// exporting the catalog. They are off unless a token is set, and run read-only
+// against the report views of the catalog.
+
+const (
+	defaultQueryRows = 1000
+	maxQueryRows     = 10000
+)
+
+var (
+	queryToken   string
+	queryTokenMu sync.RWMutex
+)
+
+// SetQueryToken enables /api/query for requests that send token as a bearer
+// token. An empty token disables it.
+func SetQueryToken(token string) {
+	queryTokenMu.Lock()
+	defer queryTokenMu.Unlock()
+	queryToken = token
+}
+
+func getQueryToken() string {
+	queryTokenMu.RLock()
+	defer queryTokenMu.RUnlock()
+	return queryToken
+}
+
+// handleQuery lists the report views on GET and runs the SELECT statement of
+// a POST body {"sql": ..., "limit": ...} against them.
+func handleQuery(w http.ResponseWriter, r *http.Request) {
+	token := getQueryToken()
+	if token == "" {
+		http.Error(w, "Report queries are disabled; start the server with --query-token", http.StatusNotFound)
+		return
+	}
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="picpurge"`)
+		http.Error(w, "A valid bearer token is required", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, r, map[string]interface{}{"views": database.ReportViews()})
+	case http.MethodPost:
+		var requestData struct {
+			SQL   string `json:"sql"`
+			Limit int    `json:"limit"`
+		}
+		if err := readJSON(r, &requestData); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(requestData.SQL) == "" {
+			http.Error(w, "A SELECT statement is required", http.StatusBadRequest)
+			return
+		}
+		limit := requestData.Limit
+		if limit <= 0 {
+			limit = defaultQueryRows
+		}
+		limit = min(limit, maxQueryRows)
+		report, err := database.RunReport(r.Context(), requestData.SQL, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, r, report)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
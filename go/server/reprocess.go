@@ -0,0 +1,85 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"picpurge/database"
+	"picpurge/events"
+	"picpurge/processor"
+)
+
+// ReprocessImage hashes, reads the EXIF of and thumbnails the file of a
+// catalogued image again, e.g. after it was repaired or its EXIF edited, and
+// updates its record and groups. It returns the new image data.
+func ReprocessImage(id int) (*processor.ImageData, error) {
+	db, err := database.GetDBInstance()
+	if err != nil {
+		return nil, err
+	}
+	var filePath, oldMD5 string
+	if err := db.QueryRow("SELECT file_path, md5 FROM images WHERE id = ? AND is_recycled = FALSE", id).Scan(&filePath, &oldMD5); err != nil {
+		return nil, fmt.Errorf("image ID %d is not in the catalog", id)
+	}
+
+	imageData, thumbnailData, err := processor.ProcessImage(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process %s: %w", filePath, err)
+	}
+	if err := database.StoreScanResult(database.ScanResult{ImageData: imageData, ThumbnailData: thumbnailData}); err != nil {
+		return nil, fmt.Errorf("failed to update image ID %d: %w", id, err)
+	}
+	// Keep a RAW thumbnail an earlier run rendered over the placeholder
+	if thumbnailData != nil && !(imageData.RawPending && GetThumbnailFromMemory(imageData.MD5) != nil) {
+		AddThumbnailToMemory(imageData.MD5, thumbnailData)
+	}
+	if err := database.RegroupImage(id, oldMD5); err != nil {
+		return nil, fmt.Errorf("failed to update groups of image ID %d: %w", id, err)
+	}
+	if imageData.MD5 != oldMD5 {
+		events.Publish(events.Event{Kind: events.ImageUpdated, ImageID: id, MD5: imageData.MD5, OldMD5: oldMD5, Path: filePath})
+	}
+	return imageData, nil
+}
+
+// handleReprocess processes one image again and answers with its updated
+// record. The path is /api/image/{id}/reprocess.
+func handleReprocess(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/image/"), "/reprocess"))
+	if err != nil {
+		http.Error(w, "Invalid image ID", http.StatusBadRequest)
+		return
+	}
+	db, err := database.GetDBInstance()
+	if err != nil {
+		http.Error(w, "Failed to connect to database", http.StatusInternalServerError)
+		return
+	}
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM images WHERE id = ? AND is_recycled = FALSE)", id).Scan(&exists); err != nil || !exists {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	if _, err := ReprocessImage(id); err != nil {
+		log.Printf("Error reprocessing image ID %d: %v\n", id, err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	var q imageQuery
+	q.where("id = ?", id)
+	images, err := q.images(db, "", 0, 0)
+	if err != nil || len(images) == 0 {
+		http.Error(w, "Failed to load image", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, r, images[0])
+}
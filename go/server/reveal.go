@@ -0,0 +1,92 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"picpurge/util"
+)
+
+// homeDir is shortened to ~ in display paths.
+var homeDir, _ = os.UserHomeDir()
+
+// displayPath returns a cleaned, platform-native path with the home directory shortened to ~.
+func displayPath(filePath string) string {
+	// Names from archives made on Chinese or Japanese systems may be GBK or Shift-JIS
+	p := util.DecodeText([]byte(filepath.Clean(filepath.FromSlash(filePath))))
+	if homeDir != "" {
+		if rel, err := filepath.Rel(homeDir, p); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return filepath.Join("~", rel)
+		}
+	}
+	return p
+}
+
+// revealURI returns a file URI for the folder containing filePath. Browsers usually
+// refuse to follow it from an http page, but it can be copied into a file manager.
+func revealURI(filePath string) string {
+	dir := filepath.ToSlash(filepath.Dir(filePath))
+	if !strings.HasPrefix(dir, "/") {
+		dir = "/" + dir // Windows drive paths become file:///C:/...
+	}
+	return (&url.URL{Scheme: "file", Path: dir}).String()
+}
+
+// revealCommand builds the command that shows filePath selected in the platform's file manager.
+func revealCommand(filePath string) (*exec.Cmd, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", "-R", filePath), nil
+	case "windows":
+		return exec.Command("explorer", "/select,"+filePath), nil
+	case "linux", "freebsd", "openbsd", "netbsd":
+		// Most desktop file managers implement the FileManager1 interface; otherwise just open the folder.
+		if _, err := exec.LookPath("dbus-send"); err == nil {
+			fileURI := (&url.URL{Scheme: "file", Path: filePath}).String()
+			return exec.Command("dbus-send", "--session", "--print-reply", "--dest=org.freedesktop.FileManager1",
+				"/org/freedesktop/FileManager1", "org.freedesktop.FileManager1.ShowItems",
+				"array:string:"+fileURI, "string:"), nil
+		}
+		return exec.Command("xdg-open", filepath.Dir(filePath)), nil
+	}
+	return nil, fmt.Errorf("revealing files is not supported on %s", runtime.GOOS)
+}
+
+// handleReveal shows an original selected in the host's file manager.
+func handleReveal(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		ID int `json:"id"`
+	}
+	if !decodeLaunchRequest(w, r, &requestData) {
+		return
+	}
+
+	filePath, err := launchablePath(requestData.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	cmd, err := revealCommand(filePath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to launch %s: %v", cmd.Path, err), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Revealed %s with %s\n", filePath, cmd.Path)
+	go cmd.Wait()
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, r, map[string]interface{}{
+		"success": true,
+	})
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+
+	"picpurge/database"
+)
+
+// ScanController is implemented by a running scan that can be paused and
+// resumed, or throttled to fewer workers while someone is browsing.
+type ScanController interface {
+	Pause()
+	Resume()
+	Paused() bool
+	Throttle(workers int)
+	Throttled() int
+}
+
+var (
+	scanController   ScanController
+	scanControllerMu sync.RWMutex
+)
+
+// SetScanController registers the running scan with the API. Pass nil once the scan is done.
+func SetScanController(c ScanController) {
+	scanControllerMu.Lock()
+	defer scanControllerMu.Unlock()
+	scanController = c
+}
+
+func getScanController() ScanController {
+	scanControllerMu.RLock()
+	defer scanControllerMu.RUnlock()
+	return scanController
+}
+
+// handleScanPause stops workers from picking up new files; in-flight files finish.
+func handleScanPause(w http.ResponseWriter, r *http.Request) {
+	handleScanControl(w, r, func(c ScanController) { c.Pause() })
+}
+
+// handleScanResume lets a paused scan continue.
+func handleScanResume(w http.ResponseWriter, r *http.Request) {
+	handleScanControl(w, r, func(c ScanController) { c.Resume() })
+}
+
+func handleScanControl(w http.ResponseWriter, r *http.Request, action func(ScanController)) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	c := getScanController()
+	if c == nil {
+		http.Error(w, "No scan is running", http.StatusConflict)
+		return
+	}
+	action(c)
+
+	response := map[string]interface{}{
+		"success": true,
+		"paused":  c.Paused(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, r, response)
+}
+
+// handleScanStatus reports the checkpointed phases of the current run.
+func handleScanStatus(w http.ResponseWriter, r *http.Request) {
+	phases, err := database.GetPhases()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	current, err := database.CurrentPhase()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	paused, throttled := false, 0
+	if c := getScanController(); c != nil {
+		paused, throttled = c.Paused(), c.Throttled()
+	}
+
+	response := map[string]interface{}{
+		"phases":       phases,
+		"currentPhase": current,
+		"paused":       paused,
+		"throttled":    throttled, // workers per pool while someone is browsing, 0 at full speed
+	}
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, r, response)
+}
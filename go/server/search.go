@@ -0,0 +1,193 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"picpurge/database"
+	"picpurge/processor"
+)
+
+// handleSearch returns images whose file name, path or extracted text matches the q parameter, best matches first.
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		http.Error(w, "Query is required", http.StatusBadRequest)
+		return
+	}
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if page <= 0 {
+		page = 1
+	}
+	if limit <= 0 {
+		limit = 50
+	}
+
+	ids, err := database.SearchImages(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Only the requested page is loaded, in the order of the ranking
+	totalImages := len(ids)
+	start := min((page-1)*limit, totalImages)
+	end := min(start+limit, totalImages)
+	db, err := database.GetDBInstance()
+	if err != nil {
+		http.Error(w, "Failed to connect to database", http.StatusInternalServerError)
+		return
+	}
+	images, err := imagesByID(db, ids[start:end])
+	if err != nil {
+		http.Error(w, "Failed to fetch images", http.StatusInternalServerError)
+		return
+	}
+	if err := attachTags(images); err != nil {
+		log.Printf("Warning: Could not load image tags: %v\n", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, r, map[string]interface{}{
+		"images":      images,
+		"totalImages": totalImages,
+	})
+}
+
+// maxExampleSize is the largest image handleSearchByImage accepts.
+const maxExampleSize = 256 << 20
+
+// defaultExampleDistance is the pHash distance handleSearchByImage looks
+// within by default; looser than grouping, as the closest images are wanted
+// even if they would not be grouped.
+const defaultExampleDistance = 10
+
+// ExampleMatch is a catalog image found for an uploaded example.
+type ExampleMatch struct {
+	Image    Image `json:"image"`
+	Distance int   `json:"distance"` // pHash distance to the example
+	Turned   bool  `json:"turned"`   // the image matches the example turned or mirrored
+	Exact    bool  `json:"exact"`    // the image has the content of the example
+}
+
+// handleSearchByImage hashes an uploaded image, sent as the "image" field of
+// a multipart form or as the request body, and returns the catalog images
+// closest to it. The example is not added to the catalog. Optional query
+// parameters: maxDistance, the largest pHash distance to return, and limit.
+func handleSearchByImage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	maxDistance := defaultExampleDistance
+	if s := r.URL.Query().Get("maxDistance"); s != "" {
+		d, err := strconv.Atoi(s)
+		if err != nil || d < 0 || d > 64 {
+			http.Error(w, "maxDistance must be between 0 and 64", http.StatusBadRequest)
+			return
+		}
+		maxDistance = d
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = 20
+	}
+
+	examplePath, err := saveExample(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer os.Remove(examplePath)
+	example, _, err := processor.AnalyzeImage(examplePath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read image: %v", err), http.StatusBadRequest)
+		return
+	}
+	var phashes processor.PHashes
+	if example.PHash != "" {
+		if phashes, err = processor.ParsePHashes(example.PHash, example.TurnedPHashes); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to hash image: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	found, err := database.FindSimilarTo(example.MD5, phashes, maxDistance)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Only the closest matches are loaded, closest first
+	truncated := len(found) > limit
+	if truncated {
+		found = found[:limit]
+	}
+	ids := make([]int, len(found))
+	for i, match := range found {
+		ids[i] = match.ID
+	}
+	db, err := database.GetDBInstance()
+	if err != nil {
+		http.Error(w, "Failed to connect to database", http.StatusInternalServerError)
+		return
+	}
+	images, err := imagesByID(db, ids)
+	if err != nil {
+		http.Error(w, "Failed to fetch images", http.StatusInternalServerError)
+		return
+	}
+	if err := attachTags(images); err != nil {
+		log.Printf("Warning: Could not load image tags: %v\n", err)
+	}
+	byID := make(map[int]Image, len(images))
+	for _, img := range images {
+		byID[img.ID] = img
+	}
+	matches := []ExampleMatch{}
+	for _, match := range found {
+		if img, ok := byID[match.ID]; ok {
+			matches = append(matches, ExampleMatch{Image: img, Distance: match.Distance, Turned: match.Turned, Exact: match.Exact})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, r, map[string]interface{}{
+		"md5":       example.MD5,
+		"hashed":    example.PHash != "", // false for images that could not be decoded, which only match exact copies
+		"matches":   matches,
+		"truncated": truncated,
+	})
+}
+
+// saveExample writes the image uploaded to handleSearchByImage to a temporary
+// file, keeping its extension so RAW files and videos are recognised.
+func saveExample(w http.ResponseWriter, r *http.Request) (string, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxExampleSize)
+	var upload io.Reader = r.Body
+	name := ""
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		file, header, err := r.FormFile("image")
+		if err != nil {
+			return "", fmt.Errorf("an image field is required: %w", err)
+		}
+		defer file.Close()
+		upload, name = file, header.Filename
+	}
+	tempFile, err := os.CreateTemp("", "picpurge-example-*"+filepath.Ext(name))
+	if err != nil {
+		return "", fmt.Errorf("failed to store image: %w", err)
+	}
+	defer tempFile.Close()
+	if _, err := io.Copy(tempFile, upload); err != nil {
+		os.Remove(tempFile.Name())
+		return "", fmt.Errorf("failed to receive image: %w", err)
+	}
+	return tempFile.Name(), nil
+}
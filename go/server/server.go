@@ -2,22 +2,27 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"embed"
-	"encoding/json"
 	"fmt"
+	"image/color"
 	"io/fs"
 	"log"
+	"net"
 	"net/http"
-	"os/exec"
-	"path/filepath"
+	"os"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"picpurge/database"
+	"picpurge/fileops"
+	"picpurge/processor"
 	"picpurge/util"
+	"picpurge/walker"
 )
 
 //go:embed web/*
@@ -27,38 +32,163 @@ var webFiles embed.FS
 var thumbnailMemoryStore = make(map[string][]byte)
 var thumbnailMutex sync.RWMutex // Mutex to protect concurrent access to the maps
 
+// thumbnailMemoryLimit caps the bytes kept in thumbnailMemoryStore to a
+// quarter of a container's memory limit, so large libraries are not
+// OOM-killed. Thumbnails that do not fit are read from the catalog when asked
+// for. 0 keeps every thumbnail in memory.
+var thumbnailMemoryLimit = util.DetectResourceLimits().Memory / 4
+var thumbnailMemoryUsed uint64
+
 // AddThumbnailToMemory adds a thumbnail to the in-memory store.
 func AddThumbnailToMemory(md5 string, data []byte) {
 	thumbnailMutex.Lock()
 	defer thumbnailMutex.Unlock()
+	removeThumbnailLocked(md5)
+	if thumbnailMemoryLimit > 0 {
+		// Map order is random, which makes this a random eviction
+		for evict := range thumbnailMemoryStore {
+			if thumbnailMemoryUsed+uint64(len(data)) <= thumbnailMemoryLimit {
+				break
+			}
+			removeThumbnailLocked(evict)
+		}
+	}
 	thumbnailMemoryStore[md5] = data
+	thumbnailMemoryUsed += uint64(len(data))
 }
 
-// GetThumbnailFromMemory retrieves a thumbnail from the in-memory store.
+// removeThumbnailLocked drops a thumbnail from the in-memory store. The caller
+// holds thumbnailMutex.
+func removeThumbnailLocked(md5 string) {
+	if data, ok := thumbnailMemoryStore[md5]; ok {
+		thumbnailMemoryUsed -= uint64(len(data))
+		delete(thumbnailMemoryStore, md5)
+	}
+}
+
+// GetThumbnailFromMemory retrieves a thumbnail from the in-memory store, or
+// from the catalog when the store is capped and it was evicted.
 func GetThumbnailFromMemory(md5 string) []byte {
 	thumbnailMutex.RLock()
-	defer thumbnailMutex.RUnlock()
-	return thumbnailMemoryStore[md5]
+	data := thumbnailMemoryStore[md5]
+	thumbnailMutex.RUnlock()
+	if data != nil || thumbnailMemoryLimit == 0 {
+		return data
+	}
+	data, err := database.Thumbnail(md5)
+	if err != nil {
+		log.Printf("Warning: %v\n", err)
+		return nil
+	}
+	if data != nil {
+		AddThumbnailToMemory(md5, data)
+	}
+	return data
+}
+
+// StartServer starts the HTTP server and serves until ctx is cancelled, when it
+// shuts down gracefully and returns nil. It listens on the addresses of
+// listen as Listen does.
+func StartServer(ctx context.Context, port int, listen []string) error {
+	listeners, err := Listen(port, listen)
+	if err != nil {
+		return err
+	}
+	return Serve(ctx, listeners)
 }
 
-// StartServer starts the HTTP server.
-func StartServer(port int) error {
+// Listen opens a listener on every address of listen: a host and port, a
+// host or IPv6 address alone on port, a port alone, or unix: and the path of a
+// Unix domain socket. Without any, it listens on port of every interface.
+// Commands that work before serving listen first, so a port that is taken
+// stops them at once instead of after hours of scanning.
+func Listen(port int, listen []string) ([]net.Listener, error) {
+	if len(listen) == 0 {
+		listen = []string{strconv.Itoa(port)}
+	}
+	var listeners []net.Listener
+	closeListeners := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+	for _, s := range listen {
+		address, err := parseListenAddress(s, port)
+		if err != nil {
+			closeListeners()
+			return nil, err
+		}
+		l, err := address.listen()
+		if err != nil {
+			closeListeners()
+			return nil, fmt.Errorf("server failed to start: %w", err)
+		}
+		listeners = append(listeners, l)
+		log.Printf("Server listening on %s\n", address)
+	}
+	return listeners, nil
+}
+
+// Serve serves the web interface on listeners until ctx is cancelled, when it
+// shuts down gracefully and returns nil.
+func Serve(ctx context.Context, listeners []net.Listener) error {
 	// Serve static files from the embedded web directory
 	http.HandleFunc("/", handleWebFiles)
 
 	http.HandleFunc("/thumbnails/", handleThumbnails)
+	// Shareable links to a single image or group
+	http.HandleFunc("/image/", handleSharePage)
+	http.HandleFunc("/group/", handleSharePage)
 	// API Endpoints
 	http.HandleFunc("/api/stats", handleStats)
+	http.HandleFunc("/api/stats/devices", handleDeviceStats)
+	http.HandleFunc("/api/alerts", handleAlerts)
+	http.HandleFunc("/api/folders", handleFolders)
 	http.HandleFunc("/api/images", handleImages)
+	http.HandleFunc("/api/images/prefetch", handlePrefetch)
+	http.HandleFunc("/api/search", handleSearch)
+	http.HandleFunc("/api/search/by-image", handleSearchByImage)
+	http.HandleFunc("/api/query", handleQuery)
+	http.HandleFunc("/api/similar/explain", handleSimilarExplain)
+	http.HandleFunc("/api/similar/merge", handleSimilarMerge)
+	http.HandleFunc("/api/similar/split", handleSimilarSplit)
 	http.HandleFunc("/api/recycle", handleRecycle)
+	http.HandleFunc("/api/recycle/stats", handleRecycleStats)
+	http.HandleFunc("/api/pdf/matches", handlePDFMatches)
+	http.HandleFunc("/api/jobs", handleJobs)
+	http.HandleFunc("/api/jobs/", handleJob)
+	http.HandleFunc("/api/jobs/recycle", handleRecycleJob)
+	http.HandleFunc("/api/groups/review", handleGroupReview)
+	http.HandleFunc("/api/favorite", handleFavorite)
 	http.HandleFunc("/api/image/", handleImageFile)
-
-	log.Printf("Server listening on :%d\n", port)
-	err := http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
-	if err != nil {
-		return fmt.Errorf("server failed to start: %w", err)
+	http.HandleFunc("/api/launch", handleLaunch)
+	http.HandleFunc("/api/reveal", handleReveal)
+	http.HandleFunc("/api/scan/status", handleScanStatus)
+	http.HandleFunc("/api/scan/pause", handleScanPause)
+	http.HandleFunc("/api/scan/resume", handleScanResume)
+
+	srv := &http.Server{Handler: stripBasePath(trackBrowsing(http.DefaultServeMux))}
+	stop := context.AfterFunc(ctx, func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Warning: server did not shut down cleanly: %v\n", err)
+		}
+	})
+	defer stop()
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() { errs <- srv.Serve(l) }()
+	}
+	var serveErr error
+	for range listeners {
+		// One listener failing stops the others, as if the server failed to start
+		if err := <-errs; err != nil && err != http.ErrServerClosed && serveErr == nil {
+			serveErr = fmt.Errorf("server failed: %w", err)
+			srv.Close()
+		}
 	}
-	return nil
+	return serveErr
 }
 
 // handleWebFiles serves embedded web files
@@ -71,9 +201,9 @@ func handleWebFiles(w http.ResponseWriter, r *http.Request) {
 
 	// Try to read the file from embedded FS
 	fileData, err := fs.ReadFile(webFiles, "web/"+path)
-	if err != nil {
+	if err != nil || path == "index.html" {
 		// If file not found, try index.html (for SPA routing)
-		fileData, err = fs.ReadFile(webFiles, "web/index.html")
+		fileData, err = indexPage()
 		if err != nil {
 			http.NotFound(w, r)
 			return
@@ -113,6 +243,10 @@ type StatsResponse struct {
 	DuplicateGroupCount int `json:"duplicateGroupCount"`
 	SimilarGroupCount   int `json:"similarGroupCount"`
 	UniqueImageCount    int `json:"uniqueImageCount"`
+	BracketSetCount     int `json:"bracketSetCount"`
+	PanoramaSetCount    int `json:"panoramaSetCount"`
+	// Space taken by messenger folders and what removing their redundant copies frees
+	Messengers []database.MessengerUsage `json:"messengers"`
 }
 
 // handleStats returns image statistics.
@@ -142,26 +276,22 @@ func handleStats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Similar Group Count (This is more complex and might need a dedicated function or more complex query)
-	// For now, a placeholder or simplified count.
+	// Similar Group Count
 	var similarGroupCount int
-	err = db.QueryRow("SELECT COUNT(*) FROM (SELECT 1 FROM images WHERE similar_images IS NOT NULL AND similar_images != '[]' AND is_recycled = FALSE GROUP BY similar_images) AS similar_groups").Scan(&similarGroupCount)
+	err = db.QueryRow("SELECT COUNT(DISTINCT similar_group) FROM images WHERE similar_group IS NOT NULL AND is_recycled = FALSE").Scan(&similarGroupCount)
 	if err != nil {
-		log.Printf("Error querying similar group count: %v", err)
-		// Fallback to a simpler query if the above fails
-		err = db.QueryRow("SELECT COUNT(*) FROM images WHERE similar_images IS NOT NULL AND similar_images != '[]' AND is_recycled = FALSE").Scan(&similarGroupCount)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	// Unique Image Count (images that are neither duplicates nor similar to others)
+	// Unique Image Count (images that are neither duplicates, similar to others nor part of a bracket or panorama)
 	var uniqueImageCount int
 	err = db.QueryRow(`
         SELECT COUNT(*) FROM images 
         WHERE is_duplicate = FALSE 
-        AND (similar_images IS NULL OR similar_images = '[]') 
+        AND similar_group IS NULL
+        AND bracket_set IS NULL
+        AND panorama_set IS NULL
         AND is_recycled = FALSE
     `).Scan(&uniqueImageCount)
 	if err != nil {
@@ -169,96 +299,219 @@ func handleStats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Exposure bracket sets, kept apart from similar groups
+	var bracketSetCount int
+	err = db.QueryRow("SELECT COUNT(DISTINCT bracket_set) FROM images WHERE bracket_set IS NOT NULL AND is_recycled = FALSE").Scan(&bracketSetCount)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Panorama sequences, also kept apart from similar groups
+	var panoramaSetCount int
+	err = db.QueryRow("SELECT COUNT(DISTINCT panorama_set) FROM images WHERE panorama_set IS NOT NULL AND is_recycled = FALSE").Scan(&panoramaSetCount)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	messengers, err := database.GetMessengerUsage()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	response := StatsResponse{
 		TotalImages:         totalImages,
 		DuplicateGroupCount: duplicateGroupCount,
 		SimilarGroupCount:   similarGroupCount,
 		UniqueImageCount:    uniqueImageCount,
+		BracketSetCount:     bracketSetCount,
+		PanoramaSetCount:    panoramaSetCount,
+		Messengers:          messengers,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, response)
 }
 
 type Image struct {
-	ID            int    `json:"id"`
-	FilePath      string `json:"file_path"`
-	FileName      string `json:"file_name"`
-	FileSize      int64  `json:"file_size"`
-	MD5           string `json:"md5"`
-	ImageWidth    int    `json:"image_width"`
-	ImageHeight   int    `json:"image_height"`
-	DeviceMake    string `json:"device_make"`
-	DeviceModel   string `json:"device_model"`
-	LensModel     string `json:"lens_model"`
-	CreateDate    string `json:"create_date"`
-	PHash         string `json:"phash"`
-	ThumbnailPath string `json:"thumbnail_path"`
-	IsDuplicate   bool   `json:"is_duplicate"`
-	DuplicateOf   *int   `json:"duplicate_of"`
-	SimilarImages string `json:"similar_images"`
-	IsRecycled    bool   `json:"is_recycled"`
+	ID            int                 `json:"id"`
+	FilePath      string              `json:"file_path"`
+	DisplayPath   string              `json:"display_path"` // native path with the home directory shortened to ~
+	Volume        string              `json:"volume"`       // drive or mounted volume holding the file
+	RevealURI     string              `json:"reveal_uri"`   // file URI of the containing folder
+	FileName      string              `json:"file_name"`
+	FileSize      int64               `json:"file_size"`
+	MD5           string              `json:"md5"`
+	PreviousMD5   string              `json:"previous_md5"` // hash before the file last changed, empty if it never did
+	ImageWidth    int                 `json:"image_width"`
+	ImageHeight   int                 `json:"image_height"`
+	DeviceMake    string              `json:"device_make"`
+	DeviceModel   string              `json:"device_model"`
+	LensModel     string              `json:"lens_model"`
+	CameraSerial  string              `json:"camera_serial"`
+	ShutterCount  int64               `json:"shutter_count"`
+	CreateDate    string              `json:"create_date"`
+	DateSource    string              `json:"date_source"` // exif, metadata, filename, estimated or modtime; empty for older catalogs
+	ExposureBias  *float64            `json:"exposure_bias"`
+	PHash         string              `json:"phash"`
+	Palette       []string            `json:"palette"`        // most common colours as #rrggbb
+	DominantColor string              `json:"dominant_color"` // first palette entry, empty when unknown
+	ThumbnailPath string              `json:"thumbnail_path"`
+	IsDuplicate   bool                `json:"is_duplicate"`
+	DuplicateOf   *int                `json:"duplicate_of"`
+	SimilarImages string              `json:"similar_images"`
+	SimilarGroup  string              `json:"similar_group"` // ID of the similar group that later scans keep, the key of its review
+	SameShotOf    *int                `json:"same_shot_of"`
+	BracketSet    *int                `json:"bracket_set"`
+	PanoramaSet   *int                `json:"panorama_set"`
+	IsScreenshot  bool                `json:"is_screenshot"`
+	LowInfo       string              `json:"low_info"`    // solid, dark or bright for blank shots
+	Messenger     string              `json:"messenger"`   // whatsapp, telegram or signal for media saved from a chat app
+	EditedWith    string              `json:"edited_with"` // editing app named by the EXIF Software tag, empty for camera originals
+	IsRecycled    bool                `json:"is_recycled"`
+	IsFavorite    bool                `json:"is_favorite"`
+	Rating        int                 `json:"rating"` // 1 to 5 stars, 0 when unrated
+	IsVideo       bool                `json:"is_video"`
+	Duration      float64             `json:"duration"` // seconds, 0 for images and unprobed videos
+	Format        string              `json:"format"`   // format found in the file's content, empty when not recognized
+	Tags          []database.ImageTag `json:"tags"`
 }
 
-// Helper function to get all images from the database
-func getAllImages(db *sql.DB) ([]Image, error) {
-	rows, err := db.Query("SELECT id, file_path, file_name, file_size, md5, image_width, image_height, device_make, device_model, lens_model, create_date, phash, thumbnail_path, is_duplicate, duplicate_of, similar_images, is_recycled FROM images WHERE is_recycled = FALSE")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var images []Image
-	for rows.Next() {
-		var img Image
-		var duplicateOf sql.NullInt64
-		var similarImages sql.NullString
-		var createDateStr string
-
-		err := rows.Scan(
-			&img.ID, &img.FilePath, &img.FileName, &img.FileSize, &img.MD5, &img.ImageWidth, &img.ImageHeight,
-			&img.DeviceMake, &img.DeviceModel, &img.LensModel, &createDateStr, &img.PHash, &img.ThumbnailPath,
-			&img.IsDuplicate, &duplicateOf, &similarImages, &img.IsRecycled,
-		)
-		if err != nil {
-			log.Printf("Error scanning image row in getAllImages: %v\n", err)
-			continue
-		}
+// Helper function to get a sort key for images (e.g., area)
+func getSortKey(image Image) int {
+	return image.ImageWidth * image.ImageHeight
+}
 
-		img.CreateDate = createDateStr
-		if duplicateOf.Valid {
-			val := int(duplicateOf.Int64)
-			img.DuplicateOf = &val
+// dimensionFilter narrows image listings by resolution and orientation.
+type dimensionFilter struct {
+	MinMP       float64
+	MaxMP       float64
+	Orientation string // "portrait", "landscape" or "square"
+}
+
+// parseDimensionFilter reads minMP, maxMP and orientation from the query string.
+func parseDimensionFilter(r *http.Request) (dimensionFilter, error) {
+	var f dimensionFilter
+	var err error
+	query := r.URL.Query()
+	if v := query.Get("minMP"); v != "" {
+		if f.MinMP, err = strconv.ParseFloat(v, 64); err != nil || f.MinMP < 0 {
+			return f, fmt.Errorf("invalid minMP: %s", v)
 		}
-		if similarImages.Valid {
-			img.SimilarImages = similarImages.String
+	}
+	if v := query.Get("maxMP"); v != "" {
+		if f.MaxMP, err = strconv.ParseFloat(v, 64); err != nil || f.MaxMP < 0 {
+			return f, fmt.Errorf("invalid maxMP: %s", v)
 		}
-
-		images = append(images, img)
 	}
+	f.Orientation = strings.ToLower(query.Get("orientation"))
+	switch f.Orientation {
+	case "", "portrait", "landscape", "square":
+	default:
+		return f, fmt.Errorf("invalid orientation: %s", f.Orientation)
+	}
+	return f, nil
+}
+
+func (f dimensionFilter) active() bool {
+	return f.MinMP > 0 || f.MaxMP > 0 || f.Orientation != ""
+}
 
-	if err = rows.Err(); err != nil {
-		return nil, err
+// apply adds the filter's conditions to q. Images without known dimensions
+// (e.g. undecodable RAW files) never match an active filter.
+func (f dimensionFilter) apply(q *imageQuery) {
+	q.where("image_width > 0 AND image_height > 0")
+	if f.MinMP > 0 {
+		q.where("image_width * image_height >= ?", f.MinMP*1e6)
+	}
+	if f.MaxMP > 0 {
+		q.where("image_width * image_height <= ?", f.MaxMP*1e6)
 	}
+	switch f.Orientation {
+	case "portrait":
+		q.where("image_height > image_width")
+	case "landscape":
+		q.where("image_width > image_height")
+	case "square":
+		q.where("image_width = image_height")
+	}
+}
+
+// defaultColorTolerance is the RGB distance accepted when the color filter gives no tolerance.
+const defaultColorTolerance = 48
 
-	return images, nil
+// colorFilter narrows image listings to a dominant colour.
+type colorFilter struct {
+	Color     *color.RGBA
+	Tolerance float64 // largest RGB distance from Color, up to processor.MaxColorDistance
 }
 
-// Helper function to get an image by ID in a slice of images
-func findImageByID(images []Image, id int) *Image {
-	for i := range images {
-		if images[i].ID == id {
-			return &images[i]
+// parseColorFilter reads color (e.g. #ff6600) and tolerance from the query string.
+func parseColorFilter(r *http.Request) (colorFilter, error) {
+	f := colorFilter{Tolerance: defaultColorTolerance}
+	query := r.URL.Query()
+	if v := query.Get("color"); v != "" {
+		c, err := processor.ParseHexColor(v)
+		if err != nil {
+			return f, err
+		}
+		f.Color = &c
+	}
+	if v := query.Get("tolerance"); v != "" {
+		tolerance, err := strconv.ParseFloat(v, 64)
+		if err != nil || tolerance < 0 || tolerance > processor.MaxColorDistance {
+			return f, fmt.Errorf("invalid tolerance: %s", v)
 		}
+		f.Tolerance = tolerance
 	}
-	return nil
+	return f, nil
 }
 
-// Helper function to get a sort key for images (e.g., area)
-func getSortKey(image Image) int {
-	return image.ImageWidth * image.ImageHeight
+func (f colorFilter) active() bool {
+	return f.Color != nil
+}
+
+// matches reports whether the image's dominant colour is within the tolerance.
+// Images without a palette never match.
+func (f colorFilter) matches(img Image) bool {
+	if img.DominantColor == "" {
+		return false
+	}
+	dominant, err := processor.ParseHexColor(img.DominantColor)
+	if err != nil {
+		return false
+	}
+	return processor.ColorDistance(dominant, *f.Color) <= f.Tolerance
+}
+
+// imageTypeConditions are the SQL conditions selecting each listing type.
+var imageTypeConditions = map[string]string{
+	"duplicates":  "is_duplicate = TRUE",
+	"similar":     "similar_group IS NOT NULL",
+	"brackets":    "bracket_set IS NOT NULL",
+	"panoramas":   "panorama_set IS NOT NULL",
+	"screenshots": "is_screenshot = TRUE",
+	"blank":       "low_info IS NOT NULL AND low_info != ''",
+	"messenger":   "messenger IS NOT NULL AND messenger != ''",
+	"edited":      "edited_with IS NOT NULL AND edited_with != ''",
+	"favorites":   "is_favorite = TRUE",
+	"unique":      "is_duplicate = FALSE AND similar_group IS NULL AND bracket_set IS NULL AND panorama_set IS NULL",
+}
+
+// imageTypeOrders keep the members of a group together on consecutive pages,
+// largest image first; other listings show the biggest files first.
+var imageTypeOrders = map[string]string{
+	"duplicates": "md5, image_width * image_height DESC, id",
+	"similar":    "similar_group, image_width * image_height DESC, id",
+	"brackets":   "bracket_set, create_date, id",
+	"panoramas":  "panorama_set, create_date, id",
 }
 
+// defaultImageOrder sorts unique images and all other listings by file size (descending).
+const defaultImageOrder = "file_size DESC, id"
+
 // handleImages returns paginated image data based on type (duplicates, similar, unique)
 func handleImages(w http.ResponseWriter, r *http.Request) {
 	db, err := database.GetDBInstance()
@@ -282,92 +535,145 @@ func handleImages(w http.ResponseWriter, r *http.Request) {
 	// Calculate offset
 	offset := (page - 1) * limit
 
-	// Get all images (this might be memory-intensive for large datasets)
-	allImages, err := getAllImages(db)
+	dimFilter, err := parseDimensionFilter(r)
 	if err != nil {
-		http.Error(w, "Failed to fetch images", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	colFilter, err := parseColorFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Filter images based on type
-	var filteredImages []Image
-	switch imageType {
-	case "duplicates":
-		for _, img := range allImages {
-			if img.IsDuplicate {
-				filteredImages = append(filteredImages, img)
+	var q imageQuery
+	// Shared links narrow the listing to one duplicate group (md5), one similar group (similarGroup) or to given images (ids)
+	if md5 := r.URL.Query().Get("md5"); md5 != "" {
+		q.where("md5 = ?", md5)
+	}
+	if similarGroup := r.URL.Query().Get("similarGroup"); similarGroup != "" {
+		q.where("similar_group = ?", similarGroup)
+	}
+	if ids := r.URL.Query().Get("ids"); ids != "" {
+		var wanted []interface{}
+		for _, part := range strings.Split(ids, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid ids: %s", ids), http.StatusBadRequest)
+				return
 			}
+			wanted = append(wanted, id)
 		}
-	case "similar":
-		for _, img := range allImages {
-			if img.SimilarImages != "" && img.SimilarImages != "[]" {
-				filteredImages = append(filteredImages, img)
-			}
+		q.where("id IN ("+strings.TrimSuffix(strings.Repeat("?, ", len(wanted)), ", ")+")", wanted...)
+	}
+	if folder := r.URL.Query().Get("folder"); folder != "" {
+		prefix := folderPrefix(folder)
+		q.where("substr(file_path, 1, length(?)) = ?", prefix, prefix)
+	}
+	// An empty make or model matches any value, so a whole brand can be selected
+	if deviceMake := r.URL.Query().Get("deviceMake"); deviceMake != "" {
+		q.where("device_make = ?", deviceMake)
+	}
+	if deviceModel := r.URL.Query().Get("deviceModel"); deviceModel != "" {
+		q.where("device_model = ?", deviceModel)
+	}
+	if dimFilter.active() {
+		dimFilter.apply(&q)
+	}
+	if condition, ok := imageTypeConditions[imageType]; ok {
+		q.where(condition)
+	}
+
+	// Group review state, optionally used to show only groups with a given status
+	var groupReviews map[string]database.GroupReview
+	if imageType == database.GroupTypeDuplicates || imageType == database.GroupTypeSimilar {
+		groupReviews, err = database.GetGroupReviews(imageType)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-	case "unique":
-		for _, img := range allImages {
-			if !img.IsDuplicate && (img.SimilarImages == "" || img.SimilarImages == "[]") {
-				filteredImages = append(filteredImages, img)
+		if status := r.URL.Query().Get("reviewStatus"); status != "" {
+			keyColumn := "md5"
+			if imageType == database.GroupTypeSimilar {
+				keyColumn = "similar_group"
 			}
+			q.where("COALESCE((SELECT status FROM group_reviews WHERE group_type = ? AND group_key = images."+keyColumn+"), ?) = ?",
+				imageType, database.ReviewUnreviewed, status)
 		}
-	default:
-		// Default to all images if no type specified
-		filteredImages = allImages
 	}
 
-	// Sort images: duplicates by MD5, similar by similar_images, unique by file size (descending)
-	if imageType == "duplicates" {
-		sort.Slice(filteredImages, func(i, j int) bool {
-			if filteredImages[i].MD5 != filteredImages[j].MD5 {
-				return filteredImages[i].MD5 < filteredImages[j].MD5
-			}
-			// If MD5s are equal, sort by image area (larger first)
-			return getSortKey(filteredImages[i]) > getSortKey(filteredImages[j])
-		})
-	} else if imageType == "similar" {
-		sort.Slice(filteredImages, func(i, j int) bool {
-			if filteredImages[i].SimilarImages != filteredImages[j].SimilarImages {
-				return filteredImages[i].SimilarImages < filteredImages[j].SimilarImages
-			}
-			// If similar_images are equal, sort by image area (larger first)
-			return getSortKey(filteredImages[i]) > getSortKey(filteredImages[j])
-		})
-	} else {
-		// For unique images or all images, sort by file size (descending)
-		sort.Slice(filteredImages, func(i, j int) bool {
-			return filteredImages[i].FileSize > filteredImages[j].FileSize
-		})
+	orderBy, ok := imageTypeOrders[imageType]
+	if !ok {
+		orderBy = defaultImageOrder
+	}
+	if orderBy, err = imageSortOrder(r, imageType, orderBy); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	// Calculate total count for pagination
-	totalImages := len(filteredImages)
-
-	// Apply pagination
-	start := offset
-	end := start + limit
-	if start > totalImages {
-		start = totalImages
+	var totalImages int
+	var paginatedImages []Image
+	if colFilter.active() {
+		// Colour distance is computed in Go, so the colour filter pages in memory
+		// over the rows the other filters left
+		matches, err := q.images(db, orderBy, 0, 0)
+		if err != nil {
+			http.Error(w, "Failed to fetch images", http.StatusInternalServerError)
+			return
+		}
+		var filteredImages []Image
+		for _, img := range matches {
+			if colFilter.matches(img) {
+				filteredImages = append(filteredImages, img)
+			}
+		}
+		totalImages = len(filteredImages)
+		start := offset
+		end := start + limit
+		if start > totalImages {
+			start = totalImages
+		}
+		if end > totalImages {
+			end = totalImages
+		}
+		paginatedImages = filteredImages[start:end]
+	} else {
+		if totalImages, err = q.count(db); err != nil {
+			http.Error(w, "Failed to count images", http.StatusInternalServerError)
+			return
+		}
+		if paginatedImages, err = q.images(db, orderBy, limit, offset); err != nil {
+			http.Error(w, "Failed to fetch images", http.StatusInternalServerError)
+			return
+		}
 	}
-	if end > totalImages {
-		end = totalImages
+	if err := attachTags(paginatedImages); err != nil {
+		log.Printf("Warning: Could not load image tags: %v\n", err)
 	}
 
-	paginatedImages := filteredImages[start:end]
-
-	// Prepare response data
-	var response map[string]interface{}
+	// Prepare response data. Group listings can be huge, so they are streamed
+	// image by image instead of being encoded into one buffer.
+	var groupsKey string
+	var groups [][]Image
 
 	if imageType == "duplicates" {
-		// Group duplicates by MD5
-		duplicateGroups := make(map[string][]Image)
+		// Group duplicates by MD5, keeping the order of the listing
+		var duplicateGroups [][]Image
+		groupIndex := make(map[string]int)
 		for _, img := range paginatedImages {
-			if img.IsDuplicate {
-				duplicateGroups[img.MD5] = append(duplicateGroups[img.MD5], img)
+			if !img.IsDuplicate {
+				continue
+			}
+			i, ok := groupIndex[img.MD5]
+			if !ok {
+				i = len(duplicateGroups)
+				groupIndex[img.MD5] = i
+				duplicateGroups = append(duplicateGroups, nil)
 			}
+			duplicateGroups[i] = append(duplicateGroups[i], img)
 		}
 
-		// Convert map to slice of slices
-		var groups [][]Image
+		groupsKey = "duplicateGroups"
 		for _, group := range duplicateGroups {
 			// Sort each group by image area (larger first)
 			sort.Slice(group, func(i, j int) bool {
@@ -375,22 +681,24 @@ func handleImages(w http.ResponseWriter, r *http.Request) {
 			})
 			groups = append(groups, group)
 		}
-
-		response = map[string]interface{}{
-			"duplicateGroups": groups,
-			"totalImages":     totalImages,
-		}
 	} else if imageType == "similar" {
-		// Group similar images by similar_images field
-		similarGroups := make(map[string][]Image)
+		// Group similar images by their group ID, keeping the order of the listing
+		var similarGroups [][]Image
+		groupIndex := make(map[string]int)
 		for _, img := range paginatedImages {
-			if img.SimilarImages != "" && img.SimilarImages != "[]" {
-				similarGroups[img.SimilarImages] = append(similarGroups[img.SimilarImages], img)
+			if img.SimilarGroup == "" {
+				continue
+			}
+			i, ok := groupIndex[img.SimilarGroup]
+			if !ok {
+				i = len(similarGroups)
+				groupIndex[img.SimilarGroup] = i
+				similarGroups = append(similarGroups, nil)
 			}
+			similarGroups[i] = append(similarGroups[i], img)
 		}
 
-		// Convert map to slice of slices
-		var groups [][]Image
+		groupsKey = "similarGroups"
 		for _, group := range similarGroups {
 			// Sort each group by image area (larger first)
 			sort.Slice(group, func(i, j int) bool {
@@ -398,21 +706,106 @@ func handleImages(w http.ResponseWriter, r *http.Request) {
 			})
 			groups = append(groups, group)
 		}
+	} else if imageType == "brackets" || imageType == "panoramas" {
+		// Group frames by set, preserving capture order
+		for _, img := range paginatedImages {
+			if n := len(groups); n > 0 && *sequenceSetOf(imageType, groups[n-1][0]) == *sequenceSetOf(imageType, img) {
+				groups[n-1] = append(groups[n-1], img)
+			} else {
+				groups = append(groups, []Image{img})
+			}
+		}
 
-		response = map[string]interface{}{
-			"similarGroups": groups,
-			"totalImages":   totalImages,
+		groupsKey = "bracketGroups"
+		if imageType == "panoramas" {
+			groupsKey = "panoramaGroups"
 		}
+	}
+
+	stream := newJSONStream(w, r)
+	stream.Field("totalImages", totalImages)
+	if groupReviews != nil {
+		stream.Field("groupReviews", groupReviews)
+	}
+	if groupsKey != "" {
+		stream.Groups(groupsKey, groups)
 	} else {
 		// For unique images or all images
-		response = map[string]interface{}{
-			"images":      paginatedImages,
-			"totalImages": totalImages,
-		}
+		stream.Images("images", paginatedImages)
+	}
+	if err := stream.Close(); err != nil {
+		log.Printf("Error writing image listing: %v\n", err)
+	}
+}
+
+// sequenceSetOf returns the bracket or panorama set an image belongs to, or nil.
+func sequenceSetOf(imageType string, img Image) *int {
+	if imageType == "panoramas" {
+		return img.PanoramaSet
+	}
+	return img.BracketSet
+}
+
+// handleGroupReview updates the review status and notes of a duplicate or similar group.
+func handleGroupReview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var review database.GroupReview
+	if err := readJSON(r, &review); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if review.GroupKey == "" {
+		http.Error(w, "Group key is required", http.StatusBadRequest)
+		return
+	}
+	if review.Status == "" {
+		review.Status = database.ReviewUnreviewed
+	}
+
+	if err := database.SetGroupReview(review); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"review":  review,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, r, response)
+}
+
+// handleFavorite toggles the favorite flag of an image.
+func handleFavorite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var requestData struct {
+		ID       int  `json:"id"`
+		Favorite bool `json:"favorite"`
+	}
+	if err := readJSON(r, &requestData); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
 	}
 
+	if err := database.SetFavorite(requestData.ID, requestData.Favorite); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":  true,
+		"favorite": requestData.Favorite,
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, response)
 }
 
 // handleRecycle handles recycling (moving to trash) of an image file
@@ -426,7 +819,7 @@ func handleRecycle(w http.ResponseWriter, r *http.Request) {
 		FilePath string `json:"filePath"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+	if err := readJSON(r, &requestData); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
@@ -436,23 +829,17 @@ func handleRecycle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Use the utility function to recycle the file
-	if err := util.RecycleFile(requestData.FilePath, "Recycle"); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to recycle file: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	// Update the database to mark the image as recycled
-	db, err := database.GetDBInstance()
+	recycledPath, err := recycleFile(requestData.FilePath)
 	if err != nil {
-		http.Error(w, "Failed to connect to database", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	_, err = db.Exec("UPDATE images SET is_recycled = TRUE WHERE file_path = ?", requestData.FilePath)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to update database: %v", err), http.StatusInternalServerError)
-		return
+	// Each recycle from the web interface can be undone on its own
+	if operationID, err := database.BeginOperation(database.OperationRecycle); err != nil {
+		log.Printf("Warning: The recycle of %s cannot be undone: %v\n", requestData.FilePath, err)
+	} else if err := database.RecordMove(operationID, requestData.FilePath, recycledPath); err != nil {
+		log.Printf("Warning: The recycle of %s cannot be undone: %v\n", requestData.FilePath, err)
 	}
 
 	response := map[string]interface{}{
@@ -460,11 +847,41 @@ func handleRecycle(w http.ResponseWriter, r *http.Request) {
 		"message": "File recycled successfully",
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, response)
+}
+
+// recycleFile moves a file to the Recycle directory and marks it as recycled
+// in the catalog, or puts it back if the catalog cannot be updated. It returns
+// where the file went.
+func recycleFile(filePath string) (string, error) {
+	recycledPath, err := fileops.Recycle(filePath, recycleDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to recycle file: %w", err)
+	}
+	// Update the database to mark the image as recycled; thumbnails and groups follow via events
+	if err := database.MarkRecycled(filePath); err != nil {
+		// The catalog still lists the file, so it goes back where it was
+		if restoreErr := fileops.Unrecycle(recycledPath, filePath); restoreErr != nil {
+			log.Printf("ERROR: %s was recycled, but the catalog still lists it: %v\n", filePath, restoreErr)
+		}
+		return "", fmt.Errorf("failed to update database: %w", err)
+	}
+	return recycledPath, nil
 }
 
-// handleImageFile serves the original image file
+// handleImageFile serves the original image file. It answers HEAD requests and
+// conditional GETs, so clients can keep originals they already downloaded.
+// POSTs to /api/image/{id}/reprocess are passed on to handleReprocess.
 func handleImageFile(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/reprocess") {
+		handleReprocess(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 	imageIDStr := r.URL.Path[len("/api/image/"):]
 
 	db, err := database.GetDBInstance()
@@ -473,72 +890,79 @@ func handleImageFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var filePath, md5 string
-	err = db.QueryRow("SELECT file_path, md5 FROM images WHERE id = ?", imageIDStr).Scan(&filePath, &md5)
+	var filePath, md5, format string
+	var fileSize, fileModTime sql.NullInt64
+	err = db.QueryRow("SELECT file_path, md5, COALESCE(format, ''), file_size, file_mod_time FROM images WHERE id = ?", imageIDStr).Scan(&filePath, &md5, &format, &fileSize, &fileModTime)
 	if err != nil {
 		http.Error(w, "Image not found", http.StatusNotFound)
 		return
 	}
+	info, err := os.Stat(filePath)
+	if err != nil || info.IsDir() {
+		http.Error(w, "Image file not found", http.StatusNotFound)
+		return
+	}
 
-	// Check if it's a CR2 file that needs conversion
-	ext := strings.ToLower(filepath.Ext(filePath))
-	if ext == ".cr2" {
-		// Generate a preview image on-demand
-		previewData, err := generateCR2Preview(filePath)
+	// The MD5 only names the content while the file is as it was when hashed
+	etag := ""
+	if info.Size() == fileSize.Int64 && info.ModTime().UnixNano() == fileModTime.Int64 {
+		etag = `"` + md5 + `"`
+	}
+	w.Header().Set("Cache-Control", "private, no-cache")
+
+	// RAW files are shown through their embedded preview, or converted when they have none
+	if walker.IsRaw(filePath, format) {
+		if etag != "" {
+			etag = `"` + md5 + `-preview"`
+			w.Header().Set("ETag", etag)
+		}
+		// Skip extracting the preview when the client already has it
+		if notModified(r, etag, info.ModTime()) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		previewData, err := processor.ExtractRawPreview(filePath)
+		if err != nil {
+			previewData, err = processor.ConvertRAW(filePath, false)
+		}
 		if err != nil {
-			log.Printf("Error generating CR2 preview for %s: %v", filePath, err)
+			log.Printf("Error generating RAW preview for %s: %v", filePath, err)
 			http.Error(w, fmt.Sprintf("Error generating preview: %v", err), http.StatusInternalServerError)
 			return
 		}
 
 		w.Header().Set("Content-Type", "image/jpeg")
-		w.Write(previewData)
+		http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(previewData))
 		return
 	}
 
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
 	http.ServeFile(w, r, filePath)
 }
 
-// generateCR2Preview generates a preview image for CR2 files
-func generateCR2Preview(filePath string) ([]byte, error) {
-	// Check if dcraw is installed
-	if _, err := exec.LookPath("dcraw"); err != nil {
-		return nil, fmt.Errorf("dcraw is not installed. Please install dcraw to view CR2 files")
-	}
-
-	// Check if convert (ImageMagick) is installed
-	if _, err := exec.LookPath("convert"); err != nil {
-		return nil, fmt.Errorf("ImageMagick is not installed. Please install ImageMagick to view CR2 files")
-	}
-
-	// Use dcraw to convert CR2 to PPM with half size for better performance
-	cmd := exec.Command("dcraw", "-c", "-q", "3", "-w", "-H", "5", "-h", filePath)
-	var ppmData bytes.Buffer
-	var stderr bytes.Buffer
-	cmd.Stdout = &ppmData
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("dcraw failed: %w, stderr: %s", err, stderr.String())
-	}
-
-	// Convert PPM to JPEG using ImageMagick's convert command
-	convertCmd := exec.Command("convert", "-", "-quality", "85", "jpeg:-")
-	convertCmd.Stdin = &ppmData
-
-	var jpegData bytes.Buffer
-	var convertStderr bytes.Buffer
-	convertCmd.Stdout = &jpegData
-	convertCmd.Stderr = &convertStderr
-
-	if err := convertCmd.Run(); err != nil {
-		return nil, fmt.Errorf("convert failed: %w, stderr: %s", err, convertStderr.String())
+// notModified reports whether a conditional request already has the current
+// version: If-None-Match is checked against etag and, without it,
+// If-Modified-Since against modTime, as net/http does for ServeContent.
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
 	}
-
-	return jpegData.Bytes(), nil
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !modTime.Truncate(time.Second).After(since)
 }
 
-// handleThumbnails serves image thumbnails from the in-memory store.
+// handleThumbnails serves image thumbnails from the in-memory store, regenerating stale ones.
 func handleThumbnails(w http.ResponseWriter, r *http.Request) {
 	md5 := r.URL.Path[len("/thumbnails/"):]
 	if md5 == "" {
@@ -546,12 +970,13 @@ func handleThumbnails(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	thumbnailData := GetThumbnailFromMemory(md5)
+	thumbnailData := currentThumbnail(md5)
 	if thumbnailData == nil {
 		http.NotFound(w, r)
 		return
 	}
 
-	w.Header().Set("Content-Type", "image/webp") // Changed to image/webp
+	// WebP, or JPEG from a build with the purego tag
+	w.Header().Set("Content-Type", http.DetectContentType(thumbnailData))
 	w.Write(thumbnailData)
 }
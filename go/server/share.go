@@ -0,0 +1,234 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"picpurge/database"
+)
+
+// sharePreview is what a shared link shows in chat apps before it is opened.
+type sharePreview struct {
+	Title        string
+	Description  string
+	ThumbnailMD5 string // empty when the image has no thumbnail
+}
+
+const defaultPageTitle = "Image Util Dashboard"
+
+// handleSharePage serves the web interface for /image/{id}, /group/duplicates/{md5}
+// and /group/similar/{id-id-...} with Open Graph tags describing the image or
+// group, so links sent to others preview correctly. The page itself shows the
+// image or group once loaded.
+func handleSharePage(w http.ResponseWriter, r *http.Request) {
+	db, err := database.GetDBInstance()
+	if err != nil {
+		http.Error(w, "Failed to connect to database", http.StatusInternalServerError)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	var preview *sharePreview
+	switch {
+	case len(parts) == 2 && parts[0] == "image":
+		if id, convErr := strconv.Atoi(parts[1]); convErr == nil {
+			preview, err = imagePreview(db, id)
+		}
+	case len(parts) == 3 && parts[0] == "group" && parts[1] == database.GroupTypeDuplicates:
+		preview, err = duplicateGroupPreview(db, parts[2])
+	case len(parts) == 3 && parts[0] == "group" && parts[1] == database.GroupTypeSimilar:
+		if key, ok := similarGroupID(db, parts[2]); ok {
+			preview, err = similarGroupPreview(db, key)
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page, err := indexPage()
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if preview == nil {
+		// Unknown or recycled: the page explains that nothing matched
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(page)
+		return
+	}
+	w.Write(injectPreview(page, preview, requestBaseURL(r), r.URL.Path))
+}
+
+// similarGroupID returns the similar group ID a link names: the ID itself, or
+// for an ID list such as 12-5, as older links have, the group of its first image.
+func similarGroupID(db *sql.DB, group string) (string, bool) {
+	if database.IsSimilarGroupID(group) {
+		return group, true
+	}
+	first, _, _ := strings.Cut(group, "-")
+	id, err := strconv.Atoi(first)
+	if err != nil {
+		return "", false
+	}
+	var groupID string
+	if err := db.QueryRow("SELECT similar_group FROM images WHERE id = ? AND similar_group IS NOT NULL AND is_recycled = FALSE", id).Scan(&groupID); err != nil {
+		return "", false
+	}
+	return groupID, true
+}
+
+func imagePreview(db *sql.DB, id int) (*sharePreview, error) {
+	var fileName, md5, thumbnailPath, createDate string
+	var width, height int
+	err := db.QueryRow(`
+		SELECT file_name, md5, COALESCE(thumbnail_path, ''), COALESCE(image_width, 0), COALESCE(image_height, 0), COALESCE(create_date, '')
+		FROM images WHERE id = ? AND is_recycled = FALSE
+	`, id).Scan(&fileName, &md5, &thumbnailPath, &width, &height, &createDate)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up image %d: %w", id, err)
+	}
+
+	preview := &sharePreview{Title: fileName, ThumbnailMD5: thumbnailMD5(thumbnailPath, md5)}
+	var details []string
+	if width > 0 && height > 0 {
+		details = append(details, fmt.Sprintf("%dx%d", width, height))
+	}
+	if len(createDate) >= len("2006-01-02") {
+		details = append(details, "taken "+createDate[:len("2006-01-02")])
+	}
+	preview.Description = strings.Join(details, ", ")
+	return preview, nil
+}
+
+func duplicateGroupPreview(db *sql.DB, md5 string) (*sharePreview, error) {
+	members, err := groupMembers(db, "md5 = ?", md5)
+	if err != nil || len(members) < 2 {
+		return nil, err
+	}
+	return &sharePreview{
+		Title:        fmt.Sprintf("%d identical copies of %s", len(members), members[0].fileName),
+		Description:  memberNames(members),
+		ThumbnailMD5: members[0].thumbnailMD5,
+	}, nil
+}
+
+func similarGroupPreview(db *sql.DB, key string) (*sharePreview, error) {
+	members, err := groupMembers(db, "similar_group = ?", key)
+	if err != nil || len(members) < 2 {
+		return nil, err
+	}
+	return &sharePreview{
+		Title:        fmt.Sprintf("%d similar photos", len(members)),
+		Description:  memberNames(members),
+		ThumbnailMD5: members[0].thumbnailMD5,
+	}, nil
+}
+
+type groupMember struct {
+	fileName     string
+	thumbnailMD5 string
+}
+
+// groupMembers lists the catalogued images matching condition, originals first.
+func groupMembers(db *sql.DB, condition string, arg interface{}) ([]groupMember, error) {
+	rows, err := db.Query(`
+		SELECT file_name, md5, COALESCE(thumbnail_path, '') FROM images
+		WHERE `+condition+` AND is_recycled = FALSE
+		ORDER BY is_duplicate ASC, id ASC
+	`, arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up group: %w", err)
+	}
+	defer rows.Close()
+
+	var members []groupMember
+	for rows.Next() {
+		var fileName, md5, thumbnailPath string
+		if err := rows.Scan(&fileName, &md5, &thumbnailPath); err != nil {
+			return nil, fmt.Errorf("failed to read group member: %w", err)
+		}
+		members = append(members, groupMember{fileName: fileName, thumbnailMD5: thumbnailMD5(thumbnailPath, md5)})
+	}
+	return members, rows.Err()
+}
+
+// memberNames lists the first few distinct file names of a group.
+func memberNames(members []groupMember) string {
+	const shown = 4
+	var names []string
+	seen := make(map[string]bool)
+	for _, member := range members {
+		if !seen[member.fileName] {
+			seen[member.fileName] = true
+			names = append(names, member.fileName)
+		}
+	}
+	if len(names) > shown {
+		names = append(names[:shown], fmt.Sprintf("and %d more", len(names)-shown))
+	}
+	return strings.Join(names, ", ")
+}
+
+// thumbnailMD5 returns the key the thumbnail is served under, if there is one.
+func thumbnailMD5(thumbnailPath, md5 string) string {
+	if strings.HasPrefix(thumbnailPath, "memory://") {
+		return md5
+	}
+	return ""
+}
+
+// requestBaseURL returns the scheme and host the request was made to, honouring
+// a reverse proxy's X-Forwarded-Proto. Open Graph images need absolute URLs.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
+
+// injectPreview replaces the page title and adds Open Graph and Twitter card tags.
+func injectPreview(page []byte, preview *sharePreview, baseURL, path string) []byte {
+	var tags strings.Builder
+	meta := func(attribute, name, content string) {
+		fmt.Fprintf(&tags, "\n  <meta %s=\"%s\" content=\"%s\">", attribute, name, html.EscapeString(content))
+	}
+	fmt.Fprintf(&tags, "<title>%s</title>", html.EscapeString(preview.Title))
+	meta("property", "og:type", "website")
+	meta("property", "og:site_name", "PicPurge")
+	meta("property", "og:title", preview.Title)
+	meta("property", "og:url", baseURL+appURL(path))
+	if preview.Description != "" {
+		meta("property", "og:description", preview.Description)
+		meta("name", "description", preview.Description)
+	}
+	if preview.ThumbnailMD5 != "" {
+		meta("property", "og:image", baseURL+appURL("/thumbnails/"+preview.ThumbnailMD5))
+		if thumbnailData := currentThumbnail(preview.ThumbnailMD5); thumbnailData != nil {
+			meta("property", "og:image:type", http.DetectContentType(thumbnailData))
+		}
+		meta("name", "twitter:card", "summary_large_image")
+	} else {
+		meta("name", "twitter:card", "summary")
+	}
+
+	original := "<title>" + defaultPageTitle + "</title>"
+	if !strings.Contains(string(page), original) {
+		log.Printf("Warning: index.html has no %s, link previews are disabled\n", original)
+		return page
+	}
+	return []byte(strings.Replace(string(page), original, tags.String(), 1))
+}
@@ -0,0 +1,279 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"picpurge/database"
+	"picpurge/grouping"
+	"picpurge/processor"
+
+	"github.com/corona10/goimagehash"
+)
+
+// SimilarityCheck is one threshold test applied when grouping similar images.
+type SimilarityCheck struct {
+	Name      string  `json:"name"`
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+	Passed    bool    `json:"passed"`
+}
+
+// SimilarityExplanation reports how two images compare under the similarity rules.
+type SimilarityExplanation struct {
+	A               int               `json:"a"`
+	B               int               `json:"b"`
+	PHashDistance   *int              `json:"phashDistance"` // nil when either image has no pHash
+	Turned          bool              `json:"turned"`        // b matches best turned or mirrored; aspectDelta is then of b turned
+	Document        bool              `json:"document"`      // either image is a document, held to a stricter pHash threshold
+	DHashDistance   *int              `json:"dhashDistance"` // these three only count with --confirm-hashes
+	AHashDistance   *int              `json:"ahashDistance"`
+	WHashDistance   *int              `json:"whashDistance"`
+	SizeRatio       float64           `json:"sizeRatio"`
+	AspectDelta     float64           `json:"aspectDelta"`
+	Checks          []SimilarityCheck `json:"checks"`
+	SameBracketSet  bool              `json:"sameBracketSet"`
+	SamePanoramaSet bool              `json:"samePanoramaSet"`
+	Similar         bool              `json:"similar"`        // all checks pass and the images are not in one sequence
+	Grouped         bool              `json:"grouped"`        // the last scan put them in one similar group
+	GroupSize       int               `json:"groupSize"`      // size of the group they are in or would form
+	GroupThreshold  int               `json:"groupThreshold"` // pHash limit between any two members of such a group
+}
+
+type similarityRow struct {
+	id            int
+	phash, dhash  string
+	ahash, whash  string
+	turnedPHashes string
+	width, height int
+	bracketSet    int
+	panoramaSet   int
+	isDocument    bool
+	similarImages []int
+}
+
+func loadSimilarityRow(db *sql.DB, id int) (*similarityRow, error) {
+	row := &similarityRow{id: id}
+	var similarJSON sql.NullString
+	err := db.QueryRow(`
+		SELECT COALESCE(phash, ''), COALESCE(turned_phashes, ''), COALESCE(dhash, ''), COALESCE(ahash, ''), COALESCE(whash, ''), COALESCE(image_width, 0), COALESCE(image_height, 0),
+			COALESCE(bracket_set, 0), COALESCE(panorama_set, 0), COALESCE(is_document, FALSE), similar_images
+		FROM images WHERE id = ?
+	`, id).Scan(&row.phash, &row.turnedPHashes, &row.dhash, &row.ahash, &row.whash, &row.width, &row.height, &row.bracketSet, &row.panoramaSet, &row.isDocument, &similarJSON)
+	if err != nil {
+		return nil, err
+	}
+	if similarJSON.Valid && similarJSON.String != "" {
+		if err := json.Unmarshal([]byte(similarJSON.String), &row.similarImages); err != nil {
+			return nil, fmt.Errorf("failed to parse similar images for image %d: %w", id, err)
+		}
+	}
+	return row, nil
+}
+
+// hashDistance returns the Hamming distance between two stored hashes, or nil if
+// either is missing or unreadable.
+func hashDistance(hashA, hashB string) *int {
+	if hashA == "" || hashB == "" {
+		return nil
+	}
+	a, err := goimagehash.ImageHashFromString(hashA)
+	if err != nil {
+		return nil
+	}
+	b, err := goimagehash.ImageHashFromString(hashB)
+	if err != nil {
+		return nil
+	}
+	distance, err := a.Distance(b)
+	if err != nil {
+		return nil
+	}
+	return &distance
+}
+
+// phashDistance returns the pHash distance of two images in the orientation
+// of b that matches best, and whether b is turned in it. The distance is nil
+// if either pHash is missing or unreadable, or no orientation can be compared.
+func phashDistance(a, b *similarityRow) (*int, bool) {
+	if a.phash == "" || b.phash == "" {
+		return nil, false
+	}
+	hashesA, err := processor.ParsePHashes(a.phash, a.turnedPHashes)
+	if err != nil {
+		return nil, false
+	}
+	hashesB, err := processor.ParsePHashes(b.phash, b.turnedPHashes)
+	if err != nil {
+		return nil, false
+	}
+	upright, sideways := processor.Orientations(a.width, a.height, b.width, b.height)
+	if !upright && !sideways {
+		upright = true // Report the distance even though the sizes keep them apart
+	}
+	distance, turned := hashesA.Distance(hashesB, upright, sideways)
+	if distance == math.MaxInt {
+		return nil, false
+	}
+	return &distance, turned
+}
+
+func containsID(ids []int, id int) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+// explainSimilarity applies the same tests as the scan's similar-image pass.
+func explainSimilarity(a, b *similarityRow) SimilarityExplanation {
+	explanation := SimilarityExplanation{
+		A:               a.id,
+		B:               b.id,
+		DHashDistance:   hashDistance(a.dhash, b.dhash),
+		AHashDistance:   hashDistance(a.ahash, b.ahash),
+		WHashDistance:   hashDistance(a.whash, b.whash),
+		SizeRatio:       processor.SizeRatio(a.width, a.height, b.width, b.height),
+		AspectDelta:     processor.AspectDelta(a.width, a.height, b.width, b.height),
+		SameBracketSet:  a.bracketSet != 0 && a.bracketSet == b.bracketSet,
+		SamePanoramaSet: a.panoramaSet != 0 && a.panoramaSet == b.panoramaSet,
+		Document:        a.isDocument || b.isDocument,
+		Grouped:         containsID(a.similarImages, b.id),
+	}
+	explanation.PHashDistance, explanation.Turned = phashDistance(a, b)
+	if explanation.Turned && processor.AspectDelta(a.width, a.height, b.height, b.width) <= processor.AspectRatioTolerance {
+		explanation.AspectDelta = processor.AspectDelta(a.width, a.height, b.height, b.width)
+	}
+
+	// Larger groups use a stricter limit, so a passing pair can still be kept apart
+	members := map[int]bool{a.id: true, b.id: true}
+	for _, id := range a.similarImages {
+		members[id] = true
+	}
+	if !explanation.Grouped {
+		for _, id := range b.similarImages {
+			members[id] = true
+		}
+	}
+	explanation.GroupSize = len(members)
+	explanation.GroupThreshold = grouping.ClusterThreshold(processor.PHashThreshold, explanation.GroupSize)
+
+	sizeDifference := 1 - explanation.SizeRatio
+	explanation.Checks = []SimilarityCheck{
+		{Name: "aspectRatio", Value: explanation.AspectDelta, Threshold: processor.AspectRatioTolerance, Passed: explanation.AspectDelta <= processor.AspectRatioTolerance},
+		{Name: "size", Value: sizeDifference, Threshold: processor.SizeThreshold, Passed: sizeDifference <= processor.SizeThreshold},
+	}
+	phashThreshold := processor.PairThreshold(a.isDocument, b.isDocument)
+	phashCheck := SimilarityCheck{Name: "phash", Value: -1, Threshold: float64(phashThreshold)}
+	if explanation.PHashDistance != nil {
+		phashCheck.Value = float64(*explanation.PHashDistance)
+		phashCheck.Passed = *explanation.PHashDistance <= phashThreshold
+	}
+	explanation.Checks = append(explanation.Checks, phashCheck)
+
+	explanation.Similar = !explanation.SameBracketSet && !explanation.SamePanoramaSet
+	for _, check := range explanation.Checks {
+		explanation.Similar = explanation.Similar && check.Passed
+	}
+	return explanation
+}
+
+// handleSimilarExplain reports the distances and threshold results for the images given by the a and b parameters.
+func handleSimilarExplain(w http.ResponseWriter, r *http.Request) {
+	idA, errA := strconv.Atoi(r.URL.Query().Get("a"))
+	idB, errB := strconv.Atoi(r.URL.Query().Get("b"))
+	if errA != nil || errB != nil {
+		http.Error(w, "Image IDs a and b are required", http.StatusBadRequest)
+		return
+	}
+
+	db, err := database.GetDBInstance()
+	if err != nil {
+		http.Error(w, "Failed to connect to database", http.StatusInternalServerError)
+		return
+	}
+
+	rows := make([]*similarityRow, 2)
+	for i, id := range []int{idA, idB} {
+		rows[i], err = loadSimilarityRow(db, id)
+		if err == sql.ErrNoRows {
+			http.Error(w, fmt.Sprintf("Image %d not found", id), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, r, explainSimilarity(rows[0], rows[1]))
+}
+
+// handleSimilarMerge merges the similar groups of the images given as ids into
+// one, e.g. two groups of the same scene the scan kept apart.
+func handleSimilarMerge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var requestData struct {
+		IDs []int `json:"ids"`
+	}
+	if err := readJSON(r, &requestData); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(requestData.IDs) < 2 {
+		http.Error(w, "At least two image IDs are required", http.StatusBadRequest)
+		return
+	}
+
+	group, err := database.MergeSimilarGroups(requestData.IDs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	response := map[string]interface{}{
+		"success": true,
+		"group":   group,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, r, response)
+}
+
+// handleSimilarSplit takes the images given as ids out of their similar
+// groups. Images split from one group together form a group of their own.
+func handleSimilarSplit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var requestData struct {
+		IDs []int `json:"ids"`
+	}
+	if err := readJSON(r, &requestData); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(requestData.IDs) == 0 {
+		http.Error(w, "Image IDs are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := database.SplitSimilarGroup(requestData.IDs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	response := map[string]interface{}{
+		"success": true,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, r, response)
+}
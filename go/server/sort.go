@@ -0,0 +1,66 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"picpurge/database"
+)
+
+// imageSortKeys are the SQL expressions behind the sort parameter of
+// /api/images. Names sort naturally, so IMG_2 comes before IMG_10.
+var imageSortKeys = map[string]string{
+	"name":       "file_name COLLATE " + database.NaturalCollation,
+	"date":       "create_date",
+	"size":       "file_size",
+	"resolution": "image_width * image_height",
+}
+
+// imageGroupColumns hold the group of each image in the grouped listings.
+var imageGroupColumns = map[string]string{
+	"duplicates": "md5",
+	"similar":    "similar_group",
+	"brackets":   "bracket_set",
+	"panoramas":  "panorama_set",
+}
+
+// imageSortOrder returns the ORDER BY clause for the sort and order parameters
+// of a listing, or defaultOrder when no sort is asked for. Names sort
+// ascending by default and dates, sizes and resolutions descending, newest and
+// largest first. Grouped listings keep the members of a group together and
+// order the groups by their first member under the sort; defaultOrder, which
+// starts with the group column, breaks ties and orders the images within a group.
+func imageSortOrder(r *http.Request, imageType, defaultOrder string) (string, error) {
+	sortBy := r.URL.Query().Get("sort")
+	order := r.URL.Query().Get("order")
+	if sortBy == "" {
+		if order != "" {
+			return "", fmt.Errorf("order needs a sort")
+		}
+		return defaultOrder, nil
+	}
+	key, ok := imageSortKeys[sortBy]
+	if !ok {
+		return "", fmt.Errorf("sort must be name, date, size or resolution")
+	}
+	if order == "" {
+		order = "desc"
+		if sortBy == "name" {
+			order = "asc"
+		}
+	}
+	direction, aggregate := "ASC", "MIN"
+	switch order {
+	case "asc":
+	case "desc":
+		direction, aggregate = "DESC", "MAX"
+	default:
+		return "", fmt.Errorf("order must be asc or desc")
+	}
+
+	// Images without a date or size go last either way
+	if column, ok := imageGroupColumns[imageType]; ok {
+		return fmt.Sprintf("%s(%s) OVER (PARTITION BY %s) %s NULLS LAST, %s", aggregate, key, column, direction, defaultOrder), nil
+	}
+	return fmt.Sprintf("%s %s NULLS LAST, id", key, direction), nil
+}
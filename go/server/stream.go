@@ -0,0 +1,117 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// jsonStream writes a JSON object field by field. Image lists are encoded one
+// image at a time and flushed after every group, so a listing with thousands of
+// images is never held in memory as a single encoded buffer.
+type jsonStream struct {
+	w       io.Writer
+	flusher http.Flusher
+	fields  int
+	snake   bool // API version 2: field names in snake_case
+	err     error
+}
+
+// newJSONStream sets the JSON content type and opens the response object in
+// the API version of the request.
+func newJSONStream(w http.ResponseWriter, r *http.Request) *jsonStream {
+	version := requestAPIVersion(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(apiVersionHeader, strconv.Itoa(version))
+	flusher, _ := w.(http.Flusher)
+	s := &jsonStream{w: w, flusher: flusher, snake: version == APIVersion2}
+	s.write("{")
+	return s
+}
+
+// Field writes one field with an arbitrary JSON value.
+func (s *jsonStream) Field(name string, value interface{}) {
+	s.key(name)
+	if s.snake && s.err == nil {
+		value, s.err = snakeCaseJSON(value)
+	}
+	s.value(value)
+}
+
+// Images writes a field holding a list of images. Image fields are snake_case
+// in every API version, so images are written as they are.
+func (s *jsonStream) Images(name string, images []Image) {
+	s.key(name)
+	s.images(images)
+	s.flush()
+}
+
+// Groups writes a field holding a list of image groups, flushing after each group.
+func (s *jsonStream) Groups(name string, groups [][]Image) {
+	s.key(name)
+	s.write("[")
+	for i, group := range groups {
+		if i > 0 {
+			s.write(",")
+		}
+		s.images(group)
+		s.flush()
+	}
+	s.write("]")
+}
+
+// Close ends the response object and returns the first write or encoding error.
+func (s *jsonStream) Close() error {
+	s.write("}")
+	s.flush()
+	return s.err
+}
+
+func (s *jsonStream) images(images []Image) {
+	s.write("[")
+	for i := range images {
+		if i > 0 {
+			s.write(",")
+		}
+		s.value(images[i])
+	}
+	s.write("]")
+}
+
+func (s *jsonStream) key(name string) {
+	if s.fields > 0 {
+		s.write(",")
+	}
+	s.fields++
+	if s.snake {
+		name = snakeCase(name)
+	}
+	s.value(name)
+	s.write(":")
+}
+
+func (s *jsonStream) value(v interface{}) {
+	if s.err != nil {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		s.err = err
+		return
+	}
+	_, s.err = s.w.Write(data)
+}
+
+func (s *jsonStream) write(text string) {
+	if s.err != nil {
+		return
+	}
+	_, s.err = io.WriteString(s.w, text)
+}
+
+func (s *jsonStream) flush() {
+	if s.err == nil && s.flusher != nil {
+		s.flusher.Flush()
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"database/sql"
+	"log"
+	"os"
+
+	"picpurge/database"
+	"picpurge/events"
+	"picpurge/processor"
+	"picpurge/util"
+)
+
+// currentThumbnail returns the thumbnail for an MD5 after checking that the file it
+// was made from is unchanged. When the file was edited since the scan, the image is
+// processed again and the fresh thumbnail is returned instead of the stale one.
+// A thumbnail missing from the store is generated from the file.
+func currentThumbnail(md5 string) []byte {
+	cached := GetThumbnailFromMemory(md5)
+
+	db, err := database.GetDBInstance()
+	if err != nil {
+		return cached
+	}
+	var id int
+	var filePath string
+	var fileSize int64
+	var modTime sql.NullInt64
+	err = db.QueryRow("SELECT id, file_path, file_size, file_mod_time FROM images WHERE md5 = ? AND is_recycled = FALSE ORDER BY id ASC LIMIT 1", md5).
+		Scan(&id, &filePath, &fileSize, &modTime)
+	if err != nil {
+		return cached // Not in the catalog; nothing to verify against
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return cached // File is gone; keep showing what was scanned
+	}
+	if info.Size() == fileSize && modTime.Valid && info.ModTime().UnixNano() == modTime.Int64 {
+		if cached == nil {
+			return renderMissingThumbnail(md5, filePath)
+		}
+		return cached
+	}
+
+	// Size or timestamp changed: only a different hash means the content changed.
+	currentMD5, err := util.FileHash(filePath, util.DigestAlgo(md5))
+	if err != nil {
+		log.Printf("Warning: Could not verify thumbnail for %s: %v\n", filePath, err)
+		return cached
+	}
+	if currentMD5 == md5 {
+		if err := database.SetFileModTime(id, info.ModTime()); err != nil {
+			log.Printf("Warning: %v\n", err)
+		}
+		if cached == nil {
+			return renderMissingThumbnail(md5, filePath)
+		}
+		return cached
+	}
+
+	log.Printf("File %s changed since it was scanned; regenerating thumbnail.\n", filePath)
+	imageData, thumbnailData, err := processor.ProcessImage(filePath)
+	if err != nil {
+		log.Printf("Error reprocessing %s: %v\n", filePath, err)
+		return nil // Never show a preview of content that is no longer on disk
+	}
+	if thumbnailData != nil {
+		AddThumbnailToMemory(imageData.MD5, thumbnailData)
+		if err := database.StoreThumbnail(imageData.MD5, thumbnailData); err != nil {
+			log.Printf("Warning: %v\n", err)
+		}
+	}
+	if err := database.UpdateImageContent(id, imageData); err != nil {
+		log.Printf("Error updating image ID %d: %v\n", id, err)
+	} else {
+		events.Publish(events.Event{Kind: events.ImageUpdated, ImageID: id, MD5: imageData.MD5, OldMD5: md5, Path: filePath})
+	}
+	return thumbnailData
+}
+
+// renderSlots bounds how many missing thumbnails are generated at once, so a
+// grid full of them does not decode every original in parallel.
+var renderSlots = make(chan struct{}, util.DetectResourceLimits().CPUs)
+
+// renderMissingThumbnail generates the thumbnail of an unchanged file that has
+// none in the store, e.g. because it was lost or never written, and stores it.
+func renderMissingThumbnail(md5, filePath string) []byte {
+	renderSlots <- struct{}{}
+	defer func() { <-renderSlots }()
+	// Another request may have generated it while this one waited
+	if cached := GetThumbnailFromMemory(md5); cached != nil {
+		return cached
+	}
+
+	log.Printf("No thumbnail stored for %s; generating it.\n", filePath)
+	imageData, thumbnailData, err := processor.ProcessImage(filePath)
+	if err != nil {
+		log.Printf("Error generating thumbnail for %s: %v\n", filePath, err)
+		return nil
+	}
+	if imageData.MD5 != md5 || thumbnailData == nil {
+		return nil // Changed while being read, or nothing to show
+	}
+	AddThumbnailToMemory(md5, thumbnailData)
+	if err := database.StoreThumbnail(md5, thumbnailData); err != nil {
+		log.Printf("Warning: %v\n", err)
+	}
+	return thumbnailData
+}
+
+func init() {
+	events.Subscribe(evictThumbnails)
+}
+
+// evictThumbnails drops cached thumbnails that no catalog image refers to anymore.
+func evictThumbnails(e events.Event) {
+	md5 := e.MD5
+	if e.Kind == events.ImageUpdated {
+		md5 = e.OldMD5
+	} else if e.Kind != events.ImageRecycled {
+		return
+	}
+	// Duplicates share a thumbnail, so keep it while another copy is active
+	if count, err := database.CountActiveImagesWithMD5(md5); err != nil || count > 0 {
+		return
+	}
+	thumbnailMutex.Lock()
+	defer thumbnailMutex.Unlock()
+	removeThumbnailLocked(md5)
+}
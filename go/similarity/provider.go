@@ -0,0 +1,107 @@
+// Package similarity lets an external worker, such as a CLIP embedding service,
+// take the place of the built-in perceptual hash when grouping similar images.
+//
+// A worker receives one request per image, {"path": "/photos/IMG_0001.JPG"},
+// and answers with the image's signature: either {"hash": "<hex>"}, compared
+// by Hamming distance, or {"vector": [0.12, -0.4, ...]}, compared by cosine
+// distance. {"error": "..."} reports an image the worker cannot read.
+//
+// Command workers read requests from stdin and write responses to stdout, one
+// JSON object per line, in order. HTTP workers receive each request as the
+// body of a POST and return the response as its body.
+package similarity
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/bits"
+	"strings"
+)
+
+// Provider computes the signatures images are compared by.
+type Provider interface {
+	// Name identifies the provider, so cached signatures of another one are
+	// not mixed in.
+	Name() string
+	// Signature returns the signature of an image file.
+	Signature(filePath string) (Signature, error)
+	// Close stops the worker.
+	Close() error
+}
+
+// Signature is what a provider returns for an image: a hash or a vector.
+type Signature struct {
+	Hash   string    `json:"hash,omitempty"`
+	Vector []float64 `json:"vector,omitempty"`
+}
+
+// request and response are the messages of the worker protocol.
+type request struct {
+	Path string `json:"path"`
+}
+
+type response struct {
+	Signature
+	Error string `json:"error,omitempty"`
+}
+
+// signature validates a response from a worker.
+func (r response) signature() (Signature, error) {
+	if r.Error != "" {
+		return Signature{}, fmt.Errorf("worker error: %s", r.Error)
+	}
+	if r.Hash == "" && len(r.Vector) == 0 {
+		return Signature{}, fmt.Errorf("worker returned neither a hash nor a vector")
+	}
+	return r.Signature, nil
+}
+
+// New starts the provider for spec: an http:// or https:// URL for an HTTP
+// worker, or otherwise a command line that starts a command worker.
+func New(spec string) (Provider, error) {
+	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
+		return NewHTTPWorker(spec), nil
+	}
+	return NewCommandWorker(spec)
+}
+
+// Distance compares two signatures. Hashes are compared by the number of
+// differing bits; vectors by cosine distance in thousandths, so 150 means the
+// vectors are 0.15 apart.
+func Distance(a, b Signature) (int, error) {
+	switch {
+	case a.Hash != "" && b.Hash != "":
+		x, err := hex.DecodeString(a.Hash)
+		if err != nil {
+			return 0, fmt.Errorf("invalid hash %q: %w", a.Hash, err)
+		}
+		y, err := hex.DecodeString(b.Hash)
+		if err != nil {
+			return 0, fmt.Errorf("invalid hash %q: %w", b.Hash, err)
+		}
+		if len(x) != len(y) {
+			return 0, fmt.Errorf("hashes differ in length: %d and %d bytes", len(x), len(y))
+		}
+		distance := 0
+		for i := range x {
+			distance += bits.OnesCount8(x[i] ^ y[i])
+		}
+		return distance, nil
+	case len(a.Vector) > 0 && len(b.Vector) > 0:
+		if len(a.Vector) != len(b.Vector) {
+			return 0, fmt.Errorf("vectors differ in length: %d and %d", len(a.Vector), len(b.Vector))
+		}
+		var dot, normA, normB float64
+		for i := range a.Vector {
+			dot += a.Vector[i] * b.Vector[i]
+			normA += a.Vector[i] * a.Vector[i]
+			normB += b.Vector[i] * b.Vector[i]
+		}
+		if normA == 0 || normB == 0 {
+			return 0, fmt.Errorf("zero vector")
+		}
+		return int(math.Round(1000 * (1 - dot/math.Sqrt(normA*normB)))), nil
+	}
+	return 0, fmt.Errorf("cannot compare a hash with a vector")
+}
@@ -0,0 +1,98 @@
+package similarity
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDistance(t *testing.T) {
+	testCases := []struct {
+		a, b     Signature
+		expected int
+	}{
+		{Signature{Hash: "ff00"}, Signature{Hash: "ff00"}, 0},
+		{Signature{Hash: "ff00"}, Signature{Hash: "0f01"}, 5},
+		{Signature{Vector: []float64{1, 0}}, Signature{Vector: []float64{2, 0}}, 0},
+		{Signature{Vector: []float64{1, 0}}, Signature{Vector: []float64{0, 1}}, 1000},
+		{Signature{Vector: []float64{1, 0}}, Signature{Vector: []float64{1, 1}}, 293},
+	}
+	for _, tc := range testCases {
+		distance, err := Distance(tc.a, tc.b)
+		if err != nil || distance != tc.expected {
+			t.Errorf("Distance(%+v, %+v) = %d, %v; expected %d", tc.a, tc.b, distance, err, tc.expected)
+		}
+	}
+
+	for _, pair := range [][2]Signature{
+		{{Hash: "ff"}, {Hash: "ff00"}},
+		{{Hash: "zz"}, {Hash: "ff"}},
+		{{Vector: []float64{1}}, {Vector: []float64{1, 0}}},
+		{{Hash: "ff"}, {Vector: []float64{1}}},
+	} {
+		if _, err := Distance(pair[0], pair[1]); err == nil {
+			t.Errorf("Distance(%+v, %+v) succeeded; expected an error", pair[0], pair[1])
+		}
+	}
+}
+
+func TestCommandWorker(t *testing.T) {
+	// Answers with a hash for JPEGs and an error for anything else
+	script := `while read -r line; do
+		case "$line" in
+		*.jpg*) echo '{"hash": "0f0f"}' ;;
+		*) echo '{"error": "unsupported"}' ;;
+		esac
+	done`
+	scriptPath := filepath.Join(t.TempDir(), "worker.sh")
+	if err := os.WriteFile(scriptPath, []byte(script), 0644); err != nil {
+		t.Fatalf("Failed to write worker: %v", err)
+	}
+	provider, err := New("sh " + scriptPath)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if _, ok := provider.(*CommandWorker); !ok {
+		t.Fatalf("New returned %T; expected a command worker", provider)
+	}
+
+	signature, err := provider.Signature("/photos/a.jpg")
+	if err != nil || signature.Hash != "0f0f" {
+		t.Errorf("Signature(a.jpg) = %+v, %v; expected hash 0f0f", signature, err)
+	}
+	if _, err := provider.Signature("/photos/b.png"); err == nil || !strings.Contains(err.Error(), "unsupported") {
+		t.Errorf("Signature(b.png) error = %v; expected the worker's error", err)
+	}
+	if err := provider.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+}
+
+func TestHTTPWorker(t *testing.T) {
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" {
+			http.Error(w, `{"error": "bad request"}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(response{Signature: Signature{Vector: []float64{float64(len(req.Path)), 1}}})
+	}))
+	defer service.Close()
+
+	provider, err := New(service.URL)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer provider.Close()
+	signature, err := provider.Signature("/photos/a.jpg")
+	if err != nil || len(signature.Vector) != 2 || signature.Vector[0] != 13 {
+		t.Errorf("Signature = %+v, %v; expected a vector starting with 13", signature, err)
+	}
+	if _, err := provider.Signature(""); err == nil || !strings.Contains(err.Error(), "bad request") {
+		t.Errorf("Signature(\"\") error = %v; expected the service's error", err)
+	}
+}
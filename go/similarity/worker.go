@@ -0,0 +1,131 @@
+package similarity
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CommandWorker is a provider backed by a long-running command that speaks the
+// worker protocol on stdin and stdout.
+type CommandWorker struct {
+	spec   string
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	stderr bytes.Buffer
+	mu     sync.Mutex // one request at a time; responses come back in order
+}
+
+// NewCommandWorker starts command, a command line split on spaces.
+func NewCommandWorker(command string) (*CommandWorker, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no worker command given")
+	}
+	w := &CommandWorker{spec: command, cmd: exec.Command(args[0], args[1:]...)}
+	w.cmd.Stderr = &w.stderr
+	stdin, err := w.cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to worker: %w", err)
+	}
+	stdout, err := w.cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to worker: %w", err)
+	}
+	if err := w.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start worker %s: %w", args[0], err)
+	}
+	w.stdin, w.stdout = stdin, bufio.NewReader(stdout)
+	return w, nil
+}
+
+// Name returns the worker's command line.
+func (w *CommandWorker) Name() string {
+	return w.spec
+}
+
+// Signature sends one request to the worker and waits for its answer.
+func (w *CommandWorker) Signature(filePath string) (Signature, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	data, err := json.Marshal(request{Path: filePath})
+	if err != nil {
+		return Signature{}, err
+	}
+	if _, err := w.stdin.Write(append(data, '\n')); err != nil {
+		return Signature{}, fmt.Errorf("failed to write to worker: %w, stderr: %s", err, w.stderr.String())
+	}
+	line, err := w.stdout.ReadBytes('\n')
+	if err != nil {
+		return Signature{}, fmt.Errorf("failed to read from worker: %w, stderr: %s", err, w.stderr.String())
+	}
+	var resp response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return Signature{}, fmt.Errorf("failed to parse worker response %q: %w", line, err)
+	}
+	return resp.signature()
+}
+
+// Close closes the worker's stdin, which tells it to exit, and waits for it.
+func (w *CommandWorker) Close() error {
+	w.stdin.Close()
+	if err := w.cmd.Wait(); err != nil {
+		return fmt.Errorf("worker failed: %w, stderr: %s", err, w.stderr.String())
+	}
+	return nil
+}
+
+// HTTPWorker is a provider backed by a service that answers the worker
+// protocol over HTTP.
+type HTTPWorker struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPWorker returns a provider that posts requests to url.
+func NewHTTPWorker(url string) *HTTPWorker {
+	return &HTTPWorker{url: url, client: &http.Client{Timeout: 2 * time.Minute}}
+}
+
+// Name returns the worker's URL.
+func (w *HTTPWorker) Name() string {
+	return w.url
+}
+
+// Signature posts one request to the worker and decodes its answer.
+func (w *HTTPWorker) Signature(filePath string) (Signature, error) {
+	data, err := json.Marshal(request{Path: filePath})
+	if err != nil {
+		return Signature{}, err
+	}
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return Signature{}, fmt.Errorf("failed to reach worker: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return Signature{}, fmt.Errorf("failed to read worker response: %w", err)
+	}
+	var r response
+	if err := json.Unmarshal(body, &r); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return Signature{}, fmt.Errorf("worker returned %s", resp.Status)
+		}
+		return Signature{}, fmt.Errorf("failed to parse worker response: %w", err)
+	}
+	return r.signature()
+}
+
+// Close does nothing; the service keeps running.
+func (w *HTTPWorker) Close() error {
+	return nil
+}
@@ -0,0 +1,84 @@
+package util
+
+import (
+	"bytes"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// legacyEncodings are tried, in order of preference, for text that is not
+// UTF-8. Chinese and Japanese cameras and phones often write EXIF strings, and
+// archives made on those systems file names, in these encodings.
+var legacyEncodings = []encoding.Encoding{
+	simplifiedchinese.GB18030, // a superset of GBK and GB2312
+	japanese.ShiftJIS,
+}
+
+// DecodeText returns text as UTF-8. Text that is not valid UTF-8 is decoded
+// as GBK or Shift-JIS, whichever reads as more plausible Chinese or Japanese,
+// and as Windows-1252 when it looks like accented Latin text or neither fits.
+func DecodeText(text []byte) string {
+	if utf8.Valid(text) {
+		return string(text)
+	}
+	if !looksLatin(text) {
+		best, bestScore := "", 0
+		for _, enc := range legacyEncodings {
+			decoded, err := enc.NewDecoder().Bytes(text)
+			if err != nil || bytes.ContainsRune(decoded, utf8.RuneError) {
+				continue
+			}
+			if score := cjkScore(string(decoded)); score > bestScore {
+				best, bestScore = string(decoded), score
+			}
+		}
+		if best != "" {
+			return best
+		}
+	}
+	decoded, err := charmap.Windows1252.NewDecoder().Bytes(text)
+	if err != nil {
+		return string(bytes.ToValidUTF8(text, []byte("\ufffd")))
+	}
+	return string(decoded)
+}
+
+// looksLatin reports whether every non-ASCII byte stands alone and is an
+// accented letter in Windows-1252, as in "numérique". Double-byte encodings
+// pair their non-ASCII bytes or use lead bytes below 0xC0.
+func looksLatin(text []byte) bool {
+	for i, b := range text {
+		if b < utf8.RuneSelf {
+			continue
+		}
+		if b < 0xc0 || i > 0 && text[i-1] >= utf8.RuneSelf || i+1 < len(text) && text[i+1] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// cjkScore rates how much text looks like Chinese or Japanese. Kana only
+// occur in Japanese, so they outweigh ideographs, which any decoding of
+// double-byte text produces. Half-width katakana and rare characters are
+// what a wrong guess typically yields.
+func cjkScore(text string) int {
+	score := 0
+	for _, r := range text {
+		switch {
+		case r < utf8.RuneSelf:
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana) && (r < 0xff61 || r > 0xff9f):
+			score += 2
+		case r >= 0x4e00 && r <= 0x9fff, r >= 0x3000 && r <= 0x303f, r >= 0xff01 && r <= 0xff5e:
+			score++ // common ideographs, CJK and full-width punctuation
+		default:
+			score -= 2
+		}
+	}
+	return score
+}
@@ -0,0 +1,75 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DateShift moves a date by calendar units, e.g. to correct a camera clock that
+// was set a year and two days behind.
+type DateShift struct {
+	Years, Months, Days int
+	Clock               time.Duration // hours, minutes and seconds
+}
+
+var dateShiftPart = regexp.MustCompile(`^(\d+)(y|mo|d|h|m|s)`)
+
+// ParseDateShift reads a shift such as +1y2d, -3h or +1y2mo3d4h5m6s. Units are
+// y (years), mo (months), d (days), h, m and s. The sign applies to the whole
+// shift and defaults to +.
+func ParseDateShift(s string) (DateShift, error) {
+	var shift DateShift
+	rest := strings.TrimSpace(s)
+	sign := 1
+	if strings.HasPrefix(rest, "-") {
+		sign = -1
+	}
+	rest = strings.TrimLeft(rest, "+-")
+	if rest == "" {
+		return shift, fmt.Errorf("invalid date shift %q: expected e.g. +1y2d or -3h", s)
+	}
+
+	seen := make(map[string]bool)
+	for rest != "" {
+		m := dateShiftPart.FindStringSubmatch(rest)
+		if m == nil || seen[m[2]] {
+			return shift, fmt.Errorf("invalid date shift %q: expected e.g. +1y2d or -3h", s)
+		}
+		seen[m[2]] = true
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return shift, fmt.Errorf("invalid date shift %q: %w", s, err)
+		}
+		n *= sign
+		switch m[2] {
+		case "y":
+			shift.Years = n
+		case "mo":
+			shift.Months = n
+		case "d":
+			shift.Days = n
+		case "h":
+			shift.Clock += time.Duration(n) * time.Hour
+		case "m":
+			shift.Clock += time.Duration(n) * time.Minute
+		case "s":
+			shift.Clock += time.Duration(n) * time.Second
+		}
+		rest = rest[len(m[0]):]
+	}
+	return shift, nil
+}
+
+// Apply returns t moved by the shift. Calendar units are applied first, so
+// shifting 31 January by a month gives 3 March as with time.AddDate.
+func (s DateShift) Apply(t time.Time) time.Time {
+	return t.AddDate(s.Years, s.Months, s.Days).Add(s.Clock)
+}
+
+// IsZero reports whether the shift leaves dates unchanged.
+func (s DateShift) IsZero() bool {
+	return s == DateShift{}
+}
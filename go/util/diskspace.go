@@ -0,0 +1,140 @@
+package util
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SpaceThreshold is the free space below which a volume counts as running low.
+// It is either an absolute number of bytes or a percentage of the volume size.
+type SpaceThreshold struct {
+	Bytes   uint64
+	Percent float64
+}
+
+var sizeUnits = []struct {
+	suffix string
+	scale  uint64
+}{
+	{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+	{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+}
+
+// ParseSpaceThreshold parses values such as "10GB", "500M" or "5%". An empty
+// string or "0" disables the threshold.
+func ParseSpaceThreshold(s string) (SpaceThreshold, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return SpaceThreshold{}, nil
+	}
+	if strings.HasSuffix(s, "%") {
+		p, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || p < 0 || p > 100 {
+			return SpaceThreshold{}, fmt.Errorf("invalid free space percentage: %s", s)
+		}
+		return SpaceThreshold{Percent: p}, nil
+	}
+	n, err := ParseByteSize(s)
+	if err != nil {
+		return SpaceThreshold{}, fmt.Errorf("invalid free space threshold: %s", s)
+	}
+	return SpaceThreshold{Bytes: n}, nil
+}
+
+// ParseByteSize parses a size such as "10GB", "500M", "1.5 KB" or "2048".
+// Units are binary, so 1KB is 1024 bytes.
+func ParseByteSize(input string) (uint64, error) {
+	s := strings.ToUpper(strings.TrimSpace(input))
+	scale := uint64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			s, scale = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix)), unit.scale
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q; use e.g. 20KB", input)
+	}
+	return uint64(n * float64(scale)), nil
+}
+
+// ParseResolution parses a minimum resolution given as "WIDTHxHEIGHT", e.g.
+// "200x200", or as a single number that applies to both sides. An empty
+// string or "0" means no minimum.
+func ParseResolution(s string) (width, height int, err error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return 0, 0, nil
+	}
+	w, h, found := strings.Cut(s, "x")
+	if !found {
+		h = w
+	}
+	width, errW := strconv.Atoi(strings.TrimSpace(w))
+	height, errH := strconv.Atoi(strings.TrimSpace(h))
+	if errW != nil || errH != nil || width < 0 || height < 0 {
+		return 0, 0, fmt.Errorf("invalid resolution %q; use e.g. 200x200", s)
+	}
+	return width, height, nil
+}
+
+// Enabled reports whether a threshold was configured.
+func (t SpaceThreshold) Enabled() bool {
+	return t.Bytes > 0 || t.Percent > 0
+}
+
+// Low reports whether free bytes out of total are below the threshold.
+func (t SpaceThreshold) Low(free, total uint64) bool {
+	switch {
+	case t.Percent > 0:
+		return total > 0 && float64(free) < float64(total)*t.Percent/100
+	case t.Bytes > 0:
+		return free < t.Bytes
+	}
+	return false
+}
+
+// String formats the threshold the way it is given on the command line.
+func (t SpaceThreshold) String() string {
+	switch {
+	case t.Percent > 0:
+		return strconv.FormatFloat(t.Percent, 'f', -1, 64) + "%"
+	case t.Bytes > 0:
+		return FormatBytes(t.Bytes)
+	}
+	return "none"
+}
+
+// FormatBytes renders a byte count with a binary unit, e.g. "1.5 GB".
+func FormatBytes(n uint64) string {
+	for _, unit := range sizeUnits[:4] {
+		if n >= unit.scale {
+			return strconv.FormatFloat(float64(n)/float64(unit.scale), 'f', 1, 64) + " " + unit.suffix
+		}
+	}
+	return strconv.FormatUint(n, 10) + " B"
+}
+
+// VolumeName returns the drive or mounted volume a path lives on for display, e.g.
+// "C:", "Photos" for /Volumes/Photos or /media/user/Photos, and "/" otherwise.
+func VolumeName(path string) string {
+	if v := filepath.VolumeName(path); v != "" {
+		return v
+	}
+	parts := strings.Split(filepath.ToSlash(filepath.Clean(path)), "/")
+	// parts[0] is empty for absolute paths
+	switch {
+	case len(parts) > 3 && parts[1] == "Volumes":
+		return parts[2]
+	case len(parts) > 4 && parts[1] == "media":
+		return parts[3]
+	case len(parts) > 5 && parts[1] == "run" && parts[2] == "media":
+		return parts[4]
+	case len(parts) > 3 && parts[1] == "mnt":
+		return parts[2]
+	}
+	return "/"
+}
@@ -0,0 +1,18 @@
+//go:build !(linux || darwin || freebsd || windows)
+
+package util
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// VolumeUsage is not supported on this platform.
+func VolumeUsage(path string) (free, total uint64, err error) {
+	return 0, 0, fmt.Errorf("free space checks are not supported on %s", runtime.GOOS)
+}
+
+// VolumeID is not supported on this platform.
+func VolumeID(path string) (string, error) {
+	return "", fmt.Errorf("volume detection is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,32 @@
+//go:build linux || darwin || freebsd
+
+package util
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// VolumeUsage reports the free (available to this user) and total bytes of the
+// file system holding path.
+func VolumeUsage(path string) (free, total uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, fmt.Errorf("failed to stat file system of %s: %w", path, err)
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), uint64(st.Blocks) * uint64(st.Bsize), nil
+}
+
+// VolumeID identifies the file system holding path so paths can be grouped by volume.
+func VolumeID(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", fmt.Errorf("no device information for %s", path)
+	}
+	return fmt.Sprint(st.Dev), nil
+}
@@ -1,77 +1,67 @@
 package util
 
 import (
-	"fmt"
+	"bytes"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
-// CopyFile copies a file from src to dst.
-func CopyFile(src, dst string) error {
-	sourceFile, err := os.Open(src)
+// FileMD5 returns the hex encoded MD5 hash of a file's contents.
+func FileMD5(filePath string) (string, error) {
+	return FileHash(filePath, HashMD5)
+}
+
+// FilesEqual reports whether two files have identical contents, comparing
+// every byte rather than trusting a hash.
+func FilesEqual(a, b string) (bool, error) {
+	fileA, err := os.Open(a)
 	if err != nil {
-		return err
+		return false, err
 	}
-	defer sourceFile.Close()
-
-	destinationFile, err := os.Create(dst)
+	defer fileA.Close()
+	fileB, err := os.Open(b)
 	if err != nil {
-		return err
+		return false, err
 	}
-	defer destinationFile.Close()
-
-	_, err = io.Copy(destinationFile, sourceFile)
-	return err
-}
+	defer fileB.Close()
 
-// RecycleFile moves a file to the Recycle directory.
-func RecycleFile(filePath, recycleDir string) error {
-	// Check if file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return fmt.Errorf("file does not exist: %s", filePath)
+	infoA, err := fileA.Stat()
+	if err != nil {
+		return false, err
 	}
-
-	// Create the Recycle directory if it doesn't exist
-	if err := os.MkdirAll(recycleDir, 0755); err != nil {
-		return fmt.Errorf("failed to create Recycle directory: %w", err)
+	infoB, err := fileB.Stat()
+	if err != nil {
+		return false, err
+	}
+	if infoA.Size() != infoB.Size() {
+		return false, nil
 	}
 
-	// Get the base name of the file
-	fileName := filepath.Base(filePath)
-
-	// Generate the destination path
-	destPath := filepath.Join(recycleDir, fileName)
-
-	// If a file with the same name already exists in Recycle, add a counter
-	counter := 1
+	bufA, bufB := make([]byte, 64*1024), make([]byte, 64*1024)
 	for {
-		if _, err := os.Stat(destPath); os.IsNotExist(err) {
-			break // File doesn't exist, we can use this path
+		n, errA := io.ReadFull(fileA, bufA)
+		m, errB := io.ReadFull(fileB, bufB)
+		if n != m || !bytes.Equal(bufA[:n], bufB[:m]) {
+			return false, nil
 		}
-		// File exists, add a counter to the filename
-		ext := filepath.Ext(fileName)
-		nameWithoutExt := fileName[:len(fileName)-len(ext)]
-		destPath = filepath.Join(recycleDir, fmt.Sprintf("%s_%d%s", nameWithoutExt, counter, ext))
-		counter++
-
-		// Prevent infinite loop
-		if counter > 1000 {
-			return fmt.Errorf("too many files with the same name in Recycle directory")
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
 		}
-	}
-
-	// Move the file to the Recycle directory
-	if err := os.Rename(filePath, destPath); err != nil {
-		// If Rename fails, try to copy and then remove
-		if copyErr := CopyFile(filePath, destPath); copyErr != nil {
-			return fmt.Errorf("failed to move or copy file: %w", copyErr)
+		if errA != nil {
+			return false, errA
 		}
-		// Remove the original file
-		if removeErr := os.Remove(filePath); removeErr != nil {
-			return fmt.Errorf("copied file successfully but failed to remove original: %w", removeErr)
+		if errB != nil {
+			return false, errB
 		}
 	}
+}
 
-	return nil
+// InFolder reports whether filePath lies in folder or one of its subfolders.
+func InFolder(filePath, folder string) bool {
+	folder = filepath.Clean(folder)
+	dir := filepath.Dir(filePath)
+	return dir == folder || strings.HasPrefix(dir, folder+string(filepath.Separator)) ||
+		(strings.HasSuffix(folder, string(filepath.Separator)) && strings.HasPrefix(dir, folder))
 }
@@ -0,0 +1,99 @@
+package util
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/zeebo/blake3"
+	"github.com/zeebo/xxh3"
+)
+
+// HashAlgo names an algorithm for the content digests that tell exact
+// duplicates apart.
+type HashAlgo string
+
+// Content hash algorithms. MD5 is the original one; collisions for it are
+// cheap to construct, so SHA-256 and BLAKE3 are there for audit-grade dedupe.
+// XXH3 is the fastest but, like MD5, no protection against crafted files.
+const (
+	HashMD5    HashAlgo = "md5"
+	HashSHA256 HashAlgo = "sha256"
+	HashBLAKE3 HashAlgo = "blake3"
+	HashXXH3   HashAlgo = "xxh3"
+)
+
+// HashAlgos lists the supported content hash algorithms.
+var HashAlgos = []HashAlgo{HashMD5, HashSHA256, HashBLAKE3, HashXXH3}
+
+// ParseHashAlgo returns the content hash algorithm with the given name.
+func ParseHashAlgo(name string) (HashAlgo, error) {
+	algo := HashAlgo(strings.ToLower(strings.TrimSpace(name)))
+	for _, known := range HashAlgos {
+		if algo == known {
+			return algo, nil
+		}
+	}
+	return "", fmt.Errorf("unknown hash algorithm %q; use md5, sha256, blake3 or xxh3", name)
+}
+
+// NewHash returns a new hash of the given algorithm.
+func NewHash(algo HashAlgo) hash.Hash {
+	switch algo {
+	case HashSHA256:
+		return sha256.New()
+	case HashBLAKE3:
+		return blake3.New()
+	case HashXXH3:
+		return xxh3.New()
+	}
+	return md5.New()
+}
+
+// Digests are stored as hex, prefixed with their algorithm as in
+// "sha256:9f86d0...", except MD5 digests, which stay bare as catalogs have
+// always stored them.
+
+// FormatDigest returns the stored form of a digest.
+func FormatDigest(algo HashAlgo, sum []byte) string {
+	if algo == HashMD5 || algo == "" {
+		return hex.EncodeToString(sum)
+	}
+	return string(algo) + ":" + hex.EncodeToString(sum)
+}
+
+// DigestAlgo returns the algorithm of a stored digest.
+func DigestAlgo(digest string) HashAlgo {
+	if algo, _, ok := strings.Cut(digest, ":"); ok {
+		return HashAlgo(algo)
+	}
+	return HashMD5
+}
+
+// DigestHex returns a stored digest without its algorithm, e.g. for file names.
+func DigestHex(digest string) string {
+	if _, sum, ok := strings.Cut(digest, ":"); ok {
+		return sum
+	}
+	return digest
+}
+
+// FileHash returns the stored form of the digest of a file's contents.
+func FileHash(filePath string, algo HashAlgo) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := NewHash(algo)
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return FormatDigest(algo, hash.Sum(nil)), nil
+}
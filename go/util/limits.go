@@ -0,0 +1,117 @@
+package util
+
+import (
+	"math"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ResourceLimits are the CPUs and memory the process may use. In a container
+// or a systemd service they come from its cgroup; otherwise they are the
+// machine's CPUs and no memory limit.
+type ResourceLimits struct {
+	CPUs   int    // whole CPUs, a fractional quota rounded up
+	Memory uint64 // bytes, 0 when unlimited
+	Source string // "cgroup v2", "cgroup v1" or "" for the machine
+}
+
+var (
+	resourceLimits     ResourceLimits
+	resourceLimitsOnce sync.Once
+)
+
+// DetectResourceLimits returns the limits of the process, read once.
+func DetectResourceLimits() ResourceLimits {
+	resourceLimitsOnce.Do(func() {
+		resourceLimits = ResourceLimits{CPUs: runtime.NumCPU()}
+		cpus, memory, source := cgroupLimits()
+		if cpus > 0 {
+			if n := int(math.Ceil(cpus)); n < resourceLimits.CPUs {
+				resourceLimits.CPUs = n
+			}
+		}
+		if resourceLimits.CPUs < 1 {
+			resourceLimits.CPUs = 1
+		}
+		resourceLimits.Memory = memory
+		if cpus > 0 || memory > 0 {
+			resourceLimits.Source = source
+		}
+	})
+	return resourceLimits
+}
+
+// Limited reports whether a cgroup restricts the CPUs or memory.
+func (l ResourceLimits) Limited() bool {
+	return l.Source != ""
+}
+
+// String describes the limits for logging, e.g. "2 CPUs, 1.0 GB memory (cgroup v2)".
+func (l ResourceLimits) String() string {
+	s := strconv.Itoa(l.CPUs) + " CPUs"
+	if l.Memory > 0 {
+		s += ", " + FormatBytes(l.Memory) + " memory"
+	}
+	if l.Source != "" {
+		s += " (" + l.Source + ")"
+	}
+	return s
+}
+
+// SetSoftMemoryLimit lets the Go runtime collect garbage harder as the process
+// nears its memory limit, at 90% of it, instead of being killed there. A
+// GOMEMLIMIT set in the environment takes precedence.
+func SetSoftMemoryLimit(l ResourceLimits) {
+	if l.Memory == 0 || os.Getenv("GOMEMLIMIT") != "" {
+		return
+	}
+	debug.SetMemoryLimit(int64(l.Memory / 10 * 9))
+}
+
+// parseCPUMax parses a cgroup v2 cpu.max file, "QUOTA PERIOD" in
+// microseconds, into a number of CPUs. It returns 0 for "max" or an invalid
+// file.
+func parseCPUMax(content string) float64 {
+	fields := strings.Fields(content)
+	if len(fields) == 0 || fields[0] == "max" {
+		return 0
+	}
+	period := 100000.0
+	if len(fields) > 1 {
+		p, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || p <= 0 {
+			return 0
+		}
+		period = p
+	}
+	return parseCPUQuota(fields[0], period)
+}
+
+// parseCPUQuota turns a quota in microseconds per period into a number of
+// CPUs. It returns 0 when the quota is unlimited, which cgroup v1 writes as -1.
+func parseCPUQuota(quota string, period float64) float64 {
+	q, err := strconv.ParseFloat(strings.TrimSpace(quota), 64)
+	if err != nil || q <= 0 || period <= 0 {
+		return 0
+	}
+	return q / period
+}
+
+// parseCgroupMemory parses memory.max (cgroup v2) or memory.limit_in_bytes
+// (cgroup v1). It returns 0 for "max", for the huge value cgroup v1 reports
+// when there is no limit, and for an invalid file.
+func parseCgroupMemory(content string) uint64 {
+	content = strings.TrimSpace(content)
+	if content == "" || content == "max" {
+		return 0
+	}
+	n, err := strconv.ParseUint(content, 10, 64)
+	if err != nil || n >= 1<<62 {
+		return 0
+	}
+	return n
+}
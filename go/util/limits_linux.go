@@ -0,0 +1,86 @@
+//go:build linux
+
+package util
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupLimits reads the CPU quota and memory limit of the cgroup the process
+// runs in. Zero values mean no limit.
+func cgroupLimits() (cpus float64, memory uint64, source string) {
+	read := func(path string) string {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+
+	// cgroup v2 has one hierarchy; limits may be set on any ancestor, so the
+	// tightest one from the process's group up to the root applies
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err == nil {
+		for dir := filepath.Join(cgroupRoot, cgroupPath("")); strings.HasPrefix(dir, cgroupRoot); dir = filepath.Dir(dir) {
+			if c := parseCPUMax(read(filepath.Join(dir, "cpu.max"))); c > 0 && (cpus == 0 || c < cpus) {
+				cpus = c
+			}
+			if m := parseCgroupMemory(read(filepath.Join(dir, "memory.max"))); m > 0 && (memory == 0 || m < memory) {
+				memory = m
+			}
+			if dir == cgroupRoot {
+				break
+			}
+		}
+		return cpus, memory, "cgroup v2"
+	}
+
+	// cgroup v1 has a hierarchy per controller. Inside a container the
+	// process's group is usually mounted as the root of each
+	for _, dir := range []string{filepath.Join(cgroupRoot, "cpu", cgroupPath("cpu")), filepath.Join(cgroupRoot, "cpu")} {
+		period := parseCgroupMemory(read(filepath.Join(dir, "cpu.cfs_period_us")))
+		if c := parseCPUQuota(read(filepath.Join(dir, "cpu.cfs_quota_us")), float64(period)); c > 0 {
+			cpus = c
+			break
+		}
+	}
+	for _, dir := range []string{filepath.Join(cgroupRoot, "memory", cgroupPath("memory")), filepath.Join(cgroupRoot, "memory")} {
+		if m := parseCgroupMemory(read(filepath.Join(dir, "memory.limit_in_bytes"))); m > 0 {
+			memory = m
+			break
+		}
+	}
+	return cpus, memory, "cgroup v1"
+}
+
+// cgroupPath returns the path of the process's cgroup for a cgroup v1
+// controller, or the cgroup v2 path when controller is empty, as listed in
+// /proc/self/cgroup.
+func cgroupPath(controller string) string {
+	file, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "/"
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Lines are "ID:CONTROLLERS:PATH"; cgroup v2 has ID 0 and no controllers
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if controller == "" && parts[0] == "0" && parts[1] == "" {
+			return parts[2]
+		}
+		for _, c := range strings.Split(parts[1], ",") {
+			if controller != "" && c == controller {
+				return parts[2]
+			}
+		}
+	}
+	return "/"
+}
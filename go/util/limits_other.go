@@ -0,0 +1,8 @@
+//go:build !linux
+
+package util
+
+// cgroupLimits reports no limits on platforms without cgroups.
+func cgroupLimits() (cpus float64, memory uint64, source string) {
+	return 0, 0, ""
+}
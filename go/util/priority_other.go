@@ -0,0 +1,15 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package util
+
+import "os/exec"
+
+// LowerProcessPriority is a no-op on platforms without setpriority.
+func LowerProcessPriority() error {
+	return nil
+}
+
+// LowPriorityCommand is exec.Command on platforms without nice.
+func LowPriorityCommand(name string, args ...string) *exec.Cmd {
+	return exec.Command(name, args...)
+}
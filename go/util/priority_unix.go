@@ -0,0 +1,23 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package util
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// LowerProcessPriority raises the nice value of the current process so that
+// other programs get the CPU first.
+func LowerProcessPriority() error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, 0, 10)
+}
+
+// LowPriorityCommand is exec.Command for background work: the program runs
+// under nice when it is installed, so it only takes otherwise idle CPU.
+func LowPriorityCommand(name string, args ...string) *exec.Cmd {
+	if nice, err := exec.LookPath("nice"); err == nil {
+		return exec.Command(nice, append([]string{"-n", "19", name}, args...)...)
+	}
+	return exec.Command(name, args...)
+}
@@ -0,0 +1,61 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrRecycleCapExceeded is returned when an automated action would recycle more files than the cap allows.
+var ErrRecycleCapExceeded = errors.New("recycle cap exceeded")
+
+// RecycleCap limits how many files a single automated action may recycle.
+// It is either an absolute count or a percentage of the library.
+type RecycleCap struct {
+	Count   int
+	Percent float64
+}
+
+// ParseRecycleCap parses values such as "500" or "10%". An empty string or "0" disables the cap.
+func ParseRecycleCap(s string) (RecycleCap, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return RecycleCap{}, nil
+	}
+	if strings.HasSuffix(s, "%") {
+		p, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || p < 0 || p > 100 {
+			return RecycleCap{}, fmt.Errorf("invalid recycle cap percentage: %s", s)
+		}
+		return RecycleCap{Percent: p}, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return RecycleCap{}, fmt.Errorf("invalid recycle cap: %s", s)
+	}
+	return RecycleCap{Count: n}, nil
+}
+
+// Limit returns the maximum number of files that may be recycled from a library
+// of librarySize files, or -1 when there is no cap.
+func (c RecycleCap) Limit(librarySize int) int {
+	switch {
+	case c.Percent > 0:
+		return int(float64(librarySize) * c.Percent / 100)
+	case c.Count > 0:
+		return c.Count
+	}
+	return -1
+}
+
+// String formats the cap the way it is given on the command line.
+func (c RecycleCap) String() string {
+	switch {
+	case c.Percent > 0:
+		return strconv.FormatFloat(c.Percent, 'f', -1, 64) + "%"
+	case c.Count > 0:
+		return strconv.Itoa(c.Count)
+	}
+	return "none"
+}
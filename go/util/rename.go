@@ -0,0 +1,113 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// RenamePolicy controls how sorted files are named in their destination directory.
+type RenamePolicy string
+
+const (
+	// RenameKeep keeps the original file name.
+	RenameKeep RenamePolicy = "keep"
+	// RenameDatePrefix prefixes the original file name with the capture date.
+	RenameDatePrefix RenamePolicy = "date-prefix"
+	// RenameHash names the file after its content hash.
+	RenameHash RenamePolicy = "hash"
+)
+
+// maxNameBytes is the longest base name (without extension) we generate.
+// Most file systems limit a single path component to 255 bytes.
+const maxNameBytes = 200
+
+// ParseRenamePolicy validates a policy name given on the command line.
+func ParseRenamePolicy(s string) (RenamePolicy, error) {
+	switch p := RenamePolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case RenameKeep, RenameDatePrefix, RenameHash:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown rename policy %q (expected keep, date-prefix or hash)", s)
+}
+
+// SortFileName builds the destination file name for a sorted image according to the policy.
+// The original extension is always kept last, lower-cased.
+func SortFileName(policy RenamePolicy, originalName string, createDate time.Time, md5 string) string {
+	ext := strings.ToLower(filepath.Ext(originalName))
+	base := sanitizeName(strings.TrimSuffix(originalName, filepath.Ext(originalName)))
+
+	var name string
+	switch policy {
+	case RenameKeep:
+		name = base
+	case RenameHash:
+		name = DigestHex(md5)
+	default:
+		name = createDate.Format("20060102_150405") + "_" + base
+	}
+	if name == "" {
+		name = createDate.Format("20060102_150405")
+	}
+	return truncateName(name, maxNameBytes) + ext
+}
+
+// SortDir returns the YYYY/MM directory below baseDir that an image taken at createDate is sorted into.
+func SortDir(baseDir string, createDate time.Time) string {
+	return filepath.Join(baseDir, createDate.Format("2006"), createDate.Format("01"))
+}
+
+// UniquePath returns a path in dir for name that does not exist yet.
+// Collisions are resolved by appending _1, _2, ... before the extension, so the
+// same input order always produces the same names.
+func UniquePath(dir, name string) (string, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := filepath.Join(dir, name)
+	for counter := 1; ; counter++ {
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate, nil
+		} else if err != nil {
+			return "", err
+		}
+		// Prevent infinite loop
+		if counter > 10000 {
+			return "", fmt.Errorf("too many files named %s in %s", name, dir)
+		}
+		candidate = filepath.Join(dir, fmt.Sprintf("%s_%d%s", base, counter, ext))
+	}
+}
+
+// sanitizeName replaces path separators, control characters and characters that
+// are reserved on common file systems. Other Unicode characters are kept as is.
+func sanitizeName(name string) string {
+	if !utf8.ValidString(name) {
+		name = strings.ToValidUTF8(name, "_")
+	}
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsControl(r):
+			return '_'
+		case strings.ContainsRune(`/\:*?"<>|`, r):
+			return '_'
+		}
+		return r
+	}, name)
+	return strings.Trim(name, " .")
+}
+
+// truncateName shortens name to at most max bytes without splitting a UTF-8 sequence.
+func truncateName(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(name[cut]) {
+		cut--
+	}
+	return name[:cut]
+}
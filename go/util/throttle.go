@@ -0,0 +1,74 @@
+package util
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimiter caps the number of bytes read per second across all readers that
+// share it, and counts the bytes that went through it.
+// A limit of 0 disables throttling but keeps counting.
+type RateLimiter struct {
+	mu          sync.Mutex
+	bytesPerSec float64
+	next        time.Time
+	total       atomic.Int64
+}
+
+// NewRateLimiter creates a limiter allowing bytesPerSec bytes per second.
+func NewRateLimiter(bytesPerSec float64) *RateLimiter {
+	return &RateLimiter{bytesPerSec: bytesPerSec}
+}
+
+// Wait blocks until n more bytes may be read.
+func (l *RateLimiter) Wait(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+	l.total.Add(int64(n))
+	if l.bytesPerSec <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(n) / l.bytesPerSec * float64(time.Second)))
+	l.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// Total returns the number of bytes counted so far.
+func (l *RateLimiter) Total() int64 {
+	if l == nil {
+		return 0
+	}
+	return l.total.Load()
+}
+
+// Reader wraps r so that reads are throttled and counted by the limiter.
+func (l *RateLimiter) Reader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &throttledReader{r: r, limiter: l}
+}
+
+type throttledReader struct {
+	r       io.Reader
+	limiter *RateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.limiter.Wait(n)
+	return n, err
+}
@@ -1,80 +1,362 @@
 package util
 
 import (
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
 )
 
-func TestCopyFile(t *testing.T) {
-	// Create a temporary source file
-	srcFile, err := os.CreateTemp("", "test_src_*.txt")
-	if err != nil {
-		t.Fatalf("Failed to create temporary source file: %v", err)
+func TestFileMD5(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "hello.txt")
+	if err := os.WriteFile(filePath, []byte("hello"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
 	}
-	defer os.Remove(srcFile.Name())
-	srcFile.Close()
 
-	// Write some content to the source file
-	content := "Hello, World!"
-	err = os.WriteFile(srcFile.Name(), []byte(content), 0644)
+	sum, err := FileMD5(filePath)
 	if err != nil {
-		t.Fatalf("Failed to write to source file: %v", err)
+		t.Fatalf("FileMD5 failed: %v", err)
+	}
+	if sum != "5d41402abc4b2a76b9719d911017c592" {
+		t.Errorf("FileMD5 = %s; expected 5d41402abc4b2a76b9719d911017c592", sum)
 	}
+}
 
-	// Create a destination file path
-	dstFile := filepath.Join(os.TempDir(), "test_dst.txt")
-	defer os.Remove(dstFile)
+func TestFileHash(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "hello.txt")
+	if err := os.WriteFile(filePath, []byte("hello"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
 
-	// Test CopyFile function
-	err = CopyFile(srcFile.Name(), dstFile)
-	if err != nil {
-		t.Fatalf("CopyFile failed: %v", err)
+	expected := map[HashAlgo]string{
+		HashMD5:    "5d41402abc4b2a76b9719d911017c592",
+		HashSHA256: "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		HashBLAKE3: "blake3:ea8f163db38682925e4491c5e58d4bb3506ef8c14eb78a86e908c5624a67200f",
+		HashXXH3:   "xxh3:9555e8555c62dcfd",
+	}
+	for _, algo := range HashAlgos {
+		digest, err := FileHash(filePath, algo)
+		if err != nil {
+			t.Fatalf("FileHash(%s) failed: %v", algo, err)
+		}
+		if digest != expected[algo] {
+			t.Errorf("FileHash(%s) = %s; expected %s", algo, digest, expected[algo])
+		}
+		if DigestAlgo(digest) != algo {
+			t.Errorf("DigestAlgo(%s) = %s; expected %s", digest, DigestAlgo(digest), algo)
+		}
+		if hex := DigestHex(digest); strings.Contains(hex, ":") || !strings.HasSuffix(digest, hex) {
+			t.Errorf("DigestHex(%s) = %s", digest, hex)
+		}
 	}
 
-	// Check if the destination file exists
-	if _, err := os.Stat(dstFile); os.IsNotExist(err) {
-		t.Fatalf("Destination file was not created")
+	if algo, err := ParseHashAlgo(" SHA256 "); err != nil || algo != HashSHA256 {
+		t.Errorf("ParseHashAlgo(SHA256) = %s, %v", algo, err)
+	}
+	if _, err := ParseHashAlgo("crc32"); err == nil {
+		t.Error("ParseHashAlgo accepted crc32")
 	}
+}
 
-	// Check if the content is correct
-	dstContent, err := os.ReadFile(dstFile)
-	if err != nil {
-		t.Fatalf("Failed to read destination file: %v", err)
+func TestSortFileName(t *testing.T) {
+	date := time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)
+	testCases := []struct {
+		policy   RenamePolicy
+		name     string
+		expected string
+	}{
+		{RenameKeep, "img.JPG", "img.jpg"},
+		{RenameDatePrefix, "img.jpg", "20230102_150405_img.jpg"},
+		{RenameHash, "img.jpg", "abc123.jpg"},
+		{RenameKeep, "照片:1.png", "照片_1.png"},
+		{RenameKeep, ".jpg", "20230102_150405.jpg"},
 	}
 
-	if string(dstContent) != content {
-		t.Fatalf("Content mismatch. Expected: %s, Got: %s", content, string(dstContent))
+	for _, tc := range testCases {
+		result := SortFileName(tc.policy, tc.name, date, "abc123")
+		if result != tc.expected {
+			t.Errorf("SortFileName(%s, %s) = %s; expected %s", tc.policy, tc.name, result, tc.expected)
+		}
+	}
+
+	long := strings.Repeat("é", 300) + ".jpg"
+	result := SortFileName(RenameKeep, long, date, "")
+	if !utf8.ValidString(result) || len(result) > maxNameBytes+len(".jpg") {
+		t.Errorf("SortFileName did not truncate long name safely: %d bytes", len(result))
 	}
 }
 
-func TestRecycleFile(t *testing.T) {
-	// Create a temporary file to recycle
-	tempFile, err := os.CreateTemp("", "test_recycle_*.txt")
+func TestUniquePath(t *testing.T) {
+	tempDir := t.TempDir()
+
+	first, err := UniquePath(tempDir, "a.jpg")
 	if err != nil {
-		t.Fatalf("Failed to create temporary file: %v", err)
+		t.Fatalf("UniquePath failed: %v", err)
+	}
+	if first != filepath.Join(tempDir, "a.jpg") {
+		t.Errorf("UniquePath returned %s; expected a.jpg", first)
+	}
+	if err := os.WriteFile(first, []byte("test"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
 	}
-	defer os.Remove(tempFile.Name())
-	tempFile.Close()
 
-	// Create a recycle directory
-	recycleDir := filepath.Join(os.TempDir(), "test_recycle_dir")
-	defer os.RemoveAll(recycleDir)
+	second, err := UniquePath(tempDir, "a.jpg")
+	if err != nil {
+		t.Fatalf("UniquePath failed: %v", err)
+	}
+	if second != filepath.Join(tempDir, "a_1.jpg") {
+		t.Errorf("UniquePath returned %s; expected a_1.jpg", second)
+	}
+}
 
-	// Test RecycleFile function
-	err = RecycleFile(tempFile.Name(), recycleDir)
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(1000) // 1000 bytes per second
+	data := strings.NewReader(strings.Repeat("x", 300))
+
+	start := time.Now()
+	n, err := io.Copy(io.Discard, limiter.Reader(data))
 	if err != nil {
-		t.Fatalf("RecycleFile failed: %v", err)
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if n != 300 || limiter.Total() != 300 {
+		t.Errorf("Expected 300 bytes read and counted, got %d read and %d counted", n, limiter.Total())
+	}
+
+	// A second read must wait for the first one's budget.
+	limiter.Wait(100)
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("RateLimiter did not throttle: elapsed %v", elapsed)
+	}
+}
+
+func TestParseRecycleCap(t *testing.T) {
+	testCases := []struct {
+		input    string
+		library  int
+		expected int
+	}{
+		{"", 1000, -1},
+		{"0", 1000, -1},
+		{"500", 1000, 500},
+		{"10%", 1000, 100},
+		{"2.5%", 1000, 25},
+	}
+
+	for _, tc := range testCases {
+		c, err := ParseRecycleCap(tc.input)
+		if err != nil {
+			t.Fatalf("ParseRecycleCap(%q) failed: %v", tc.input, err)
+		}
+		if limit := c.Limit(tc.library); limit != tc.expected {
+			t.Errorf("ParseRecycleCap(%q).Limit(%d) = %d; expected %d", tc.input, tc.library, limit, tc.expected)
+		}
+	}
+
+	for _, input := range []string{"abc", "-1", "150%"} {
+		if _, err := ParseRecycleCap(input); err == nil {
+			t.Errorf("ParseRecycleCap(%q) should fail", input)
+		}
+	}
+}
+
+func TestParseSpaceThreshold(t *testing.T) {
+	testCases := []struct {
+		input       string
+		free, total uint64
+		expected    bool
+	}{
+		{"", 0, 100, false},
+		{"10GB", 5 << 30, 100 << 30, true},
+		{"10g", 20 << 30, 100 << 30, false},
+		{"500M", 400 << 20, 1 << 30, true},
+		{"5%", 4, 100, true},
+		{"5%", 6, 100, false},
+	}
+
+	for _, tc := range testCases {
+		threshold, err := ParseSpaceThreshold(tc.input)
+		if err != nil {
+			t.Fatalf("ParseSpaceThreshold(%q) failed: %v", tc.input, err)
+		}
+		if low := threshold.Low(tc.free, tc.total); low != tc.expected {
+			t.Errorf("ParseSpaceThreshold(%q).Low(%d, %d) = %v; expected %v", tc.input, tc.free, tc.total, low, tc.expected)
+		}
+	}
+
+	for _, input := range []string{"abc", "-1GB", "150%"} {
+		if _, err := ParseSpaceThreshold(input); err == nil {
+			t.Errorf("ParseSpaceThreshold(%q) should fail", input)
+		}
 	}
+}
+
+func TestParseResolution(t *testing.T) {
+	testCases := []struct {
+		input         string
+		width, height int
+	}{
+		{"", 0, 0},
+		{"200x100", 200, 100},
+		{"64", 64, 64},
+		{" 640 X 480 ", 640, 480},
+	}
+	for _, tc := range testCases {
+		width, height, err := ParseResolution(tc.input)
+		if err != nil || width != tc.width || height != tc.height {
+			t.Errorf("ParseResolution(%q) = %d, %d, %v; expected %d, %d", tc.input, width, height, err, tc.width, tc.height)
+		}
+	}
+
+	for _, input := range []string{"abc", "200x", "-1x10"} {
+		if _, _, err := ParseResolution(input); err == nil {
+			t.Errorf("ParseResolution(%q) should fail", input)
+		}
+	}
+}
 
-	// Check if the file was moved to the recycle directory
-	recycledFile := filepath.Join(recycleDir, filepath.Base(tempFile.Name()))
-	if _, err := os.Stat(recycledFile); os.IsNotExist(err) {
-		t.Fatalf("File was not moved to recycle directory")
+func TestParseCgroupLimits(t *testing.T) {
+	cpuMax := map[string]float64{
+		"max 100000\n":    0,
+		"200000 100000\n": 2,
+		"50000 100000":    0.5,
+		"150000":          1.5,
+		"":                0,
+		"abc 100000":      0,
+	}
+	for content, expected := range cpuMax {
+		if cpus := parseCPUMax(content); cpus != expected {
+			t.Errorf("parseCPUMax(%q) = %v; expected %v", content, cpus, expected)
+		}
 	}
+	if cpus := parseCPUQuota("-1", 100000); cpus != 0 {
+		t.Errorf("parseCPUQuota(-1) = %v; expected no limit", cpus)
+	}
+
+	memory := map[string]uint64{
+		"max\n":               0,
+		"1073741824\n":        1 << 30,
+		"9223372036854771712": 0, // cgroup v1 without a limit
+		"":                    0,
+	}
+	for content, expected := range memory {
+		if limit := parseCgroupMemory(content); limit != expected {
+			t.Errorf("parseCgroupMemory(%q) = %d; expected %d", content, limit, expected)
+		}
+	}
+
+	if limits := DetectResourceLimits(); limits.CPUs < 1 {
+		t.Errorf("DetectResourceLimits().CPUs = %d; expected at least 1", limits.CPUs)
+	}
+}
+
+func TestVolumeName(t *testing.T) {
+	testCases := map[string]string{
+		"/Volumes/Photos/2023/a.jpg":    "Photos",
+		"/media/alice/Backup/a.jpg":     "Backup",
+		"/run/media/alice/Backup/a.jpg": "Backup",
+		"/mnt/nas/a.jpg":                "nas",
+		"/home/alice/Pictures/a.jpg":    "/",
+	}
+	for path, expected := range testCases {
+		if name := VolumeName(path); name != expected {
+			t.Errorf("VolumeName(%q) = %q; expected %q", path, name, expected)
+		}
+	}
+}
 
-	// Check if the original file no longer exists
-	if _, err := os.Stat(tempFile.Name()); !os.IsNotExist(err) {
-		t.Fatalf("Original file still exists")
+func TestParseDateShift(t *testing.T) {
+	start := time.Date(2023, 1, 31, 12, 0, 0, 0, time.UTC)
+	testCases := map[string]string{
+		"+1y2d":          "2024-02-02T12:00:00Z",
+		"1mo":            "2023-03-03T12:00:00Z",
+		"-3h":            "2023-01-31T09:00:00Z",
+		"-1d2h30m":       "2023-01-30T09:30:00Z",
+		"+1y2mo3d4h5m6s": "2024-04-03T16:05:06Z",
+	}
+	for input, expected := range testCases {
+		shift, err := ParseDateShift(input)
+		if err != nil {
+			t.Errorf("ParseDateShift(%q) failed: %v", input, err)
+			continue
+		}
+		if got := shift.Apply(start).Format(time.RFC3339); got != expected {
+			t.Errorf("ParseDateShift(%q).Apply = %s; expected %s", input, got, expected)
+		}
+	}
+	for _, input := range []string{"", "+", "1x", "1d1d", "d", "1y-2d"} {
+		if _, err := ParseDateShift(input); err == nil {
+			t.Errorf("ParseDateShift(%q) should fail", input)
+		}
+	}
+}
+
+func TestInFolder(t *testing.T) {
+	if !InFolder("/photos/2023/a.jpg", "/photos") || !InFolder("/photos/2023/a.jpg", "/photos/2023/") {
+		t.Error("InFolder should match files below the folder")
+	}
+	if InFolder("/photos-old/a.jpg", "/photos") {
+		t.Error("InFolder should not match a folder sharing a prefix")
+	}
+}
+
+func TestFilesEqual(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		return path
+	}
+	content := []byte(strings.Repeat("picpurge", 20000))
+	changed := append([]byte{}, content...)
+	changed[len(changed)-1] = 'X'
+
+	original := write("a.jpg", content)
+	if equal, err := FilesEqual(original, write("b.jpg", content)); err != nil || !equal {
+		t.Errorf("FilesEqual on identical files = %v, %v; expected true", equal, err)
+	}
+	if equal, err := FilesEqual(original, write("c.jpg", changed)); err != nil || equal {
+		t.Errorf("FilesEqual on files differing in the last byte = %v, %v; expected false", equal, err)
+	}
+	if equal, err := FilesEqual(original, write("d.jpg", content[:100])); err != nil || equal {
+		t.Errorf("FilesEqual on files of different sizes = %v, %v; expected false", equal, err)
+	}
+	if _, err := FilesEqual(original, filepath.Join(dir, "missing.jpg")); err == nil {
+		t.Error("FilesEqual with a missing file should fail")
+	}
+}
+
+func TestDecodeText(t *testing.T) {
+	testCases := []struct {
+		text string
+		enc  encoding.Encoding
+	}{
+		{"Canon EOS 5D", nil},
+		{"华为 Mate 40", simplifiedchinese.GBK},
+		{"小米手机", simplifiedchinese.GBK},
+		{"キヤノン デジタルカメラ", japanese.ShiftJIS},
+		{"富士フイルム", japanese.ShiftJIS},
+		{"Appareil photo numérique", charmap.Windows1252},
+	}
+	for _, tc := range testCases {
+		raw := []byte(tc.text)
+		if tc.enc != nil {
+			var err error
+			if raw, err = tc.enc.NewEncoder().Bytes(raw); err != nil {
+				t.Fatalf("Failed to encode %q: %v", tc.text, err)
+			}
+		}
+		if got := DecodeText(raw); got != tc.text {
+			t.Errorf("DecodeText(%q) = %q; expected %q", raw, got, tc.text)
+		}
 	}
 }
@@ -0,0 +1,144 @@
+package walker
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// sniffLen is the number of leading bytes SniffFormat looks at.
+const sniffLen = 16
+
+// detectContentType makes IsImageFile accept files whose content is a
+// supported format whatever their extension. Off by default, since it opens
+// every file with an unknown extension.
+var detectContentType bool
+
+// SetDetectContentType turns content sniffing in IsImageFile on or off.
+func SetDetectContentType(enabled bool) {
+	detectContentType = enabled
+}
+
+// formatExtensions maps the formats SniffFormat reports to the extension used
+// for them. Formats without a supported extension, such as HEIC, are detected
+// only to be recorded.
+var formatExtensions = map[string]string{
+	"jpeg":      ".jpg",
+	"png":       ".png",
+	"gif":       ".gif",
+	"bmp":       ".bmp",
+	"webp":      ".webp",
+	"tiff":      ".tif",
+	"avif":      ".avif",
+	"jxl":       ".jxl",
+	"mp4":       ".mp4",
+	"quicktime": ".mov",
+	"avi":       ".avi",
+	"cr2":       ".cr2",
+	"orf":       ".orf",
+	"rw2":       ".rw2",
+	"raf":       ".raf",
+	"x3f":       ".x3f",
+	"mrw":       ".mrw",
+}
+
+// heifBrands are the ftyp brands of HEIF images, as written by phones.
+var heifBrands = map[string]bool{
+	"heic": true, "heix": true, "hevc": true, "hevx": true,
+	"heim": true, "heis": true, "mif1": true, "msf1": true,
+}
+
+// SniffFormat names the format of a file from its first bytes, or returns ""
+// when it is not recognized. TIFF-based RAW formats such as NEF, ARW and DNG
+// are reported as tiff.
+func SniffFormat(header []byte) string {
+	has := func(offset int, magic string) bool {
+		return len(header) >= offset+len(magic) && string(header[offset:offset+len(magic)]) == magic
+	}
+	switch {
+	case has(0, "\xff\xd8\xff"):
+		return "jpeg"
+	case has(0, "\x89PNG\r\n\x1a\n"):
+		return "png"
+	case has(0, "GIF87a"), has(0, "GIF89a"):
+		return "gif"
+	case has(0, "RIFF") && has(8, "WEBP"):
+		return "webp"
+	case has(0, "RIFF") && has(8, "AVI "):
+		return "avi"
+	case has(4, "ftyp") && len(header) >= 12:
+		brand := string(header[8:12])
+		switch {
+		case brand == "avif", brand == "avis":
+			return "avif"
+		case heifBrands[brand]:
+			return "heic"
+		case brand == "crx ":
+			return "cr3"
+		case brand == "qt  ":
+			return "quicktime"
+		}
+		return "mp4"
+	case has(0, "\xff\x0a"), has(0, "\x00\x00\x00\x0cJXL \x0d\x0a\x87\x0a"):
+		return "jxl"
+	case has(0, "II*\x00") && has(8, "CR"):
+		return "cr2"
+	case has(0, "II*\x00"), has(0, "MM\x00*"):
+		return "tiff"
+	case has(0, "IIRO"), has(0, "IIRS"), has(0, "MMOR"):
+		return "orf"
+	case has(0, "IIU\x00"):
+		return "rw2"
+	case has(0, "FUJIFILMCCD-RAW"):
+		return "raf"
+	case has(0, "FOVb"):
+		return "x3f"
+	case has(0, "\x00MRM"):
+		return "mrw"
+	case has(0, "BM") && len(header) >= 14 && bytes.Equal(header[6:10], []byte{0, 0, 0, 0}):
+		// "BM" alone is too common; the reserved bytes of a BMP header are zero
+		return "bmp"
+	}
+	return ""
+}
+
+// DetectFormat reads the first bytes of a file and names its format, or
+// returns "" when it is not recognized.
+func DetectFormat(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	header := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return SniffFormat(header[:n]), nil
+}
+
+// IsVideo checks if a file is a video, going by its detected format when
+// known and by its extension otherwise.
+func IsVideo(filePath, format string) bool {
+	if format == "" {
+		return IsVideoFile(filePath)
+	}
+	return videoExtensions[formatExtensions[format]]
+}
+
+// IsRaw checks if a file is a camera RAW file, going by its detected format
+// when known and by its extension otherwise. TIFF content is only told apart
+// from TIFF-based RAW formats by the extension.
+func IsRaw(filePath, format string) bool {
+	if format == "" || format == "tiff" {
+		return IsRawFile(filePath)
+	}
+	return rawExtensions[formatExtensions[format]]
+}
+
+// isImageContent checks if a file's content is in a supported format.
+func isImageContent(filePath string) bool {
+	format, err := DetectFormat(filePath)
+	return err == nil && imageExtensions[formatExtensions[format]]
+}
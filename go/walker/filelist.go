@@ -0,0 +1,47 @@
+package walker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ReadFileList reads paths from a list such as the output of find or fd. Paths are
+// separated by NUL bytes if the list contains any (find -print0), otherwise by
+// newlines. Empty entries are skipped.
+func ReadFileList(r io.Reader) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file list: %w", err)
+	}
+
+	separator := "\n"
+	if bytes.IndexByte(data, 0) >= 0 {
+		separator = "\x00"
+	}
+	var paths []string
+	for _, entry := range strings.Split(string(data), separator) {
+		if separator == "\n" {
+			entry = strings.TrimSuffix(entry, "\r")
+		}
+		if entry != "" {
+			paths = append(paths, entry)
+		}
+	}
+	return paths, nil
+}
+
+// OpenFileList reads a file list from the named file, or from stdin when name is "-".
+func OpenFileList(name string) ([]string, error) {
+	if name == "-" {
+		return ReadFileList(os.Stdin)
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file list: %w", err)
+	}
+	defer file.Close()
+	return ReadFileList(file)
+}
@@ -0,0 +1,100 @@
+package walker
+
+import (
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"os"
+)
+
+// MinimumSize excludes files too small to be photos, such as icons, favicons
+// and UI sprites. Zero fields set no minimum.
+type MinimumSize struct {
+	Bytes  uint64
+	Width  int
+	Height int
+}
+
+var minimumSize MinimumSize
+
+// SetMinimumSize changes the files FilterSmall and TooSmall exclude.
+func SetMinimumSize(m MinimumSize) {
+	minimumSize = m
+}
+
+// configDecoders read the dimensions of the formats small images come in from
+// their headers. Other formats, such as RAW files and videos, are never too
+// small by resolution.
+var configDecoders = map[string]func(io.Reader) (image.Config, error){
+	"jpeg": jpeg.DecodeConfig,
+	"png":  png.DecodeConfig,
+	"gif":  gif.DecodeConfig,
+}
+
+// TooSmall reports whether a file is below the minimum set with
+// SetMinimumSize, and why. Files that cannot be read are left for the scan to
+// report.
+func TooSmall(filePath string) (bool, string) {
+	m := minimumSize
+	if m.Bytes == 0 && m.Width == 0 && m.Height == 0 {
+		return false, ""
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return false, ""
+	}
+	if uint64(info.Size()) < m.Bytes {
+		return true, "file size"
+	}
+	if m.Width == 0 && m.Height == 0 {
+		return false, ""
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false, ""
+	}
+	defer file.Close()
+	header := make([]byte, sniffLen)
+	n, _ := io.ReadFull(file, header)
+	decodeConfig := configDecoders[SniffFormat(header[:n])]
+	if decodeConfig == nil {
+		return false, ""
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return false, ""
+	}
+	config, err := decodeConfig(file)
+	if err != nil {
+		return false, ""
+	}
+	if config.Width < m.Width || config.Height < m.Height {
+		return true, "resolution"
+	}
+	return false, ""
+}
+
+// FilterSmall drops the files below the minimum set with SetMinimumSize and
+// logs how many were skipped. The order is kept.
+func FilterSmall(files []string) []string {
+	m := minimumSize
+	if m.Bytes == 0 && m.Width == 0 && m.Height == 0 {
+		return files
+	}
+	var kept []string
+	skipped := make(map[string]int)
+	for _, file := range files {
+		if small, reason := TooSmall(file); small {
+			skipped[reason]++
+			continue
+		}
+		kept = append(kept, file)
+	}
+	if dropped := len(files) - len(kept); dropped > 0 {
+		log.Printf("Skipped %d files below the minimum size (%d by file size, %d by resolution).\n", dropped, skipped["file size"], skipped["resolution"])
+	}
+	return kept
+}
@@ -0,0 +1,10 @@
+//go:build !purego
+
+package walker
+
+import "github.com/chai2010/webp"
+
+func init() {
+	// The WebP codec needs cgo; without it WebP files are never too small by resolution
+	configDecoders["webp"] = webp.DecodeConfig
+}
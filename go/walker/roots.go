@@ -0,0 +1,57 @@
+package walker
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"picpurge/util"
+)
+
+// CanonicalPath returns the absolute path of a file or folder with symlinks
+// resolved, or just the absolute path when it cannot be resolved.
+func CanonicalPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("invalid path %s: %w", path, err)
+	}
+	if target, err := filepath.EvalSymlinks(abs); err == nil {
+		return target, nil
+	}
+	return abs, nil
+}
+
+// CollapseRoots returns the given scan roots without repeats and without roots
+// that lie inside another root, so no file is walked and counted twice. Roots
+// are compared as absolute paths, and by their target when reached through a
+// symlink, but returned as given so catalog paths stay the same. The order of
+// the remaining roots is kept.
+func CollapseRoots(roots []string) ([]string, error) {
+	type root struct{ path, resolved string }
+	var candidates []root
+	for _, path := range roots {
+		resolved, err := CanonicalPath(path)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, root{filepath.Clean(path), resolved})
+	}
+
+	var collapsed []string
+	for i, candidate := range candidates {
+		covered := false
+		for j, other := range candidates {
+			if i == j {
+				continue
+			}
+			// Of two equal roots the first is kept
+			if other.resolved == candidate.resolved && j < i || util.InFolder(candidate.resolved, other.resolved) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			collapsed = append(collapsed, candidate.path)
+		}
+	}
+	return collapsed, nil
+}
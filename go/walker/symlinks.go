@@ -0,0 +1,129 @@
+package walker
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// SymlinkOptions controls how symbolic links are treated while walking.
+type SymlinkOptions struct {
+	Follow bool // descend into symlinked folders; by default only symlinked files are listed
+	Dedupe bool // list a file reached through several symlinks once, by its real path when possible
+}
+
+var symlinkOptions SymlinkOptions
+
+// SetSymlinkOptions changes how WalkDir, FindImageFiles and Dedupe treat symlinks.
+func SetSymlinkOptions(o SymlinkOptions) {
+	symlinkOptions = o
+}
+
+// WalkDir walks the tree below root like filepath.WalkDir. With
+// SymlinkOptions.Follow it also walks symlinked folders, under the path of the
+// link, except for links back into a folder that is being walked, which would
+// never end.
+func WalkDir(root string, fn fs.WalkDirFunc) error {
+	if !symlinkOptions.Follow {
+		return filepath.WalkDir(root, fn)
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	canonical, err := CanonicalPath(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	err = walkFollowing(root, canonical, fs.FileInfoToDirEntry(info), map[string]bool{}, fn)
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// walkFollowing visits path and, when it is a folder, everything below it.
+// canonical is the real path of the folder; ancestors holds the real paths of
+// the folders above it.
+func walkFollowing(path, canonical string, d fs.DirEntry, ancestors map[string]bool, fn fs.WalkDirFunc) error {
+	if err := fn(path, d, nil); err != nil || !d.IsDir() {
+		return err
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		if err := fn(path, d, err); err != nil && err != filepath.SkipDir {
+			return err
+		}
+		return nil
+	}
+
+	ancestors[canonical] = true
+	defer delete(ancestors, canonical)
+	for _, entry := range entries {
+		child := filepath.Join(path, entry.Name())
+		childCanonical := filepath.Join(canonical, entry.Name())
+		if entry.Type()&fs.ModeSymlink != 0 {
+			info, err := os.Stat(child)
+			if err != nil {
+				log.Printf("Warning: Skipping broken symlink %s: %v\n", child, err)
+				continue
+			}
+			if childCanonical, err = filepath.EvalSymlinks(child); err != nil {
+				log.Printf("Warning: Skipping broken symlink %s: %v\n", child, err)
+				continue
+			}
+			if info.IsDir() && ancestors[childCanonical] {
+				log.Printf("Warning: Skipping symlink %s, which loops back to %s\n", child, childCanonical)
+				continue
+			}
+			entry = fs.FileInfoToDirEntry(info)
+		}
+		err := walkFollowing(child, childCanonical, entry, ancestors, fn)
+		if err == filepath.SkipDir {
+			if !entry.IsDir() {
+				return nil // SkipDir on a file skips the rest of its folder
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Dedupe drops files that are the same file on disk as another one in the
+// list when SymlinkOptions.Dedupe is set, so a symlink and its target are not
+// catalogued as duplicates of each other. Of each set the path without
+// symlinks is kept, or else the first one. The order is otherwise kept.
+func Dedupe(files []string) []string {
+	if !symlinkOptions.Dedupe {
+		return files
+	}
+	var deduped []string
+	index := make(map[string]int) // position in deduped by real path
+	for _, file := range files {
+		resolved, err := filepath.EvalSymlinks(file)
+		if err != nil {
+			deduped = append(deduped, file)
+			continue
+		}
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			abs = file
+		}
+		if i, ok := index[resolved]; ok {
+			if abs == resolved {
+				deduped[i] = file
+			}
+			continue
+		}
+		index[resolved] = len(deduped)
+		deduped = append(deduped, file)
+	}
+	if dropped := len(files) - len(deduped); dropped > 0 {
+		log.Printf("Skipped %d files that are symlinks to other files found.\n", dropped)
+	}
+	return deduped
+}
@@ -1,8 +1,9 @@
 package walker
 
 import (
+	"context"
 	"fmt" // Import fmt for error formatting
-	"os"
+	"io/fs"
 	"path/filepath"
 	"strings"
 )
@@ -17,6 +18,8 @@ var imageExtensions = map[string]bool{
 	".tiff": true,
 	".tif":  true,
 	".webp": true,
+	".avif": true,
+	".jxl":  true, // JPEG XL
 	".cr2":  true,
 	".nef":  true, // Nikon RAW
 	".arw":  true, // Sony RAW
@@ -33,34 +36,94 @@ var imageExtensions = map[string]bool{
 	".mef":  true, // Mamiya RAW
 	".mrw":  true, // Minolta RAW
 	".x3f":  true, // Sigma RAW
+	// Videos are catalogued alongside images; see videoExtensions
+	".mp4": true,
+	".m4v": true,
+	".mov": true, // QuickTime, e.g. iPhone videos and Live Photo clips
+	".avi": true,
+}
+
+// rawExtensions are the imageExtensions of camera RAW files, which are read
+// through their embedded previews instead of being decoded.
+var rawExtensions = map[string]bool{
+	".cr2": true, ".nef": true, ".arw": true, ".dng": true, ".orf": true,
+	".rw2": true, ".pef": true, ".sr2": true, ".raf": true, ".3fr": true,
+	".fff": true, ".mos": true, ".iiq": true, ".mef": true, ".mrw": true,
+	".x3f": true,
+}
+
+// videoExtensions are the imageExtensions of video containers, which are
+// thumbnailed with ffmpeg instead of being decoded.
+var videoExtensions = map[string]bool{
+	".mp4": true,
+	".m4v": true,
+	".mov": true,
+	".avi": true,
 }
 
 // IsImageFile checks if a given file path has a supported image extension.
+// Supported videos count as images. With SetDetectContentType, files with
+// other extensions count when their content is in a supported format.
 func IsImageFile(filePath string) bool {
 	ext := strings.ToLower(filepath.Ext(filePath))
-	return imageExtensions[ext]
+	if imageExtensions[ext] {
+		return true
+	}
+	return detectContentType && isImageContent(filePath)
 }
 
-// FindImageFiles recursively finds image files in the given path.
-func FindImageFiles(rootPath string) ([]string, error) {
-	var imageFiles []string
+// IsRawFile checks if a given file path has a supported camera RAW extension.
+func IsRawFile(filePath string) bool {
+	ext := strings.ToLower(filepath.Ext(filePath))
+	return rawExtensions[ext]
+}
 
-	err := filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
+// IsVideoFile checks if a given file path has a supported video extension.
+func IsVideoFile(filePath string) bool {
+	ext := strings.ToLower(filepath.Ext(filePath))
+	return videoExtensions[ext]
+}
+
+// FindImageFiles recursively finds image files in the given path, honouring
+// SetSymlinkOptions. It stops with ctx's error once ctx is cancelled.
+func FindImageFiles(ctx context.Context, rootPath string) ([]string, error) {
+	imageFiles, _, err := findFiles(ctx, rootPath, false)
+	return imageFiles, err
+}
+
+// FindImageAndPDFFiles finds the image files in the given path like
+// FindImageFiles, and in the same walk the PDF files.
+func FindImageAndPDFFiles(ctx context.Context, rootPath string) (imageFiles, pdfFiles []string, err error) {
+	return findFiles(ctx, rootPath, true)
+}
+
+func findFiles(ctx context.Context, rootPath string, withPDFs bool) (imageFiles, pdfFiles []string, err error) {
+	err = WalkDir(rootPath, func(path string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 		if err != nil {
 			return fmt.Errorf("error accessing path %s: %w", path, err)
 		}
-		if info.IsDir() {
-			return nil // Skip directories, filepath.Walk will recurse
+		if d.IsDir() {
+			return nil // Skip directories, WalkDir will recurse
 		}
 
-		if IsImageFile(path) { // Use the exported function
+		if withPDFs && IsPDFFile(path) {
+			pdfFiles = append(pdfFiles, path)
+		} else if IsImageFile(path) { // Use the exported function
 			imageFiles = append(imageFiles, path)
 		}
 		return nil
 	})
 
 	if err != nil {
-		return nil, fmt.Errorf("error walking path %s: %w", rootPath, err)
+		return nil, nil, fmt.Errorf("error walking path %s: %w", rootPath, err)
 	}
-	return imageFiles, nil
+	return Dedupe(imageFiles), Dedupe(pdfFiles), nil
+}
+
+// IsPDFFile checks if a given file path has a PDF extension.
+func IsPDFFile(filePath string) bool {
+	return strings.ToLower(filepath.Ext(filePath)) == ".pdf"
 }
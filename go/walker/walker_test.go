@@ -1,8 +1,15 @@
 package walker
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -19,6 +26,8 @@ func TestIsImageFile(t *testing.T) {
 		{"test.tiff", true},
 		{"test.tif", true},
 		{"test.webp", true},
+		{"test.avif", true},
+		{"test.jxl", true},
 		{"test.cr2", true},
 		{"test.nef", true},
 		{"test.arw", true},
@@ -35,6 +44,9 @@ func TestIsImageFile(t *testing.T) {
 		{"test.mef", true},
 		{"test.mrw", true},
 		{"test.x3f", true},
+		{"test.mp4", true},
+		{"test.MOV", true},
+		{"test.avi", true},
 		{"test.txt", false},
 		{"test.pdf", false},
 		{"test.doc", false},
@@ -49,6 +61,22 @@ func TestIsImageFile(t *testing.T) {
 	}
 }
 
+func TestIsRawFile(t *testing.T) {
+	for filePath, expected := range map[string]bool{"IMG_0001.CR2": true, "DSC_0001.nef": true, "photo.dng": true, "photo.jpg": false, "clip.mov": false} {
+		if result := IsRawFile(filePath); result != expected {
+			t.Errorf("IsRawFile(%s) = %v; expected %v", filePath, result, expected)
+		}
+	}
+}
+
+func TestIsVideoFile(t *testing.T) {
+	for filePath, expected := range map[string]bool{"clip.mp4": true, "IMG_0001.MOV": true, "old.avi": true, "photo.jpg": false, "raw.cr2": false} {
+		if result := IsVideoFile(filePath); result != expected {
+			t.Errorf("IsVideoFile(%s) = %v; expected %v", filePath, result, expected)
+		}
+	}
+}
+
 func TestFindImageFiles(t *testing.T) {
 	// Create a temporary directory structure for testing
 	tempDir := t.TempDir()
@@ -87,7 +115,7 @@ func TestFindImageFiles(t *testing.T) {
 	}
 
 	// Test FindImageFiles function
-	foundFiles, err := FindImageFiles(tempDir)
+	foundFiles, err := FindImageFiles(context.Background(), tempDir)
 	if err != nil {
 		t.Fatalf("FindImageFiles failed: %v", err)
 	}
@@ -113,3 +141,233 @@ func TestFindImageFiles(t *testing.T) {
 		}
 	}
 }
+
+func TestFindImageAndPDFFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.jpg", "album.pdf", "notes.txt", "sub/SCAN.PDF", "sub/b.png"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create folder: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("test"), 0644); err != nil {
+			t.Fatalf("Failed to create test file %s: %v", path, err)
+		}
+	}
+	images, pdfs, err := FindImageAndPDFFiles(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("FindImageAndPDFFiles failed: %v", err)
+	}
+	expectedImages := []string{filepath.Join(dir, "a.jpg"), filepath.Join(dir, "sub/b.png")}
+	expectedPDFs := []string{filepath.Join(dir, "album.pdf"), filepath.Join(dir, "sub/SCAN.PDF")}
+	if !reflect.DeepEqual(images, expectedImages) || !reflect.DeepEqual(pdfs, expectedPDFs) {
+		t.Errorf("FindImageAndPDFFiles = %v, %v; expected %v, %v", images, pdfs, expectedImages, expectedPDFs)
+	}
+}
+
+func TestReadFileList(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected []string
+	}{
+		{"a.jpg\nb c.png\n\n", []string{"a.jpg", "b c.png"}},
+		{"a.jpg\r\nb.png", []string{"a.jpg", "b.png"}},
+		{"a\nb.jpg\x00c.png\x00", []string{"a\nb.jpg", "c.png"}},
+		{"", nil},
+	}
+
+	for _, tc := range testCases {
+		paths, err := ReadFileList(strings.NewReader(tc.input))
+		if err != nil {
+			t.Fatalf("ReadFileList(%q) failed: %v", tc.input, err)
+		}
+		if len(paths) != len(tc.expected) {
+			t.Errorf("ReadFileList(%q) = %q; expected %q", tc.input, paths, tc.expected)
+			continue
+		}
+		for i := range paths {
+			if paths[i] != tc.expected[i] {
+				t.Errorf("ReadFileList(%q) = %q; expected %q", tc.input, paths, tc.expected)
+				break
+			}
+		}
+	}
+}
+
+func TestCollapseRoots(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"photos/2020", "backup"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatalf("Failed to create folder: %v", err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "photos"), filepath.Join(dir, "link")); err != nil {
+		t.Skipf("Symlinks not supported: %v", err)
+	}
+
+	roots, err := CollapseRoots([]string{
+		filepath.Join(dir, "photos", "2020"),
+		filepath.Join(dir, "backup"),
+		filepath.Join(dir, "photos"),
+		filepath.Join(dir, "backup") + string(filepath.Separator),
+		filepath.Join(dir, "link"),
+	})
+	if err != nil {
+		t.Fatalf("CollapseRoots failed: %v", err)
+	}
+	expected := []string{filepath.Join(dir, "backup"), filepath.Join(dir, "photos")}
+	if strings.Join(roots, "|") != strings.Join(expected, "|") {
+		t.Errorf("CollapseRoots = %v; expected %v", roots, expected)
+	}
+}
+
+func TestSniffFormat(t *testing.T) {
+	testCases := []struct {
+		header   string
+		expected string
+	}{
+		{"\xff\xd8\xff\xe0\x00\x10JFIF\x00", "jpeg"},
+		{"\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR", "png"},
+		{"GIF89a\x01\x00\x01\x00", "gif"},
+		{"BM\x36\x00\x0c\x00\x00\x00\x00\x00\x36\x00\x00\x00", "bmp"},
+		{"RIFF\x24\x00\x00\x00WEBPVP8 ", "webp"},
+		{"RIFF\x24\x00\x00\x00AVI LIST", "avi"},
+		{"\x00\x00\x00\x1cftypavif\x00\x00\x00\x00", "avif"},
+		{"\x00\x00\x00\x18ftypheic\x00\x00\x00\x00", "heic"},
+		{"\x00\x00\x00\x14ftypqt  \x00\x00\x02\x00", "quicktime"},
+		{"\x00\x00\x00\x20ftypisom\x00\x00\x02\x00", "mp4"},
+		{"\xff\x0a\xfa\x7f", "jxl"},
+		{"II*\x00\x10\x00\x00\x00CR\x02\x00", "cr2"},
+		{"MM\x00*\x00\x00\x00\x08", "tiff"},
+		{"IIRO\x08\x00\x00\x00", "orf"},
+		{"FUJIFILMCCD-RAW 0201", "raf"},
+		{"BMW", ""},
+		{"hello, world", ""},
+		{"", ""},
+	}
+	for _, tc := range testCases {
+		if result := SniffFormat([]byte(tc.header)); result != tc.expected {
+			t.Errorf("SniffFormat(%q) = %q; expected %q", tc.header, result, tc.expected)
+		}
+	}
+}
+
+func TestDetectContentType(t *testing.T) {
+	defer SetDetectContentType(false)
+	dir := t.TempDir()
+	misnamed := filepath.Join(dir, "IMG_0001.dat")
+	heic := filepath.Join(dir, "IMG_0002.bin")
+	text := filepath.Join(dir, "notes.dat")
+	os.WriteFile(misnamed, []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"), 0644)
+	os.WriteFile(heic, []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00"), 0644)
+	os.WriteFile(text, []byte("hello, world"), 0644)
+
+	if IsImageFile(misnamed) {
+		t.Errorf("IsImageFile(%s) = true without content detection", misnamed)
+	}
+	SetDetectContentType(true)
+	for filePath, expected := range map[string]bool{misnamed: true, heic: false, text: false} {
+		if result := IsImageFile(filePath); result != expected {
+			t.Errorf("IsImageFile(%s) = %v with content detection; expected %v", filePath, result, expected)
+		}
+	}
+	files, err := FindImageFiles(context.Background(), dir)
+	if err != nil || len(files) != 1 || files[0] != misnamed {
+		t.Errorf("FindImageFiles = %v, %v; expected only %s", files, err, misnamed)
+	}
+
+	// A RAW file's content wins over a misleading extension
+	if !IsRaw("IMG_0003.jpg", "cr2") || IsRaw("photo.nef", "jpeg") || !IsRaw("photo.nef", "tiff") || IsRaw("scan.tif", "tiff") {
+		t.Errorf("IsRaw does not prefer the detected format")
+	}
+	if !IsVideo("clip.dat", "mp4") || IsVideo("photo.mov", "jpeg") || !IsVideo("clip.mov", "") {
+		t.Errorf("IsVideo does not prefer the detected format")
+	}
+}
+
+func TestFindImageFilesCancelled(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.jpg"), []byte("jpg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	files, err := FindImageFiles(ctx, dir)
+	if !errors.Is(err, context.Canceled) || files != nil {
+		t.Errorf("FindImageFiles with a cancelled context = %v, %v; expected context.Canceled", files, err)
+	}
+}
+
+func TestFindImageFilesSymlinks(t *testing.T) {
+	defer SetSymlinkOptions(SymlinkOptions{})
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "a"), 0755)
+	os.WriteFile(filepath.Join(root, "a", "img.jpg"), []byte("jpeg"), 0644)
+	for link, target := range map[string]string{
+		"link":     filepath.Join(root, "a"),            // an alias of a folder
+		"a/loop":   root,                                // back up the tree
+		"b.jpg":    filepath.Join(root, "a", "img.jpg"), // an alias of a file
+		"gone.jpg": filepath.Join(root, "missing.jpg"),  // broken
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Skipf("Symlinks are not supported: %v", err)
+		}
+	}
+
+	testCases := []struct {
+		options  SymlinkOptions
+		expected []string
+	}{
+		{SymlinkOptions{}, []string{"a/img.jpg", "b.jpg", "gone.jpg"}},
+		{SymlinkOptions{Follow: true}, []string{"a/img.jpg", "b.jpg", "link/img.jpg"}},
+		{SymlinkOptions{Follow: true, Dedupe: true}, []string{"a/img.jpg"}},
+	}
+	for _, tc := range testCases {
+		SetSymlinkOptions(tc.options)
+		files, err := FindImageFiles(context.Background(), root)
+		if err != nil {
+			t.Fatalf("FindImageFiles with %+v failed: %v", tc.options, err)
+		}
+		var relative []string
+		for _, file := range files {
+			rel, _ := filepath.Rel(root, file)
+			relative = append(relative, filepath.ToSlash(rel))
+		}
+		if strings.Join(relative, ",") != strings.Join(tc.expected, ",") {
+			t.Errorf("FindImageFiles with %+v = %v; expected %v", tc.options, relative, tc.expected)
+		}
+	}
+}
+
+func TestFilterSmall(t *testing.T) {
+	defer SetMinimumSize(MinimumSize{})
+	dir := t.TempDir()
+	writePNG := func(name string, width, height int) string {
+		var buf bytes.Buffer
+		png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)))
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, buf.Bytes(), 0644)
+		return path
+	}
+	icon := writePNG("favicon.png", 16, 16)
+	banner := writePNG("banner.png", 1200, 40)
+	photo := writePNG("photo.png", 400, 300)
+	raw := filepath.Join(dir, "IMG_0001.cr2")
+	os.WriteFile(raw, bytes.Repeat([]byte{0}, 1000), 0644)
+	files := []string{icon, banner, photo, raw}
+
+	if result := FilterSmall(files); len(result) != len(files) {
+		t.Errorf("FilterSmall = %v without a minimum; expected all files", result)
+	}
+	// Dimensions of RAW files are not read, so only their size counts
+	SetMinimumSize(MinimumSize{Width: 200, Height: 200})
+	if result := FilterSmall(files); !reflect.DeepEqual(result, []string{photo, raw}) {
+		t.Errorf("FilterSmall = %v with a minimum resolution; expected %v", result, []string{photo, raw})
+	}
+	SetMinimumSize(MinimumSize{Bytes: 500})
+	if small, reason := TooSmall(icon); !small || reason != "file size" {
+		t.Errorf("TooSmall(%s) = %v, %q; expected too small by file size", icon, small, reason)
+	}
+	if small, _ := TooSmall(raw); small {
+		t.Errorf("TooSmall(%s) = true; expected a 1000 byte file to pass", raw)
+	}
+}
@@ -1 +1,94 @@
 package worker
+
+import (
+	"context"
+	"sync"
+)
+
+// Gate lets a pool of workers be paused and resumed, or throttled to fewer
+// workers. Workers call Wait before picking up new work; work already in
+// flight is allowed to finish.
+type Gate struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	paused bool
+	limit  int // workers let through while throttled, 0 for all
+}
+
+// NewGate creates an open gate.
+func NewGate() *Gate {
+	g := &Gate{}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// Pause closes the gate; subsequent Wait calls block until Resume.
+func (g *Gate) Pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.paused = true
+}
+
+// Resume opens the gate and wakes up all waiting workers.
+func (g *Gate) Resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.paused = false
+	g.cond.Broadcast()
+}
+
+// Paused reports whether the gate is currently closed.
+func (g *Gate) Paused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused
+}
+
+// Wait blocks while the gate is paused.
+func (g *Gate) Wait() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for g.paused {
+		g.cond.Wait()
+	}
+}
+
+// Throttle lets only the first n workers of each pool through the gate, e.g.
+// to leave the machine to someone using it; 0 lets all of them through again.
+func (g *Gate) Throttle(n int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.limit = n
+	g.cond.Broadcast()
+}
+
+// Throttled returns the number of workers the gate lets through, 0 if it is
+// not throttled.
+func (g *Gate) Throttled() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.limit
+}
+
+// WaitContext blocks while the gate is paused, like Wait, but gives up once ctx
+// is cancelled and returns its error, so a paused pool can still be stopped.
+func (g *Gate) WaitContext(ctx context.Context) error {
+	return g.WaitWorker(ctx, 0)
+}
+
+// WaitWorker is WaitContext for the worker with the given index in its pool,
+// which also blocks while the gate is throttled to fewer workers.
+func (g *Gate) WaitWorker(ctx context.Context, worker int) error {
+	stop := context.AfterFunc(ctx, func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		g.cond.Broadcast()
+	})
+	defer stop()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for (g.paused || (g.limit > 0 && worker >= g.limit)) && ctx.Err() == nil {
+		g.cond.Wait()
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,89 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGatePauseResume(t *testing.T) {
+	gate := NewGate()
+	gate.Pause()
+	if !gate.Paused() {
+		t.Fatal("Gate should be paused after Pause")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		gate.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Wait returned while gate was paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	gate.Resume()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Wait did not return after Resume")
+	}
+}
+
+func TestGateWaitContext(t *testing.T) {
+	gate := NewGate()
+	if err := gate.WaitContext(context.Background()); err != nil {
+		t.Fatalf("WaitContext on an open gate = %v", err)
+	}
+
+	gate.Pause()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- gate.WaitContext(ctx)
+	}()
+	select {
+	case <-done:
+		t.Fatal("WaitContext returned while gate was paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("WaitContext = %v; expected context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("WaitContext did not return after cancel")
+	}
+}
+
+func TestGateThrottle(t *testing.T) {
+	gate := NewGate()
+	gate.Throttle(1)
+	if err := gate.WaitWorker(context.Background(), 0); err != nil {
+		t.Fatalf("WaitWorker of the first worker = %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- gate.WaitWorker(context.Background(), 1)
+	}()
+	select {
+	case <-done:
+		t.Fatal("WaitWorker of the second worker returned while throttled to one")
+	case <-time.After(50 * time.Millisecond):
+	}
+	gate.Throttle(0)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("WaitWorker = %v after the throttle was lifted", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("WaitWorker did not return after the throttle was lifted")
+	}
+}